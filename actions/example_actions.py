@@ -3,12 +3,23 @@ Example actions demonstrating Tinpot features.
 """
 import time
 import os
-from tinpot import action, action_print
+from tinpot import action, action_print, action_progress
 
 
-@action(group="Maintenance", description="Clean up temporary files older than specified days")
+@action(
+    group="Maintenance",
+    description="Clean up temporary files older than specified days",
+    examples=[{"title": "Weekly cleanup", "parameters": {"days": 7}},
+              {"title": "Purge everything but today's files", "parameters": {"days": 1}}],
+)
 def clean_cache(days: int = 7):
-    """Clean cache files older than N days."""
+    """
+    Clean cache files older than N days.
+
+    Scans the temporary cache directories and deletes every file whose
+    modification time is older than `days` days. Returns the number of
+    deleted files as `files_deleted`.
+    """
     action_print(f"Starting cache cleanup (files older than {days} days)...")
     
     # Simulate finding and deleting files
@@ -26,7 +37,14 @@ def clean_cache(days: int = 7):
     return {"files_deleted": len(cache_files)}
 
 
-@action(group="DevOps", description="Deploy application to specified environment")
+@action(
+    group="DevOps",
+    description="Deploy application to specified environment",
+    ui={
+        "environment": {"widget": "select", "choices": ["staging", "production"]},
+        "skip_tests": {"title": "Skip tests", "help": "Deploy without running the test suite"},
+    },
+)
 def deploy_app(environment: str = "staging", skip_tests: bool = False):
     """Deploy the application to an environment."""
     action_print(f"🚀 Starting deployment to {environment}...")
@@ -105,6 +123,7 @@ def health_check(duration: int = 30):
     
     for i in range(duration):
         action_print(f"[{i+1}/{duration}] Checking system health...")
+        action_progress(i + 1, duration, "Checking system health")
         time.sleep(1)
         
         if i % 5 == 4:
@@ -1,17 +1,34 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+	// The zone database of the schedule timezones, for images without one
+	_ "time/tzdata"
 
 	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/broker"
+	"github.com/balazsgrill/tinpot/federation"
+	"github.com/balazsgrill/tinpot/grafana"
+	"github.com/balazsgrill/tinpot/ldapauth"
+	"github.com/balazsgrill/tinpot/oidcauth"
+	"github.com/balazsgrill/tinpot/remote"
+	"github.com/balazsgrill/tinpot/replay"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/telegram"
 	"github.com/google/uuid"
 )
 
@@ -20,8 +37,142 @@ var staticContent embed.FS
 
 // Configuration
 var (
+	// Comma separated broker URLs in failover order, mqtt+srv://domain discovers them via DNS SRV
 	MQTTBroker = getEnv("MQTT_BROKER", "tcp://localhost:1883")
 	RootPath   = getEnv("ROOT_PATH", "")
+	// API_TOKENS binds API tokens to tenants: "token1:teamA,token2:teamB".
+	// A token without tenant ("token3:") belongs to the default tenant.
+	// When no tokens are configured, the API is open and every caller is the default tenant.
+	APITokens = getEnv("API_TOKENS", "")
+	// AUTH_ROLES maps the groups of OIDC/LDAP users to roles: "admins:admin,ops:operator,*:viewer"
+	AuthRoles = getEnv("AUTH_ROLES", "*:viewer")
+	// AUTH_TENANTS maps the groups of OIDC/LDAP users to tenants: "team-a-ops:teamA"
+	AuthTenants = getEnv("AUTH_TENANTS", "")
+	// OIDC_ISSUER enables OpenID Connect, see oidcauth.Config for the other OIDC_ settings
+	OIDCIssuer       = getEnv("OIDC_ISSUER", "")
+	OIDCClientID     = getEnv("OIDC_CLIENT_ID", "")
+	OIDCClientSecret = getEnv("OIDC_CLIENT_SECRET", "")
+	OIDCRedirectURL  = getEnv("OIDC_REDIRECT_URL", "")
+	OIDCAudience     = getEnv("OIDC_AUDIENCE", "")
+	OIDCGroupsClaim  = getEnv("OIDC_GROUPS_CLAIM", "")
+	// LDAP_URL enables LDAP with HTTP basic credentials, see ldapauth.Config for the other LDAP_ settings
+	LDAPURL          = getEnv("LDAP_URL", "")
+	LDAPStartTLS     = getEnv("LDAP_START_TLS", "false")
+	LDAPBindDN       = getEnv("LDAP_BIND_DN", "")
+	LDAPBindPassword = getEnv("LDAP_BIND_PASSWORD", "")
+	LDAPBaseDN       = getEnv("LDAP_BASE_DN", "")
+	LDAPUserFilter   = getEnv("LDAP_USER_FILTER", "")
+	LDAPGroupFilter  = getEnv("LDAP_GROUP_FILTER", "")
+	// QUOTAS_FILE points to a JSON document with the quota configuration, see server.QuotaConfig
+	QuotasFile = getEnv("QUOTAS_FILE", "")
+	// LOAD_SHEDDING rejects executions of a priority while that many are in flight, e.g.
+	// "low=50,normal=200", see server.SheddingPolicy. Rejected callers are told to retry
+	// after LOAD_SHEDDING_RETRY_AFTER.
+	LoadShedding           = getEnv("LOAD_SHEDDING", "")
+	LoadSheddingRetryAfter = getEnv("LOAD_SHEDDING_RETRY_AFTER", "30s")
+	// EXECUTION_VISIBILITY limits the executions the callers see: all (default), group (their
+	// groups' ones) or own. Callers with EXECUTION_VISIBILITY_ROLE see all of their tenant.
+	ExecutionVisibility     = getEnv("EXECUTION_VISIBILITY", "all")
+	ExecutionVisibilityRole = getEnv("EXECUTION_VISIBILITY_ROLE", "admin")
+	// ALERT_RULES_FILE points to a JSON document with alert rules on execution outcomes, see server.AlertRules
+	AlertRulesFile = getEnv("ALERT_RULES_FILE", "")
+	// USAGE_RATES price the executions in the usage reports, see server.ParseUsageRates
+	UsageRates = getEnv("USAGE_RATES", "")
+	// CANARY_TRAFFIC is the percentage of the executions of each action sent to its canary version, see server.ParseCanaryTraffic
+	CanaryTraffic = getEnv("CANARY_TRAFFIC", "")
+	// MAINTENANCE_FILE points to a JSON document with the maintenance windows of action groups, see server.MaintenanceConfig
+	MaintenanceFile = getEnv("MAINTENANCE_FILE", "")
+	// ALERTMANAGER_FILE points to a JSON document routing Alertmanager alerts to actions, see server.AlertmanagerConfig
+	AlertmanagerFile = getEnv("ALERTMANAGER_FILE", "")
+	// GRAFANA_URL enables annotating the finished executions in Grafana with a GRAFANA_TOKEN service account
+	GrafanaURL   = getEnv("GRAFANA_URL", "")
+	GrafanaToken = getEnv("GRAFANA_TOKEN", "")
+	// GRAFANA_TAGS are comma separated extra tags of the annotations, GRAFANA_DASHBOARD_UID limits them to a dashboard
+	GrafanaTags         = getEnv("GRAFANA_TAGS", "")
+	GrafanaDashboardUID = getEnv("GRAFANA_DASHBOARD_UID", "")
+	// SLACK_SIGNING_SECRET enables the Slack slash command, running the actions of SLACK_TENANT
+	// for the comma separated SLACK_USERS (everyone when empty)
+	SlackSigningSecret = getEnv("SLACK_SIGNING_SECRET", "")
+	SlackTenant        = getEnv("SLACK_TENANT", "")
+	SlackUsers         = getEnv("SLACK_USERS", "")
+	// GIT_WEBHOOK_SECRET enables the push webhook of the actions repository updating the workers
+	GitWebhookSecret = getEnv("GIT_WEBHOOK_SECRET", "")
+	// TELEGRAM_TOKEN enables the Telegram bot running the actions of TELEGRAM_TENANT in the
+	// comma separated TELEGRAM_CHATS (every chat when empty)
+	TelegramToken  = getEnv("TELEGRAM_TOKEN", "")
+	TelegramTenant = getEnv("TELEGRAM_TENANT", "")
+	TelegramChats  = getEnv("TELEGRAM_CHATS", "")
+	// WORKFLOWS_DIR holds workflow definitions in YAML or JSON loaded at startup, see server.LoadWorkflows
+	WorkflowsDir = getEnv("WORKFLOWS_DIR", "")
+	// EXECUTION_HISTORY is the number of executions kept for the history endpoints (0 = unlimited)
+	ExecutionHistory = getEnv("EXECUTION_HISTORY", "1000")
+	// SCHEDULES_FILE keeps the schedules and held executions across restarts, so they can catch up
+	// on missed runs
+	SchedulesFile = getEnv("SCHEDULES_FILE", "")
+	// OFFLINE_QUEUE_FILE enables the edge mode: the coordinator starts without the broker and
+	// queues the executions in this file until it is reachable
+	OfflineQueueFile = getEnv("OFFLINE_QUEUE_FILE", "")
+	// MOCK_FILE points to a JSON document with the canned results of mocked actions, see server.MockConfig
+	MockFile = getEnv("MOCK_FILE", "")
+	// RECORD_DIR records the executions to files, without the secrets named in the comma separated
+	// RECORD_REDACT (see replay.DefaultRedact). REPLAY_DIR serves such recordings instead of the
+	// workers, at their recorded pace with REPLAY_PACED=true.
+	RecordDir    = getEnv("RECORD_DIR", "")
+	RecordRedact = getEnv("RECORD_REDACT", "")
+	ReplayDir    = getEnv("REPLAY_DIR", "")
+	ReplayPaced  = getEnv("REPLAY_PACED", "false")
+	// MIN_WORKERS is the number of connected workers /readyz requires
+	MinWorkers = getEnv("MIN_WORKERS", "0")
+	// STREAM_BUFFER_SIZE is the number of recent events kept per execution stream for (re)connecting clients
+	StreamBufferSize = getEnv("STREAM_BUFFER_SIZE", "1000")
+	// MAX_REQUEST_BYTES, MAX_PARAMETERS and MAX_PARAMETER_DEPTH limit the execute requests,
+	// see server.RequestLimits
+	MaxRequestBytes   = getEnv("MAX_REQUEST_BYTES", "1048576")
+	MaxParameters     = getEnv("MAX_PARAMETERS", "256")
+	MaxParameterDepth = getEnv("MAX_PARAMETER_DEPTH", "32")
+	// STREAM_BUFFER_AGE drops the buffered stream events older than it, "0" keeps them
+	StreamBufferAge = getEnv("STREAM_BUFFER_AGE", "0")
+	// STATIC_OVERRIDE_DIR holds files shadowing the embedded static files (index.html, execution.html)
+	// or adding to them, served under /static/
+	StaticOverrideDir = getEnv("STATIC_OVERRIDE_DIR", "")
+	// SECURITY_CSP is the Content-Security-Policy of the responses, "off" sends none. Inline
+	// scripts need the 'nonce-{nonce}' source, the pages get the nonce of each request.
+	SecurityCSP = getEnv("SECURITY_CSP", server.DefaultContentSecurityPolicy)
+	// SECURITY_FRAME_OPTIONS is the X-Frame-Options header, "off" sends none
+	SecurityFrameOptions = getEnv("SECURITY_FRAME_OPTIONS", server.DefaultSecurityHeaders.FrameOptions)
+	// SECURITY_HSTS is the Strict-Transport-Security header, for coordinators behind HTTPS
+	SecurityHSTS = getEnv("SECURITY_HSTS", "")
+	// UI_TITLE, UI_LOGO_URL and UI_FEATURES brand the web interface, see server.UIConfig
+	UITitle    = getEnv("UI_TITLE", "Tinpot")
+	UILogoURL  = getEnv("UI_LOGO_URL", "")
+	UIFeatures = getEnv("UI_FEATURES", "")
+	// ALIASES_FILE points to a JSON document with action aliases, see tinpot.LoadAliases
+	AliasesFile = getEnv("ALIASES_FILE", "")
+	// FEDERATION_SITES points to a JSON document with the site coordinators whose actions this one
+	// serves, see federation.LoadSites. FEDERATION_INTERVAL is how often they are checked.
+	FederationSites    = getEnv("FEDERATION_SITES", "")
+	FederationInterval = getEnv("FEDERATION_INTERVAL", "30s")
+	// MQTT_DELIVERY overrides the QoS and retain flag per message class, see tinpot.ParseDelivery
+	MQTTDelivery = getEnv("MQTT_DELIVERY", "")
+	// MQTT_VERSION selects the protocol: "3" (3.1.1) or "5", which adds correlation user properties
+	MQTTVersion = getEnv("MQTT_VERSION", "3")
+	// REQUEST_SIGNING_KEY signs the execution requests, see tinpot.ParseSigningKey
+	RequestSigningKey = getEnv("REQUEST_SIGNING_KEY", "")
+	// MQTT_COMPRESSION asks the workers to compress results and logs: "gzip" or "zstd"
+	MQTTCompression = getEnv("MQTT_COMPRESSION", "")
+	// MQTT_FORMAT asks the workers for binary triggers, results and logs: "cbor" or "msgpack"
+	MQTTFormat = getEnv("MQTT_FORMAT", "")
+	// SESSION_IDLE is how long a session keeps its worker without executions
+	SessionIdle = getEnv("SESSION_IDLE", "1h")
+	// LOG_REORDER_WINDOW is how long log entries arriving out of order wait for the missing ones
+	LogReorderWindow = getEnv("LOG_REORDER_WINDOW", "200ms")
+	// EMBEDDED_BROKER_ADDRESS is the MQTT listener of the embedded broker (-embedded-broker),
+	// with TLS when EMBEDDED_BROKER_TLS_CERT and EMBEDDED_BROKER_TLS_KEY are set.
+	// EMBEDDED_BROKER_WEBSOCKET adds a websocket listener.
+	EmbeddedBrokerAddress   = getEnv("EMBEDDED_BROKER_ADDRESS", ":1883")
+	EmbeddedBrokerTLSCert   = getEnv("EMBEDDED_BROKER_TLS_CERT", "")
+	EmbeddedBrokerTLSKey    = getEnv("EMBEDDED_BROKER_TLS_KEY", "")
+	EmbeddedBrokerWebsocket = getEnv("EMBEDDED_BROKER_WEBSOCKET", "")
 )
 
 func getEnv(key, def string) string {
@@ -31,334 +182,322 @@ func getEnv(key, def string) string {
 	return def
 }
 
-// Execution Registry
-type ExecutionState struct {
-	EventChan chan StreamEvent
-	mu        sync.Mutex
-	Done      bool
-}
-
-var (
-	executions = make(map[string]*ExecutionState)
-	execMu     sync.RWMutex
-)
-
-func registerExecution(id string) *ExecutionState {
-	execMu.Lock()
-	defer execMu.Unlock()
-	state := &ExecutionState{
-		EventChan: make(chan StreamEvent, 1000), // Buffered to assume non-blocking for reasonable volume
-	}
-	executions[id] = state
-	return state
-}
-
-func getExecution(id string) *ExecutionState {
-	execMu.RLock()
-	defer execMu.RUnlock()
-	return executions[id]
-}
-
-func removeExecution(id string) {
-	execMu.Lock()
-	defer execMu.Unlock()
-	delete(executions, id)
-}
-
 func main() {
-	mgr := NewMqttActionManager(MQTTBroker)
-
-	// Setup Router
-	mux := http.NewServeMux()
-
-	// API Routes
-	mux.HandleFunc("GET /api/actions", func(w http.ResponseWriter, r *http.Request) {
-		listActions(w, r, mgr)
-	})
-	mux.HandleFunc("POST /api/actions/{name}/execute", func(w http.ResponseWriter, r *http.Request) {
-		executeAction(w, r, mgr, false)
-	})
-	mux.HandleFunc("POST /api/actions/{name}/sync_execute", func(w http.ResponseWriter, r *http.Request) {
-		executeAction(w, r, mgr, true)
-	})
-	mux.HandleFunc("GET /api/executions/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
-		streamLogs(w, r)
-	})
-	mux.HandleFunc("GET /api/executions/{id}/status", getStatus)
-	mux.HandleFunc("POST /api/executions/{id}/cancel", cancelAction)
+	embeddedBroker := flag.Bool("embedded-broker", getEnv("EMBEDDED_BROKER", "false") == "true", "run an MQTT broker in the coordinator for the workers, instead of connecting to MQTT_BROKER")
+	flag.Parse()
+	if *embeddedBroker {
+		b, err := broker.Start(broker.Options{
+			Address:          EmbeddedBrokerAddress,
+			TLSCert:          EmbeddedBrokerTLSCert,
+			TLSKey:           EmbeddedBrokerTLSKey,
+			WebsocketAddress: EmbeddedBrokerWebsocket,
+			Logger:           slog.Default(),
+		})
+		if err != nil {
+			log.Fatalf("Failed to start the embedded broker: %v", err)
+		}
+		defer b.Close()
+		log.Printf("Embedded MQTT broker listening on %s", EmbeddedBrokerAddress)
+		MQTTBroker = b.URL()
+	}
 
-	// Static Files - Serve from embedded FS
-	mux.Handle("/static/", http.FileServer(http.FS(staticContent)))
+	var mock server.MockConfig
+	if MockFile != "" {
+		var err error
+		if mock, err = server.LoadMockConfig(MockFile); err != nil {
+			log.Fatalf("Failed to load mock configuration: %v", err)
+		}
+	}
 
-	// Serve Index
-	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	// Setup MQTT
+	transport, err := newTransport("tinpot-coordinator-" + uuid.New().String())
+	if err != nil {
+		log.Fatalf("Invalid MQTT configuration: %v", err)
+	}
+	delivery, err := tinpot.ParseDelivery(MQTTDelivery)
+	if err != nil {
+		log.Fatalf("Invalid MQTT_DELIVERY: %v", err)
+	}
+	var signingKey *tinpot.SigningKey
+	if RequestSigningKey != "" {
+		if signingKey, err = tinpot.ParseSigningKey(RequestSigningKey); err != nil || !signingKey.CanSign() {
+			log.Fatalf("Invalid REQUEST_SIGNING_KEY: needs an HMAC secret or an Ed25519 private key (%v)", err)
 		}
-		// Read from embedded FS
-		indexFile, err := staticContent.ReadFile("static/index.html")
-		if err != nil {
-			http.Error(w, "Failed to load index.html", http.StatusInternalServerError)
-			return
+	}
+	compression, err := tinpot.ParseEncoding(MQTTCompression)
+	if err != nil {
+		log.Fatalf("Invalid MQTT_COMPRESSION: %v", err)
+	}
+	format, err := tinpot.ParseFormat(MQTTFormat)
+	if err != nil {
+		log.Fatalf("Invalid MQTT_FORMAT: %v", err)
+	}
+	sessionIdle, err := time.ParseDuration(SessionIdle)
+	if err != nil {
+		log.Fatalf("Invalid SESSION_IDLE: %v", err)
+	}
+	logReorderWindow, err := time.ParseDuration(LogReorderWindow)
+	if err != nil {
+		log.Fatalf("Invalid LOG_REORDER_WINDOW: %v", err)
+	}
+	events := tinpot.NewEventBus()
+	var mgr tinpot.ActionManager = remote.NewActionManager(transport, remote.Options{Events: events, Delivery: &delivery, SigningKey: signingKey, Compression: compression, Format: format, SessionIdle: sessionIdle, LogReorderWindow: logReorderWindow})
+	if ReplayDir != "" {
+		if mgr, err = replay.Load(ReplayDir, replay.Options{Paced: ReplayPaced == "true"}); err != nil {
+			log.Fatalf("Failed to load recordings: %v", err)
 		}
-		html := string(indexFile)
-		// Inject Base Path
-		script := fmt.Sprintf(`<script>window.BASE_PATH = "%s";</script>`, RootPath)
-		html = strings.Replace(html, "<!-- BASE_PATH_INJECTION -->", script, 1)
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(html))
-	})
-
-	// Serve Execution View with Injection
-	mux.HandleFunc("GET /static/execution.html", func(w http.ResponseWriter, r *http.Request) {
-		// Read from embedded FS
-		fileData, err := staticContent.ReadFile("static/execution.html")
+	}
+	if FederationSites != "" {
+		sites, err := federation.LoadSites(FederationSites)
 		if err != nil {
-			http.Error(w, "Failed to load execution.html", http.StatusInternalServerError)
-			return
+			log.Fatalf("Failed to load federation sites: %v", err)
 		}
-		html := string(fileData)
-		// Inject Base Path
-		script := fmt.Sprintf(`<script>window.BASE_PATH = "%s";</script>`, RootPath)
-		html = strings.Replace(html, "<!-- BASE_PATH_INJECTION -->", script, 1)
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(html))
-	})
-
-	// Health/Ready
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		if mgr.IsConnected() {
-			writeJSON(w, 200, map[string]string{"status": "healthy"})
-		} else {
-			writeJSON(w, 503, map[string]string{"status": "unhealthy", "detail": "MQTT not connected"})
+		interval, err := time.ParseDuration(FederationInterval)
+		if err != nil {
+			log.Fatalf("Invalid FEDERATION_INTERVAL: %v", err)
 		}
-	})
-
-	handler := corsMiddleware(mux)
-
-	port := getEnv("PORT", "8000")
-	log.Println("Starting Coordinator on :" + port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatal(err)
+		federated := federation.NewManager(mgr, sites, federation.Options{Interval: interval, Events: events})
+		go federated.Run(context.Background())
+		mgr = federated
 	}
-}
-
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	if AliasesFile != "" {
+		aliases, err := tinpot.LoadAliases(AliasesFile)
+		if err != nil {
+			log.Fatalf("Failed to load aliases: %v", err)
 		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-func writeJSON(w http.ResponseWriter, status int, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(v)
-}
-
-func listActions(w http.ResponseWriter, r *http.Request, mgr tinpot.ActionManager) {
-	writeJSON(w, 200, mgr.ListActions())
-}
-
-func executeAction(w http.ResponseWriter, r *http.Request, mgr tinpot.ActionManager, syncMode bool) {
-	actionName := r.PathValue("name")
-
-	trigger := mgr.GetAction(actionName)
-	if trigger == nil {
-		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
-		return
+		mgr = tinpot.NewAliasManager(mgr, aliases)
 	}
-
-	var req ExecuteActionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
-		return
+	if OfflineQueueFile != "" {
+		go func() {
+			if err := transport.Connect(); err != nil {
+				log.Printf("Failed to connect to MQTT, queueing executions: %v", err)
+			}
+		}()
+	} else if mock.All || ReplayDir != "" {
+		// Mocked and replayed actions need no broker
+		go func() {
+			if err := transport.Connect(); err != nil {
+				log.Printf("Failed to connect to MQTT: %v", err)
+			}
+		}()
+	} else if err := transport.Connect(); err != nil {
+		log.Fatalf("Failed to connect to MQTT: %v", err)
 	}
 
-	// Request Parameters
-	params := req.Parameters
-	if params == nil {
-		params = make(map[string]interface{})
+	opts := server.Options{Events: events, OfflineQueueFile: OfflineQueueFile, SchedulesFile: SchedulesFile, Mock: mock, RecordDir: RecordDir}
+	if RecordRedact != "" {
+		opts.RecordRedact = strings.Split(RecordRedact, ",")
 	}
-
-	// Generate Execution ID and inject it
-	execID := uuid.New().String()
-	params["_execution_id"] = execID
-
-	if syncMode {
-		var finalResult map[string]interface{}
-		var finalError string
-		var wg sync.WaitGroup
-		wg.Add(1)
-
-		trigger(params, func(err string, res map[string]interface{}) {
-			finalError = err
-			finalResult = res
-			wg.Done()
-		}, nil) // No logs callback for sync
-
-		wg.Wait()
-
-		status := "SUCCESS"
-		if finalError != "" {
-			status = "FAILURE"
-		}
-
-		writeJSON(w, 200, SyncExecutionResponse{
-			ExecutionID: execID,
-			ActionName:  actionName,
-			Status:      status,
-			Result:      finalResult,
+	tokens, err := server.ParseAPITokens(APITokens)
+	if err != nil {
+		log.Fatalf("Invalid API_TOKENS: %v", err)
+	}
+	opts.Tokens = tokens
+	mapping, err := server.ParseRoleMapping(AuthRoles, AuthTenants)
+	if err != nil {
+		log.Fatalf("Invalid AUTH_ROLES or AUTH_TENANTS: %v", err)
+	}
+	if OIDCIssuer != "" {
+		oidcProvider, err := oidcauth.New(context.Background(), oidcauth.Config{
+			Issuer:       OIDCIssuer,
+			ClientID:     OIDCClientID,
+			ClientSecret: OIDCClientSecret,
+			RedirectURL:  OIDCRedirectURL,
+			Audience:     OIDCAudience,
+			GroupsClaim:  OIDCGroupsClaim,
+			HomeURL:      RootPath + "/",
+			Mapping:      mapping,
 		})
-		return
+		if err != nil {
+			log.Fatalf("Failed to set up OIDC: %v", err)
+		}
+		opts.AuthProviders = append(opts.AuthProviders, oidcProvider)
 	}
-
-	// Async
-	state := registerExecution(execID)
-
-	// Log Callback
-	logCallback := func(level string, message string) {
-		event := StreamEvent{
-			Type: "log",
-			Data: tinpot.MqttLogEntry{
-				Timestamp: time.Now().Format(time.RFC3339),
-				Level:     level,
-				Message:   message,
-			},
+	if LDAPURL != "" {
+		opts.AuthProviders = append(opts.AuthProviders, ldapauth.New(ldapauth.Config{
+			URL:          LDAPURL,
+			StartTLS:     LDAPStartTLS == "true",
+			BindDN:       LDAPBindDN,
+			BindPassword: LDAPBindPassword,
+			BaseDN:       LDAPBaseDN,
+			UserFilter:   LDAPUserFilter,
+			GroupFilter:  LDAPGroupFilter,
+			Mapping:      mapping,
+		}))
+	}
+	if QuotasFile != "" {
+		if opts.Quotas, err = server.LoadQuotaConfig(QuotasFile); err != nil {
+			log.Fatalf("Failed to load quotas: %v", err)
 		}
-		// Non-blocking send to not stall execution
-		select {
-		case state.EventChan <- event:
-		default:
-			log.Printf("Dropped log for %s due to full buffer", execID)
+	}
+	if opts.LoadShedding.Thresholds, err = server.ParseSheddingThresholds(LoadShedding); err != nil {
+		log.Fatalf("Invalid LOAD_SHEDDING: %v", err)
+	}
+	if opts.LoadShedding.RetryAfter, err = time.ParseDuration(LoadSheddingRetryAfter); err != nil {
+		log.Fatalf("Invalid LOAD_SHEDDING_RETRY_AFTER: %v", err)
+	}
+	opts.Visibility = server.VisibilityPolicy{Scope: ExecutionVisibility, Role: ExecutionVisibilityRole}
+	if err := opts.Visibility.Validate(); err != nil {
+		log.Fatalf("Invalid EXECUTION_VISIBILITY or EXECUTION_VISIBILITY_ROLE: %v", err)
+	}
+	if opts.UsageRates, err = server.ParseUsageRates(UsageRates); err != nil {
+		log.Fatalf("Invalid USAGE_RATES: %v", err)
+	}
+	if opts.CanaryTraffic, err = server.ParseCanaryTraffic(CanaryTraffic); err != nil {
+		log.Fatalf("Invalid CANARY_TRAFFIC: %v", err)
+	}
+	if AlertRulesFile != "" {
+		if opts.AlertRules, err = server.LoadAlertRules(AlertRulesFile); err != nil {
+			log.Fatalf("Failed to load alert rules: %v", err)
 		}
 	}
-
-	// Response Callback
-	responseCallback := func(err string, res map[string]interface{}) {
-		success := err == ""
-		status := "SUCCESS"
-		if !success {
-			status = "FAILURE"
+	if MaintenanceFile != "" {
+		if opts.Maintenance, err = server.LoadMaintenanceConfig(MaintenanceFile); err != nil {
+			log.Fatalf("Failed to load maintenance windows: %v", err)
 		}
-
-		data := map[string]interface{}{
-			"state":      status,
-			"successful": success,
+	}
+	if AlertmanagerFile != "" {
+		if opts.Alertmanager, err = server.LoadAlertmanagerConfig(AlertmanagerFile); err != nil {
+			log.Fatalf("Failed to load Alertmanager routes: %v", err)
 		}
-		if success {
-			data["result"] = res
-		} else {
-			data["error"] = err
+	}
+	opts.GitWebhookSecret = GitWebhookSecret
+	opts.Slack = server.SlackConfig{SigningSecret: SlackSigningSecret, Tenant: SlackTenant}
+	if SlackUsers != "" {
+		opts.Slack.Users = strings.Split(SlackUsers, ",")
+	}
+	if WorkflowsDir != "" {
+		if opts.Workflows, err = server.LoadWorkflows(WorkflowsDir); err != nil {
+			log.Fatalf("Failed to load workflows: %v", err)
 		}
-
-		event := StreamEvent{
-			Type: "complete",
-			Data: data,
+	}
+	historySize, err := strconv.Atoi(ExecutionHistory)
+	if err != nil {
+		log.Fatalf("Invalid EXECUTION_HISTORY: %v", err)
+	}
+	if opts.MinWorkers, err = strconv.Atoi(MinWorkers); err != nil {
+		log.Fatalf("Invalid MIN_WORKERS: %v", err)
+	}
+	if opts.StreamBufferSize, err = strconv.Atoi(StreamBufferSize); err != nil || opts.StreamBufferSize < 1 {
+		log.Fatalf("Invalid STREAM_BUFFER_SIZE: %q", StreamBufferSize)
+	}
+	if opts.RequestLimits.MaxBodyBytes, err = strconv.ParseInt(MaxRequestBytes, 10, 64); err != nil || opts.RequestLimits.MaxBodyBytes < 1 {
+		log.Fatalf("Invalid MAX_REQUEST_BYTES: %q", MaxRequestBytes)
+	}
+	if opts.RequestLimits.MaxParameters, err = strconv.Atoi(MaxParameters); err != nil || opts.RequestLimits.MaxParameters < 1 {
+		log.Fatalf("Invalid MAX_PARAMETERS: %q", MaxParameters)
+	}
+	if opts.RequestLimits.MaxDepth, err = strconv.Atoi(MaxParameterDepth); err != nil || opts.RequestLimits.MaxDepth < 1 {
+		log.Fatalf("Invalid MAX_PARAMETER_DEPTH: %q", MaxParameterDepth)
+	}
+	if opts.StreamBufferAge, err = time.ParseDuration(StreamBufferAge); err != nil {
+		log.Fatalf("Invalid STREAM_BUFFER_AGE: %v", err)
+	}
+	features, err := server.ParseUIFeatures(UIFeatures)
+	if err != nil {
+		log.Fatalf("Invalid UI_FEATURES: %v", err)
+	}
+	opts.UI = server.UIConfig{Title: UITitle, LogoURL: UILogoURL, BasePath: RootPath, Features: features}
+	opts.Plugins = server.RegisteredPlugins()
+	if GrafanaURL != "" {
+		var tags []string
+		if GrafanaTags != "" {
+			tags = strings.Split(GrafanaTags, ",")
 		}
-
-		// Send complete and close
-		select {
-		case state.EventChan <- event:
-		default:
+		opts.Plugins = append(opts.Plugins, grafana.New(grafana.Config{URL: GrafanaURL, Token: GrafanaToken, Tags: tags, DashboardUID: GrafanaDashboardUID}))
+	}
+	if TelegramToken != "" {
+		config := telegram.Config{Token: TelegramToken, Tenant: TelegramTenant}
+		for _, c := range strings.Split(TelegramChats, ",") {
+			if c == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(c, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid TELEGRAM_CHATS: %v", err)
+			}
+			config.Chats = append(config.Chats, id)
 		}
-		close(state.EventChan)
-
-		// Cleanup after some time?
-		// We can't cleanup immediately if client is still reading buffered channel?
-		// Channel is safely closed, client will drain it.
-		// But map entry persists.
-		// We should start a timer to remove execution from map.
-		go func() {
-			time.Sleep(1 * time.Minute)
-			removeExecution(execID)
-		}()
+		opts.Plugins = append(opts.Plugins, telegram.New(config))
 	}
+	srv := server.NewServer(mgr, server.NewMemoryStore(historySize), opts)
+	srv.RunPlugins(context.Background())
 
-	go trigger(params, responseCallback, logCallback)
+	// Setup Router
+	mux := http.NewServeMux()
 
-	// Async Response
-	writeJSON(w, 200, ExecutionResponse{
-		ExecutionID: execID,
-		ActionName:  actionName,
-		Status:      "submitted",
-		StreamURL:   fmt.Sprintf("/api/executions/%s/stream", execID),
-	})
-}
+	// API Routes
+	mux.Handle("/api/", srv)
+	mux.Handle("GET /health", srv)
+	mux.Handle("GET /livez", srv)
+	mux.Handle("GET /readyz", srv)
 
-func streamLogs(w http.ResponseWriter, r *http.Request) {
-	execID := r.PathValue("id")
+	mux.Handle("/auth/", srv)
 
-	state := getExecution(execID)
-	if state == nil {
-		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
-		return
+	// Static Files - Serve from embedded FS, shadowed by the override directory
+	assets, _ := fs.Sub(staticContent, "static")
+	if StaticOverrideDir != "" {
+		assets = overlayFS{override: os.DirFS(StaticOverrideDir), base: assets}
 	}
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
-
-	// For http.ResponseWriter, we check if it supports flushing
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
+	mux.Handle("/static/", staticHandler(assets, srv))
+	mux.Handle("GET /{$}", pageHandler(assets, "index.html", srv))
+
+	headers := server.DefaultSecurityHeaders
+	headers.ContentSecurityPolicy, headers.FrameOptions, headers.StrictTransportSecurity = SecurityCSP, SecurityFrameOptions, SecurityHSTS
+	// The execution view is made for iframes
+	headers.Embeddable = []string{"/static/execution.html"}
+	if SecurityCSP == "off" {
+		headers.ContentSecurityPolicy = ""
 	}
+	if SecurityFrameOptions == "off" {
+		headers.FrameOptions = ""
+	}
+	handler := corsMiddleware(headers.Handler(mux))
 
-	// Send connected
-	encoded, _ := json.Marshal(map[string]string{"type": "connected", "execution_id": execID})
-	fmt.Fprintf(w, "data: %s\n\n", encoded)
-	flusher.Flush()
+	port := getEnv("PORT", "8000")
+	log.Println("Starting Coordinator on :" + port)
+	if err := http.ListenAndServe(":"+port, handler); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// Iterate over channel
-	ctx := r.Context()
-	for {
-		select {
-		case event, ok := <-state.EventChan:
-			if !ok {
-				// Channel closed (completed)
-				return
-			}
-			bytes, _ := json.Marshal(event)
-			fmt.Fprintf(w, "data: %s\n\n", bytes)
-			flusher.Flush()
-		case <-ctx.Done():
+// pageHandler serves an embedded page with window.TINPOT_CONFIG (and the older
+// window.BASE_PATH) set in place of its injection marker, and the CSP nonce on its scripts
+func pageHandler(assets fs.FS, name string, srv *server.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := fs.ReadFile(assets, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
 			return
 		}
-	}
+		if err != nil {
+			http.Error(w, "Failed to load "+path.Base(name), http.StatusInternalServerError)
+			return
+		}
+		// The JSON encoding escapes <, > and &, so it can't end the script
+		config, _ := json.Marshal(srv.UIConfig())
+		script := fmt.Sprintf(`<script>window.TINPOT_CONFIG = %s; window.BASE_PATH = window.TINPOT_CONFIG.base_path;</script>`, config)
+		html := strings.Replace(string(page), "<!-- TINPOT_CONFIG_INJECTION -->", script, 1)
+		if nonce := server.CSPNonce(r); nonce != "" {
+			html = strings.ReplaceAll(html, "<script>", `<script nonce="`+nonce+`">`)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(html))
+	})
 }
 
-func getStatus(w http.ResponseWriter, r *http.Request) {
-	state := getExecution(r.PathValue("id"))
-	status := "PENDING"
-	if state == nil {
-		status = "UNKNOWN"
-	} else {
-		// We could check if channel is closed?
-		// Or add Status field to state.
-	}
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
-	writeJSON(w, 200, map[string]interface{}{
-		"execution_id": r.PathValue("id"),
-		"state":        status,
-		"ready":        false,
-	})
-}
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 
-func cancelAction(w http.ResponseWriter, r *http.Request) {
-	// Not supported
-	writeJSON(w, 501, map[string]string{"detail": "Cancellation not supported"})
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,7 @@
+package main
+
+// Plugins register themselves with server.RegisterPlugin when their package is imported.
+// To compile one into the coordinator, import it here (or in a file of your own, so
+// updates don't conflict):
+//
+//	import _ "example.com/tinpot-plugins/audit"
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/balazsgrill/tinpot/server"
+)
+
+// overlayFS serves the files of override, falling back to base for the ones it lacks
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// staticHandler serves the assets under /static/, the pages with the UI configuration injected
+func staticHandler(assets fs.FS, srv *server.Server) http.Handler {
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := strings.TrimPrefix(r.URL.Path, "/static/"); strings.HasSuffix(name, ".html") && (r.Method == "GET" || r.Method == "HEAD") {
+			pageHandler(assets, name, srv).ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
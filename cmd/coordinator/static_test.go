@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestStaticOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><!-- TINPOT_CONFIG_INJECTION --><script>branded()</script></html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "logo.svg"), []byte("<svg/>"), 0644)
+	embedded, _ := fs.Sub(staticContent, "static")
+	srv := server.NewServer(tinpottest.NewActionManager(), nil, server.Options{UI: server.UIConfig{Title: "Ops"}})
+	assets := overlayFS{override: os.DirFS(dir), base: embedded}
+	ts := httptest.NewServer(server.DefaultSecurityHeaders.Handler(staticHandler(assets, srv)))
+	defer ts.Close()
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// Overridden files shadow the embedded ones
+	if resp, body := get("/static/logo.svg"); resp.StatusCode != 200 || body != "<svg/>" {
+		t.Errorf("override not served: %d %s", resp.StatusCode, body)
+	}
+	// Overridden pages still get the configuration and the nonce of the policy
+	resp, body := get("/static/index.html")
+	nonce := strings.Split(strings.Split(resp.Header.Get("Content-Security-Policy"), "'nonce-")[1], "'")[0]
+	if !strings.Contains(body, "branded()") || !strings.Contains(body, `"title":"Ops"`) || strings.Contains(body, "TINPOT_CONFIG_INJECTION") {
+		t.Errorf("configuration not injected into the overridden page: %s", body)
+	}
+	if nonce == "" || strings.Count(body, `<script nonce="`+nonce+`">`) != 2 {
+		t.Errorf("scripts of the overridden page without the nonce %q: %s", nonce, body)
+	}
+	// The missing ones fall back to the embedded assets
+	if resp, body := get("/static/execution.html"); resp.StatusCode != 200 || !strings.Contains(body, "<title>Execution Log</title>") || !strings.Contains(body, `"title":"Ops"`) {
+		t.Errorf("embedded page not served: %d", resp.StatusCode)
+	}
+	if resp, _ := get("/static/missing.css"); resp.StatusCode != 404 {
+		t.Errorf("expected 404 for a file in neither, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/mqtt5transport"
+	"github.com/balazsgrill/tinpot/mqtttransport"
+	"github.com/eclipse/paho.golang/autopaho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectableTransport is a transport whose connection is managed by main
+type connectableTransport interface {
+	tinpot.Transport
+	Connect() error
+}
+
+func newTransport(clientID string) (connectableTransport, error) {
+	brokers, err := tinpot.ResolveBrokers(context.Background(), MQTTBroker)
+	if err != nil {
+		return nil, err
+	}
+	switch MQTTVersion {
+	case "3":
+		opts := mqtt.NewClientOptions()
+		for _, broker := range brokers {
+			opts.AddBroker(broker.String())
+		}
+		opts.SetClientID(clientID)
+		opts.SetAutoReconnect(true)
+		// Without a broker at startup, keep trying in the background in the edge mode
+		opts.SetConnectRetry(OfflineQueueFile != "")
+		return mqtttransport.New(opts), nil
+	case "5":
+		cfg := autopaho.ClientConfig{ServerUrls: brokers, KeepAlive: 30}
+		cfg.ClientID = clientID
+		return mqtt5transport.New(cfg), nil
+	}
+	return nil, fmt.Errorf("unsupported MQTT_VERSION %q", MQTTVersion)
+}
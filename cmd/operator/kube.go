@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient is the bit of the Kubernetes API the operator needs: listing and
+// patching the tinpot.io custom resources
+type kubeClient struct {
+	url       string
+	token     string
+	namespace string // watched namespace, all when empty
+	client    *http.Client
+}
+
+// newKubeClient connects to KUBE_API_URL (e.g. "kubectl proxy") when set, otherwise to
+// the cluster the operator runs in with its service account
+func newKubeClient(url, token, namespace string) (*kubeClient, error) {
+	k := &kubeClient{url: strings.TrimSuffix(url, "/"), token: token, namespace: namespace, client: http.DefaultClient}
+	if k.url != "" {
+		return k, nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		return nil, fmt.Errorf("not running in a cluster, set KUBE_API_URL")
+	}
+	k.url = "https://" + host + ":" + port
+	saToken, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	k.token = strings.TrimSpace(string(saToken))
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	return k, nil
+}
+
+func (k *kubeClient) resourcePath(resource, namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("/apis/%s/%s", apiVersion, resource)
+	}
+	return fmt.Sprintf("/apis/%s/namespaces/%s/%s", apiVersion, namespace, resource)
+}
+
+// list decodes the items of a resource in the watched namespace
+func (k *kubeClient) list(ctx context.Context, resource string, items interface{}) error {
+	var list struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := k.do(ctx, "GET", k.resourcePath(resource, k.namespace), nil, &list); err != nil {
+		return err
+	}
+	return json.Unmarshal(list.Items, items)
+}
+
+// patch merge-patches an object, or its status subresource with subresource "status"
+func (k *kubeClient) patch(ctx context.Context, resource string, meta objectMeta, subresource string, patch interface{}) error {
+	path := k.resourcePath(resource, meta.Namespace) + "/" + meta.Name
+	if subresource != "" {
+		path += "/" + subresource
+	}
+	return k.do(ctx, "PATCH", path, patch, nil)
+}
+
+func (k *kubeClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.url+path, reader)
+	if err != nil {
+		return err
+	}
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, data)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
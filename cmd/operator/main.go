@@ -0,0 +1,56 @@
+// operator reconciles the TinpotAction and TinpotSchedule resources of a Kubernetes
+// cluster into coordinator configuration, and reports their state in the resource status
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Configuration
+var (
+	CoordinatorURL = getEnv("COORDINATOR_URL", "http://localhost:8000")
+	// Token of an admin, schedules and derived actions are admin operations
+	CoordinatorToken = getEnv("COORDINATOR_TOKEN", "")
+	// Kubernetes API, e.g. "kubectl proxy" outside of the cluster; the service account is used when empty
+	KubeAPIURL = getEnv("KUBE_API_URL", "")
+	KubeToken  = getEnv("KUBE_TOKEN", "")
+	// Namespace of the resources, all namespaces when empty
+	WatchNamespace = getEnv("WATCH_NAMESPACE", "")
+	ResyncInterval = getEnv("RESYNC_INTERVAL", "30s")
+)
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	interval, err := time.ParseDuration(ResyncInterval)
+	if err != nil || interval <= 0 {
+		log.Fatalf("Invalid RESYNC_INTERVAL: %s", ResyncInterval)
+	}
+	kube, err := newKubeClient(KubeAPIURL, KubeToken, WatchNamespace)
+	if err != nil {
+		log.Fatalf("Failed to connect to Kubernetes: %v", err)
+	}
+	op := &operator{
+		kube:        kube,
+		coordinator: &coordinatorClient{url: CoordinatorURL, token: CoordinatorToken, client: http.DefaultClient},
+	}
+
+	log.Printf("Reconciling %s resources into %s every %s", apiVersion, CoordinatorURL, interval)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := op.reconcile(ctx); err != nil {
+			log.Printf("Reconcile failed: %v", err)
+		}
+		cancel()
+		time.Sleep(interval)
+	}
+}
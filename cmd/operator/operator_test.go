@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+// fakeKube serves the tinpot.io resources of a namespace, or of all of them
+type fakeKube struct {
+	mu      sync.Mutex
+	objects map[string][]map[string]interface{}
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/apis/"+apiVersion+"/"), "/")
+	namespace := ""
+	if parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	inNamespace := func(obj map[string]interface{}) bool {
+		return namespace == "" || obj["metadata"].(map[string]interface{})["namespace"] == namespace
+	}
+	if r.Method == "GET" {
+		var items []map[string]interface{}
+		for _, obj := range f.objects[parts[0]] {
+			if inNamespace(obj) {
+				items = append(items, obj)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		return
+	}
+	var patch map[string]map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&patch)
+	for _, obj := range f.objects[parts[0]] {
+		meta := obj["metadata"].(map[string]interface{})
+		if meta["name"] != parts[1] || !inNamespace(obj) {
+			continue
+		}
+		if len(parts) > 2 {
+			obj["status"] = patch["status"]
+		} else {
+			meta["finalizers"] = patch["metadata"]["finalizers"]
+		}
+	}
+}
+
+func (f *fakeKube) add(resource, name string, spec map[string]interface{}) {
+	f.addIn("default", resource, name, spec)
+}
+
+func (f *fakeKube) addIn(namespace, resource, name string, spec map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[resource] = append(f.objects[resource], map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": namespace, "generation": 1},
+		"spec":     spec,
+	})
+}
+
+func (f *fakeKube) get(resource, name string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, _ := json.Marshal(f.objects[resource])
+	var objects []map[string]interface{}
+	json.Unmarshal(data, &objects)
+	for _, obj := range objects {
+		if obj["metadata"].(map[string]interface{})["name"] == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "svc_restart", Parameters: map[string]tinpot.ParameterInfo{
+		"service": {Type: "str"},
+	}}, tinpottest.Echo())
+	coordinator := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer coordinator.Close()
+	kube := &fakeKube{objects: make(map[string][]map[string]interface{})}
+	kubeServer := httptest.NewServer(kube)
+	defer kubeServer.Close()
+
+	kube.add("tinpotactions", "nginx-restart", map[string]interface{}{"action": "svc_restart", "parameters": map[string]interface{}{"service": "nginx"}})
+	kube.add("tinpotschedules", "nightly", map[string]interface{}{"action": "nginx-restart", "cron": "@every 20ms"})
+	op := &operator{
+		kube:        &kubeClient{url: kubeServer.URL, namespace: "default", client: http.DefaultClient},
+		coordinator: &coordinatorClient{url: coordinator.URL, client: http.DefaultClient},
+	}
+
+	tinpottest.WaitFor(t, func() bool {
+		if err := op.reconcile(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		status, _ := kube.get("tinpotschedules", "nightly")["status"].(map[string]interface{})
+		return status != nil && status["lastStatus"] == tinpot.StatusSuccess
+	})
+
+	action := kube.get("tinpotactions", "nginx-restart")
+	if status := action["status"].(map[string]interface{}); status["ready"] != true {
+		t.Errorf("action not ready: %v", status)
+	}
+	if finalizers := action["metadata"].(map[string]interface{})["finalizers"]; len(finalizers.([]interface{})) != 1 {
+		t.Errorf("finalizer not added: %v", finalizers)
+	}
+	status := kube.get("tinpotschedules", "nightly")["status"].(map[string]interface{})
+	if result := status["lastResult"].(map[string]interface{}); result["service"] != "nginx" {
+		t.Errorf("unexpected result: %v", status)
+	}
+
+	// Deleted schedules are removed from the coordinator before their finalizer
+	kube.mu.Lock()
+	kube.objects["tinpotschedules"][0]["metadata"].(map[string]interface{})["deletionTimestamp"] = time.Now()
+	kube.mu.Unlock()
+	if err := op.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if finalizers := kube.get("tinpotschedules", "nightly")["metadata"].(map[string]interface{})["finalizers"]; len(finalizers.([]interface{})) != 0 {
+		t.Errorf("finalizer not removed: %v", finalizers)
+	}
+	resp, err := http.Get(coordinator.URL + "/api/schedules/nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("schedule not deleted: %d", resp.StatusCode)
+	}
+}
+
+func TestReconcileAllNamespaces(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "svc_restart"}, tinpottest.Echo())
+	coordinator := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer coordinator.Close()
+	kube := &fakeKube{objects: make(map[string][]map[string]interface{})}
+	kubeServer := httptest.NewServer(kube)
+	defer kubeServer.Close()
+
+	// Same-named resources of two namespaces don't overwrite each other
+	kube.addIn("team-a", "tinpotschedules", "nightly", map[string]interface{}{"action": "svc_restart", "cron": "@daily"})
+	kube.addIn("team-b", "tinpotschedules", "nightly", map[string]interface{}{"action": "svc_restart", "cron": "@hourly"})
+	op := &operator{
+		kube:        &kubeClient{url: kubeServer.URL, client: http.DefaultClient},
+		coordinator: &coordinatorClient{url: coordinator.URL, client: http.DefaultClient},
+	}
+	if err := op.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	schedule := func(id string) server.ScheduleResponse {
+		resp, err := http.Get(coordinator.URL + "/api/schedules/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var schedule server.ScheduleResponse
+		json.NewDecoder(resp.Body).Decode(&schedule)
+		return schedule
+	}
+	if a, b := schedule("team-a.nightly"), schedule("team-b.nightly"); a.Cron != "@daily" || b.Cron != "@hourly" {
+		t.Fatalf("schedules not kept apart: %+v %+v", a, b)
+	}
+
+	// Deleting one of them leaves the other in place
+	kube.mu.Lock()
+	kube.objects["tinpotschedules"][0]["metadata"].(map[string]interface{})["deletionTimestamp"] = time.Now()
+	kube.mu.Unlock()
+	if err := op.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := schedule("team-a.nightly"), schedule("team-b.nightly"); a.ID != "" || b.Cron != "@hourly" {
+		t.Errorf("unexpected schedules after the deletion: %+v %+v", a, b)
+	}
+}
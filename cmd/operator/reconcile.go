@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+)
+
+const (
+	apiVersion = "tinpot.io/v1alpha1"
+	// finalizer keeps deleted resources until their coordinator configuration is removed
+	finalizer = "tinpot.io/cleanup"
+)
+
+type objectMeta struct {
+	Name              string     `json:"name"`
+	Namespace         string     `json:"namespace"`
+	Generation        int64      `json:"generation"`
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+	Finalizers        []string   `json:"finalizers,omitempty"`
+}
+
+// TinpotAction declares an action derived from a worker's action, see POST /api/actions/{name}/derive
+type tinpotAction struct {
+	Metadata objectMeta   `json:"metadata"`
+	Spec     actionSpec   `json:"spec"`
+	Status   actionStatus `json:"status"`
+}
+
+type actionSpec struct {
+	// Name of the derived action, the resource name when empty
+	Name string `json:"name,omitempty"`
+	// Action it is derived from
+	Action      string                 `json:"action"`
+	Description string                 `json:"description,omitempty"`
+	Group       string                 `json:"group,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Defaults    map[string]interface{} `json:"defaults,omitempty"`
+}
+
+type actionStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Ready              bool   `json:"ready"`
+	Error              string `json:"error,omitempty"`
+}
+
+// TinpotSchedule declares a schedule, see PUT /api/schedules/{id}
+type tinpotSchedule struct {
+	Metadata objectMeta     `json:"metadata"`
+	Spec     scheduleSpec   `json:"spec"`
+	Status   scheduleStatus `json:"status"`
+}
+
+type scheduleSpec struct {
+	// ID of the schedule, the resource name when empty
+	ID string `json:"id,omitempty"`
+	server.ScheduleRequest
+}
+
+type scheduleStatus struct {
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+	Ready              bool                   `json:"ready"`
+	Error              string                 `json:"error,omitempty"`
+	NextRun            *time.Time             `json:"nextRun,omitempty"`
+	LastRun            *time.Time             `json:"lastRun,omitempty"`
+	LastExecutionID    string                 `json:"lastExecutionID,omitempty"`
+	LastStatus         string                 `json:"lastStatus,omitempty"`
+	LastError          string                 `json:"lastError,omitempty"`
+	LastResult         map[string]interface{} `json:"lastResult,omitempty"`
+}
+
+type operator struct {
+	kube        *kubeClient
+	coordinator *coordinatorClient
+}
+
+// reconcile brings the coordinator in line with the resources, actions first as the
+// schedules may run them
+func (o *operator) reconcile(ctx context.Context) error {
+	var actions []tinpotAction
+	if err := o.kube.list(ctx, "tinpotactions", &actions); err != nil {
+		return err
+	}
+	var catalog map[string]tinpot.ActionInfo
+	if err := o.coordinator.do(ctx, "GET", "/api/actions", nil, &catalog); err != nil {
+		return err
+	}
+	for _, act := range actions {
+		o.reconcileAction(ctx, act, catalog)
+	}
+
+	var schedules []tinpotSchedule
+	if err := o.kube.list(ctx, "tinpotschedules", &schedules); err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		o.reconcileSchedule(ctx, schedule)
+	}
+	return nil
+}
+
+func (o *operator) reconcileAction(ctx context.Context, act tinpotAction, catalog map[string]tinpot.ActionInfo) {
+	name := act.Spec.Name
+	if name == "" {
+		name = o.defaultName(act.Metadata)
+	}
+	path := "/api/actions/" + url.PathEscape(name)
+	if o.finalize(ctx, "tinpotactions", act.Metadata, path) {
+		return
+	}
+
+	status := act.Status
+	_, exists := catalog[name]
+	if !exists || status.ObservedGeneration != act.Metadata.Generation {
+		// Derived actions can't be modified, changed ones are replaced
+		if exists {
+			if err := o.coordinator.do(ctx, "DELETE", path, nil, nil); err != nil && !isNotFound(err) {
+				log.Printf("Failed to replace action %s: %v", name, err)
+			}
+		}
+		req := server.DeriveActionRequest{
+			Name:        name,
+			Description: act.Spec.Description,
+			Group:       act.Spec.Group,
+			Parameters:  act.Spec.Parameters,
+			Defaults:    act.Spec.Defaults,
+		}
+		err := o.coordinator.do(ctx, "POST", "/api/actions/"+url.PathEscape(act.Spec.Action)+"/derive", req, nil)
+		status = actionStatus{ObservedGeneration: act.Metadata.Generation, Ready: err == nil}
+		if err != nil {
+			status.Error = err.Error()
+		}
+	}
+	if !reflect.DeepEqual(status, act.Status) {
+		o.patchStatus(ctx, "tinpotactions", act.Metadata, status)
+	}
+}
+
+func (o *operator) reconcileSchedule(ctx context.Context, schedule tinpotSchedule) {
+	id := schedule.Spec.ID
+	if id == "" {
+		id = o.defaultName(schedule.Metadata)
+	}
+	path := "/api/schedules/" + url.PathEscape(id)
+	if o.finalize(ctx, "tinpotschedules", schedule.Metadata, path) {
+		return
+	}
+
+	var resp server.ScheduleResponse
+	status := scheduleStatus{ObservedGeneration: schedule.Metadata.Generation}
+	if err := o.coordinator.do(ctx, "PUT", path, schedule.Spec.ScheduleRequest, &resp); err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Ready = true
+		status.NextRun = resp.NextRun
+		if run := resp.LastRun; run != nil {
+			status.LastRun = &run.At
+			status.LastExecutionID = run.ExecutionID
+			status.LastError = run.Error
+		}
+		if rec := resp.LastExecution; rec != nil {
+			status.LastStatus = rec.Status
+			status.LastResult = rec.Result
+			if rec.Error != "" {
+				status.LastError = rec.Error
+			}
+		}
+	}
+	if !reflect.DeepEqual(status, schedule.Status) {
+		o.patchStatus(ctx, "tinpotschedules", schedule.Metadata, status)
+	}
+}
+
+// defaultName names the coordinator configuration of a resource without an explicit name.
+// Watching all namespaces, the resource name is qualified with its namespace ("team-a.nightly")
+// as resources of different namespaces may share a name.
+func (o *operator) defaultName(meta objectMeta) string {
+	if o.kube.namespace == "" {
+		return meta.Namespace + "." + meta.Name
+	}
+	return meta.Name
+}
+
+// finalize removes the coordinator configuration (at path) of a deleted resource and
+// reports whether it was deleted; live resources get the finalizer
+func (o *operator) finalize(ctx context.Context, resource string, meta objectMeta, path string) bool {
+	has := slices.Contains(meta.Finalizers, finalizer)
+	if meta.DeletionTimestamp == nil {
+		if !has {
+			finalizers := append(slices.Clone(meta.Finalizers), finalizer)
+			if err := o.kube.patch(ctx, resource, meta, "", map[string]interface{}{"metadata": map[string]interface{}{"finalizers": finalizers}}); err != nil {
+				log.Printf("Failed to add the finalizer to %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+			}
+		}
+		return false
+	}
+	if has {
+		if err := o.coordinator.do(ctx, "DELETE", path, nil, nil); err != nil && !isNotFound(err) {
+			log.Printf("Failed to clean up %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+			return true
+		}
+		finalizers := slices.DeleteFunc(slices.Clone(meta.Finalizers), func(f string) bool { return f == finalizer })
+		if err := o.kube.patch(ctx, resource, meta, "", map[string]interface{}{"metadata": map[string]interface{}{"finalizers": finalizers}}); err != nil {
+			log.Printf("Failed to remove the finalizer of %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+		}
+	}
+	return true
+}
+
+func (o *operator) patchStatus(ctx context.Context, resource string, meta objectMeta, status interface{}) {
+	if err := o.kube.patch(ctx, resource, meta, "status", map[string]interface{}{"status": status}); err != nil {
+		log.Printf("Failed to update the status of %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+	}
+}
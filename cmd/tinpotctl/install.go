@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// install deploys a bundle archive to the actions directory of workers over MQTT
+func install(args []string) error {
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	broker := flags.String("broker", MQTTBroker, "MQTT broker URL")
+	tenant := flags.String("tenant", "", "tenant of the workers")
+	workerID := flags.String("worker", "", "worker to install to, every worker of the tenant when empty")
+	signatureFile := flags.String("signature", "", "signature of the archive, <bundle>.minisig or <bundle>.sig by default")
+	timeout := flags.Duration("timeout", 2*time.Minute, "time to wait for the workers")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tinpotctl install [flags] <bundle.tgz|URL>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	archive, signature, err := loadBundle(flags.Arg(0), *signatureFile)
+	if err != nil {
+		return err
+	}
+	manifest, err := tinpot.ReadBundleManifest(archive)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	fmt.Printf("Installing %s %s (%s)\n", manifest.Name, manifest.Version, strings.Join(manifest.Actions, ", "))
+
+	opts := mqtt.NewClientOptions().AddBroker(*broker)
+	opts.SetClientID("tinpotctl-" + uuid.New().String())
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	defer client.Disconnect(250)
+
+	req := tinpot.MqttInstallRequest{ID: uuid.New().String(), Archive: archive, Signature: signature}
+	results := make(chan tinpot.MqttInstallResult, 16)
+	resultTopic := tinpot.WorkerInstallResultTopic(*tenant, "+")
+	if token := client.Subscribe(resultTopic, 1, func(c mqtt.Client, msg mqtt.Message) {
+		var result tinpot.MqttInstallResult
+		if json.Unmarshal(msg.Payload(), &result) == nil && result.ID == req.ID {
+			results <- result
+		}
+	}); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	topic := tinpot.InstallTopic(*tenant)
+	if *workerID != "" {
+		topic = tinpot.WorkerInstallTopic(*tenant, *workerID)
+	}
+	payload, _ := json.Marshal(req)
+	if token := client.Publish(topic, 1, false, payload); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	// A single worker answers once, the workers of a tenant until the timeout
+	deadline := time.After(*timeout)
+	installed, failed := 0, 0
+	for {
+		select {
+		case result := <-results:
+			if result.Error != "" {
+				failed++
+				fmt.Printf("%s: failed: %s\n", result.WorkerID, result.Error)
+			} else {
+				installed++
+				fmt.Printf("%s: installed %s\n", result.WorkerID, result.Bundle.Digest)
+			}
+			if *workerID != "" {
+				return installResult(installed, failed)
+			}
+		case <-deadline:
+			return installResult(installed, failed)
+		}
+	}
+}
+
+func installResult(installed, failed int) error {
+	switch {
+	case failed > 0:
+		return fmt.Errorf("failed on %d workers", failed)
+	case installed == 0:
+		return errors.New("no worker answered")
+	}
+	return nil
+}
+
+// loadBundle reads the archive and its signature from a file or URL. Without an explicit
+// signature file the .minisig and .sig next to the archive are used, if any.
+func loadBundle(location, signatureFile string) (archive, signature []byte, err error) {
+	if archive, err = readLocation(location); err != nil {
+		return nil, nil, err
+	}
+	if signatureFile != "" {
+		signature, err = readLocation(signatureFile)
+		return archive, signature, err
+	}
+	for _, ext := range []string{".minisig", ".sig"} {
+		if signature, err = readLocation(location + ext); err == nil {
+			return archive, signature, nil
+		}
+	}
+	return archive, nil, nil
+}
+
+func readLocation(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.ReadFile(location)
+	}
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
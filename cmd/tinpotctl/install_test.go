@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBundle(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ops.tgz"), []byte("archive"), 0644)
+	os.WriteFile(filepath.Join(dir, "ops.tgz.minisig"), []byte("minisig"), 0644)
+	archive, signature, err := loadBundle(filepath.Join(dir, "ops.tgz"), "")
+	if err != nil || string(archive) != "archive" || string(signature) != "minisig" {
+		t.Errorf("unexpected bundle: %q %q %v", archive, signature, err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+	os.WriteFile(filepath.Join(dir, "other.sig"), []byte("sig"), 0644)
+	archive, signature, err = loadBundle(server.URL+"/ops.tgz", filepath.Join(dir, "other.sig"))
+	if err != nil || string(archive) != "archive" || string(signature) != "sig" {
+		t.Errorf("unexpected bundle: %q %q %v", archive, signature, err)
+	}
+	if _, _, err := loadBundle(server.URL+"/missing.tgz", ""); err == nil {
+		t.Error("missing bundle loaded")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// janitor clears the retained result and log messages of executions. Coordinators clear the
+// results they receive, this catches what is left behind by crashes and older versions.
+func janitor(args []string) error {
+	flags := flag.NewFlagSet("janitor", flag.ExitOnError)
+	broker := flags.String("broker", MQTTBroker, "MQTT broker URL")
+	minAge := flags.Duration("min-age", 24*time.Hour, "keep messages younger than this")
+	settle := flags.Duration("settle", 2*time.Second, "time to wait for the retained messages")
+	dryRun := flags.Bool("dry-run", false, "only list the messages that would be purged")
+	flags.Parse(args)
+
+	opts := mqtt.NewClientOptions().AddBroker(*broker)
+	opts.SetClientID("tinpot-janitor-" + uuid.New().String())
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	defer client.Disconnect(250)
+
+	topics, err := staleTopics(client, time.Now().Add(-*minAge), *settle)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if *dryRun {
+			fmt.Println(topic)
+			continue
+		}
+		if token := client.Publish(topic, 1, true, []byte{}); token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to clear %s: %w", topic, token.Error())
+		}
+	}
+	log.Printf("%d retained execution messages older than %v", len(topics), *minAge)
+	return nil
+}
+
+// staleTopics collects the retained execution messages published before cutoff.
+// Messages without a timestamp are considered stale.
+func staleTopics(client mqtt.Client, cutoff time.Time, settle time.Duration) ([]string, error) {
+	var mu sync.Mutex
+	var topics []string
+	handler := func(c mqtt.Client, msg mqtt.Message) {
+		if !msg.Retained() || len(msg.Payload()) == 0 {
+			return
+		}
+		if published, ok := messageTime(msg.Topic(), msg.Payload()); ok && published.After(cutoff) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		topics = append(topics, msg.Topic())
+	}
+	// Both the shared and the worker topic layout
+	patterns := []string{
+		tinpot.TopicPrefix("") + "exec/#",
+		tinpot.TopicPrefix("+") + "exec/#",
+		tinpot.WorkerExecPrefix("", "+") + "#",
+		tinpot.WorkerExecPrefix("+", "+") + "#",
+	}
+	filters := make(map[string]byte)
+	for _, pattern := range patterns {
+		filters[pattern] = 1
+	}
+	if token := client.SubscribeMultiple(filters, handler); token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+	time.Sleep(settle)
+	client.Unsubscribe(patterns...).Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return topics, nil
+}
+
+// messageTime extracts when a result or log message was published
+func messageTime(topic string, payload []byte) (time.Time, bool) {
+	var timestamp string
+	payload, err := tinpot.DecompressPayload(payload)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case strings.HasSuffix(topic, "/result"):
+		var res tinpot.MqttResultResponse
+		if _, err := tinpot.UnmarshalMessage(payload, tinpot.MessageResult, &res); err != nil {
+			return time.Time{}, false
+		}
+		timestamp = res.CompletedAt
+	case strings.HasSuffix(topic, "/log"):
+		// A single entry or a batch, whose last entry is the latest
+		var entries []tinpot.MqttLogEntry
+		if _, err := tinpot.UnmarshalMessage(payload, tinpot.MessageLog, &entries); err != nil {
+			entries = make([]tinpot.MqttLogEntry, 1)
+			if _, err := tinpot.UnmarshalMessage(payload, tinpot.MessageLog, &entries[0]); err != nil {
+				return time.Time{}, false
+			}
+		}
+		if len(entries) == 0 {
+			return time.Time{}, false
+		}
+		timestamp = entries[len(entries)-1].Timestamp
+	}
+	t, err := time.Parse(time.RFC3339, timestamp)
+	return t, err == nil
+}
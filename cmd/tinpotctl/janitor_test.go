@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestStaleTopics(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	transport := tinpottest.Connect(t, broker)
+	result := func(completed time.Time) []byte {
+		payload, _ := json.Marshal(tinpot.MqttResultResponse{Status: "SUCCESS", CompletedAt: completed.Format(time.RFC3339)})
+		return payload
+	}
+	transport.Publish("tinpot/exec/old/result", 1, true, result(time.Now().Add(-48*time.Hour)))
+	transport.Publish("tinpot/exec/new/result", 1, true, result(time.Now()))
+	transport.Publish("tinpot/tenants/a/exec/legacy/result", 1, true, []byte(`{"status":"FAILURE"}`))
+	transport.Publish("tinpot/exec/running/log", 1, true, []byte(`{"timestamp":"`+time.Now().Format(time.RFC3339)+`"}`))
+
+	topics, err := staleTopics(transport.Client(), time.Now().Add(-24*time.Hour), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(topics)
+	expected := []string{"tinpot/exec/old/result", "tinpot/tenants/a/exec/legacy/result"}
+	if !reflect.DeepEqual(topics, expected) {
+		t.Errorf("expected %v, got %v", expected, topics)
+	}
+}
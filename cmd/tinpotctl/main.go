@@ -0,0 +1,50 @@
+// tinpotctl is the administration tool of a tinpot deployment
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Configuration
+var (
+	MQTTBroker = getEnv("MQTT_BROKER", "tcp://localhost:1883")
+)
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+var commands = map[string]func(args []string) error{
+	"install":     install,
+	"janitor":     janitor,
+	"signing-key": signingKey,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: tinpotctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  install       deploy an action bundle to the workers")
+	fmt.Fprintln(os.Stderr, "  janitor       purge old retained execution messages from the broker")
+	fmt.Fprintln(os.Stderr, "  signing-key   generate a REQUEST_SIGNING_KEY for the coordinator and the workers")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
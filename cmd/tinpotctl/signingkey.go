@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+)
+
+// signingKey prints a new REQUEST_SIGNING_KEY pair
+func signingKey(args []string) error {
+	flags := flag.NewFlagSet("signing-key", flag.ExitOnError)
+	kind := flags.String("type", "ed25519", "key type: ed25519 (workers only get the public key) or hmac (shared secret)")
+	flags.Parse(args)
+
+	encode := base64.StdEncoding.EncodeToString
+	switch *kind {
+	case "ed25519":
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("coordinator: REQUEST_SIGNING_KEY=ed25519:%s\n", encode(private))
+		fmt.Printf("worker:      REQUEST_SIGNING_KEY=ed25519:%s\n", encode(public))
+	case "hmac":
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		fmt.Printf("coordinator and worker: REQUEST_SIGNING_KEY=hmac:%s\n", encode(secret))
+	default:
+		return fmt.Errorf("unknown key type: %s", *kind)
+	}
+	return nil
+}
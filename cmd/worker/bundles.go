@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+const (
+	BundleManifestFile = tinpot.BundleManifestFile
+
+	// IsolationShared loads the bundle into the embedded interpreter (default)
+	IsolationShared = "shared"
+	// IsolationSubprocess runs each execution in a separate interpreter process
+	IsolationSubprocess = "subprocess"
+)
+
+// BundleManifest describes a directory of actions under ACTIONS_DIR.
+// It is read from <bundle>/bundle.json, the shared format with the worker specific settings.
+type BundleManifest struct {
+	tinpot.BundleManifest
+	// Venv is the virtual environment used to run the bundle, relative to the bundle directory or absolute
+	Venv string `json:"venv"`
+	// Python overrides the interpreter executable, takes precedence over Venv
+	Python    string `json:"python"`
+	Isolation string `json:"isolation"`
+
+	Dir string `json:"-"`
+	// Sandbox is the default profile of the actions of an isolated bundle, also applied when
+	// listing them
+	Sandbox *tinpot.SandboxProfile `json:"sandbox"`
+	// RunAs is the default user of the actions of an isolated bundle, also listing them
+	RunAs string `json:"run_as"`
+
+	// Signed is the verified archive the bundle was extracted from, see BUNDLE_KEYS
+	Signed *tinpot.BundleInfo `json:"-"`
+}
+
+func (b *BundleManifest) Isolated() bool {
+	return b.Isolation == IsolationSubprocess
+}
+
+// Interpreter returns the python executable the bundle should be run with
+func (b *BundleManifest) Interpreter() string {
+	if b.Python != "" {
+		return b.Python
+	}
+	if b.Venv != "" {
+		venv := b.Venv
+		if !filepath.IsAbs(venv) {
+			venv = filepath.Join(b.Dir, venv)
+		}
+		return filepath.Join(venv, venvPython)
+	}
+	return defaultPython
+}
+
+func loadBundleManifest(dir string) (*BundleManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BundleManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", BundleManifestFile, err)
+	}
+	manifest.Dir = dir
+	manifest.Signed = signedDirs[dir]
+	if manifest.Name == "" {
+		manifest.Name = filepath.Base(dir)
+	}
+	if manifest.Venv == "" && manifest.Python == "" && len(manifest.Dependencies) > 0 {
+		// Created by the installer, see prepareBundle
+		manifest.Venv = bundleVenv
+	}
+	if manifest.Isolation == "" {
+		if manifest.Venv != "" || manifest.Python != "" {
+			manifest.Isolation = IsolationSubprocess
+		} else {
+			manifest.Isolation = IsolationShared
+		}
+	}
+	switch manifest.Isolation {
+	case IsolationShared, IsolationSubprocess:
+	default:
+		return nil, fmt.Errorf("unsupported isolation %q", manifest.Isolation)
+	}
+	if manifest.RunAs != "" && !manifest.Isolated() {
+		return nil, fmt.Errorf("run_as needs the %s isolation", IsolationSubprocess)
+	}
+	if manifest.Sandbox != nil {
+		if !manifest.Isolated() {
+			return nil, fmt.Errorf("sandbox profiles need the %s isolation", IsolationSubprocess)
+		}
+		if err := manifest.Sandbox.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, nil
+}
+
+// discoverBundles finds the action bundles declared by a manifest in the direct subdirectories of dir
+func discoverBundles(dir string) ([]*BundleManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundles []*BundleManifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		bundleDir, _ := filepath.Abs(filepath.Join(dir, entry.Name()))
+		if _, err := os.Stat(filepath.Join(bundleDir, BundleManifestFile)); err != nil {
+			continue
+		}
+		manifest, err := loadBundleManifest(bundleDir)
+		if err != nil {
+			return nil, fmt.Errorf("bundle %s: %w", entry.Name(), err)
+		}
+		bundles = append(bundles, manifest)
+	}
+	return bundles, nil
+}
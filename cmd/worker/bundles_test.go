@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func writeManifest(t *testing.T, dir, name, manifest string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name, BundleManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverBundles(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, dir, "reports", `{"venv": ".venv"}`)
+	writeManifest(t, dir, "legacy", `{"name": "old", "python": "/usr/bin/python3.8", "isolation": "subprocess"}`)
+	writeManifest(t, dir, "shared", `{}`)
+	// Directories without a manifest are plain action modules
+	os.MkdirAll(filepath.Join(dir, "plain"), 0755)
+
+	bundles, err := discoverBundles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*BundleManifest)
+	for _, b := range bundles {
+		byName[b.Name] = b
+	}
+	if len(bundles) != 3 {
+		t.Fatalf("unexpected bundles: %v", byName)
+	}
+	if b := byName["reports"]; b == nil || !b.Isolated() || b.Interpreter() != filepath.Join(dir, "reports", ".venv", venvPython) {
+		t.Errorf("venv bundle not isolated with its interpreter: %+v", b)
+	}
+	if b := byName["old"]; b == nil || !b.Isolated() || b.Interpreter() != "/usr/bin/python3.8" {
+		t.Errorf("python override not used: %+v", b)
+	}
+	if b := byName["shared"]; b == nil || b.Isolated() || b.Interpreter() != defaultPython {
+		t.Errorf("bundle without an environment isolated: %+v", b)
+	}
+}
+
+func TestInvalidBundleManifests(t *testing.T) {
+	for manifest, expected := range map[string]string{
+		`{"isolation": "container"}`:                       "unsupported isolation",
+		`{"isolation": "shared", "run_as": "nobody"}`:      "run_as needs",
+		`{"sandbox": {"network": false}}`:                  "sandbox profiles need",
+		`{"venv": ".venv", "isolation": "subinterpreter"}`: "unsupported isolation",
+		`{"venv": `: "invalid " + BundleManifestFile,
+	} {
+		dir := t.TempDir()
+		writeManifest(t, dir, "broken", manifest)
+		if _, err := discoverBundles(dir); err == nil || !strings.Contains(err.Error(), expected) || !strings.Contains(err.Error(), "bundle broken") {
+			t.Errorf("%s: expected %q, got %v", manifest, expected, err)
+		}
+	}
+}
+
+func TestMultiActionManager(t *testing.T) {
+	first := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "report", Description: "first"}, tinpottest.Succeed(map[string]interface{}{"from": "first"}))
+	second := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "report", Description: "second"}, tinpottest.Succeed(map[string]interface{}{"from": "second"})).
+		Add(tinpot.ActionInfo{Name: "cleanup"}, tinpottest.Succeed(nil))
+	mgr := NewMultiActionManager(first, second)
+
+	// The first manager providing an action wins, for the listing and the trigger alike
+	actions := mgr.ListActions()
+	if len(actions) != 2 || actions["report"].Description != "first" {
+		t.Errorf("unexpected actions: %v", actions)
+	}
+	var result map[string]interface{}
+	mgr.GetAction("report")(nil, func(err string, res map[string]interface{}) { result = res }, nil)
+	if result["from"] != "first" {
+		t.Errorf("unexpected trigger used: %v", result)
+	}
+	if mgr.GetAction("cleanup") == nil || mgr.GetAction("missing") != nil {
+		t.Errorf("actions not resolved across the managers")
+	}
+
+	second.SetConnected(false)
+	if mgr.IsConnected() {
+		t.Errorf("connected with a disconnected manager")
+	}
+}
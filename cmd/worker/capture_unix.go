@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultPython is the interpreter of bundles not naming one
+const defaultPython = "python3"
+
+// venvPython is the interpreter within a virtual environment
+var venvPython = filepath.Join("bin", "python")
+
+// captureFile returns the Python expression opening the write end of the log pipe
+func captureFile(w *os.File) (string, error) {
+	return fmt.Sprintf(`os.fdopen(%d, "w", buffering=1, encoding="utf-8", closefd=False)`, w.Fd()), nil
+}
+
+// terminate asks a bundle interpreter to stop, cancelling its async actions
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
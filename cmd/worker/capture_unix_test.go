@@ -0,0 +1,53 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPlatformInterpreter(t *testing.T) {
+	if defaultPython != "python3" || venvPython != filepath.Join("bin", "python") {
+		t.Errorf("unexpected interpreters: %s, %s", defaultPython, venvPython)
+	}
+}
+
+func TestCaptureFile(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	file, err := captureFile(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The interpreter shares the descriptor with the worker, it must not close it
+	if !strings.Contains(file, "os.fdopen("+strconv.Itoa(int(w.Fd()))+",") || !strings.Contains(file, "closefd=False") {
+		t.Errorf("unexpected capture file: %s", file)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("no sleep command")
+	}
+	start := time.Now()
+	if err := terminate(cmd.Process); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	// SIGTERM, so the bundle runner can cancel its async actions
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM || time.Since(start) > 5*time.Second {
+		t.Errorf("process not terminated by SIGTERM: %v", cmd.ProcessState)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultPython is the interpreter of bundles not naming one
+const defaultPython = "python"
+
+// venvPython is the interpreter within a virtual environment
+var venvPython = filepath.Join("Scripts", "python.exe")
+
+// captureFile returns the Python expression opening the write end of the log pipe.
+// Python files need C runtime descriptors, not handles: a duplicate of the handle is
+// converted with msvcrt and owned (closed) by the Python file.
+func captureFile(w *os.File) (string, error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return "", err
+	}
+	var dup syscall.Handle
+	if err := syscall.DuplicateHandle(process, syscall.Handle(w.Fd()), process, &dup, 0, false, syscall.DUPLICATE_SAME_ACCESS); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`os.fdopen(__import__("msvcrt").open_osfhandle(%d, os.O_WRONLY), "w", buffering=1, encoding="utf-8", newline="\n")`, dup), nil
+}
+
+// terminate stops a bundle interpreter. Windows has no SIGTERM, so the process is killed
+// and async actions get no chance to clean up.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
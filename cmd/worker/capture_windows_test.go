@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPlatformInterpreter(t *testing.T) {
+	if defaultPython != "python" || venvPython != filepath.Join("Scripts", "python.exe") {
+		t.Errorf("unexpected interpreters: %s, %s", defaultPython, venvPython)
+	}
+}
+
+func TestCaptureFile(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	file, err := captureFile(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The interpreter gets a duplicate of the handle, converted to a C runtime descriptor
+	match := regexp.MustCompile(`open_osfhandle\((\d+), os\.O_WRONLY\)`).FindStringSubmatch(file)
+	if match == nil {
+		t.Fatalf("unexpected capture file: %s", file)
+	}
+	handle, _ := strconv.ParseUint(match[1], 10, 64)
+	if syscall.Handle(handle) == syscall.Handle(w.Fd()) {
+		t.Errorf("the handle of the pipe is not duplicated")
+	}
+	// The duplicate writes to the same pipe
+	dup := os.NewFile(uintptr(handle), "dup")
+	if _, err := dup.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	dup.Close()
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil || buf[0] != 'x' {
+		t.Errorf("duplicate not writing to the pipe: %v", err)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	cmd := exec.Command("ping", "-n", "10", "127.0.0.1")
+	if err := cmd.Start(); err != nil {
+		t.Skip("no ping command")
+	}
+	start := time.Now()
+	if err := terminate(cmd.Process); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	if !cmd.ProcessState.Exited() || cmd.ProcessState.Success() || time.Since(start) > 5*time.Second {
+		t.Errorf("process not killed: %v", cmd.ProcessState)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+)
+
+// devRunResponse is the reply of POST /run/{name} in dev mode
+type devRunResponse struct {
+	Status   string                 `json:"status"`
+	Result   map[string]interface{} `json:"result,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Logs     []tinpot.MqttLogEntry  `json:"logs"`
+	Duration string                 `json:"duration"`
+}
+
+// devHandler serves the loaded actions over HTTP without a broker: the regular /api/
+// endpoints, and POST /run/{name} taking the parameters as body and replying with
+// the logs and the result at once.
+func devHandler(mgr tinpot.ActionManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", server.NewServer(mgr, nil, server.Options{}))
+	mux.HandleFunc("POST /run/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		trigger := mgr.GetAction(name)
+		if trigger == nil {
+			writeDevJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", name)})
+			return
+		}
+		params := make(map[string]interface{})
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+				writeDevJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+				return
+			}
+		}
+
+		var mu sync.Mutex
+		resp := devRunResponse{Logs: []tinpot.MqttLogEntry{}}
+		done := make(chan struct{})
+		start := time.Now()
+		go trigger(params, func(errMsg string, result map[string]interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Result, resp.Error = result, errMsg
+			close(done)
+		}, func(level, message string) {
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Logs = append(resp.Logs, tinpot.MqttLogEntry{Timestamp: time.Now().Format(time.RFC3339Nano), Level: level, Message: message})
+		})
+
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		resp.Status = tinpot.StatusSuccess
+		if resp.Error != "" {
+			resp.Status = tinpot.StatusFailure
+		}
+		resp.Duration = time.Since(start).String()
+		writeDevJSON(w, 200, resp)
+	})
+	return mux
+}
+
+func writeDevJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitRepo keeps a directory checked out at the latest commit of a branch, with the git command
+type gitRepo struct {
+	URL    string
+	Branch string
+	Dir    string
+	// SSHKey is the private key file for ssh URLs, optional
+	SSHKey string
+	// Verify requires the commits to carry a valid signature (git verify-commit), checked
+	// against AllowedSigners for ssh signatures or the gpg keyring otherwise
+	Verify         bool
+	AllowedSigners string
+
+	mu sync.Mutex
+}
+
+func (g *gitRepo) git(args ...string) (string, error) {
+	if g.AllowedSigners != "" {
+		args = append([]string{"-c", "gpg.ssh.allowedSignersFile=" + g.AllowedSigners}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if g.SSHKey != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %q -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", g.SSHKey))
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Sync clones the repository or fetches the branch, and checks out its latest commit if it
+// differs from the current one. It returns the commit and whether it changed.
+func (g *gitRepo) Sync() (commit string, changed bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := os.Stat(filepath.Join(g.Dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(g.Dir, 0755); err != nil {
+			return "", false, err
+		}
+		// Nothing is checked out before the signature is verified
+		if _, err := g.git("clone", "--no-checkout", "--branch", g.Branch, g.URL, "."); err != nil {
+			return "", false, err
+		}
+		changed = true
+	} else if _, err := g.git("fetch", "origin", g.Branch); err != nil {
+		return "", false, err
+	}
+	if commit, err = g.git("rev-parse", "origin/"+g.Branch+"^{commit}"); err != nil {
+		return "", false, err
+	}
+	if g.Verify {
+		if _, err := g.git("verify-commit", commit); err != nil {
+			return "", false, fmt.Errorf("commit %s is not signed by a trusted key: %w", commit, err)
+		}
+	}
+	if head, err := g.git("rev-parse", "HEAD"); !changed && err == nil && head == commit {
+		return commit, false, nil
+	}
+	if _, err := g.git("reset", "--hard", commit); err != nil {
+		return "", false, err
+	}
+	return commit, true, nil
+}
+
+// Head returns the commit checked out
+func (g *gitRepo) Head() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.git("rev-parse", "HEAD")
+}
+
+// Follows tells whether a pushed ref is the branch of the repository, every ref matches when empty
+func (g *gitRepo) Follows(ref string) bool {
+	return ref == "" || ref == "refs/heads/"+g.Branch
+}
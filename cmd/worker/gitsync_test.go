@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitSync(t *testing.T) {
+	origin := t.TempDir()
+	commit := func(name string) {
+		t.Helper()
+		os.WriteFile(filepath.Join(origin, name), []byte("# "+name), 0644)
+		for _, args := range [][]string{{"add", "."}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", name}} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = origin
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v: %s", args, err, out)
+			}
+		}
+	}
+	if out, err := exec.Command("git", "init", "-q", "-b", "main", origin).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	commit("first.py")
+
+	repo := &gitRepo{URL: origin, Branch: "main", Dir: filepath.Join(t.TempDir(), "actions")}
+	first, changed, err := repo.Sync()
+	if err != nil || !changed {
+		t.Fatalf("clone failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Dir, "first.py")); err != nil {
+		t.Errorf("not checked out: %v", err)
+	}
+	if _, changed, err := repo.Sync(); err != nil || changed {
+		t.Errorf("unchanged repository updated: %v", err)
+	}
+
+	commit("second.py")
+	second, changed, err := repo.Sync()
+	if err != nil || !changed || second == first {
+		t.Fatalf("pull failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Dir, "second.py")); err != nil {
+		t.Errorf("not checked out: %v", err)
+	}
+
+	repo.Verify = true
+	if _, _, err := repo.Sync(); err == nil {
+		t.Error("unsigned commit accepted")
+	}
+	if !repo.Follows("refs/heads/main") || repo.Follows("refs/heads/dev") || !repo.Follows("") {
+		t.Error("unexpected ref matching")
+	}
+}
@@ -1,20 +1,44 @@
-module github.com/balazsgrill/tinpot/worker
+module github.com/balazsgrill/tinpot/cmd/worker
 
 go 1.25.5
 
 require (
+	github.com/balazsgrill/tinpot v0.0.0-20260112114307-6f6f6f6f6f6f
 	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/google/uuid v1.6.0
 	go.nhat.io/cpy/v3 v3.12.0 // version is intentional to match python version compatibility
 	go.nhat.io/python/v3 v3.12.0 // version is intentional to match python version compatibility
-	github.com/balazsgrill/tinpot v0.0.0-20260112114307-6f6f6f6f6f6f
 )
 
 replace github.com/balazsgrill/tinpot => ../../tinpot
 
 require (
+	github.com/eclipse/paho.golang v0.23.0
+	golang.org/x/sys v0.36.0
+)
+
+require (
+	cel.dev/expr v0.24.0 // indirect
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/fxamacker/cbor/v2 v2.9.4 // indirect
+	github.com/google/cel-go v0.26.1 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/mochi-mqtt/server/v2 v2.7.9 // indirect
+	github.com/robfig/cron/v3 v3.0.1 // indirect
+	github.com/rs/xid v1.4.0 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	github.com/teambition/rrule-go v1.8.2 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	go.nhat.io/once v0.3.0 // indirect
+	golang.org/x/crypto v0.42.0 // indirect
+	golang.org/x/exp v0.0.0-20230626212559-97b1e661b5df // indirect
 	golang.org/x/net v0.44.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
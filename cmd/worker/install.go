@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// bundleVenv is the virtual environment created for the dependencies of a bundle
+const bundleVenv = ".venv"
+
+// installBundle deploys the bundle of an install command to dir. With keys the archive is
+// verified and stored with its signature, to be extracted on startup like the other signed
+// bundles; otherwise it is extracted to the directory of the bundle, replacing the previous version.
+func installBundle(dir string, keys *tinpot.BundleKeys, req *tinpot.MqttInstallRequest) (*tinpot.BundleInfo, error) {
+	shared, err := tinpot.ReadBundleManifest(req.Archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := shared.Validate(); err != nil {
+		return nil, err
+	}
+	info := &tinpot.BundleInfo{Name: shared.Name, Version: shared.Version, Digest: tinpot.ArchiveDigest(req.Archive)}
+
+	if keys != nil {
+		verify, signatureExt := keys.VerifySignature, ".sig"
+		if bytes.HasPrefix(req.Signature, []byte("untrusted comment:")) {
+			verify, signatureExt = keys.VerifyMinisign, ".minisig"
+		}
+		if len(req.Signature) == 0 {
+			return nil, fmt.Errorf("bundle %s is not signed", shared.Name)
+		}
+		if info.KeyID, err = verify(req.Archive, req.Signature); err != nil {
+			return nil, err
+		}
+		if err := checkRuntime(defaultPython, shared); err != nil {
+			return nil, err
+		}
+		base := filepath.Join(dir, shared.Name)
+		for _, ext := range bundleArchiveExtensions {
+			os.Remove(base + ext + ".minisig")
+			os.Remove(base + ext + ".sig")
+			os.Remove(base + ext)
+		}
+		if err := os.WriteFile(base+".tar.gz"+signatureExt, req.Signature, 0644); err != nil {
+			return nil, err
+		}
+		return info, os.WriteFile(base+".tar.gz", req.Archive, 0644)
+	}
+
+	staging, err := os.MkdirTemp(dir, "."+shared.Name+"-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	if err := extractTar(req.Archive, staging); err != nil {
+		return nil, err
+	}
+	manifest, err := loadBundleManifest(staging)
+	if err != nil {
+		return nil, err
+	}
+	python := manifest.Interpreter()
+	if _, err := os.Stat(python); err != nil && manifest.Venv == bundleVenv {
+		python = defaultPython
+	}
+	if err := checkRuntime(python, shared); err != nil {
+		return nil, err
+	}
+	if err := prepareBundle(staging, manifest); err != nil {
+		return nil, err
+	}
+	target := filepath.Join(dir, shared.Name)
+	if err := os.RemoveAll(target); err != nil {
+		return nil, err
+	}
+	return info, os.Rename(staging, target)
+}
+
+// prepareBundle creates the virtual environment of a bundle with dependencies, unless it
+// configures its own interpreter
+func prepareBundle(dir string, manifest *BundleManifest) error {
+	venv := filepath.Join(dir, bundleVenv)
+	if len(manifest.Dependencies) == 0 || manifest.Venv != bundleVenv || manifest.Python != "" {
+		return nil
+	}
+	if _, err := os.Stat(venv); err == nil {
+		return nil
+	}
+	if out, err := exec.Command(defaultPython, "-m", "venv", venv).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create the virtual environment: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	args := append([]string{"-m", "pip", "install", "--quiet", "--disable-pip-version-check"}, manifest.Dependencies...)
+	if out, err := exec.Command(filepath.Join(venv, venvPython), args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to install the dependencies: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checkRuntime tells whether the interpreter satisfies the runtime of the bundle
+func checkRuntime(python string, manifest *tinpot.BundleManifest) error {
+	major, minor, err := manifest.PythonVersion()
+	if err != nil || major == 0 {
+		return err
+	}
+	out, err := exec.Command(python, "-c", "import sys; print('%d.%d' % sys.version_info[:2])").Output()
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", python, err)
+	}
+	var haveMajor, haveMinor int
+	if _, err := fmt.Sscanf(string(out), "%d.%d", &haveMajor, &haveMinor); err != nil {
+		return fmt.Errorf("unexpected version of %s: %s", python, out)
+	}
+	if haveMajor < major || haveMajor == major && haveMinor < minor {
+		return fmt.Errorf("bundle %s needs %s, %s is %d.%d", manifest.Name, manifest.Runtime, python, haveMajor, haveMinor)
+	}
+	return nil
+}
+
+// missingActions reports the actions declared by the bundles that were not loaded
+func missingActions(bundles []*BundleManifest, mgr tinpot.ActionManager) []tinpot.MqttLoadError {
+	var missing []tinpot.MqttLoadError
+	loaded := mgr.ListActions()
+	for _, bundle := range bundles {
+		for _, name := range bundle.Actions {
+			if _, ok := loaded[name]; !ok {
+				missing = append(missing, tinpot.MqttLoadError{Module: bundle.Name, Path: bundle.Dir, Error: fmt.Sprintf("declared action %s not found", name)})
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+func tarball(files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for file, content := range files {
+		tw.WriteHeader(&tar.Header{Name: file, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestInstallBundle(t *testing.T) {
+	dir := t.TempDir()
+	v1 := tarball(map[string]string{"bundle.json": `{"name": "ops", "version": "1"}`, "old_action.py": "# action"})
+	v2 := tarball(map[string]string{"bundle.json": `{"name": "ops", "version": "2", "runtime": "python>=3"}`, "new_action.py": "# action"})
+	for _, archive := range [][]byte{v1, v2} {
+		if _, err := installBundle(dir, nil, &tinpot.MqttInstallRequest{Archive: archive}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ops", "new_action.py")); err != nil {
+		t.Errorf("bundle not installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ops", "old_action.py")); err == nil {
+		t.Error("previous version not replaced")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("staging directory left behind: %v", entries)
+	}
+	for _, invalid := range []map[string]string{
+		{"action.py": "# no manifest"},
+		{"bundle.json": `{"name": "../ops"}`},
+		{"bundle.json": `{"name": "ops", "runtime": "python>=99"}`},
+	} {
+		if _, err := installBundle(dir, nil, &tinpot.MqttInstallRequest{Archive: tarball(invalid)}); err == nil {
+			t.Errorf("invalid bundle installed: %v", invalid)
+		}
+	}
+
+	// Verifying workers keep the signed archive
+	public, private, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	keys, _ := tinpot.ParseBundleKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if _, err := installBundle(dir, keys, &tinpot.MqttInstallRequest{Archive: v2}); err == nil {
+		t.Error("unsigned bundle installed")
+	}
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, v2)))
+	info, err := installBundle(dir, keys, &tinpot.MqttInstallRequest{Archive: v2, Signature: signature})
+	if err != nil || info.KeyID == "" || info.Version != "2" {
+		t.Fatalf("signed bundle not installed: %+v %v", info, err)
+	}
+	if archive, _ := os.ReadFile(filepath.Join(dir, "ops.tar.gz")); !bytes.Equal(archive, v2) {
+		t.Error("archive not stored")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ops.tar.gz.sig")); err != nil {
+		t.Errorf("signature not stored: %v", err)
+	}
+}
@@ -1,3 +1,4 @@
-from .decorators import action, action_print
+from .context import scratch_dir
+from .decorators import action, action_print, action_progress
 from .loader import discover_actions
 from .utils import run_command
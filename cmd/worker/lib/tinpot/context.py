@@ -0,0 +1,23 @@
+"""
+Context of the execution an action runs in, set up by the worker.
+"""
+import os
+import threading
+from typing import Optional
+
+_local = threading.local()
+
+
+def scratch_dir() -> Optional[str]:
+    """
+    The scratch directory of the running execution, or None if the worker has no SCRATCH_DIR.
+
+    The directory is empty when the action starts. It is removed once the execution succeeds,
+    and kept for SCRATCH_RETENTION after a failure, to be downloaded from the coordinator.
+    """
+    return getattr(_local, "scratch_dir", None) or os.environ.get("TINPOT_SCRATCH_DIR") or None
+
+
+def _set_scratch_dir(path: str):
+    """Called by the embedded runtime around each action, on the thread running it."""
+    _local.scratch_dir = path or None
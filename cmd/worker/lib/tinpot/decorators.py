@@ -1,6 +1,12 @@
+import asyncio
+import functools
 import inspect
+import json
 import sys
-from typing import Any, Callable, Dict, Optional, get_type_hints
+import threading
+from typing import Any, Callable, Dict, List, Optional, get_type_hints
+
+from .schema import coerce, describe
 
 # Global registry for discovered actions
 ACTION_REGISTRY: Dict[str, Dict[str, Any]] = {}
@@ -11,45 +17,154 @@ def action(
     group: Optional[str] = "General",
     description: Optional[str] = None,
     queue: str = "default", 
+    docs: Optional[str] = None,
+    examples: Optional[List[Dict[str, Any]]] = None,
+    ui: Optional[Dict[str, Dict[str, Any]]] = None,
+    translations: Optional[Dict[str, Dict[str, Any]]] = None,
+    tests: Optional[List[Dict[str, Any]]] = None,
+    sandbox: Optional[Dict[str, Any]] = None,
+    run_as: Optional[str] = None,
+    selector: Optional[str] = None,
+    debounce: Optional[float] = None,
 ):
     """
     Decorator to mark a function as a Tinpot action.
+
+    docs is a long-form markdown help text (defaults to the docstring),
+    examples is a list of {"title": ..., "description": ..., "parameters": {...}}
+    sample invocations, optionally with the "result" they return, which the
+    coordinator answers with in mock mode.
+    ui maps parameter names to form hints: widget, title, placeholder, help,
+    order, group, choices, choices_from ({"action": "list_services"}, see
+    below) and visible_if ({"other_param": value}).
+    translations maps locales to {"description": ..., "docs": ...,
+    "parameters": {"param": "label"}}.
+    Parameter types come from the type hints: Optional, Literal and Enum
+    values, typed lists, dataclasses and pydantic models are described in
+    full and converted back from JSON when the action is called.
+    async def actions run in an asyncio event loop of their own, see
+    cancel_running.
+    tests are self-tests run by `worker -test`: {"title": ..., "parameters":
+    {...}, "expect": {subset of the result}} or "error": "expected error text".
+    sandbox confines actions of isolated bundles on Linux: {"no_network": True,
+    "read_only": ["/etc"], "seccomp": "default", "apparmor": "profile"}.
+    run_as is the user the worker runs actions of isolated bundles as.
+    selector picks the workers that may run the action by their labels, like
+    "os=linux,gpu" (see WORKER_LABELS).
+    debounce coalesces identical requests (same parameters) within that many
+    seconds into one execution whose result all callers share.
     """
     def decorator(func: Callable):
         # Extract metadata
         action_name = name or func.__name__
         action_desc = description or func.__doc__ or ""
+        action_docs = docs or inspect.getdoc(func) or ""
         
         # Get function signature for form generation
         sig = inspect.signature(func)
         type_hints = get_type_hints(func)
         
         parameters = {}
-        for param_name, param in sig.parameters.items():
+        for position, (param_name, param) in enumerate(sig.parameters.items()):
             param_type = type_hints.get(param_name, str)
             param_default = param.default if param.default != inspect.Parameter.empty else None
             
             parameters[param_name] = {
-                "type": param_type.__name__ if hasattr(param_type, '__name__') else str(param_type),
-                "default": param_default,
-                "required": param.default == inspect.Parameter.empty
+                **describe(param_type, param_default, param.default == inspect.Parameter.empty),
+                "ui": {"order": position, **(ui or {}).get(param_name, {})},
             }
+
+        @functools.wraps(func)
+        def call(**kwargs):
+            # Parameters arrive as JSON, rebuild the annotated types (dataclasses, models, enums)
+            result = func(**{k: coerce(type_hints[k], v) if k in type_hints else v for k, v in kwargs.items()})
+            if inspect.iscoroutine(result):
+                return _run_coroutine(result)
+            return result
         
         # Store metadata in registry
         ACTION_REGISTRY[action_name] = {
             "name": action_name,
             "group": group,
             "description": action_desc.strip(),
-            "function": func,
+            "function": call,
             "parameters": parameters,
+            "docs": action_docs,
+            "examples": [_normalize_example(e) for e in (examples or [])],
+            "translations": translations or {},
+            "tests": list(tests or []),
             "module": func.__module__,
             "queue": queue,
+            "sandbox": sandbox,
+            "run_as": run_as,
+            "selector": selector,
+            "debounce": debounce,
         }
         
         return func
     
     return decorator
 
+# Event loops of the running async actions, see cancel_running
+_RUNNING_LOCK = threading.Lock()
+_RUNNING: Dict[asyncio.AbstractEventLoop, "asyncio.Task"] = {}
+
+
+def _run_coroutine(coro):
+    """Runs an async action in its own event loop on the calling thread."""
+    loop = asyncio.new_event_loop()
+    try:
+        task = loop.create_task(coro)
+        with _RUNNING_LOCK:
+            _RUNNING[loop] = task
+        try:
+            return loop.run_until_complete(task)
+        finally:
+            with _RUNNING_LOCK:
+                del _RUNNING[loop]
+            loop.run_until_complete(loop.shutdown_asyncgens())
+    finally:
+        loop.close()
+
+
+def cancel_running() -> int:
+    """
+    Cancels the running async actions (e.g. when the worker shuts down): they
+    get a CancelledError at their current await, so finally blocks and async
+    context managers clean up. Safe to call from any thread or a signal handler.
+    Returns the number of cancelled actions.
+    """
+    with _RUNNING_LOCK:
+        running = list(_RUNNING.items())
+    for loop, task in running:
+        loop.call_soon_threadsafe(task.cancel)
+    return len(running)
+
+
+def _normalize_example(example: Dict[str, Any]) -> Dict[str, Any]:
+    if "parameters" not in example:
+        # Plain parameter dict
+        return {"title": "", "parameters": dict(example)}
+    normalized = {
+        "title": example.get("title", ""),
+        "description": example.get("description", ""),
+        "parameters": example["parameters"],
+    }
+    if "result" in example:
+        normalized["result"] = example["result"]
+    return normalized
+
+def action_progress(current: float, total: Optional[float] = None, message: str = ""):
+    """
+    Report execution progress (e.g. action_progress(3, 10, "Copying files")).
+    Emitted as a progress event instead of a log line.
+    """
+    payload = {"current": current, "message": message}
+    if total is not None:
+        payload["total"] = total
+    print("##tinpot[progress] " + json.dumps(payload))
+    sys.stdout.flush()
+
 def action_print(*args, **kwargs):
     """
     Helper to print messages that will be captured as logs.
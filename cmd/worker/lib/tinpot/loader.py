@@ -2,27 +2,48 @@ import importlib
 import os
 import sys
 import pkgutil
+import traceback
 
-def discover_actions(directory: str):
+# Modules that failed to load in the last discover_actions call:
+# {"module", "path", "error", "traceback"}
+LOAD_ERRORS = []
+
+def discover_actions(directory: str, exclude=None):
     """
     Recursively find and import Python modules in the given directory
     to trigger the @action decorators.
+
+    Directories listed in exclude (e.g. isolated action bundles running in
+    their own interpreter) are skipped.
     """
     directory = os.path.abspath(directory)
     if directory not in sys.path:
         sys.path.append(directory)
 
+    excluded = {os.path.abspath(d) for d in (exclude or [])}
+    LOAD_ERRORS.clear()
+
     # Walk directory
     for root, dirs, files in os.walk(directory):
+        dirs[:] = [d for d in dirs if os.path.join(root, d) not in excluded]
+        # Modules of subdirectories (bundles) are imported by their name too
+        if root not in sys.path and any(f.endswith(".py") for f in files):
+            sys.path.append(root)
         for file in files:
             if file.endswith(".py") and not file.startswith("__"):
                 module_name = file[:-3]
-                
+
                 # Calculate relative path for package import if needed
                 # But typically we just add directory to sys.path and import module
                 # If actions are flat or simple packages.
-                
+
                 try:
                     importlib.import_module(module_name)
-                except Exception as e:
+                except (Exception, SystemExit) as e:
                     print(f"WARNING: Failed to load action module '{module_name}': {e}", file=sys.stderr)
+                    LOAD_ERRORS.append({
+                        "module": module_name,
+                        "path": os.path.join(root, file),
+                        "error": f"{type(e).__name__}: {e}",
+                        "traceback": traceback.format_exc(),
+                    })
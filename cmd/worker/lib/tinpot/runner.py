@@ -0,0 +1,107 @@
+"""
+Entry point used by the Go worker to run the actions of an isolated bundle
+in a separate interpreter (e.g. the bundle's own virtual environment).
+
+    python -m tinpot.runner list <bundle_dir>   ({"actions": [...], "load_errors": [...]})
+    python -m tinpot.runner run <bundle_dir> <action>   (parameters as JSON on stdin)
+
+Stdout is reserved for the single JSON reply; everything the action prints
+is redirected to stderr, which the worker streams as logs.
+"""
+import asyncio
+import json
+import signal
+import sys
+import traceback
+
+from . import sandbox
+from .decorators import ACTION_REGISTRY, cancel_running
+from .loader import LOAD_ERRORS, discover_actions
+
+# Whether the interpreter runs in a sandbox, see tinpot.sandbox
+SANDBOXED = False
+
+
+def _describe():
+    actions = []
+    for name, info in ACTION_REGISTRY.items():
+        actions.append({
+            "name": name,
+            "group": info["group"],
+            "description": info["description"],
+            "parameters": info["parameters"],
+            "docs": info["docs"],
+            "examples": info["examples"],
+            "translations": info["translations"],
+            "tests": info["tests"],
+            "sandbox": info["sandbox"],
+            "run_as": info["run_as"],
+            "selector": info.get("selector"),
+            "debounce": info.get("debounce"),
+        })
+    return {"actions": actions, "load_errors": LOAD_ERRORS}
+
+
+def _terminate(signum, frame):
+    # The worker terminates the interpreter to cancel the action: async
+    # actions get a CancelledError, synchronous ones are stopped
+    if not cancel_running():
+        sys.exit(128 + signum)
+
+
+def _run(name: str):
+    info = ACTION_REGISTRY.get(name)
+    if info is None:
+        return {"error": f"Action not found: {name}"}
+
+    params = json.load(sys.stdin) or {}
+    signal.signal(signal.SIGTERM, _terminate)
+    try:
+        result = info["function"](**params)
+    except (Exception, asyncio.CancelledError) as e:
+        traceback.print_exc()
+        if SANDBOXED and sandbox.denied(e):
+            return {"error": f"{sandbox.SANDBOX_DENIED}: {type(e).__name__}: {e}"}
+        return {"error": f"{type(e).__name__}: {e}"}
+    return {"result": result}
+
+
+def main(argv):
+    if len(argv) < 3:
+        print(__doc__, file=sys.stderr)
+        return 2
+
+    command, directory = argv[1], argv[2]
+    out = sys.stdout
+    sys.stdout = sys.stderr
+
+    global SANDBOXED
+    try:
+        SANDBOXED = sandbox.apply()
+    except OSError as e:
+        _reply(out, {"error": f"Failed to set up the sandbox: {e}"})
+        return 1
+
+    discover_actions(directory)
+
+    if command == "list":
+        reply = _describe()
+    elif command == "run" and len(argv) > 3:
+        reply = _run(argv[3])
+    else:
+        print(__doc__, file=sys.stderr)
+        return 2
+
+    _reply(out, reply)
+    return 0
+
+
+def _reply(out, reply):
+    sys.stderr.flush()
+    json.dump(reply, out, default=str)
+    out.write("\n")
+    out.flush()
+
+
+if __name__ == "__main__":
+    sys.exit(main(sys.argv))
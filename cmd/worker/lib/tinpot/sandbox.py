@@ -0,0 +1,123 @@
+"""
+Confines the runner of an isolated bundle to the sandbox profile set up by
+the worker in TINPOT_SANDBOX, before any action module is imported:
+
+    {"read_only": [paths], "apparmor": "profile", "seccomp": "<base64 BPF program>",
+     "user": {"uid": ..., "gid": ..., "groups": [...]}}
+
+The read-only paths are bind mounted in the mount namespace the worker
+started the runner in, then the AppArmor profile is entered and the seccomp
+filter installed, which also keeps the mounts from being undone.
+"""
+import base64
+import ctypes
+import errno
+import json
+import os
+
+SANDBOX_DENIED = "SANDBOX_DENIED"
+
+MS_RDONLY = 1
+MS_NOSUID = 2
+MS_NODEV = 4
+MS_NOEXEC = 8
+MS_REMOUNT = 32
+MS_NOATIME = 1024
+MS_NODIRATIME = 2048
+MS_BIND = 4096
+MS_REC = 16384
+MS_PRIVATE = 1 << 18
+MS_RELATIME = 1 << 21
+
+PR_SET_NO_NEW_PRIVS = 38
+PR_SET_SECCOMP = 22
+SECCOMP_MODE_FILTER = 2
+
+
+class _SockFprog(ctypes.Structure):
+    _fields_ = [("len", ctypes.c_ushort), ("filter", ctypes.c_void_p)]
+
+
+def _libc():
+    return ctypes.CDLL(None, use_errno=True)
+
+
+def _check(result, what):
+    if result != 0:
+        err = ctypes.get_errno()
+        raise OSError(err, f"{what}: {os.strerror(err)}")
+
+
+def _read_only(libc, path):
+    path = os.path.realpath(path)
+    target = path.encode()
+    _check(libc.mount(target, target, None, MS_BIND | MS_REC, None), f"bind mount {path}")
+    # The flags of the mount are locked in a user namespace, they are kept
+    locked = {
+        os.ST_NOSUID: MS_NOSUID,
+        os.ST_NODEV: MS_NODEV,
+        os.ST_NOEXEC: MS_NOEXEC,
+        os.ST_NOATIME: MS_NOATIME,
+        os.ST_NODIRATIME: MS_NODIRATIME,
+        os.ST_RELATIME: MS_RELATIME,
+    }
+    flags = MS_REMOUNT | MS_BIND | MS_RDONLY
+    stat = os.statvfs(path)
+    for st, ms in locked.items():
+        if stat.f_flag & st:
+            flags |= ms
+    _check(libc.mount(None, target, None, flags, None), f"remount {path} read-only")
+
+
+def _apparmor(profile):
+    for attr in ("/proc/self/attr/apparmor/current", "/proc/self/attr/current"):
+        try:
+            with open(attr, "w") as f:
+                f.write(f"changeprofile {profile}")
+            return
+        except FileNotFoundError:
+            continue
+    raise OSError(errno.ENOTSUP, "AppArmor is not available")
+
+
+def _seccomp(libc, program):
+    buffer = ctypes.create_string_buffer(program, len(program))
+    prog = _SockFprog(len(program) // 8, ctypes.cast(buffer, ctypes.c_void_p))
+    _check(libc.prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0), "no_new_privs")
+    _check(libc.prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, ctypes.byref(prog), 0, 0), "seccomp")
+
+
+def apply() -> bool:
+    """Applies the profile of TINPOT_SANDBOX, returns whether there was one."""
+    setup = os.environ.pop("TINPOT_SANDBOX", None)
+    if not setup:
+        return False
+    setup = json.loads(setup)
+    libc = _libc()
+    if setup.get("read_only"):
+        # Nothing mounted here propagates to the host
+        _check(libc.mount(b"none", b"/", None, MS_REC | MS_PRIVATE, None), "make / private")
+        for path in setup["read_only"]:
+            _read_only(libc, path)
+    user = setup.get("user")
+    if user:
+        # Started as root for mounting, the action runs as its run_as user
+        os.setgroups(user.get("groups") or [])
+        os.setgid(user["gid"])
+        os.setuid(user["uid"])
+    if setup.get("apparmor"):
+        _apparmor(setup["apparmor"])
+    if setup.get("seccomp"):
+        _seccomp(libc, base64.b64decode(setup["seccomp"]))
+    return True
+
+
+def denied(exc: BaseException) -> bool:
+    """Tells whether an exception, or one it was raised from, was caused by the sandbox."""
+    seen = set()
+    while exc is not None and id(exc) not in seen:
+        seen.add(id(exc))
+        if isinstance(exc, OSError) and exc.errno in (errno.EPERM, errno.EROFS):
+            return True
+        exc = exc.__cause__ or exc.__context__
+    return False
@@ -0,0 +1,129 @@
+"""
+Type hint introspection: turns the annotations of action functions into
+parameter descriptions (nested objects, lists, enums) and converts the JSON
+parameters of a call back into the annotated types.
+"""
+import dataclasses
+import enum
+from typing import Any, Dict, Literal, Union, get_args, get_origin, get_type_hints
+
+_TYPE_NAMES = {str: "str", int: "int", float: "float", bool: "bool", list: "list", dict: "dict"}
+
+
+def _is_pydantic(hint) -> bool:
+    return isinstance(hint, type) and (hasattr(hint, "model_fields") or hasattr(hint, "__fields__"))
+
+
+def _optional_of(hint):
+    """Returns X for Optional[X], None otherwise."""
+    if get_origin(hint) is Union:
+        args = [a for a in get_args(hint) if a is not type(None)]
+        if len(args) == 1 and len(get_args(hint)) == 2:
+            return args[0]
+    return None
+
+
+def _fields(hint) -> Dict[str, Dict[str, Any]]:
+    """Describes the fields of a dataclass or pydantic model."""
+    fields = {}
+    if dataclasses.is_dataclass(hint):
+        hints = get_type_hints(hint)
+        for field in dataclasses.fields(hint):
+            default = None
+            required = True
+            if field.default is not dataclasses.MISSING:
+                default, required = field.default, False
+            elif field.default_factory is not dataclasses.MISSING:
+                default, required = field.default_factory(), False
+            fields[field.name] = describe(hints.get(field.name, str), default, required)
+        return fields
+
+    model_fields = getattr(hint, "model_fields", None)
+    if model_fields is not None:
+        # pydantic v2
+        for name, field in model_fields.items():
+            required = field.is_required()
+            fields[name] = describe(field.annotation, None if required else field.default, required)
+    else:
+        # pydantic v1
+        for name, field in hint.__fields__.items():
+            fields[name] = describe(field.outer_type_, field.default, bool(field.required))
+    return fields
+
+
+def describe(hint, default=None, required=True) -> Dict[str, Any]:
+    """Describes a parameter of the given type as a ParameterInfo dict."""
+    inner = _optional_of(hint)
+    if inner is not None:
+        return describe(inner, default, False)
+
+    info: Dict[str, Any] = {"default": to_json(default), "required": required}
+    origin = get_origin(hint)
+    if origin is Literal:
+        values = list(get_args(hint))
+        info["type"] = _TYPE_NAMES.get(type(values[0]), "str") if values else "str"
+        info["enum"] = values
+    elif isinstance(hint, type) and issubclass(hint, enum.Enum):
+        values = [member.value for member in hint]
+        info["type"] = _TYPE_NAMES.get(type(values[0]), "str") if values else "str"
+        info["enum"] = values
+    elif origin in (list, tuple, set, frozenset):
+        info["type"] = "list"
+        args = get_args(hint)
+        if args and args[0] is not Ellipsis:
+            item = describe(args[0])
+            item.pop("required")
+            item.pop("default")
+            info["items"] = item
+    elif origin is dict:
+        info["type"] = "dict"
+    elif dataclasses.is_dataclass(hint) or _is_pydantic(hint):
+        info["type"] = "dict"
+        info["properties"] = _fields(hint)
+    elif hint in _TYPE_NAMES:
+        info["type"] = _TYPE_NAMES[hint]
+    else:
+        info["type"] = hint.__name__ if hasattr(hint, "__name__") else str(hint)
+    return info
+
+
+def to_json(value):
+    """Converts defaults (enums, dataclasses, models) to plain JSON values."""
+    if isinstance(value, enum.Enum):
+        return value.value
+    if dataclasses.is_dataclass(value) and not isinstance(value, type):
+        return to_json(dataclasses.asdict(value))
+    if hasattr(value, "model_dump"):
+        return to_json(value.model_dump())
+    if _is_pydantic(type(value)) and hasattr(value, "dict"):
+        return to_json(value.dict())
+    if isinstance(value, dict):
+        return {k: to_json(v) for k, v in value.items()}
+    if isinstance(value, (list, tuple, set, frozenset)):
+        return [to_json(v) for v in value]
+    return value
+
+
+def coerce(hint, value):
+    """Converts a JSON parameter value to the annotated type."""
+    if value is None:
+        return None
+    inner = _optional_of(hint)
+    if inner is not None:
+        return coerce(inner, value)
+    origin = get_origin(hint)
+    if isinstance(hint, type) and issubclass(hint, enum.Enum):
+        return hint(value)
+    if origin in (list, tuple, set, frozenset) and isinstance(value, list):
+        args = get_args(hint)
+        if args and args[0] is not Ellipsis:
+            value = [coerce(args[0], v) for v in value]
+        return origin(value)
+    if not isinstance(value, dict):
+        return value
+    if dataclasses.is_dataclass(hint):
+        hints = get_type_hints(hint)
+        return hint(**{k: coerce(hints.get(k, Any), v) for k, v in value.items()})
+    if _is_pydantic(hint):
+        return hint.model_validate(value) if hasattr(hint, "model_validate") else hint.parse_obj(value)
+    return value
@@ -1,5 +1,6 @@
 import subprocess
 import os
+from .context import scratch_dir
 from .decorators import action_print
 
 class CommandResult:
@@ -17,7 +18,7 @@ def run_command(command: str, cwd: str = None) -> CommandResult:
     
     Args:
         command: Shell command to execute
-        cwd: Working directory (optional)
+        cwd: Working directory (default: the scratch directory of the execution, if any)
         
     Returns:
         CommandResult object with .stdout, .stderr, and .returncode
@@ -26,6 +27,8 @@ def run_command(command: str, cwd: str = None) -> CommandResult:
         Exception: If command fails (non-zero exit code)
     """
     action_print(f"$ {command}")
+    if cwd is None:
+        cwd = scratch_dir()
     
     process = subprocess.Popen(
         command,
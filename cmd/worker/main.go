@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"embed"
-	"encoding/json"
-	"fmt"
+	"flag"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/balazsgrill/tinpot"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/balazsgrill/tinpot/worker"
 	"github.com/google/uuid"
 )
 
@@ -20,8 +26,56 @@ var embeddedLib embed.FS
 
 // Configuration
 var (
+	// Comma separated broker URLs in failover order, mqtt+srv://domain discovers them via DNS SRV
 	MQTTBroker = getEnv("MQTT_BROKER", "tcp://localhost:1883")
-	ActionsDir = getEnv("ACTIONS_DIR", "../actions")
+	// MQTT_MIRROR_BROKER gets the announcements too, e.g. while migrating to another broker
+	MQTTMirrorBroker = getEnv("MQTT_MIRROR_BROKER", "")
+	ActionsDir       = getEnv("ACTIONS_DIR", "../actions")
+	// GIT_REPOSITORY makes ACTIONS_DIR a checkout of GIT_BRANCH of the repository, updated on
+	// startup and on the sync commands of the coordinator
+	GitRepository = getEnv("GIT_REPOSITORY", "")
+	GitBranch     = getEnv("GIT_BRANCH", "main")
+	// GIT_SSH_KEY is the private key file for ssh repository URLs
+	GitSSHKey = getEnv("GIT_SSH_KEY", "")
+	// GIT_VERIFY_SIGNATURES "true" only checks out signed commits, trusting the ssh keys of
+	// the GIT_ALLOWED_SIGNERS file or the gpg keyring
+	GitVerifySignatures = getEnv("GIT_VERIFY_SIGNATURES", "false")
+	GitAllowedSigners   = getEnv("GIT_ALLOWED_SIGNERS", "")
+	// BUNDLE_KEYS is a file of trusted minisign or PEM public keys, making the worker load
+	// only the signed archives of ACTIONS_DIR, see tinpot.ParseBundleKeys
+	BundleKeys = getEnv("BUNDLE_KEYS", "")
+	// BUNDLE_INSTALL "true" accepts the bundles deployed by tinpotctl install into ACTIONS_DIR,
+	// only signed ones if BUNDLE_KEYS is set
+	BundleInstall = getEnv("BUNDLE_INSTALL", "false")
+	// RUN_AS_HELPER runs the actions declaring run_as through a command like "sudo -n -u {user} --"
+	// ({user} is replaced), a root worker switches to the user itself without it
+	RunAsHelper = getEnv("RUN_AS_HELPER", "")
+	// SCRATCH_DIR gives each execution an empty directory under it, see tinpot.scratch_dir()
+	ScratchDir = getEnv("SCRATCH_DIR", "")
+	// SCRATCH_RETENTION is how long the scratch directories of failed executions are kept, "0"
+	// removes them right away
+	ScratchRetention = getEnv("SCRATCH_RETENTION", "24h")
+	// SCRATCH_QUOTA_MB fails the executions whose scratch directory grows larger, "0" for no limit
+	ScratchQuotaMB = getEnv("SCRATCH_QUOTA_MB", "0")
+	// Tenant whose topic tree the actions are announced in, empty for the default tenant
+	Tenant = getEnv("TENANT", "")
+	// TELEMETRY_INTERVAL is how often resource usage is reported, "0" disables it
+	TelemetryInterval = getEnv("TELEMETRY_INTERVAL", "30s")
+	// MQTT_DELIVERY overrides the QoS and retain flag per message class, see tinpot.ParseDelivery
+	MQTTDelivery = getEnv("MQTT_DELIVERY", "")
+	// MQTT_VERSION selects the protocol: "3" (3.1.1) or "5", which adds correlation user properties
+	MQTTVersion = getEnv("MQTT_VERSION", "3")
+	// REQUEST_SIGNING_KEY verifies the execution requests, see tinpot.ParseSigningKey
+	RequestSigningKey = getEnv("REQUEST_SIGNING_KEY", "")
+	// LOG_BATCH_INTERVAL is how long log lines are collected into one message, "0" disables batching
+	LogBatchInterval = getEnv("LOG_BATCH_INTERVAL", "100ms")
+	// WORKER_LABELS are matched by the selectors of the actions and executions, like
+	// "site=berlin,gpu=a100", on top of the "os" and "arch" of the worker
+	WorkerLabels = getEnv("WORKER_LABELS", "")
+	// TOPIC_LAYOUT "worker" nests the trigger and execution topics under the worker's own topic
+	TopicLayout = getEnv("TOPIC_LAYOUT", tinpot.TopicLayoutShared)
+	// RELEASE_TRACK "canary" announces the actions as canary versions besides the stable workers' ones
+	ReleaseTrack = getEnv("RELEASE_TRACK", tinpot.TrackStable)
 )
 
 func getEnv(key, def string) string {
@@ -32,25 +86,259 @@ func getEnv(key, def string) string {
 }
 
 func main() {
+	testMode := flag.Bool("test", false, "validate the actions and run their self-tests instead of serving them, exits non-zero on problems")
+	selfTests := flag.Bool("self-tests", true, "run the self-tests declared with @action(tests=...) in -test mode")
+	devAddr := flag.String("dev", "", "serve the actions over HTTP on this address (e.g. localhost:8090) instead of MQTT, for local development")
+	flag.Parse()
+	// The tenant is a level of the topics, the coordinator rejects the same names
+	if err := tinpot.ValidTenant(Tenant); err != nil {
+		log.Fatalf("Invalid TENANT: %v", err)
+	}
 
-	mgr := NewPyActionManager()
-	opts := mqtt.NewClientOptions().AddBroker(MQTTBroker)
-	clientID := "tinpot-worker-" + uuid.New().String()
-	opts.SetClientID(clientID)
-	opts.SetAutoReconnect(true)
+	var repo *gitRepo
+	if GitRepository != "" {
+		repo = &gitRepo{URL: GitRepository, Branch: GitBranch, Dir: ActionsDir, SSHKey: GitSSHKey, Verify: GitVerifySignatures == "true", AllowedSigners: GitAllowedSigners}
+		commit, _, err := repo.Sync()
+		if err == nil {
+			log.Printf("Actions at commit %s of %s", commit, GitRepository)
+		} else if _, statErr := os.Stat(filepath.Join(ActionsDir, ".git")); statErr == nil {
+			// The current checkout serves while the repository is unreachable
+			log.Printf("WARNING: Failed to update the actions: %v", err)
+		} else {
+			log.Fatalf("Failed to clone the actions: %v", err)
+		}
+	}
 
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
-		log.Println("Connected to MQTT Broker")
-		announceActions(mgr, c)
-		subscribeToActions(mgr, c)
-	})
+	// The commit of the actions is reported with every result, also for mounted checkouts
+	var actionsCommit string
+	if repo != nil {
+		actionsCommit, _ = repo.Head()
+	} else if _, err := os.Stat(filepath.Join(ActionsDir, ".git")); err == nil {
+		actionsCommit, _ = (&gitRepo{Dir: ActionsDir}).Head()
+	}
+
+	// Extract embedded lib to temp directory
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		log.Fatalf("Failed to extract embedded lib: %v", err)
+	}
+	log.Printf("Extracted embedded lib to: %s", libPath)
+
+	var bundleErrors []tinpot.MqttLoadError
+	var bundleKeys *tinpot.BundleKeys
+	installDir, extractedDir := ActionsDir, ""
+	if BundleKeys != "" {
+		data, err := os.ReadFile(BundleKeys)
+		if err != nil {
+			log.Fatalf("Failed to read the bundle keys: %v", err)
+		}
+		bundleKeys, err = tinpot.ParseBundleKeys(data)
+		if err != nil {
+			log.Fatalf("Invalid bundle keys: %v", err)
+		}
+		extractedDir, bundleErrors, err = extractSignedBundles(ActionsDir, bundleKeys)
+		if err != nil {
+			log.Fatalf("Failed to extract the signed bundles: %v", err)
+		}
+		// Only the verified content is loaded
+		ActionsDir = extractedDir
+	}
+
+	bundles, err := discoverBundles(ActionsDir)
+	if err != nil {
+		log.Fatalf("Failed to discover action bundles: %v", err)
+	}
+
+	// Isolated bundles run in their own interpreter, the rest shares the embedded one
+	var isolated []string
+	var managers []tinpot.ActionManager
+	for _, bundle := range bundles {
+		if !bundle.Isolated() {
+			continue
+		}
+		isolated = append(isolated, bundle.Dir)
+		bundleMgr, err := NewSubprocessActionManager(bundle, libPath)
+		if err != nil {
+			log.Printf("WARNING: Skipping bundle %s: %v", bundle.Name, err)
+			bundleErrors = append(bundleErrors, tinpot.MqttLoadError{Module: bundle.Name, Path: bundle.Dir, Error: err.Error()})
+			continue
+		}
+		managers = append(managers, bundleMgr)
+	}
+
+	pyMgr := NewPyActionManager(libPath, isolated)
+	all := append([]tinpot.ActionManager{pyMgr}, managers...)
+	mgr := NewMultiActionManager(all...)
+	bundleErrors = append(bundleErrors, missingActions(bundles, mgr)...)
+	loadErrors := func() []tinpot.MqttLoadError {
+		loadErrors := append([]tinpot.MqttLoadError(nil), bundleErrors...)
+		for _, m := range all {
+			if l, ok := m.(interface{ LoadErrors() []tinpot.MqttLoadError }); ok {
+				loadErrors = append(loadErrors, l.LoadErrors()...)
+			}
+		}
+		return loadErrors
+	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("Failed to connect to MQTT: %v", token.Error())
+	if *testMode {
+		tests := make(map[string][]actionTest)
+		for _, m := range all {
+			if s, ok := m.(selfTester); ok {
+				for name, t := range s.SelfTests() {
+					tests[name] = t
+				}
+			}
+		}
+		if runChecks(os.Stdout, mgr, loadErrors(), tests, *selfTests) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *devAddr != "" {
+		log.Printf("Dev mode: POST http://%s/run/{action} with the parameters as JSON body, or use the /api/ endpoints", *devAddr)
+		log.Fatal(http.ListenAndServe(*devAddr, devHandler(mgr)))
+	}
+
+	clientID := "tinpot-worker-" + uuid.New().String()
+	transport, err := newTransport(MQTTBroker, clientID)
+	if err != nil {
+		log.Fatalf("Invalid MQTT configuration: %v", err)
+	}
+	var workerTransport tinpot.Transport = transport
+	var mirror connectableTransport
+	if MQTTMirrorBroker != "" {
+		if mirror, err = newTransport(MQTTMirrorBroker, clientID); err != nil {
+			log.Fatalf("Invalid MQTT_MIRROR_BROKER: %v", err)
+		}
+		workerTransport = tinpot.NewMirrorTransport(transport, mirror)
+	}
+	telemetryInterval, err := time.ParseDuration(TelemetryInterval)
+	if err != nil {
+		log.Fatalf("Invalid TELEMETRY_INTERVAL: %v", err)
+	}
+	delivery, err := tinpot.ParseDelivery(MQTTDelivery)
+	if err != nil {
+		log.Fatalf("Invalid MQTT_DELIVERY: %v", err)
+	}
+	var signingKey *tinpot.SigningKey
+	if RequestSigningKey != "" {
+		if signingKey, err = tinpot.ParseSigningKey(RequestSigningKey); err != nil {
+			log.Fatalf("Invalid REQUEST_SIGNING_KEY: %v", err)
+		}
+	}
+	logBatchInterval, err := time.ParseDuration(LogBatchInterval)
+	if err != nil {
+		log.Fatalf("Invalid LOG_BATCH_INTERVAL: %v", err)
+	}
+	if logBatchInterval == 0 {
+		logBatchInterval = -1
+	}
+	scratchRetention, err := time.ParseDuration(ScratchRetention)
+	if err != nil {
+		log.Fatalf("Invalid SCRATCH_RETENTION: %v", err)
+	}
+	scratchQuota, err := strconv.ParseInt(ScratchQuotaMB, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid SCRATCH_QUOTA_MB: %v", err)
+	}
+	labels, err := tinpot.ParseLabels(WorkerLabels)
+	if err != nil {
+		log.Fatalf("Invalid WORKER_LABELS: %v", err)
+	}
+	for key, value := range map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH} {
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+	if TopicLayout != tinpot.TopicLayoutShared && TopicLayout != tinpot.TopicLayoutWorker {
+		log.Fatalf("Invalid TOPIC_LAYOUT: %q", TopicLayout)
+	}
+	if ReleaseTrack != tinpot.TrackStable && ReleaseTrack != tinpot.TrackCanary {
+		log.Fatalf("Invalid RELEASE_TRACK: %q", ReleaseTrack)
+	}
+	// On shutdown, running async actions are cancelled so they can clean up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The embedded interpreter can't unload modules, new actions are loaded by a restart
+	var restarting atomic.Bool
+	var syncActions func(ref string)
+	if repo != nil {
+		syncActions = func(ref string) {
+			if !repo.Follows(ref) {
+				return
+			}
+			commit, changed, err := repo.Sync()
+			if err != nil {
+				log.Printf("Failed to update the actions: %v", err)
+			} else if changed && restarting.CompareAndSwap(false, true) {
+				log.Printf("Actions updated to commit %s, restarting", commit)
+				stop()
+			}
+		}
+	}
+	var install func(req *tinpot.MqttInstallRequest) (*tinpot.BundleInfo, error)
+	if BundleInstall == "true" {
+		install = func(req *tinpot.MqttInstallRequest) (*tinpot.BundleInfo, error) {
+			return installBundle(installDir, bundleKeys, req)
+		}
+	}
+	installed := func() {
+		if restarting.CompareAndSwap(false, true) {
+			log.Printf("Bundle installed, restarting")
+			stop()
+		}
+	}
+	w := worker.NewWorker(workerTransport, mgr, worker.Options{
+		Tenant:            Tenant,
+		ID:                clientID,
+		TelemetryInterval: telemetryInterval,
+		TelemetryDisk:     ActionsDir,
+		Delivery:          &delivery,
+		Telemetry: func(sample *tinpot.MqttWorkerTelemetry) {
+			sample.Python = pyMgr.Stats()
+		},
+		LoadErrors:       loadErrors,
+		SigningKey:       signingKey,
+		TopicLayout:      TopicLayout,
+		Track:            ReleaseTrack,
+		LogBatchInterval: logBatchInterval,
+		Sync:             syncActions,
+		Install:          install,
+		Installed:        installed,
+		ScratchDir:       ScratchDir,
+		ScratchRetention: scratchRetention,
+		ScratchQuota:     scratchQuota << 20,
+		Labels:           labels,
+		Environment:      tinpot.ExecutionEnvironment{PythonVersion: pyMgr.PythonVersion(), GitCommit: actionsCommit},
+		Cancel: func() {
+			for _, m := range all {
+				if c, ok := m.(interface{ CancelRunning() }); ok {
+					c.CancelRunning()
+				}
+			}
+		},
+	})
+	if err := transport.Connect(); err != nil {
+		log.Fatalf("Failed to connect to MQTT: %v", err)
+	}
+	if mirror != nil {
+		if err := mirror.Connect(); err != nil {
+			log.Printf("Failed to connect to the mirror broker: %v", err)
+		}
 	}
 
-	select {}
+	if err := w.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Worker stopped: %v", err)
+	}
+	if extractedDir != "" {
+		os.RemoveAll(extractedDir)
+	}
+	if restarting.Load() {
+		os.RemoveAll(libPath)
+		if err := restart(); err != nil {
+			log.Fatalf("Failed to restart: %v", err)
+		}
+	}
 }
 
 func extractEmbeddedLib() (string, error) {
@@ -58,6 +346,10 @@ func extractEmbeddedLib() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// Readable by the run_as users of the actions
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		return "", err
+	}
 
 	// Our embedded FS has "lib" at root.
 	// We want to extract contents of "lib" to tempDir.
@@ -98,91 +390,3 @@ func extractEmbeddedLib() (string, error) {
 
 	return tempDir, nil
 }
-
-func triggerTopicForAction(actionName string) string {
-	return fmt.Sprintf("tinpot/actions/%s/trigger", actionName)
-}
-
-func announceTopicForAction(actionName string) string {
-	return fmt.Sprintf("tinpot/actions/%s", actionName)
-}
-
-func toMqttAction(act tinpot.ActionInfo) tinpot.MqttAction {
-	return tinpot.MqttAction{
-		Description:  act.Description,
-		Group:        act.Group,
-		Parameters:   act.Parameters,
-		TriggerTopic: triggerTopicForAction(act.Name),
-	}
-}
-
-func announceActions(mgr tinpot.ActionManager, c mqtt.Client) {
-	actions := mgr.ListActions()
-	for _, act := range actions {
-		topic := announceTopicForAction(act.Name)
-		payload, _ := json.Marshal(toMqttAction(act))
-		c.Publish(topic, 1, true, payload).Wait()
-	}
-}
-
-func subscribeToActions(mgr tinpot.ActionManager, c mqtt.Client) {
-	actions := mgr.ListActions()
-	for _, act := range actions {
-		topic := triggerTopicForAction(act.Name)
-		c.Subscribe(topic, 1, func(cl mqtt.Client, msg mqtt.Message) {
-			go executeAction(mgr, cl, act.Name, msg)
-		})
-	}
-}
-
-type ExecutionRequest struct {
-	ExecutionID string                 `json:"execution_id"`
-	Parameters  map[string]interface{} `json:"parameters"`
-	ResultTopic string                 `json:"result_topic"`
-	LogTopic    string                 `json:"log_topic"`
-}
-
-func sendResult(c mqtt.Client, req ExecutionRequest, status string, result interface{}, error string) {
-	resp := tinpot.MqttResultResponse{
-		Status: status,
-		Result: result,
-		Error:  error,
-	}
-	payload, _ := json.Marshal(resp)
-	token := c.Publish(req.ResultTopic, 1, true, payload)
-	token.Wait()
-	if token.Error() != nil {
-		log.Printf("Failed to publish result: %v", token.Error())
-	}
-}
-
-func executeAction(mgr tinpot.ActionManager, c mqtt.Client, actionName string, msg mqtt.Message) {
-	var req ExecutionRequest
-	err := json.Unmarshal(msg.Payload(), &req)
-	if err != nil {
-		log.Printf("Failed to unmarshal action %s: %v", actionName, err)
-		return
-	}
-
-	var responseCallback tinpot.ActionResponse
-	responseCallback = func(error string, result map[string]interface{}) {
-		status := "SUCCESS"
-		if error != "" {
-			status = "FAILURE"
-		}
-		sendResult(c, req, status, result, error)
-	}
-
-	var logsCallback tinpot.ActionLogs
-	logsCallback = func(level, message string) {
-		entry := tinpot.MqttLogEntry{
-			Timestamp: time.Now().Format(time.RFC3339),
-			Level:     level,
-			Message:   message,
-		}
-		data, _ := json.Marshal(entry)
-		c.Publish(req.LogTopic, 1, true, data)
-	}
-
-	mgr.GetAction(actionName)(req.Parameters, responseCallback, logsCallback)
-}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// multiActionManager exposes the actions of several managers as one.
+// When two managers provide the same action name, the first one wins.
+type multiActionManager struct {
+	managers []tinpot.ActionManager
+}
+
+func NewMultiActionManager(managers ...tinpot.ActionManager) tinpot.ActionManager {
+	m := &multiActionManager{managers: managers}
+	seen := make(map[string]bool)
+	for _, mgr := range managers {
+		for name := range mgr.ListActions() {
+			if seen[name] {
+				log.Printf("WARNING: Action %s is provided by multiple bundles, only the first one is used", name)
+			}
+			seen[name] = true
+		}
+	}
+	return m
+}
+
+func (m *multiActionManager) GetAction(name string) tinpot.ActionTrigger {
+	for _, mgr := range m.managers {
+		if trigger := mgr.GetAction(name); trigger != nil {
+			return trigger
+		}
+	}
+	return nil
+}
+
+func (m *multiActionManager) ListActions() map[string]tinpot.ActionInfo {
+	result := make(map[string]tinpot.ActionInfo)
+	for i := len(m.managers) - 1; i >= 0; i-- {
+		for name, act := range m.managers[i].ListActions() {
+			result[name] = act
+		}
+	}
+	return result
+}
+
+func (m *multiActionManager) IsConnected() bool {
+	for _, mgr := range m.managers {
+		if !mgr.IsConnected() {
+			return false
+		}
+	}
+	return true
+}
@@ -1,15 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/balazsgrill/tinpot"
 	cpy3 "go.nhat.io/cpy/v3"
@@ -28,6 +27,7 @@ type Action struct {
 type pyActionInfo struct {
 	tinpot.ActionInfo
 	Function *python.Object
+	Tests    []actionTest
 }
 
 type pyActionManager struct {
@@ -37,14 +37,15 @@ type pyActionManager struct {
 	stateMu         sync.Mutex
 	currentLogTopic string
 	mainThreadState *cpy3.PyThreadState
+	// loadErrors are the action modules that failed to import during discovery
+	loadErrors []tinpot.MqttLoadError
 }
 
 func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	w := setupLogCapture(logs)
-	defer w.Close()
+	finishLogs := setupLogCapture(logs)
 
 	// Acquire GIL
 	gstate := cpy3.PyGILState_Ensure()
@@ -55,12 +56,20 @@ func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tin
 	defer kwargs.DecRef()
 
 	for k, v := range parameters {
+		// Internal parameters (e.g. _execution_id) are not passed to the action
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
 		keyStr := cpy3.PyUnicode_FromString(k)
 		var valPy *cpy3.PyObject
 
 		switch val := v.(type) {
 		case string:
 			valPy = cpy3.PyUnicode_FromString(val)
+		case int:
+			valPy = cpy3.PyLong_FromLong(val)
+		case int64:
+			valPy = cpy3.PyLong_FromLong(int(val))
 		case float64:
 			if float64(int(val)) == val {
 				valPy = cpy3.PyLong_FromLong(int(val))
@@ -74,16 +83,26 @@ func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tin
 				valPy = cpy3.PyBool_FromLong(0)
 			}
 		default:
-			valPy = cpy3.PyUnicode_FromString(fmt.Sprintf("%v", val))
+			// Objects, lists and null are passed through JSON
+			valPy = jsonToPy(val)
+			if valPy == nil {
+				valPy = cpy3.PyUnicode_FromString(fmt.Sprintf("%v", val))
+			}
 		}
 		cpy3.PyDict_SetItem(kwargs, keyStr, valPy)
 		keyStr.DecRef()
 		valPy.DecRef()
 	}
 
+	if dir, ok := parameters[tinpot.ScratchDirParameter].(string); ok {
+		setScratchDir(dir)
+		defer setScratchDir("")
+	}
+
 	argsTuple := cpy3.PyTuple_New(0)
 	if argsTuple == nil {
 		log.Printf("ERROR: PyTuple_New failed")
+		finishLogs()
 		response("Internal Error", nil)
 		return
 	}
@@ -91,9 +110,12 @@ func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tin
 
 	log.Printf("Triggering action %s (argsTuple=%p, kwargs=%p)", act.Name, argsTuple, kwargs)
 
-	// Call using cpy3 method
+	// Call using cpy3 method. The interpreter shares the worker's memory, so only the CPU time
+	// of the (locked) thread is measured.
+	cpuBefore := threadCPUTime()
 	resPy := act.Function.PyObject().Call(argsTuple, kwargs)
 	log.Printf("Python call returned %p", resPy)
+	tinpot.ReportUsage(parameters, tinpot.ResourceUsage{CPUSeconds: (threadCPUTime() - cpuBefore).Seconds()})
 
 	var result map[string]interface{}
 	var errMsg string
@@ -101,7 +123,7 @@ func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tin
 	if resPy == nil {
 		if cpy3.PyErr_Occurred() != nil {
 			cpy3.PyErr_Print()
-			errMsg = "Exception occurred"
+			errMsg = lastException()
 		}
 	} else {
 		// Convert valid result
@@ -125,53 +147,80 @@ func (act *pyActionInfo) trigger(parameters map[string]interface{}, response tin
 			}
 		}
 	}
+	// All output of the action is logged before the response
+	finishLogs()
 	log.Printf("Trigger finished, sending result")
 	response(errMsg, result)
 }
 
-func setupLogCapture(callback tinpot.ActionLogs) *os.File {
+// setScratchDir makes tinpot.scratch_dir() return dir on the calling thread, the GIL must be held
+func setScratchDir(dir string) {
+	module, err := python.ImportModule("tinpot.context")
+	if err != nil {
+		log.Printf("Failed to set the scratch directory: %v", err)
+		return
+	}
+	module.CallMethodArgs("_set_scratch_dir", dir)
+}
+
+// progressMarker prefixes the lines action_progress() writes to stdout
+const progressMarker = "##tinpot[progress] "
+
+// parseLogLine classifies a line of captured action output
+func parseLogLine(line string) (level string, message string) {
+	if payload, ok := strings.CutPrefix(line, progressMarker); ok {
+		return tinpot.LogLevelProgress, payload
+	}
+	return "INFO", line
+}
+
+// setupLogCapture redirects the Python output to the callback (which may be nil).
+// The returned function must be called with the GIL held once the action returned;
+// it flushes the output and waits until every line has been delivered.
+func setupLogCapture(callback tinpot.ActionLogs) (finish func()) {
 	r, w, err := os.Pipe()
 	if err != nil {
 		log.Fatal(err)
 	}
-	fd := int(w.Fd())
+	file, err := captureFile(w)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	script := fmt.Sprintf(`
 import sys
 import os
-sys.stdout = os.fdopen(%d, "w", buffering=1, closefd=False)
+sys.stdout = %s
 sys.stderr = sys.stdout
-`, fd)
+`, file)
 	// Run with GIL
 	gstate := cpy3.PyGILState_Ensure()
 	cpy3.PyRun_SimpleString(script)
 	cpy3.PyGILState_Release(gstate)
 
+	done := make(chan struct{})
 	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := r.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				time.Sleep(100 * time.Millisecond)
+		defer close(done)
+		defer r.Close()
+		// Read whole lines, so progress markers are never split between reads
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" || callback == nil {
 				continue
 			}
-			lines := strings.Split(string(buf[:n]), "\n")
-			for _, line := range lines {
-				if strings.TrimSpace(line) == "" {
-					continue
-				}
 
-				callback("INFO", line)
-			}
+			callback(parseLogLine(line))
 		}
 	}()
-	return w
+	return func() {
+		cpy3.PyRun_SimpleString("import sys\nsys.stdout.close()\nsys.stdout, sys.stderr = sys.__stdout__, sys.__stderr__")
+		w.Close()
+		<-done
+	}
 }
 
-func setupPython() {
+func setupPython(libPath string) {
 	sys, err := python.ImportModule("sys")
 	if err != nil {
 		log.Fatal(err)
@@ -180,13 +229,6 @@ func setupPython() {
 
 	cwd, _ := os.Getwd()
 
-	// Extract embedded lib to temp directory
-	libPath, err := extractEmbeddedLib()
-	if err != nil {
-		log.Fatalf("Failed to extract embedded lib: %v", err)
-	}
-	log.Printf("Extracted embedded lib to: %s", libPath)
-
 	// Add temp lib path to python sys.path
 	// Also add ActionsDir so actions can be found
 
@@ -196,7 +238,7 @@ func setupPython() {
 	path.CallMethodArgs("append", ActionsDir)
 }
 
-func (mgr *pyActionManager) discoverActions() {
+func (mgr *pyActionManager) discoverActions(exclude []string) {
 	mgr.actionsMu.Lock()
 	defer mgr.actionsMu.Unlock()
 	log.Printf("Discovering actions in %s...", ActionsDir)
@@ -207,8 +249,12 @@ func (mgr *pyActionManager) discoverActions() {
 	}
 
 	discoverFunc := loader.GetAttr("discover_actions")
-	// Call discover_actions(ActionsDir)
-	discoverFunc.CallMethodArgs("__call__", ActionsDir)
+	// Call discover_actions(ActionsDir, exclude)
+	discoverFunc.CallMethodArgs("__call__", ActionsDir, python.NewListFromValues(exclude...))
+	mgr.loadErrors = nil
+	if err := pyToJSON(loader.GetAttr("LOAD_ERRORS"), &mgr.loadErrors); err != nil {
+		log.Printf("WARNING: Invalid load errors: %v", err)
+	}
 
 	decorators, err := python.ImportModule("tinpot.decorators")
 	if err != nil {
@@ -232,8 +278,44 @@ func (mgr *pyActionManager) discoverActions() {
 		val := registry.GetItem(key) // Dict action info
 
 		name := python.AsString(key)
+		// The embedded interpreter is the worker itself, it can't be confined or switch users
+		var isolatedOnly []string
+		for _, key := range []string{"sandbox", "run_as"} {
+			if val.HasItem(key) && val.GetItem(key).PyObject() != cpy3.Py_None {
+				isolatedOnly = append(isolatedOnly, key)
+			}
+		}
+		if len(isolatedOnly) > 0 {
+			mgr.loadErrors = append(mgr.loadErrors, tinpot.MqttLoadError{
+				Module: python.AsString(val.GetItem("module")),
+				Error:  fmt.Sprintf("action %s declares %s, which needs the %s isolation of its bundle", name, strings.Join(isolatedOnly, " and "), IsolationSubprocess),
+			})
+			continue
+		}
 		desc := python.AsString(val.GetItem("description"))
 		group := python.AsString(val.GetItem("group"))
+		docs := python.AsString(val.GetItem("docs"))
+		var selector string
+		if val.HasItem("selector") && val.GetItem("selector").PyObject() != cpy3.Py_None {
+			selector = python.AsString(val.GetItem("selector"))
+		}
+		var debounce float64
+		if val.HasItem("debounce") && val.GetItem("debounce").PyObject() != cpy3.Py_None {
+			debounce = python.AsFloat64(val.GetItem("debounce"))
+		}
+
+		var examples []tinpot.ActionExample
+		if err := pyToJSON(val.GetItem("examples"), &examples); err != nil {
+			log.Printf("WARNING: Invalid examples of action %s: %v", name, err)
+		}
+		var translations map[string]tinpot.LocalizedText
+		if err := pyToJSON(val.GetItem("translations"), &translations); err != nil {
+			log.Printf("WARNING: Invalid translations of action %s: %v", name, err)
+		}
+		var tests []actionTest
+		if err := pyToJSON(val.GetItem("tests"), &tests); err != nil {
+			log.Printf("WARNING: Invalid tests of action %s: %v", name, err)
+		}
 
 		params := make(map[string]tinpot.ParameterInfo)
 		pDict := val.GetItem("parameters")
@@ -254,20 +336,41 @@ func (mgr *pyActionManager) discoverActions() {
 
 			// Check None
 			if pDefObj.PyObject() != cpy3.Py_None {
-				if python.IsInt(pDefObj) {
+				if python.IsBool(pDefObj) {
+					pDefault = python.AsBool(pDefObj)
+				} else if python.IsInt(pDefObj) {
 					pDefault = python.AsInt(pDefObj)
 				} else if python.IsString(pDefObj) {
 					pDefault = python.AsString(pDefObj)
 				} else if python.IsFloat(pDefObj) {
 					pDefault = python.AsFloat64(pDefObj)
-				} else {
+				} else if err := pyToJSON(pDefObj, &pDefault); err != nil {
 					pDefault = pDefObj.String()
 				}
 			}
-			params[pName] = tinpot.ParameterInfo{
-				Type:    pType,
-				Default: pDefault,
+
+			var pUI *tinpot.ParameterUI
+			if pV.HasItem("ui") {
+				if err := pyToJSON(pV.GetItem("ui"), &pUI); err != nil {
+					log.Printf("WARNING: Invalid UI hints of %s.%s: %v", name, pName, err)
+				}
+			}
+
+			info := tinpot.ParameterInfo{
+				Type:     pType,
+				Default:  pDefault,
+				Required: python.AsBool(pV.GetItem("required")),
+				UI:       pUI,
+			}
+			// Structure derived from the type hints (Literal/Enum values, list items, dataclass fields)
+			for key, target := range map[string]interface{}{"enum": &info.Enum, "items": &info.Items, "properties": &info.Properties} {
+				if pV.HasItem(key) {
+					if err := pyToJSON(pV.GetItem(key), target); err != nil {
+						log.Printf("WARNING: Invalid %s of %s.%s: %v", key, name, pName, err)
+					}
+				}
 			}
+			params[pName] = info
 		}
 
 		funcObj := val.GetItem("function")
@@ -280,29 +383,84 @@ func (mgr *pyActionManager) discoverActions() {
 
 		mgr.actions[name] = &pyActionInfo{
 			ActionInfo: tinpot.ActionInfo{
-				Name:        name,
-				Group:       group,
-				Description: desc,
-				Parameters:  params,
+				Name:         name,
+				Group:        group,
+				Description:  desc,
+				Parameters:   params,
+				Docs:         docs,
+				Examples:     examples,
+				Translations: translations,
+				Bundle:       signedModules[python.AsString(val.GetItem("module"))],
+				Selector:     selector,
+				Debounce:     debounce,
 			},
 			Function: funcObj,
+			Tests:    tests,
 		}
 		log.Printf("Loaded action: %s", name)
 	}
 }
 
-func NewPyActionManager() tinpot.ActionManager {
+// pyToJSON converts a JSON-serializable Python object into v through json.dumps
+func pyToJSON(obj *python.Object, v interface{}) error {
+	jsonMod, err := python.ImportModule("json")
+	if err != nil {
+		return err
+	}
+	jsonStrObj := jsonMod.CallMethodArgs("dumps", obj)
+	if jsonStrObj == nil {
+		python.ClearError()
+		return fmt.Errorf("not JSON serializable")
+	}
+	return json.Unmarshal([]byte(python.AsString(jsonStrObj)), v)
+}
+
+// lastException describes the exception PyErr_Print just reported, e.g. "ValueError: days must be positive"
+func lastException() string {
+	sys, err := python.ImportModule("sys")
+	if err != nil {
+		return "Exception occurred"
+	}
+	last := sys.GetAttr("last_value")
+	if last == nil {
+		python.ClearError()
+		return "Exception occurred"
+	}
+	return python.AsString(last.GetAttr("__class__").GetAttr("__name__")) + ": " + last.String()
+}
+
+// jsonToPy converts a JSON value to a new Python object reference, nil on failure. Requires the GIL.
+func jsonToPy(v interface{}) *cpy3.PyObject {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	jsonMod, err := python.ImportModule("json")
+	if err != nil {
+		return nil
+	}
+	loaded := jsonMod.CallMethodArgs("loads", string(data))
+	if loaded == nil {
+		python.ClearError()
+		return nil
+	}
+	return loaded.PyObject()
+}
+
+// NewPyActionManager loads the actions of ActionsDir into the embedded interpreter.
+// Directories listed in exclude (isolated bundles) are not loaded.
+func NewPyActionManager(libPath string, exclude []string) *pyActionManager {
 	// Initialize Python
 	cpy3.Py_Initialize()
 
-	setupPython()
+	setupPython(libPath)
 
 	// Release GIL to allow other threads to run
 	result := &pyActionManager{
 		actions: make(map[string]*pyActionInfo),
 	}
 
-	result.discoverActions()
+	result.discoverActions(exclude)
 
 	// Release GIL to allow other threads to run
 	result.mainThreadState = cpy3.PyEval_SaveThread()
@@ -332,6 +490,78 @@ func (mgr *pyActionManager) ListActions() map[string]tinpot.ActionInfo {
 	return result
 }
 
+// Stats reports the state of the embedded interpreter for the worker telemetry
+func (mgr *pyActionManager) Stats() map[string]interface{} {
+	mgr.actionsMu.RLock()
+	actions := len(mgr.actions)
+	mgr.actionsMu.RUnlock()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	gstate := cpy3.PyGILState_Ensure()
+	defer cpy3.PyGILState_Release(gstate)
+	stats := map[string]interface{}{"actions": actions}
+	if sys, err := python.ImportModule("sys"); err == nil {
+		stats["version"] = python.AsString(sys.GetAttr("version"))
+		stats["modules"] = sys.GetAttr("modules").Length()
+	}
+	if gc, err := python.ImportModule("gc"); err == nil {
+		var counts []int
+		if pyToJSON(gc.CallMethodArgs("get_count"), &counts) == nil {
+			stats["gc_counts"] = counts
+		}
+	}
+	return stats
+}
+
+// PythonVersion is the version of the embedded interpreter, e.g. "3.11.9"
+func (mgr *pyActionManager) PythonVersion() string {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	gstate := cpy3.PyGILState_Ensure()
+	defer cpy3.PyGILState_Release(gstate)
+	sys, err := python.ImportModule("sys")
+	if err != nil {
+		return ""
+	}
+	version, _, _ := strings.Cut(python.AsString(sys.GetAttr("version")), " ")
+	return version
+}
+
+func (mgr *pyActionManager) LoadErrors() []tinpot.MqttLoadError {
+	mgr.actionsMu.RLock()
+	defer mgr.actionsMu.RUnlock()
+	return mgr.loadErrors
+}
+
+func (mgr *pyActionManager) SelfTests() map[string][]actionTest {
+	mgr.actionsMu.RLock()
+	defer mgr.actionsMu.RUnlock()
+	tests := make(map[string][]actionTest)
+	for name, act := range mgr.actions {
+		if len(act.Tests) > 0 {
+			tests[name] = act.Tests
+		}
+	}
+	return tests
+}
+
+// CancelRunning cancels the running async actions of the embedded interpreter
+func (mgr *pyActionManager) CancelRunning() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	gstate := cpy3.PyGILState_Ensure()
+	defer cpy3.PyGILState_Release(gstate)
+	decorators, err := python.ImportModule("tinpot.decorators")
+	if err != nil {
+		python.ClearError()
+		return
+	}
+	if cancelled := decorators.CallMethodArgs("cancel_running"); cancelled != nil && python.AsInt(cancelled) > 0 {
+		log.Printf("Cancelled %d running async actions", python.AsInt(cancelled))
+	}
+}
+
 func (mgr *pyActionManager) IsConnected() bool {
 	return true
 }
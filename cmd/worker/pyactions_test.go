@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestActionManagerContract(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	ActionsDir = "testdata/contract"
+
+	t.Run("Embedded", func(t *testing.T) {
+		tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+			return NewPyActionManager(libPath, nil)
+		})
+	})
+
+	t.Run("Subprocess", func(t *testing.T) {
+		dir, _ := filepath.Abs(ActionsDir)
+		tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+			mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "contract"}, Python: "python3", Dir: dir}, libPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mgr
+		})
+	})
+}
+
+func TestTypeHintSchemas(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	// The embedded interpreter is set up once per process, the bundle runner uses the same introspection
+	dir, _ := filepath.Abs("testdata/typed")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "typed"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := mgr.ListActions()["deploy"].Parameters
+	target := params["target"]
+	if target.Type != "dict" || !target.Required || target.Properties["port"].Default != float64(22) || target.Properties["tags"].Items.Type != "str" {
+		t.Errorf("unexpected dataclass parameter: %+v", target)
+	}
+	if mode := params["mode"]; !reflect.DeepEqual(mode.Enum, []interface{}{"fast", "safe"}) || mode.Default != "safe" {
+		t.Errorf("unexpected Literal parameter: %+v", mode)
+	}
+	if colors := params["colors"]; colors.Type != "list" || colors.Items == nil || len(colors.Items.Enum) != 2 {
+		t.Errorf("unexpected enum list parameter: %+v", colors)
+	}
+	if note := params["note"]; note.Type != "str" || note.Required {
+		t.Errorf("unexpected Optional parameter: %+v", note)
+	}
+
+	done := make(chan map[string]interface{}, 1)
+	mgr.GetAction("deploy")(map[string]interface{}{
+		"target": map[string]interface{}{"host": "web1"},
+		"colors": []interface{}{"green"},
+	}, func(errMsg string, result map[string]interface{}) {
+		if errMsg != "" {
+			t.Error(errMsg)
+		}
+		done <- result
+	}, nil)
+	want := map[string]interface{}{"host": "web1", "port": float64(22), "mode": "safe", "colors": []interface{}{"GREEN"}, "note_type": "NoneType"}
+	if result := <-done; !reflect.DeepEqual(result, want) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestAsyncActions(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	dir, _ := filepath.Abs("testdata/async")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "async"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type reply struct {
+		err    string
+		result map[string]interface{}
+	}
+	done := make(chan reply, 1)
+	mgr.GetAction("gather")(map[string]interface{}{"count": 4}, func(errMsg string, result map[string]interface{}) {
+		done <- reply{errMsg, result}
+	}, nil)
+	if r := <-done; r.err != "" || r.result["sum"] != float64(6) {
+		t.Errorf("unexpected reply: %+v", r)
+	}
+
+	started := make(chan struct{})
+	var logs []string
+	go mgr.GetAction("wait_forever")(nil, func(errMsg string, result map[string]interface{}) {
+		done <- reply{errMsg, result}
+	}, func(level, message string) {
+		if logs = append(logs, message); message == "started" {
+			close(started)
+		}
+	})
+	<-started
+	mgr.(*subprocessActionManager).CancelRunning()
+	select {
+	case r := <-done:
+		if !strings.Contains(r.err, "CancelledError") || !slices.Contains(logs, "cleaned up") {
+			t.Errorf("unexpected reply to cancellation: %+v, logs %v", r, logs)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("action was not cancelled")
+	}
+}
+
+func TestLoadErrorsAndChecks(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	dir, _ := filepath.Abs("testdata/broken")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "broken"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mgr.ListActions()["still_works"]; !ok {
+		t.Error("the working module should still be loaded")
+	}
+	loadErrors := mgr.(*subprocessActionManager).LoadErrors()
+	if len(loadErrors) != 1 || loadErrors[0].Module != "missing_dependency" || !strings.HasPrefix(loadErrors[0].Error, "ModuleNotFoundError") {
+		t.Errorf("unexpected load errors: %+v", loadErrors)
+	}
+
+	var report strings.Builder
+	failures := runChecks(&report, mgr, loadErrors, mgr.(selfTester).SelfTests(), true)
+	if failures != 2 || !strings.Contains(report.String(), "ok   still_works test passes") ||
+		!strings.Contains(report.String(), "FAIL still_works test fails: result ok = true, want false") {
+		t.Errorf("unexpected report (%d failures):\n%s", failures, report.String())
+	}
+}
+
+func TestDevHandler(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	dir, _ := filepath.Abs("testdata/contract")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "contract"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(devHandler(mgr))
+	defer srv.Close()
+
+	run := func(name, body string) (int, devRunResponse) {
+		resp, err := http.Post(srv.URL+"/run/"+name, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out devRunResponse
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, out := run("log", `{"lines": 2}`)
+	if status != 200 || out.Status != tinpot.StatusSuccess || len(out.Logs) != 2 || out.Logs[1].Message != "line 2" {
+		t.Errorf("unexpected log run: %d %+v", status, out)
+	}
+	status, out = run("fail", `{"reason": "boom"}`)
+	if status != 200 || out.Status != tinpot.StatusFailure || !strings.Contains(out.Error, "boom") {
+		t.Errorf("unexpected fail run: %d %+v", status, out)
+	}
+	if status, _ = run("missing", `{}`); status != 404 {
+		t.Errorf("expected 404 for an unknown action, got %d", status)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("expected the API to be served, got %d", resp.StatusCode)
+	}
+}
+
+func TestScratchDir(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	dir, _ := filepath.Abs("testdata/scratch")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "scratch"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scratch := t.TempDir()
+	var result map[string]interface{}
+	mgr.GetAction("write_scratch")(map[string]interface{}{tinpot.ScratchDirParameter: scratch}, func(errMsg string, r map[string]interface{}) {
+		if errMsg != "" {
+			t.Errorf("action failed: %s", errMsg)
+		}
+		result = r
+	}, nil)
+	if result["dir"] != scratch {
+		t.Errorf("unexpected scratch directory: %v", result)
+	}
+	if data, _ := os.ReadFile(filepath.Join(scratch, "out.txt")); string(data) != "hello\n" {
+		t.Errorf("command not run in the scratch directory: %q", data)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// restart starts a new instance of the worker and exits, Windows can't replace the process
+func restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAs makes the command run as another user: through RUN_AS_HELPER if set (e.g.
+// "sudo -n -u {user} --"), or by switching to the user directly when the worker runs as root
+func runAs(cmd *exec.Cmd, name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("run as %s: %w", name, err)
+	}
+	if RunAsHelper != "" {
+		helper := strings.Fields(strings.ReplaceAll(RunAsHelper, "{user}", name))
+		path, err := exec.LookPath(helper[0])
+		if err != nil {
+			return fmt.Errorf("run as %s: %w", name, err)
+		}
+		// Helpers like sudo reset the environment, the variables of the runner are passed on the command line
+		args := append(helper, "env", "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+		for _, v := range cmd.Env {
+			if !slices.Contains(os.Environ(), v) {
+				args = append(args, v)
+			}
+		}
+		cmd.Path, cmd.Args = path, append(args, cmd.Args...)
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("running as %s needs a root worker or RUN_AS_HELPER", name)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groups, _ := u.GroupIds()
+	for _, g := range groups {
+		if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+			credential.Groups = append(credential.Groups, uint32(id))
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	return nil
+}
+
+// shareDir lets the user an action runs as write to a directory of the worker: it hands the
+// directory over when the worker runs as root, makes it writable for everyone otherwise
+func shareDir(dir, name string) error {
+	if os.Geteuid() != 0 {
+		return os.Chmod(dir, 0777)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("run as %s: %w", name, err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	return os.Chown(dir, uid, gid)
+}
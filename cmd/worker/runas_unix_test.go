@@ -0,0 +1,76 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+func TestRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	// The interpreter too, unlike ones installed in the home of root
+	python, err := exec.LookPath("/usr/bin/python3")
+	if err != nil {
+		t.Skip(err)
+	}
+	nobodyUID, _ := strconv.Atoi(nobody.Uid)
+	uid := float64(nobodyUID)
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	// The bundle has to be readable by the user
+	dir, _ := os.MkdirTemp("", "tinpot-runas-*")
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0755)
+	source, _ := os.ReadFile("testdata/runas/runas_actions.py")
+	os.WriteFile(filepath.Join(dir, "runas_actions.py"), source, 0644)
+
+	run := func(name string) (string, map[string]interface{}) {
+		mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "runas"}, Python: python, Dir: dir}, libPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runAs := mgr.ListActions()[name].RunAs; runAs != "nobody" {
+			t.Errorf("run_as not announced: %q", runAs)
+		}
+		var errMsg string
+		var result map[string]interface{}
+		mgr.GetAction(name)(nil, func(e string, r map[string]interface{}) { errMsg, result = e, r }, nil)
+		return errMsg, result
+	}
+	if errMsg, result := run("whoami"); errMsg != "" || result["uid"] != uid || result["home"] != nobody.HomeDir {
+		t.Errorf("not run as nobody: %s %v", errMsg, result)
+	}
+	if runtime.GOOS == "linux" {
+		if errMsg, result := run("whoami_confined"); errMsg != "" || result["uid"] != uid {
+			t.Errorf("sandboxed action not run as nobody: %s %v", errMsg, result)
+		}
+	}
+
+	if _, err := exec.LookPath("runuser"); err == nil {
+		RunAsHelper = "runuser -u {user} --"
+		defer func() { RunAsHelper = "" }()
+		if errMsg, result := run("whoami"); errMsg != "" || result["uid"] != uid {
+			t.Errorf("not run as nobody through the helper: %s %v", errMsg, result)
+		}
+		if errMsg, _ := run("whoami_confined"); errMsg == "" {
+			t.Error("read-only paths combined with the helper")
+		}
+	}
+}
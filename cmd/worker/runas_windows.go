@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func runAs(cmd *exec.Cmd, name string) error {
+	return errors.New("run_as is not supported on Windows")
+}
+
+func shareDir(dir, name string) error {
+	return errors.New("run_as is not supported on Windows")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/balazsgrill/tinpot"
+	"golang.org/x/sys/unix"
+)
+
+// deniedSyscalls stop the action with the default seccomp filter
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT, unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE, unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD, unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY, unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+}
+
+// sandbox makes the runner confine itself to the profile before loading the actions: it mounts
+// the read-only paths in a mount namespace of its own, changes to the AppArmor profile and
+// installs the seccomp filter, see tinpot/sandbox.py
+func sandbox(cmd *exec.Cmd, profile *tinpot.SandboxProfile) error {
+	setup := map[string]interface{}{"read_only": profile.ReadOnly, "apparmor": profile.AppArmor}
+	if profile.NoNetwork || profile.Seccomp != "" || len(profile.ReadOnly) > 0 {
+		filter, err := seccompFilter(profile)
+		if err != nil {
+			return err
+		}
+		setup["seccomp"] = base64.StdEncoding.EncodeToString(filter)
+	}
+	switch {
+	case len(profile.ReadOnly) == 0:
+	case cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil:
+		// A root worker running the action as another user: the runner mounts as root, then
+		// switches to the user
+		c := cmd.SysProcAttr.Credential
+		setup["user"] = map[string]interface{}{"uid": c.Uid, "gid": c.Gid, "groups": c.Groups}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	default:
+		// Mounting needs the capabilities of a user namespace, as its root mapped to the worker's user
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		}
+	}
+	data, _ := json.Marshal(setup)
+	cmd.Env = append(cmd.Env, "TINPOT_SANDBOX="+string(data))
+	return nil
+}
+
+// seccompFilter builds the BPF program of the profile, in the layout of struct sock_filter
+func seccompFilter(profile *tinpot.SandboxProfile) ([]byte, error) {
+	arch, ok := map[string]uint32{"amd64": unix.AUDIT_ARCH_X86_64, "arm64": unix.AUDIT_ARCH_AARCH64}[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("seccomp filters are not supported on %s", runtime.GOARCH)
+	}
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		ret  = unix.BPF_RET | unix.BPF_K
+		// Offsets in struct seccomp_data
+		nr, archOffset, arg0 = 0, 4, 16
+	)
+	kill := unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_KILL_PROCESS}
+	program := []unix.SockFilter{
+		{Code: load, K: archOffset},
+		{Code: jeq, Jt: 1, K: arch},
+		kill,
+		{Code: load, K: nr},
+	}
+	if runtime.GOARCH == "amd64" {
+		// The x32 ABI would get around the system call numbers
+		program = append(program, unix.SockFilter{Code: jge, Jf: 1, K: 0x40000000}, kill)
+	}
+	// Root of the user namespace could undo the read-only mounts without it
+	if profile.Seccomp != "" || len(profile.ReadOnly) > 0 {
+		for _, call := range deniedSyscalls {
+			program = append(program, unix.SockFilter{Code: jeq, Jf: 1, K: call}, kill)
+		}
+	}
+	if profile.NoNetwork {
+		// Refused rather than killed, libraries probe for IPv6 support on import
+		deny := unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)}
+		program = append(program,
+			unix.SockFilter{Code: jeq, Jf: 5, K: unix.SYS_SOCKET},
+			unix.SockFilter{Code: load, K: arg0},
+			unix.SockFilter{Code: jeq, Jt: 2, K: unix.AF_INET},
+			unix.SockFilter{Code: jeq, Jt: 1, K: unix.AF_INET6},
+			unix.SockFilter{Code: jeq, Jf: 1, K: unix.AF_PACKET},
+			deny,
+		)
+	}
+	program = append(program, unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ALLOW})
+
+	data := make([]byte, 0, 8*len(program))
+	for _, f := range program {
+		data = binary.NativeEndian.AppendUint16(data, f.Code)
+		data = append(data, f.Jt, f.Jf)
+		data = binary.NativeEndian.AppendUint32(data, f.K)
+	}
+	return data, nil
+}
+
+// sandboxDenied tells whether the seccomp filter stopped the process
+func sandboxDenied(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGSYS
+}
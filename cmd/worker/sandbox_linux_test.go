@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+func TestSandbox(t *testing.T) {
+	libPath, err := extractEmbeddedLib()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(libPath)
+	dir, _ := filepath.Abs("testdata/sandbox")
+	mgr, err := NewSubprocessActionManager(&BundleManifest{BundleManifest: tinpot.BundleManifest{Name: "sandbox"}, Python: "python3", Dir: dir}, libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := mgr.ListActions()["connect"].Sandbox; profile == nil || !profile.NoNetwork {
+		t.Errorf("sandbox profile not announced: %+v", profile)
+	}
+	run := func(name string) string {
+		done := make(chan string, 1)
+		mgr.GetAction(name)(nil, func(errMsg string, result map[string]interface{}) { done <- errMsg }, nil)
+		return <-done
+	}
+
+	for _, name := range []string{"connect", "write_bundle", "trace"} {
+		errMsg := run(name)
+		if strings.HasPrefix(errMsg, "Failed to set up the sandbox") && name == "write_bundle" {
+			t.Logf("user namespaces not available: %s", errMsg)
+			continue
+		}
+		if !strings.HasPrefix(errMsg, tinpot.SandboxDeniedError) {
+			t.Errorf("%s not denied: %q", name, errMsg)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "written.txt")); err == nil {
+		os.Remove(filepath.Join(dir, "written.txt"))
+		t.Error("read-only path written")
+	}
+	if errMsg := run("unconfined"); errMsg != "" {
+		t.Errorf("unconfined action failed: %s", errMsg)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+func sandbox(cmd *exec.Cmd, profile *tinpot.SandboxProfile) error {
+	return errors.New("sandbox profiles are only supported on Linux")
+}
+
+func sandboxDenied(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// selfTestTimeout bounds a single self-test run
+var selfTestTimeout = time.Minute
+
+// actionTest is a self-test declared with @action(tests=[...])
+type actionTest struct {
+	Title      string                 `json:"title"`
+	Parameters map[string]interface{} `json:"parameters"`
+	// Expect is a subset of the expected result
+	Expect map[string]interface{} `json:"expect,omitempty"`
+	// Error is a part of the expected error, empty when the action must succeed
+	Error string `json:"error,omitempty"`
+}
+
+// selfTester is implemented by the action managers knowing the self-tests of their actions
+type selfTester interface {
+	SelfTests() map[string][]actionTest
+}
+
+// runChecks validates the loaded actions and runs their self-tests (when runTests is set),
+// writing a report to out. It returns the number of problems found.
+func runChecks(out io.Writer, mgr tinpot.ActionManager, loadErrors []tinpot.MqttLoadError, tests map[string][]actionTest, runTests bool) int {
+	failures := 0
+	for _, loadError := range loadErrors {
+		fmt.Fprintf(out, "FAIL load %s: %s\n", loadError.Module, loadError.Error)
+		if loadError.Traceback != "" {
+			fmt.Fprintf(out, "%s\n", indent(loadError.Traceback))
+		}
+		failures++
+	}
+
+	actions := mgr.ListActions()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems := tinpot.ValidateAction(actions[name], actions)
+		for _, problem := range problems {
+			fmt.Fprintf(out, "FAIL %s: %s\n", name, problem)
+		}
+		failures += len(problems)
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "ok   %s\n", name)
+		}
+		if !runTests {
+			continue
+		}
+		for i, test := range tests[name] {
+			title := test.Title
+			if title == "" {
+				title = fmt.Sprintf("#%d", i+1)
+			}
+			if err := runSelfTest(mgr.GetAction(name), test); err != nil {
+				fmt.Fprintf(out, "FAIL %s test %s: %v\n", name, title, err)
+				failures++
+			} else {
+				fmt.Fprintf(out, "ok   %s test %s\n", name, title)
+			}
+		}
+	}
+
+	if failures > 0 {
+		fmt.Fprintf(out, "FAIL: %d problems in %d actions\n", failures, len(actions))
+	} else {
+		fmt.Fprintf(out, "PASS: %d actions\n", len(actions))
+	}
+	return failures
+}
+
+func runSelfTest(trigger tinpot.ActionTrigger, test actionTest) error {
+	type reply struct {
+		err    string
+		result map[string]interface{}
+	}
+	done := make(chan reply, 1)
+	params := make(map[string]interface{}, len(test.Parameters))
+	for k, v := range test.Parameters {
+		params[k] = v
+	}
+	go trigger(params, func(err string, result map[string]interface{}) {
+		done <- reply{err, result}
+	}, nil)
+
+	var r reply
+	select {
+	case r = <-done:
+	case <-time.After(selfTestTimeout):
+		return fmt.Errorf("timed out after %s", selfTestTimeout)
+	}
+	switch {
+	case test.Error != "" && r.err == "":
+		return fmt.Errorf("expected error %q, got result %v", test.Error, r.result)
+	case test.Error != "" && !strings.Contains(r.err, test.Error):
+		return fmt.Errorf("expected error %q, got %q", test.Error, r.err)
+	case test.Error == "" && r.err != "":
+		return fmt.Errorf("failed: %s", r.err)
+	}
+	result, _ := tinpot.NormalizeJSON(r.result).(map[string]interface{})
+	for key, want := range test.Expect {
+		if got := result[key]; !reflect.DeepEqual(tinpot.NormalizeJSON(want), got) {
+			return fmt.Errorf("result %s = %v, want %v", key, got, want)
+		}
+	}
+	return nil
+}
+
+func indent(text string) string {
+	return "    " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n    ")
+}
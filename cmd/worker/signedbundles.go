@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// The bundles extracted from signed archives, by directory and by the name of their modules
+var (
+	signedDirs    = make(map[string]*tinpot.BundleInfo)
+	signedModules = make(map[string]*tinpot.BundleInfo)
+)
+
+var bundleArchiveExtensions = []string{".tar.gz", ".tgz", ".tar"}
+
+// extractSignedBundles verifies the archives of dir against the trusted keys and extracts the
+// valid ones into a new directory, one subdirectory per bundle. Every archive needs a minisign
+// signature (<archive>.minisig) or a base64 one of cosign sign-blob (<archive>.sig); the rest
+// of dir is ignored. Rejected archives are returned as load errors.
+func extractSignedBundles(dir string, keys *tinpot.BundleKeys) (string, []tinpot.MqttLoadError, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	target, err := os.MkdirTemp("", "tinpot-worker-actions-*")
+	if err != nil {
+		return "", nil, err
+	}
+	// Readable by the run_as users of the actions
+	if err := os.Chmod(target, 0755); err != nil {
+		return "", nil, err
+	}
+	var loadErrors []tinpot.MqttLoadError
+	for _, entry := range entries {
+		name, ok := bundleArchiveName(entry.Name())
+		if entry.IsDir() || !ok {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := extractSignedBundle(path, filepath.Join(target, name), keys)
+		if err != nil {
+			log.Printf("WARNING: Rejected bundle %s: %v", entry.Name(), err)
+			loadErrors = append(loadErrors, tinpot.MqttLoadError{Module: name, Path: path, Error: err.Error()})
+			os.RemoveAll(filepath.Join(target, name))
+			continue
+		}
+		log.Printf("Verified bundle %s %s (%s, key %s)", info.Name, info.Version, info.Digest, info.KeyID)
+	}
+	return target, loadErrors, nil
+}
+
+func bundleArchiveName(file string) (string, bool) {
+	for _, ext := range bundleArchiveExtensions {
+		if strings.HasSuffix(file, ext) && len(file) > len(ext) {
+			return strings.TrimSuffix(file, ext), true
+		}
+	}
+	return "", false
+}
+
+func extractSignedBundle(path, target string, keys *tinpot.BundleKeys) (*tinpot.BundleInfo, error) {
+	archive, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keyID string
+	if sig, err := os.ReadFile(path + ".minisig"); err == nil {
+		keyID, err = keys.VerifyMinisign(archive, sig)
+		if err != nil {
+			return nil, err
+		}
+	} else if sig, err := os.ReadFile(path + ".sig"); err == nil {
+		keyID, err = keys.VerifySignature(archive, sig)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("no signature (.minisig or .sig)")
+	}
+	if err := extractTar(archive, target); err != nil {
+		return nil, err
+	}
+
+	info := &tinpot.BundleInfo{Name: filepath.Base(target), Digest: tinpot.ArchiveDigest(archive), KeyID: keyID}
+	if _, err := os.Stat(filepath.Join(target, BundleManifestFile)); err == nil {
+		manifest, err := loadBundleManifest(target)
+		if err != nil {
+			return nil, err
+		}
+		info.Name, info.Version = manifest.Name, manifest.Version
+		if err := checkRuntime(defaultPython, &manifest.BundleManifest); err != nil {
+			return nil, err
+		}
+		if err := prepareBundle(target, manifest); err != nil {
+			return nil, err
+		}
+	}
+	filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(d.Name(), ".py") {
+			signedModules[strings.TrimSuffix(d.Name(), ".py")] = info
+		}
+		return nil
+	})
+	signedDirs[target] = info
+	return info, nil
+}
+
+// extractTar unpacks a tar archive, gzip compressed or not, refusing links and entries
+// outside of the target directory
+func extractTar(archive []byte, target string) error {
+	var r io.Reader = bytes.NewReader(archive)
+	if bytes.HasPrefix(archive, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = gz
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		path := filepath.Join(target, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(header.Mode)&0755|0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return fmt.Errorf("unsupported entry in archive: %s", header.Name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+func TestSignedBundles(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	keys, err := tinpot.ParseBundleKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	bundle := func(name string, files map[string]string, sign bool) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for file, content := range files {
+			tw.WriteHeader(&tar.Header{Name: file, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(content))
+		}
+		tw.Close()
+		gz.Close()
+		os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644)
+		if sign {
+			sig := base64.StdEncoding.EncodeToString(ed25519.Sign(private, buf.Bytes()))
+			os.WriteFile(filepath.Join(dir, name+".sig"), []byte(sig), 0644)
+		}
+	}
+	bundle("ops.tar.gz", map[string]string{"bundle.json": `{"name": "ops", "version": "1.2.0"}`, "./ops/restart_service.py": "# action"}, true)
+	bundle("unsigned.tgz", map[string]string{"unsigned_action.py": "# action"}, false)
+	bundle("escape.tar.gz", map[string]string{"../escape.py": "# action"}, true)
+	bundle("tampered.tar.gz", map[string]string{"tampered_action.py": "# action"}, true)
+	os.WriteFile(filepath.Join(dir, "tampered.tar.gz"), []byte("other"), 0644)
+	os.WriteFile(filepath.Join(dir, "loose_action.py"), []byte("# action"), 0644)
+
+	target, loadErrors, err := extractSignedBundles(dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	if len(loadErrors) != 3 {
+		t.Errorf("expected the unsigned, escaping and tampered bundles rejected: %+v", loadErrors)
+	}
+	if _, err := os.Stat(filepath.Join(target, "ops", "ops", "restart_service.py")); err != nil {
+		t.Errorf("signed bundle not extracted: %v", err)
+	}
+	entries, _ := os.ReadDir(target)
+	if len(entries) != 1 {
+		t.Errorf("only the signed bundle should be extracted: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(target), "escape.py")); err == nil {
+		t.Error("archive escaped the target directory")
+	}
+
+	info := signedModules["restart_service"]
+	if info == nil || info.Name != "ops" || info.Version != "1.2.0" || info.Digest == "" || info.KeyID == "" {
+		t.Errorf("unexpected bundle info: %+v", info)
+	}
+	manifest, err := loadBundleManifest(filepath.Join(target, "ops"))
+	if err != nil || manifest.Signed != info {
+		t.Errorf("bundle manifest not linked to the signature: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// subprocessActionManager serves the actions of an isolated bundle by running
+// them with the bundle's own interpreter through the tinpot.runner module.
+type subprocessActionManager struct {
+	bundle  *BundleManifest
+	libPath string
+	actions map[string]tinpot.ActionInfo
+	// loadErrors are the modules of the bundle that failed to load
+	loadErrors []tinpot.MqttLoadError
+	tests      map[string][]actionTest
+
+	runningMu sync.Mutex
+	running   map[*exec.Cmd]bool
+}
+
+type runnerReply struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error"`
+}
+
+func NewSubprocessActionManager(bundle *BundleManifest, libPath string) (tinpot.ActionManager, error) {
+	mgr := &subprocessActionManager{
+		bundle:  bundle,
+		libPath: libPath,
+		actions: make(map[string]tinpot.ActionInfo),
+		running: make(map[*exec.Cmd]bool),
+		tests:   make(map[string][]actionTest),
+	}
+
+	var stdout bytes.Buffer
+	cmd := mgr.command("list")
+	// The modules are imported to list the actions
+	if err := confine(cmd, bundle.RunAs, bundle.Sandbox); err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", bundle.Name, err)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to list actions of bundle %s: %w", bundle.Name, err)
+	}
+
+	var list struct {
+		Actions []struct {
+			tinpot.ActionInfo
+			Tests []actionTest `json:"tests"`
+		} `json:"actions"`
+		LoadErrors []tinpot.MqttLoadError `json:"load_errors"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &list); err != nil {
+		return nil, fmt.Errorf("invalid action list from bundle %s: %w", bundle.Name, err)
+	}
+	mgr.loadErrors = list.LoadErrors
+	for _, info := range list.Actions {
+		info.Bundle = bundle.Signed
+		if info.RunAs == "" {
+			info.RunAs = bundle.RunAs
+		}
+		if info.Sandbox == nil {
+			info.Sandbox = bundle.Sandbox
+		} else if err := info.Sandbox.Validate(); err != nil {
+			mgr.loadErrors = append(mgr.loadErrors, tinpot.MqttLoadError{Module: bundle.Name, Path: bundle.Dir, Error: fmt.Sprintf("action %s: %v", info.Name, err)})
+			continue
+		}
+		mgr.actions[info.Name] = info.ActionInfo
+		if len(info.Tests) > 0 {
+			mgr.tests[info.Name] = info.Tests
+		}
+		log.Printf("Loaded action: %s (bundle %s, %s)", info.Name, bundle.Name, bundle.Interpreter())
+	}
+	return mgr, nil
+}
+
+func (mgr *subprocessActionManager) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(mgr.bundle.Interpreter(), append([]string{"-m", "tinpot.runner", args[0], mgr.bundle.Dir}, args[1:]...)...)
+	cmd.Dir = mgr.bundle.Dir
+	cmd.Env = append(os.Environ(),
+		"PYTHONPATH="+mgr.libPath+string(os.PathListSeparator)+mgr.bundle.Dir,
+		"PYTHONUNBUFFERED=1",
+	)
+	return cmd
+}
+
+// confine applies the run-as user and the sandbox profile of an action to its runner
+func confine(cmd *exec.Cmd, runAsUser string, profile *tinpot.SandboxProfile) error {
+	if runAsUser != "" {
+		if err := runAs(cmd, runAsUser); err != nil {
+			return err
+		}
+	}
+	if profile == nil {
+		return nil
+	}
+	if runAsUser != "" && RunAsHelper != "" && len(profile.ReadOnly) > 0 {
+		return errors.New("read-only sandbox paths can't be combined with RUN_AS_HELPER")
+	}
+	return sandbox(cmd, profile)
+}
+
+func (mgr *subprocessActionManager) trigger(name string) tinpot.ActionTrigger {
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		// Internal parameters (e.g. _execution_id) are not passed to the action
+		actual := make(map[string]interface{}, len(parameters))
+		for k, v := range parameters {
+			if !strings.HasPrefix(k, "_") {
+				actual[k] = v
+			}
+		}
+		input, err := json.Marshal(actual)
+		if err != nil {
+			response(fmt.Sprintf("invalid parameters: %v", err), nil)
+			return
+		}
+
+		var stdout bytes.Buffer
+		cmd := mgr.command("run", name)
+		if dir, ok := parameters[tinpot.ScratchDirParameter].(string); ok {
+			cmd.Env = append(cmd.Env, "TINPOT_SCRATCH_DIR="+dir)
+			if user := mgr.actions[name].RunAs; user != "" {
+				if err := shareDir(dir, user); err != nil {
+					response(err.Error(), nil)
+					return
+				}
+			}
+		}
+		if err := confine(cmd, mgr.actions[name].RunAs, mgr.actions[name].Sandbox); err != nil {
+			response(err.Error(), nil)
+			return
+		}
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &stdout
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			response(err.Error(), nil)
+			return
+		}
+
+		log.Printf("Triggering action %s in subprocess (bundle %s)", name, mgr.bundle.Name)
+		if err := cmd.Start(); err != nil {
+			response(fmt.Sprintf("failed to start interpreter: %v", err), nil)
+			return
+		}
+		mgr.runningMu.Lock()
+		mgr.running[cmd] = true
+		mgr.runningMu.Unlock()
+		defer func() {
+			mgr.runningMu.Lock()
+			delete(mgr.running, cmd)
+			mgr.runningMu.Unlock()
+		}()
+
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" || logs == nil {
+				continue
+			}
+			logs(parseLogLine(line))
+		}
+
+		waitErr := cmd.Wait()
+		tinpot.ReportUsage(parameters, tinpot.ResourceUsage{
+			CPUSeconds:      (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds(),
+			PeakMemoryBytes: peakMemory(cmd.ProcessState),
+		})
+		if waitErr != nil && sandboxDenied(cmd.ProcessState) {
+			response(tinpot.SandboxDeniedError+": stopped at a system call denied by the seccomp filter", nil)
+			return
+		}
+
+		var reply runnerReply
+		if err := json.Unmarshal(stdout.Bytes(), &reply); err != nil {
+			if waitErr != nil {
+				response(fmt.Sprintf("interpreter failed: %v", waitErr), nil)
+			} else {
+				response(fmt.Sprintf("invalid reply from interpreter: %v", err), nil)
+			}
+			return
+		}
+		if reply.Error != "" {
+			response(reply.Error, nil)
+			return
+		}
+
+		var result map[string]interface{}
+		if reply.Result != nil {
+			if m, ok := reply.Result.(map[string]interface{}); ok {
+				result = m
+			} else {
+				result = map[string]interface{}{"result": reply.Result}
+			}
+		}
+		log.Printf("Trigger finished, sending result")
+		response("", result)
+	}
+}
+
+func (mgr *subprocessActionManager) LoadErrors() []tinpot.MqttLoadError {
+	return mgr.loadErrors
+}
+
+func (mgr *subprocessActionManager) SelfTests() map[string][]actionTest {
+	return mgr.tests
+}
+
+// CancelRunning terminates the running interpreters, async actions are cancelled at their current await
+func (mgr *subprocessActionManager) CancelRunning() {
+	mgr.runningMu.Lock()
+	defer mgr.runningMu.Unlock()
+	for cmd := range mgr.running {
+		terminate(cmd.Process)
+	}
+}
+
+func (mgr *subprocessActionManager) GetAction(name string) tinpot.ActionTrigger {
+	if _, ok := mgr.actions[name]; !ok {
+		return nil
+	}
+	return mgr.trigger(name)
+}
+
+func (mgr *subprocessActionManager) ListActions() map[string]tinpot.ActionInfo {
+	result := make(map[string]tinpot.ActionInfo)
+	for name, act := range mgr.actions {
+		result[name] = act
+	}
+	return result
+}
+
+func (mgr *subprocessActionManager) IsConnected() bool {
+	return true
+}
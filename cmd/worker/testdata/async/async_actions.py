@@ -0,0 +1,19 @@
+"""Async actions, see TestAsyncActions."""
+import asyncio
+
+from tinpot import action, action_print
+
+
+@action()
+async def gather(count: int = 3):
+    results = await asyncio.gather(*(asyncio.sleep(0.01, result=i) for i in range(count)))
+    return {"sum": sum(results)}
+
+
+@action()
+async def wait_forever():
+    action_print("started")
+    try:
+        await asyncio.sleep(60)
+    finally:
+        action_print("cleaned up")
@@ -0,0 +1 @@
+import tinpot_no_such_module  # noqa: F401
@@ -0,0 +1,14 @@
+from tinpot import action
+
+
+@action(tests=[
+    {"title": "passes", "expect": {"ok": True}},
+    {"title": "fails", "expect": {"ok": False}},
+])
+def still_works():
+    return {"ok": True}
+
+
+@action(tests=[{"title": "raises", "error": "ValueError: nope"}])
+def rejects():
+    raise ValueError("nope")
@@ -0,0 +1,19 @@
+"""Fixture actions of the ActionManager contract tests (see tinpottest.ContractActions)."""
+from tinpot import action
+
+
+@action(description="Echo the message")
+def echo(message: str):
+    return {"message": message}
+
+
+@action(description="Fail with the given reason")
+def fail(reason: str):
+    raise RuntimeError(reason)
+
+
+@action(description="Log the given number of lines")
+def log(lines: int):
+    for i in range(1, lines + 1):
+        print(f"line {i}")
+    return {"lines": lines}
@@ -0,0 +1,15 @@
+import os
+
+from tinpot import action
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+@action(run_as="nobody")
+def whoami():
+    return {"uid": os.getuid(), "home": os.environ.get("HOME")}
+
+
+@action(run_as="nobody", sandbox={"read_only": [HERE]})
+def whoami_confined():
+    return {"uid": os.getuid()}
@@ -0,0 +1,29 @@
+import ctypes
+import os
+import socket
+
+from tinpot import action
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+@action(sandbox={"no_network": True})
+def connect():
+    socket.create_connection(("127.0.0.1", 9), timeout=1)
+
+
+@action(sandbox={"read_only": [HERE]})
+def write_bundle():
+    with open(os.path.join(HERE, "written.txt"), "w") as f:
+        f.write("escaped")
+
+
+@action(sandbox={"seccomp": "default"})
+def trace():
+    ctypes.CDLL(None).ptrace(0, 0, None, None)
+
+
+@action()
+def unconfined():
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM):
+        return {"network": True}
@@ -0,0 +1,8 @@
+"""Actions using the scratch directory, see TestScratchDir."""
+from tinpot import action, run_command, scratch_dir
+
+
+@action()
+def write_scratch():
+    run_command("echo hello > out.txt")
+    return {"dir": scratch_dir()}
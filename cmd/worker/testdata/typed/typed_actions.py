@@ -0,0 +1,30 @@
+"""Actions with rich type hints, see TestTypeHintSchemas."""
+import enum
+from dataclasses import dataclass, field
+from typing import List, Literal, Optional
+
+from tinpot import action
+
+
+class Color(enum.Enum):
+    RED = "red"
+    GREEN = "green"
+
+
+@dataclass
+class Target:
+    host: str
+    port: int = 22
+    tags: List[str] = field(default_factory=list)
+
+
+@action()
+def deploy(target: Target, mode: Literal["fast", "safe"] = "safe", colors: List[Color] = None,
+           note: Optional[str] = None):
+    return {
+        "host": target.host,
+        "port": target.port,
+        "mode": mode,
+        "colors": [c.name for c in colors or []],
+        "note_type": type(note).__name__,
+    }
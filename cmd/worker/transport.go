@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/mqtt5transport"
+	"github.com/balazsgrill/tinpot/mqtttransport"
+	"github.com/balazsgrill/tinpot/worker"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectableTransport is a transport whose connection is managed by main
+type connectableTransport interface {
+	tinpot.Transport
+	Connect() error
+}
+
+// newTransport creates the worker's connection to the brokers. The broker marks the
+// worker offline through the last will if it goes away without saying so.
+func newTransport(brokerSpec, clientID string) (connectableTransport, error) {
+	willTopic := tinpot.WorkerStatusTopic(Tenant, clientID)
+	willPayload := worker.OfflineStatus(clientID)
+	brokers, err := tinpot.ResolveBrokers(context.Background(), brokerSpec)
+	if err != nil {
+		return nil, err
+	}
+	switch MQTTVersion {
+	case "3":
+		opts := mqtt.NewClientOptions()
+		for _, broker := range brokers {
+			opts.AddBroker(broker.String())
+		}
+		opts.SetClientID(clientID)
+		opts.SetAutoReconnect(true)
+		opts.SetWill(willTopic, string(willPayload), 1, true)
+		opts.SetOnConnectHandler(func(c mqtt.Client) {
+			log.Println("Connected to MQTT Broker")
+		})
+		return mqtttransport.New(opts), nil
+	case "5":
+		cfg := autopaho.ClientConfig{ServerUrls: brokers, KeepAlive: 30}
+		cfg.ClientID = clientID
+		cfg.WillMessage = &paho.WillMessage{Topic: willTopic, Payload: willPayload, QoS: 1, Retain: true}
+		cfg.OnConnectionUp = func(*autopaho.ConnectionManager, *paho.Connack) {
+			log.Println("Connected to MQTT Broker")
+		}
+		return mqtt5transport.New(cfg), nil
+	}
+	return nil, fmt.Errorf("unsupported MQTT_VERSION %q", MQTTVersion)
+}
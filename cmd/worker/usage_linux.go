@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// peakMemory is the maximum resident set size of an exited process
+func peakMemory(state *os.ProcessState) uint64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// Reported in kilobytes
+		return uint64(usage.Maxrss) << 10
+	}
+	return 0
+}
+
+// threadCPUTime is the CPU time consumed by the calling OS thread so far
+func threadCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_THREAD, &usage) != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
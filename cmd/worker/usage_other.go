@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// peakMemory is only measured on Linux
+func peakMemory(state *os.ProcessState) uint64 {
+	return 0
+}
+
+// threadCPUTime is only measured on Linux
+func threadCPUTime() time.Duration {
+	return 0
+}
@@ -120,6 +120,37 @@ func TestEndToEnd(t *testing.T) {
 		return ok
 	}, 30*time.Second, 1*time.Second, "Actions not discovered in time")
 
+	// Action documentation
+	resp, err := http.Get(apiURL + "/api/actions/clean_cache/docs")
+	require.NoError(t, err)
+	var docs struct {
+		Docs     string `json:"docs"`
+		Examples []struct {
+			Title   string `json:"title"`
+			Request string `json:"request"`
+		} `json:"examples"`
+	}
+	json.NewDecoder(resp.Body).Decode(&docs)
+	resp.Body.Close()
+	assert.Contains(t, docs.Docs, "Clean cache files older than N days.")
+	require.Len(t, docs.Examples, 2)
+	assert.Contains(t, docs.Examples[0].Request, `"days": 7`)
+
+	// Parameter form schema
+	resp, err = http.Get(apiURL + "/api/actions/deploy_app/schema")
+	require.NoError(t, err)
+	var schema struct {
+		Schema struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+		} `json:"schema"`
+		UISchema map[string]interface{} `json:"uiSchema"`
+	}
+	json.NewDecoder(resp.Body).Decode(&schema)
+	resp.Body.Close()
+	assert.Equal(t, "boolean", schema.Schema.Properties["skip_tests"]["type"])
+	assert.Equal(t, []interface{}{"staging", "production"}, schema.Schema.Properties["environment"]["enum"])
+	assert.Equal(t, []interface{}{"environment", "skip_tests"}, schema.UISchema["ui:order"])
+
 	// 6. Execute Action (Sync)
 	payload := map[string]interface{}{
 		"parameters": map[string]interface{}{
@@ -128,7 +159,7 @@ func TestEndToEnd(t *testing.T) {
 	}
 	payloadBytes, _ := json.Marshal(payload)
 
-	resp, err := http.Post(
+	resp, err = http.Post(
 		apiURL+"/api/actions/clean_cache/sync_execute",
 		"application/json",
 		bytes.NewBuffer(payloadBytes),
@@ -224,6 +255,7 @@ func TestEndToEnd(t *testing.T) {
 	// Verify Logs
 	assert.Contains(t, streamOutput, "Starting health check")
 	assert.Contains(t, streamOutput, "[1/3] Checking system health")
+	assert.Contains(t, streamOutput, `"type":"progress","data":{"current":1,"total":3,"message":"Checking system health"}`)
 
 	// Verify Completion
 	// SSE format: data: {"type": "complete", "data": ...}
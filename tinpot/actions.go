@@ -1,5 +1,14 @@
 package tinpot
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+)
+
 type ActionResponse func(error string, result map[string]interface{})
 type ActionLogs func(level string, message string)
 
@@ -7,8 +16,55 @@ type ActionLogs func(level string, message string)
 type ActionTrigger func(parameters map[string]interface{}, response ActionResponse, logs ActionLogs)
 
 type ParameterInfo struct {
-	Type    string      `json:"type"`
-	Default interface{} `json:"default"`
+	Type     string       `json:"type"`
+	Default  interface{}  `json:"default"`
+	Required bool         `json:"required"`
+	UI       *ParameterUI `json:"ui,omitempty"`
+	// Enum restricts the value to fixed values, e.g. of a Literal or Enum type hint
+	Enum []interface{} `json:"enum,omitempty"`
+	// Items describes the elements of list parameters
+	Items *ParameterInfo `json:"items,omitempty"`
+	// Properties describes the fields of object parameters (dataclasses, pydantic models)
+	Properties map[string]ParameterInfo `json:"properties,omitempty"`
+}
+
+// ParameterUI carries hints for form generators
+type ParameterUI struct {
+	// Widget overrides the input type derived from the parameter type (e.g. textarea, password, select)
+	Widget      string `json:"widget,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Placeholder string `json:"placeholder,omitempty"`
+	Help        string `json:"help,omitempty"`
+	// Order is the position of the parameter in the form
+	Order int `json:"order"`
+	// Group is the form section the parameter is shown in
+	Group string `json:"group,omitempty"`
+	// Choices restricts the value to a set of options
+	Choices []interface{} `json:"choices,omitempty"`
+	// ChoicesFrom takes the choices from the result of another action instead
+	ChoicesFrom *ChoiceSource `json:"choices_from,omitempty"`
+	// VisibleIf shows the parameter only when the other parameters have the given values
+	VisibleIf map[string]interface{} `json:"visible_if,omitempty"`
+}
+
+// ChoiceSource is a read-only action of the same tenant listing the allowed values of a parameter,
+// resolved by the coordinator on GET /api/actions/{name}/parameters/{param}/options
+type ChoiceSource struct {
+	Action     string                 `json:"action"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Field is the result field holding the list of values, "options" by default
+	Field string `json:"field,omitempty"`
+	// TTL is how long the values are cached in seconds, 60 by default
+	TTL int `json:"ttl,omitempty"`
+}
+
+// ActionExample is a sample invocation of an action, shown in its documentation
+type ActionExample struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	// Result is what the action returns for the parameters, answered in mock mode
+	Result map[string]interface{} `json:"result,omitempty"`
 }
 
 type ActionInfo struct {
@@ -16,6 +72,73 @@ type ActionInfo struct {
 	Description string                   `json:"description"`
 	Group       string                   `json:"group"`
 	Parameters  map[string]ParameterInfo `json:"parameters"`
+	Tenant      string                   `json:"tenant,omitempty"`
+	// Docs is a long-form markdown help text
+	Docs     string          `json:"docs,omitempty"`
+	Examples []ActionExample `json:"examples,omitempty"`
+	// Translations maps locales to localized metadata
+	Translations map[string]LocalizedText `json:"translations,omitempty"`
+	// AliasOf is the name of the action an alias stands for, see Alias
+	AliasOf string `json:"alias_of,omitempty"`
+	// Bundle is the signed archive the action was loaded from, if any
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+	// Sandbox the action runs in, if any
+	Sandbox *SandboxProfile `json:"sandbox,omitempty"`
+	// RunAs is the user the worker runs the action as, empty for the worker's own
+	RunAs string `json:"run_as,omitempty"`
+	// Site is the federated coordinator running the action, empty for local ones
+	Site string `json:"site,omitempty"`
+	// Selector of the workers eligible to run the action, see ParseSelector
+	Selector string `json:"selector,omitempty"`
+	// Debounce is the window in seconds in which identical requests share one execution
+	Debounce float64 `json:"debounce,omitempty"`
+	// Capabilities the workers announced for the action, nil for workers predating them
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Canary is set when a canary version of the action is announced besides this one
+	Canary *CanaryInfo `json:"canary,omitempty"`
+}
+
+// CanaryInfo describes the canary version of an action
+type CanaryInfo struct {
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+}
+
+// Release tracks of the workers, see MqttAction.Track
+const (
+	TrackStable = "stable"
+	TrackCanary = "canary"
+)
+
+// TrackParameter is the internal parameter choosing the release track of an execution,
+// TrackStable or TrackCanary
+const TrackParameter = "_track"
+
+// CanaryName is the name the canary version of an action is announced under, so it does not
+// replace the retained announcement of the stable one
+func CanaryName(name string) string {
+	return name + "@" + TrackCanary
+}
+
+// Capabilities of the workers, announced with their actions
+const (
+	// CapabilityCancel is for executions the worker can stop on request
+	CapabilityCancel = "cancel"
+	// CapabilityProgress is for progress updates, see LogLevelProgress
+	CapabilityProgress = "progress"
+	// CapabilityArtifacts is for scratch directories kept for download, see ScratchFetcher
+	CapabilityArtifacts = "artifacts"
+	// CapabilityCompression is for compressed results and logs, see Encodings
+	CapabilityCompression = "compression"
+)
+
+// Supports tells whether the workers of the action announced the capability. Workers
+// predating the announcement are assumed to have the ones they always had, all but
+// cancellation.
+func (a ActionInfo) Supports(capability string) bool {
+	if a.Capabilities == nil {
+		return capability != CapabilityCancel
+	}
+	return slices.Contains(a.Capabilities, capability)
 }
 
 type ActionManager interface {
@@ -29,17 +152,363 @@ type MqttAction struct {
 	Group        string                   `json:"group"`
 	Parameters   map[string]ParameterInfo `json:"parameters"`
 	TriggerTopic string                   `json:"trigger_topic"`
+	Docs         string                   `json:"docs,omitempty"`
+	Examples     []ActionExample          `json:"examples,omitempty"`
+	Translations map[string]LocalizedText `json:"translations,omitempty"`
+	// ExecPrefix is where the coordinator puts the result, log and ack topics of the
+	// executions (e.g. tinpot/workers/{id}/exec/), empty for the shared {prefix}exec/ tree
+	ExecPrefix string `json:"exec_prefix,omitempty"`
+	// Encodings the worker can compress results and logs with, see CompressPayload
+	Encodings []string `json:"encodings,omitempty"`
+	// Formats the worker can take triggers and send results and logs in, see MarshalPayload
+	Formats []string `json:"formats,omitempty"`
+	// SchemaVersion is the newest message envelope the worker reads, see Envelope
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// Bundle is the signed archive the action was loaded from, if any
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+	// Sandbox the action runs in, if any
+	Sandbox *SandboxProfile `json:"sandbox,omitempty"`
+	// RunAs is the user the worker runs the action as, empty for the worker's own
+	RunAs string `json:"run_as,omitempty"`
+	// Selector of the workers eligible to run the action, see ParseSelector
+	Selector string `json:"selector,omitempty"`
+	// Debounce is the window in seconds in which identical requests share one execution
+	Debounce float64 `json:"debounce,omitempty"`
+	// Capabilities of the worker for the action, see ActionInfo.Supports
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Track is TrackCanary for the canary version of the action, announced under its
+	// CanaryName. Empty for the stable one.
+	Track string `json:"track,omitempty"`
 }
 
 const (
 	MQTT_TOPIC_PREFIX = "tinpot/actions/"
 )
 
+// Topic layouts of the worker's trigger and execution topics
+const (
+	// TopicLayoutShared triggers on {prefix}actions/{name}/trigger, executions under {prefix}exec/
+	TopicLayoutShared = "shared"
+	// TopicLayoutWorker nests the triggers ({prefix}workers/{id}/actions/{name}/trigger) and the
+	// executions ({prefix}workers/{id}/exec/) under the worker, so broker ACLs can be per worker
+	TopicLayoutWorker = "worker"
+)
+
+// ExpiredError is the error of the executions not started before their deadline
+const ExpiredError = "Expired before a worker picked it up"
+
+// SandboxDeniedError starts the error of the executions stopped by their sandbox profile
+const SandboxDeniedError = "SANDBOX_DENIED"
+
+// ScratchQuotaError starts the error of the executions whose scratch directory outgrew the
+// quota of the worker
+const ScratchQuotaError = "SCRATCH_QUOTA_EXCEEDED"
+
+// ScratchDirParameter is the internal parameter passing the scratch directory of an execution
+// to the runtime of the worker
+const ScratchDirParameter = "_scratch_dir"
+
+// UsageParameter is the internal parameter holding the UsageReport of an execution. Action
+// managers that can measure their executions report the usage through it before responding.
+const UsageParameter = "_report_usage"
+
+// UsageReport takes the resources an execution used
+type UsageReport func(ResourceUsage)
+
+// ResourceUsage is what an execution consumed on its worker
+type ResourceUsage struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	CPUSeconds      float64 `json:"cpu_seconds,omitempty"`
+	PeakMemoryBytes uint64  `json:"peak_memory_bytes,omitempty"`
+}
+
+// ReportUsage passes the usage to the UsageReport of the parameters, if any
+func ReportUsage(parameters map[string]interface{}, usage ResourceUsage) {
+	if report, ok := parameters[UsageParameter].(UsageReport); ok {
+		report(usage)
+	}
+}
+
+// EnvironmentParameter is the internal parameter holding the EnvironmentReport of an execution
+const EnvironmentParameter = "_report_environment"
+
+// EnvironmentReport takes the environment an execution ran in
+type EnvironmentReport func(ExecutionEnvironment)
+
+// ExecutionEnvironment tells which code produced the result of an execution, attached to
+// the result by the worker
+type ExecutionEnvironment struct {
+	Hostname string `json:"hostname,omitempty"`
+	WorkerID string `json:"worker_id,omitempty"`
+	// Bundle is the signed archive the action was loaded from, if any
+	Bundle        *BundleInfo `json:"bundle,omitempty"`
+	PythonVersion string      `json:"python_version,omitempty"`
+	// GitCommit of the actions repository the worker runs, if it is a git checkout
+	GitCommit string `json:"git_commit,omitempty"`
+	// Mock is set for the canned results of the coordinator's mock mode
+	Mock bool `json:"mock,omitempty"`
+}
+
+// ReportEnvironment passes the environment to the EnvironmentReport of the parameters, if any
+func ReportEnvironment(parameters map[string]interface{}, environment ExecutionEnvironment) {
+	if report, ok := parameters[EnvironmentParameter].(EnvironmentReport); ok {
+		report(environment)
+	}
+}
+
+// TimingParameter is the internal parameter holding the TimingReport of an execution
+const TimingParameter = "_report_timing"
+
+// TimingReport takes the clock readings of the worker, reported with StartedAt alone when it
+// picks the execution up, then with both times along with the result
+type TimingReport func(WorkerTimes)
+
+// WorkerTimes are read from the worker's clock, which may be skewed against the coordinator's
+type WorkerTimes struct {
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ReportTiming passes the times to the TimingReport of the parameters, if any
+func ReportTiming(parameters map[string]interface{}, times WorkerTimes) {
+	if report, ok := parameters[TimingParameter].(TimingReport); ok {
+		report(times)
+	}
+}
+
+// PublishParameter is the internal parameter holding the PublishReport of an execution
+const PublishParameter = "_report_publish"
+
+// PublishReport takes the time the request of an execution was handed to the broker, reported
+// once the broker took it
+type PublishReport func(time.Time)
+
+// ReportPublish passes the time to the PublishReport of the parameters, if any
+func ReportPublish(parameters map[string]interface{}, at time.Time) {
+	if report, ok := parameters[PublishParameter].(PublishReport); ok {
+		report(at)
+	}
+}
+
+// SandboxProfile restricts the process of an action run by a subprocess runtime
+type SandboxProfile struct {
+	// NoNetwork denies opening IP sockets
+	NoNetwork bool `json:"no_network,omitempty"`
+	// ReadOnly paths are mounted read-only for the action
+	ReadOnly []string `json:"read_only,omitempty"`
+	// Seccomp "default" stops the action on the system calls it has no business with, like
+	// ptrace, mount or loading kernel modules
+	Seccomp string `json:"seccomp,omitempty"`
+	// AppArmor is a profile loaded on the host, which the action is confined to
+	AppArmor string `json:"apparmor,omitempty"`
+}
+
+// Validate checks the profile of an action
+func (p *SandboxProfile) Validate() error {
+	if p.Seccomp != "" && p.Seccomp != "default" {
+		return fmt.Errorf("unknown seccomp filter %q", p.Seccomp)
+	}
+	for _, dir := range p.ReadOnly {
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("read-only path %q is not absolute", dir)
+		}
+	}
+	return nil
+}
+
+// Execution Request Payload, published on the trigger topic
+type MqttExecutionRequest struct {
+	ExecutionID string                 `json:"execution_id"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	ResultTopic string                 `json:"result_topic"`
+	LogTopic    string                 `json:"log_topic"`
+	// AcceptedTopic is where the worker publishes an MqttAccepted when it starts the execution
+	AcceptedTopic string `json:"accepted_topic,omitempty"`
+	// AckTopic is where the coordinator confirms the result, the worker retries until then.
+	// Empty when the coordinator does not send acknowledgements.
+	AckTopic string `json:"ack_topic,omitempty"`
+	// TraceID correlates the execution with the caller's distributed trace, optional
+	TraceID string `json:"trace_id,omitempty"`
+	// ContentEncoding of the result and log messages, chosen by the coordinator from the
+	// announced encodings. Empty for plain JSON.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// ContentFormat of the request, result and log messages, chosen by the coordinator from
+	// the announced formats. Empty for JSON.
+	ContentFormat string `json:"content_format,omitempty"`
+	// SchemaVersion of the Envelope the request came in, the worker answers in the same one
+	SchemaVersion int `json:"-"`
+	// BatchLogs lets the worker publish several log entries as one JSON array
+	BatchLogs bool `json:"batch_logs,omitempty"`
+	// ExpiresAt is the deadline of the execution, workers receiving the request later
+	// answer with ExpiredError instead of running it
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// SignedAt (Unix seconds) and Signature are set when the coordinator signs its requests, see SigningKey
+	SignedAt  int64  `json:"signed_at,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Worker presence, retained on {prefix}workers/{id}. Workers going offline replace it
+// with Online false, either themselves or through their last will.
+type MqttWorkerStatus struct {
+	ID      string   `json:"id"`
+	Online  bool     `json:"online"`
+	Actions []string `json:"actions,omitempty"`
+	Since   string   `json:"since,omitempty"`
+	// Labels are matched by the selectors of the executions, see ParseSelector
+	Labels map[string]string `json:"labels,omitempty"`
+	// TopicLayout of the worker. Workers announcing it also take the executions published on
+	// their WorkerTriggerTopic, so the coordinator can pick one.
+	TopicLayout string `json:"topic_layout,omitempty"`
+	// Track is TrackCanary for workers running the canary versions of their actions
+	Track string `json:"track,omitempty"`
+}
+
+// MqttSyncRequest asks workers to update their actions from their git repository, published
+// on WorkerSyncTopic or SyncTopic
+type MqttSyncRequest struct {
+	// Ref that changed (e.g. refs/heads/main), workers following another branch ignore the
+	// request. Empty updates regardless of the branch.
+	Ref string `json:"ref,omitempty"`
+}
+
+// WorkerSyncer is implemented by action managers that can send MqttSyncRequest commands
+type WorkerSyncer interface {
+	// SyncWorkers asks a worker of the tenant, or all of them when workerID is empty
+	SyncWorkers(tenant, workerID, ref string) error
+}
+
+// RetainedMessage is a retained message the coordinator saw on the broker
+type RetainedMessage struct {
+	Topic  string    `json:"topic"`
+	Size   int       `json:"size"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+// InvalidMessages counts the messages of a tenant the coordinator dropped since connecting,
+// as malformed or on unexpected topics
+type InvalidMessages struct {
+	Total int `json:"total"`
+	// ByKind counts them by the Kind of MqttDiagnostic
+	ByKind map[string]int `json:"by_kind"`
+	// ByWorker counts those of known workers
+	ByWorker map[string]int `json:"by_worker"`
+	// Samples are the latest ones, newest first
+	Samples []MqttDiagnostic `json:"samples"`
+}
+
+// BrokerAdmin is implemented by action managers that can inspect and clean up the state the
+// protocol leaves on the broker
+type BrokerAdmin interface {
+	// RetainedMessages lists the retained messages of the tenant seen since connecting
+	RetainedMessages(tenant string) []RetainedMessage
+	// PurgeExecution clears the retained result and log of an execution of the tenant,
+	// returning the cleared topics
+	PurgeExecution(tenant, executionID string) ([]string, error)
+	// Rescan asks every worker of the tenant to announce its actions and status again
+	Rescan(tenant string) error
+	// InvalidMessages counts the messages of the tenant dropped since connecting
+	InvalidMessages(tenant string) InvalidMessages
+}
+
+// SiteState is the health of a coordinator federated into this one
+type SiteState struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Status is "healthy", "unhealthy" (the site reports a problem) or "unreachable"
+	Status  string    `json:"status"`
+	Detail  string    `json:"detail,omitempty"`
+	Actions int       `json:"actions"`
+	Checked time.Time `json:"checked"`
+}
+
+// SiteReporter is implemented by action managers serving the actions of other coordinators
+type SiteReporter interface {
+	Sites() []SiteState
+}
+
+// MqttInstallRequest deploys a bundle to the actions directory of workers, published on
+// WorkerInstallTopic or InstallTopic. Workers restart to load it.
+type MqttInstallRequest struct {
+	ID string `json:"id"`
+	// Archive of the bundle, a tar file (gzip compressed or not) with a bundle.json manifest
+	Archive []byte `json:"archive"`
+	// Signature of the archive, a minisign signature or the base64 one of cosign sign-blob.
+	// Required by workers verifying bundles.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// MqttInstallResult answers an MqttInstallRequest on WorkerInstallResultTopic
+type MqttInstallResult struct {
+	ID       string      `json:"id"`
+	WorkerID string      `json:"worker_id"`
+	Bundle   *BundleInfo `json:"bundle,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// MqttScratchRequest asks the workers of a tenant for the scratch directory of an execution,
+// published on ScratchTopic. Only the worker keeping the directory answers.
+type MqttScratchRequest struct {
+	ExecutionID string `json:"execution_id"`
+	// ReplyTopic receives the MqttScratchArchive, under ScratchTopic
+	ReplyTopic string `json:"reply_topic"`
+}
+
+// MqttScratchArchive answers an MqttScratchRequest
+type MqttScratchArchive struct {
+	WorkerID string `json:"worker_id"`
+	// Archive of the directory, a gzip compressed tar file
+	Archive []byte `json:"archive,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ScratchFetcher is implemented by action managers that can download scratch directories
+type ScratchFetcher interface {
+	// FetchScratch returns the scratch directory of an execution as gzip compressed tar file,
+	// ErrScratchNotFound if no worker answered before ctx was done
+	FetchScratch(ctx context.Context, tenant, executionID string) ([]byte, error)
+}
+
+// ErrScratchNotFound is returned for executions whose scratch directory no worker keeps
+var ErrScratchNotFound = errors.New("scratch directory not found")
+
+// Worker resource usage, published periodically on {prefix}workers/{id}/telemetry
+type MqttWorkerTelemetry struct {
+	Timestamp string `json:"timestamp"`
+	// CPUPercent is the CPU usage of the worker process since the previous sample, 100 per busy core
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+	MemoryTotal uint64  `json:"memory_total,omitempty"`
+	DiskFree    uint64  `json:"disk_free,omitempty"`
+	DiskTotal   uint64  `json:"disk_total,omitempty"`
+	Goroutines  int     `json:"goroutines"`
+	// Python holds runtime specific statistics of the worker's interpreter
+	Python map[string]interface{} `json:"python,omitempty"`
+}
+
+// Action module that failed to load, retained on {prefix}workers/{id}/load_errors as a list
+type MqttLoadError struct {
+	Module string `json:"module"`
+	Path   string `json:"path,omitempty"`
+	// Error is the exception, e.g. "ModuleNotFoundError: No module named 'requests'"
+	Error     string `json:"error"`
+	Traceback string `json:"traceback,omitempty"`
+}
+
 // Log Entry
 type MqttLogEntry struct {
 	Timestamp string `json:"timestamp"`
 	Level     string `json:"level"`
 	Message   string `json:"message"`
+	// Sequence numbers the log entries of an execution from 1, so the coordinator can restore
+	// their order. Zero from workers predating them.
+	Sequence uint64 `json:"seq,omitempty"`
+}
+
+// MqttAccepted tells the coordinator that a worker picked an execution up
+type MqttAccepted struct {
+	WorkerID string `json:"worker_id,omitempty"`
+	// AcceptedAt (RFC 3339) is read from the worker's clock
+	AcceptedAt string `json:"accepted_at"`
 }
 
 // Result Entry
@@ -47,4 +516,12 @@ type MqttResultResponse struct {
 	Status string      `json:"status"`
 	Result interface{} `json:"result"`
 	Error  string      `json:"error,omitempty"`
+	// StartedAt (RFC 3339) is when the worker started the execution, by its clock
+	StartedAt string `json:"started_at,omitempty"`
+	// CompletedAt (RFC 3339) tells janitors the age of retained results
+	CompletedAt string `json:"completed_at,omitempty"`
+	// Usage is measured by the worker, CPU time and memory when its runtime reports them
+	Usage *ResourceUsage `json:"usage,omitempty"`
+	// Environment is the worker and code version that produced the result
+	Environment *ExecutionEnvironment `json:"environment,omitempty"`
 }
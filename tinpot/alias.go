@@ -0,0 +1,171 @@
+package tinpot
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Alias exposes an action under another name, e.g. "restart-webserver" for svc_restart
+// with the service pinned to nginx. Aliases live in the tenant of the aliased action.
+type Alias struct {
+	// Action is the name of the aliased action within the alias' tenant
+	Action      string `json:"action"`
+	Description string `json:"description,omitempty"`
+	Group       string `json:"group,omitempty"`
+	// Parameters are always sent with these values and hidden from the alias' parameters
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Defaults replace the defaults of the action, callers can still override them
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+	// Replace hides the aliased action, so the alias renames it
+	Replace bool `json:"replace,omitempty"`
+}
+
+// LoadAliases reads aliases keyed by qualified name from a JSON file:
+//
+//	{"restart-webserver": {"action": "svc_restart", "parameters": {"service": "nginx"}}}
+func LoadAliases(path string) (map[string]Alias, error) {
+	var aliases map[string]Alias
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &aliases)
+	return aliases, err
+}
+
+// AliasManager is an ActionManager that serves aliases over the actions of another one
+type AliasManager struct {
+	ActionManager
+	mu      sync.RWMutex
+	aliases map[string]Alias
+}
+
+func NewAliasManager(mgr ActionManager, aliases map[string]Alias) *AliasManager {
+	m := &AliasManager{ActionManager: mgr, aliases: make(map[string]Alias)}
+	for name, alias := range aliases {
+		m.aliases[name] = alias
+	}
+	return m
+}
+
+// SetAlias adds or replaces the alias with the given qualified name
+func (m *AliasManager) SetAlias(qualified string, alias Alias) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aliases[qualified] = alias
+}
+
+func (m *AliasManager) RemoveAlias(qualified string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.aliases, qualified)
+}
+
+// Aliases returns the configured aliases by qualified name
+func (m *AliasManager) Aliases() map[string]Alias {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]Alias, len(m.aliases))
+	for name, alias := range m.aliases {
+		result[name] = alias
+	}
+	return result
+}
+
+// target returns the qualified name of the aliased action
+func (alias Alias) target(qualified string) string {
+	tenant, _ := SplitQualifiedName(qualified)
+	return QualifiedName(tenant, alias.Action)
+}
+
+// replaced tells whether an alias hides the action. Must be called with mu held.
+func (m *AliasManager) replaced(qualified string) bool {
+	for name, alias := range m.aliases {
+		if alias.Replace && alias.target(name) == qualified {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *AliasManager) ListActions() map[string]ActionInfo {
+	actions := m.ActionManager.ListActions()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]ActionInfo, len(actions)+len(m.aliases))
+	for qualified, act := range actions {
+		if !m.replaced(qualified) {
+			result[qualified] = act
+		}
+	}
+	for qualified, alias := range m.aliases {
+		if act, ok := actions[alias.target(qualified)]; ok {
+			result[qualified] = alias.info(qualified, act)
+		}
+	}
+	return result
+}
+
+func (alias Alias) info(qualified string, act ActionInfo) ActionInfo {
+	_, act.Name = SplitQualifiedName(qualified)
+	act.AliasOf = alias.Action
+	if alias.Description != "" {
+		act.Description = alias.Description
+	}
+	if alias.Group != "" {
+		act.Group = alias.Group
+	}
+	parameters := make(map[string]ParameterInfo, len(act.Parameters))
+	for name, param := range act.Parameters {
+		if _, pinned := alias.Parameters[name]; pinned {
+			continue
+		}
+		if value, ok := alias.Defaults[name]; ok {
+			param.Default = value
+			param.Required = false
+		}
+		parameters[name] = param
+	}
+	act.Parameters = parameters
+	if alias.Description != "" && len(act.Translations) > 0 {
+		// The translated descriptions belong to the aliased action
+		translations := make(map[string]LocalizedText, len(act.Translations))
+		for locale, text := range act.Translations {
+			text.Description = ""
+			translations[locale] = text
+		}
+		act.Translations = translations
+	}
+	return act
+}
+
+func (m *AliasManager) GetAction(name string) ActionTrigger {
+	m.mu.RLock()
+	alias, ok := m.aliases[name]
+	replaced := m.replaced(name)
+	m.mu.RUnlock()
+	if !ok {
+		if replaced {
+			return nil
+		}
+		return m.ActionManager.GetAction(name)
+	}
+	trigger := m.ActionManager.GetAction(alias.target(name))
+	if trigger == nil {
+		return nil
+	}
+	return func(parameters map[string]interface{}, response ActionResponse, logs ActionLogs) {
+		merged := make(map[string]interface{}, len(alias.Defaults)+len(parameters)+len(alias.Parameters))
+		for k, v := range alias.Defaults {
+			merged[k] = v
+		}
+		for k, v := range parameters {
+			merged[k] = v
+		}
+		for k, v := range alias.Parameters {
+			merged[k] = v
+		}
+		trigger(merged, response, logs)
+	}
+}
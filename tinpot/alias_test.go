@@ -0,0 +1,53 @@
+package tinpot_test
+
+import (
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestAliasManager(t *testing.T) {
+	actions := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "svc_restart", Parameters: map[string]tinpot.ParameterInfo{
+			"service": {Type: "str", Required: true},
+			"timeout": {Type: "int", Default: 10},
+		}}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "cleanup", Tenant: "team-a"}, tinpottest.Echo())
+	mgr := tinpot.NewAliasManager(actions, map[string]tinpot.Alias{
+		"restart-webserver": {Action: "svc_restart", Description: "Restart nginx",
+			Parameters: map[string]interface{}{"service": "nginx"},
+			Defaults:   map[string]interface{}{"timeout": 30}},
+		"team-a/purge": {Action: "cleanup", Replace: true},
+		"dangling":     {Action: "missing"},
+	})
+
+	list := mgr.ListActions()
+	alias, ok := list["restart-webserver"]
+	if !ok || alias.AliasOf != "svc_restart" || alias.Description != "Restart nginx" {
+		t.Fatalf("unexpected alias: %+v", alias)
+	}
+	if _, ok := alias.Parameters["service"]; ok || alias.Parameters["timeout"].Default != 30 {
+		t.Errorf("unexpected alias parameters: %+v", alias.Parameters)
+	}
+	if _, ok := list["svc_restart"]; !ok {
+		t.Error("aliased action should still be listed")
+	}
+	if _, ok := list["team-a/cleanup"]; ok || list["team-a/purge"].Tenant != "team-a" {
+		t.Errorf("renamed action should replace the original: %v", list)
+	}
+	if _, ok := list["dangling"]; ok || mgr.GetAction("dangling") != nil {
+		t.Error("alias of a missing action should not be available")
+	}
+	if mgr.GetAction("team-a/cleanup") != nil {
+		t.Error("renamed action should not be executable under its old name")
+	}
+
+	done := make(chan struct{})
+	mgr.GetAction("restart-webserver")(map[string]interface{}{"service": "sshd"}, func(string, map[string]interface{}) { close(done) }, nil)
+	<-done
+	calls := actions.Calls("svc_restart")
+	if len(calls) != 1 || calls[0]["service"] != "nginx" || calls[0]["timeout"] != 30 {
+		t.Errorf("unexpected call: %v", calls)
+	}
+}
@@ -0,0 +1,81 @@
+// Package broker embeds an MQTT broker (mochi-mqtt) for small installations, where the
+// coordinator serves the workers without a separate broker process.
+package broker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// Options of the embedded broker
+type Options struct {
+	// Address of the MQTT listener, ":1883" by default
+	Address string
+	// TLSCert and TLSKey are PEM files; with them the listener only accepts TLS connections
+	TLSCert string
+	TLSKey  string
+	// WebsocketAddress adds an MQTT over websocket listener (with the same TLS settings)
+	WebsocketAddress string
+	// Logger of the broker, discarded when nil
+	Logger *slog.Logger
+}
+
+// Broker is a running embedded broker
+type Broker struct {
+	server *mqttserver.Server
+	local  string
+}
+
+// Start runs the broker in the background. Besides the configured listeners it listens on a
+// loopback port for the clients of this process, see URL.
+func Start(opts Options) (*Broker, error) {
+	if opts.Address == "" {
+		opts.Address = ":1883"
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	var tlsConfig *tls.Config
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the broker certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	server := mqttserver.New(&mqttserver.Options{Logger: opts.Logger})
+	server.AddHook(new(auth.AllowHook), nil)
+	local := listeners.NewTCP(listeners.Config{ID: "local", Address: "127.0.0.1:0"})
+	all := []listeners.Listener{local, listeners.NewTCP(listeners.Config{ID: "tcp", Address: opts.Address, TLSConfig: tlsConfig})}
+	if opts.WebsocketAddress != "" {
+		all = append(all, listeners.NewWebsocket(listeners.Config{ID: "ws", Address: opts.WebsocketAddress, TLSConfig: tlsConfig}))
+	}
+	for _, l := range all {
+		if err := server.AddListener(l); err != nil {
+			server.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", l.Address(), err)
+		}
+	}
+	if err := server.Serve(); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return &Broker{server: server, local: local.Address()}, nil
+}
+
+// URL is the plain loopback URL the clients in this process connect to
+func (b *Broker) URL() string {
+	return "tcp://" + b.local
+}
+
+// Close disconnects the clients and stops the listeners
+func (b *Broker) Close() error {
+	return b.server.Close()
+}
@@ -0,0 +1,45 @@
+package broker_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot/broker"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestTLSBroker(t *testing.T) {
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), DNSNames: []string{"localhost"}}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	os.WriteFile(dir+"/cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600)
+	os.WriteFile(dir+"/key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	if _, err := broker.Start(broker.Options{Address: "127.0.0.1:0", TLSCert: dir + "/cert.pem"}); err == nil {
+		t.Error("started without the TLS key")
+	}
+	b, err := broker.Start(broker.Options{Address: "127.0.0.1:0", TLSCert: dir + "/cert.pem", TLSKey: dir + "/key.pem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if !strings.HasPrefix(b.URL(), "tcp://127.0.0.1:") {
+		t.Errorf("unexpected local URL: %s", b.URL())
+	}
+	// The clients of the process connect without TLS
+	if transport := tinpottest.Connect(t, b.URL()); !transport.IsConnected() {
+		t.Error("local client not connected")
+	}
+}
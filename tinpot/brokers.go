@@ -0,0 +1,54 @@
+package tinpot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SRV services of the broker discovery schemes: "mqtt+srv://example.com" looks up
+// _mqtt._tcp.example.com, "mqtts+srv://" _secure-mqtt._tcp for TLS brokers
+var srvSchemes = map[string]struct{ service, scheme string }{
+	"mqtt+srv":  {"mqtt", "tcp"},
+	"mqtts+srv": {"secure-mqtt", "ssl"},
+}
+
+// lookupSRV is replaced by tests
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// ResolveBrokers turns a comma separated list of broker URLs into the URLs to connect
+// to, in failover order. DNS SRV entries (mqtt+srv://domain) are expanded to their
+// targets, ordered by priority and weight.
+func ResolveBrokers(ctx context.Context, spec string) ([]*url.URL, error) {
+	var brokers []*url.URL
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		broker, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid broker URL %q: %w", entry, err)
+		}
+		srv, ok := srvSchemes[broker.Scheme]
+		if !ok {
+			brokers = append(brokers, broker)
+			continue
+		}
+		_, records, err := lookupSRV(ctx, srv.service, "tcp", broker.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("broker discovery of %s failed: %w", entry, err)
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			brokers = append(brokers, &url.URL{Scheme: srv.scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(record.Port)))})
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no MQTT broker in %q", spec)
+	}
+	return brokers, nil
+}
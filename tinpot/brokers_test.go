@@ -0,0 +1,36 @@
+package tinpot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestResolveBrokers(t *testing.T) {
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service != "secure-mqtt" || name != "example.com" {
+			return "", nil, fmt.Errorf("no such host")
+		}
+		return "", []*net.SRV{{Target: "b1.example.com.", Port: 8883}, {Target: "b2.example.com.", Port: 8884}}, nil
+	}
+	defer func() { lookupSRV = net.DefaultResolver.LookupSRV }()
+
+	brokers, err := ResolveBrokers(context.Background(), "tcp://primary:1883, mqtts+srv://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, broker := range brokers {
+		got = append(got, broker.String())
+	}
+	if fmt.Sprint(got) != "[tcp://primary:1883 ssl://b1.example.com:8883 ssl://b2.example.com:8884]" {
+		t.Errorf("unexpected brokers: %v", got)
+	}
+	if _, err := ResolveBrokers(context.Background(), "mqtt+srv://example.com"); err == nil {
+		t.Error("failed discovery accepted")
+	}
+	if _, err := ResolveBrokers(context.Background(), " , "); err == nil {
+		t.Error("empty broker list accepted")
+	}
+}
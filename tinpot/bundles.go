@@ -0,0 +1,241 @@
+package tinpot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// BundleManifestFile is the manifest at the root of a bundle directory or archive
+const BundleManifestFile = "bundle.json"
+
+// BundleManifest describes a pack of actions shared as a bundle, see MqttInstallRequest
+type BundleManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Runtime the actions need: "python", optionally with a minimal version like "python>=3.10"
+	Runtime string `json:"runtime,omitempty"`
+	// Dependencies are pip requirements, installed into a virtual environment of the bundle
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Actions the bundle provides
+	Actions []string `json:"actions,omitempty"`
+}
+
+var bundleNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Validate checks the manifest of a bundle to install
+func (m *BundleManifest) Validate() error {
+	if !bundleNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("invalid bundle name %q", m.Name)
+	}
+	if _, _, err := m.PythonVersion(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PythonVersion is the minimal Python version of the runtime, 0.0 if any version is fine
+func (m *BundleManifest) PythonVersion() (major, minor int, err error) {
+	runtime := strings.ReplaceAll(m.Runtime, " ", "")
+	if runtime == "" || runtime == "python" {
+		return 0, 0, nil
+	}
+	version, ok := strings.CutPrefix(runtime, "python>=")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported runtime %q", m.Runtime)
+	}
+	majorText, minorText, _ := strings.Cut(version, ".")
+	if major, err = strconv.Atoi(majorText); err == nil && minorText != "" {
+		minor, err = strconv.Atoi(minorText)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid runtime version %q", m.Runtime)
+	}
+	return major, minor, nil
+}
+
+// ReadBundleManifest reads the manifest of a bundle archive, a tar file, gzip compressed or not
+func ReadBundleManifest(archive []byte) (*BundleManifest, error) {
+	var r io.Reader = bytes.NewReader(archive)
+	if bytes.HasPrefix(archive, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the archive", BundleManifestFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if path.Clean(header.Name) != BundleManifestFile {
+			continue
+		}
+		var manifest BundleManifest
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", BundleManifestFile, err)
+		}
+		return &manifest, nil
+	}
+}
+
+// BundleInfo identifies a bundle archive, announced with the actions loaded from signed ones
+type BundleInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Digest of the archive, "sha256:<hex>"
+	Digest string `json:"digest"`
+	// KeyID is the key that verified the signature: the minisign key id, or "sha256:" and the
+	// start of the digest of a PEM public key. Empty if the archive was not verified.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// BundleKeys are the public keys trusted to sign action bundles
+type BundleKeys struct {
+	minisign map[uint64]ed25519.PublicKey
+	pem      map[string]interface{} // *ecdsa.PublicKey or ed25519.PublicKey by key id
+}
+
+// ParseBundleKeys reads trusted keys: minisign public keys (the base64 line of minisign.pub,
+// "untrusted comment" lines are skipped) and PEM encoded ECDSA or Ed25519 public keys, like
+// cosign.pub. Lines starting with # are comments.
+func ParseBundleKeys(data []byte) (*BundleKeys, error) {
+	keys := &BundleKeys{minisign: make(map[uint64]ed25519.PublicKey), pem: make(map[string]interface{})}
+	var block []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN "):
+			block = []string{line}
+		case block != nil:
+			block = append(block, line)
+			if strings.HasPrefix(line, "-----END ") {
+				if err := keys.addPEM([]byte(strings.Join(block, "\n"))); err != nil {
+					return nil, err
+				}
+				block = nil
+			}
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "untrusted comment:"):
+		default:
+			raw, err := base64.StdEncoding.DecodeString(line)
+			if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+				return nil, fmt.Errorf("invalid minisign public key %q", line)
+			}
+			keys.minisign[binary.LittleEndian.Uint64(raw[2:10])] = ed25519.PublicKey(raw[10:])
+		}
+	}
+	if len(keys.minisign)+len(keys.pem) == 0 {
+		return nil, errors.New("no public keys")
+	}
+	return keys, nil
+}
+
+func (k *BundleKeys) addPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("invalid PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid PEM public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	digest := sha256.Sum256(block.Bytes)
+	k.pem["sha256:"+hex.EncodeToString(digest[:8])] = key
+	return nil
+}
+
+// VerifyMinisign checks a minisign signature (the .minisig file) of the archive, including its
+// trusted comment, and returns the id of the key
+func (k *BundleKeys) VerifyMinisign(archive, signature []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("invalid minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 74 {
+		return "", errors.New("invalid minisign signature")
+	}
+	id := binary.LittleEndian.Uint64(sig[2:10])
+	keyID := fmt.Sprintf("%016X", id)
+	key, ok := k.minisign[id]
+	if !ok {
+		return "", fmt.Errorf("signed by the unknown key %s", keyID)
+	}
+	message := archive
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		// Prehashed, the default since minisign 0.10
+		digest := blake2b.Sum512(archive)
+		message = digest[:]
+	default:
+		return "", fmt.Errorf("unsupported minisign algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(key, message, sig[10:]) {
+		return "", errors.New("invalid signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if err != nil || !ed25519.Verify(key, append(append([]byte{}, sig[10:]...), comment...), global) {
+		return "", errors.New("invalid signature of the trusted comment")
+	}
+	return keyID, nil
+}
+
+// VerifySignature checks a base64 signature of the archive by one of the PEM keys, as made by
+// cosign sign-blob (ECDSA over the SHA-256 digest) or with an Ed25519 key, and returns the id of the key
+func (k *BundleKeys) VerifySignature(archive, signature []byte) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return "", errors.New("invalid signature encoding")
+	}
+	digest := sha256.Sum256(archive)
+	for id, key := range k.pem {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], sig) {
+				return id, nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, archive, sig) {
+				return id, nil
+			}
+		}
+	}
+	return "", errors.New("not signed by a trusted key")
+}
+
+// ArchiveDigest is the BundleInfo digest of an archive
+func ArchiveDigest(archive []byte) string {
+	digest := sha256.Sum256(archive)
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
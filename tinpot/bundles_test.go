@@ -0,0 +1,95 @@
+package tinpot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisign signs like minisign -S, prehashed
+func minisign(private ed25519.PrivateKey, keyID []byte, data []byte, comment string) string {
+	digest := blake2b.Sum512(data)
+	sig := append(append([]byte("ED"), keyID...), ed25519.Sign(private, digest[:])...)
+	global := ed25519.Sign(private, append(append([]byte{}, sig[10:]...), comment...))
+	return "untrusted comment: signature from minisign secret key\n" + base64.StdEncoding.EncodeToString(sig) +
+		"\ntrusted comment: " + comment + "\n" + base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestBundleSignatures(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	minisignKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...))
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	cosignKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	keys, err := ParseBundleKeys([]byte("# ops team\nuntrusted comment: minisign public key 0807060504030201\n" + minisignKey + "\n" + string(cosignKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := []byte("archive")
+
+	id, err := keys.VerifyMinisign(archive, []byte(minisign(private, keyID, archive, "timestamp:1 file:clean.tar.gz")))
+	if err != nil || id != "0807060504030201" {
+		t.Errorf("valid minisign signature rejected: %s %v", id, err)
+	}
+	if _, err := keys.VerifyMinisign([]byte("tampered"), []byte(minisign(private, keyID, archive, "c"))); err == nil {
+		t.Error("signature of another archive accepted")
+	}
+	forged := strings.Replace(minisign(private, keyID, archive, "file:clean.tar.gz"), "file:clean", "file:other", 1)
+	if _, err := keys.VerifyMinisign(archive, []byte(forged)); err == nil {
+		t.Error("modified trusted comment accepted")
+	}
+	_, otherPrivate, _ := ed25519.GenerateKey(nil)
+	if _, err := keys.VerifyMinisign(archive, []byte(minisign(otherPrivate, keyID, archive, "c"))); err == nil {
+		t.Error("signature of another key accepted")
+	}
+
+	digest := sha256.Sum256(archive)
+	sig, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if id, err := keys.VerifySignature(archive, []byte(base64.StdEncoding.EncodeToString(sig))); err != nil || !strings.HasPrefix(id, "sha256:") {
+		t.Errorf("valid cosign signature rejected: %s %v", id, err)
+	}
+	if _, err := keys.VerifySignature([]byte("tampered"), []byte(base64.StdEncoding.EncodeToString(sig))); err == nil {
+		t.Error("cosign signature of another archive accepted")
+	}
+}
+
+func TestBundleManifest(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	manifest := `{"name": "ops", "version": "1.0.0", "runtime": "python>=3.10", "dependencies": ["requests>=2"], "actions": ["restart_service"]}`
+	tw.WriteHeader(&tar.Header{Name: "./bundle.json", Mode: 0644, Size: int64(len(manifest))})
+	tw.Write([]byte(manifest))
+	tw.Close()
+	gz.Close()
+
+	m, err := ReadBundleManifest(buf.Bytes())
+	if err != nil || m.Name != "ops" || len(m.Dependencies) != 1 || len(m.Actions) != 1 {
+		t.Fatalf("unexpected manifest: %+v %v", m, err)
+	}
+	if major, minor, err := m.PythonVersion(); err != nil || major != 3 || minor != 10 {
+		t.Errorf("unexpected runtime version: %d.%d %v", major, minor, err)
+	}
+	for _, invalid := range []BundleManifest{{Name: "../ops"}, {Name: ""}, {Name: "ops", Runtime: "node"}, {Name: "ops", Runtime: "python>=three"}} {
+		if invalid.Validate() == nil {
+			t.Errorf("invalid manifest accepted: %+v", invalid)
+		}
+	}
+	if _, err := ReadBundleManifest([]byte("not an archive")); err == nil {
+		t.Error("invalid archive accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package tinpot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of the result and log messages
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// Encodings are the content encodings workers announce, in order of preference
+var Encodings = []string{EncodingZstd, EncodingGzip}
+
+// MaxDecompressedSize bounds the decompressed size of a message
+const MaxDecompressedSize = 64 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+)
+
+// ParseEncoding validates a content encoding, empty for none
+func ParseEncoding(encoding string) (string, error) {
+	if encoding != "" && !slices.Contains(Encodings, encoding) {
+		return "", fmt.Errorf("unknown content encoding %q, expected one of %v", encoding, Encodings)
+	}
+	return encoding, nil
+}
+
+// CompressPayload compresses a message with the encoding. Messages that would not get
+// smaller are kept as they are, DecompressPayload tells them apart by their magic bytes.
+func CompressPayload(encoding string, payload []byte) []byte {
+	var compressed []byte
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(payload)
+		zw.Close()
+		compressed = buf.Bytes()
+	case EncodingZstd:
+		compressed = zstdEncoder.EncodeAll(payload, nil)
+	default:
+		return payload
+	}
+	if len(compressed) >= len(payload) {
+		return payload
+	}
+	return compressed
+}
+
+// DecompressPayload decompresses a gzip or zstd message, others (JSON) are returned as they are
+func DecompressPayload(payload []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(payload, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(zr, MaxDecompressedSize+1))
+		if err == nil && len(data) > MaxDecompressedSize {
+			err = fmt.Errorf("decompressed message exceeds %d bytes", MaxDecompressedSize)
+		}
+		return data, err
+	case bytes.HasPrefix(payload, zstdMagic):
+		return zstdDecoder.DecodeAll(payload, nil)
+	}
+	return payload, nil
+}
@@ -0,0 +1,30 @@
+package tinpot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	large := []byte(`{"result": "` + strings.Repeat("all work and no play ", 100) + `"}`)
+	small := []byte(`{}`)
+	for _, encoding := range Encodings {
+		compressed := CompressPayload(encoding, large)
+		if len(compressed) >= len(large) {
+			t.Errorf("%s did not compress", encoding)
+		}
+		if data, err := DecompressPayload(compressed); err != nil || !bytes.Equal(data, large) {
+			t.Errorf("%s round trip failed: %v", encoding, err)
+		}
+		if !bytes.Equal(CompressPayload(encoding, small), small) {
+			t.Errorf("%s should keep messages that don't get smaller", encoding)
+		}
+	}
+	if data, _ := DecompressPayload(large); !bytes.Equal(data, large) {
+		t.Error("plain JSON should be kept")
+	}
+	if _, err := ParseEncoding("brotli"); err == nil {
+		t.Error("unknown encoding should be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package tinpot
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Kinds of the messages the coordinator receives, reported in MqttDiagnostic
+const (
+	KindAnnouncement = "announcement"
+	KindWorkerStatus = "worker_status"
+	KindLoadErrors   = "load_errors"
+	KindTelemetry    = "telemetry"
+	KindResult       = "result"
+	KindLog          = "log"
+	KindAccepted     = "accepted"
+	// KindUnexpected is a message on a topic the coordinator does not expect any
+	KindUnexpected = "unexpected"
+)
+
+// MqttDiagnostic reports a message the coordinator dropped as malformed, published on the
+// DiagnosticsTopic of the tenant
+type MqttDiagnostic struct {
+	Topic string `json:"topic"`
+	// Kind of the message, see KindAnnouncement and the others
+	Kind string `json:"kind"`
+	// Worker that sent the message, when it is known
+	Worker string `json:"worker,omitempty"`
+	Error  string `json:"error"`
+	// Size of the payload in bytes
+	Size int    `json:"size"`
+	At   string `json:"at"`
+}
+
+// DecodeMessage is the tolerant decoding of the messages received over MQTT: it decompresses
+// the payload and decodes it as UnmarshalMessage does, or as plain JSON for an empty message
+// type. It returns an error instead of panicking on malformed input, and leaves v untouched
+// unless the whole message decoded, so a bad message never leaves partial state behind.
+func DecodeMessage(payload []byte, messageType string, v interface{}) (version int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			version, err = 0, fmt.Errorf("malformed message: %v", r)
+		}
+	}()
+	payload, err = DecompressPayload(payload)
+	if err != nil {
+		return 0, err
+	}
+	decoded := reflect.New(reflect.TypeOf(v).Elem())
+	if messageType == "" {
+		err = json.Unmarshal(payload, decoded.Interface())
+	} else {
+		version, err = UnmarshalMessage(payload, messageType, decoded.Interface())
+	}
+	if err != nil {
+		return version, err
+	}
+	reflect.ValueOf(v).Elem().Set(decoded.Elem())
+	return version, nil
+}
@@ -0,0 +1,63 @@
+package tinpot
+
+import (
+	"reflect"
+	"testing"
+)
+
+// decodeTargets are the messages received over MQTT, by message type
+var decodeTargets = []struct {
+	messageType string
+	new         func() interface{}
+}{
+	{MessageExecutionRequest, func() interface{} { return &MqttExecutionRequest{} }},
+	{MessageResult, func() interface{} { return &MqttResultResponse{} }},
+	{MessageLog, func() interface{} { return &[]MqttLogEntry{} }},
+	{MessageLog, func() interface{} { return &MqttLogEntry{} }},
+	{MessageAccepted, func() interface{} { return &MqttAccepted{} }},
+	{"", func() interface{} { return &MqttAction{} }},
+	{"", func() interface{} { return &MqttWorkerStatus{} }},
+	{"", func() interface{} { return &MqttWorkerTelemetry{} }},
+	{"", func() interface{} { return &[]MqttLoadError{} }},
+	{"", func() interface{} { return &MqttSyncRequest{} }},
+	{"", func() interface{} { return &MqttInstallRequest{} }},
+	{"", func() interface{} { return &MqttScratchRequest{} }},
+	{"", func() interface{} { return &MqttScratchArchive{} }},
+}
+
+func TestDecodeMessage(t *testing.T) {
+	payload, _ := MarshalMessage(FormatCBOR, SchemaVersion, MessageResult, MqttResultResponse{Status: "SUCCESS", Result: map[string]interface{}{"n": 1}})
+	var res MqttResultResponse
+	if version, err := DecodeMessage(CompressPayload(EncodingZstd, payload), MessageResult, &res); err != nil || version != SchemaVersion || res.Status != "SUCCESS" {
+		t.Errorf("unexpected result %+v (version %d): %v", res, version, err)
+	}
+
+	// A message failing halfway leaves nothing behind
+	status := MqttWorkerStatus{ID: "w1"}
+	if _, err := DecodeMessage([]byte(`{"id": "w2", "online": "yes"}`), "", &status); err == nil || status.ID != "w1" {
+		t.Errorf("expected an error and no change, got %+v: %v", status, err)
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add([]byte(`{"status": "SUCCESS", "result": {"a": [1, "b", null]}}`))
+	f.Add([]byte(`{"schema_version": 1, "type": "log", "payload": [{"level": "INFO", "message": "hi", "sequence": 1}]}`))
+	f.Add([]byte(`{"description": "", "trigger_topic": "tinpot/actions/x/trigger", "parameters": {"n": {"type": "int"}}}`))
+	f.Add([]byte(`{"id": "w1", "online": true, "labels": {"os": "linux"}}`))
+	f.Add([]byte(`[{"module": "m", "error": "e"}]`))
+	for _, format := range Formats {
+		payload, _ := MarshalMessage(format, SchemaVersion, MessageResult, MqttResultResponse{Status: "FAILURE", Error: "boom"})
+		f.Add(payload)
+		f.Add(CompressPayload(EncodingGzip, payload))
+		f.Add(CompressPayload(EncodingZstd, payload))
+	}
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		for _, target := range decodeTargets {
+			v, zero := target.new(), target.new()
+			if _, err := DecodeMessage(payload, target.messageType, v); err != nil && !reflect.DeepEqual(v, zero) {
+				t.Errorf("%T changed by a failed decoding: %+v", v, v)
+			}
+		}
+	})
+}
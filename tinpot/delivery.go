@@ -0,0 +1,78 @@
+package tinpot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Delivery is the MQTT QoS level and retain flag of a class of messages.
+// The QoS applies to both publishing and subscribing.
+type Delivery struct {
+	QoS      byte
+	Retained bool
+}
+
+// DeliveryConfig configures the delivery of each message class of the protocol.
+// Workers and coordinators of a deployment should use the same configuration.
+type DeliveryConfig struct {
+	Announce Delivery
+	Trigger  Delivery
+	Log      Delivery
+	Result   Delivery
+}
+
+// DefaultDelivery keeps announcements and results retained, so coordinators (re)connecting
+// late still see the actions and the outcome of the executions they are waiting for
+func DefaultDelivery() DeliveryConfig {
+	return DeliveryConfig{
+		Announce: Delivery{QoS: 1, Retained: true},
+		Trigger:  Delivery{QoS: 1},
+		Log:      Delivery{QoS: 0},
+		Result:   Delivery{QoS: 1, Retained: true},
+	}
+}
+
+// ParseDelivery overrides the defaults with a spec like "log=1,result=1:noretain,announce=1:retain"
+func ParseDelivery(spec string) (DeliveryConfig, error) {
+	config := DefaultDelivery()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid delivery entry %q, expected class=qos[:retain|:noretain]", entry)
+		}
+		var target *Delivery
+		switch class {
+		case "announce":
+			target = &config.Announce
+		case "trigger":
+			target = &config.Trigger
+		case "log":
+			target = &config.Log
+		case "result":
+			target = &config.Result
+		default:
+			return config, fmt.Errorf("unknown message class %q", class)
+		}
+		qos, flag, _ := strings.Cut(value, ":")
+		level, err := strconv.Atoi(qos)
+		if err != nil || level < 0 || level > 2 {
+			return config, fmt.Errorf("invalid QoS %q of %s", qos, class)
+		}
+		target.QoS = byte(level)
+		switch flag {
+		case "":
+		case "retain":
+			target.Retained = true
+		case "noretain":
+			target.Retained = false
+		default:
+			return config, fmt.Errorf("invalid retain flag %q of %s", flag, class)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,28 @@
+package tinpot
+
+import "testing"
+
+func TestParseDelivery(t *testing.T) {
+	config, err := ParseDelivery("log=1, result=2:noretain,trigger=0:retain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DeliveryConfig{
+		Announce: Delivery{QoS: 1, Retained: true},
+		Trigger:  Delivery{QoS: 0, Retained: true},
+		Log:      Delivery{QoS: 1},
+		Result:   Delivery{QoS: 2},
+	}
+	if config != expected {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+
+	if config, err := ParseDelivery(""); err != nil || config != DefaultDelivery() {
+		t.Errorf("expected defaults, got %+v %v", config, err)
+	}
+	for _, spec := range []string{"log", "log=3", "status=1", "result=1:sometimes"} {
+		if _, err := ParseDelivery(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
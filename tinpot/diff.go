@@ -0,0 +1,90 @@
+package tinpot
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change operations of a JSON diff
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// JSONChange is a difference between two JSON documents at Path (a JSON Pointer)
+type JSONChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// NormalizeJSON converts a value to its plain JSON form (maps, slices, float64, string, bool, nil),
+// so values produced by different runtimes compare equal
+func NormalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return v
+	}
+	return normalized
+}
+
+// DiffJSON computes the structural differences turning a into b. Both are expected to be normalized.
+// Objects are compared by key, arrays by index. Changes are sorted by path.
+func DiffJSON(a, b interface{}) []JSONChange {
+	var changes []JSONChange
+	diffJSON("", a, b, &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffJSON(path string, a, b interface{}, changes *[]JSONChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			for k, v := range av {
+				if w, ok := bv[k]; ok {
+					diffJSON(path+"/"+escapePointer(k), v, w, changes)
+				} else {
+					*changes = append(*changes, JSONChange{Path: path + "/" + escapePointer(k), Op: DiffRemoved, From: v})
+				}
+			}
+			for k, w := range bv {
+				if _, ok := av[k]; !ok {
+					*changes = append(*changes, JSONChange{Path: path + "/" + escapePointer(k), Op: DiffAdded, To: w})
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				p := path + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(bv):
+					*changes = append(*changes, JSONChange{Path: p, Op: DiffRemoved, From: av[i]})
+				case i >= len(av):
+					*changes = append(*changes, JSONChange{Path: p, Op: DiffAdded, To: bv[i]})
+				default:
+					diffJSON(p, av[i], bv[i], changes)
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, JSONChange{Path: path, Op: DiffChanged, From: a, To: b})
+	}
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
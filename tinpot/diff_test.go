@@ -0,0 +1,39 @@
+package tinpot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	a := NormalizeJSON(map[string]interface{}{
+		"count": 1,
+		"hosts": []string{"a", "b"},
+		"disk":  map[string]interface{}{"free": 10, "path/to": "x"},
+		"old":   true,
+	})
+	b := NormalizeJSON(map[string]interface{}{
+		"count": 1.0,
+		"hosts": []string{"a", "c", "d"},
+		"disk":  map[string]interface{}{"free": 7, "path/to": "x"},
+		"new":   "yes",
+	})
+
+	expected := []JSONChange{
+		{Path: "/disk/free", Op: DiffChanged, From: 10.0, To: 7.0},
+		{Path: "/hosts/1", Op: DiffChanged, From: "b", To: "c"},
+		{Path: "/hosts/2", Op: DiffAdded, To: "d"},
+		{Path: "/new", Op: DiffAdded, To: "yes"},
+		{Path: "/old", Op: DiffRemoved, From: true},
+	}
+	if changes := DiffJSON(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	if changes := DiffJSON(a, a); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	if changes := DiffJSON(nil, "x"); len(changes) != 1 || changes[0].Path != "" {
+		t.Errorf("expected a root change, got %+v", changes)
+	}
+}
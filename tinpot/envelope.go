@@ -0,0 +1,89 @@
+package tinpot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// SchemaVersion is the newest message envelope version this build reads and writes. Peers
+// announce theirs, and messages are exchanged in the lower of the two; version 0 stands for
+// the bare messages of peers predating the envelope.
+const SchemaVersion = 1
+
+// Types of the enveloped messages
+const (
+	MessageExecutionRequest = "execution_request"
+	MessageResult           = "result"
+	MessageLog              = "log"
+	MessageAccepted         = "accepted"
+)
+
+// Envelope wraps a message with its schema version and type, so a peer tells a message of a
+// newer schema apart from a malformed one instead of misreading it
+type Envelope struct {
+	SchemaVersion int         `json:"schema_version"`
+	Type          string      `json:"type"`
+	Payload       interface{} `json:"payload"`
+}
+
+// MarshalMessage encodes a message in the format, in an Envelope of the version unless it is 0
+func MarshalMessage(format string, version int, messageType string, v interface{}) ([]byte, error) {
+	if version <= 0 {
+		return MarshalPayload(format, v)
+	}
+	return MarshalPayload(format, Envelope{SchemaVersion: min(version, SchemaVersion), Type: messageType, Payload: v})
+}
+
+// UnmarshalMessage decodes a message of the type, enveloped or bare, and returns its schema
+// version (0 when bare). Envelopes of a newer schema version or another type are rejected.
+func UnmarshalMessage(payload []byte, messageType string, v interface{}) (int, error) {
+	format := PayloadFormat(payload)
+	version, envelopeType, inner := unwrap(format, payload)
+	if version == 0 {
+		return 0, unmarshalFormat(format, payload, v)
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("unsupported schema version %d of %s, up to %d is supported", version, envelopeType, SchemaVersion)
+	}
+	if envelopeType != messageType {
+		return version, fmt.Errorf("expected a %s message, got %s", messageType, envelopeType)
+	}
+	return version, unmarshalFormat(format, inner, v)
+}
+
+// unwrap returns the version, type and payload of an enveloped message, version 0 if it is bare
+func unwrap(format string, payload []byte) (int, string, []byte) {
+	switch format {
+	case FormatCBOR:
+		var env struct {
+			SchemaVersion int             `json:"schema_version"`
+			Type          string          `json:"type"`
+			Payload       cbor.RawMessage `json:"payload"`
+		}
+		if cborDecoder.Unmarshal(payload, &env) == nil && env.Payload != nil {
+			return env.SchemaVersion, env.Type, env.Payload
+		}
+	case FormatMsgpack:
+		var env struct {
+			SchemaVersion int                `msgpack:"schema_version"`
+			Type          string             `msgpack:"type"`
+			Payload       msgpack.RawMessage `msgpack:"payload"`
+		}
+		if checkMsgpack(payload) == nil && msgpack.Unmarshal(payload, &env) == nil && env.Payload != nil {
+			return env.SchemaVersion, env.Type, env.Payload
+		}
+	default:
+		var env struct {
+			SchemaVersion int             `json:"schema_version"`
+			Type          string          `json:"type"`
+			Payload       json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(payload, &env) == nil && env.Payload != nil {
+			return env.SchemaVersion, env.Type, env.Payload
+		}
+	}
+	return 0, "", nil
+}
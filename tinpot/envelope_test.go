@@ -0,0 +1,36 @@
+package tinpot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	result := MqttResultResponse{Status: "SUCCESS", Result: map[string]interface{}{"n": 1.0}}
+	for _, format := range append(Formats, "") {
+		for _, version := range []int{0, SchemaVersion} {
+			payload, err := MarshalMessage(format, version, MessageResult, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded MqttResultResponse
+			got, err := UnmarshalMessage(payload, MessageResult, &decoded)
+			if err != nil || got != version || decoded.Status != "SUCCESS" || decoded.Result.(map[string]interface{})["n"] != 1.0 {
+				t.Errorf("%q version %d: got version %d, %v, %+v", format, version, got, err, decoded)
+			}
+		}
+		payload, _ := MarshalMessage(format, SchemaVersion, MessageLog, []MqttLogEntry{{Message: "hi"}})
+		var entries []MqttLogEntry
+		if _, err := UnmarshalMessage(payload, MessageLog, &entries); err != nil || len(entries) != 1 || entries[0].Message != "hi" {
+			t.Errorf("%q log batch: %v %+v", format, err, entries)
+		}
+		if _, err := UnmarshalMessage(payload, MessageResult, &result); err == nil {
+			t.Errorf("%q: a log accepted as result", format)
+		}
+	}
+
+	future := []byte(`{"schema_version": 99, "type": "result", "payload": {"status": "SUCCESS", "result": {"renamed": true}}}`)
+	if _, err := UnmarshalMessage(future, MessageResult, &result); err == nil || !strings.Contains(err.Error(), "unsupported schema version 99") {
+		t.Errorf("newer schema accepted: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package tinpot
+
+import (
+	"sync"
+	"time"
+)
+
+// Execution event types
+const (
+	EventSubmitted = "submitted"
+	// EventStarted is emitted when the execution has been dispatched to the action
+	EventStarted   = "started"
+	EventLog       = "log"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+
+	EventActionAdded        = "action_added"
+	EventActionUpdated      = "action_updated"
+	EventActionRemoved      = "action_removed"
+	EventWorkerConnected    = "worker_connected"
+	EventWorkerDisconnected = "worker_disconnected"
+	EventWorkerTelemetry    = "worker_telemetry"
+	EventWorkerLoadErrors   = "worker_load_errors"
+	// EventInvalidMessage reports a malformed message the coordinator dropped
+	EventInvalidMessage = "invalid_message"
+
+	// EventAlertFiring and EventAlertResolved report the alert rules of the coordinator
+	EventAlertFiring   = "alert_firing"
+	EventAlertResolved = "alert_resolved"
+)
+
+// LogLevelProgress marks log entries carrying a JSON encoded Progress instead of a message
+const LogLevelProgress = "PROGRESS"
+
+type Progress struct {
+	Current float64 `json:"current"`
+	Total   float64 `json:"total,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// ExecutionEvent is an event of an execution, or of the action catalog and workers
+// (no ExecutionID) for the action_* and worker_* types
+type ExecutionEvent struct {
+	Type        string    `json:"type"`
+	ExecutionID string    `json:"execution_id,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Worker      string    `json:"worker,omitempty"`
+	Time        time.Time `json:"time"`
+
+	// Log events
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Progress events
+	Progress *Progress `json:"progress,omitempty"`
+
+	// Worker telemetry events
+	Telemetry *MqttWorkerTelemetry `json:"telemetry,omitempty"`
+
+	// Worker load errors events, empty when every action module loaded
+	LoadErrors []MqttLoadError `json:"load_errors,omitempty"`
+
+	// Invalid message events
+	Diagnostic *MqttDiagnostic `json:"diagnostic,omitempty"`
+
+	// Completed events
+	Status string                 `json:"status,omitempty"`
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+
+	// Alert events, with the Message explaining a firing alert
+	Alert string `json:"alert,omitempty"`
+}
+
+// EventBus distributes execution events to in-process subscribers
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(ExecutionEvent)
+	nextID      int
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]func(ExecutionEvent))}
+}
+
+// Subscribe registers a handler for all events. Handlers are called synchronously
+// from the publisher, so they must not block. The returned function unsubscribes.
+func (b *EventBus) Subscribe(handler func(ExecutionEvent)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers the event to every subscriber. Time is set if missing.
+func (b *EventBus) Publish(event ExecutionEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handler := range b.subscribers {
+		handler(event)
+	}
+}
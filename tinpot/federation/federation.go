@@ -0,0 +1,530 @@
+// Package federation lets a central coordinator serve the actions of site-local coordinators
+// through their HTTP API: the site catalogs are listed with the site name as prefix, the
+// executions are proxied to the sites with their logs streamed back, and the health of the
+// sites is reported with the central one's.
+package federation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+)
+
+// Site is a coordinator federated into the central one
+type Site struct {
+	// Name of the site, its actions are listed as "{name}.{action}"
+	Name string `json:"name"`
+	// URL of the site's coordinator, e.g. https://berlin.example.com
+	URL string `json:"url"`
+	// Token authenticates at the site (see API_TOKENS), it selects the site's tenant
+	Token string `json:"token,omitempty"`
+	// Tenant of the central coordinator listing the site's actions, the default tenant when empty
+	Tenant string `json:"tenant,omitempty"`
+}
+
+var siteNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// LoadSites reads the sites from a JSON file:
+//
+//	[{"name": "berlin", "url": "https://berlin.example.com", "token": "..."}]
+func LoadSites(path string) ([]Site, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sites []Site
+	if err := json.Unmarshal(data, &sites); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, site := range sites {
+		if !siteNamePattern.MatchString(site.Name) || names[site.Name] {
+			return nil, fmt.Errorf("invalid or duplicate site name %q", site.Name)
+		}
+		if u, err := url.Parse(site.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid URL of site %s: %q", site.Name, site.URL)
+		}
+		names[site.Name] = true
+	}
+	return sites, nil
+}
+
+type Options struct {
+	// Interval of the catalog and health checks of the sites (default 30 seconds)
+	Interval time.Duration
+	// Client sends the requests, http.DefaultClient when nil. Executions are followed on
+	// long-lived streams, so it should not have a Timeout.
+	Client *http.Client
+	// Events receives the catalog changes of the sites, optional
+	Events *tinpot.EventBus
+}
+
+const (
+	// requestTimeout bounds the requests other than the execution streams
+	requestTimeout = 10 * time.Second
+	// streamRetries is the number of reconnections to a broken execution stream
+	streamRetries = 5
+)
+
+// streamRetryDelay is the wait before the first reconnection, growing with each retry
+var streamRetryDelay = time.Second
+
+// Manager is an ActionManager serving the actions of the sites along the local ones
+type Manager struct {
+	tinpot.ActionManager
+	opts  Options
+	sites []*site
+}
+
+type site struct {
+	Site
+	mu sync.RWMutex
+	// actions of the site by their name at the site
+	actions map[string]tinpot.ActionInfo
+	etag    string
+	state   tinpot.SiteState
+}
+
+// NewManager adds the actions of the sites to those of local. The site catalogs are
+// fetched by Run.
+func NewManager(local tinpot.ActionManager, sites []Site, opts Options) *Manager {
+	if opts.Interval == 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	m := &Manager{ActionManager: local, opts: opts}
+	for _, s := range sites {
+		s.URL = strings.TrimSuffix(s.URL, "/")
+		m.sites = append(m.sites, &site{
+			Site:  s,
+			state: tinpot.SiteState{Name: s.Name, URL: s.URL, Status: "unreachable", Detail: "not checked yet"},
+		})
+	}
+	return m
+}
+
+// Run checks the sites until ctx is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, s := range m.sites {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.refresh(ctx, s)
+			}()
+		}
+		wg.Wait()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh checks the health of a site and updates its catalog. The actions of an unreachable
+// site stay listed, its executions fail until it is back.
+func (m *Manager) refresh(ctx context.Context, s *site) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	state := tinpot.SiteState{Name: s.Name, URL: s.URL, Status: "healthy", Checked: time.Now()}
+	failed := func(err error) {
+		if state.Status != "healthy" {
+			return
+		}
+		state.Status, state.Detail = "unreachable", err.Error()
+		var unhealthy siteError
+		if errors.As(err, &unhealthy) {
+			state.Status = "unhealthy"
+		}
+	}
+	if err := s.health(ctx, m.opts.Client); err != nil {
+		failed(err)
+	}
+	actions, err := s.catalog(ctx, m.opts.Client)
+	if err != nil {
+		failed(err)
+	}
+
+	s.mu.Lock()
+	previous := s.actions
+	if actions != nil {
+		s.actions = actions
+	}
+	state.Actions = len(s.actions)
+	s.state = state
+	s.mu.Unlock()
+	if actions != nil && m.opts.Events != nil {
+		m.catalogChanged(s, previous, actions)
+	}
+}
+
+// catalogChanged publishes the differences between two catalogs of a site
+func (m *Manager) catalogChanged(s *site, previous, current map[string]tinpot.ActionInfo) {
+	publish := func(eventType, name string) {
+		m.opts.Events.Publish(tinpot.ExecutionEvent{Type: eventType, Action: s.prefix() + name, Tenant: s.Tenant, Time: time.Now()})
+	}
+	for name, act := range current {
+		if old, ok := previous[name]; !ok {
+			publish(tinpot.EventActionAdded, name)
+		} else if !reflect.DeepEqual(old, act) {
+			publish(tinpot.EventActionUpdated, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			publish(tinpot.EventActionRemoved, name)
+		}
+	}
+}
+
+func (s *site) prefix() string {
+	return s.Name + "."
+}
+
+// siteError is an error reported by the site itself, rather than a failure to reach it
+type siteError struct {
+	status int
+	detail string
+}
+
+func (e siteError) Error() string {
+	return fmt.Sprintf("%d: %s", e.status, e.detail)
+}
+
+// do sends a request to the site, answers other than 2xx and 304 are siteErrors
+func (s *site) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		var body struct {
+			Detail string `json:"detail"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+		if body.Detail == "" {
+			body.Detail = http.StatusText(resp.StatusCode)
+		}
+		return nil, siteError{status: resp.StatusCode, detail: body.Detail}
+	}
+	return resp, nil
+}
+
+func (s *site) health(ctx context.Context, client *http.Client) error {
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.URL+"/health", nil)
+	resp, err := s.do(client, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// catalog fetches the actions of the site, nil if they did not change since the last fetch
+func (s *site) catalog(ctx context.Context, client *http.Client) (map[string]tinpot.ActionInfo, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.URL+"/api/actions", nil)
+	s.mu.RLock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.RUnlock()
+	resp, err := s.do(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	var actions map[string]tinpot.ActionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&actions); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
+	return actions, nil
+}
+
+// ListActions returns the local actions and those of the sites
+func (m *Manager) ListActions() map[string]tinpot.ActionInfo {
+	result := make(map[string]tinpot.ActionInfo)
+	for qualified, act := range m.ActionManager.ListActions() {
+		result[qualified] = act
+	}
+	for _, s := range m.sites {
+		s.mu.RLock()
+		for name, act := range s.actions {
+			act.Name = s.prefix() + name
+			act.Tenant = s.Tenant
+			act.Site = s.Name
+			if act.AliasOf != "" {
+				act.AliasOf = s.prefix() + act.AliasOf
+			}
+			result[tinpot.QualifiedName(s.Tenant, act.Name)] = act
+		}
+		s.mu.RUnlock()
+	}
+	return result
+}
+
+func (m *Manager) GetAction(qualified string) tinpot.ActionTrigger {
+	tenant, name := tinpot.SplitQualifiedName(qualified)
+	for _, s := range m.sites {
+		action, ok := strings.CutPrefix(name, s.prefix())
+		if !ok || s.Tenant != tenant {
+			continue
+		}
+		s.mu.RLock()
+		_, exists := s.actions[action]
+		s.mu.RUnlock()
+		if !exists {
+			return nil
+		}
+		return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			m.execute(s, action, parameters, response, logs)
+		}
+	}
+	return m.ActionManager.GetAction(qualified)
+}
+
+// execute runs the action at the site and relays its logs and outcome
+func (m *Manager) execute(s *site, action string, parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+	// Internal parameters (e.g. _execution_id) belong to this coordinator
+	actual := make(map[string]interface{}, len(parameters))
+	for k, v := range parameters {
+		if !strings.HasPrefix(k, "_") {
+			actual[k] = v
+		}
+	}
+	// The site routes the execution among its workers
+	selector, _ := parameters["_selector"].(string)
+	session, _ := parameters["_session"].(string)
+	body, _ := json.Marshal(server.ExecuteActionRequest{Parameters: actual, Selector: selector, Session: session})
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", s.URL+"/api/actions/"+url.PathEscape(action)+"/execute", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.do(m.opts.Client, req)
+	if err != nil {
+		response(fmt.Sprintf("site %s: %v", s.Name, err), nil)
+		return
+	}
+	var started server.ExecutionResponse
+	err = json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if err != nil || started.ExecutionID == "" {
+		response(fmt.Sprintf("site %s: invalid execution response: %v", s.Name, err), nil)
+		return
+	}
+	log.Printf("Execution of %s%s started at site %s as %s", s.prefix(), action, s.Name, started.ExecutionID)
+
+	// The stream is resumed after the last received event when it breaks
+	lastEventID := ""
+	for attempt := 0; ; attempt++ {
+		done, err := m.follow(s, started.ExecutionID, &lastEventID, response, logs)
+		if done {
+			return
+		}
+		var gone siteError
+		if errors.As(err, &gone) && gone.status == http.StatusNotFound {
+			// The stream is no longer kept, the record tells the outcome
+			m.outcome(s, started.ExecutionID, response)
+			return
+		}
+		if attempt >= streamRetries {
+			response(fmt.Sprintf("site %s: lost execution %s: %v", s.Name, started.ExecutionID, err), nil)
+			return
+		}
+		time.Sleep(streamRetryDelay * time.Duration(attempt+1))
+	}
+}
+
+// streamEvent is a server.StreamEvent with its data left encoded
+type streamEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// follow relays the events of an execution stream, it tells whether the execution completed
+func (m *Manager) follow(s *site, id string, lastEventID *string, response tinpot.ActionResponse, logs tinpot.ActionLogs) (bool, error) {
+	req, _ := http.NewRequest("GET", s.URL+"/api/executions/"+url.PathEscape(id)+"/stream", nil)
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+	resp, err := s.do(m.opts.Client, req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	eventID := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			eventID = id
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+		if eventID != "" {
+			*lastEventID = eventID
+		}
+		switch event.Type {
+		case "log":
+			var entry tinpot.MqttLogEntry
+			if json.Unmarshal(event.Data, &entry) == nil && logs != nil {
+				logs(entry.Level, entry.Message)
+			}
+		case "progress":
+			if logs != nil {
+				logs(tinpot.LogLevelProgress, string(event.Data))
+			}
+		case "complete":
+			var outcome struct {
+				Successful bool                   `json:"successful"`
+				Result     map[string]interface{} `json:"result"`
+				Error      string                 `json:"error"`
+			}
+			json.Unmarshal(event.Data, &outcome)
+			if !outcome.Successful && outcome.Error == "" {
+				outcome.Error = "failed at site " + s.Name
+			}
+			response(outcome.Error, outcome.Result)
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, io.ErrUnexpectedEOF
+}
+
+// outcome answers with the record of a finished execution of the site
+func (m *Manager) outcome(s *site, id string, response tinpot.ActionResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.URL+"/api/executions/"+url.PathEscape(id), nil)
+	resp, err := s.do(m.opts.Client, req)
+	if err != nil {
+		response(fmt.Sprintf("site %s: lost execution %s: %v", s.Name, id, err), nil)
+		return
+	}
+	defer resp.Body.Close()
+	var rec tinpot.ExecutionRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil || !rec.Done() {
+		response(fmt.Sprintf("site %s: lost execution %s", s.Name, id), nil)
+		return
+	}
+	if rec.Status == tinpot.StatusSuccess {
+		response("", rec.Result)
+		return
+	}
+	if rec.Error == "" {
+		rec.Error = fmt.Sprintf("%s at site %s", rec.Status, s.Name)
+	}
+	response(rec.Error, nil)
+}
+
+// Sites reports the health of the sites, see tinpot.SiteReporter
+func (m *Manager) Sites() []tinpot.SiteState {
+	states := make([]tinpot.SiteState, 0, len(m.sites))
+	for _, s := range m.sites {
+		s.mu.RLock()
+		states = append(states, s.state)
+		s.mu.RUnlock()
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Brokers reports the brokers of the local manager, see tinpot.BrokerReporter
+func (m *Manager) Brokers() []tinpot.BrokerState {
+	if r, ok := m.ActionManager.(tinpot.BrokerReporter); ok {
+		return r.Brokers()
+	}
+	return nil
+}
+
+// SyncWorkers asks the local workers to update their actions, see tinpot.WorkerSyncer
+func (m *Manager) SyncWorkers(tenant, workerID, ref string) error {
+	if syncer, ok := m.ActionManager.(tinpot.WorkerSyncer); ok {
+		return syncer.SyncWorkers(tenant, workerID, ref)
+	}
+	return errors.New("syncing workers not supported")
+}
+
+// FetchScratch downloads scratch directories from the local workers, see tinpot.ScratchFetcher
+func (m *Manager) FetchScratch(ctx context.Context, tenant, executionID string) ([]byte, error) {
+	if fetcher, ok := m.ActionManager.(tinpot.ScratchFetcher); ok {
+		return fetcher.FetchScratch(ctx, tenant, executionID)
+	}
+	return nil, tinpot.ErrScratchNotFound
+}
+
+// RetainedMessages lists those of the local broker, see tinpot.BrokerAdmin
+func (m *Manager) RetainedMessages(tenant string) []tinpot.RetainedMessage {
+	if admin, ok := m.ActionManager.(tinpot.BrokerAdmin); ok {
+		return admin.RetainedMessages(tenant)
+	}
+	return []tinpot.RetainedMessage{}
+}
+
+// PurgeExecution clears the retained messages of a local execution, see tinpot.BrokerAdmin
+func (m *Manager) PurgeExecution(tenant, executionID string) ([]string, error) {
+	if admin, ok := m.ActionManager.(tinpot.BrokerAdmin); ok {
+		return admin.PurgeExecution(tenant, executionID)
+	}
+	return nil, errors.New("broker administration not supported")
+}
+
+// Rescan asks the local workers to announce themselves again, see tinpot.BrokerAdmin
+func (m *Manager) Rescan(tenant string) error {
+	if admin, ok := m.ActionManager.(tinpot.BrokerAdmin); ok {
+		return admin.Rescan(tenant)
+	}
+	return errors.New("broker administration not supported")
+}
+
+// InvalidMessages counts those dropped from the local broker, see tinpot.BrokerAdmin
+func (m *Manager) InvalidMessages(tenant string) tinpot.InvalidMessages {
+	if admin, ok := m.ActionManager.(tinpot.BrokerAdmin); ok {
+		return admin.InvalidMessages(tenant)
+	}
+	return tinpot.InvalidMessages{ByKind: map[string]int{}, ByWorker: map[string]int{}, Samples: []tinpot.MqttDiagnostic{}}
+}
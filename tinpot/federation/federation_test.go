@@ -0,0 +1,99 @@
+package federation_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/federation"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestFederation(t *testing.T) {
+	siteActions := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "greet"}, tinpottest.Script{
+			Logs:   []tinpottest.LogLine{{Level: "INFO", Message: "greeting"}},
+			Result: map[string]interface{}{"greeting": "hello"},
+		}.Trigger()).
+		Add(tinpot.ActionInfo{Name: "broken"}, tinpottest.Fail("disk full"))
+	site := httptest.NewServer(server.NewServer(siteActions, nil, server.Options{Tokens: map[string]string{"site-token": ""}}))
+	defer site.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	local := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	events := tinpot.NewEventBus()
+	added := make(chan string, 10)
+	events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventActionAdded {
+			added <- event.Action
+		}
+	})
+	mgr := federation.NewManager(local, []federation.Site{
+		{Name: "berlin", URL: site.URL, Token: "site-token", Tenant: "edge"},
+		{Name: "paris", URL: down.URL},
+	}, federation.Options{Events: events})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mgr.Run(ctx)
+	tinpottest.WaitFor(t, func() bool { return len(added) == 2 })
+
+	actions := mgr.ListActions()
+	if act, ok := actions["edge/berlin.greet"]; !ok || act.Site != "berlin" || act.Tenant != "edge" {
+		t.Errorf("site action not listed: %+v", actions)
+	}
+	if _, ok := actions["echo"]; !ok {
+		t.Errorf("local action not listed: %+v", actions)
+	}
+	if mgr.GetAction("berlin.greet") != nil {
+		t.Error("site action found outside of its tenant")
+	}
+
+	run := func(name string) (errMsg string, result map[string]interface{}, logs []string) {
+		t.Helper()
+		done := make(chan struct{})
+		mgr.GetAction(name)(map[string]interface{}{"_execution_id": "central"}, func(e string, r map[string]interface{}) {
+			errMsg, result = e, r
+			close(done)
+		}, func(level, message string) {
+			logs = append(logs, message)
+		})
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not complete", name)
+		}
+		return
+	}
+	if errMsg, result, logs := run("edge/berlin.greet"); errMsg != "" || result["greeting"] != "hello" || len(logs) != 1 || logs[0] != "greeting" {
+		t.Errorf("unexpected outcome: %q %v %v", errMsg, result, logs)
+	}
+	if errMsg, _, _ := run("edge/berlin.broken"); errMsg != "disk full" {
+		t.Errorf("unexpected error: %q", errMsg)
+	}
+	if calls := siteActions.Calls("greet"); len(calls) != 1 || calls[0]["_execution_id"] == "central" {
+		t.Errorf("internal parameters passed to the site: %v", calls)
+	}
+
+	// The central coordinator reports the sites with its own health
+	central := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer central.Close()
+	resp, err := http.Get(central.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Status string             `json:"status"`
+		Sites  []tinpot.SiteState `json:"sites"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if health.Status != "degraded" || len(health.Sites) != 2 || health.Sites[0].Status != "healthy" || health.Sites[0].Actions != 2 || health.Sites[1].Status != "unreachable" {
+		t.Errorf("unexpected health: %+v", health)
+	}
+}
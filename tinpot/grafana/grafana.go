@@ -0,0 +1,153 @@
+// Package grafana posts a Grafana annotation for each finished execution, so operational
+// actions show up on dashboards next to the metrics they affect.
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+)
+
+// Config of the Grafana instance
+type Config struct {
+	// URL of Grafana, e.g. https://grafana.example.com
+	URL string
+	// Token is a service account token with the annotations:write permission
+	Token string
+	// Tags are added to the tags of every annotation ("tinpot", the action, the status and the tenant)
+	Tags []string
+	// DashboardUID and PanelID restrict the annotations to a dashboard (and panel),
+	// otherwise they are organization wide
+	DashboardUID string
+	PanelID      int64
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// Annotation is the body of POST /api/annotations
+type Annotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int64    `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// Plugin is the server.Plugin posting the annotations in the background
+type Plugin struct {
+	config Config
+	store  tinpot.ExecutionStore
+	queue  chan tinpot.ExecutionEvent
+}
+
+func New(config Config) *Plugin {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return &Plugin{config: config, queue: make(chan tinpot.ExecutionEvent, 1000)}
+}
+
+func (p *Plugin) Name() string {
+	return "grafana"
+}
+
+func (p *Plugin) Setup(host *server.PluginHost) error {
+	if p.config.URL == "" {
+		return fmt.Errorf("missing Grafana URL")
+	}
+	p.store = host.Store
+	host.Events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type != tinpot.EventCompleted {
+			return
+		}
+		select {
+		case p.queue <- event:
+		default:
+			log.Printf("Grafana annotation of %s dropped, the queue is full", event.ExecutionID)
+		}
+	})
+	return nil
+}
+
+// Run posts the queued annotations until the context is done
+func (p *Plugin) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-p.queue:
+			if err := p.post(ctx, p.annotation(event)); err != nil {
+				log.Printf("Failed to post the Grafana annotation of %s: %v", event.ExecutionID, err)
+			}
+		}
+	}
+}
+
+// annotation spans the execution from its start (or submission) to its completion
+func (p *Plugin) annotation(event tinpot.ExecutionEvent) Annotation {
+	start, end := event.Time, event.Time
+	if rec, err := p.store.Get(event.ExecutionID); err == nil {
+		start = rec.SubmittedAt
+		if rec.StartedAt != nil {
+			start = *rec.StartedAt
+		}
+		if rec.CompletedAt != nil {
+			end = *rec.CompletedAt
+		}
+	}
+	tags := []string{"tinpot", event.Action, event.Status}
+	if event.Tenant != "" {
+		tags = append(tags, "tenant:"+event.Tenant)
+	}
+	text := fmt.Sprintf("%s %s (execution %s)", event.Action, event.Status, event.ExecutionID)
+	if event.Error != "" {
+		text += ": " + event.Error
+	}
+	a := Annotation{
+		DashboardUID: p.config.DashboardUID,
+		PanelID:      p.config.PanelID,
+		Time:         start.UnixMilli(),
+		Tags:         append(tags, p.config.Tags...),
+		Text:         text,
+	}
+	if end.After(start) {
+		a.TimeEnd = end.UnixMilli()
+	}
+	return a
+}
+
+func (p *Plugin) post(ctx context.Context, a Annotation) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.URL+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	}
+	resp, err := p.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("grafana responded %s", resp.Status)
+	}
+	return nil
+}
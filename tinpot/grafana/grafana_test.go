@@ -0,0 +1,55 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestAnnotations(t *testing.T) {
+	var mu sync.Mutex
+	var annotations []Annotation
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/annotations" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var a Annotation
+		json.NewDecoder(r.Body).Decode(&a)
+		mu.Lock()
+		annotations = append(annotations, a)
+		mu.Unlock()
+	}))
+	defer grafana.Close()
+
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "restart"}, tinpottest.Fail("timeout"))
+	srv := server.NewServer(mgr, nil, server.Options{Plugins: []server.Plugin{New(Config{URL: grafana.URL, Token: "secret", Tags: []string{"prod"}, DashboardUID: "ops"})}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.RunPlugins(ctx)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/actions/restart/sync_execute", "application/json", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tinpottest.WaitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(annotations) == 1
+	})
+	a := annotations[0]
+	if a.DashboardUID != "ops" || a.Time == 0 || a.TimeEnd < a.Time || len(a.Tags) != 4 || a.Tags[1] != "restart" || a.Tags[2] != tinpot.StatusFailure || a.Tags[3] != "prod" {
+		t.Errorf("unexpected annotation: %+v", a)
+	}
+}
@@ -0,0 +1,98 @@
+package tinpot
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LocalizedText holds the translation of an action's metadata to one locale
+type LocalizedText struct {
+	Description string `json:"description,omitempty"`
+	Docs        string `json:"docs,omitempty"`
+	// Parameters maps parameter names to their labels
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header, most preferred first
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// matchLocale picks the translation for the most preferred language,
+// falling back from a regional tag (de-AT) to its base language (de).
+func matchLocale(translations map[string]LocalizedText, preferred []string) (string, bool) {
+	lowered := make(map[string]string, len(translations))
+	for locale := range translations {
+		lowered[strings.ToLower(locale)] = locale
+	}
+	for _, tag := range preferred {
+		if locale, ok := lowered[tag]; ok {
+			return locale, true
+		}
+		base, _, _ := strings.Cut(tag, "-")
+		if locale, ok := lowered[base]; ok {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// Localize returns a copy of the action with its description, docs and parameter
+// labels translated to the most preferred available locale, and that locale.
+func Localize(act ActionInfo, preferred []string) (ActionInfo, string) {
+	locale, ok := matchLocale(act.Translations, preferred)
+	if !ok {
+		return act, ""
+	}
+	text := act.Translations[locale]
+
+	if text.Description != "" {
+		act.Description = text.Description
+	}
+	if text.Docs != "" {
+		act.Docs = text.Docs
+	}
+	if len(text.Parameters) > 0 {
+		params := make(map[string]ParameterInfo, len(act.Parameters))
+		for name, p := range act.Parameters {
+			if label, ok := text.Parameters[name]; ok {
+				ui := ParameterUI{}
+				if p.UI != nil {
+					ui = *p.UI
+				}
+				ui.Title = label
+				p.UI = &ui
+			}
+			params[name] = p
+		}
+		act.Parameters = params
+	}
+	return act, locale
+}
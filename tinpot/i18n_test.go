@@ -0,0 +1,45 @@
+package tinpot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := ParseAcceptLanguage("fr;q=0.5, de-AT, en;q=0.8, *;q=0.1")
+	want := []string{"de-at", "en", "fr"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAcceptLanguage() = %v, want %v", got, want)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	act := ActionInfo{
+		Name:        "cleanup",
+		Description: "Clean up old files",
+		Parameters: map[string]ParameterInfo{
+			"days": {Type: "int", UI: &ParameterUI{Order: 1}},
+		},
+		Translations: map[string]LocalizedText{
+			"de": {Description: "Alte Dateien aufräumen", Parameters: map[string]string{"days": "Tage"}},
+		},
+	}
+
+	localized, locale := Localize(act, ParseAcceptLanguage("de-AT,en;q=0.5"))
+	if locale != "de" {
+		t.Fatalf("locale = %q, want de", locale)
+	}
+	if localized.Description != "Alte Dateien aufräumen" {
+		t.Errorf("Description = %q", localized.Description)
+	}
+	if ui := localized.Parameters["days"].UI; ui.Title != "Tage" || ui.Order != 1 {
+		t.Errorf("UI = %+v", ui)
+	}
+	if act.Parameters["days"].UI.Title != "" {
+		t.Errorf("Localize modified the original action")
+	}
+
+	if _, locale := Localize(act, []string{"fr"}); locale != "" {
+		t.Errorf("locale = %q for unavailable translation", locale)
+	}
+}
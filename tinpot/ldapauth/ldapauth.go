@@ -0,0 +1,127 @@
+// Package ldapauth authenticates tinpot API callers against an LDAP directory with HTTP
+// basic credentials, mapping their groups to roles.
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Config of an LDAP directory
+type Config struct {
+	// URL of the server, ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades ldap:// connections
+	StartTLS bool
+	// BindDN and BindPassword of the account searching users and groups, empty binds anonymously
+	BindDN       string
+	BindPassword string
+	// BaseDN the users and groups are searched in
+	BaseDN string
+	// UserFilter finds the user, %s is the username (default "(uid=%s)")
+	UserFilter string
+	// GroupFilter finds the groups of the user, %s is the user's DN (default "(member=%s)")
+	GroupFilter string
+	// GroupAttribute is the group name mapped to roles (default "cn")
+	GroupAttribute string
+	Mapping        server.RoleMapping
+}
+
+// Provider authenticates users by binding as them
+type Provider struct {
+	cfg Config
+}
+
+func New(cfg Config) *Provider {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.GroupFilter == "" {
+		cfg.GroupFilter = "(member=%s)"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "cn"
+	}
+	return &Provider{cfg: cfg}
+}
+
+func (p *Provider) Name() string {
+	return "ldap"
+}
+
+// AuthenticatePassword looks up the user, binds with the password and reads the groups
+func (p *Provider) AuthenticatePassword(ctx context.Context, username, password string) (*server.Identity, error) {
+	// An empty password would be an anonymous bind, which many servers accept
+	if username == "" || password == "" {
+		return nil, nil
+	}
+	conn, err := ldap.DialURL(p.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if p.cfg.StartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: hostname(p.cfg.URL)}); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	users, err := conn.Search(ldap.NewSearchRequest(p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(p.cfg.UserFilter, ldap.EscapeFilter(username)), []string{"dn"}, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(users.Entries) != 1 {
+		return nil, nil
+	}
+	userDN := users.Entries[0].DN
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Groups may not be readable by the user
+	if err := p.bindService(conn); err != nil {
+		return nil, err
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(p.cfg.GroupFilter, ldap.EscapeFilter(userDN)), []string{p.cfg.GroupAttribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, entry := range result.Entries {
+		if name := entry.GetAttributeValue(p.cfg.GroupAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return p.cfg.Mapping.Identity(username, groups), nil
+}
+
+func (p *Provider) bindService(conn *ldap.Conn) error {
+	if p.cfg.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+		return fmt.Errorf("service account bind failed: %w", err)
+	}
+	return nil
+}
+
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,80 @@
+package tinpot
+
+import (
+	"log"
+	"strings"
+)
+
+// MirrorTransport publishes the announcements (actions, worker status) to a second
+// broker too, so coordinators of both see the worker while migrating between brokers.
+// Subscriptions and executions stay on the primary transport.
+type MirrorTransport struct {
+	Transport
+	mirror Transport
+}
+
+func NewMirrorTransport(primary, mirror Transport) *MirrorTransport {
+	return &MirrorTransport{Transport: primary, mirror: mirror}
+}
+
+func (m *MirrorTransport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	m.publishMirror(topic, qos, retained, payload)
+	return m.Transport.Publish(topic, qos, retained, payload)
+}
+
+func (m *MirrorTransport) PublishWithProperties(topic string, qos byte, retained bool, payload []byte, properties map[string]string) error {
+	m.publishMirror(topic, qos, retained, payload)
+	return PublishWithProperties(m.Transport, topic, qos, retained, payload, properties)
+}
+
+func (m *MirrorTransport) publishMirror(topic string, qos byte, retained bool, payload []byte) {
+	if !IsAnnouncementTopic(topic) || !m.mirror.IsConnected() {
+		return
+	}
+	if err := m.mirror.Publish(topic, qos, retained, payload); err != nil {
+		log.Printf("Failed to mirror %s: %v", topic, err)
+	}
+}
+
+// OnConnect handlers also run when the mirror (re)connects, so the announcements are
+// renewed there
+func (m *MirrorTransport) OnConnect(handler func()) {
+	m.Transport.OnConnect(handler)
+	m.mirror.OnConnect(handler)
+}
+
+func (m *MirrorTransport) Brokers() []BrokerState {
+	var brokers []BrokerState
+	if r, ok := m.Transport.(BrokerReporter); ok {
+		brokers = r.Brokers()
+	}
+	if r, ok := m.mirror.(BrokerReporter); ok {
+		for _, broker := range r.Brokers() {
+			broker.Mirror = true
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// IsAnnouncementTopic tells whether a topic is an action announcement or a worker's
+// status, load errors or telemetry, as opposed to triggers and execution messages
+func IsAnnouncementTopic(topic string) bool {
+	rest, ok := strings.CutPrefix(topic, MQTT_TENANT_TOPIC_PREFIX)
+	if ok {
+		_, rest, ok = strings.Cut(rest, "/")
+	} else {
+		rest, ok = strings.CutPrefix(topic, "tinpot/")
+	}
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rest, "/")
+	switch {
+	case len(parts) == 2:
+		return parts[0] == "actions" || parts[0] == "workers"
+	case len(parts) == 3:
+		return parts[0] == "workers" && (parts[2] == "load_errors" || parts[2] == "telemetry")
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package tinpot
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recorder is a connected transport remembering the published topics
+type recorder struct {
+	topics []string
+}
+
+func (r *recorder) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	r.topics = append(r.topics, topic)
+	return nil
+}
+func (r *recorder) Subscribe(string, byte, MessageHandler) error { return nil }
+func (r *recorder) Unsubscribe(...string) error                  { return nil }
+func (r *recorder) OnConnect(func())                             {}
+func (r *recorder) IsConnected() bool                            { return true }
+
+func TestMirrorTransport(t *testing.T) {
+	primary, mirror := &recorder{}, &recorder{}
+	transport := NewMirrorTransport(primary, mirror)
+	topics := []string{
+		"tinpot/actions/echo",
+		"tinpot/actions/echo/trigger",
+		"tinpot/tenants/team-a/actions/echo",
+		"tinpot/tenants/team-a/workers/w1",
+		"tinpot/workers/w1/load_errors",
+		"tinpot/workers/w1/exec/123/result",
+		"tinpot/exec/123/logs",
+	}
+	for _, topic := range topics {
+		transport.Publish(topic, 1, true, nil)
+	}
+	if len(primary.topics) != len(topics) {
+		t.Errorf("primary missed messages: %v", primary.topics)
+	}
+	if fmt.Sprint(mirror.topics) != "[tinpot/actions/echo tinpot/tenants/team-a/actions/echo tinpot/tenants/team-a/workers/w1 tinpot/workers/w1/load_errors]" {
+		t.Errorf("unexpected mirrored topics: %v", mirror.topics)
+	}
+}
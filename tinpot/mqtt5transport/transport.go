@@ -0,0 +1,219 @@
+// Package mqtt5transport implements tinpot.Transport over MQTT 5 with the Eclipse Paho Go client.
+// Messages published with tinpot.PublishWithProperties carry MQTT 5 user properties.
+package mqtt5transport
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// timeout of a single publish, (un)subscribe or connection attempt
+const timeout = 10 * time.Second
+
+type Transport struct {
+	cfg    autopaho.ClientConfig
+	cm     atomic.Pointer[autopaho.ConnectionManager]
+	cancel context.CancelFunc
+	up     atomic.Bool
+	broker atomic.Pointer[url.URL] // last connection attempt
+
+	mu        sync.RWMutex
+	handlers  map[string]tinpot.MessageHandler // by topic filter
+	onConnect []func()
+}
+
+// New creates the transport from the client configuration. The configuration's
+// OnConnectionUp and OnConnectionDown are kept and called before the transport's own handling.
+func New(cfg autopaho.ClientConfig) *Transport {
+	t := &Transport{handlers: make(map[string]tinpot.MessageHandler)}
+	previousUp, previousDown := cfg.OnConnectionUp, cfg.OnConnectionDown
+	previousBuilder := cfg.ConnectPacketBuilder
+	cfg.ConnectPacketBuilder = func(connect *paho.Connect, broker *url.URL) (*paho.Connect, error) {
+		t.broker.Store(broker)
+		if previousBuilder != nil {
+			return previousBuilder(connect, broker)
+		}
+		return connect, nil
+	}
+	cfg.OnConnectionUp = func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+		if previousUp != nil {
+			previousUp(cm, connack)
+		}
+		t.cm.Store(cm)
+		t.up.Store(true)
+		t.mu.RLock()
+		handlers := append([]func(){}, t.onConnect...)
+		t.mu.RUnlock()
+		// Must not block the connection manager
+		go func() {
+			for _, handler := range handlers {
+				handler()
+			}
+		}()
+	}
+	cfg.OnConnectionDown = func() bool {
+		t.up.Store(false)
+		if previousDown != nil {
+			return previousDown()
+		}
+		return true
+	}
+	cfg.OnPublishReceived = append(cfg.OnPublishReceived, func(received paho.PublishReceived) (bool, error) {
+		t.route(received.Packet.Topic, received.Packet.Payload)
+		return true, nil
+	})
+	t.cfg = cfg
+	return t
+}
+
+// Connect starts the connection manager and waits for the first connection
+func (t *Transport) Connect() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, t.cfg)
+	if err != nil {
+		cancel()
+		return err
+	}
+	t.cancel = cancel
+	t.cm.Store(cm)
+	wait, cancelWait := context.WithTimeout(ctx, timeout)
+	defer cancelWait()
+	return cm.AwaitConnection(wait)
+}
+
+// Disconnect closes the connection and stops reconnecting
+func (t *Transport) Disconnect() {
+	cm := t.cm.Load()
+	if cm == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cm.Disconnect(ctx)
+	t.cancel()
+}
+
+func (t *Transport) manager() (*autopaho.ConnectionManager, error) {
+	cm := t.cm.Load()
+	if cm == nil {
+		return nil, errors.New("not connected")
+	}
+	return cm, nil
+}
+
+func (t *Transport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	return t.PublishWithProperties(topic, qos, retained, payload, nil)
+}
+
+func (t *Transport) PublishWithProperties(topic string, qos byte, retained bool, payload []byte, properties map[string]string) error {
+	cm, err := t.manager()
+	if err != nil {
+		return err
+	}
+	publish := &paho.Publish{Topic: topic, QoS: qos, Retain: retained, Payload: payload}
+	if len(properties) > 0 {
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		publish.Properties = &paho.PublishProperties{}
+		for _, key := range keys {
+			publish.Properties.User.Add(key, properties[key])
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = cm.Publish(ctx, publish)
+	return err
+}
+
+func (t *Transport) Subscribe(topic string, qos byte, handler tinpot.MessageHandler) error {
+	cm, err := t.manager()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.handlers[topic] = handler
+	t.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = cm.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}}})
+	return err
+}
+
+func (t *Transport) Unsubscribe(topics ...string) error {
+	t.mu.Lock()
+	for _, topic := range topics {
+		delete(t.handlers, topic)
+	}
+	t.mu.Unlock()
+	cm, err := t.manager()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+	return err
+}
+
+func (t *Transport) OnConnect(handler func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onConnect = append(t.onConnect, handler)
+}
+
+func (t *Transport) IsConnected() bool {
+	return t.up.Load()
+}
+
+// Brokers reports the configured brokers, the connected one is the last one attempted
+func (t *Transport) Brokers() []tinpot.BrokerState {
+	current := t.broker.Load()
+	brokers := make([]tinpot.BrokerState, len(t.cfg.ServerUrls))
+	for i, server := range t.cfg.ServerUrls {
+		brokers[i] = tinpot.BrokerState{URL: server.Redacted(), Connected: current != nil && *current == *server && t.up.Load()}
+	}
+	return brokers
+}
+
+// route delivers a message once to every handler whose filter matches the topic
+func (t *Transport) route(topic string, payload []byte) {
+	t.mu.RLock()
+	var matching []tinpot.MessageHandler
+	for filter, handler := range t.handlers {
+		if matches(filter, topic) {
+			matching = append(matching, handler)
+		}
+	}
+	t.mu.RUnlock()
+	for _, handler := range matching {
+		handler(topic, payload)
+	}
+}
+
+// matches tells if the topic matches the subscription filter (+ and # wildcards)
+func matches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
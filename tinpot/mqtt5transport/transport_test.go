@@ -0,0 +1,71 @@
+package mqtt5transport_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/mqtt5transport"
+	"github.com/balazsgrill/tinpot/remote"
+	"github.com/balazsgrill/tinpot/tinpottest"
+	"github.com/balazsgrill/tinpot/worker"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/uuid"
+)
+
+func connect(t *testing.T, broker string, received func(paho.PublishReceived) (bool, error)) *mqtt5transport.Transport {
+	u, _ := url.Parse(broker)
+	cfg := autopaho.ClientConfig{ServerUrls: []*url.URL{u}, KeepAlive: 30}
+	cfg.ClientID = "mqtt5-" + uuid.New().String()
+	if received != nil {
+		cfg.OnPublishReceived = append(cfg.OnPublishReceived, received)
+	}
+	transport := mqtt5transport.New(cfg)
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(transport.Disconnect)
+	return transport
+}
+
+func TestActionManagerContract(t *testing.T) {
+	var properties chan paho.UserProperties
+	tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+		broker := tinpottest.StartBroker(t)
+		properties = make(chan paho.UserProperties, 1000)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			worker.NewWorker(connect(t, broker, nil), tinpottest.ContractActions(), worker.Options{}).Run(ctx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		// The coordinator side sees the properties of the log and result messages
+		transport := connect(t, broker, func(received paho.PublishReceived) (bool, error) {
+			if received.Packet.Properties != nil {
+				select {
+				case properties <- received.Packet.Properties.User:
+				default:
+				}
+			}
+			return false, nil
+		})
+		mgr := remote.NewActionManager(transport, remote.Options{})
+		tinpottest.WaitFor(t, func() bool { return len(mgr.ListActions()) == 3 })
+		return mgr
+	})
+
+	found := false
+	for len(properties) > 0 && !found {
+		user := <-properties
+		found = user.Get(tinpot.PropertyExecutionID) != "" && user.Get(tinpot.PropertyAction) != ""
+	}
+	if !found {
+		t.Errorf("no execution message carried the user properties")
+	}
+}
@@ -0,0 +1,107 @@
+// Package mqtttransport implements tinpot.Transport with the Eclipse Paho MQTT client.
+package mqtttransport
+
+import (
+	"crypto/tls"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/balazsgrill/tinpot"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type Transport struct {
+	client  mqtt.Client
+	servers []*url.URL
+	broker  atomic.Pointer[url.URL] // last connection attempt
+
+	mu        sync.RWMutex
+	onConnect []func()
+}
+
+// New creates the transport from the client options. The options' OnConnectHandler
+// is kept and called before the handlers registered with OnConnect.
+func New(opts *mqtt.ClientOptions) *Transport {
+	t := &Transport{}
+	t.servers = opts.Servers
+	previousAttempt := opts.OnConnectAttempt
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		t.broker.Store(broker)
+		if previousAttempt != nil {
+			return previousAttempt(broker, tlsCfg)
+		}
+		return tlsCfg
+	})
+	previous := opts.OnConnect
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		if previous != nil {
+			previous(c)
+		}
+		t.mu.RLock()
+		handlers := append([]func(){}, t.onConnect...)
+		t.mu.RUnlock()
+		for _, handler := range handlers {
+			handler()
+		}
+	})
+	t.client = mqtt.NewClient(opts)
+	return t
+}
+
+// Connect connects to the broker and waits for the result
+func (t *Transport) Connect() error {
+	token := t.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+// Disconnect closes the connection, waiting at most quiesce milliseconds for pending work
+func (t *Transport) Disconnect(quiesce uint) {
+	t.client.Disconnect(quiesce)
+}
+
+// Client returns the underlying paho client
+func (t *Transport) Client() mqtt.Client {
+	return t.client
+}
+
+func (t *Transport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := t.client.Publish(topic, qos, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (t *Transport) Subscribe(topic string, qos byte, handler tinpot.MessageHandler) error {
+	token := t.client.Subscribe(topic, qos, func(c mqtt.Client, msg mqtt.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (t *Transport) Unsubscribe(topics ...string) error {
+	token := t.client.Unsubscribe(topics...)
+	token.Wait()
+	return token.Error()
+}
+
+func (t *Transport) OnConnect(handler func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onConnect = append(t.onConnect, handler)
+}
+
+func (t *Transport) IsConnected() bool {
+	return t.client.IsConnected()
+}
+
+// Brokers reports the configured brokers, the connected one is the last one attempted
+func (t *Transport) Brokers() []tinpot.BrokerState {
+	current := t.broker.Load()
+	brokers := make([]tinpot.BrokerState, len(t.servers))
+	for i, server := range t.servers {
+		brokers[i] = tinpot.BrokerState{URL: server.Redacted(), Connected: current != nil && *current == *server && t.client.IsConnected()}
+	}
+	return brokers
+}
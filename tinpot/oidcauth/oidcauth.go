@@ -0,0 +1,193 @@
+// Package oidcauth authenticates tinpot API callers with an OpenID Connect provider:
+// bearer tokens of API clients (e.g. from the client credentials grant) and the
+// authorization code flow for the web UI.
+package oidcauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const stateCookie = "tinpot_oidc_state"
+
+// Config of an OIDC provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider returns after login, the URL of the callback path
+	RedirectURL string
+	// Audience the API tokens must be issued for (default ClientID)
+	Audience string
+	// GroupsClaim names the claim with the groups mapped to roles (default "groups")
+	GroupsClaim string
+	// Scopes requested at login besides "openid"
+	Scopes []string
+	// HomeURL is where the browser is sent after login (default "/")
+	HomeURL string
+	Mapping server.RoleMapping
+}
+
+// Provider authenticates OIDC tokens and implements the login flow of the UI
+// (GET /auth/oidc/login and GET /auth/oidc/callback) as a server.LoginFlow.
+type Provider struct {
+	cfg Config
+	// verifiers accept the API tokens and the ID tokens of the UI
+	verifiers []*oidc.IDTokenVerifier
+	oauth     oauth2.Config
+}
+
+// New discovers the provider configuration from the issuer
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Audience == "" {
+		cfg.Audience = cfg.ClientID
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.HomeURL == "" {
+		cfg.HomeURL = "/"
+	}
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	verifiers := []*oidc.IDTokenVerifier{provider.Verifier(&oidc.Config{ClientID: cfg.Audience})}
+	if cfg.Audience != cfg.ClientID {
+		verifiers = append(verifiers, provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}))
+	}
+	return &Provider{
+		cfg:       cfg,
+		verifiers: verifiers,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID, "profile"}, cfg.Scopes...),
+		},
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return "oidc"
+}
+
+// AuthenticateToken verifies a JWT issued by the provider for the audience
+func (p *Provider) AuthenticateToken(ctx context.Context, token string) (*server.Identity, error) {
+	// Opaque tokens are not ours
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	idToken := p.verify(ctx, token)
+	if idToken == nil {
+		return nil, nil
+	}
+	return p.identity(idToken)
+}
+
+// identity maps the claims of a verified token
+func (p *Provider) identity(idToken *oidc.IDToken) (*server.Identity, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	subject := idToken.Subject
+	for _, claim := range []string{"preferred_username", "email"} {
+		if name, ok := claims[claim].(string); ok && name != "" {
+			subject = name
+			break
+		}
+	}
+	return p.cfg.Mapping.Identity(subject, groups(claims[p.cfg.GroupsClaim])), nil
+}
+
+func (p *Provider) verify(ctx context.Context, token string) *oidc.IDToken {
+	for _, v := range p.verifiers {
+		if idToken, err := v.Verify(ctx, token); err == nil {
+			return idToken
+		}
+	}
+	return nil
+}
+
+// groups reads a claim holding either a list of group names or a single one
+func groups(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var names []string
+		for _, g := range v {
+			if name, ok := g.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func (p *Provider) LoginHandler(login func(w http.ResponseWriter, r *http.Request, id *server.Identity)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/oidc/login":
+			p.login(w, r)
+		case "/auth/oidc/callback":
+			p.callback(w, r, login)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (p *Provider) login(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name: stateCookie, Value: state, Path: "/", MaxAge: 600,
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, p.oauth.AuthCodeURL(state), http.StatusFound)
+}
+
+func (p *Provider) callback(w http.ResponseWriter, r *http.Request, login func(w http.ResponseWriter, r *http.Request, id *server.Identity)) {
+	state, err := r.Cookie(stateCookie)
+	if err != nil || state.Value == "" || r.URL.Query().Get("state") != state.Value {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s", msg), http.StatusUnauthorized)
+		return
+	}
+	token, err := p.oauth.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Login failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	rawID, _ := token.Extra("id_token").(string)
+	idToken := p.verify(r.Context(), rawID)
+	if idToken == nil {
+		http.Error(w, "Login failed: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	id, err := p.identity(idToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Login failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+	login(w, r, id)
+	http.Redirect(w, r, p.cfg.HomeURL, http.StatusFound)
+}
@@ -0,0 +1,93 @@
+package oidcauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// issuer serves the discovery document and keys of a fake OIDC provider
+func issuer(t *testing.T) (*httptest.Server, func(claims map[string]interface{}) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: key.Public(), KeyID: "k1", Algorithm: "RS256", Use: "sig"}
+	ts := httptest.NewServer(nil)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                 ts.URL,
+				"authorization_endpoint": ts.URL + "/authorize",
+				"token_endpoint":         ts.URL + "/token",
+				"jwks_uri":               ts.URL + "/keys",
+			})
+		case "/keys":
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "k1"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims map[string]interface{}) string {
+		claims["iss"] = ts.URL
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		payload, _ := json.Marshal(claims)
+		jws, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := jws.CompactSerialize()
+		return token
+	}
+	return ts, sign
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	ts, sign := issuer(t)
+	defer ts.Close()
+	mapping, _ := server.ParseRoleMapping("ops:operator", "ops:team-a")
+	p, err := New(context.Background(), Config{Issuer: ts.URL, ClientID: "tinpot-ui", Audience: "tinpot-api", Mapping: mapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := p.AuthenticateToken(context.Background(), sign(map[string]interface{}{
+		"sub": "svc-1", "aud": "tinpot-api", "groups": []string{"ops", "other"},
+	}))
+	want := &server.Identity{Subject: "svc-1", Tenant: "team-a", Roles: []string{"operator"}, Groups: []string{"ops", "other"}}
+	if err != nil || !reflect.DeepEqual(id, want) {
+		t.Errorf("AuthenticateToken() = %+v, %v", id, err)
+	}
+
+	// ID tokens of the UI are issued for the client
+	id, _ = p.AuthenticateToken(context.Background(), sign(map[string]interface{}{
+		"sub": "u-1", "aud": "tinpot-ui", "preferred_username": "alice", "groups": "ops",
+	}))
+	if id == nil || id.Subject != "alice" || !id.HasRole(server.RoleOperator) {
+		t.Errorf("unexpected ID token identity: %+v", id)
+	}
+
+	for _, token := range []string{
+		"opaque-token",
+		sign(map[string]interface{}{"sub": "svc-2", "aud": "someone-else"}),
+	} {
+		if id, err := p.AuthenticateToken(context.Background(), token); id != nil || err != nil {
+			t.Errorf("token %s should not be recognized: %+v, %v", token, id, err)
+		}
+	}
+}
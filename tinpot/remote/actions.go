@@ -0,0 +1,876 @@
+// Package remote implements the coordinator side of the protocol: an ActionManager
+// over the actions announced by workers on a tinpot.Transport.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+type Options struct {
+	// Events receives the catalog changes, worker presence and telemetry, optional
+	Events *tinpot.EventBus
+	// Delivery of the protocol messages, tinpot.DefaultDelivery when nil
+	Delivery *tinpot.DeliveryConfig
+	// SigningKey signs the execution requests, optional
+	SigningKey *tinpot.SigningKey
+	// Compression asks the workers announcing it to compress results and logs, see
+	// tinpot.Encodings. Optional, compressed messages are always accepted.
+	Compression string
+	// Format asks the workers announcing it to take triggers and send results and logs in a
+	// binary format, see tinpot.Formats. Optional, JSON otherwise.
+	Format string
+	// SessionIdle ends the worker affinity of sessions without executions for that long,
+	// an hour when zero
+	SessionIdle time.Duration
+	// LogReorderWindow is how long log entries arriving out of order wait for the missing
+	// ones, 200ms when zero
+	LogReorderWindow time.Duration
+}
+
+type actionManager struct {
+	transport tinpot.Transport
+	events    *tinpot.EventBus
+	delivery  tinpot.DeliveryConfig
+	signing   *tinpot.SigningKey
+	encoding  string
+	format    string
+	actions   map[string]tinpot.MqttAction
+	workers   map[string]tinpot.MqttWorkerStatus
+	// running counts the executions routed to the workers that did not complete yet
+	running map[string]int
+	// sessions binds the qualified session IDs to the worker running their executions
+	sessions    map[string]sessionBinding
+	sessionIdle time.Duration
+	logWindow   time.Duration
+	// retained messages seen on the announcement topics, see tinpot.BrokerAdmin
+	retained map[string]tinpot.RetainedMessage
+	mu       sync.RWMutex
+
+	// messages dropped by tenant, see invalid
+	dropped   map[string]*tinpot.InvalidMessages
+	droppedMu sync.Mutex
+
+	// subscriptions of the executions waiting for their result, renewed on reconnect
+	pending   map[string]func()
+	pendingMu sync.Mutex
+}
+
+func (m *actionManager) IsConnected() bool {
+	return m.transport.IsConnected()
+}
+
+// Brokers reports the brokers of the transport, see tinpot.BrokerReporter
+func (m *actionManager) Brokers() []tinpot.BrokerState {
+	if r, ok := m.transport.(tinpot.BrokerReporter); ok {
+		return r.Brokers()
+	}
+	return nil
+}
+
+// NewActionManager tracks the action announcements on the transport. Announcements are
+// (re)subscribed on every connection, so the transport may be connected before or after.
+func NewActionManager(transport tinpot.Transport, opts Options) tinpot.ActionManager {
+	m := &actionManager{
+		transport: transport,
+		events:    opts.Events,
+		delivery:  tinpot.DefaultDelivery(),
+		signing:   opts.SigningKey,
+		encoding:  opts.Compression,
+		format:    opts.Format,
+		actions:   make(map[string]tinpot.MqttAction),
+		workers:   make(map[string]tinpot.MqttWorkerStatus),
+		running:   make(map[string]int),
+		sessions:  make(map[string]sessionBinding),
+		retained:  make(map[string]tinpot.RetainedMessage),
+		dropped:   make(map[string]*tinpot.InvalidMessages),
+		pending:   make(map[string]func()),
+
+		sessionIdle: opts.SessionIdle,
+		logWindow:   opts.LogReorderWindow,
+	}
+	if opts.Delivery != nil {
+		m.delivery = *opts.Delivery
+	}
+	if m.logWindow <= 0 {
+		m.logWindow = 200 * time.Millisecond
+	}
+	if m.sessionIdle <= 0 {
+		m.sessionIdle = time.Hour
+	}
+	transport.OnConnect(m.subscribe)
+	if transport.IsConnected() {
+		m.subscribe()
+	}
+	return m
+}
+
+func (m *actionManager) subscribe() {
+	// Subscribe to action announcements
+	for _, topic := range []string{tinpot.MQTT_TOPIC_PREFIX + "+", tinpot.MQTT_TENANT_TOPIC_PREFIX + "+/actions/+"} {
+		if err := m.transport.Subscribe(topic, m.delivery.Announce.QoS, m.onActionAnnounced); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+	// Subscribe to worker presence and telemetry
+	for _, topic := range []string{tinpot.WorkerStatusTopic("", "+"), tinpot.WorkerStatusTopic("+", "+")} {
+		if err := m.transport.Subscribe(topic, 1, m.onWorkerStatus); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+	for _, topic := range []string{tinpot.WorkerTelemetryTopic("", "+"), tinpot.WorkerTelemetryTopic("+", "+")} {
+		if err := m.transport.Subscribe(topic, 0, m.onWorkerTelemetry); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+	for _, topic := range []string{tinpot.WorkerLoadErrorsTopic("", "+"), tinpot.WorkerLoadErrorsTopic("+", "+")} {
+		if err := m.transport.Subscribe(topic, 1, m.onWorkerLoadErrors); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+
+	// Sessions are clean, the subscriptions of running executions are lost with the connection
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	for _, subscribe := range m.pending {
+		go subscribe()
+	}
+}
+
+func (m *actionManager) setPending(execID string, subscribe func()) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if subscribe == nil {
+		delete(m.pending, execID)
+	} else {
+		m.pending[execID] = subscribe
+	}
+}
+
+// workerTopic returns the tenant and id of a {prefix}workers/{id}/{suffix} topic
+func workerTopic(topic string) (tenant string, id string, ok bool) {
+	parts := strings.Split(topic, "/")
+	switch {
+	case len(parts) == 4:
+		// tinpot/workers/{id}/{suffix}
+		return "", parts[2], true
+	case len(parts) == 6 && parts[1] == "tenants" && parts[3] == "workers":
+		// tinpot/tenants/{tenant}/workers/{id}/{suffix}
+		return parts[2], parts[4], true
+	}
+	return "", "", false
+}
+
+func (m *actionManager) onWorkerLoadErrors(topic string, payload []byte) {
+	m.seen(topic, payload, true)
+	tenant, id, ok := workerTopic(topic)
+	if !ok {
+		m.unexpected(topic, payload)
+		return
+	}
+	if len(payload) == 0 {
+		return
+	}
+	var loadErrors []tinpot.MqttLoadError
+	if _, err := tinpot.DecodeMessage(payload, "", &loadErrors); err != nil {
+		m.invalid(tenant, id, tinpot.KindLoadErrors, topic, payload, err)
+		return
+	}
+	for _, loadError := range loadErrors {
+		log.Printf("Worker %s failed to load %s: %s", id, loadError.Module, loadError.Error)
+	}
+	m.publish(tinpot.ExecutionEvent{Type: tinpot.EventWorkerLoadErrors, Tenant: tenant, Worker: id, LoadErrors: loadErrors})
+}
+
+func (m *actionManager) onWorkerTelemetry(topic string, payload []byte) {
+	tenant, id, ok := workerTopic(topic)
+	if !ok {
+		m.unexpected(topic, payload)
+		return
+	}
+	var sample tinpot.MqttWorkerTelemetry
+	if _, err := tinpot.DecodeMessage(payload, "", &sample); err != nil {
+		m.invalid(tenant, id, tinpot.KindTelemetry, topic, payload, err)
+		return
+	}
+	m.publish(tinpot.ExecutionEvent{Type: tinpot.EventWorkerTelemetry, Tenant: tenant, Worker: id, Telemetry: &sample})
+}
+
+// SyncWorkers asks workers to update their actions, see tinpot.WorkerSyncer
+func (m *actionManager) SyncWorkers(tenant, workerID, ref string) error {
+	topic := tinpot.SyncTopic(tenant)
+	if workerID != "" {
+		topic = tinpot.WorkerSyncTopic(tenant, workerID)
+	}
+	payload, _ := json.Marshal(tinpot.MqttSyncRequest{Ref: ref})
+	return m.transport.Publish(topic, 1, false, payload)
+}
+
+// seen keeps track of the retained messages of the broker, clearing ones are empty
+func (m *actionManager) seen(topic string, payload []byte, retained bool) {
+	if !retained {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(payload) == 0 {
+		delete(m.retained, topic)
+	} else {
+		m.retained[topic] = tinpot.RetainedMessage{Topic: topic, Size: len(payload), SeenAt: time.Now()}
+	}
+}
+
+// RetainedMessages lists the retained actions, worker statuses and load errors of the tenant
+// seen since connecting, see tinpot.BrokerAdmin
+func (m *actionManager) RetainedMessages(tenant string) []tinpot.RetainedMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	messages := []tinpot.RetainedMessage{}
+	for topic, message := range m.retained {
+		if tinpot.IsTenantTopic(tenant, topic) {
+			messages = append(messages, message)
+		}
+	}
+	slices.SortFunc(messages, func(a, b tinpot.RetainedMessage) int { return strings.Compare(a.Topic, b.Topic) })
+	return messages
+}
+
+// PurgeExecution clears the retained result and log of an execution under the shared topics
+// and those of the workers of the tenant using their own, see tinpot.BrokerAdmin
+func (m *actionManager) PurgeExecution(tenant, executionID string) ([]string, error) {
+	if !tinpot.ValidName(executionID) {
+		return nil, fmt.Errorf("invalid execution ID %q", executionID)
+	}
+	prefixes := []string{tinpot.TopicPrefix(tenant) + "exec/"}
+	m.mu.RLock()
+	for key, status := range m.workers {
+		if workerTenant, _ := tinpot.SplitQualifiedName(key); workerTenant == tenant && status.TopicLayout == tinpot.TopicLayoutWorker {
+			prefixes = append(prefixes, tinpot.WorkerExecPrefix(tenant, status.ID))
+		}
+	}
+	m.mu.RUnlock()
+	slices.Sort(prefixes[1:])
+
+	var topics []string
+	for _, prefix := range prefixes {
+		for _, topic := range []string{prefix + executionID + "/result", prefix + executionID + "/log"} {
+			if err := m.transport.Publish(topic, 1, true, nil); err != nil {
+				return topics, err
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return topics, nil
+}
+
+// Rescan asks the workers of the tenant to announce themselves again, see tinpot.BrokerAdmin
+func (m *actionManager) Rescan(tenant string) error {
+	return m.transport.Publish(tinpot.RescanTopic(tenant), 1, false, []byte("{}"))
+}
+
+// FetchScratch downloads the scratch directory of an execution from the worker keeping it,
+// see tinpot.ScratchFetcher
+func (m *actionManager) FetchScratch(ctx context.Context, tenant, executionID string) ([]byte, error) {
+	replyTopic := tinpot.ScratchTopic(tenant) + "/" + uuid.New().String()
+	replies := make(chan tinpot.MqttScratchArchive, 1)
+	err := m.transport.Subscribe(replyTopic, 1, func(topic string, payload []byte) {
+		var reply tinpot.MqttScratchArchive
+		if _, err := tinpot.DecodeMessage(payload, "", &reply); err != nil {
+			log.Printf("Invalid scratch archive: %v", err)
+			return
+		}
+		select {
+		case replies <- reply:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer m.transport.Unsubscribe(replyTopic)
+	payload, _ := json.Marshal(tinpot.MqttScratchRequest{ExecutionID: executionID, ReplyTopic: replyTopic})
+	if err := m.transport.Publish(tinpot.ScratchTopic(tenant), 1, false, payload); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-replies:
+		if reply.Error != "" {
+			return nil, fmt.Errorf("worker %s: %s", reply.WorkerID, reply.Error)
+		}
+		return reply.Archive, nil
+	case <-ctx.Done():
+		return nil, tinpot.ErrScratchNotFound
+	}
+}
+
+func (m *actionManager) publish(event tinpot.ExecutionEvent) {
+	if m.events != nil {
+		m.events.Publish(event)
+	}
+}
+
+// catalogChanged notifies the event bus and the MQTT subscribers of the tenant's catalog topic
+func (m *actionManager) catalogChanged(eventType, tenant, name string) {
+	// The canary version of an action is listed with the stable one
+	if stable, ok := strings.CutSuffix(name, "@"+tinpot.TrackCanary); ok {
+		eventType, name = tinpot.EventActionUpdated, stable
+	}
+	event := tinpot.ExecutionEvent{Type: eventType, Action: name, Tenant: tenant, Time: time.Now()}
+	m.publish(event)
+	payload, _ := json.Marshal(event)
+	// Publishing from the message handler must not wait for the acknowledgement
+	go func() {
+		if err := m.transport.Publish(tinpot.CatalogTopic(tenant), 1, false, payload); err != nil {
+			log.Printf("Failed to publish catalog change: %v", err)
+		}
+	}()
+}
+
+func (m *actionManager) onWorkerStatus(topic string, payload []byte) {
+	m.seen(topic, payload, true)
+	parts := strings.Split(topic, "/")
+	var tenant, id string
+	switch {
+	case len(parts) == 3:
+		// tinpot/workers/{id}
+		id = parts[2]
+	case len(parts) == 5 && parts[1] == "tenants" && parts[3] == "workers":
+		// tinpot/tenants/{tenant}/workers/{id}
+		tenant, id = parts[2], parts[4]
+	default:
+		m.unexpected(topic, payload)
+		return
+	}
+	if !validNames(tenant, id) {
+		m.invalid(tenant, "", tinpot.KindWorkerStatus, topic, payload, fmt.Errorf("invalid worker ID %q", id))
+		return
+	}
+	key := tinpot.QualifiedName(tenant, id)
+
+	var status tinpot.MqttWorkerStatus
+	if len(payload) > 0 {
+		if _, err := tinpot.DecodeMessage(payload, "", &status); err != nil {
+			m.invalid(tenant, id, tinpot.KindWorkerStatus, topic, payload, err)
+			return
+		}
+	}
+	if !status.Online {
+		m.mu.Lock()
+		_, known := m.workers[key]
+		delete(m.workers, key)
+		delete(m.running, key)
+		for session, binding := range m.sessions {
+			if binding.worker == key {
+				delete(m.sessions, session)
+			}
+		}
+		m.mu.Unlock()
+		if known {
+			log.Printf("Worker disconnected: %s", key)
+			m.publish(tinpot.ExecutionEvent{Type: tinpot.EventWorkerDisconnected, Tenant: tenant, Worker: id})
+		}
+		return
+	}
+
+	m.mu.Lock()
+	previous, known := m.workers[key]
+	m.workers[key] = status
+	m.mu.Unlock()
+	if !known || previous.Since != status.Since {
+		log.Printf("Worker connected: %s", key)
+		m.publish(tinpot.ExecutionEvent{Type: tinpot.EventWorkerConnected, Tenant: tenant, Worker: id})
+	}
+}
+
+func (m *actionManager) onActionAnnounced(topic string, payload []byte) {
+	m.seen(topic, payload, m.delivery.Announce.Retained)
+	parts := strings.Split(topic, "/")
+	var actionName string
+	switch {
+	case len(parts) == 3:
+		// tinpot/actions/{name}
+		actionName = parts[2]
+	case len(parts) == 5 && parts[1] == "tenants" && parts[3] == "actions":
+		// tinpot/tenants/{tenant}/actions/{name}
+		actionName = tinpot.QualifiedName(parts[2], parts[4])
+	default:
+		m.unexpected(topic, payload)
+		return
+	}
+
+	tenant, name := tinpot.SplitQualifiedName(actionName)
+	if !validNames(tenant, strings.TrimSuffix(name, "@"+tinpot.TrackCanary)) {
+		m.invalid(tenant, "", tinpot.KindAnnouncement, topic, payload, fmt.Errorf("invalid action name %q", actionName))
+		return
+	}
+	if len(payload) == 0 {
+		m.mu.Lock()
+		_, known := m.actions[actionName]
+		delete(m.actions, actionName)
+		m.mu.Unlock()
+		log.Printf("Action removed: %s", actionName)
+		if known {
+			m.catalogChanged(tinpot.EventActionRemoved, tenant, name)
+		}
+		return
+	}
+
+	var act tinpot.MqttAction
+	if _, err := tinpot.DecodeMessage(payload, "", &act); err != nil {
+		m.invalid(tenant, "", tinpot.KindAnnouncement, topic, payload, err)
+		return
+	}
+	if strings.ContainsAny(act.TriggerTopic+act.ExecPrefix, "+#") {
+		m.invalid(tenant, "", tinpot.KindAnnouncement, topic, payload, fmt.Errorf("wildcards in the topics of action %s", actionName))
+		return
+	}
+
+	m.mu.Lock()
+	previous, known := m.actions[actionName]
+	m.actions[actionName] = act
+	m.mu.Unlock()
+	switch {
+	case !known:
+		log.Printf("Action discovered: %s", actionName)
+		m.catalogChanged(tinpot.EventActionAdded, tenant, name)
+	case !reflect.DeepEqual(previous, act):
+		log.Printf("Action updated: %s", actionName)
+		m.catalogChanged(tinpot.EventActionUpdated, tenant, name)
+	}
+}
+
+// validNames tells whether the tenant (empty for the default one) and the name taken from a
+// topic are valid, see tinpot.ValidName
+func validNames(tenant, name string) bool {
+	return (tenant == "" || tinpot.ValidName(tenant)) && tinpot.ValidName(name)
+}
+
+// ListActions lists the canary versions with their stable ones, see tinpot.ActionInfo.Canary.
+// An action only announced as canary is listed as is.
+func (m *actionManager) ListActions() map[string]tinpot.ActionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]tinpot.ActionInfo)
+	for qualified, act := range m.actions {
+		if act.Track != tinpot.TrackCanary {
+			result[qualified] = actionInfo(qualified, act)
+		}
+	}
+	for qualified, act := range m.actions {
+		stable, ok := strings.CutSuffix(qualified, "@"+tinpot.TrackCanary)
+		if act.Track != tinpot.TrackCanary || !ok {
+			continue
+		}
+		if info, ok := result[stable]; ok {
+			info.Canary = &tinpot.CanaryInfo{Bundle: act.Bundle}
+			result[stable] = info
+		} else {
+			result[stable] = actionInfo(stable, act)
+		}
+	}
+	return result
+}
+
+func actionInfo(qualified string, act tinpot.MqttAction) tinpot.ActionInfo {
+	tenant, name := tinpot.SplitQualifiedName(qualified)
+	return tinpot.ActionInfo{
+		Name:         name,
+		Description:  act.Description,
+		Group:        act.Group,
+		Parameters:   act.Parameters,
+		Tenant:       tenant,
+		Docs:         act.Docs,
+		Examples:     act.Examples,
+		Translations: act.Translations,
+		Bundle:       act.Bundle,
+		Sandbox:      act.Sandbox,
+		RunAs:        act.RunAs,
+		Selector:     act.Selector,
+		Debounce:     act.Debounce,
+		Capabilities: act.Capabilities,
+	}
+}
+
+type actionExecution struct {
+	manager   *actionManager
+	action    *tinpot.MqttAction
+	name      string
+	transport tinpot.Transport
+	delivery  tinpot.DeliveryConfig
+	tenant    string
+}
+
+// execution of the announced action, nil if it is not announced
+func (m *actionManager) execution(name string) *actionExecution {
+	m.mu.RLock()
+	act, ok := m.actions[name]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	tenant, actionName := tinpot.SplitQualifiedName(name)
+	return &actionExecution{
+		manager:   m,
+		action:    &act,
+		name:      strings.TrimSuffix(actionName, "@"+tinpot.TrackCanary),
+		transport: m.transport,
+		delivery:  m.delivery,
+		tenant:    tenant,
+	}
+}
+
+type CloserFunc func() error
+
+func (cf CloserFunc) Close() error {
+	return cf()
+}
+
+func (act *actionExecution) Closer(topics ...string) io.Closer {
+	return CloserFunc(func() error {
+		return act.transport.Unsubscribe(topics...)
+	})
+}
+
+// cleanup acknowledges the result, stops listening to the execution and clears its
+// retained messages from the broker
+func (act *actionExecution) cleanup(closer io.Closer, resultTopic, logTopic, ackTopic string, properties map[string]string) {
+	if err := tinpot.PublishWithProperties(act.transport, ackTopic, act.delivery.Result.QoS, false, []byte("{}"), properties); err != nil {
+		log.Printf("Failed to acknowledge result: %v", err)
+	}
+	closer.Close()
+	if act.delivery.Result.Retained {
+		tinpot.PublishWithProperties(act.transport, resultTopic, act.delivery.Result.QoS, true, nil, properties)
+	}
+	if act.delivery.Log.Retained {
+		tinpot.PublishWithProperties(act.transport, logTopic, act.delivery.Log.QoS, true, nil, properties)
+	}
+}
+
+func (act *actionExecution) handleResponse(worker, topic string, payload []byte, parameters map[string]interface{}, response tinpot.ActionResponse) {
+	var res tinpot.MqttResultResponse
+	if _, err := tinpot.DecodeMessage(payload, tinpot.MessageResult, &res); err != nil {
+		act.manager.invalid(act.tenant, worker, tinpot.KindResult, topic, payload, err)
+		response(fmt.Sprintf("invalid result: %v", err), nil)
+		return
+	}
+	if res.Usage != nil {
+		tinpot.ReportUsage(parameters, *res.Usage)
+	}
+	if res.Environment != nil {
+		tinpot.ReportEnvironment(parameters, *res.Environment)
+	}
+	if started, err := time.Parse(time.RFC3339, res.StartedAt); err == nil {
+		times := tinpot.WorkerTimes{StartedAt: &started}
+		if completed, err := time.Parse(time.RFC3339, res.CompletedAt); err == nil {
+			times.CompletedAt = &completed
+		}
+		tinpot.ReportTiming(parameters, times)
+	}
+	if res.Status == "SUCCESS" {
+		// Workers usually send a JSON object, anything else is wrapped to match the callback signature
+		var resMap map[string]interface{}
+		if m, ok := res.Result.(map[string]interface{}); ok {
+			resMap = m
+		} else {
+			resMap = map[string]interface{}{"value": res.Result}
+		}
+		response("", resMap)
+	} else {
+		response(res.Error, nil)
+	}
+}
+
+// decodeLogs unpacks a log message: a single entry, or a batch of them as array
+func decodeLogs(payload []byte) ([]tinpot.MqttLogEntry, error) {
+	var entries []tinpot.MqttLogEntry
+	if _, err := tinpot.DecodeMessage(payload, tinpot.MessageLog, &entries); err == nil {
+		return entries, nil
+	}
+	var entry tinpot.MqttLogEntry
+	if _, err := tinpot.DecodeMessage(payload, tinpot.MessageLog, &entry); err != nil {
+		return nil, err
+	}
+	return []tinpot.MqttLogEntry{entry}, nil
+}
+
+func (act *actionExecution) trigger(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+	// Extract or generate Execution ID
+	var execID string
+	if id, ok := parameters["_execution_id"].(string); ok {
+		execID = id
+	} else {
+		execID = uuid.New().String()
+	}
+	if !tinpot.ValidName(execID) {
+		if response != nil {
+			response(fmt.Sprintf("invalid execution ID %q", execID), nil)
+		}
+		return
+	}
+
+	traceID, _ := parameters["_trace_id"].(string)
+	var expiresAt *time.Time
+	if deadline, err := time.Parse(time.RFC3339Nano, fmt.Sprint(parameters["_expires_at"])); err == nil {
+		// In UTC, as binary formats don't keep the time zone
+		deadline = deadline.UTC()
+		expiresAt = &deadline
+	}
+	properties := tinpot.ExecutionProperties(execID, act.name, act.tenant, traceID)
+
+	// Filter internal parameters
+	actualParams := make(map[string]interface{})
+	for k, v := range parameters {
+		if !strings.HasPrefix(k, "_") {
+			actualParams[k] = v
+		}
+	}
+
+	// The execution goes to the least loaded of the eligible workers, those without a topic
+	// layout in their status only take it from the announced trigger topic
+	requested, _ := parameters["_selector"].(string)
+	selector, err := tinpot.ParseSelector(act.action.Selector + "," + requested)
+	if err != nil {
+		if response != nil {
+			response(fmt.Sprintf("invalid selector: %v", err), nil)
+		}
+		return
+	}
+	session, _ := parameters["_session"].(string)
+	triggerTopic, execPrefix := act.action.TriggerTopic, act.action.ExecPrefix
+	workerKey, status, err := act.manager.pickWorker(act.tenant, act.name, act.action.Track, selector, session)
+	if err != nil {
+		if response != nil {
+			response(err.Error(), nil)
+		}
+		return
+	}
+	picked := workerKey != ""
+	// The worker answering, unknown unless routed to one
+	worker := status.ID
+	switch {
+	case picked:
+		triggerTopic = tinpot.WorkerTriggerTopic(act.tenant, status.ID, act.name)
+		execPrefix = ""
+		if status.TopicLayout == tinpot.TopicLayoutWorker {
+			execPrefix = tinpot.WorkerExecPrefix(act.tenant, status.ID)
+		}
+		log.Printf("Execution %s of %s routed to worker %s", execID, act.name, workerKey)
+	case !selector.Empty() || session != "":
+		if response != nil {
+			response(fmt.Sprintf("%s: no online worker with %s matches %q", tinpot.NoEligibleWorkerError, act.name, selector), nil)
+		}
+		return
+	}
+	if execPrefix == "" {
+		execPrefix = tinpot.TopicPrefix(act.tenant) + "exec/"
+	}
+	resultTopic := execPrefix + execID + "/result"
+	logTopic := execPrefix + execID + "/log"
+	ackTopic := execPrefix + execID + "/ack"
+	acceptedTopic := execPrefix + execID + "/accepted"
+	closer := act.Closer(resultTopic, logTopic, acceptedTopic)
+
+	var responded sync.Once
+	var ordered *logReorderer
+	if logs != nil {
+		ordered = newLogReorderer(act.manager.logWindow, logs)
+	}
+	subscribe := func() {
+		// 1. Subscribe to Log Topic (if logs callback provided)
+		if ordered != nil {
+			act.transport.Subscribe(logTopic, act.delivery.Log.QoS, func(topic string, payload []byte) {
+				entries, err := decodeLogs(payload)
+				if err != nil && len(payload) > 0 {
+					act.manager.invalid(act.tenant, worker, tinpot.KindLog, topic, payload, err)
+					return
+				}
+				for _, entry := range entries {
+					ordered.add(entry)
+				}
+			})
+		}
+
+		act.transport.Subscribe(acceptedTopic, act.delivery.Result.QoS, func(topic string, payload []byte) {
+			var accepted tinpot.MqttAccepted
+			if _, err := tinpot.DecodeMessage(payload, tinpot.MessageAccepted, &accepted); err != nil {
+				if len(payload) > 0 {
+					act.manager.invalid(act.tenant, worker, tinpot.KindAccepted, topic, payload, err)
+				}
+				return
+			}
+			if started, err := time.Parse(time.RFC3339, accepted.AcceptedAt); err == nil {
+				tinpot.ReportTiming(parameters, tinpot.WorkerTimes{StartedAt: &started})
+			}
+		})
+
+		// 2. Subscribe to Result Topic
+		err := act.transport.Subscribe(resultTopic, act.delivery.Result.QoS, func(topic string, payload []byte) {
+			responded.Do(func() {
+				act.manager.setPending(execID, nil)
+				if picked {
+					act.manager.finished(workerKey)
+				}
+				// Waiting for the unsubscription inside a message handler would block the
+				// client's message delivery, which deadlocks under concurrent executions
+				go act.cleanup(closer, resultTopic, logTopic, ackTopic, properties)
+				if ordered != nil {
+					ordered.flush()
+				}
+				if response != nil {
+					act.handleResponse(worker, topic, payload, parameters, response)
+				}
+			})
+		})
+		if err != nil {
+			log.Printf("Failed to subscribe to result topic: %v", err)
+		}
+	}
+	act.manager.setPending(execID, subscribe)
+	subscribe()
+
+	// 3. Publish Execution Request
+	req := tinpot.MqttExecutionRequest{
+		ExecutionID:   execID,
+		Parameters:    actualParams,
+		ResultTopic:   resultTopic,
+		LogTopic:      logTopic,
+		AckTopic:      ackTopic,
+		AcceptedTopic: acceptedTopic,
+		TraceID:       traceID,
+		BatchLogs:     true,
+		ExpiresAt:     expiresAt,
+	}
+	if slices.Contains(act.action.Encodings, act.manager.encoding) {
+		req.ContentEncoding = act.manager.encoding
+	}
+	if slices.Contains(act.action.Formats, act.manager.format) {
+		req.ContentFormat = act.manager.format
+	}
+	if signing := act.manager.signing; signing != nil {
+		if err := signing.SignRequest(triggerTopic, &req); err != nil {
+			act.manager.setPending(execID, nil)
+			if picked {
+				act.manager.finished(workerKey)
+			}
+			closer.Close()
+			if response != nil {
+				response(fmt.Sprintf("failed to sign request: %v", err), nil)
+			}
+			return
+		}
+	}
+	payloadBytes, _ := tinpot.MarshalMessage(req.ContentFormat, act.action.SchemaVersion, tinpot.MessageExecutionRequest, req)
+	published := time.Now()
+	if err := tinpot.PublishWithProperties(act.transport, triggerTopic, act.delivery.Trigger.QoS, act.delivery.Trigger.Retained, payloadBytes, properties); err != nil {
+		act.manager.setPending(execID, nil)
+		if picked {
+			act.manager.finished(workerKey)
+		}
+		closer.Close()
+		if response != nil {
+			response(fmt.Sprintf("failed to publish request: %v", err), nil)
+		}
+		return
+	}
+	tinpot.ReportPublish(parameters, published)
+}
+
+type sessionBinding struct {
+	worker string
+	used   time.Time
+}
+
+// pickWorker returns the least loaded (by the executions routed to it) of the online workers of
+// the tenant and release track that have the action, match the selector and can be addressed on their
+// WorkerTriggerTopic. The executions are spread among equally loaded ones. The executions of a
+// session stay on its worker while it is online. No worker is picked ("") when none is eligible.
+func (m *actionManager) pickWorker(tenant, action, track string, selector tinpot.Selector, session string) (string, tinpot.MqttWorkerStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	eligible := func(key string, status tinpot.MqttWorkerStatus) bool {
+		t, _ := tinpot.SplitQualifiedName(key)
+		return t == tenant && status.Track == track && status.TopicLayout != "" && slices.Contains(status.Actions, action) && selector.Matches(status.Labels)
+	}
+	sessionKey := tinpot.QualifiedName(tenant, session)
+	if binding, ok := m.sessions[sessionKey]; session != "" && ok && time.Since(binding.used) < m.sessionIdle {
+		status := m.workers[binding.worker]
+		if !eligible(binding.worker, status) {
+			return "", status, fmt.Errorf("%s: worker %s of session %s does not run %s matching %q", tinpot.NoEligibleWorkerError, binding.worker, session, action, selector)
+		}
+		m.sessions[sessionKey] = sessionBinding{worker: binding.worker, used: time.Now()}
+		m.running[binding.worker]++
+		return binding.worker, status, nil
+	}
+
+	var candidates []string
+	least := -1
+	for key, status := range m.workers {
+		if !eligible(key, status) {
+			continue
+		}
+		switch load := m.running[key]; {
+		case least < 0 || load < least:
+			candidates, least = []string{key}, load
+		case load == least:
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", tinpot.MqttWorkerStatus{}, nil
+	}
+	key := candidates[rand.IntN(len(candidates))]
+	m.running[key]++
+	if session != "" {
+		for s, binding := range m.sessions {
+			if time.Since(binding.used) >= m.sessionIdle {
+				delete(m.sessions, s)
+			}
+		}
+		m.sessions[sessionKey] = sessionBinding{worker: key, used: time.Now()}
+		log.Printf("Session %s bound to worker %s", sessionKey, key)
+	}
+	return key, m.workers[key], nil
+}
+
+// finished counts an execution routed by pickWorker as done
+func (m *actionManager) finished(workerKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running[workerKey] > 0 {
+		m.running[workerKey]--
+	}
+}
+
+// GetAction triggers the stable version of the action, or its canary version if the
+// tinpot.TrackParameter asks for it. The canary is taken when there is no stable one.
+func (m *actionManager) GetAction(name string) tinpot.ActionTrigger {
+	stable, canary := m.execution(name), m.execution(tinpot.CanaryName(name))
+	switch {
+	case stable == nil && canary == nil:
+		return nil
+	case canary == nil:
+		return stable.trigger
+	case stable == nil:
+		return canary.trigger
+	}
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		if parameters[tinpot.TrackParameter] == tinpot.TrackCanary {
+			canary.trigger(parameters, response, logs)
+		} else {
+			stable.trigger(parameters, response, logs)
+		}
+	}
+}
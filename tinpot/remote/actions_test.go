@@ -0,0 +1,302 @@
+package remote_test
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/remote"
+	"github.com/balazsgrill/tinpot/tinpottest"
+	"github.com/balazsgrill/tinpot/worker"
+)
+
+func TestActionManagerContract(t *testing.T) {
+	for _, compression := range []string{"", tinpot.EncodingGzip, tinpot.EncodingZstd} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+				broker := tinpottest.StartBroker(t)
+				tinpottest.StartWorker(t, broker, tinpottest.ContractActions(), worker.Options{})
+				mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{Compression: compression})
+				tinpottest.WaitFor(t, func() bool { return len(mgr.ListActions()) == 3 })
+				return mgr
+			})
+		})
+	}
+	for _, format := range tinpot.Formats {
+		t.Run("format="+format, func(t *testing.T) {
+			tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+				broker := tinpottest.StartBroker(t)
+				tinpottest.StartWorker(t, broker, tinpottest.ContractActions(), worker.Options{})
+				mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{Compression: tinpot.EncodingZstd, Format: format})
+				tinpottest.WaitFor(t, func() bool { return len(mgr.ListActions()) == 3 })
+				return mgr
+			})
+		})
+	}
+}
+
+func TestSelectorRouting(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	linux := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "probe"}, tinpottest.Echo())
+	windows := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "probe"}, tinpottest.Echo())
+	tinpottest.StartWorker(t, broker, linux, worker.Options{ID: "linux-1", Labels: map[string]string{"os": "linux"}})
+	tinpottest.StartWorker(t, broker, windows, worker.Options{ID: "windows-1", Labels: map[string]string{"os": "windows"}})
+
+	events := tinpot.NewEventBus()
+	connected := make(chan string, 2)
+	events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventWorkerConnected {
+			connected <- event.Worker
+		}
+	})
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{Events: events})
+	tinpottest.WaitFor(t, func() bool { return len(connected) == 2 && mgr.GetAction("probe") != nil })
+
+	run := func(selector string) string {
+		t.Helper()
+		done := make(chan string, 1)
+		mgr.GetAction("probe")(map[string]interface{}{"_selector": selector}, func(err string, result map[string]interface{}) {
+			done <- err
+		}, nil)
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("execution did not complete")
+			return ""
+		}
+	}
+	for range 3 {
+		if err := run("os=windows"); err != "" {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if len(windows.Calls("probe")) != 3 || len(linux.Calls("probe")) != 0 {
+		t.Errorf("executions not routed by the selector: %d on windows, %d on linux", len(windows.Calls("probe")), len(linux.Calls("probe")))
+	}
+	if err := run("os=linux,gpu"); !strings.HasPrefix(err, tinpot.NoEligibleWorkerError) {
+		t.Errorf("unexpected error: %q", err)
+	}
+}
+
+func TestSessionAffinity(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	managers := map[string]*tinpottest.ActionManager{}
+	for _, id := range []string{"w-1", "w-2"} {
+		managers[id] = tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "mount"}, tinpottest.Echo())
+		tinpottest.StartWorker(t, broker, managers[id], worker.Options{ID: id})
+	}
+	events := tinpot.NewEventBus()
+	presence := make(chan tinpot.ExecutionEvent, 10)
+	events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventWorkerConnected || event.Type == tinpot.EventWorkerDisconnected {
+			presence <- event
+		}
+	})
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{Events: events})
+	tinpottest.WaitFor(t, func() bool { return len(presence) == 2 && mgr.GetAction("mount") != nil })
+
+	run := func(session string) {
+		t.Helper()
+		done := make(chan string, 1)
+		mgr.GetAction("mount")(map[string]interface{}{"_session": session}, func(err string, result map[string]interface{}) {
+			done <- err
+		}, nil)
+		select {
+		case err := <-done:
+			if err != "" {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("execution did not complete")
+		}
+	}
+	calls := func(id string) int { return len(managers[id].Calls("mount")) }
+	for range 4 {
+		run("volume-a")
+	}
+	bound, other := "w-1", "w-2"
+	if calls("w-2") == 4 {
+		bound, other = other, bound
+	}
+	if calls(bound) != 4 {
+		t.Fatalf("session spread over workers: %d and %d", calls("w-1"), calls("w-2"))
+	}
+
+	// The session moves on once its worker is gone
+	status, _ := json.Marshal(tinpot.MqttWorkerStatus{ID: bound, Online: false})
+	tinpottest.Connect(t, broker).Publish(tinpot.WorkerStatusTopic("", bound), 1, false, status)
+	tinpottest.WaitFor(t, func() bool { return len(presence) == 3 })
+	run("volume-a")
+	run("volume-a")
+	if calls(other) != 2 {
+		t.Errorf("session not moved to %s: %d calls", other, calls(other))
+	}
+}
+
+func TestBrokerAdmin(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "probe"}, tinpottest.Echo())
+	tinpottest.StartWorker(t, broker, actions, worker.Options{ID: "w1", TopicLayout: tinpot.TopicLayoutWorker})
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{})
+	admin := mgr.(tinpot.BrokerAdmin)
+	tinpottest.WaitFor(t, func() bool { return len(admin.RetainedMessages("")) == 2 })
+	if topics := admin.RetainedMessages(""); topics[0].Topic != "tinpot/actions/probe" || topics[1].Topic != "tinpot/workers/w1" {
+		t.Errorf("unexpected retained messages: %+v", topics)
+	}
+	if topics := admin.RetainedMessages("team-a"); len(topics) != 0 {
+		t.Errorf("retained messages of another tenant: %+v", topics)
+	}
+
+	// A result left behind by a coordinator that went away
+	client := tinpottest.Connect(t, broker)
+	stale := tinpot.WorkerExecPrefix("", "w1") + "stale/result"
+	client.Publish(stale, 1, true, []byte(`{"status": "success"}`))
+	cleared, err := admin.PurgeExecution("", "stale")
+	if err != nil || !slices.Contains(cleared, stale) || !slices.Contains(cleared, "tinpot/exec/stale/log") {
+		t.Fatalf("unexpected purge: %v %v", cleared, err)
+	}
+	received := make(chan []byte, 1)
+	client.Subscribe(stale, 1, func(topic string, payload []byte) { received <- payload })
+	select {
+	case payload := <-received:
+		t.Errorf("result still retained: %s", payload)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if _, err := admin.PurgeExecution("", "#"); err == nil {
+		t.Error("wildcard execution ID purged")
+	}
+
+	// Workers announce themselves again after their announcements were cleared
+	client.Publish("tinpot/actions/probe", 1, true, nil)
+	tinpottest.WaitFor(t, func() bool { return mgr.GetAction("probe") == nil })
+	if err := admin.Rescan(""); err != nil {
+		t.Fatal(err)
+	}
+	tinpottest.WaitFor(t, func() bool { return mgr.GetAction("probe") != nil })
+}
+
+func TestCanaryRelease(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	stable := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "probe"}, tinpottest.Echo())
+	canary := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "probe", Bundle: &tinpot.BundleInfo{Name: "ops", Version: "2.0"}}, tinpottest.Echo())
+	tinpottest.StartWorker(t, broker, stable, worker.Options{ID: "stable-1"})
+	tinpottest.StartWorker(t, broker, canary, worker.Options{ID: "canary-1", Track: tinpot.TrackCanary})
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{})
+	tinpottest.WaitFor(t, func() bool { return mgr.ListActions()["probe"].Canary != nil })
+	if actions := mgr.ListActions(); len(actions) != 1 || actions["probe"].Canary.Bundle.Version != "2.0" {
+		t.Fatalf("unexpected catalog: %+v", actions)
+	}
+
+	run := func(track string) {
+		t.Helper()
+		done := make(chan string, 1)
+		mgr.GetAction("probe")(map[string]interface{}{tinpot.TrackParameter: track}, func(err string, result map[string]interface{}) {
+			done <- err
+		}, nil)
+		select {
+		case err := <-done:
+			if err != "" {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("execution did not complete")
+		}
+	}
+	for range 3 {
+		run(tinpot.TrackStable)
+	}
+	run(tinpot.TrackCanary)
+	if len(stable.Calls("probe")) != 3 || len(canary.Calls("probe")) != 1 {
+		t.Errorf("executions not routed by track: %d stable, %d canary", len(stable.Calls("probe")), len(canary.Calls("probe")))
+	}
+}
+
+func TestLogOrdering(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	fake := tinpottest.Connect(t, broker)
+	fake.Subscribe("tinpot/test/scramble", 1, func(topic string, payload []byte) {
+		var req tinpot.MqttExecutionRequest
+		tinpot.UnmarshalMessage(payload, tinpot.MessageExecutionRequest, &req)
+		go func() {
+			publish := func(sequence uint64) {
+				entry, _ := json.Marshal(tinpot.MqttLogEntry{Level: "INFO", Message: strconv.FormatUint(sequence, 10), Sequence: sequence})
+				fake.Publish(req.LogTopic, 1, false, entry)
+			}
+			for _, sequence := range []uint64{2, 3, 1, 2, 5} {
+				publish(sequence)
+			}
+			time.Sleep(100 * time.Millisecond)
+			publish(4)
+			publish(7)
+			fake.Publish(req.ResultTopic, 1, false, []byte(`{"status": "SUCCESS", "result": {}}`))
+		}()
+	})
+	fake.Publish("tinpot/actions/scramble", 1, true, []byte(`{"description": "", "trigger_topic": "tinpot/test/scramble"}`))
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{LogReorderWindow: 20 * time.Millisecond})
+	tinpottest.WaitFor(t, func() bool { return mgr.GetAction("scramble") != nil })
+
+	done := make(chan struct{})
+	var logs []string
+	mgr.GetAction("scramble")(map[string]interface{}{}, func(err string, result map[string]interface{}) {
+		close(done)
+	}, func(level, message string) {
+		logs = append(logs, message)
+	})
+	<-done
+	// 4 came after its gap was skipped, 7 is flushed before the result
+	if strings.Join(logs, ",") != "1,2,3,5,4,7" {
+		t.Errorf("unexpected log order: %v", logs)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	fake := tinpottest.Connect(t, broker)
+	diagnostics := make(chan tinpot.MqttDiagnostic, 10)
+	fake.Subscribe(tinpot.DiagnosticsTopic(""), 0, func(topic string, payload []byte) {
+		var diagnostic tinpot.MqttDiagnostic
+		json.Unmarshal(payload, &diagnostic)
+		diagnostics <- diagnostic
+	})
+	events := tinpot.NewEventBus()
+	invalid := make(chan tinpot.ExecutionEvent, 10)
+	events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventInvalidMessage {
+			invalid <- event
+		}
+	})
+	mgr := remote.NewActionManager(tinpottest.Connect(t, broker), remote.Options{Events: events})
+
+	fake.Publish("tinpot/actions/broken", 1, false, []byte(`{"description": 42}`))
+	fake.Publish("tinpot/actions/ok", 1, false, []byte(`{"description": "", "trigger_topic": "tinpot/actions/ok/trigger"}`))
+	tinpottest.WaitFor(t, func() bool { return mgr.GetAction("ok") != nil })
+	if mgr.GetAction("broken") != nil {
+		t.Error("malformed announcement was registered")
+	}
+	select {
+	case diagnostic := <-diagnostics:
+		if diagnostic.Topic != "tinpot/actions/broken" || diagnostic.Kind != tinpot.KindAnnouncement || diagnostic.Error == "" {
+			t.Errorf("unexpected diagnostic: %+v", diagnostic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no diagnostic published")
+	}
+	if event := <-invalid; event.Diagnostic == nil || event.Diagnostic.Topic != "tinpot/actions/broken" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	fake.Publish(tinpot.WorkerTelemetryTopic("", "w1"), 0, false, []byte(`{"cpu_percent": "high"}`))
+	<-invalid
+	report := mgr.(tinpot.BrokerAdmin).InvalidMessages("")
+	if report.Total != 2 || report.ByKind[tinpot.KindTelemetry] != 1 || report.ByWorker["w1"] != 1 || len(report.Samples) != 2 || report.Samples[0].Worker != "w1" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if report := mgr.(tinpot.BrokerAdmin).InvalidMessages("team-a"); report.Total != 0 {
+		t.Errorf("other tenant reported: %+v", report)
+	}
+}
@@ -0,0 +1,75 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// maxInvalidSamples is how many of the latest dropped messages are kept by tenant
+const maxInvalidSamples = 50
+
+// invalid reports a message dropped by the manager, sent by the worker if known: it is
+// counted, logged, published as tinpot.EventInvalidMessage and on the diagnostics topic of
+// the tenant
+func (m *actionManager) invalid(tenant, worker, kind, topic string, payload []byte, err error) {
+	log.Printf("Dropped %s message on %s: %v", kind, topic, err)
+	diagnostic := tinpot.MqttDiagnostic{Topic: topic, Kind: kind, Worker: worker, Error: err.Error(), Size: len(payload), At: time.Now().Format(time.RFC3339Nano)}
+
+	m.droppedMu.Lock()
+	dropped, ok := m.dropped[tenant]
+	if !ok {
+		dropped = &tinpot.InvalidMessages{ByKind: make(map[string]int), ByWorker: make(map[string]int)}
+		m.dropped[tenant] = dropped
+	}
+	dropped.Total++
+	dropped.ByKind[kind]++
+	if worker != "" {
+		dropped.ByWorker[worker]++
+	}
+	dropped.Samples = append(dropped.Samples, diagnostic)
+	if len(dropped.Samples) > maxInvalidSamples {
+		dropped.Samples = dropped.Samples[1:]
+	}
+	m.droppedMu.Unlock()
+
+	m.publish(tinpot.ExecutionEvent{Type: tinpot.EventInvalidMessage, Tenant: tenant, Worker: worker, Time: time.Now(), Diagnostic: &diagnostic})
+	data, _ := json.Marshal(diagnostic)
+	// Not waiting for the broker inside a message handler, see catalogChanged
+	go func() {
+		if err := m.transport.Publish(tinpot.DiagnosticsTopic(tenant), 0, false, data); err != nil {
+			log.Printf("Failed to publish diagnostic: %v", err)
+		}
+	}()
+}
+
+// unexpected reports a message on a topic the subscriptions should not have matched
+func (m *actionManager) unexpected(topic string, payload []byte) {
+	tenant := ""
+	if rest, ok := strings.CutPrefix(topic, tinpot.MQTT_TENANT_TOPIC_PREFIX); ok {
+		tenant, _, _ = strings.Cut(rest, "/")
+	}
+	m.invalid(tenant, "", tinpot.KindUnexpected, topic, payload, fmt.Errorf("unexpected topic"))
+}
+
+// InvalidMessages counts the messages of the tenant dropped since connecting, see
+// tinpot.BrokerAdmin
+func (m *actionManager) InvalidMessages(tenant string) tinpot.InvalidMessages {
+	m.droppedMu.Lock()
+	defer m.droppedMu.Unlock()
+	report := tinpot.InvalidMessages{ByKind: map[string]int{}, ByWorker: map[string]int{}, Samples: []tinpot.MqttDiagnostic{}}
+	if dropped, ok := m.dropped[tenant]; ok {
+		report.Total = dropped.Total
+		maps.Copy(report.ByKind, dropped.ByKind)
+		maps.Copy(report.ByWorker, dropped.ByWorker)
+		report.Samples = append(report.Samples, dropped.Samples...)
+		slices.Reverse(report.Samples)
+	}
+	return report
+}
@@ -0,0 +1,99 @@
+package remote
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// logReorderer passes the log entries of an execution on in the order of their sequence
+// numbers. Entries arriving ahead of a missing one are held back for up to the window,
+// then the gap is skipped.
+type logReorderer struct {
+	window time.Duration
+	emit   tinpot.ActionLogs
+
+	mu      sync.Mutex
+	next    uint64 // sequence number expected next
+	pending map[uint64]tinpot.MqttLogEntry
+	skipped map[uint64]bool
+	timer   *time.Timer
+}
+
+func newLogReorderer(window time.Duration, emit tinpot.ActionLogs) *logReorderer {
+	return &logReorderer{window: window, emit: emit, next: 1, pending: make(map[uint64]tinpot.MqttLogEntry), skipped: make(map[uint64]bool)}
+}
+
+func (r *logReorderer) add(entry tinpot.MqttLogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case entry.Sequence == 0:
+		// Workers predating the sequence numbers
+		r.emit(entry.Level, entry.Message)
+		return
+	case entry.Sequence < r.next:
+		// Late after its gap was skipped, or a redelivered duplicate
+		if r.skipped[entry.Sequence] {
+			delete(r.skipped, entry.Sequence)
+			r.emit(entry.Level, entry.Message)
+		}
+		return
+	}
+	r.pending[entry.Sequence] = entry
+	r.release()
+	if len(r.pending) > 0 && r.timer == nil {
+		r.timer = time.AfterFunc(r.window, r.expire)
+	}
+}
+
+// release emits the pending entries following the last emitted one
+func (r *logReorderer) release() {
+	for {
+		entry, ok := r.pending[r.next]
+		if !ok {
+			break
+		}
+		delete(r.pending, r.next)
+		r.emit(entry.Level, entry.Message)
+		r.next++
+	}
+	if len(r.pending) == 0 && r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// expire skips the gap before the earliest pending entry
+func (r *logReorderer) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timer = nil
+	if len(r.pending) == 0 {
+		return
+	}
+	r.skipTo(slices.Min(slices.Collect(maps.Keys(r.pending))))
+	r.release()
+	if len(r.pending) > 0 {
+		r.timer = time.AfterFunc(r.window, r.expire)
+	}
+}
+
+func (r *logReorderer) skipTo(sequence uint64) {
+	for ; r.next < sequence; r.next++ {
+		r.skipped[r.next] = true
+	}
+}
+
+// flush emits every pending entry in order, before the result
+func (r *logReorderer) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.pending) > 0 {
+		r.skipTo(slices.Min(slices.Collect(maps.Keys(r.pending))))
+		r.release()
+	}
+}
@@ -0,0 +1,283 @@
+// Package replay records executions to files and serves them back as an ActionManager, for
+// deterministic integration tests and offline demos from production traces.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+// Redacted replaces the secrets in the recordings
+const Redacted = "[REDACTED]"
+
+// DefaultRedact are the parameter and result field names redacted by default, matched as
+// case-insensitive substrings
+var DefaultRedact = []string{"password", "secret", "token", "credential", "api_key", "private_key"}
+
+// Recording is an execution captured by the Recorder: what triggered it, its logs and outcome
+type Recording struct {
+	ExecutionID string            `json:"execution_id"`
+	Action      tinpot.ActionInfo `json:"action"`
+	// Parameters of the request, without the internal ones
+	Parameters      map[string]interface{} `json:"parameters"`
+	Logs            []RecordedLog          `json:"logs"`
+	Result          map[string]interface{} `json:"result,omitempty"`
+	Error           string                 `json:"error,omitempty"`
+	StartedAt       time.Time              `json:"started_at"`
+	DurationSeconds float64                `json:"duration_seconds"`
+}
+
+// RecordedLog is a log line of a Recording
+type RecordedLog struct {
+	// OffsetSeconds is the time since the execution was triggered
+	OffsetSeconds float64 `json:"offset_seconds"`
+	Level         string  `json:"level"`
+	Message       string  `json:"message"`
+}
+
+// RecorderOptions configures a Recorder
+type RecorderOptions struct {
+	// Redact are the names of the secret parameters and result fields, as case-insensitive
+	// substrings, DefaultRedact when nil. Parameters with a password widget are always
+	// redacted, and the values of the redacted parameters are also removed from the logs,
+	// the results and the errors.
+	Redact []string
+}
+
+// Recorder is an ActionManager writing every execution of another one to a file under its
+// directory, {dir}/{qualified action}/{execution id}.json
+type Recorder struct {
+	tinpot.ActionManager
+	dir  string
+	opts RecorderOptions
+}
+
+func NewRecorder(mgr tinpot.ActionManager, dir string, opts RecorderOptions) *Recorder {
+	if opts.Redact == nil {
+		opts.Redact = DefaultRedact
+	}
+	return &Recorder{ActionManager: mgr, dir: dir, opts: opts}
+}
+
+func (r *Recorder) GetAction(name string) tinpot.ActionTrigger {
+	trigger := r.ActionManager.GetAction(name)
+	if trigger == nil {
+		return nil
+	}
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		act := r.ActionManager.ListActions()[name]
+		act.Tenant, act.Name = tinpot.SplitQualifiedName(name)
+		rec := &Recording{Action: act, Parameters: map[string]interface{}{}, Logs: []RecordedLog{}, StartedAt: time.Now()}
+		rec.ExecutionID, _ = parameters["_execution_id"].(string)
+		if rec.ExecutionID == "" {
+			rec.ExecutionID = uuid.NewString()
+		}
+		for k, v := range parameters {
+			if !strings.HasPrefix(k, "_") {
+				rec.Parameters[k] = v
+			}
+		}
+		var mu sync.Mutex
+		trigger(parameters, func(err string, result map[string]interface{}) {
+			mu.Lock()
+			rec.Error, rec.Result = err, result
+			rec.DurationSeconds = time.Since(rec.StartedAt).Seconds()
+			if err := r.save(name, rec); err != nil {
+				log.Printf("Failed to record execution %s: %v", rec.ExecutionID, err)
+			}
+			mu.Unlock()
+			response(err, result)
+		}, func(level, message string) {
+			mu.Lock()
+			rec.Logs = append(rec.Logs, RecordedLog{OffsetSeconds: time.Since(rec.StartedAt).Seconds(), Level: level, Message: message})
+			mu.Unlock()
+			if logs != nil {
+				logs(level, message)
+			}
+		})
+	}
+}
+
+func (r *Recorder) save(qualified string, rec *Recording) error {
+	data, err := json.MarshalIndent(r.redact(*rec), "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(r.dir, filepath.FromSlash(qualified))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filepath.Base(rec.ExecutionID)+".json"), data, 0o644)
+}
+
+func (r *Recorder) secret(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range r.opts.Redact {
+		if strings.Contains(name, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns a copy of the recording without the secrets
+func (r *Recorder) redact(rec Recording) Recording {
+	var secrets []string
+	parameters := make(map[string]interface{}, len(rec.Parameters))
+	for name, value := range rec.Parameters {
+		param := rec.Action.Parameters[name]
+		if r.secret(name) || (param.UI != nil && param.UI.Widget == "password") {
+			if s := fmt.Sprint(value); s != "" {
+				secrets = append(secrets, s)
+			}
+			value = Redacted
+		}
+		parameters[name] = value
+	}
+	rec.Parameters = parameters
+	scrub := func(s string) string {
+		for _, secret := range secrets {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+		return s
+	}
+	logs := make([]RecordedLog, len(rec.Logs))
+	for i, line := range rec.Logs {
+		line.Message = scrub(line.Message)
+		logs[i] = line
+	}
+	rec.Logs = logs
+	rec.Error = scrub(rec.Error)
+	if rec.Result != nil {
+		rec.Result = r.redactValue(rec.Result, scrub).(map[string]interface{})
+	}
+	return rec
+}
+
+func (r *Recorder) redactValue(value interface{}, scrub func(string) string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			if r.secret(k) {
+				redacted[k] = Redacted
+			} else {
+				redacted[k] = r.redactValue(v, scrub)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, v := range value {
+			redacted[i] = r.redactValue(v, scrub)
+		}
+		return redacted
+	case string:
+		return scrub(value)
+	}
+	return value
+}
+
+// Options configures a replaying Manager
+type Options struct {
+	// Paced replays the log lines at their recorded offsets instead of right away
+	Paced bool
+}
+
+// Manager is an ActionManager answering executions with the recordings of a directory. An
+// execution gets the recording of its action whose parameters it matches (the redacted ones
+// match anything), or the latest recording of the action.
+type Manager struct {
+	opts Options
+	// recordings by qualified action name, latest first
+	recordings map[string][]Recording
+}
+
+// Load reads the recordings of the directory, written by a Recorder
+func Load(dir string, opts Options) (*Manager, error) {
+	m := &Manager{opts: opts, recordings: make(map[string][]Recording)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("invalid recording %s: %w", path, err)
+		}
+		qualified := tinpot.QualifiedName(rec.Action.Tenant, rec.Action.Name)
+		m.recordings[qualified] = append(m.recordings[qualified], rec)
+		return nil
+	})
+	for _, recs := range m.recordings {
+		sort.SliceStable(recs, func(i, j int) bool { return recs[i].StartedAt.After(recs[j].StartedAt) })
+	}
+	return m, err
+}
+
+func (m *Manager) ListActions() map[string]tinpot.ActionInfo {
+	actions := make(map[string]tinpot.ActionInfo, len(m.recordings))
+	for qualified, recs := range m.recordings {
+		actions[qualified] = recs[0].Action
+	}
+	return actions
+}
+
+func (m *Manager) GetAction(name string) tinpot.ActionTrigger {
+	recs := m.recordings[name]
+	if len(recs) == 0 {
+		return nil
+	}
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		rec := recs[0]
+		for _, candidate := range recs {
+			if matches(candidate.Parameters, parameters) {
+				rec = candidate
+				break
+			}
+		}
+		go func() {
+			start := time.Now()
+			for _, line := range rec.Logs {
+				if m.opts.Paced {
+					time.Sleep(time.Until(start.Add(time.Duration(line.OffsetSeconds * float64(time.Second)))))
+				}
+				if logs != nil {
+					logs(line.Level, line.Message)
+				}
+			}
+			if m.opts.Paced {
+				time.Sleep(time.Until(start.Add(time.Duration(rec.DurationSeconds * float64(time.Second)))))
+			}
+			response(rec.Error, rec.Result)
+		}()
+	}
+}
+
+func (m *Manager) IsConnected() bool {
+	return true
+}
+
+func matches(recorded, parameters map[string]interface{}) bool {
+	for name, value := range recorded {
+		if value != Redacted && !reflect.DeepEqual(parameters[name], value) {
+			return false
+		}
+	}
+	return true
+}
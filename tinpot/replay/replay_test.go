@@ -0,0 +1,72 @@
+package replay_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/replay"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+type execution struct {
+	logs   []string
+	err    string
+	result map[string]interface{}
+}
+
+func run(t *testing.T, mgr tinpot.ActionManager, name string, params map[string]interface{}) execution {
+	t.Helper()
+	done := make(chan execution, 1)
+	var logs []string
+	mgr.GetAction(name)(params, func(err string, result map[string]interface{}) {
+		done <- execution{logs: logs, err: err, result: result}
+	}, func(level, message string) {
+		logs = append(logs, level+" "+message)
+	})
+	return <-done
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "deploy", Tenant: "team-a", Description: "Deploy a release"}, tinpottest.Script{
+			Logs:   []tinpottest.LogLine{{Level: "INFO", Message: "logging in with hunter2"}, {Level: "INFO", Message: "deployed"}},
+			Result: map[string]interface{}{"version": "1.2", "session_token": "abc"},
+		}.Trigger()).
+		Add(tinpot.ActionInfo{Name: "check"}, tinpottest.Echo())
+	recorder := replay.NewRecorder(mgr, dir, replay.RecorderOptions{})
+
+	recorded := run(t, recorder, "team-a/deploy", map[string]interface{}{"release": "1.2", "password": "hunter2", "_execution_id": "e1"})
+	run(t, recorder, "check", map[string]interface{}{"host": "a", "_execution_id": "e2"})
+	run(t, recorder, "check", map[string]interface{}{"host": "b", "_execution_id": "e3"})
+
+	data, err := os.ReadFile(filepath.Join(dir, "team-a", "deploy", "e1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Contains(string(data), "abc") || !strings.Contains(string(data), replay.Redacted) {
+		t.Errorf("secrets recorded: %s", data)
+	}
+
+	replayed, err := replay.Load(dir, replay.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actions := replayed.ListActions(); len(actions) != 2 || actions["team-a/deploy"].Description != "Deploy a release" {
+		t.Errorf("unexpected catalog: %+v", actions)
+	}
+	again := run(t, replayed, "team-a/deploy", map[string]interface{}{"release": "1.2", "password": "other"})
+	if len(again.logs) != len(recorded.logs) || again.logs[1] != recorded.logs[1] || again.result["version"] != "1.2" {
+		t.Errorf("unexpected replay: %+v", again)
+	}
+	for host, expected := range map[string]string{"a": "a", "b": "b", "c": "b"} {
+		result := run(t, replayed, "check", map[string]interface{}{"host": host}).result
+		if !reflect.DeepEqual(result, map[string]interface{}{"host": expected}) {
+			t.Errorf("host %s replayed %v", host, result)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package tinpot
+
+import "sort"
+
+// jsonSchemaTypes maps the Python type names announced by workers to JSON Schema types
+var jsonSchemaTypes = map[string]string{
+	"str":   "string",
+	"int":   "integer",
+	"float": "number",
+	"bool":  "boolean",
+	"list":  "array",
+	"dict":  "object",
+}
+
+// ParameterOrder returns the parameter names in form order
+func ParameterOrder(params map[string]ParameterInfo) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, oj := 0, 0
+		if ui := params[names[i]].UI; ui != nil {
+			oi = ui.Order
+		}
+		if ui := params[names[j]].UI; ui != nil {
+			oj = ui.Order
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// FormSchema describes the parameters of an action as a JSON Schema and a
+// uiSchema (react-jsonschema-form conventions) holding the presentation hints.
+func FormSchema(act ActionInfo) (schema map[string]interface{}, uiSchema map[string]interface{}) {
+	properties := make(map[string]interface{})
+	required := []string{}
+	uiSchema = make(map[string]interface{})
+
+	order := ParameterOrder(act.Parameters)
+	for _, name := range order {
+		p := act.Parameters[name]
+		prop := parameterSchema(p)
+		if p.Required {
+			required = append(required, name)
+		}
+
+		if ui := p.UI; ui != nil {
+			hints := make(map[string]interface{})
+			if ui.Title != "" {
+				prop["title"] = ui.Title
+			}
+			if ui.Help != "" {
+				prop["description"] = ui.Help
+				hints["ui:help"] = ui.Help
+			}
+			if len(ui.Choices) > 0 {
+				prop["enum"] = ui.Choices
+			}
+			if ui.ChoicesFrom != nil {
+				// Resolved on demand, see GET /api/actions/{name}/parameters/{param}/options
+				hints["ui:optionsFrom"] = ui.ChoicesFrom.Action
+			}
+			if ui.Widget != "" {
+				hints["ui:widget"] = ui.Widget
+			}
+			if ui.Placeholder != "" {
+				hints["ui:placeholder"] = ui.Placeholder
+			}
+			if ui.Group != "" {
+				hints["ui:group"] = ui.Group
+			}
+			if len(ui.VisibleIf) > 0 {
+				hints["ui:visibleIf"] = ui.VisibleIf
+			}
+			if len(hints) > 0 {
+				uiSchema[name] = hints
+			}
+		}
+		properties[name] = prop
+	}
+	uiSchema["ui:order"] = order
+
+	schema = map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       act.Name,
+		"description": act.Description,
+		"type":        "object",
+		"properties":  properties,
+		"required":    required,
+	}
+	return schema, uiSchema
+}
+
+// parameterSchema is the JSON Schema of a parameter's value, without the form hints
+func parameterSchema(p ParameterInfo) map[string]interface{} {
+	prop := map[string]interface{}{}
+	if t, ok := jsonSchemaTypes[p.Type]; ok {
+		prop["type"] = t
+	} else {
+		prop["type"] = "string"
+	}
+	if p.Default != nil {
+		prop["default"] = p.Default
+	}
+	if len(p.Enum) > 0 {
+		prop["enum"] = p.Enum
+	}
+	if p.Items != nil {
+		prop["items"] = parameterSchema(*p.Items)
+	}
+	if len(p.Properties) > 0 {
+		properties := make(map[string]interface{}, len(p.Properties))
+		required := []string{}
+		for _, name := range ParameterOrder(p.Properties) {
+			properties[name] = parameterSchema(p.Properties[name])
+			if p.Properties[name].Required {
+				required = append(required, name)
+			}
+		}
+		prop["properties"] = properties
+		prop["required"] = required
+	}
+	return prop
+}
@@ -0,0 +1,109 @@
+package tinpot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NoEligibleWorkerError starts the error of the executions whose selector no online worker matches
+const NoEligibleWorkerError = "NO_ELIGIBLE_WORKER"
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// ParseLabels reads worker labels like "os=linux,site=berlin"
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || !labelPattern.MatchString(key) || !labelPattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+type requirement struct {
+	key, value string
+	// op is "=", "!=", "" (the label is set) or "!" (it is not)
+	op string
+}
+
+// Selector picks workers by their labels. It is parsed from comma separated requirements,
+// all of which must hold: "key=value", "key!=value", "key" (the label is set) and "!key"
+// (it is not).
+type Selector struct {
+	requirements []requirement
+}
+
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			r.key, r.value, _ = strings.Cut(part, "!=")
+			r.op = "!="
+		case strings.Contains(part, "="):
+			r.key, r.value, _ = strings.Cut(part, "=")
+			r.op = "="
+		case strings.HasPrefix(part, "!"):
+			r.key, r.op = part[1:], "!"
+		default:
+			r.key = part
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if !labelPattern.MatchString(r.key) || (r.op == "=" || r.op == "!=") && !labelPattern.MatchString(r.value) {
+			return Selector{}, fmt.Errorf("invalid selector requirement %q", part)
+		}
+		selector.requirements = append(selector.requirements, r)
+	}
+	return selector, nil
+}
+
+// Empty tells whether the selector matches every worker
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// Matches tells whether the labels meet every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.requirements {
+		value, set := labels[r.key]
+		var ok bool
+		switch r.op {
+		case "=":
+			ok = set && value == r.value
+		case "!=":
+			ok = !set || value != r.value
+		case "!":
+			ok = !set
+		default:
+			ok = set
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s.requirements))
+	for i, r := range s.requirements {
+		switch r.op {
+		case "=", "!=":
+			parts[i] = r.key + r.op + r.value
+		default:
+			parts[i] = r.op + r.key
+		}
+	}
+	return strings.Join(parts, ",")
+}
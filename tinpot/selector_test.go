@@ -0,0 +1,41 @@
+package tinpot
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	labels, err := ParseLabels("os=linux, site=berlin,gpu=a100")
+	if err != nil || len(labels) != 3 || labels["site"] != "berlin" {
+		t.Fatalf("ParseLabels() = %v, %v", labels, err)
+	}
+	for _, tc := range []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"os=linux,site=berlin", true},
+		{"os=windows", false},
+		{"site!=paris", true},
+		{"gpu", true},
+		{"!gpu", false},
+		{"zone!=b", true},
+	} {
+		selector, err := ParseSelector(tc.selector)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %v", tc.selector, err)
+		}
+		if selector.Matches(labels) != tc.matches {
+			t.Errorf("%q matches %v", tc.selector, !tc.matches)
+		}
+		if selector.String() != tc.selector {
+			t.Errorf("String() = %q, want %q", selector.String(), tc.selector)
+		}
+	}
+	for _, invalid := range []string{"os=", "=linux", "os==linux", "!"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("ParseSelector(%q) accepted", invalid)
+		}
+	}
+	if _, err := ParseLabels("os"); err == nil {
+		t.Error("label without value accepted")
+	}
+}
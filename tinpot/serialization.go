@@ -0,0 +1,240 @@
+package tinpot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Formats of the trigger, result and log messages besides JSON
+const (
+	FormatCBOR    = "cbor"
+	FormatMsgpack = "msgpack"
+)
+
+// Formats are the binary formats workers announce, in order of preference
+var Formats = []string{FormatCBOR, FormatMsgpack}
+
+var (
+	// cborMagic is the self-described CBOR tag (55799) prefixing the CBOR messages
+	cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+	cborEncoder, _ = cbor.EncOptions{Time: cbor.TimeRFC3339NanoUTC}.EncMode()
+	cborDecoder, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+)
+
+// ParseFormat validates a message format, empty for JSON
+func ParseFormat(format string) (string, error) {
+	if format != "" && !slices.Contains(Formats, format) {
+		return "", fmt.Errorf("unknown message format %q, expected one of %v", format, Formats)
+	}
+	return format, nil
+}
+
+// MarshalPayload encodes a message in the format, JSON if empty. The fields are named after
+// their JSON tags in every format.
+func MarshalPayload(format string, v interface{}) ([]byte, error) {
+	switch format {
+	case FormatCBOR:
+		data, err := cborEncoder.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(slices.Clip(cborMagic), data...), nil
+	case FormatMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	}
+	return json.Marshal(v)
+}
+
+// PayloadFormat tells the format of a message: CBOR by its magic bytes, msgpack by starting
+// with a map or an array, JSON otherwise
+func PayloadFormat(payload []byte) string {
+	switch {
+	case bytes.HasPrefix(payload, cborMagic):
+		return FormatCBOR
+	case len(payload) > 0 && (payload[0]&0xe0 == 0x80 || payload[0] >= 0xdc && payload[0] <= 0xdf):
+		return FormatMsgpack
+	}
+	return ""
+}
+
+// UnmarshalPayload decodes a JSON, CBOR or msgpack message. The numbers and times in the
+// decoded values are the same as encoding/json would give (float64, UTC).
+func UnmarshalPayload(payload []byte, v interface{}) error {
+	return unmarshalFormat(PayloadFormat(payload), payload, v)
+}
+
+func unmarshalFormat(format string, data []byte, v interface{}) error {
+	switch format {
+	case FormatCBOR:
+		if err := cborDecoder.Unmarshal(data, v); err != nil {
+			return err
+		}
+	case FormatMsgpack:
+		if err := checkMsgpack(data); err != nil {
+			return err
+		}
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	default:
+		return json.Unmarshal(data, v)
+	}
+	normalize(reflect.ValueOf(v))
+	return nil
+}
+
+// checkMsgpack rejects msgpack messages with arrays, maps, strings or binaries longer than
+// what is left of the message. The decoder allocates the declared lengths upfront, a few
+// bytes claiming billions of items would exhaust the memory.
+func checkMsgpack(data []byte) error {
+	// Values left to check, each takes at least a byte
+	for pending := 1; pending > 0; pending-- {
+		if len(data) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		c := data[0]
+		data = data[1:]
+		// length is read from the header bytes following c, items are the values contained
+		// and skip the bytes of the value itself
+		var header, items, skip int
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c&0xf0 == 0x80:
+			items = 2 * int(c&0x0f)
+		case c&0xf0 == 0x90:
+			items = int(c & 0x0f)
+		case c&0xe0 == 0xa0:
+			skip = int(c & 0x1f)
+		case c == 0xc4 || c == 0xd9:
+			header = 1
+		case c == 0xc5 || c == 0xda || c == 0xdc || c == 0xde:
+			header = 2
+		case c == 0xc6 || c == 0xdb || c == 0xdd || c == 0xdf:
+			header = 4
+		case c == 0xc7:
+			header, skip = 1, 1
+		case c == 0xc8:
+			header, skip = 2, 1
+		case c == 0xc9:
+			header, skip = 4, 1
+		case c == 0xcc || c == 0xd0:
+			skip = 1
+		case c == 0xcd || c == 0xd1:
+			skip = 2
+		case c == 0xca || c == 0xce || c == 0xd2:
+			skip = 4
+		case c == 0xcb || c == 0xcf || c == 0xd3:
+			skip = 8
+		case c >= 0xd4 && c <= 0xd8:
+			skip = 1 + 1<<(c-0xd4)
+		default:
+			return fmt.Errorf("invalid msgpack code 0x%x", c)
+		}
+		if header > len(data) {
+			return io.ErrUnexpectedEOF
+		}
+		length := 0
+		for _, b := range data[:header] {
+			length = length<<8 | int(b)
+		}
+		data = data[header:]
+		switch {
+		case c == 0xdc || c == 0xdd:
+			items = length
+		case c == 0xde || c == 0xdf:
+			items = 2 * length
+		default:
+			skip += length
+		}
+		if items > len(data) || skip > len(data) {
+			return fmt.Errorf("msgpack value of %d items, %d bytes exceeds the message", items, skip)
+		}
+		data = data[skip:]
+		pending += items
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// normalize converts the decoded values to the types encoding/json decodes to
+func normalize(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			normalize(v.Elem())
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(v.Interface().(time.Time).UTC()))
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				normalize(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			normalize(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() == reflect.Interface {
+			for iter := v.MapRange(); iter.Next(); {
+				if value := iter.Value(); !value.IsNil() {
+					v.SetMapIndex(iter.Key(), reflect.ValueOf(normalizeValue(value.Interface())))
+				}
+			}
+		}
+	case reflect.Interface:
+		if !v.IsNil() && v.CanSet() {
+			v.Set(reflect.ValueOf(normalizeValue(v.Interface())))
+		}
+	}
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = normalizeValue(item)
+		}
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			converted[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeValue(item)
+		}
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	}
+	if n := reflect.ValueOf(value); n.CanInt() {
+		return float64(n.Int())
+	} else if n.CanUint() {
+		return float64(n.Uint())
+	} else if n.CanFloat() {
+		return n.Float()
+	}
+	return value
+}
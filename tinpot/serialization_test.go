@@ -0,0 +1,44 @@
+package tinpot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSerialization(t *testing.T) {
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600))
+	req := MqttExecutionRequest{
+		ExecutionID: "e1",
+		Parameters:  map[string]interface{}{"count": 3, "ratio": 0.5, "tags": []interface{}{"a", int64(-1)}, "nested": map[string]interface{}{"ok": true}},
+		ResultTopic: "r",
+		ExpiresAt:   &expires,
+	}
+	want := map[string]interface{}{"count": 3.0, "ratio": 0.5, "tags": []interface{}{"a", -1.0}, "nested": map[string]interface{}{"ok": true}}
+	for _, format := range append(Formats, "") {
+		payload, err := MarshalPayload(format, req)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if PayloadFormat(payload) != format {
+			t.Errorf("%q detected as %q", format, PayloadFormat(payload))
+		}
+		var decoded MqttExecutionRequest
+		if err := UnmarshalPayload(payload, &decoded); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if decoded.ExecutionID != "e1" || decoded.ResultTopic != "r" || !reflect.DeepEqual(decoded.Parameters, want) || !decoded.ExpiresAt.Equal(expires) {
+			t.Errorf("%s round trip: %+v", format, decoded)
+		}
+
+		entries := []MqttLogEntry{{Level: "INFO", Message: "one"}, {Level: "INFO", Message: "two"}}
+		payload, _ = MarshalPayload(format, entries)
+		var batch []MqttLogEntry
+		if err := UnmarshalPayload(payload, &batch); err != nil || !reflect.DeepEqual(batch, entries) {
+			t.Errorf("%s log batch round trip: %v %+v", format, err, batch)
+		}
+	}
+	if _, err := ParseFormat("protobuf"); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
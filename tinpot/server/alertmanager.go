@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// AlertRoute runs an action for the alerts matching all of its matchers
+type AlertRoute struct {
+	// Matchers use the Alertmanager syntax: label=value, label!=value, label=~regex, label!~regex
+	Matchers []string `json:"matchers"`
+	Action   string   `json:"action"`
+	// Parameters are fixed, they take precedence over the annotations
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Resolved runs the action for resolved alerts too, otherwise only for firing ones
+	Resolved bool `json:"resolved,omitempty"`
+	// Continue goes on matching the later routes, otherwise the first matching route wins
+	Continue bool `json:"continue,omitempty"`
+}
+
+// AlertmanagerConfig routes the alerts posted to /api/integrations/alertmanager to actions, as JSON:
+//
+//	{"routes": [
+//	  {"matchers": ["alertname=DiskFull", "severity=~critical|page"], "action": "cleanup_disk"}
+//	]}
+//
+// The actions are those of the caller's tenant.
+type AlertmanagerConfig struct {
+	Routes []AlertRoute `json:"routes"`
+}
+
+// AlertmanagerWebhook is the payload of the Alertmanager webhook receiver
+type AlertmanagerWebhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     string            `json:"startsAt"`
+	EndsAt       string            `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// LoadAlertmanagerConfig reads an AlertmanagerConfig from a JSON file
+func LoadAlertmanagerConfig(path string) (AlertmanagerConfig, error) {
+	var config AlertmanagerConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	_, err = config.compile()
+	return config, err
+}
+
+type labelMatcher struct {
+	label  string
+	negate bool
+	value  *regexp.Regexp
+}
+
+var matcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"?(.*?)"?\s*$`)
+
+func parseMatcher(s string) (labelMatcher, error) {
+	m := matcherPattern.FindStringSubmatch(s)
+	if m == nil {
+		return labelMatcher{}, fmt.Errorf("invalid matcher %q", s)
+	}
+	value := regexp.QuoteMeta(m[3])
+	if strings.HasSuffix(m[2], "~") {
+		value = m[3]
+	}
+	re, err := regexp.Compile("^(?:" + value + ")$")
+	if err != nil {
+		return labelMatcher{}, fmt.Errorf("invalid matcher %q: %w", s, err)
+	}
+	return labelMatcher{label: m[1], negate: strings.HasPrefix(m[2], "!"), value: re}, nil
+}
+
+type alertRoute struct {
+	AlertRoute
+	matchers []labelMatcher
+}
+
+func (c AlertmanagerConfig) compile() ([]alertRoute, error) {
+	var routes []alertRoute
+	for i, route := range c.Routes {
+		if route.Action == "" {
+			return nil, fmt.Errorf("routes[%d]: missing action", i)
+		}
+		compiled := alertRoute{AlertRoute: route}
+		for _, s := range route.Matchers {
+			m, err := parseMatcher(s)
+			if err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			compiled.matchers = append(compiled.matchers, m)
+		}
+		routes = append(routes, compiled)
+	}
+	return routes, nil
+}
+
+// matches tells if the labels satisfy all matchers, missing labels are empty
+func (r alertRoute) matches(labels map[string]string) bool {
+	for _, m := range r.matchers {
+		if m.value.MatchString(labels[m.label]) == m.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// POST /api/integrations/alertmanager executes the actions of the routes matching the alerts
+func (s *Server) receiveAlerts(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	var payload AlertmanagerWebhook
+	if err := s.decodeBody(w, r, &payload); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	resp := []AlertExecution{}
+	for _, alert := range payload.Alerts {
+		for _, route := range s.alertRoutes {
+			if !route.matches(alert.Labels) {
+				continue
+			}
+			if alert.Status == "firing" || route.Resolved {
+				resp = append(resp, s.executeAlert(tenant, alert, route))
+			}
+			if !route.Continue {
+				break
+			}
+		}
+	}
+	writeJSON(w, 200, resp)
+}
+
+// executeAlert runs the action of a route with the annotations of the alert as parameters.
+// Of actions declaring their parameters, only the annotations named like one are passed.
+func (s *Server) executeAlert(tenant string, alert Alert, route alertRoute) AlertExecution {
+	result := AlertExecution{Alert: alert.Labels["alertname"], Fingerprint: alert.Fingerprint, Action: route.Action}
+	declared := s.mgr.ListActions()[tinpot.QualifiedName(tenant, route.Action)].Parameters
+	parameters := make(map[string]interface{}, len(alert.Annotations)+len(route.Parameters))
+	for name, value := range alert.Annotations {
+		if _, ok := declared[name]; ok || len(declared) == 0 {
+			parameters[name] = value
+		}
+	}
+	for name, value := range route.Parameters {
+		parameters[name] = value
+	}
+
+	rec := &responseRecorder{header: make(http.Header)}
+	s.submit(rec, submission{
+		tenant:     tenant,
+		action:     route.Action,
+		parameters: parameters,
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginAlertmanager, ID: result.Alert},
+	}, false)
+	if rec.status >= 300 {
+		var detail map[string]interface{}
+		json.Unmarshal(rec.body.Bytes(), &detail)
+		result.Error = fmt.Sprintf("%d %v", rec.status, detail["detail"])
+		return result
+	}
+	var execution ExecutionResponse
+	json.Unmarshal(rec.body.Bytes(), &execution)
+	result.ExecutionID, result.Status = execution.ExecutionID, execution.Status
+	return result
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// AlertRule watches the outcomes of an action: it fires when its executions failed
+// ConsecutiveFailures times in a row, or when none succeeded for NoSuccessWithin (a duration
+// like "24h"). It resolves with the next success.
+type AlertRule struct {
+	Name string `json:"name"`
+	// Action is qualified by the tenant
+	Action              string `json:"action"`
+	ConsecutiveFailures int    `json:"consecutive_failures,omitempty"`
+	NoSuccessWithin     string `json:"no_success_within,omitempty"`
+	// Webhooks receive the alerts of the rule besides AlertRules.Webhooks
+	Webhooks []string `json:"webhooks,omitempty"`
+}
+
+// AlertRules are evaluated by the coordinator on every completed execution, as JSON:
+//
+//	{
+//	  "webhooks": ["https://hooks.example.com/tinpot"],
+//	  "rules": [
+//	    {"name": "backup failing", "action": "backup", "consecutive_failures": 3},
+//	    {"name": "sync stale", "action": "team-a/sync", "no_success_within": "24h"}
+//	  ]
+//	}
+//
+// Firing and resolved alerts are published as alert_firing and alert_resolved events and
+// POSTed to the webhooks as an AlertState.
+type AlertRules struct {
+	Webhooks []string    `json:"webhooks,omitempty"`
+	Rules    []AlertRule `json:"rules"`
+}
+
+// AlertState is the state of an AlertRule, as reported by /api/alerts and sent to the webhooks
+type AlertState struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Tenant string `json:"tenant,omitempty"`
+	Firing bool   `json:"firing"`
+	// Message explains why the alert fires
+	Message string `json:"message,omitempty"`
+	// Since is when the alert fired or resolved
+	Since               time.Time  `json:"since,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	// ExecutionID is the last execution of the action
+	ExecutionID string `json:"execution_id,omitempty"`
+}
+
+// alertCheckInterval is how often the rules without successful runs are checked at most
+const alertCheckInterval = time.Minute
+
+// LoadAlertRules reads AlertRules from a JSON file
+func LoadAlertRules(path string) (AlertRules, error) {
+	var rules AlertRules
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, err
+	}
+	_, err = rules.compile()
+	return rules, err
+}
+
+type alertRule struct {
+	AlertRule
+	tenant, action  string
+	noSuccessWithin time.Duration
+	webhooks        []string
+	state           AlertState
+}
+
+func (r AlertRules) compile() ([]*alertRule, error) {
+	var compiled []*alertRule
+	for _, rule := range r.Rules {
+		c := &alertRule{AlertRule: rule, webhooks: append(append([]string(nil), r.Webhooks...), rule.Webhooks...)}
+		c.tenant, c.action = tinpot.SplitQualifiedName(rule.Action)
+		if rule.Name == "" || c.action == "" {
+			return nil, fmt.Errorf("alert rules need a name and an action: %+v", rule)
+		}
+		if rule.NoSuccessWithin != "" {
+			d, err := time.ParseDuration(rule.NoSuccessWithin)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s: invalid no_success_within %q", rule.Name, rule.NoSuccessWithin)
+			}
+			c.noSuccessWithin = d
+		}
+		if rule.ConsecutiveFailures < 0 || rule.ConsecutiveFailures == 0 && c.noSuccessWithin == 0 {
+			return nil, fmt.Errorf("%s: needs consecutive_failures or no_success_within", rule.Name)
+		}
+		for _, hook := range c.webhooks {
+			if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("%s: invalid webhook %q", rule.Name, hook)
+			}
+		}
+		c.state = AlertState{Rule: rule.Name, Action: c.action, Tenant: c.tenant}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// alerter evaluates the AlertRules
+type alerter struct {
+	server *Server
+	mu     sync.Mutex
+	rules  []*alertRule
+}
+
+func (s *Server) startAlerts(rules []*alertRule) {
+	a := &alerter{server: s, rules: rules}
+	s.alerts = a
+	// Staleness is counted from the last success in the history, or from now
+	now := time.Now()
+	interval := alertCheckInterval
+	for _, rule := range rules {
+		if rule.noSuccessWithin > 0 {
+			interval = min(interval, rule.noSuccessWithin)
+		}
+		rule.state.LastSuccess = &now
+		last, err := s.store.List(tinpot.ExecutionFilter{Tenant: rule.tenant, Action: rule.action, Status: tinpot.StatusSuccess, Archived: tinpot.IncludeArchived, Limit: 1})
+		if err == nil && len(last) > 0 && last[0].CompletedAt != nil {
+			rule.state.LastSuccess = last[0].CompletedAt
+		}
+	}
+	s.events.Subscribe(a.completed)
+	go func() {
+		for range time.Tick(interval) {
+			a.checkStale()
+		}
+	}()
+}
+
+func (a *alerter) completed(event tinpot.ExecutionEvent) {
+	if event.Type != tinpot.EventCompleted {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rule := range a.rules {
+		if rule.tenant != event.Tenant || rule.action != event.Action {
+			continue
+		}
+		rule.state.ExecutionID = event.ExecutionID
+		if event.Status == tinpot.StatusSuccess {
+			now := time.Now()
+			rule.state.ConsecutiveFailures, rule.state.LastSuccess = 0, &now
+			if rule.state.Firing {
+				a.transition(rule, false, "")
+			}
+			continue
+		}
+		rule.state.ConsecutiveFailures++
+		if n := rule.ConsecutiveFailures; n > 0 && rule.state.ConsecutiveFailures >= n && !rule.state.Firing {
+			a.transition(rule, true, fmt.Sprintf("%s failed %d times in a row, last: %s", event.Action, rule.state.ConsecutiveFailures, event.Error))
+		}
+	}
+}
+
+func (a *alerter) checkStale() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rule := range a.rules {
+		if rule.noSuccessWithin > 0 && !rule.state.Firing && time.Since(*rule.state.LastSuccess) >= rule.noSuccessWithin {
+			a.transition(rule, true, fmt.Sprintf("no successful run of %s since %s", rule.action, rule.state.LastSuccess.Format(time.RFC3339)))
+		}
+	}
+}
+
+// transition fires or resolves the alert of a rule. Must hold mu.
+func (a *alerter) transition(rule *alertRule, firing bool, message string) {
+	rule.state.Firing, rule.state.Message, rule.state.Since = firing, message, time.Now()
+	eventType := tinpot.EventAlertResolved
+	if firing {
+		eventType = tinpot.EventAlertFiring
+		log.Printf("Alert %s firing: %s", rule.Name, message)
+	} else {
+		log.Printf("Alert %s resolved", rule.Name)
+	}
+	a.server.events.Publish(tinpot.ExecutionEvent{
+		Type: eventType, ExecutionID: rule.state.ExecutionID, Action: rule.action, Tenant: rule.tenant,
+		Alert: rule.Name, Message: message,
+	})
+	payload, _ := json.Marshal(rule.state)
+	for _, hook := range rule.webhooks {
+		go notifyWebhook(hook, payload)
+	}
+}
+
+func notifyWebhook(hook string, payload []byte) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(hook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Failed to send alert to %s: %v", hook, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Failed to send alert to %s: %s", hook, resp.Status)
+	}
+}
+
+// listAlerts reports the alert rules of the caller's tenant, the firing ones first
+func (s *Server) listAlerts(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	states := []AlertState{}
+	if s.alerts != nil {
+		s.alerts.mu.Lock()
+		for _, rule := range s.alerts.rules {
+			if rule.tenant == tenant {
+				states = append(states, rule.state)
+			}
+		}
+		s.alerts.mu.Unlock()
+	}
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Firing && !states[j].Firing
+	})
+	writeJSON(w, 200, states)
+}
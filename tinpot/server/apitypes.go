@@ -0,0 +1,413 @@
+package server
+
+import (
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// API Request/Response models
+type ExecuteActionRequest struct {
+	Parameters map[string]interface{} `json:"parameters"`
+	// NotBefore holds the trigger until then, NotAfter expires the execution if no worker
+	// picked it up by then
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// Selector is matched against the worker labels on top of the selector of the action
+	Selector string `json:"selector,omitempty"`
+	// Session routes the executions sharing it to the same worker while it is online
+	Session string `json:"session,omitempty"`
+	// Priority is "low", "normal" (default) or "high", see SheddingPolicy
+	Priority string `json:"priority,omitempty"`
+}
+
+// ScheduleAtRequest runs an action once at a time, see POST /api/actions/{name}/schedule_at.
+// The At time replaces NotBefore.
+type ScheduleAtRequest struct {
+	At time.Time `json:"at"`
+	ExecuteActionRequest
+}
+
+type ExecutionResponse struct {
+	ExecutionID string `json:"execution_id"`
+	ActionName  string `json:"action_name"`
+	Status      string `json:"status"`
+	StreamURL   string `json:"stream_url"`
+}
+
+type SyncExecutionResponse struct {
+	ExecutionID string      `json:"execution_id"`
+	ActionName  string      `json:"action_name"`
+	Status      string      `json:"status"`
+	Result      interface{} `json:"result"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// ScheduleRequest runs an action periodically, see PUT /api/schedules/{id}
+type ScheduleRequest struct {
+	Action string `json:"action"`
+	// Cron is a standard 5 field expression or a descriptor like "@hourly"
+	Cron string `json:"cron"`
+	// Timezone is the IANA name of the zone Cron is in, e.g. "Europe/Budapest", so the runs
+	// keep their local time across DST changes. The coordinator's local time by default.
+	Timezone   string                 `json:"timezone,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Paused schedules keep their configuration but don't run
+	Paused bool `json:"paused,omitempty"`
+	// Jitter delays every run by a random duration up to it, e.g. "30s"
+	Jitter string `json:"jitter,omitempty"`
+	// CatchUp is what happens to the runs missed while the coordinator was down, CatchUpSkip
+	// by default. Only schedules kept in Options.SchedulesFile survive a restart.
+	CatchUp string `json:"catch_up,omitempty"`
+	// Overlap is what happens when a run is due while the previous execution is still
+	// running, OverlapAllow by default
+	Overlap string `json:"overlap,omitempty"`
+}
+
+// Catch-up policies of the schedules
+const (
+	// CatchUpSkip drops the missed runs, the schedule continues with its next run
+	CatchUpSkip = "skip"
+	// CatchUpOnce runs the schedule once on startup if it missed any runs
+	CatchUpOnce = "once"
+)
+
+// Overlap policies of the schedules
+const (
+	// OverlapAllow starts the run anyway
+	OverlapAllow = "allow"
+	// OverlapSkip drops the run
+	OverlapSkip = "skip"
+	// OverlapQueue starts the run once the previous execution completed. Every run due
+	// meanwhile waits, they start one after the other.
+	OverlapQueue = "queue"
+)
+
+type ScheduleResponse struct {
+	ID string `json:"id"`
+	ScheduleRequest
+	NextRun *time.Time   `json:"next_run,omitempty"`
+	LastRun *ScheduleRun `json:"last_run,omitempty"`
+	// Queued counts the runs waiting for the previous execution, see OverlapQueue
+	Queued int `json:"queued,omitempty"`
+	// LastExecution is the record of the latest run, while it is in the history
+	LastExecution *tinpot.ExecutionRecord `json:"last_execution,omitempty"`
+}
+
+// ScheduleNextRunsResponse previews the next runs of a schedule, in its timezone, see GET
+// /api/schedules/{id}/next. The jitter is not applied.
+type ScheduleNextRunsResponse struct {
+	ID       string      `json:"id"`
+	Timezone string      `json:"timezone,omitempty"`
+	NextRuns []time.Time `json:"next_runs"`
+}
+
+// ScheduleRun is a run of a schedule, with the execution it started or why it could not
+type ScheduleRun struct {
+	At          time.Time `json:"at"`
+	ExecutionID string    `json:"execution_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	// Skipped tells why the run didn't start, see ScheduleRequest.Overlap
+	Skipped string `json:"skipped,omitempty"`
+	// CatchUp is set on the run making up for the ones missed while the coordinator was down
+	CatchUp bool `json:"catch_up,omitempty"`
+}
+
+// Workflow runs actions as the steps of a DAG, see PUT /api/workflows/{name}
+type Workflow struct {
+	Description string `json:"description,omitempty"`
+	// Inputs are the parameters of the runs, available to the step expressions
+	Inputs map[string]tinpot.ParameterInfo `json:"inputs,omitempty"`
+	Steps  []WorkflowStep                  `json:"steps"`
+}
+
+type WorkflowStep struct {
+	ID string `json:"id"`
+	// Type is empty for action steps, WorkflowStepManual for the tasks completed by a user
+	Type string `json:"type,omitempty"`
+	// Instructions tell the user what to do in a manual step
+	Instructions string                 `json:"instructions,omitempty"`
+	Action       string                 `json:"action,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	// Expressions compute parameters with CEL from the inputs and the results of the
+	// dependencies, e.g. {"amount": "steps.fetch.result.total * 2"}
+	Expressions map[string]string `json:"expressions,omitempty"`
+	// ForEach is an expression evaluating to a list, the action runs once per item with the
+	// item and index variables in the expressions; the results are collected into result.items
+	ForEach string `json:"for_each,omitempty"`
+	// Parallelism is how many items of a for_each step run at once (default 4)
+	Parallelism int `json:"parallelism,omitempty"`
+	// DependsOn are the steps that must succeed before this one starts
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Retries is how many times a failed execution of the step is repeated
+	Retries int `json:"retries,omitempty"`
+}
+
+// WorkflowValidation is the response of POST /api/workflows/validate
+type WorkflowValidation struct {
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+// WorkflowRunRequest is the body of POST /api/workflows/{name}/run
+type WorkflowRunRequest struct {
+	Inputs map[string]interface{} `json:"inputs,omitempty"`
+}
+
+type WorkflowResponse struct {
+	Name string `json:"name"`
+	Workflow
+}
+
+// WorkflowRun is a run of a workflow. Steps depending on a failed one are skipped, the
+// others go on; a failed run can be resumed from its failed steps.
+type WorkflowRun struct {
+	ID          string                 `json:"id"`
+	Workflow    string                 `json:"workflow"`
+	Status      string                 `json:"status"`
+	Inputs      map[string]interface{} `json:"inputs,omitempty"`
+	Steps       []WorkflowStepRun      `json:"steps"`
+	StartedAt   time.Time              `json:"started_at"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	// Resumed counts the resumptions of the run
+	Resumed int `json:"resumed,omitempty"`
+}
+
+type WorkflowStepRun struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// ExecutionID is the latest execution of the step
+	ExecutionID string                 `json:"execution_id,omitempty"`
+	Attempts    int                    `json:"attempts,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	StartedAt   *time.Time             `json:"started_at,omitempty"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	// CompletedBy is the user who completed a manual step
+	CompletedBy string `json:"completed_by,omitempty"`
+	// Items are the executions of a for_each step, by index
+	Items []WorkflowItemRun `json:"items,omitempty"`
+}
+
+// WorkflowGraph is the DAG of a run, see GET /api/workflow_runs/{id}/graph. The field
+// names follow the Grafana node graph panel.
+type WorkflowGraph struct {
+	RunID    string              `json:"run_id"`
+	Workflow string              `json:"workflow"`
+	Status   string              `json:"status"`
+	Nodes    []WorkflowGraphNode `json:"nodes"`
+	Edges    []WorkflowGraphEdge `json:"edges"`
+}
+
+type WorkflowGraphNode struct {
+	ID string `json:"id"`
+	// Title is the action, or the type of the step without one
+	Title       string `json:"title"`
+	Subtitle    string `json:"subtitle,omitempty"`
+	Status      string `json:"status"`
+	ExecutionID string `json:"execution_id,omitempty"`
+	// DurationMs is the run time so far of the running steps
+	DurationMs  int64      `json:"duration_ms,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Items counts the items of for_each steps by status
+	Items map[string]int `json:"items,omitempty"`
+}
+
+// WorkflowGraphEdge points from a step to the one depending on it
+type WorkflowGraphEdge struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// TaskCompletionRequest completes a manual step, see POST /api/workflow_runs/{id}/tasks/{task}/complete
+type TaskCompletionRequest struct {
+	// Result is available to the later steps like the result of an action
+	Result map[string]interface{} `json:"result,omitempty"`
+	// Error fails the step instead
+	Error  string `json:"error,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+type WorkflowItemRun struct {
+	Status      string      `json:"status"`
+	ExecutionID string      `json:"execution_id,omitempty"`
+	Attempts    int         `json:"attempts,omitempty"`
+	Result      interface{} `json:"result,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// AutomationAction describes an action for low-code tools, see GET /api/automation/catalog
+type AutomationAction struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Group       string            `json:"group"`
+	Fields      []AutomationField `json:"fields"`
+	// Schema is the JSON Schema of the parameters
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// AutomationField is a parameter, in form order
+type AutomationField struct {
+	Name string `json:"name"`
+	// Type is a JSON Schema type: string, integer, number, boolean, array or object
+	Type        string        `json:"type"`
+	Title       string        `json:"title,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required"`
+	Default     interface{}   `json:"default"`
+	Options     []interface{} `json:"options,omitempty"`
+}
+
+// AutomationExecution has the same shape in every state: result is {} and error "" when there is none
+type AutomationExecution struct {
+	ID          string                 `json:"id"`
+	Action      string                 `json:"action"`
+	Status      string                 `json:"status"`
+	Done        bool                   `json:"done"`
+	Result      map[string]interface{} `json:"result"`
+	Error       string                 `json:"error"`
+	SubmittedAt time.Time              `json:"submitted_at"`
+	CompletedAt *time.Time             `json:"completed_at"`
+}
+
+// AlertExecution is an action started for an alert, see POST /api/integrations/alertmanager
+type AlertExecution struct {
+	Alert       string `json:"alert"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Action      string `json:"action"`
+	ExecutionID string `json:"execution_id,omitempty"`
+	Status      string `json:"status,omitempty"`
+	// Error tells why the execution was not started, e.g. "404 Action not found: ..."
+	Error string `json:"error,omitempty"`
+}
+
+type ActionDocsExample struct {
+	tinpot.ActionExample
+	// Request is the rendered body of an execute call running the example
+	Request string `json:"request"`
+}
+
+type ActionDocsResponse struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Docs        string              `json:"docs"`
+	Examples    []ActionDocsExample `json:"examples"`
+}
+
+type ActionSchemaResponse struct {
+	Schema   map[string]interface{} `json:"schema"`
+	UISchema map[string]interface{} `json:"uiSchema"`
+}
+
+type ActionDiffResponse struct {
+	Action  string              `json:"action"`
+	From    string              `json:"from"`
+	To      string              `json:"to"`
+	Changes []tinpot.JSONChange `json:"changes"`
+}
+
+type ParameterOptionsResponse struct {
+	Options []interface{} `json:"options"`
+	// Source is the action the options were resolved from, empty for static choices
+	Source     string     `json:"source,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// DeriveActionRequest creates an action from an existing one with some parameters bound
+type DeriveActionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Group       string `json:"group,omitempty"`
+	// Parameters are fixed and hidden from the derived action
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Defaults only change the defaults, callers can still override them
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+}
+
+type CommentRequest struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// ArchiveRequest selects the executions to archive in bulk
+type ArchiveRequest struct {
+	Action string     `json:"action"`
+	Status string     `json:"status"`
+	Before *time.Time `json:"before"`
+}
+
+type WorkerTelemetryResponse struct {
+	Worker  string                       `json:"worker"`
+	Samples []tinpot.MqttWorkerTelemetry `json:"samples"` // oldest first
+}
+
+type WorkerLoadErrorsResponse struct {
+	Worker string                 `json:"worker"`
+	Errors []tinpot.MqttLoadError `json:"errors"`
+}
+
+// Stream Event
+type StreamEvent struct {
+	Type string      `json:"type"` // "log" or "complete"
+	Data interface{} `json:"data"` // LogEntry or ResultResponse-like map
+}
+
+// StreamEventWithID is a stream event outside of SSE, which frames the ID otherwise
+type StreamEventWithID struct {
+	ID int64 `json:"id"`
+	StreamEvent
+}
+
+// MultiplexedEvent is an event of the combined stream, see GET /api/stream. ID is the event
+// ID within the stream of its execution.
+type MultiplexedEvent struct {
+	ExecutionID string `json:"execution_id"`
+	ID          int64  `json:"id"`
+	StreamEvent
+}
+
+// StreamEventsResponse is a batch of the events of an execution after a cursor
+type StreamEventsResponse struct {
+	Events []StreamEventWithID `json:"events"`
+	// Cursor is the ID of the last event, to poll the next batch with
+	Cursor int64 `json:"cursor"`
+	// Missed counts the events after the requested cursor that are no longer buffered
+	Missed int64 `json:"missed"`
+	// Closed is set when the execution completed and no more events follow
+	Closed bool `json:"closed"`
+}
+
+// StreamStats describes the event buffer of an execution stream, in the status response
+type StreamStats struct {
+	// LastEventID is the ID of the latest event, the number of events sent so far
+	LastEventID int64 `json:"last_event_id"`
+	Buffered    int   `json:"buffered"`
+	// Dropped events were overwritten by newer ones in the full buffer
+	Dropped int `json:"dropped"`
+	// Expired events were older than the buffer's maximum age
+	Expired int `json:"expired"`
+}
+
+type LoginRequest struct {
+	// Username and Password log in with a password provider (e.g. LDAP)
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token logs in with an API token or a token of a provider
+	Token string `json:"token,omitempty"`
+}
+
+type LoginMethodsResponse struct {
+	Password bool `json:"password"`
+	Token    bool `json:"token"`
+	// Redirects are the login URLs of the redirect flows (e.g. OIDC) by provider
+	Redirects map[string]string `json:"redirects"`
+}
+
+type MeResponse struct {
+	Identity
+	Permissions []string `json:"permissions"`
+	// CSRFToken must be sent in the X-CSRF-Token header by session callers changing state
+	CSRFToken string `json:"csrf_token,omitempty"`
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// APIPrefix is the versioned API, serving the endpoints of /api/ with envelopes: lists as
+// ListResponse and errors as ErrorResponse. The unversioned paths keep their responses.
+const APIPrefix = "/api/v1/"
+
+// Headers of the list endpoints, the envelope of APIPrefix carries them in ListResponse
+const (
+	TotalCountHeader = "X-Total-Count"
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// ListResponse is the envelope of the lists of APIPrefix
+type ListResponse struct {
+	Items []json.RawMessage `json:"items"`
+	// Total counts the items of every page
+	Total int `json:"total"`
+	// NextCursor is passed as the cursor query parameter to fetch the next page, null on the last one
+	NextCursor *string `json:"next_cursor"`
+}
+
+// ErrorResponse is the envelope of the errors of APIPrefix
+type ErrorResponse struct {
+	// Code is stable for the kind of error, see errorCodes
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details carry the other fields of the error, if any
+	Details []map[string]interface{} `json:"details"`
+}
+
+// errorCodes are the codes of the errors by HTTP status
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthenticated",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal"
+	}
+	return "invalid_request"
+}
+
+type apiVersionContextKey struct{}
+
+// isV1 tells whether the request came through APIPrefix, for handlers whose list isn't an array
+func isV1(r *http.Request) bool {
+	v1, _ := r.Context().Value(apiVersionContextKey{}).(bool)
+	return v1
+}
+
+// versionedAPI serves APIPrefix with the handler of the unversioned paths, wrapping its
+// responses in the envelopes
+func versionedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, APIPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), apiVersionContextKey{}, true))
+		r.URL.Path = "/api/" + strings.TrimPrefix(r.URL.Path, APIPrefix)
+		r.URL.RawPath = ""
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			// The cursors of the lists are their before parameter
+			query := r.URL.Query()
+			query.Set("before", cursor)
+			r.URL.RawQuery = query.Encode()
+		}
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// envelopeWriter holds back the JSON responses and errors to wrap them, streams pass through
+type envelopeWriter struct {
+	http.ResponseWriter
+	status   int
+	buffered bool
+	body     bytes.Buffer
+}
+
+func (e *envelopeWriter) WriteHeader(status int) {
+	if e.status != 0 {
+		return
+	}
+	e.status = status
+	contentType := e.Header().Get("Content-Type")
+	e.buffered = strings.HasPrefix(contentType, "application/json") ||
+		status >= 400 && !strings.HasPrefix(contentType, "text/event-stream") && !strings.HasPrefix(contentType, "application/x-ndjson")
+	if !e.buffered {
+		e.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (e *envelopeWriter) Write(b []byte) (int, error) {
+	if e.status == 0 {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.buffered {
+		return e.body.Write(b)
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+func (e *envelopeWriter) Flush() {
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok && !e.buffered {
+		flusher.Flush()
+	}
+}
+
+func (e *envelopeWriter) finish() {
+	if !e.buffered {
+		return
+	}
+	var v interface{}
+	switch {
+	case e.status >= 400:
+		v = errorEnvelope(e.status, e.body.Bytes())
+	case bytes.HasPrefix(bytes.TrimSpace(e.body.Bytes()), []byte("[")):
+		list := ListResponse{Items: []json.RawMessage{}}
+		if err := json.Unmarshal(e.body.Bytes(), &list.Items); err != nil {
+			break
+		}
+		list.Total = len(list.Items)
+		if total, err := strconv.Atoi(e.Header().Get(TotalCountHeader)); err == nil {
+			list.Total = total
+		}
+		if cursor := e.Header().Get(NextCursorHeader); cursor != "" {
+			list.NextCursor = &cursor
+		}
+		v = list
+	}
+	if v == nil {
+		e.ResponseWriter.WriteHeader(e.status)
+		e.ResponseWriter.Write(e.body.Bytes())
+		return
+	}
+	e.Header().Del("Content-Length")
+	writeJSON(e.ResponseWriter, e.status, v)
+}
+
+// errorEnvelope turns the {"detail": ...} errors, and the plain text ones of the mux, into
+// an ErrorResponse
+func errorEnvelope(status int, body []byte) ErrorResponse {
+	resp := ErrorResponse{Code: errorCode(status), Details: []map[string]interface{}{}}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		resp.Message = strings.TrimSpace(string(body))
+		return resp
+	}
+	resp.Message, _ = fields["detail"].(string)
+	delete(fields, "detail")
+	if len(fields) > 0 {
+		resp.Details = append(resp.Details, fields)
+	}
+	if resp.Message == "" {
+		resp.Message = http.StatusText(status)
+	}
+	return resp
+}
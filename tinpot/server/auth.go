@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// Roles, each granting the permissions of the ones before it
+const (
+	// RoleViewer may read the catalog, executions and streams
+	RoleViewer = "viewer"
+	// RoleOperator may also execute, cancel and comment
+	RoleOperator = "operator"
+	// RoleAdmin may also derive and delete actions and manage schedules and workflows
+	RoleAdmin = "admin"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// rolePermissions lists what each role adds to the ones before it, reported by /api/me
+var rolePermissions = []struct{ role, permission string }{
+	{RoleViewer, "read"},
+	{RoleOperator, "execute"},
+	{RoleAdmin, "manage_actions"},
+}
+
+// Identity is an authenticated API caller
+type Identity struct {
+	Subject string   `json:"subject"`
+	Tenant  string   `json:"tenant"`
+	Roles   []string `json:"roles"`
+	// Groups of the external identity, for the group visibility of executions
+	Groups []string `json:"groups,omitempty"`
+}
+
+// HasRole tells whether the identity has the role or a higher one
+func (id *Identity) HasRole(role string) bool {
+	if role == "" {
+		return true
+	}
+	for _, r := range id.Roles {
+		if roleRanks[r] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthProvider authenticates API callers besides the static tokens. Providers implement
+// TokenAuthenticator, PasswordAuthenticator and/or LoginFlow.
+type AuthProvider interface {
+	Name() string
+}
+
+// TokenAuthenticator authenticates bearer tokens and token logins. It returns nil
+// without error for tokens it does not recognize, so the next provider is tried.
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (*Identity, error)
+}
+
+// PasswordAuthenticator authenticates HTTP basic credentials and password logins. It
+// returns nil without error for wrong credentials.
+type PasswordAuthenticator interface {
+	AuthenticatePassword(ctx context.Context, username, password string) (*Identity, error)
+}
+
+// LoginFlow is implemented by providers logging browsers in through redirects, e.g. OIDC.
+// The server serves the handler under /auth/{name}/ (starting at /auth/{name}/login);
+// the flow calls login with the authenticated caller to start a session.
+type LoginFlow interface {
+	LoginHandler(login func(w http.ResponseWriter, r *http.Request, id *Identity)) http.Handler
+}
+
+// RoleMapping maps the groups (or claims) of external identities to roles and tenants
+type RoleMapping struct {
+	// Roles by group, "*" applies to every authenticated caller
+	Roles map[string][]string
+	// Tenants by group, callers in none of them belong to the default tenant
+	Tenants map[string]string
+}
+
+// ParseRoleMapping parses a group to role list and a group to tenant list:
+// "admins:admin,ops:operator,*:viewer" and "team-a-ops:teamA".
+func ParseRoleMapping(roles, tenants string) (RoleMapping, error) {
+	m := RoleMapping{Roles: make(map[string][]string), Tenants: make(map[string]string)}
+	for _, entry := range strings.Split(roles, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 || roleRanks[entry[i+1:]] == 0 {
+			return m, fmt.Errorf("invalid role mapping: %q", entry)
+		}
+		m.Roles[entry[:i]] = append(m.Roles[entry[:i]], entry[i+1:])
+	}
+	for _, entry := range strings.Split(tenants, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 || tinpot.ValidTenant(entry[i+1:]) != nil {
+			return m, fmt.Errorf("invalid tenant mapping: %q", entry)
+		}
+		m.Tenants[entry[:i]] = entry[i+1:]
+	}
+	return m, nil
+}
+
+// Identity maps the groups of a subject. The tenant is taken from the first group (in
+// sorted order) that has one.
+func (m RoleMapping) Identity(subject string, groups []string) *Identity {
+	id := &Identity{Subject: subject, Groups: slices.Clone(groups)}
+	groups = append(slices.Clone(groups), "*")
+	sort.Strings(groups)
+	for _, group := range groups {
+		for _, role := range m.Roles[group] {
+			if !slices.Contains(id.Roles, role) {
+				id.Roles = append(id.Roles, role)
+			}
+		}
+		if tenant, ok := m.Tenants[group]; ok && id.Tenant == "" {
+			id.Tenant = tenant
+		}
+	}
+	return id
+}
+
+// requiredRole is the role an API request needs
+func requiredRole(r *http.Request) string {
+	if r.URL.Path == "/api/me" {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/admin/"):
+		return RoleAdmin
+	case r.Method == "GET" || r.Method == "HEAD":
+		return RoleViewer
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/api/actions/"),
+		r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/actions/") && strings.HasSuffix(r.URL.Path, "/derive"),
+		strings.HasPrefix(r.URL.Path, "/api/schedules/"),
+		r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/workers/") && strings.HasSuffix(r.URL.Path, "/sync"),
+		(r.Method == "PUT" || r.Method == "DELETE") && strings.HasPrefix(r.URL.Path, "/api/workflows/"):
+		return RoleAdmin
+	}
+	return RoleOperator
+}
+
+// authenticate resolves the identity of a request from its credentials
+func authenticate(r *http.Request, tokens map[string]string, providers []AuthProvider) *Identity {
+	if username, password, ok := r.BasicAuth(); ok {
+		return authenticatePassword(r.Context(), providers, username, password)
+	}
+	return authenticateToken(r.Context(), tokens, providers, requestToken(r))
+}
+
+func authenticatePassword(ctx context.Context, providers []AuthProvider, username, password string) *Identity {
+	for _, p := range providers {
+		if pa, ok := p.(PasswordAuthenticator); ok {
+			id, err := pa.AuthenticatePassword(ctx, username, password)
+			if err != nil {
+				log.Printf("Authentication with %s failed: %v", p.Name(), err)
+			} else if id != nil {
+				return id
+			}
+		}
+	}
+	return nil
+}
+
+// authenticateToken checks the static tokens, then the providers
+func authenticateToken(ctx context.Context, tokens map[string]string, providers []AuthProvider, token string) *Identity {
+	if token == "" {
+		return nil
+	}
+	if tenant, ok := tokens[token]; ok {
+		// Static tokens predate the roles and may do anything within their tenant
+		return &Identity{Subject: "token", Tenant: tenant, Roles: []string{RoleAdmin}}
+	}
+	for _, p := range providers {
+		if ta, ok := p.(TokenAuthenticator); ok {
+			id, err := ta.AuthenticateToken(ctx, token)
+			if err != nil {
+				log.Printf("Authentication with %s failed: %v", p.Name(), err)
+			} else if id != nil {
+				return id
+			}
+		}
+	}
+	return nil
+}
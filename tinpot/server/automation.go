@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// The /api/automation/ endpoints are a simplified surface for low-code tools (n8n, Node-RED):
+// flat parameter bodies, the same execution shape everywhere and long-polling instead of streams.
+
+// maxWait bounds the long-polling of the automation endpoints
+const maxWait = 5 * time.Minute
+
+// GET /api/automation/catalog describes the actions of the tenant for generating nodes
+func (s *Server) getAutomationCatalog(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	catalog := []AutomationAction{}
+	for _, act := range s.mgr.ListActions() {
+		if act.Tenant != tenant {
+			continue
+		}
+		act = localizedAction(w, r, act)
+		schema, _ := tinpot.FormSchema(act)
+		properties, _ := schema["properties"].(map[string]interface{})
+		entry := AutomationAction{Name: act.Name, Description: act.Description, Group: act.Group, Fields: []AutomationField{}, Schema: schema}
+		for _, name := range tinpot.ParameterOrder(act.Parameters) {
+			p := act.Parameters[name]
+			field := AutomationField{Name: name, Type: "string", Required: p.Required, Default: p.Default, Options: p.Enum}
+			if prop, ok := properties[name].(map[string]interface{}); ok {
+				field.Type, _ = prop["type"].(string)
+			}
+			if p.UI != nil {
+				field.Title, field.Description = p.UI.Title, p.UI.Help
+				if len(field.Options) == 0 {
+					field.Options = p.UI.Choices
+				}
+			}
+			entry.Fields = append(entry.Fields, field)
+		}
+		catalog = append(catalog, entry)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+	writeJSON(w, 200, catalog)
+}
+
+// POST /api/automation/actions/{name}/run starts an execution with the body as parameters,
+// and with ?wait=30s waits for it to finish
+func (s *Server) runAutomation(w http.ResponseWriter, r *http.Request) {
+	wait, err := waitDuration(r.URL.Query().Get("wait"))
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	var parameters map[string]interface{}
+	if err := s.decodeBody(w, r, &parameters); err != nil && err != io.EOF {
+		writeBodyError(w, err)
+		return
+	}
+	tenant := TenantFromRequest(r)
+	rec := &responseRecorder{header: make(http.Header)}
+	sub := submission{
+		tenant:     tenant,
+		action:     r.PathValue("name"),
+		parameters: parameters,
+		traceID:    traceID(r),
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginAPI},
+	}
+	sub.requestedBy, sub.requesterGroups = requester(r)
+	s.submit(rec, sub, false)
+	if rec.status >= 300 {
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+	var started ExecutionResponse
+	json.Unmarshal(rec.body.Bytes(), &started)
+	s.writeAutomationExecution(w, r, started.ExecutionID, tenant, wait)
+}
+
+// GET /api/automation/executions/{id}?wait=30s returns the execution, waiting for it to finish
+func (s *Server) getAutomationExecution(w http.ResponseWriter, r *http.Request) {
+	wait, err := waitDuration(r.URL.Query().Get("wait"))
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	s.writeAutomationExecution(w, r, r.PathValue("id"), TenantFromRequest(r), wait)
+}
+
+func waitDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(s)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("Invalid wait: %q", s)
+	}
+	return min(wait, maxWait), nil
+}
+
+func (s *Server) writeAutomationExecution(w http.ResponseWriter, r *http.Request, id, tenant string, wait time.Duration) {
+	completed := make(chan struct{}, 1)
+	unsubscribe := s.events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventCompleted && event.ExecutionID == id {
+			select {
+			case completed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer unsubscribe()
+
+	rec, err := s.visibleRecord(r, id)
+	if err == nil && !rec.Done() && wait > 0 {
+		select {
+		case <-completed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+		}
+		rec, err = s.visibleRecord(r, id)
+	}
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	resp := AutomationExecution{
+		ID:          rec.ID,
+		Action:      rec.Action,
+		Status:      rec.Status,
+		Done:        rec.Done(),
+		Result:      rec.Result,
+		Error:       rec.Error,
+		SubmittedAt: rec.SubmittedAt,
+		CompletedAt: rec.CompletedAt,
+	}
+	if resp.Result == nil {
+		resp.Result = map[string]interface{}{}
+	}
+	writeJSON(w, 200, resp)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// brokerAdmin returns the tinpot.BrokerAdmin of the action manager, answering 501 without one
+func (s *Server) brokerAdmin(w http.ResponseWriter) tinpot.BrokerAdmin {
+	admin, ok := s.aliases.ActionManager.(tinpot.BrokerAdmin)
+	if !ok {
+		writeJSON(w, 501, map[string]string{"detail": "Broker administration not supported"})
+	}
+	return admin
+}
+
+// listRetained reports the retained messages of the caller's tenant the coordinator saw:
+// action announcements, worker statuses and load errors
+func (s *Server) listRetained(w http.ResponseWriter, r *http.Request) {
+	if admin := s.brokerAdmin(w); admin != nil {
+		writeJSON(w, 200, admin.RetainedMessages(TenantFromRequest(r)))
+	}
+}
+
+// listInvalidMessages counts the messages of the caller's tenant the coordinator dropped, with
+// the latest of them, to find the misbehaving workers
+func (s *Server) listInvalidMessages(w http.ResponseWriter, r *http.Request) {
+	if admin := s.brokerAdmin(w); admin != nil {
+		writeJSON(w, 200, admin.InvalidMessages(TenantFromRequest(r)))
+	}
+}
+
+// purgeExecution clears the retained result and log of an execution, e.g. one left behind by
+// a coordinator that went away before acknowledging it
+func (s *Server) purgeExecution(w http.ResponseWriter, r *http.Request) {
+	admin := s.brokerAdmin(w)
+	if admin == nil {
+		return
+	}
+	id := r.PathValue("id")
+	topics, err := admin.PurgeExecution(TenantFromRequest(r), id)
+	if err != nil {
+		writeJSON(w, 503, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"execution_id": id, "cleared": topics})
+}
+
+// rescanWorkers asks the workers of the caller's tenant to announce their actions, status and
+// load errors again
+func (s *Server) rescanWorkers(w http.ResponseWriter, r *http.Request) {
+	admin := s.brokerAdmin(w)
+	if admin == nil {
+		return
+	}
+	if err := admin.Rescan(TenantFromRequest(r)); err != nil {
+		writeJSON(w, 503, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 202, map[string]string{"status": "requested"})
+}
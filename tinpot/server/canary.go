@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// TrackHeader opts an execution in or out of the canary version of the action, with
+// tinpot.TrackCanary or tinpot.TrackStable
+const TrackHeader = "X-Tinpot-Track"
+
+// CanaryTraffic is the percentage of the executions the canary versions of the actions get, by
+// qualified action name, "*" for the other actions. Without an entry, canaries only run the
+// executions opting in with the TrackHeader.
+type CanaryTraffic map[string]int
+
+// ParseCanaryTraffic parses "backup=20,team-a/sync=5,*=1"
+func ParseCanaryTraffic(spec string) (CanaryTraffic, error) {
+	traffic := CanaryTraffic{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		action, value, _ := strings.Cut(entry, "=")
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if action == "" || err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid canary traffic %q, expected action=percent", entry)
+		}
+		traffic[action] = percent
+	}
+	return traffic, nil
+}
+
+func (c CanaryTraffic) percent(qualified string) int {
+	if percent, ok := c[qualified]; ok {
+		return percent
+	}
+	return c["*"]
+}
+
+// releaseTrack picks the track of an execution: the requested one, or the canary for its share
+// of the executions. Empty for actions without a canary version.
+func (s *Server) releaseTrack(tenant, action, requested string) string {
+	qualified := tinpot.QualifiedName(tenant, action)
+	if s.mgr.ListActions()[qualified].Canary == nil {
+		return ""
+	}
+	if requested != "" {
+		return requested
+	}
+	if rand.IntN(100) < s.opts.CanaryTraffic.percent(qualified) {
+		return tinpot.TrackCanary
+	}
+	return tinpot.TrackStable
+}
+
+// validTrack tells whether the TrackHeader names a track, empty lets the coordinator pick
+func validTrack(track string) bool {
+	return track == "" || track == tinpot.TrackStable || track == tinpot.TrackCanary
+}
+
+// TrackStats are the outcomes of the executions of an action on one release track
+type TrackStats struct {
+	Executions  int     `json:"executions"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+	// AvgDurationSeconds is the average run time, by the coordinator's clock
+	AvgDurationSeconds float64 `json:"avg_duration_seconds"`
+}
+
+// CanaryReport compares the stable and canary versions of an action
+type CanaryReport struct {
+	Action string `json:"action"`
+	// Canary is the announced canary version, nil once it is withdrawn
+	Canary  *tinpot.CanaryInfo `json:"canary,omitempty"`
+	Percent int                `json:"percent"`
+	Since   time.Time          `json:"since"`
+	// Tracks has the stats of the "stable" and "canary" executions
+	Tracks map[string]TrackStats `json:"tracks"`
+	// FailureRateDelta is the failure rate of the canary minus the stable one
+	FailureRateDelta float64 `json:"failure_rate_delta"`
+}
+
+// getCanaryReport compares the outcomes of the executions completed on each track since the
+// "since" time (the last 24 hours by default)
+func (s *Server) getCanaryReport(w http.ResponseWriter, r *http.Request) {
+	actionName := r.PathValue("name")
+	tenant := TenantFromRequest(r)
+	qualified := tinpot.QualifiedName(tenant, actionName)
+	act, ok := s.mgr.ListActions()[qualified]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+	report := CanaryReport{Action: actionName, Canary: act.Canary, Percent: s.opts.CanaryTraffic.percent(qualified), Since: time.Now().Add(-24 * time.Hour)}
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid since: %v", err)})
+			return
+		}
+		report.Since = t
+	}
+
+	records, err := s.store.List(tinpot.ExecutionFilter{Tenant: tenant, Action: actionName, Archived: tinpot.IncludeArchived})
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	durations := map[string]float64{}
+	report.Tracks = map[string]TrackStats{tinpot.TrackStable: {}, tinpot.TrackCanary: {}}
+	for _, rec := range records {
+		stats, ok := report.Tracks[rec.Track]
+		if !ok || rec.CompletedAt == nil || rec.CompletedAt.Before(report.Since) {
+			continue
+		}
+		stats.Executions++
+		if rec.Status != tinpot.StatusSuccess {
+			stats.Failures++
+		}
+		if rec.Durations != nil {
+			durations[rec.Track] += rec.Durations.RunSeconds
+		}
+		report.Tracks[rec.Track] = stats
+	}
+	for track, stats := range report.Tracks {
+		if stats.Executions > 0 {
+			stats.FailureRate = float64(stats.Failures) / float64(stats.Executions)
+			stats.AvgDurationSeconds = durations[track] / float64(stats.Executions)
+			report.Tracks[track] = stats
+		}
+	}
+	report.FailureRateDelta = report.Tracks[tinpot.TrackCanary].FailureRate - report.Tracks[tinpot.TrackStable].FailureRate
+	writeJSON(w, 200, report)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// debouncer coalesces the identical requests of the actions declaring a Debounce window
+type debouncer struct {
+	mu      sync.Mutex
+	pending map[string]*debouncedExecution // by debounceKey, until the window passes
+	running map[string]*debouncedExecution // by execution id, until it completes
+}
+
+type debouncedExecution struct {
+	id   string
+	done chan struct{} // closed on completion, or when the execution could not be started
+}
+
+// debounceKey identifies identical requests: same caller, action, parameters, selector, session
+// and track
+func debounceKey(qualified string, sub submission) string {
+	data, _ := json.Marshal([]interface{}{sub.requestedBy, qualified, sub.parameters, sub.selector, sub.session, sub.track})
+	return string(data)
+}
+
+// debounce returns the execution an identical request started within the debounce window of
+// the action, or registers execID as the one later requests join (then leader is true).
+// Returns nil for actions without a window.
+func (s *Server) debounce(execID string, sub submission) (entry *debouncedExecution, leader bool) {
+	qualified := tinpot.QualifiedName(sub.tenant, sub.action)
+	window := time.Duration(s.mgr.ListActions()[qualified].Debounce * float64(time.Second))
+	if window <= 0 {
+		return nil, false
+	}
+	key := debounceKey(qualified, sub)
+	d := &s.debouncer
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.pending[key]; ok {
+		return entry, false
+	}
+	entry = &debouncedExecution{id: execID, done: make(chan struct{})}
+	d.pending[key], d.running[execID] = entry, entry
+	time.AfterFunc(window, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.pending[key] == entry {
+			delete(d.pending, key)
+		}
+	})
+	return entry, true
+}
+
+// forget drops an execution that could not be started, its joiners get an error
+func (d *debouncer) forget(entry *debouncedExecution) {
+	if entry == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, pending := range d.pending {
+		if pending == entry {
+			delete(d.pending, key)
+		}
+	}
+	if _, ok := d.running[entry.id]; ok {
+		delete(d.running, entry.id)
+		close(entry.done)
+	}
+}
+
+func (d *debouncer) completed(event tinpot.ExecutionEvent) {
+	if event.Type != tinpot.EventCompleted {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.running[event.ExecutionID]; ok {
+		delete(d.running, event.ExecutionID)
+		close(entry.done)
+	}
+}
+
+// joinExecution answers a request coalesced into an execution already started: with its ID,
+// or with its outcome once it completes in sync mode
+func (s *Server) joinExecution(w http.ResponseWriter, entry *debouncedExecution, sub submission, syncMode bool) {
+	s.updateRecord(entry.id, func(rec *tinpot.ExecutionRecord) {
+		rec.Coalesced++
+	})
+	if !syncMode {
+		writeJSON(w, 200, ExecutionResponse{
+			ExecutionID: entry.id,
+			ActionName:  sub.action,
+			Status:      "coalesced",
+			StreamURL:   fmt.Sprintf("/api/executions/%s/stream", entry.id),
+		})
+		return
+	}
+	if sub.streaming {
+		// The events expire with the stream, then only the result is sent
+		s.streamSync(w, entry.id, sub.action, s.stream(entry.id, sub.tenant))
+		return
+	}
+	<-entry.done
+	s.writeSyncResult(w, entry.id, sub.action)
+}
+
+// writeSyncResult writes the sync_execute response of a completed execution
+func (s *Server) writeSyncResult(w http.ResponseWriter, execID, actionName string) {
+	rec, err := s.store.Get(execID)
+	if err != nil {
+		writeJSON(w, 503, map[string]string{"detail": fmt.Sprintf("The coalesced execution %s could not be started", execID)})
+		return
+	}
+	writeJSON(w, 200, SyncExecutionResponse{
+		ExecutionID: execID,
+		ActionName:  actionName,
+		Status:      rec.Status,
+		Result:      rec.Result,
+		Error:       rec.Error,
+	})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// deriveAction creates an alias of the action with some parameters bound, see DeriveActionRequest
+func (s *Server) deriveAction(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	actionName := r.PathValue("name")
+	var req DeriveActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	if !tinpot.ValidName(req.Name) {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid action name"})
+		return
+	}
+
+	actions := s.mgr.ListActions()
+	act, ok := actions[tinpot.QualifiedName(tenant, actionName)]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+	qualified := tinpot.QualifiedName(tenant, req.Name)
+	if _, exists := actions[qualified]; exists {
+		writeJSON(w, 409, map[string]string{"detail": fmt.Sprintf("Action already exists: %s", req.Name)})
+		return
+	}
+	for _, values := range []map[string]interface{}{req.Parameters, req.Defaults} {
+		for name := range values {
+			if _, ok := act.Parameters[name]; !ok {
+				writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Unknown parameter: %s", name)})
+				return
+			}
+		}
+	}
+
+	alias := tinpot.Alias{
+		Action:      actionName,
+		Description: req.Description,
+		Group:       req.Group,
+		Parameters:  req.Parameters,
+		Defaults:    req.Defaults,
+	}
+	if act.AliasOf != "" {
+		// Derived from an alias: bind on top of the aliased action
+		alias = mergeAlias(s.aliases.Aliases()[tinpot.QualifiedName(tenant, actionName)], alias)
+	}
+	s.aliases.SetAlias(qualified, alias)
+	s.derivedMu.Lock()
+	s.derived[qualified] = true
+	s.derivedMu.Unlock()
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventActionAdded, Action: req.Name, Tenant: tenant})
+
+	writeJSON(w, 201, localizedAction(w, r, s.mgr.ListActions()[qualified]))
+}
+
+// mergeAlias binds the parameters of derived over those of base
+func mergeAlias(base, derived tinpot.Alias) tinpot.Alias {
+	result := derived
+	result.Action = base.Action
+	result.Parameters = make(map[string]interface{})
+	result.Defaults = make(map[string]interface{})
+	for _, source := range []tinpot.Alias{base, derived} {
+		for k, v := range source.Parameters {
+			result.Parameters[k] = v
+		}
+		for k, v := range source.Defaults {
+			result.Defaults[k] = v
+		}
+	}
+	if result.Description == "" {
+		result.Description = base.Description
+	}
+	if result.Group == "" {
+		result.Group = base.Group
+	}
+	return result
+}
+
+func (s *Server) deleteDerivedAction(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	actionName := r.PathValue("name")
+	qualified := tinpot.QualifiedName(tenant, actionName)
+	s.derivedMu.Lock()
+	derived := s.derived[qualified]
+	delete(s.derived, qualified)
+	s.derivedMu.Unlock()
+	if !derived {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Derived action not found: %s", actionName)})
+		return
+	}
+	s.aliases.RemoveAlias(qualified)
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventActionRemoved, Action: actionName, Tenant: tenant})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Workflow step expressions are CEL over two variables: inputs, the inputs of the run,
+// and steps, the finished dependencies by id with their result, execution_id and status.
+var expressionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("inputs", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("steps", cel.MapType(cel.StringType, cel.DynType)),
+	)
+})
+
+// The parameter expressions of for_each steps also get the item and its index
+var itemExpressionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	env, err := expressionEnv()
+	if err != nil {
+		return nil, err
+	}
+	return env.Extend(cel.Variable("item", cel.DynType), cel.Variable("index", cel.IntType))
+})
+
+// compileExpression checks an expression, and that it only refers to the given inputs and steps
+func compileExpression(expr string, inputs map[string]bool, steps map[string]bool, item bool) (cel.Program, error) {
+	env, err := expressionEnv()
+	if item {
+		env, err = itemExpressionEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	checked, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		var msgs []string
+		for _, e := range iss.Errors() {
+			msgs = append(msgs, fmt.Sprintf("%d:%d: %s", e.Location.Line(), e.Location.Column()+1, e.Message))
+		}
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+
+	native := checked.NativeRep()
+	var problems []string
+	ast.PreOrderVisit(native.Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		variable, field, ok := referencedField(e)
+		if !ok {
+			return
+		}
+		loc := native.SourceInfo().GetStartLocation(e.ID())
+		switch {
+		case variable == "inputs" && !inputs[field]:
+			problems = append(problems, fmt.Sprintf("%d:%d: unknown input %q", loc.Line(), loc.Column()+1, field))
+		case variable == "steps" && !steps[field]:
+			problems = append(problems, fmt.Sprintf("%d:%d: step %q is not a dependency", loc.Line(), loc.Column()+1, field))
+		}
+	}))
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return env.Program(checked)
+}
+
+// referencedField matches inputs.name, steps.name and their index forms
+func referencedField(e ast.Expr) (variable, field string, ok bool) {
+	var operand, key ast.Expr
+	switch e.Kind() {
+	case ast.SelectKind:
+		operand, field = e.AsSelect().Operand(), e.AsSelect().FieldName()
+	case ast.CallKind:
+		call := e.AsCall()
+		if call.FunctionName() != operators.Index || len(call.Args()) != 2 {
+			return "", "", false
+		}
+		operand, key = call.Args()[0], call.Args()[1]
+		if key.Kind() != ast.LiteralKind {
+			return "", "", false
+		}
+		if field, ok = key.AsLiteral().Value().(string); !ok {
+			return "", "", false
+		}
+	default:
+		return "", "", false
+	}
+	if operand.Kind() != ast.IdentKind || (operand.AsIdent() != "inputs" && operand.AsIdent() != "steps") {
+		return "", "", false
+	}
+	return operand.AsIdent(), field, true
+}
+
+// evalExpression evaluates a compiled expression over the variables to a JSON value
+func evalExpression(prg cel.Program, vars map[string]interface{}) (interface{}, error) {
+	out, _, err := prg.Eval(celJSON(vars))
+	if err != nil {
+		return nil, err
+	}
+	value, err := out.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, err
+	}
+	return value.(*structpb.Value).AsInterface(), nil
+}
+
+// celJSON turns the whole numbers of decoded JSON into ints, CEL does not mix ints and doubles
+// in arithmetic, so steps.fetch.result.total * 2 would fail on a float64 total
+func celJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = celJSON(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = celJSON(e)
+		}
+		return l
+	}
+	return v
+}
+
+// ancestors returns the steps a step transitively depends on
+func ancestors(wf Workflow, id string) map[string]bool {
+	deps := make(map[string][]string, len(wf.Steps))
+	for _, step := range wf.Steps {
+		deps[step.ID] = step.DependsOn
+	}
+	seen := make(map[string]bool)
+	var walk func(id string)
+	walk = func(id string) {
+		for _, dep := range deps[id] {
+			if !seen[dep] {
+				seen[dep] = true
+				walk(dep)
+			}
+		}
+	}
+	walk(id)
+	return seen
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// POST /api/workers/{id}/sync asks a worker to update its actions from its git repository
+func (s *Server) syncWorker(w http.ResponseWriter, r *http.Request) {
+	s.requestSync(w, TenantFromRequest(r), r.PathValue("id"), "")
+}
+
+// POST /api/integrations/git?tenant= is the push webhook of the actions repository (GitHub,
+// Gitea or GitLab), asking the workers of the tenant following the pushed branch to update.
+// It is authenticated by the webhook secret instead of API tokens.
+func (s *Server) receiveGitPush(w http.ResponseWriter, r *http.Request) {
+	if s.opts.GitWebhookSecret == "" {
+		writeJSON(w, 404, map[string]string{"detail": "The git webhook is not configured"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil || !s.verifyGitPush(r, body) {
+		writeJSON(w, 401, map[string]string{"detail": "Invalid webhook signature"})
+		return
+	}
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	if push.Ref == "" {
+		// Other events, e.g. the ping of GitHub
+		writeJSON(w, 200, map[string]string{"status": "ignored"})
+		return
+	}
+	s.requestSync(w, r.URL.Query().Get("tenant"), "", push.Ref)
+}
+
+// verifyGitPush checks the HMAC signature of GitHub and Gitea, or the token of GitLab
+func (s *Server) verifyGitPush(r *http.Request, body []byte) bool {
+	secret := []byte(s.opts.GitWebhookSecret)
+	if token := r.Header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), secret) == 1
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Hub-Signature-256")))
+}
+
+func (s *Server) requestSync(w http.ResponseWriter, tenant, workerID, ref string) {
+	syncer, ok := s.aliases.ActionManager.(tinpot.WorkerSyncer)
+	if !ok {
+		writeJSON(w, 501, map[string]string{"detail": "Syncing workers not supported"})
+		return
+	}
+	if err := syncer.SyncWorkers(tenant, workerID, ref); err != nil {
+		writeJSON(w, 503, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 202, map[string]string{"status": "requested"})
+}
@@ -0,0 +1,1035 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+func (s *Server) listActions(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	result := make(map[string]tinpot.ActionInfo)
+	for _, act := range s.mgr.ListActions() {
+		if act.Tenant == tenant {
+			result[act.Name] = localizedAction(w, r, act)
+		}
+	}
+
+	// The catalog rarely changes, pollers revalidate with If-None-Match
+	body, _ := json.Marshal(result)
+	if isV1(r) {
+		// Lists are arrays in the versioned API
+		actions := slices.Collect(maps.Values(result))
+		slices.SortFunc(actions, func(a, b tinpot.ActionInfo) int { return strings.Compare(a.Name, b.Name) })
+		body, _ = json.Marshal(actions)
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	w.Header().Set("ETag", etag)
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if match = strings.TrimPrefix(strings.TrimSpace(match), "W/"); match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(body)
+}
+
+// localizedAction translates the action metadata according to the request's Accept-Language
+func localizedAction(w http.ResponseWriter, r *http.Request, act tinpot.ActionInfo) tinpot.ActionInfo {
+	w.Header().Set("Vary", "Accept-Language")
+	localized, locale := tinpot.Localize(act, tinpot.ParseAcceptLanguage(r.Header.Get("Accept-Language")))
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+	return localized
+}
+
+func (s *Server) getActionDocs(w http.ResponseWriter, r *http.Request) {
+	actionName := r.PathValue("name")
+	act, ok := s.mgr.ListActions()[tinpot.QualifiedName(TenantFromRequest(r), actionName)]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+	act = localizedAction(w, r, act)
+
+	examples := act.Examples
+	if len(examples) == 0 {
+		// Without declared examples, show how to run the action with its defaults
+		params := make(map[string]interface{})
+		for name, p := range act.Parameters {
+			params[name] = p.Default
+		}
+		examples = []tinpot.ActionExample{{Title: "Default parameters", Parameters: params}}
+	}
+
+	resp := ActionDocsResponse{
+		Name:        act.Name,
+		Description: act.Description,
+		Docs:        act.Docs,
+		Examples:    make([]ActionDocsExample, 0, len(examples)),
+	}
+	for _, ex := range examples {
+		request, _ := json.MarshalIndent(ExecuteActionRequest{Parameters: ex.Parameters}, "", "  ")
+		resp.Examples = append(resp.Examples, ActionDocsExample{ActionExample: ex, Request: string(request)})
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) getActionSchema(w http.ResponseWriter, r *http.Request) {
+	actionName := r.PathValue("name")
+	act, ok := s.mgr.ListActions()[tinpot.QualifiedName(TenantFromRequest(r), actionName)]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+
+	schema, uiSchema := tinpot.FormSchema(localizedAction(w, r, act))
+	writeJSON(w, 200, ActionSchemaResponse{Schema: schema, UISchema: uiSchema})
+}
+
+func (s *Server) executeAction(w http.ResponseWriter, r *http.Request, syncMode bool) {
+	var req ExecuteActionRequest
+	if err := s.decodeBody(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.execute(w, r, req, syncMode)
+}
+
+// execute submits the decoded execute request
+func (s *Server) execute(w http.ResponseWriter, r *http.Request, req ExecuteActionRequest, syncMode bool) {
+	if name := reservedParameter(req.Parameters); name != "" {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Reserved parameter: %q", name)})
+		return
+	}
+	if _, err := tinpot.ParseSelector(req.Selector); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	if !validPriority(req.Priority) {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid priority: %q", req.Priority)})
+		return
+	}
+	track := r.Header.Get(TrackHeader)
+	if !validTrack(track) {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid %s: %q", TrackHeader, track)})
+		return
+	}
+	origin := tinpot.ExecutionOrigin{Type: tinpot.OriginAPI}
+	if parent := r.Header.Get(ParentExecutionHeader); parent != "" {
+		if _, err := s.visibleRecord(r, parent); err != nil {
+			writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Unknown %s: %q", ParentExecutionHeader, parent)})
+			return
+		}
+		origin = tinpot.ExecutionOrigin{Type: tinpot.OriginExecution, ID: parent}
+	}
+
+	sub := submission{
+		tenant:     TenantFromRequest(r),
+		action:     r.PathValue("name"),
+		parameters: req.Parameters,
+		traceID:    traceID(r),
+		notBefore:  req.NotBefore,
+		notAfter:   req.NotAfter,
+		selector:   req.Selector,
+		session:    req.Session,
+		priority:   req.Priority,
+		track:      track,
+		origin:     origin,
+		streaming:  syncMode && acceptsNDJSON(r),
+	}
+	sub.requestedBy, sub.requesterGroups = requester(r)
+	s.submit(w, sub, syncMode)
+}
+
+// ParentExecutionHeader names the execution requesting another one, e.g. an action calling
+// the API with the _execution_id parameter it got. The new execution's origin points to it.
+const ParentExecutionHeader = "X-Tinpot-Parent-Execution"
+
+// traceID returns the trace id of a W3C traceparent header ("00-<trace id>-<span id>-<flags>")
+func traceID(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	return parts[1]
+}
+
+// submission is a decoded request to run an action
+type submission struct {
+	tenant     string
+	action     string
+	parameters map[string]interface{}
+	// rerunOf is the execution this one repeats
+	rerunOf string
+	origin  tinpot.ExecutionOrigin
+	traceID string
+	// notBefore and notAfter bound when the execution may start, optional
+	notBefore, notAfter *time.Time
+	// selector narrows the eligible workers, session pins them to one, optional
+	selector, session string
+	// priority decides which executions are rejected under load, see SheddingPolicy
+	priority string
+	// track is the requested release track, see TrackHeader
+	track string
+	// requestedBy and requesterGroups identify the caller, see requester
+	requestedBy     string
+	requesterGroups []string
+	// streaming sync executions write their log and progress events before the result
+	streaming bool
+}
+
+// submit starts the execution and writes the execute/sync_execute response
+func (s *Server) submit(w http.ResponseWriter, sub submission, syncMode bool) {
+	actionName := sub.action
+	tenant := sub.tenant
+
+	// Bodies and integrations name actions too, a qualified name would reach another tenant
+	if !tinpot.ValidName(actionName) {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid action: %q", actionName)})
+		return
+	}
+	trigger := s.mgr.GetAction(tinpot.QualifiedName(tenant, actionName))
+	if trigger == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+	if err := s.opts.RequestLimits.check(sub.parameters); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	sub.track = s.releaseTrack(tenant, actionName, sub.track)
+	held := sub.notBefore != nil && sub.notBefore.After(time.Now())
+	if sub.notAfter != nil && (sub.notAfter.Before(time.Now()) || (sub.notBefore != nil && !sub.notAfter.After(*sub.notBefore))) {
+		writeJSON(w, 400, map[string]string{"detail": "not_after must be in the future and after not_before"})
+		return
+	}
+	if held && syncMode {
+		writeJSON(w, 400, map[string]string{"detail": "not_before requires the asynchronous execute endpoint"})
+		return
+	}
+	if group, calendar := s.maintenanceCalendar(tenant, actionName); calendar != nil {
+		at := time.Now()
+		if held {
+			at = *sub.notBefore
+		}
+		if open, opens := calendar.openAt(at); !open {
+			if calendar.policy == MaintenancePolicyReject || syncMode || opens.IsZero() {
+				detail := fmt.Sprintf("Outside of the maintenance windows of %s", group)
+				if !opens.IsZero() {
+					detail += ", the next one opens at " + opens.Format(time.RFC3339)
+				}
+				writeJSON(w, 409, map[string]string{"detail": detail})
+				return
+			}
+			sub.notBefore, held = &opens, true
+		}
+	}
+	if held {
+		s.hold(w, sub)
+		return
+	}
+	if s.opts.OfflineQueueFile != "" && !s.mgr.IsConnected() {
+		if syncMode {
+			writeJSON(w, 503, map[string]string{"detail": "MQTT not connected, use the asynchronous execute endpoint to queue the execution"})
+			return
+		}
+		s.queueOffline(w, sub)
+		return
+	}
+
+	// Generate Execution ID and inject it
+	execID := uuid.New().String()
+	debounced, leader := s.debounce(execID, sub)
+	if debounced != nil && !leader {
+		s.joinExecution(w, debounced, sub, syncMode)
+		return
+	}
+
+	if err := s.shed(sub.priority); err != nil {
+		s.debouncer.forget(debounced)
+		writeQuotaError(w, err)
+		return
+	}
+	release, err := s.quotas.acquire(tenant, tinpot.QualifiedName(tenant, actionName))
+	if err != nil {
+		s.debouncer.forget(debounced)
+		writeQuotaError(w, err)
+		return
+	}
+
+	if err := s.store.Create(tinpot.ExecutionRecord{
+		ID:              execID,
+		Action:          actionName,
+		Tenant:          tenant,
+		Parameters:      sub.parameters,
+		RerunOf:         sub.rerunOf,
+		Origin:          &sub.origin,
+		TraceID:         sub.traceID,
+		Selector:        sub.selector,
+		Session:         sub.session,
+		Priority:        sub.priority,
+		Track:           sub.track,
+		Status:          tinpot.StatusPending,
+		SubmittedAt:     time.Now(),
+		RequestedBy:     sub.requestedBy,
+		RequesterGroups: sub.requesterGroups,
+		NotAfter:        sub.notAfter,
+	}); err != nil {
+		release()
+		s.debouncer.forget(debounced)
+		writeJSON(w, 500, map[string]string{"detail": fmt.Sprintf("Failed to record execution: %v", err)})
+		return
+	}
+	params := triggerParameters(execID, sub)
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventSubmitted, ExecutionID: execID, Action: actionName, Tenant: tenant})
+
+	if syncMode && sub.streaming {
+		stream := s.registerStream(execID, tenant)
+		s.startAsync(execID, actionName, tenant, trigger, params, release, stream)
+		s.streamSync(w, execID, actionName, stream)
+		return
+	}
+	if syncMode && debounced != nil {
+		// Coalesced requests may stream the execution
+		s.startAsync(execID, actionName, tenant, trigger, params, release, s.registerStream(execID, tenant))
+		<-debounced.done
+		s.writeSyncResult(w, execID, actionName)
+		return
+	}
+	if syncMode {
+		var finalResult map[string]interface{}
+		var status, failure string
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		s.executionStarted(execID, actionName, tenant)
+		trigger(s.withReports(execID, params), func(err string, res map[string]interface{}) {
+			finalResult, failure = res, err
+			status = s.executionCompleted(execID, actionName, tenant, err, res)
+			wg.Done()
+		}, nil) // No logs callback for sync
+
+		wg.Wait()
+		release()
+
+		writeJSON(w, 200, SyncExecutionResponse{
+			ExecutionID: execID,
+			ActionName:  actionName,
+			Status:      status,
+			Result:      finalResult,
+			Error:       failure,
+		})
+		return
+	}
+
+	// Async
+	s.startAsync(execID, actionName, tenant, trigger, params, release, s.registerStream(execID, tenant))
+
+	// Async Response
+	writeJSON(w, 200, ExecutionResponse{
+		ExecutionID: execID,
+		ActionName:  actionName,
+		Status:      "submitted",
+		StreamURL:   fmt.Sprintf("/api/executions/%s/stream", execID),
+	})
+}
+
+// reservedParameter returns the first of the parameters named like the internal ones ("_"
+// prefix, see triggerParameters), only the server sets those from the validated request fields
+func reservedParameter(params map[string]interface{}) string {
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		if strings.HasPrefix(name, "_") {
+			return name
+		}
+	}
+	return ""
+}
+
+// triggerParameters are the request parameters with the injected execution and trace IDs,
+// deadline, selector, session and release track. Reserved parameters given by the other
+// entry points (workflow steps, integrations) are dropped, e.g. a _session the submission
+// doesn't record.
+func triggerParameters(execID string, sub submission) map[string]interface{} {
+	params := make(map[string]interface{}, len(sub.parameters)+2)
+	for k, v := range sub.parameters {
+		if !strings.HasPrefix(k, "_") {
+			params[k] = v
+		}
+	}
+	params["_execution_id"] = execID
+	if sub.traceID != "" {
+		params["_trace_id"] = sub.traceID
+	}
+	if sub.notAfter != nil {
+		params["_expires_at"] = sub.notAfter.Format(time.RFC3339Nano)
+	}
+	if sub.selector != "" {
+		params["_selector"] = sub.selector
+	}
+	if sub.session != "" {
+		params["_session"] = sub.session
+	}
+	if sub.track != "" {
+		params[tinpot.TrackParameter] = sub.track
+	}
+	return params
+}
+
+// hold records an execution with a future not_before and starts it then
+func (s *Server) hold(w http.ResponseWriter, sub submission) {
+	execID := uuid.New().String()
+	rec := tinpot.ExecutionRecord{
+		ID:              execID,
+		Action:          sub.action,
+		Tenant:          sub.tenant,
+		Parameters:      sub.parameters,
+		RerunOf:         sub.rerunOf,
+		Origin:          &sub.origin,
+		TraceID:         sub.traceID,
+		Selector:        sub.selector,
+		Session:         sub.session,
+		Priority:        sub.priority,
+		Track:           sub.track,
+		Status:          tinpot.StatusPending,
+		SubmittedAt:     time.Now(),
+		RequestedBy:     sub.requestedBy,
+		RequesterGroups: sub.requesterGroups,
+		NotBefore:       sub.notBefore,
+		NotAfter:        sub.notAfter,
+	}
+	if err := s.store.Create(rec); err != nil {
+		writeJSON(w, 500, map[string]string{"detail": fmt.Sprintf("Failed to record execution: %v", err)})
+		return
+	}
+	s.registerStream(execID, sub.tenant)
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventSubmitted, ExecutionID: execID, Action: sub.action, Tenant: sub.tenant})
+	s.holdEntry(offlineEntry{Record: rec, Parameters: triggerParameters(execID, sub)}, false)
+	s.saveSchedules()
+	writeJSON(w, 202, ExecutionResponse{
+		ExecutionID: execID,
+		ActionName:  sub.action,
+		Status:      "scheduled",
+		StreamURL:   fmt.Sprintf("/api/executions/%s/stream", execID),
+	})
+}
+
+// dispatch starts an execution that was held back (not_before, offline queue) unless
+// it expired meanwhile
+func (s *Server) dispatch(rec tinpot.ExecutionRecord, params map[string]interface{}) {
+	stream := s.stream(rec.ID, rec.Tenant)
+	if stream == nil {
+		stream = s.registerStream(rec.ID, rec.Tenant)
+	}
+	if rec.NotAfter != nil && time.Now().After(*rec.NotAfter) {
+		s.failHeld(rec, stream, tinpot.ExpiredError)
+		return
+	}
+	trigger := s.mgr.GetAction(tinpot.QualifiedName(rec.Tenant, rec.Action))
+	if trigger == nil {
+		s.failHeld(rec, stream, fmt.Sprintf("Action not found: %s", rec.Action))
+		return
+	}
+	release, err := s.quotas.acquire(rec.Tenant, tinpot.QualifiedName(rec.Tenant, rec.Action))
+	if err != nil {
+		s.failHeld(rec, stream, err.Error())
+		return
+	}
+	s.startAsync(rec.ID, rec.Action, rec.Tenant, trigger, params, release, stream)
+}
+
+func (s *Server) failHeld(rec tinpot.ExecutionRecord, stream *executionStream, message string) {
+	status := s.executionCompleted(rec.ID, rec.Action, rec.Tenant, message, nil)
+	s.completeStream(rec.ID, stream, status, message, nil)
+}
+
+func (s *Server) executionStarted(execID, actionName, tenant string) {
+	// Workers refuse to run the actions as another user than announced
+	runAs := s.mgr.ListActions()[tinpot.QualifiedName(tenant, actionName)].RunAs
+	s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+		now := time.Now()
+		rec.Status = tinpot.StatusRunning
+		rec.StartedAt = &now
+		rec.RunAs = runAs
+	})
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventStarted, ExecutionID: execID, Action: actionName, Tenant: tenant})
+}
+
+// executionCompleted records the outcome and returns the final status
+func (s *Server) executionCompleted(execID, actionName, tenant, err string, res map[string]interface{}) string {
+	status := tinpot.StatusSuccess
+	if err == tinpot.ExpiredError {
+		status = tinpot.StatusExpired
+	} else if err == tinpot.CancelledError {
+		status = tinpot.StatusCancelled
+	} else if err != "" {
+		status = tinpot.StatusFailure
+	}
+	s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+		now := time.Now()
+		rec.Status = status
+		// Results are stored in plain JSON form, so executions can be compared
+		rec.Result = res
+		if normalized, ok := tinpot.NormalizeJSON(res).(map[string]interface{}); ok {
+			rec.Result = normalized
+		}
+		rec.Error = err
+		rec.CompletedAt = &now
+		rec.ComputeDurations()
+	})
+	s.events.Publish(tinpot.ExecutionEvent{
+		Type: tinpot.EventCompleted, ExecutionID: execID, Action: actionName, Tenant: tenant,
+		Status: status, Result: res, Error: err,
+	})
+	return status
+}
+
+// startAsync triggers the action, its logs and outcome go to the stream
+func (s *Server) startAsync(execID, actionName, tenant string, trigger tinpot.ActionTrigger, params map[string]interface{}, release func(), stream *executionStream) {
+	// Log Callback
+	// The callbacks are called concurrently, e.g. by the MQTT client
+	var execLog executionLog
+	var logged atomic.Bool
+	var progressPoints atomic.Int64
+	logCallback := func(level string, message string) {
+		if level == tinpot.LogLevelProgress {
+			var progress tinpot.Progress
+			if err := json.Unmarshal([]byte(message), &progress); err != nil {
+				return
+			}
+			if progressPoints.Add(1) <= tinpot.MaxProgressPoints {
+				point := tinpot.ProgressPoint{At: time.Now(), Progress: progress}
+				s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+					rec.ProgressPoints = append(rec.ProgressPoints, point)
+				})
+			}
+			s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventProgress, ExecutionID: execID, Action: actionName, Tenant: tenant, Progress: &progress})
+			stream.send(StreamEvent{Type: "progress", Data: progress})
+			return
+		}
+		if logged.CompareAndSwap(false, true) {
+			now := time.Now()
+			s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+				rec.FirstLogAt = &now
+			})
+		}
+		allowed, truncated := s.quotas.allowLog(tenant, tinpot.QualifiedName(tenant, actionName), &execLog, len(message))
+		if truncated {
+			level = "WARN"
+			message = "Log quota exceeded, further logs are dropped"
+		} else if !allowed {
+			return
+		}
+		s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventLog, ExecutionID: execID, Action: actionName, Tenant: tenant, Level: level, Message: message})
+		event := StreamEvent{
+			Type: "log",
+			Data: tinpot.MqttLogEntry{
+				Timestamp: time.Now().Format(time.RFC3339),
+				Level:     level,
+				Message:   message,
+			},
+		}
+		stream.send(event)
+	}
+
+	// Response Callback
+	responseCallback := func(err string, res map[string]interface{}) {
+		release()
+		s.completeStream(execID, stream, s.executionCompleted(execID, actionName, tenant, err, res), err, res)
+	}
+
+	s.executionStarted(execID, actionName, tenant)
+	go trigger(s.withReports(execID, params), responseCallback, logCallback)
+}
+
+// withReports adds the UsageReport, EnvironmentReport, TimingReport and PublishReport recording the resource
+// usage, environment, worker times and publication of the execution to its parameters. They are added on triggering, as the
+// parameters of held executions are persisted.
+func (s *Server) withReports(execID string, params map[string]interface{}) map[string]interface{} {
+	reporting := make(map[string]interface{}, len(params)+4)
+	for k, v := range params {
+		reporting[k] = v
+	}
+	reporting[tinpot.UsageParameter] = tinpot.UsageReport(func(usage tinpot.ResourceUsage) {
+		s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+			rec.Usage = &usage
+		})
+	})
+	reporting[tinpot.EnvironmentParameter] = tinpot.EnvironmentReport(func(environment tinpot.ExecutionEnvironment) {
+		s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+			rec.Environment = &environment
+		})
+	})
+	reporting[tinpot.TimingParameter] = tinpot.TimingReport(func(times tinpot.WorkerTimes) {
+		s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+			if times.CompletedAt == nil && rec.AcceptedAt == nil {
+				now := time.Now()
+				rec.AcceptedAt = &now
+			}
+			rec.WorkerTimes = &times
+		})
+	})
+	reporting[tinpot.PublishParameter] = tinpot.PublishReport(func(at time.Time) {
+		s.updateRecord(execID, func(rec *tinpot.ExecutionRecord) {
+			rec.PublishedAt = &at
+		})
+	})
+	return reporting
+}
+
+// completeStream sends the outcome and closes the stream
+func (s *Server) completeStream(execID string, stream *executionStream, status, err string, res map[string]interface{}) {
+	success := err == ""
+	data := map[string]interface{}{
+		"state":      status,
+		"successful": success,
+	}
+	if success {
+		data["result"] = res
+	} else {
+		data["error"] = err
+	}
+
+	// Send complete and close
+	stream.send(StreamEvent{Type: "complete", Data: data})
+	s.closeStream(execID, stream)
+}
+
+func (s *Server) updateRecord(id string, fn func(*tinpot.ExecutionRecord)) {
+	if err := s.store.Update(id, fn); err != nil {
+		log.Printf("Failed to update execution %s: %v", id, err)
+	}
+}
+
+// record returns the execution record only if it belongs to the tenant
+func (s *Server) record(id string, tenant string) (tinpot.ExecutionRecord, error) {
+	rec, err := s.store.Get(id)
+	if err == nil && rec.Tenant != tenant {
+		return tinpot.ExecutionRecord{}, tinpot.ErrExecutionNotFound
+	}
+	return rec, err
+}
+
+func (s *Server) listExecutions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := tinpot.ExecutionFilter{
+		Tenant:   TenantFromRequest(r),
+		Action:   query.Get("action"),
+		Status:   query.Get("status"),
+		Origin:   query.Get("origin"),
+		OriginID: query.Get("origin_id"),
+		Limit:    100,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeJSON(w, 400, map[string]string{"detail": "Invalid limit"})
+			return
+		}
+		filter.Limit = n
+	}
+	if before := query.Get("before"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			writeJSON(w, 400, map[string]string{"detail": "Invalid before, expected RFC 3339 time"})
+			return
+		}
+		filter.Before = t
+	}
+	switch query.Get("archived") {
+	case "", "false":
+		filter.Archived = tinpot.ExcludeArchived
+	case "true":
+		filter.Archived = tinpot.OnlyArchived
+	case "all":
+		filter.Archived = tinpot.IncludeArchived
+	default:
+		writeJSON(w, 400, map[string]string{"detail": "Invalid archived, expected true, false or all"})
+		return
+	}
+	s.visibilityFilter(r, &filter)
+
+	records, err := s.store.List(filter)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	if records == nil {
+		records = []tinpot.ExecutionRecord{}
+	}
+	all := filter
+	all.Before, all.Limit = time.Time{}, 0
+	if matching, err := s.store.List(all); err == nil {
+		w.Header().Set(TotalCountHeader, strconv.Itoa(len(matching)))
+	}
+	if filter.Limit > 0 && len(records) == filter.Limit {
+		w.Header().Set(NextCursorHeader, records[len(records)-1].SubmittedAt.Format(time.RFC3339Nano))
+	}
+	writeJSON(w, 200, records)
+}
+
+func (s *Server) getExecution(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 200, rec)
+}
+
+// scratchFetchTimeout is how long the workers are given to answer a scratch download
+const scratchFetchTimeout = 10 * time.Second
+
+// getScratch downloads the scratch directory of an execution from the worker keeping it
+func (s *Server) getScratch(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	fetcher, ok := s.aliases.ActionManager.(tinpot.ScratchFetcher)
+	if !ok {
+		writeJSON(w, 501, map[string]string{"detail": "Scratch directories not supported"})
+		return
+	}
+	if err := s.unsupported(rec, tinpot.CapabilityArtifacts, "don't keep scratch directories"); err != "" {
+		writeJSON(w, 501, map[string]string{"detail": err})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), scratchFetchTimeout)
+	defer cancel()
+	archive, err := fetcher.FetchScratch(ctx, rec.Tenant, rec.ID)
+	if err == tinpot.ErrScratchNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Scratch directory not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 502, map[string]string{"detail": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-scratch.tar.gz"`, rec.ID))
+	w.Write(archive)
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{
+		"execution_id": r.PathValue("id"),
+		"state":        "UNKNOWN",
+		"ready":        false,
+	}
+	if rec, err := s.visibleRecord(r, r.PathValue("id")); err == nil {
+		status["state"] = rec.Status
+		status["ready"] = rec.Done()
+		if rec.Status == tinpot.StatusSuccess {
+			status["result"] = rec.Result
+		} else if rec.Status == tinpot.StatusFailure {
+			status["error"] = rec.Error
+		}
+	}
+	if stream := s.visibleStream(r, r.PathValue("id")); stream != nil {
+		status["stream"] = stream.stats()
+	}
+	writeJSON(w, 200, status)
+}
+
+// diffResults compares the results of two executions of an action. Without from/to,
+// the two latest successful executions are compared.
+func (s *Server) diffResults(w http.ResponseWriter, r *http.Request) {
+	actionName := r.PathValue("name")
+	tenant := TenantFromRequest(r)
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+
+	if from == "" || to == "" {
+		filter := tinpot.ExecutionFilter{Tenant: tenant, Action: actionName, Status: tinpot.StatusSuccess, Limit: 2}
+		s.visibilityFilter(r, &filter)
+		latest, err := s.store.List(filter)
+		if err != nil {
+			writeJSON(w, 500, map[string]string{"detail": err.Error()})
+			return
+		}
+		if to == "" && len(latest) > 0 {
+			to = latest[0].ID
+		}
+		if from == "" {
+			for _, rec := range latest {
+				if rec.ID != to {
+					from = rec.ID
+					break
+				}
+			}
+		}
+		if from == "" || to == "" {
+			writeJSON(w, 404, map[string]string{"detail": "Not enough successful executions to compare"})
+			return
+		}
+	}
+
+	var records [2]tinpot.ExecutionRecord
+	for i, id := range []string{from, to} {
+		rec, err := s.visibleRecord(r, id)
+		if err == tinpot.ErrExecutionNotFound {
+			writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Execution not found: %s", id)})
+			return
+		}
+		if err != nil {
+			writeJSON(w, 500, map[string]string{"detail": err.Error()})
+			return
+		}
+		if rec.Action != actionName {
+			writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Execution %s is not of action %s", id, actionName)})
+			return
+		}
+		records[i] = rec
+	}
+
+	changes := tinpot.DiffJSON(tinpot.NormalizeJSON(records[0].Result), tinpot.NormalizeJSON(records[1].Result))
+	if changes == nil {
+		changes = []tinpot.JSONChange{}
+	}
+	writeJSON(w, 200, ActionDiffResponse{
+		Action:  actionName,
+		From:    from,
+		To:      to,
+		Changes: changes,
+	})
+}
+
+// rerunExecution runs the action of a past execution again with its parameters,
+// updated by the overrides in the request body (which is optional)
+func (s *Server) rerunExecution(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	var req ExecuteActionRequest
+	if err := s.decodeBody(w, r, &req); err != nil && err != io.EOF {
+		writeBodyError(w, err)
+		return
+	}
+
+	params := make(map[string]interface{}, len(rec.Parameters)+len(req.Parameters))
+	for k, v := range rec.Parameters {
+		params[k] = v
+	}
+	if name := reservedParameter(req.Parameters); name != "" {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Reserved parameter: %q", name)})
+		return
+	}
+	for k, v := range req.Parameters {
+		params[k] = v
+	}
+	selector := rec.Selector
+	if req.Selector != "" {
+		if _, err := tinpot.ParseSelector(req.Selector); err != nil {
+			writeJSON(w, 400, map[string]string{"detail": err.Error()})
+			return
+		}
+		selector = req.Selector
+	}
+	session := rec.Session
+	if req.Session != "" {
+		session = req.Session
+	}
+	priority := rec.Priority
+	if req.Priority != "" {
+		if !validPriority(req.Priority) {
+			writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid priority: %q", req.Priority)})
+			return
+		}
+		priority = req.Priority
+	}
+
+	sub := submission{
+		tenant:     tenant,
+		action:     rec.Action,
+		parameters: params,
+		rerunOf:    rec.ID,
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginRerun, ID: rec.ID},
+		traceID:    traceID(r),
+		selector:   selector,
+		session:    session,
+		priority:   priority,
+	}
+	sub.requestedBy, sub.requesterGroups = requester(r)
+	s.submit(w, sub, r.URL.Query().Get("sync") == "true")
+}
+
+func (s *Server) addComment(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	var req CommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		writeJSON(w, 400, map[string]string{"detail": "Comment text is required"})
+		return
+	}
+
+	if id := IdentityFromRequest(r); req.Author == "" && id != nil {
+		req.Author = id.Subject
+	}
+	comment := tinpot.ExecutionComment{
+		ID:        uuid.New().String(),
+		Author:    req.Author,
+		Text:      req.Text,
+		CreatedAt: time.Now(),
+	}
+	if err := s.store.Update(rec.ID, func(rec *tinpot.ExecutionRecord) {
+		rec.Comments = append(rec.Comments, comment)
+	}); err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 201, comment)
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	comments := rec.Comments
+	if comments == nil {
+		comments = []tinpot.ExecutionComment{}
+	}
+	writeJSON(w, 200, comments)
+}
+
+func (s *Server) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == nil {
+		err = s.store.Update(rec.ID, func(rec *tinpot.ExecutionRecord) {
+			rec.Archived = archived
+		})
+	}
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"execution_id": rec.ID, "archived": archived})
+}
+
+// archiveExecutions archives every execution of the caller's tenant matching the filter in the body
+func (s *Server) archiveExecutions(w http.ResponseWriter, r *http.Request) {
+	var req ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	if req.Action == "" && req.Status == "" && req.Before == nil {
+		writeJSON(w, 400, map[string]string{"detail": "At least one of action, status or before is required"})
+		return
+	}
+
+	filter := tinpot.ExecutionFilter{
+		Tenant: TenantFromRequest(r),
+		Action: req.Action,
+		Status: req.Status,
+	}
+	if req.Before != nil {
+		filter.Before = *req.Before
+	}
+	s.visibilityFilter(r, &filter)
+	records, err := s.store.List(filter)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	archived := 0
+	for _, rec := range records {
+		if err := s.store.Update(rec.ID, func(rec *tinpot.ExecutionRecord) { rec.Archived = true }); err == nil {
+			archived++
+		}
+	}
+	writeJSON(w, 200, map[string]int{"archived": archived})
+}
+
+func (s *Server) cancelAction(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	if s.cancelHeld(rec) {
+		rec, _ = s.store.Get(rec.ID)
+		writeJSON(w, 200, rec)
+		return
+	}
+	if err := s.unsupported(rec, tinpot.CapabilityCancel, "can't cancel executions"); err != "" {
+		writeJSON(w, 501, map[string]string{"detail": err})
+		return
+	}
+	// No worker announces cancellation yet
+	writeJSON(w, 501, map[string]string{"detail": "Cancellation not supported"})
+}
+
+// unsupported explains why a feature is not available for the execution, if its action's
+// workers didn't announce the capability
+func (s *Server) unsupported(rec tinpot.ExecutionRecord, capability, explanation string) string {
+	act, ok := s.mgr.ListActions()[tinpot.QualifiedName(rec.Tenant, rec.Action)]
+	if !ok || act.Supports(capability) {
+		return ""
+	}
+	return fmt.Sprintf("The workers of %s %s (no %q capability)", rec.Action, explanation, capability)
+}
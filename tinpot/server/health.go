@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// healthCheckTimeout bounds each readiness check
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is a component check of the readiness probe
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthResponse is the body of /livez and /readyz
+type HealthResponse struct {
+	Status string              `json:"status"` // "ok" or "failing"
+	Checks []HealthCheckStatus `json:"checks"`
+}
+
+type HealthCheckStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// storePinger is implemented by execution stores with a cheaper reachability check than a listing
+type storePinger interface {
+	Ping(ctx context.Context) error
+}
+
+// livez tells whether the process serves requests; the dependencies are left to readyz,
+// as restarting the coordinator does not fix the broker
+func (s *Server) livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, HealthResponse{Status: "ok", Checks: []HealthCheckStatus{}})
+}
+
+// readyz runs the component checks, failing when any of them fails
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: "ok"}
+	for _, check := range s.readinessChecks() {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		start := time.Now()
+		err := check.Check(ctx)
+		cancel()
+		status := HealthCheckStatus{Name: check.Name, Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			status.Status = "failing"
+			status.Error = err.Error()
+			resp.Status = "failing"
+		}
+		resp.Checks = append(resp.Checks, status)
+	}
+	if resp.Status != "ok" {
+		writeJSON(w, 503, resp)
+		return
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) readinessChecks() []HealthCheck {
+	checks := []HealthCheck{
+		{Name: "mqtt", Check: func(ctx context.Context) error {
+			if !s.mgr.IsConnected() {
+				return errors.New("MQTT not connected")
+			}
+			return nil
+		}},
+		{Name: "store", Check: func(ctx context.Context) error {
+			if pinger, ok := s.store.(storePinger); ok {
+				return pinger.Ping(ctx)
+			}
+			_, err := s.store.List(tinpot.ExecutionFilter{Limit: 1})
+			return err
+		}},
+	}
+	if s.opts.MinWorkers > 0 {
+		checks = append(checks, HealthCheck{Name: "workers", Check: func(ctx context.Context) error {
+			if n := s.onlineWorkers(); n < s.opts.MinWorkers {
+				return fmt.Errorf("%d of the required %d workers connected", n, s.opts.MinWorkers)
+			}
+			return nil
+		}})
+	}
+	return append(checks, s.healthChecks...)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RequestLimits protect the coordinator, the broker and the workers' conversion of the
+// parameters from oversized or maliciously deep execution requests. Zero fields take the
+// defaults.
+type RequestLimits struct {
+	// MaxBodyBytes caps the body of the execute requests (default 1 MiB)
+	MaxBodyBytes int64
+	// MaxParameters caps the number of parameters of an execution (default 256)
+	MaxParameters int
+	// MaxDepth caps the nesting of the lists and objects in a parameter value (default 32)
+	MaxDepth int
+}
+
+// decodeBody decodes the JSON body of an execute request within MaxBodyBytes, see writeBodyError
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, s.opts.RequestLimits.MaxBodyBytes)).Decode(v)
+}
+
+// writeBodyError rejects a body decodeBody failed on
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, 413, map[string]string{"detail": fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit)})
+		return
+	}
+	writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+}
+
+// check rejects the parameters beyond the count and depth limits
+func (l RequestLimits) check(parameters map[string]interface{}) error {
+	if len(parameters) > l.MaxParameters {
+		return fmt.Errorf("too many parameters: %d, at most %d are accepted", len(parameters), l.MaxParameters)
+	}
+	for name, value := range parameters {
+		if depth(value, l.MaxDepth) > l.MaxDepth {
+			return fmt.Errorf("parameter %s is nested deeper than %d levels", name, l.MaxDepth)
+		}
+	}
+	return nil
+}
+
+// depth is the nesting of the lists and objects in a value, only counted to one above limit
+func depth(value interface{}, limit int) int {
+	var children []interface{}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = value
+	default:
+		return 0
+	}
+	deepest := 0
+	for _, child := range children {
+		if limit > 0 {
+			deepest = max(deepest, depth(child, limit-1))
+		}
+	}
+	return deepest + 1
+}
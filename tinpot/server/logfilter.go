@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// maxGrepLength bounds the grep pattern of the stream requests
+const maxGrepLength = 1024
+
+// levelRanks orders the log levels of the actions, unknown ones rank as INFO
+var levelRanks = map[string]int{"DEBUG": 10, "INFO": 20, "WARN": 30, "WARNING": 30, "ERROR": 40, "CRITICAL": 50, "FATAL": 50}
+
+func levelRank(level string) int {
+	if rank, ok := levelRanks[strings.ToUpper(level)]; ok {
+		return rank
+	}
+	return levelRanks["INFO"]
+}
+
+// logFilter narrows the log events of an execution stream, the other events pass
+type logFilter struct {
+	// level is the lowest rank passing, see levelRank
+	level   int
+	pattern *regexp.Regexp
+	// tail keeps only the last log events of the buffered ones, when not 0
+	tail int
+}
+
+// parseLogFilter reads the level (the lowest one to pass), grep (a regular expression on the
+// message) and tail (the number of buffered log events to start with) query parameters
+func parseLogFilter(query url.Values) (logFilter, error) {
+	var f logFilter
+	if level := query.Get("level"); level != "" {
+		rank, ok := levelRanks[strings.ToUpper(level)]
+		if !ok {
+			return f, fmt.Errorf("invalid level %q, expected DEBUG, INFO, WARNING, ERROR or CRITICAL", level)
+		}
+		f.level = rank
+	}
+	if grep := query.Get("grep"); grep != "" {
+		if len(grep) > maxGrepLength {
+			return f, fmt.Errorf("invalid grep, longer than %d characters", maxGrepLength)
+		}
+		pattern, err := regexp.Compile(grep)
+		if err != nil {
+			return f, fmt.Errorf("invalid grep: %v", err)
+		}
+		f.pattern = pattern
+	}
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return f, errors.New("invalid tail")
+		}
+		f.tail = n
+	}
+	return f, nil
+}
+
+func (f logFilter) matches(event StreamEvent) bool {
+	entry, ok := event.Data.(tinpot.MqttLogEntry)
+	if event.Type != "log" || !ok {
+		return true
+	}
+	return levelRank(entry.Level) >= f.level && (f.pattern == nil || f.pattern.MatchString(entry.Message))
+}
+
+// apply filters a batch of events. Of the buffered batch, the first one sent, only the last
+// tail log events are kept.
+func (f logFilter) apply(events []bufferedEvent, buffered bool) []bufferedEvent {
+	var filtered []bufferedEvent
+	for _, event := range events {
+		if f.matches(event.event) {
+			filtered = append(filtered, event)
+		}
+	}
+	if !buffered || f.tail == 0 {
+		return filtered
+	}
+	logs := 0
+	for _, event := range filtered {
+		if event.event.Type == "log" {
+			logs++
+		}
+	}
+	tailed := filtered[:0]
+	for _, event := range filtered {
+		if event.event.Type == "log" && logs > f.tail {
+			logs--
+			continue
+		}
+		tailed = append(tailed, event)
+	}
+	return tailed
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/robfig/cron/v3"
+	"github.com/teambition/rrule-go"
+)
+
+// Policies of the executions requested outside of the maintenance windows
+const (
+	// MaintenancePolicyQueue holds the execution until the next window opens
+	MaintenancePolicyQueue = "queue"
+	// MaintenancePolicyReject rejects the execution with 409
+	MaintenancePolicyReject = "reject"
+)
+
+// MaintenanceWindow is either recurring, opening on the Cron schedule (a "CRON_TZ=Europe/Berlin"
+// prefix sets its time zone) for Duration, or a single window from Start to End
+type MaintenanceWindow struct {
+	Cron     string    `json:"cron,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Start    time.Time `json:"start,omitempty"`
+	End      time.Time `json:"end,omitempty"`
+}
+
+// MaintenanceCalendar are the windows in which the actions of a group may run
+type MaintenanceCalendar struct {
+	// Policy outside of the windows, MaintenancePolicyQueue by default
+	Policy  string              `json:"policy,omitempty"`
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+	// ICal is an iCalendar file whose events (with RRULE, RDATE and EXDATE) are windows too
+	ICal string `json:"ical,omitempty"`
+}
+
+// MaintenanceConfig are the calendars by action group, as JSON:
+//
+//	{
+//	  "databases": {"policy": "reject", "windows": [{"cron": "0 2 * * SAT", "duration": "4h"}]},
+//	  "team-a/network": {"ical": "/etc/tinpot/network-changes.ics"}
+//	}
+//
+// Groups are qualified by the tenant like actions. Groups without a calendar run any time.
+type MaintenanceConfig map[string]*MaintenanceCalendar
+
+// MaintenanceStatus reports whether the window of a group is open
+type MaintenanceStatus struct {
+	Policy   string     `json:"policy"`
+	Open     bool       `json:"open"`
+	OpensAt  *time.Time `json:"opens_at,omitempty"`
+	ClosesAt *time.Time `json:"closes_at,omitempty"`
+}
+
+// LoadMaintenanceConfig reads a MaintenanceConfig from a JSON file, with its iCalendar files
+func LoadMaintenanceConfig(path string) (MaintenanceConfig, error) {
+	var config MaintenanceConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	for group, calendar := range config {
+		if _, err := calendar.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", group, err)
+		}
+	}
+	return config, nil
+}
+
+// window is a compiled MaintenanceWindow or calendar event
+type window interface {
+	// openAt tells if t is in the window and when it closes, otherwise when it opens next
+	// (zero when never)
+	openAt(t time.Time) (bool, time.Time)
+}
+
+type fixedWindow struct {
+	start, end time.Time
+}
+
+func (w fixedWindow) openAt(t time.Time) (bool, time.Time) {
+	switch {
+	case t.Before(w.start):
+		return false, w.start
+	case t.Before(w.end):
+		return true, w.end
+	}
+	return false, time.Time{}
+}
+
+type cronWindow struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+func (w cronWindow) openAt(t time.Time) (bool, time.Time) {
+	start := w.schedule.Next(t.Add(-w.duration))
+	if !start.After(t) {
+		return true, start.Add(w.duration)
+	}
+	return false, start
+}
+
+type ruleWindow struct {
+	set      *rrule.Set
+	duration time.Duration
+}
+
+func (w ruleWindow) openAt(t time.Time) (bool, time.Time) {
+	if start := w.set.Before(t, true); !start.IsZero() && t.Before(start.Add(w.duration)) {
+		return true, start.Add(w.duration)
+	}
+	return false, w.set.After(t, false)
+}
+
+type maintenanceCalendar struct {
+	policy  string
+	windows []window
+}
+
+// openAt tells if any window is open at t and when it closes, otherwise when the first one opens
+func (c *maintenanceCalendar) openAt(t time.Time) (bool, time.Time) {
+	var next time.Time
+	open := false
+	for _, w := range c.windows {
+		inside, at := w.openAt(t)
+		switch {
+		case inside && (!open || at.After(next)):
+			open, next = true, at
+		case !inside && !open && !at.IsZero() && (next.IsZero() || at.Before(next)):
+			next = at
+		}
+	}
+	return open, next
+}
+
+func (c *MaintenanceCalendar) compile() (*maintenanceCalendar, error) {
+	compiled := &maintenanceCalendar{policy: c.Policy}
+	if compiled.policy == "" {
+		compiled.policy = MaintenancePolicyQueue
+	}
+	if compiled.policy != MaintenancePolicyQueue && compiled.policy != MaintenancePolicyReject {
+		return nil, fmt.Errorf("unknown policy %q", c.Policy)
+	}
+	for _, w := range c.Windows {
+		if w.Cron == "" {
+			if !w.End.After(w.Start) {
+				return nil, fmt.Errorf("window without cron or end after start")
+			}
+			compiled.windows = append(compiled.windows, fixedWindow{w.Start, w.End})
+			continue
+		}
+		schedule, err := cron.ParseStandard(w.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", w.Cron, err)
+		}
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", w.Duration)
+		}
+		compiled.windows = append(compiled.windows, cronWindow{schedule, duration})
+	}
+	if c.ICal != "" {
+		data, err := os.ReadFile(c.ICal)
+		if err != nil {
+			return nil, err
+		}
+		windows, err := parseICal(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.ICal, err)
+		}
+		compiled.windows = append(compiled.windows, windows...)
+	}
+	return compiled, nil
+}
+
+// parseICal reads the VEVENTs of an iCalendar document as windows
+func parseICal(data string) ([]window, error) {
+	// Unfold the continuation lines
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.NewReplacer("\n ", "", "\n\t", "").Replace(data)
+	var windows []window
+	var event []string
+	inEvent := false
+	for _, line := range strings.Split(data, "\n") {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent, event = true, nil
+		case line == "END:VEVENT":
+			w, err := eventWindow(event)
+			if err != nil {
+				return nil, err
+			}
+			windows = append(windows, w)
+			inEvent = false
+		case inEvent:
+			event = append(event, line)
+		}
+	}
+	return windows, nil
+}
+
+// eventWindow is the window of an event from DTSTART to DTEND (or for DURATION), repeated
+// by its RRULE and RDATEs except the EXDATEs
+func eventWindow(lines []string) (window, error) {
+	var start, end time.Time
+	var duration time.Duration
+	var rules []string
+	allDay := false
+	for _, line := range lines {
+		name, value, _ := strings.Cut(line, ":")
+		property, params, _ := strings.Cut(name, ";")
+		tzid := ""
+		for _, param := range strings.Split(params, ";") {
+			if strings.HasPrefix(param, "TZID=") {
+				tzid = param + ":"
+			}
+		}
+		var err error
+		switch property {
+		case "DTSTART":
+			start, err = rrule.StrToDtStart(tzid+value, time.Local)
+			allDay = len(value) == len("20060102")
+			rules = append([]string{"DTSTART;" + tzid + value}, rules...)
+		case "DTEND":
+			end, err = rrule.StrToDtStart(tzid+value, time.Local)
+		case "DURATION":
+			duration, err = parseICalDuration(value)
+		case "RRULE", "RDATE", "EXDATE":
+			rules = append(rules, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", property, err)
+		}
+	}
+	if start.IsZero() {
+		return nil, fmt.Errorf("event without DTSTART")
+	}
+	if !end.IsZero() {
+		duration = end.Sub(start)
+	} else if duration == 0 && allDay {
+		duration = 24 * time.Hour
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("event at %s without DTEND or DURATION", start)
+	}
+	if len(rules) == 1 {
+		return fixedWindow{start, start.Add(duration)}, nil
+	}
+	set, err := rrule.StrSliceToRRuleSetInLoc(rules, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return ruleWindow{set, duration}, nil
+}
+
+var icalDuration = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICalDuration parses RFC 5545 durations, e.g. PT2H30M or P1D
+func parseICalDuration(value string) (time.Duration, error) {
+	match := icalDuration.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var duration time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(match[i+1])
+		duration += time.Duration(n) * unit
+	}
+	return duration, nil
+}
+
+// maintenanceCalendar returns the calendar of the action's group, nil when it runs any time
+func (s *Server) maintenanceCalendar(tenant, action string) (string, *maintenanceCalendar) {
+	info, ok := s.mgr.ListActions()[tinpot.QualifiedName(tenant, action)]
+	if !ok || info.Group == "" {
+		return "", nil
+	}
+	return info.Group, s.calendars[tinpot.QualifiedName(tenant, info.Group)]
+}
+
+// GET /api/maintenance reports the windows of the tenant's groups
+func (s *Server) listMaintenance(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	now := time.Now()
+	resp := make(map[string]MaintenanceStatus)
+	for key, calendar := range s.calendars {
+		calendarTenant, group := tinpot.SplitQualifiedName(key)
+		if calendarTenant != tenant {
+			continue
+		}
+		open, at := calendar.openAt(now)
+		status := MaintenanceStatus{Policy: calendar.policy, Open: open}
+		if !at.IsZero() && open {
+			status.ClosesAt = &at
+		} else if !at.IsZero() {
+			status.OpensAt = &at
+		}
+		resp[group] = status
+	}
+	writeJSON(w, 200, resp)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// MemoryStore is an ExecutionStore keeping the history in memory
+type MemoryStore struct {
+	mu         sync.RWMutex
+	records    map[string]*tinpot.ExecutionRecord
+	order      []string // ids in submission order
+	maxRecords int
+}
+
+// NewMemoryStore creates a store that keeps at most maxRecords executions,
+// dropping the oldest ones first. 0 means no limit.
+func NewMemoryStore(maxRecords int) *MemoryStore {
+	return &MemoryStore{
+		records:    make(map[string]*tinpot.ExecutionRecord),
+		maxRecords: maxRecords,
+	}
+}
+
+func (m *MemoryStore) Create(record tinpot.ExecutionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.records[record.ID]; ok {
+		return fmt.Errorf("execution already exists: %s", record.ID)
+	}
+	m.records[record.ID] = &record
+	m.order = append(m.order, record.ID)
+	for m.maxRecords > 0 && len(m.order) > m.maxRecords {
+		delete(m.records, m.order[0])
+		m.order = m.order[1:]
+	}
+	return nil
+}
+
+func (m *MemoryStore) Update(id string, fn func(*tinpot.ExecutionRecord)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return tinpot.ErrExecutionNotFound
+	}
+	fn(rec)
+	return nil
+}
+
+func (m *MemoryStore) Get(id string) (tinpot.ExecutionRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return tinpot.ExecutionRecord{}, tinpot.ErrExecutionNotFound
+	}
+	return *rec, nil
+}
+
+func (m *MemoryStore) List(filter tinpot.ExecutionFilter) ([]tinpot.ExecutionRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []tinpot.ExecutionRecord
+	for i := len(m.order) - 1; i >= 0; i-- {
+		rec := m.records[m.order[i]]
+		if !filter.Match(rec) {
+			continue
+		}
+		result = append(result, *rec)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result, nil
+}
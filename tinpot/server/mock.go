@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// MockConfig makes the coordinator answer executions with canned results instead of
+// triggering the workers, for UI development and demos without real infrastructure
+type MockConfig struct {
+	// All mocks every action, and the coordinator counts as connected without a broker
+	All bool `json:"all,omitempty"`
+	// Actions are the mocked actions by qualified name
+	Actions map[string]MockAction `json:"actions,omitempty"`
+}
+
+// MockAction is the canned response of a mocked action. Without a result or an error, the
+// announced example matching the parameters answers, or the first example with a result.
+type MockAction struct {
+	// Action declares the action when no worker announces it
+	Action *tinpot.ActionInfo     `json:"action,omitempty"`
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	// Logs are sent before the result
+	Logs []string `json:"logs,omitempty"`
+	// Delay of the result, like "2s"
+	Delay string `json:"delay,omitempty"`
+}
+
+// LoadMockConfig reads the mock configuration from a JSON file:
+//
+//	{"actions": {"backup": {"result": {"files": 42}, "logs": ["Copying..."], "delay": "3s"}}}
+func LoadMockConfig(path string) (MockConfig, error) {
+	var config MockConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	return config, config.validate()
+}
+
+func (c MockConfig) validate() error {
+	for name, mock := range c.Actions {
+		if mock.Delay == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(mock.Delay); err != nil {
+			return fmt.Errorf("invalid delay of mocked action %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// mockManager answers the mocked actions of its MockConfig itself and passes the others on
+type mockManager struct {
+	tinpot.ActionManager
+	mu     sync.RWMutex
+	config MockConfig
+}
+
+func (m *mockManager) mocked(qualified string) (MockAction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mock, ok := m.config.Actions[qualified]
+	return mock, ok || m.config.All
+}
+
+func (m *mockManager) ListActions() map[string]tinpot.ActionInfo {
+	actions := m.ActionManager.ListActions()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for qualified, mock := range m.config.Actions {
+		if _, ok := actions[qualified]; ok || mock.Action == nil {
+			continue
+		}
+		info := *mock.Action
+		info.Tenant, info.Name = tinpot.SplitQualifiedName(qualified)
+		actions[qualified] = info
+	}
+	return actions
+}
+
+func (m *mockManager) GetAction(name string) tinpot.ActionTrigger {
+	mock, ok := m.mocked(name)
+	if !ok {
+		return m.ActionManager.GetAction(name)
+	}
+	act, ok := m.ListActions()[name]
+	if !ok {
+		return nil
+	}
+	delay, _ := time.ParseDuration(mock.Delay)
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		tinpot.ReportEnvironment(parameters, tinpot.ExecutionEnvironment{Mock: true})
+		go func() {
+			for _, line := range mock.Logs {
+				if logs != nil {
+					logs("INFO", line)
+				}
+			}
+			time.Sleep(delay)
+			if mock.Error != "" {
+				response(mock.Error, nil)
+				return
+			}
+			response("", mockResult(mock, act, parameters))
+		}()
+	}
+}
+
+// mockResult is the configured result, or the one of the example the parameters match
+func mockResult(mock MockAction, act tinpot.ActionInfo, parameters map[string]interface{}) map[string]interface{} {
+	if mock.Result != nil {
+		return mock.Result
+	}
+	var fallback map[string]interface{}
+	for _, example := range act.Examples {
+		if example.Result == nil {
+			continue
+		}
+		matches := true
+		for name, value := range example.Parameters {
+			if !reflect.DeepEqual(parameters[name], value) {
+				matches = false
+			}
+		}
+		if matches {
+			return example.Result
+		}
+		if fallback == nil {
+			fallback = example.Result
+		}
+	}
+	if fallback == nil {
+		fallback = map[string]interface{}{}
+	}
+	return fallback
+}
+
+func (m *mockManager) IsConnected() bool {
+	m.mu.RLock()
+	all := m.config.All
+	m.mu.RUnlock()
+	return all || m.ActionManager.IsConnected()
+}
+
+// getMockConfig returns the mock configuration of every tenant
+func (s *Server) getMockConfig(w http.ResponseWriter, r *http.Request) {
+	s.mock.mu.RLock()
+	defer s.mock.mu.RUnlock()
+	writeJSON(w, 200, s.mock.config)
+}
+
+// putMockConfig replaces the mock configuration, {} turns the mock mode off
+func (s *Server) putMockConfig(w http.ResponseWriter, r *http.Request) {
+	var config MockConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid mock configuration: %v", err)})
+		return
+	}
+	if err := config.validate(); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	s.mock.mu.Lock()
+	s.mock.config = config
+	s.mock.mu.Unlock()
+	writeJSON(w, 200, config)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// maxStreamExecutions caps the executions listed for one combined stream
+const maxStreamExecutions = 100
+
+// streamExecutions multiplexes the events of several executions into one SSE connection (or
+// ndjson, like streamLogs), each event carrying its execution_id. With ids the listed
+// executions are followed and the stream ends with an "end" event once all of them
+// completed. With action the running executions of the action are followed, and the ones
+// submitted later, until the client disconnects. The log events are filtered like those of
+// streamLogs. The combined stream doesn't resume after a Last-Event-ID.
+func (s *Server) streamExecutions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	action := query.Get("action")
+	var ids []string
+	for _, id := range strings.Split(query.Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if (len(ids) == 0) == (action == "") {
+		writeJSON(w, 400, map[string]string{"detail": "Either ids or action is required"})
+		return
+	}
+	if len(ids) > maxStreamExecutions {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("At most %d executions can be streamed together", maxStreamExecutions)})
+		return
+	}
+	filter, err := parseLogFilter(query)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	tenant := TenantFromRequest(r)
+	visible := tinpot.ExecutionFilter{Tenant: tenant, Action: action, Archived: tinpot.ExcludeArchived, Limit: maxStreamExecutions}
+	s.visibilityFilter(r, &visible)
+
+	streams := make(map[string]*executionStream)
+	missing := []string{}
+	for _, id := range ids {
+		if stream := s.visibleStream(r, id); stream != nil {
+			streams[id] = stream
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(ids) > 0 && len(streams) == 0 {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+
+	// The executions of the action submitted while the stream is open
+	submitted := make(chan string, 100)
+	if action != "" {
+		unsubscribe := s.events.Subscribe(func(event tinpot.ExecutionEvent) {
+			if event.Type != tinpot.EventSubmitted || event.Tenant != tenant || event.Action != action {
+				return
+			}
+			select {
+			case submitted <- event.ExecutionID:
+			default:
+			}
+		})
+		defer unsubscribe()
+		records, _ := s.store.List(visible)
+		for _, rec := range records {
+			if stream := s.stream(rec.ID, tenant); stream != nil {
+				if _, closed, _ := stream.after(math.MaxInt64); !closed {
+					streams[rec.ID] = stream
+				}
+			}
+		}
+	}
+
+	ndjson := query.Get("format") == "ndjson" || acceptsNDJSON(r)
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Connection", "keep-alive")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	write := func(v interface{}) {
+		encoded, _ := json.Marshal(v)
+		if ndjson {
+			fmt.Fprintf(w, "%s\n", encoded)
+		} else {
+			fmt.Fprintf(w, "data: %s\n\n", encoded)
+		}
+		flusher.Flush()
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	out := make(chan MultiplexedEvent)
+	done := make(chan string)
+	following := slices.Sorted(maps.Keys(streams))
+	write(map[string]interface{}{"type": "connected", "execution_ids": following, "missing": missing})
+	for id, stream := range streams {
+		go forwardStream(ctx, id, stream, filter, out, done)
+	}
+	active := len(streams)
+
+	// Submitted executions get their stream right after the event
+	var pending []string
+	var retry <-chan time.Time
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-out:
+			write(event)
+		case <-done:
+			active--
+			if action == "" && active == 0 {
+				write(map[string]string{"type": "end"})
+				return
+			}
+		case id := <-submitted:
+			pending = append(pending, id)
+		case <-retry:
+		case <-heartbeat.C:
+			if !ndjson {
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		pending = slices.DeleteFunc(pending, func(id string) bool {
+			rec, err := s.store.Get(id)
+			if err != nil || !visible.Match(&rec) || streams[id] != nil {
+				return true
+			}
+			stream := s.stream(id, tenant)
+			if stream == nil {
+				// Sync executions without streaming have none
+				return rec.CompletedAt != nil
+			}
+			streams[id] = stream
+			active++
+			go forwardStream(ctx, id, stream, filter, out, done)
+			return true
+		})
+		retry = nil
+		if len(pending) > 0 {
+			retry = time.After(100 * time.Millisecond)
+		}
+	}
+}
+
+// forwardStream sends the filtered events of an execution to out, then its ID to done once
+// its stream is closed
+func forwardStream(ctx context.Context, id string, stream *executionStream, filter logFilter, out chan<- MultiplexedEvent, done chan<- string) {
+	var last int64
+	for buffered := true; ; buffered = false {
+		events, closed, changed := stream.after(last)
+		if len(events) > 0 {
+			last = events[len(events)-1].id
+		}
+		for _, event := range filter.apply(events, buffered) {
+			select {
+			case out <- MultiplexedEvent{ExecutionID: id, ID: event.id, StreamEvent: event.event}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if closed {
+			select {
+			case done <- id:
+			case <-ctx.Done():
+			}
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+// offlineEntry is an execution accepted while the broker was unreachable
+type offlineEntry struct {
+	Record tinpot.ExecutionRecord `json:"record"`
+	// Parameters of the trigger, with the injected execution and trace IDs
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// offlineQueue keeps the entries in a file, so they survive a restart of the coordinator
+type offlineQueue struct {
+	mu      sync.Mutex
+	path    string
+	entries []offlineEntry
+}
+
+func (q *offlineQueue) load() error {
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &q.entries)
+}
+
+// save replaces the file, the caller holds mu
+func (q *offlineQueue) save() error {
+	data, err := json.Marshal(q.entries)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// startOfflineQueue restores the queued executions and dispatches them once connected
+func (s *Server) startOfflineQueue() {
+	s.offline = &offlineQueue{path: s.opts.OfflineQueueFile}
+	if err := s.offline.load(); err != nil {
+		log.Printf("Failed to load the offline queue: %v", err)
+	}
+	for _, entry := range s.offline.entries {
+		if _, err := s.store.Get(entry.Record.ID); err != nil {
+			s.store.Create(entry.Record)
+		}
+		s.registerStream(entry.Record.ID, entry.Record.Tenant)
+	}
+	go func() {
+		for range time.Tick(s.opts.OfflineQueueInterval) {
+			if s.mgr.IsConnected() {
+				s.dispatchOffline()
+			}
+		}
+	}()
+}
+
+// queueOffline accepts an execution while the broker is unreachable
+func (s *Server) queueOffline(w http.ResponseWriter, sub submission) {
+	execID := uuid.New().String()
+	entry := offlineEntry{
+		Record: tinpot.ExecutionRecord{
+			ID:              execID,
+			Action:          sub.action,
+			Tenant:          sub.tenant,
+			Parameters:      sub.parameters,
+			RerunOf:         sub.rerunOf,
+			Origin:          &sub.origin,
+			TraceID:         sub.traceID,
+			Track:           sub.track,
+			Status:          tinpot.StatusQueuedOffline,
+			SubmittedAt:     time.Now(),
+			NotAfter:        sub.notAfter,
+			RequestedBy:     sub.requestedBy,
+			RequesterGroups: sub.requesterGroups,
+		},
+		Parameters: triggerParameters(execID, sub),
+	}
+	if err := s.offline.add(entry, s.opts.OfflineQueueSize); err != nil {
+		writeJSON(w, 503, map[string]string{"detail": fmt.Sprintf("MQTT not connected and the execution can't be queued: %v", err)})
+		return
+	}
+	if err := s.store.Create(entry.Record); err != nil {
+		log.Printf("Failed to record execution %s: %v", execID, err)
+	}
+	s.registerStream(execID, sub.tenant)
+	s.events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventSubmitted, ExecutionID: execID, Action: sub.action, Tenant: sub.tenant, Status: tinpot.StatusQueuedOffline})
+	writeJSON(w, 202, ExecutionResponse{
+		ExecutionID: execID,
+		ActionName:  sub.action,
+		Status:      "queued_offline",
+		StreamURL:   fmt.Sprintf("/api/executions/%s/stream", execID),
+	})
+}
+
+// enqueueOffline queues an execution already recorded, e.g. one held until not_before
+func (s *Server) enqueueOffline(entry offlineEntry) {
+	rec := entry.Record
+	if err := s.offline.add(entry, s.opts.OfflineQueueSize); err != nil {
+		stream := s.stream(rec.ID, rec.Tenant)
+		if stream == nil {
+			stream = s.registerStream(rec.ID, rec.Tenant)
+		}
+		s.failHeld(rec, stream, fmt.Sprintf("MQTT not connected and the execution can't be queued: %v", err))
+		return
+	}
+	s.updateRecord(rec.ID, func(r *tinpot.ExecutionRecord) {
+		r.Status = tinpot.StatusQueuedOffline
+	})
+}
+
+// add appends an entry to the queue and its file
+func (q *offlineQueue) add(entry offlineEntry, size int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) >= size {
+		return errors.New("the offline queue is full")
+	}
+	q.entries = append(q.entries, entry)
+	if err := q.save(); err != nil {
+		q.entries = q.entries[:len(q.entries)-1]
+		return err
+	}
+	return nil
+}
+
+// dispatchOffline triggers the queued executions in their order
+func (s *Server) dispatchOffline() {
+	s.offline.mu.Lock()
+	entries := s.offline.entries
+	if len(entries) == 0 {
+		s.offline.mu.Unlock()
+		return
+	}
+	s.offline.entries = nil
+	if err := s.offline.save(); err != nil {
+		log.Printf("Failed to save the offline queue: %v", err)
+	}
+	s.offline.mu.Unlock()
+
+	log.Printf("Dispatching %d executions queued while offline", len(entries))
+	for _, entry := range entries {
+		s.dispatch(entry.Record, entry.Parameters)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+const (
+	defaultOptionsTTL = time.Minute
+	optionsTimeout    = 30 * time.Second
+)
+
+type cachedOptions struct {
+	values   []interface{}
+	resolved time.Time
+	expires  time.Time
+}
+
+// optionsCache keeps the resolved choices of dynamic enums by source action and parameters
+type optionsCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedOptions
+}
+
+func (c *optionsCache) get(key string) (cachedOptions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok && time.Now().Before(entry.expires)
+}
+
+func (c *optionsCache) put(key string, entry cachedOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+}
+
+func (s *Server) getParameterOptions(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	actionName := r.PathValue("name")
+	paramName := r.PathValue("param")
+	act, ok := s.mgr.ListActions()[tinpot.QualifiedName(tenant, actionName)]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", actionName)})
+		return
+	}
+	param, ok := act.Parameters[paramName]
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Parameter not found: %s", paramName)})
+		return
+	}
+	if param.UI == nil || (param.UI.ChoicesFrom == nil && len(param.UI.Choices) == 0) {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Parameter has no options: %s", paramName)})
+		return
+	}
+	source := param.UI.ChoicesFrom
+	if source == nil {
+		writeJSON(w, 200, ParameterOptionsResponse{Options: param.UI.Choices})
+		return
+	}
+
+	sourceName := tinpot.QualifiedName(tenant, source.Action)
+	sourceParams, _ := json.Marshal(source.Parameters)
+	key := sourceName + " " + string(sourceParams)
+	entry, fresh := s.options.get(key)
+	if !fresh {
+		values, err := s.resolveOptions(r, sourceName, source)
+		if err != nil {
+			writeJSON(w, 502, map[string]string{"detail": fmt.Sprintf("Failed to resolve options from %s: %v", source.Action, err)})
+			return
+		}
+		ttl := defaultOptionsTTL
+		if source.TTL > 0 {
+			ttl = time.Duration(source.TTL) * time.Second
+		}
+		entry = cachedOptions{values: values, resolved: time.Now(), expires: time.Now().Add(ttl)}
+		s.options.put(key, entry)
+	}
+	writeJSON(w, 200, ParameterOptionsResponse{Options: entry.values, Source: source.Action, ResolvedAt: &entry.resolved})
+}
+
+// resolveOptions runs the source action and extracts the list of values from its result
+func (s *Server) resolveOptions(r *http.Request, sourceName string, source *tinpot.ChoiceSource) ([]interface{}, error) {
+	trigger := s.mgr.GetAction(sourceName)
+	if trigger == nil {
+		return nil, fmt.Errorf("action not found")
+	}
+	type reply struct {
+		err    string
+		result map[string]interface{}
+	}
+	done := make(chan reply, 1)
+	params := make(map[string]interface{}, len(source.Parameters))
+	for k, v := range source.Parameters {
+		params[k] = v
+	}
+	trigger(params, func(err string, result map[string]interface{}) {
+		done <- reply{err, result}
+	}, nil)
+
+	select {
+	case res := <-done:
+		if res.err != "" {
+			return nil, fmt.Errorf("%s", res.err)
+		}
+		field := source.Field
+		if field == "" {
+			field = "options"
+		}
+		values, ok := tinpot.NormalizeJSON(res.result[field]).([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("result has no %q list", field)
+		}
+		return values, nil
+	case <-time.After(optionsTimeout):
+		return nil, fmt.Errorf("timed out")
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// pathValues are the path parameters of the routes naming actions, executions, workers and
+// the like, they must be valid names (see tinpot.ValidName) as many of them end up in topics
+var pathValues = []string{"name", "id", "param", "task"}
+
+// validatingMux rejects the requests with an invalid path parameter before its handlers
+type validatingMux struct {
+	*http.ServeMux
+}
+
+func (m validatingMux) HandleFunc(pattern string, handler http.HandlerFunc) {
+	m.ServeMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range pathValues {
+			if value := r.PathValue(name); value != "" && !tinpot.ValidName(value) {
+				writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid %s: %q", name, value)})
+				return
+			}
+		}
+		handler(w, r)
+	})
+}
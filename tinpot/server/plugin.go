@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// Plugin extends the server with routes under /api/plugins/{name}/, e.g. integrations of
+// a deployment. Plugins implementing PluginService also run in the background.
+type Plugin interface {
+	Name() string
+	// Setup mounts the routes of the plugin, called once by NewServer. A plugin failing
+	// its setup is left out.
+	Setup(host *PluginHost) error
+}
+
+// PluginService is implemented by plugins running in the background, see Server.RunPlugins
+type PluginService interface {
+	Run(ctx context.Context) error
+}
+
+// PluginHost gives a plugin access to the server
+type PluginHost struct {
+	Manager tinpot.ActionManager
+	Store   tinpot.ExecutionStore
+	Events  *tinpot.EventBus
+
+	name   string
+	mux    *http.ServeMux
+	server *Server
+}
+
+// Handle mounts a handler below the plugin's path: "GET /status" serves GET
+// /api/plugins/{name}/status. Requests are authenticated like the rest of the API,
+// see TenantFromRequest and IdentityFromRequest.
+func (h *PluginHost) Handle(pattern string, handler http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	} else {
+		method += " "
+	}
+	h.mux.Handle(method+"/api/plugins/"+h.name+"/"+strings.TrimPrefix(path, "/"), handler)
+}
+
+// AddHealthCheck adds a check of the plugin to the readiness probe (/readyz)
+func (h *PluginHost) AddHealthCheck(check HealthCheck) {
+	h.server.healthChecks = append(h.server.healthChecks, check)
+}
+
+// Execute starts an execution of an action of the tenant like POST /api/actions/{name}/execute
+// and returns its id. Logs, progress and the completion are published on Events.
+func (h *PluginHost) Execute(tenant, action string, parameters map[string]interface{}) (string, error) {
+	rec := &responseRecorder{header: make(http.Header)}
+	h.server.submit(rec, submission{
+		tenant:     tenant,
+		action:     action,
+		parameters: parameters,
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginPlugin, ID: h.name},
+	}, false)
+	if rec.status >= 300 {
+		var detail map[string]interface{}
+		json.Unmarshal(rec.body.Bytes(), &detail)
+		return "", fmt.Errorf("%v", detail["detail"])
+	}
+	var execution ExecutionResponse
+	json.Unmarshal(rec.body.Bytes(), &execution)
+	return execution.ExecutionID, nil
+}
+
+// HandleFunc mounts a handler function below the plugin's path, see Handle
+func (h *PluginHost) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	h.Handle(pattern, http.HandlerFunc(handler))
+}
+
+var (
+	pluginsMu  sync.Mutex
+	registered []Plugin
+)
+
+// RegisterPlugin makes a plugin available to RegisteredPlugins, usually from the init
+// function of its package, so a blank import compiles it into the coordinator
+func RegisterPlugin(p Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	registered = append(registered, p)
+}
+
+// RegisteredPlugins returns the plugins registered so far
+func RegisteredPlugins() []Plugin {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	return append([]Plugin(nil), registered...)
+}
+
+func (s *Server) setupPlugins(mux *http.ServeMux) {
+	for _, p := range s.opts.Plugins {
+		host := &PluginHost{Manager: s.mgr, Store: s.store, Events: s.events, name: p.Name(), mux: http.NewServeMux(), server: s}
+		if err := p.Setup(host); err != nil {
+			log.Printf("Plugin %s failed to set up: %v", p.Name(), err)
+			continue
+		}
+		mux.Handle("/api/plugins/"+p.Name()+"/", host.mux)
+		s.plugins = append(s.plugins, p)
+	}
+}
+
+// RunPlugins starts the background services of the plugins, until the context is done
+func (s *Server) RunPlugins(ctx context.Context) {
+	for _, p := range s.plugins {
+		if service, ok := p.(PluginService); ok {
+			go func() {
+				if err := service.Run(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Plugin %s stopped: %v", p.Name(), err)
+				}
+			}()
+		}
+	}
+}
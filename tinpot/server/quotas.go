@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const quotaWindow = time.Hour
+
+type QuotaLimits struct {
+	// Disabled rejects every execution in scope with 403
+	Disabled             bool `json:"disabled,omitempty"`
+	MaxExecutionsPerHour int  `json:"max_executions_per_hour,omitempty"`
+	MaxConcurrent        int  `json:"max_concurrent,omitempty"`
+	// MaxLogBytes caps the log volume of a single execution, further logs are dropped
+	MaxLogBytes int64 `json:"max_log_bytes,omitempty"`
+	// MaxLogBytesPerHour caps the log volume of all the executions in scope within the hourly
+	// window, the executions logging beyond it are truncated like by MaxLogBytes
+	MaxLogBytesPerHour int64 `json:"max_log_bytes_per_hour,omitempty"`
+}
+
+// QuotaConfig is the quota configuration, as JSON:
+//
+//	{
+//	  "tenants": {"team-a": {"max_executions_per_hour": 100, "max_concurrent": 5}},
+//	  "actions": {"team-a/clean_cache": {"max_concurrent": 1, "max_log_bytes": 65536, "max_log_bytes_per_hour": 1048576}}
+//	}
+//
+// Tenants are keyed by name ("" is the default tenant), actions by their qualified name.
+type QuotaConfig struct {
+	Tenants map[string]QuotaLimits `json:"tenants"`
+	Actions map[string]QuotaLimits `json:"actions"`
+}
+
+type QuotaUsage struct {
+	Executions  int       `json:"executions"`
+	Running     int       `json:"running"`
+	LogBytes    int64     `json:"log_bytes"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+type QuotaStatus struct {
+	Limits QuotaLimits `json:"limits"`
+	Usage  QuotaUsage  `json:"usage"`
+}
+
+type quotaError struct {
+	status     int
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+type quotaManager struct {
+	config QuotaConfig
+	mu     sync.Mutex
+	usage  map[string]*QuotaUsage
+}
+
+// LoadQuotaConfig reads a QuotaConfig from a JSON file
+func LoadQuotaConfig(path string) (QuotaConfig, error) {
+	var config QuotaConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+func newQuotaManager(config QuotaConfig) *quotaManager {
+	return &quotaManager{config: config, usage: make(map[string]*QuotaUsage)}
+}
+
+func tenantScope(tenant string) string {
+	return "tenant:" + tenant
+}
+
+func actionScope(qualified string) string {
+	return "action:" + qualified
+}
+
+// scopes returns the usage keys and limits that apply to an execution. Must hold mu.
+func (q *quotaManager) scopes(tenant string, qualified string) ([]*QuotaUsage, []QuotaLimits) {
+	now := time.Now()
+	keys := []string{tenantScope(tenant), actionScope(qualified)}
+	limits := []QuotaLimits{q.config.Tenants[tenant], q.config.Actions[qualified]}
+	usages := make([]*QuotaUsage, len(keys))
+	for i, key := range keys {
+		u := q.usage[key]
+		if u == nil {
+			u = &QuotaUsage{WindowStart: now}
+			q.usage[key] = u
+		}
+		if now.Sub(u.WindowStart) >= quotaWindow {
+			u.Executions = 0
+			u.LogBytes = 0
+			u.WindowStart = now
+		}
+		usages[i] = u
+	}
+	return usages, limits
+}
+
+// acquire admits an execution or explains why it is rejected. The returned
+// function must be called once the execution has completed.
+func (q *quotaManager) acquire(tenant string, qualified string) (func(), error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usages, limits := q.scopes(tenant, qualified)
+	for i, l := range limits {
+		u := usages[i]
+		if l.Disabled {
+			return nil, &quotaError{status: http.StatusForbidden, message: "Executions are disabled by quota"}
+		}
+		if l.MaxConcurrent > 0 && u.Running >= l.MaxConcurrent {
+			return nil, &quotaError{status: http.StatusTooManyRequests, message: fmt.Sprintf("Concurrent execution quota exceeded (%d)", l.MaxConcurrent)}
+		}
+		if l.MaxExecutionsPerHour > 0 && u.Executions >= l.MaxExecutionsPerHour {
+			return nil, &quotaError{
+				status:     http.StatusTooManyRequests,
+				message:    fmt.Sprintf("Hourly execution quota exceeded (%d)", l.MaxExecutionsPerHour),
+				retryAfter: time.Until(u.WindowStart.Add(quotaWindow)),
+			}
+		}
+	}
+
+	for _, u := range usages {
+		u.Executions++
+		u.Running++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			for _, u := range usages {
+				u.Running--
+			}
+		})
+	}, nil
+}
+
+// inFlight is the number of admitted executions that have not completed yet
+func (q *quotaManager) inFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	running := 0
+	for key, u := range q.usage {
+		if strings.HasPrefix(key, "tenant:") {
+			running += u.Running
+		}
+	}
+	return running
+}
+
+// executionLog is the log volume of an execution, accounted by allowLog under mu as the logs
+// arrive concurrently
+type executionLog struct {
+	written   int64
+	truncated bool
+}
+
+// allowLog accounts a log message of size bytes for an execution and reports whether it still
+// fits the log quotas, of the execution and of its tenant and action. Once it doesn't, the further logs of the execution are dropped: only the
+// first refused message reports truncated.
+func (q *quotaManager) allowLog(tenant string, qualified string, log *executionLog, size int) (allowed bool, truncated bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if log.truncated {
+		return false, false
+	}
+	usages, limits := q.scopes(tenant, qualified)
+	for i, l := range limits {
+		if (l.MaxLogBytes > 0 && log.written+int64(size) > l.MaxLogBytes) ||
+			(l.MaxLogBytesPerHour > 0 && usages[i].LogBytes+int64(size) > l.MaxLogBytesPerHour) {
+			log.truncated = true
+			return false, true
+		}
+	}
+	log.written += int64(size)
+	for _, u := range usages {
+		u.LogBytes += int64(size)
+	}
+	return true, false
+}
+
+func (q *quotaManager) status(key string, limits QuotaLimits) QuotaStatus {
+	result := QuotaStatus{Limits: limits}
+	if u := q.usage[key]; u != nil {
+		result.Usage = *u
+		if time.Since(u.WindowStart) >= quotaWindow {
+			result.Usage.Executions = 0
+			result.Usage.LogBytes = 0
+		}
+	}
+	return result
+}
+
+func writeQuotaError(w http.ResponseWriter, err error) {
+	qerr, ok := err.(*quotaError)
+	if !ok {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	if qerr.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(qerr.retryAfter.Seconds())+1))
+	}
+	writeJSON(w, qerr.status, map[string]string{"detail": qerr.message})
+}
+
+// getQuotas reports the quota limits and usage of the caller's tenant and its actions
+func (s *Server) getQuotas(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	quotas := s.quotas
+
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	actions := make(map[string]QuotaStatus)
+	for qualified, act := range s.mgr.ListActions() {
+		if act.Tenant != tenant {
+			continue
+		}
+		actions[act.Name] = quotas.status(actionScope(qualified), quotas.config.Actions[qualified])
+	}
+
+	writeJSON(w, 200, map[string]interface{}{
+		"tenant":  quotas.status(tenantScope(tenant), quotas.config.Tenants[tenant]),
+		"actions": actions,
+	})
+}
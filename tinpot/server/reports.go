@@ -0,0 +1,174 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// UsageRates price the resource usage of executions in the usage reports, e.g. for chargeback
+type UsageRates struct {
+	Execution      float64 `json:"execution,omitempty"`
+	CPUSecond      float64 `json:"cpu_second,omitempty"`
+	DurationSecond float64 `json:"duration_second,omitempty"`
+	// GBSecond is charged for the peak memory over the duration of the execution
+	GBSecond float64 `json:"gb_second,omitempty"`
+}
+
+// ParseUsageRates parses "execution=0.01,cpu_second=0.0002,duration_second=0,gb_second=0.00001"
+func ParseUsageRates(spec string) (UsageRates, error) {
+	var rates UsageRates
+	fields := map[string]*float64{
+		"execution":       &rates.Execution,
+		"cpu_second":      &rates.CPUSecond,
+		"duration_second": &rates.DurationSecond,
+		"gb_second":       &rates.GBSecond,
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(entry, "=")
+		field, ok := fields[name]
+		if !ok {
+			return rates, fmt.Errorf("unknown rate: %q", name)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			return rates, fmt.Errorf("invalid rate of %s: %q", name, value)
+		}
+		*field = rate
+	}
+	return rates, nil
+}
+
+// UsageReportRow aggregates the completed executions of an action, group or tenant
+type UsageReportRow struct {
+	Key             string  `json:"key"`
+	Executions      int     `json:"executions"`
+	Failures        int     `json:"failures"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	// GBSeconds is the peak memory of the executions over their duration
+	GBSeconds float64 `json:"gb_seconds"`
+	// PeakMemoryBytes is the highest peak of the executions
+	PeakMemoryBytes uint64  `json:"peak_memory_bytes"`
+	Cost            float64 `json:"cost"`
+}
+
+func (row *UsageReportRow) add(rec tinpot.ExecutionRecord, rates UsageRates) {
+	usage := tinpot.ResourceUsage{}
+	if rec.Usage != nil {
+		usage = *rec.Usage
+	} else if rec.StartedAt != nil {
+		// Executions not run by a worker only have the coordinator's timestamps
+		usage.DurationSeconds = rec.CompletedAt.Sub(*rec.StartedAt).Seconds()
+	}
+	gbSeconds := float64(usage.PeakMemoryBytes) / (1 << 30) * usage.DurationSeconds
+
+	row.Executions++
+	if rec.Status != tinpot.StatusSuccess {
+		row.Failures++
+	}
+	row.DurationSeconds += usage.DurationSeconds
+	row.CPUSeconds += usage.CPUSeconds
+	row.GBSeconds += gbSeconds
+	row.PeakMemoryBytes = max(row.PeakMemoryBytes, usage.PeakMemoryBytes)
+	row.Cost += rates.Execution + rates.CPUSecond*usage.CPUSeconds + rates.DurationSecond*usage.DurationSeconds + rates.GBSecond*gbSeconds
+}
+
+type UsageReportResponse struct {
+	GroupBy string           `json:"group_by"`
+	Since   time.Time        `json:"since"`
+	Until   time.Time        `json:"until"`
+	Rates   UsageRates       `json:"rates"`
+	Rows    []UsageReportRow `json:"rows"`
+	Total   UsageReportRow   `json:"total"`
+}
+
+// getUsageReport aggregates the usage of the executions completed in a time range (the last 30
+// days by default) by action, group, tenant or origin, most expensive first. Only default tenant
+// admins see all tenants.
+func (s *Server) getUsageReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	report := UsageReportResponse{GroupBy: query.Get("group_by"), Until: time.Now(), Rates: s.opts.UsageRates}
+	if report.GroupBy == "" {
+		report.GroupBy = "action"
+	}
+	if !slices.Contains([]string{"action", "group", "tenant", "origin"}, report.GroupBy) {
+		writeJSON(w, 400, map[string]string{"detail": "group_by must be action, group, tenant or origin"})
+		return
+	}
+	for param, target := range map[string]*time.Time{"since": &report.Since, "until": &report.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid %s: %v", param, err)})
+				return
+			}
+			*target = t
+		}
+	}
+	if report.Since.IsZero() {
+		report.Since = report.Until.AddDate(0, 0, -30)
+	}
+
+	filter := tinpot.ExecutionFilter{Tenant: TenantFromRequest(r), Archived: tinpot.IncludeArchived}
+	if query.Get("all_tenants") == "true" {
+		if id := IdentityFromRequest(r); id != nil && (id.Tenant != "" || !id.HasRole(RoleAdmin)) {
+			writeJSON(w, 403, map[string]string{"detail": "all_tenants requires an admin of the default tenant"})
+			return
+		}
+		filter.AllTenants = true
+	}
+	records, err := s.store.List(filter)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	actions := s.mgr.ListActions()
+	rows := make(map[string]*UsageReportRow)
+	for _, rec := range records {
+		if rec.CompletedAt == nil || rec.CompletedAt.Before(report.Since) || !rec.CompletedAt.Before(report.Until) {
+			continue
+		}
+		var key string
+		switch report.GroupBy {
+		case "action":
+			key = tinpot.QualifiedName(rec.Tenant, rec.Action)
+		case "group":
+			key = tinpot.QualifiedName(rec.Tenant, actions[tinpot.QualifiedName(rec.Tenant, rec.Action)].Group)
+		case "tenant":
+			key = rec.Tenant
+		case "origin":
+			// Executions recorded before origins were tracked are under ""
+			if rec.Origin != nil {
+				key = rec.Origin.Type
+			}
+		}
+		row := rows[key]
+		if row == nil {
+			row = &UsageReportRow{Key: key}
+			rows[key] = row
+		}
+		row.add(rec, report.Rates)
+		report.Total.add(rec, report.Rates)
+	}
+
+	report.Rows = []UsageReportRow{}
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	slices.SortFunc(report.Rows, func(a, b UsageReportRow) int {
+		return cmp.Or(cmp.Compare(b.Cost, a.Cost), cmp.Compare(b.CPUSeconds, a.CPUSeconds), cmp.Compare(b.DurationSeconds, a.DurationSeconds), strings.Compare(a.Key, b.Key))
+	})
+	writeJSON(w, 200, report)
+}
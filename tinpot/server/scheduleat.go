@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// heldActionWait is how long the executions held across a restart wait for the workers to
+// announce their action once due
+const heldActionWait = time.Minute
+
+// heldExecution is an execution waiting for its not_before
+type heldExecution struct {
+	entry offlineEntry
+	timer *time.Timer
+}
+
+// POST /api/actions/{name}/schedule_at runs the action once at the given time, like execute
+// with not_before. The execution is kept in SchedulesFile until then and can be cancelled.
+func (s *Server) scheduleAt(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleAtRequest
+	if err := s.decodeBody(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !req.At.After(time.Now()) {
+		writeJSON(w, 400, map[string]string{"detail": "at must be in the future"})
+		return
+	}
+	req.NotBefore = &req.At
+	s.execute(w, r, req.ExecuteActionRequest, false)
+}
+
+// holdEntry dispatches the execution at its not_before. Restored ones, held across a restart,
+// wait for their action to be announced.
+func (s *Server) holdEntry(entry offlineEntry, restored bool) {
+	s.heldMu.Lock()
+	defer s.heldMu.Unlock()
+	held := &heldExecution{entry: entry}
+	s.held[entry.Record.ID] = held
+	held.timer = time.AfterFunc(time.Until(*entry.Record.NotBefore), func() {
+		s.heldMu.Lock()
+		current := s.held[entry.Record.ID]
+		delete(s.held, entry.Record.ID)
+		s.heldMu.Unlock()
+		if current != held {
+			// Cancelled
+			return
+		}
+		s.saveSchedules()
+		if restored {
+			deadline := time.Now().Add(heldActionWait)
+			for s.mgr.GetAction(tinpot.QualifiedName(entry.Record.Tenant, entry.Record.Action)) == nil && time.Now().Before(deadline) {
+				time.Sleep(time.Second)
+			}
+		}
+		// The broker may be gone by then
+		if s.offline != nil && !s.mgr.IsConnected() {
+			s.enqueueOffline(entry)
+			return
+		}
+		s.dispatch(entry.Record, entry.Parameters)
+	})
+}
+
+// cancelHeld ends the execution as cancelled if it is still held, reporting whether it was
+func (s *Server) cancelHeld(rec tinpot.ExecutionRecord) bool {
+	s.heldMu.Lock()
+	held := s.held[rec.ID]
+	if held != nil {
+		held.timer.Stop()
+		delete(s.held, rec.ID)
+	}
+	s.heldMu.Unlock()
+	if held == nil {
+		return false
+	}
+	s.saveSchedules()
+	stream := s.stream(rec.ID, rec.Tenant)
+	if stream == nil {
+		stream = s.registerStream(rec.ID, rec.Tenant)
+	}
+	s.failHeld(held.entry.Record, stream, tinpot.CancelledError)
+	return true
+}
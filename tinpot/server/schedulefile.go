@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// savedSchedule is a schedule in Options.SchedulesFile
+type savedSchedule struct {
+	Tenant   string          `json:"tenant,omitempty"`
+	ID       string          `json:"id"`
+	Schedule ScheduleRequest `json:"schedule"`
+	// Due is when the schedule was last due, to tell the runs missed until the restart
+	Due           time.Time    `json:"due"`
+	LastRun       *ScheduleRun `json:"last_run,omitempty"`
+	LastExecution string       `json:"last_execution,omitempty"`
+}
+
+// schedulesFile is the content of Options.SchedulesFile
+type schedulesFile struct {
+	Schedules []savedSchedule `json:"schedules"`
+	// Held are the executions waiting for their not_before, e.g. from schedule_at
+	Held []offlineEntry `json:"held"`
+}
+
+// loadSchedules restores the schedules and the held executions of the file and starts them.
+// Their actions are not checked, the workers announce them later.
+func (s *Server) loadSchedules() {
+	data, err := os.ReadFile(s.opts.SchedulesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var saved schedulesFile
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil {
+		log.Printf("Failed to load the schedules: %v", err)
+		return
+	}
+	for _, entry := range saved.Held {
+		if entry.Record.NotBefore == nil {
+			continue
+		}
+		if _, err := s.store.Get(entry.Record.ID); err != nil {
+			s.store.Create(entry.Record)
+		}
+		s.registerStream(entry.Record.ID, entry.Record.Tenant)
+		s.holdEntry(entry, true)
+	}
+	s.schedulesMu.Lock()
+	for _, entry := range saved.Schedules {
+		job, err := compileSchedule(entry.Tenant, entry.Schedule)
+		if err != nil {
+			log.Printf("Failed to load schedule %s: %v", entry.ID, err)
+			continue
+		}
+		job.lastRun, job.lastExecution = entry.LastRun, entry.LastExecution
+		missed := !entry.Due.IsZero() && job.cron.Next(entry.Due).Before(job.due)
+		if missed {
+			log.Printf("Schedule %s missed runs while the coordinator was down", entry.ID)
+		}
+		s.schedules[tinpot.QualifiedName(entry.Tenant, entry.ID)] = job
+		go s.runSchedule(entry.ID, job, missed && entry.Schedule.CatchUp == CatchUpOnce && !entry.Schedule.Paused)
+	}
+	s.schedulesMu.Unlock()
+	// The missed runs are handled, a restart doesn't catch up on them again
+	s.saveSchedules()
+}
+
+// saveSchedules replaces the file with the current schedules and held executions, if there is one
+func (s *Server) saveSchedules() {
+	if s.opts.SchedulesFile == "" {
+		return
+	}
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	saved := schedulesFile{Schedules: []savedSchedule{}, Held: []offlineEntry{}}
+	s.heldMu.Lock()
+	for _, held := range s.held {
+		saved.Held = append(saved.Held, held.entry)
+	}
+	s.heldMu.Unlock()
+	for key, job := range s.schedules {
+		tenant, id := tinpot.SplitQualifiedName(key)
+		job.mu.Lock()
+		saved.Schedules = append(saved.Schedules, savedSchedule{Tenant: tenant, ID: id, Schedule: job.schedule, Due: job.due, LastRun: job.lastRun, LastExecution: job.lastExecution})
+		job.mu.Unlock()
+	}
+	data, err := json.Marshal(saved)
+	if err == nil {
+		tmp := s.opts.SchedulesFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, s.opts.SchedulesFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save the schedules: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduledJob runs a schedule until it is stopped
+type scheduledJob struct {
+	schedule ScheduleRequest
+	tenant   string
+	cron     cron.Schedule
+	location *time.Location
+	jitter   time.Duration
+	stop     chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+	// due is when the schedule was last due, run or not
+	due     time.Time
+	lastRun *ScheduleRun
+	// lastExecution is the latest execution started, for the overlap policy
+	lastExecution string
+	// queued counts the runs due while the previous execution was running, with
+	// OverlapQueue, draining is set while drainQueue runs them
+	queued   int
+	draining bool
+}
+
+// compileSchedule checks the schedule and returns its job, not started yet
+func compileSchedule(tenant string, req ScheduleRequest) (*scheduledJob, error) {
+	// The action is qualified with the tenant of the schedule, it can't name another one's
+	if !tinpot.ValidName(req.Action) {
+		return nil, fmt.Errorf("Invalid action: %q", req.Action)
+	}
+	location, expression := time.Local, req.Cron
+	if req.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(req.Timezone); err != nil {
+			return nil, fmt.Errorf("Invalid timezone %q: %v", req.Timezone, err)
+		}
+		expression = "CRON_TZ=" + req.Timezone + " " + req.Cron
+	}
+	spec, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cron expression: %v", err)
+	}
+	job := &scheduledJob{schedule: req, tenant: tenant, cron: spec, location: location, due: time.Now(), stop: make(chan struct{})}
+	if req.Jitter != "" {
+		if job.jitter, err = time.ParseDuration(req.Jitter); err != nil || job.jitter < 0 {
+			return nil, fmt.Errorf("Invalid jitter: %q", req.Jitter)
+		}
+	}
+	switch req.CatchUp {
+	case "", CatchUpSkip, CatchUpOnce:
+	default:
+		return nil, fmt.Errorf("Invalid catch_up %q, expected skip or once", req.CatchUp)
+	}
+	switch req.Overlap {
+	case "", OverlapAllow, OverlapSkip, OverlapQueue:
+	default:
+		return nil, fmt.Errorf("Invalid overlap %q, expected allow, skip or queue", req.Overlap)
+	}
+	return job, nil
+}
+
+// PUT /api/schedules/{id} creates or replaces a schedule; an unchanged one keeps its timer
+func (s *Server) putSchedule(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	id := r.PathValue("id")
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	if strings.Contains(id, "/") {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid schedule id"})
+		return
+	}
+	compiled, err := compileSchedule(tenant, req)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+	if _, ok := s.mgr.ListActions()[tinpot.QualifiedName(tenant, req.Action)]; !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Action not found: %s", req.Action)})
+		return
+	}
+
+	key := tinpot.QualifiedName(tenant, id)
+	s.schedulesMu.Lock()
+	job, exists := s.schedules[key]
+	if !exists || !reflect.DeepEqual(job.schedule, req) {
+		if exists {
+			close(job.stop)
+			compiled.lastRun, compiled.lastExecution = job.lastRun, job.lastExecution
+		}
+		job = compiled
+		s.schedules[key] = job
+		go s.runSchedule(id, job, false)
+	}
+	s.schedulesMu.Unlock()
+	s.saveSchedules()
+
+	status := 200
+	if !exists {
+		status = 201
+	}
+	writeJSON(w, status, s.scheduleResponse(id, job))
+}
+
+func (s *Server) listSchedules(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	s.schedulesMu.Lock()
+	resp := []ScheduleResponse{}
+	for key, job := range s.schedules {
+		if job.tenant == tenant {
+			_, id := tinpot.SplitQualifiedName(key)
+			resp = append(resp, s.scheduleResponse(id, job))
+		}
+	}
+	s.schedulesMu.Unlock()
+	sort.Slice(resp, func(i, j int) bool { return resp[i].ID < resp[j].ID })
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) getSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.schedulesMu.Lock()
+	job := s.schedules[tinpot.QualifiedName(TenantFromRequest(r), id)]
+	s.schedulesMu.Unlock()
+	if job == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Schedule not found: %s", id)})
+		return
+	}
+	writeJSON(w, 200, s.scheduleResponse(id, job))
+}
+
+// maxSchedulePreview caps the count of GET /api/schedules/{id}/next
+const maxSchedulePreview = 100
+
+// GET /api/schedules/{id}/next?count=5 previews the next runs of a schedule, to verify its
+// expression and timezone
+func (s *Server) previewSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.schedulesMu.Lock()
+	job := s.schedules[tinpot.QualifiedName(TenantFromRequest(r), id)]
+	s.schedulesMu.Unlock()
+	if job == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Schedule not found: %s", id)})
+		return
+	}
+	count := 5
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxSchedulePreview {
+			writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("count must be between 1 and %d", maxSchedulePreview)})
+			return
+		}
+	}
+	resp := ScheduleNextRunsResponse{ID: id, Timezone: job.schedule.Timezone, NextRuns: []time.Time{}}
+	for at := time.Now(); len(resp.NextRuns) < count; {
+		// Zero when the expression never matches, e.g. on February 30
+		if at = job.cron.Next(at); at.IsZero() {
+			break
+		}
+		resp.NextRuns = append(resp.NextRuns, at.In(job.location))
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	key := tinpot.QualifiedName(TenantFromRequest(r), id)
+	s.schedulesMu.Lock()
+	job := s.schedules[key]
+	delete(s.schedules, key)
+	s.schedulesMu.Unlock()
+	if job == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Schedule not found: %s", id)})
+		return
+	}
+	close(job.stop)
+	s.saveSchedules()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scheduleResponse reports the schedule with the record of its latest execution
+func (s *Server) scheduleResponse(id string, job *scheduledJob) ScheduleResponse {
+	job.mu.Lock()
+	resp := ScheduleResponse{ScheduleRequest: job.schedule, ID: id, LastRun: job.lastRun, Queued: job.queued}
+	if !job.next.IsZero() && !job.schedule.Paused {
+		next := job.next.In(job.location)
+		resp.NextRun = &next
+	}
+	job.mu.Unlock()
+	if resp.LastRun != nil && resp.LastRun.ExecutionID != "" {
+		if rec, err := s.record(resp.LastRun.ExecutionID, job.tenant); err == nil {
+			resp.LastExecution = &rec
+		}
+	}
+	return resp
+}
+
+// runSchedule runs the job at its times until it is stopped, first catching up on the runs
+// missed while the coordinator was down if asked to
+func (s *Server) runSchedule(id string, job *scheduledJob, catchUp bool) {
+	if catchUp {
+		s.catchUp(id, job)
+	}
+	for {
+		due := job.cron.Next(time.Now())
+		next := due
+		if job.jitter > 0 {
+			next = next.Add(rand.N(job.jitter))
+		}
+		job.mu.Lock()
+		job.next = next
+		job.mu.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-job.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		job.mu.Lock()
+		job.due = due
+		job.mu.Unlock()
+		if !job.schedule.Paused {
+			s.runScheduled(id, job, false)
+		}
+		s.saveSchedules()
+	}
+}
+
+// catchUp runs the job once as soon as its action is known (the workers announce it after a
+// restart), unless its next run comes first
+func (s *Server) catchUp(id string, job *scheduledJob) {
+	next := job.cron.Next(time.Now())
+	for s.mgr.GetAction(tinpot.QualifiedName(job.tenant, job.schedule.Action)) == nil {
+		if time.Now().After(next) {
+			return
+		}
+		select {
+		case <-job.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	s.runScheduled(id, job, true)
+	s.saveSchedules()
+}
+
+// running returns the previous execution of the job if it is still running
+func (s *Server) running(job *scheduledJob) string {
+	job.mu.Lock()
+	last := job.lastExecution
+	job.mu.Unlock()
+	if last == "" {
+		return ""
+	}
+	if rec, err := s.record(last, job.tenant); err == nil && !rec.Done() {
+		return rec.ID
+	}
+	return ""
+}
+
+// waitCompletion returns once the execution completed, false if the job was stopped first
+func (s *Server) waitCompletion(execID string, job *scheduledJob) bool {
+	completed := make(chan struct{}, 1)
+	unsubscribe := s.events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type == tinpot.EventCompleted && event.ExecutionID == execID {
+			select {
+			case completed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer unsubscribe()
+	if rec, err := s.record(execID, job.tenant); err != nil || rec.Done() {
+		return true
+	}
+	select {
+	case <-completed:
+		return true
+	case <-job.stop:
+		return false
+	}
+}
+
+// runScheduled submits an execution like the execute endpoint does, applying the overlap
+// policy of the schedule
+func (s *Server) runScheduled(id string, job *scheduledJob, catchUp bool) {
+	switch job.schedule.Overlap {
+	case OverlapSkip:
+		if previous := s.running(job); previous != "" {
+			job.mu.Lock()
+			job.lastRun = &ScheduleRun{At: time.Now(), Skipped: fmt.Sprintf("Execution %s is still running", previous), CatchUp: catchUp}
+			job.mu.Unlock()
+			return
+		}
+	case OverlapQueue:
+		if s.queue(id, job) {
+			return
+		}
+	}
+	s.startScheduled(id, job, catchUp)
+}
+
+// queue defers the run while the previous execution of the job is running or other runs
+// are waiting, so the timer of the schedule is not held up
+func (s *Server) queue(id string, job *scheduledJob) bool {
+	previous := s.running(job)
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if previous == "" && !job.draining {
+		return false
+	}
+	job.queued++
+	if !job.draining {
+		job.draining = true
+		go s.drainQueue(id, job, previous)
+	}
+	return true
+}
+
+// drainQueue runs the queued runs of the job one after the other, each once the previous
+// execution completed
+func (s *Server) drainQueue(id string, job *scheduledJob, previous string) {
+	for {
+		if previous != "" && !s.waitCompletion(previous, job) {
+			return
+		}
+		job.mu.Lock()
+		if job.queued == 0 {
+			job.draining = false
+			job.mu.Unlock()
+			return
+		}
+		job.queued--
+		job.mu.Unlock()
+		previous = s.startScheduled(id, job, false)
+		s.saveSchedules()
+	}
+}
+
+// startScheduled submits the execution of a run and records it, returning its ID if it
+// started
+func (s *Server) startScheduled(id string, job *scheduledJob, catchUp bool) string {
+	rec := &responseRecorder{header: make(http.Header)}
+	s.submit(rec, submission{
+		tenant:     job.tenant,
+		action:     job.schedule.Action,
+		parameters: job.schedule.Parameters,
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginSchedule, ID: id},
+	}, false)
+
+	run := &ScheduleRun{At: time.Now(), CatchUp: catchUp}
+	var resp ExecutionResponse
+	if rec.status >= 300 || json.Unmarshal(rec.body.Bytes(), &resp) != nil {
+		var failure map[string]interface{}
+		json.Unmarshal(rec.body.Bytes(), &failure)
+		run.Error = fmt.Sprintf("%d %v", rec.status, failure["detail"])
+		log.Printf("Schedule %s failed to run %s: %s", id, job.schedule.Action, run.Error)
+	} else {
+		run.ExecutionID = resp.ExecutionID
+	}
+	job.mu.Lock()
+	job.lastRun = run
+	if run.ExecutionID != "" {
+		job.lastExecution = run.ExecutionID
+	}
+	job.mu.Unlock()
+	return run.ExecutionID
+}
+
+// responseRecorder collects the response of a handler called outside of a request
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	return r.body.Write(data)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
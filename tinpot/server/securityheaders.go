@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// DefaultContentSecurityPolicy only lets the pages run their own scripts, the ones carrying
+// the nonce of the request. Styles may be inline, images may come from anywhere over HTTPS
+// (e.g. the logo).
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: https:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityHeaders are set on the responses by Handler, empty ones are left out
+type SecurityHeaders struct {
+	// ContentSecurityPolicy, "{nonce}" is replaced by the nonce of the request, see CSPNonce
+	ContentSecurityPolicy string
+	// FrameOptions is the X-Frame-Options header, e.g. DENY or SAMEORIGIN
+	FrameOptions string
+	// StrictTransportSecurity, e.g. "max-age=31536000; includeSubDomains". Only for
+	// coordinators reached over HTTPS.
+	StrictTransportSecurity string
+	ReferrerPolicy          string
+	// Embeddable are the paths other sites may frame: they get no X-Frame-Options and no
+	// frame-ancestors directive
+	Embeddable []string
+}
+
+// DefaultSecurityHeaders forbid framing and foreign scripts
+var DefaultSecurityHeaders = SecurityHeaders{
+	ContentSecurityPolicy: DefaultContentSecurityPolicy,
+	FrameOptions:          "DENY",
+	ReferrerPolicy:        "same-origin",
+}
+
+type nonceContextKey struct{}
+
+// CSPNonce returns the nonce the inline scripts of a page need, empty when the policy
+// doesn't use one
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceContextKey{}).(string)
+	return nonce
+}
+
+// Handler sets the headers on every response of next, X-Content-Type-Options: nosniff too
+func (h SecurityHeaders) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		embeddable := slices.Contains(h.Embeddable, r.URL.Path)
+		if policy := h.ContentSecurityPolicy; policy != "" {
+			if embeddable {
+				policy = withoutDirective(policy, "frame-ancestors")
+			}
+			if strings.Contains(policy, "{nonce}") {
+				nonce := randomKey()[:32]
+				policy = strings.ReplaceAll(policy, "{nonce}", nonce)
+				r = r.WithContext(context.WithValue(r.Context(), nonceContextKey{}, nonce))
+			}
+			header.Set("Content-Security-Policy", policy)
+		}
+		if h.FrameOptions != "" && !embeddable {
+			header.Set("X-Frame-Options", h.FrameOptions)
+		}
+		if h.StrictTransportSecurity != "" {
+			header.Set("Strict-Transport-Security", h.StrictTransportSecurity)
+		}
+		if h.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", h.ReferrerPolicy)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withoutDirective(policy, name string) string {
+	directives := strings.Split(policy, ";")
+	directives = slices.DeleteFunc(directives, func(directive string) bool {
+		return strings.HasPrefix(strings.TrimSpace(directive), name)
+	})
+	return strings.Join(directives, ";")
+}
@@ -0,0 +1,365 @@
+// Package server implements the tinpot HTTP API on top of an ActionManager,
+// so it can be mounted in any Go service.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/replay"
+)
+
+type Options struct {
+	// Tokens binds API tokens to tenants, see ParseAPITokens. Empty leaves the API open.
+	Tokens map[string]string
+	// AuthProviders authenticate the callers not holding a static token, e.g. with OIDC or LDAP
+	AuthProviders []AuthProvider
+	// SessionTTL is how long a login lasts (default 12 hours)
+	SessionTTL time.Duration
+	Quotas     QuotaConfig
+	// Events receives the execution events, a private bus is created when nil
+	Events *tinpot.EventBus
+	// StreamRetention is how long the log stream of a finished execution stays readable (default 1 minute)
+	StreamRetention time.Duration
+	// StreamBufferSize is the number of recent events kept per execution stream (default 1000),
+	// older ones are dropped
+	StreamBufferSize int
+	// StreamBufferAge drops the events older than it from the stream buffers, 0 keeps them
+	StreamBufferAge time.Duration
+	// TelemetrySamples is the number of recent telemetry samples kept per worker (default 120)
+	TelemetrySamples int
+	// MinWorkers is the number of connected workers the readiness check requires, 0 skips the check
+	MinWorkers int
+	// SchedulesFile keeps the schedules and the held executions (not_before, schedule_at) in
+	// this file, so they survive a restart and can catch up on the runs missed meanwhile, see
+	// ScheduleRequest.CatchUp
+	SchedulesFile string
+	// OfflineQueueFile enables queueing: executions requested while the broker is
+	// unreachable are kept in this file and dispatched once connected
+	OfflineQueueFile string
+	// OfflineQueueSize is the maximum number of queued executions (default 1000)
+	OfflineQueueSize int
+	// OfflineQueueInterval is how often the connection is checked for dispatching (default 1 second)
+	OfflineQueueInterval time.Duration
+	// Alertmanager routes the alerts of Alertmanager webhooks to actions, see AlertmanagerConfig
+	Alertmanager AlertmanagerConfig
+	// Slack enables the slash command endpoint, see SlackConfig
+	Slack SlackConfig
+	// GitWebhookSecret enables the push webhook of the actions repository, /api/integrations/git
+	GitWebhookSecret string
+	// Workflows are the predefined workflows by qualified name, see LoadWorkflows
+	Workflows map[string]Workflow
+	// Maintenance restricts when the actions of some groups run, see MaintenanceConfig
+	Maintenance MaintenanceConfig
+	// HealthChecks are added to the readiness check, e.g. by plugins
+	HealthChecks []HealthCheck
+	// UI configures the web interface, see UIConfig
+	UI UIConfig
+	// Plugins mounted under /api/plugins/{name}/, e.g. RegisteredPlugins()
+	Plugins []Plugin
+	// LoadShedding rejects low priority executions under load, see SheddingPolicy
+	LoadShedding SheddingPolicy
+	// RequestLimits cap the size of the execute requests, see RequestLimits
+	RequestLimits RequestLimits
+	// Visibility limits the executions the callers see to their own ones, see VisibilityPolicy
+	Visibility VisibilityPolicy
+	// UsageRates price the executions in /api/reports/usage
+	UsageRates UsageRates
+	// AlertRules watch the outcomes of actions, see AlertRules
+	AlertRules AlertRules
+	// CanaryTraffic is the share of the executions sent to the canary versions of the actions
+	CanaryTraffic CanaryTraffic
+	// Mock answers executions with canned results instead of the workers, see MockConfig
+	Mock MockConfig
+	// RecordDir enables recording the executions to files, see replay.Recorder
+	RecordDir string
+	// RecordRedact are the names of the secrets removed from the recordings, replay.DefaultRedact when nil
+	RecordRedact []string
+}
+
+// Server serves the /api/ endpoints, /health and the /auth/ login flows
+type Server struct {
+	mgr     tinpot.ActionManager
+	aliases *tinpot.AliasManager
+	mock    *mockManager
+	store   tinpot.ExecutionStore
+	opts    Options
+	events  *tinpot.EventBus
+	quotas  *quotaManager
+	handler http.Handler
+
+	streamsMu sync.RWMutex
+	streams   map[string]*executionStream
+
+	telemetryMu sync.RWMutex
+	telemetry   map[string][]tinpot.MqttWorkerTelemetry // by qualified worker id
+	loadErrors  map[string][]tinpot.MqttLoadError       // by qualified worker id
+	online      map[string]bool                         // connected workers by qualified id
+
+	heldMu      sync.Mutex
+	held        map[string]*heldExecution // executions waiting for their not_before, by id
+	schedulesMu sync.Mutex
+	schedules   map[string]*scheduledJob // by qualified id
+
+	workflowsMu  sync.Mutex
+	workflows    map[string]Workflow     // by qualified name
+	workflowRuns map[string]*workflowRun // by run id
+
+	offline     *offlineQueue                   // nil without OfflineQueueFile
+	alerts      *alerter                        // nil without AlertRules
+	calendars   map[string]*maintenanceCalendar // by qualified group
+	alertRoutes []alertRoute
+
+	derivedMu sync.Mutex
+	derived   map[string]bool // qualified names of the actions created with /derive
+
+	options optionsCache
+
+	sessions *sessionStore
+
+	debouncer debouncer
+
+	plugins      []Plugin // the ones set up successfully
+	healthChecks []HealthCheck
+}
+
+func NewServer(mgr tinpot.ActionManager, store tinpot.ExecutionStore, opts Options) *Server {
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+	if opts.Events == nil {
+		opts.Events = tinpot.NewEventBus()
+	}
+	if opts.StreamRetention == 0 {
+		opts.StreamRetention = time.Minute
+	}
+	if opts.StreamBufferSize == 0 {
+		opts.StreamBufferSize = 1000
+	}
+	if opts.SessionTTL == 0 {
+		opts.SessionTTL = 12 * time.Hour
+	}
+	if opts.TelemetrySamples == 0 {
+		opts.TelemetrySamples = 120
+	}
+	if opts.RequestLimits.MaxBodyBytes == 0 {
+		opts.RequestLimits.MaxBodyBytes = 1 << 20
+	}
+	if opts.RequestLimits.MaxParameters == 0 {
+		opts.RequestLimits.MaxParameters = 256
+	}
+	if opts.RequestLimits.MaxDepth == 0 {
+		opts.RequestLimits.MaxDepth = 32
+	}
+	if opts.Visibility.Role == "" {
+		opts.Visibility.Role = RoleAdmin
+	}
+	if opts.OfflineQueueSize == 0 {
+		opts.OfflineQueueSize = 1000
+	}
+	if opts.OfflineQueueInterval == 0 {
+		opts.OfflineQueueInterval = time.Second
+	}
+	// Derived actions are aliases, reuse the manager's when it has them
+	aliases, ok := mgr.(*tinpot.AliasManager)
+	if !ok {
+		aliases = tinpot.NewAliasManager(mgr, nil)
+	}
+	var recorded tinpot.ActionManager = aliases
+	if opts.RecordDir != "" {
+		recorded = replay.NewRecorder(aliases, opts.RecordDir, replay.RecorderOptions{Redact: opts.RecordRedact})
+	}
+	mock := &mockManager{ActionManager: recorded, config: opts.Mock}
+	s := &Server{
+		mgr:     mock,
+		aliases: aliases,
+		mock:    mock,
+		store:   store,
+		opts:    opts,
+		events:  opts.Events,
+		quotas:  newQuotaManager(opts.Quotas),
+		streams: make(map[string]*executionStream),
+
+		telemetry:  make(map[string][]tinpot.MqttWorkerTelemetry),
+		loadErrors: make(map[string][]tinpot.MqttLoadError),
+		online:     make(map[string]bool),
+		derived:    make(map[string]bool),
+		schedules:  make(map[string]*scheduledJob),
+		held:       make(map[string]*heldExecution),
+		workflows:  make(map[string]Workflow),
+		calendars:  make(map[string]*maintenanceCalendar),
+		options:    optionsCache{entries: make(map[string]cachedOptions)},
+		sessions:   &sessionStore{ttl: opts.SessionTTL, sessions: make(map[string]*session)},
+		debouncer:  debouncer{pending: make(map[string]*debouncedExecution), running: make(map[string]*debouncedExecution)},
+
+		workflowRuns: make(map[string]*workflowRun),
+
+		healthChecks: append([]HealthCheck(nil), opts.HealthChecks...),
+	}
+	s.events.Subscribe(s.recordTelemetry)
+	s.events.Subscribe(s.recordLoadErrors)
+	s.events.Subscribe(s.recordPresence)
+	s.events.Subscribe(s.debouncer.completed)
+	if opts.SchedulesFile != "" {
+		s.loadSchedules()
+	}
+	if opts.OfflineQueueFile != "" {
+		s.startOfflineQueue()
+	}
+	if rules, err := opts.AlertRules.compile(); err != nil {
+		log.Printf("Invalid alert rules: %v", err)
+	} else if len(rules) > 0 {
+		s.startAlerts(rules)
+	}
+	if routes, err := opts.Alertmanager.compile(); err != nil {
+		log.Printf("Invalid Alertmanager routes: %v", err)
+	} else {
+		s.alertRoutes = routes
+	}
+	for name, wf := range opts.Workflows {
+		s.workflows[name] = wf
+	}
+	for group, calendar := range opts.Maintenance {
+		compiled, err := calendar.compile()
+		if err != nil {
+			log.Printf("Invalid maintenance calendar of %s: %v", group, err)
+			continue
+		}
+		s.calendars[group] = compiled
+	}
+
+	mux := validatingMux{http.NewServeMux()}
+	mux.HandleFunc("GET /api/actions", s.listActions)
+	mux.HandleFunc("GET /api/actions/{name}/docs", s.getActionDocs)
+	mux.HandleFunc("GET /api/actions/{name}/schema", s.getActionSchema)
+	mux.HandleFunc("GET /api/actions/{name}/diff", s.diffResults)
+	mux.HandleFunc("GET /api/actions/{name}/canary", s.getCanaryReport)
+	mux.HandleFunc("GET /api/actions/{name}/parameters/{param}/options", s.getParameterOptions)
+	mux.HandleFunc("POST /api/actions/{name}/derive", s.deriveAction)
+	mux.HandleFunc("DELETE /api/actions/{name}", s.deleteDerivedAction)
+	mux.HandleFunc("POST /api/actions/{name}/execute", func(w http.ResponseWriter, r *http.Request) {
+		s.executeAction(w, r, false)
+	})
+	mux.HandleFunc("POST /api/actions/{name}/sync_execute", func(w http.ResponseWriter, r *http.Request) {
+		s.executeAction(w, r, true)
+	})
+	mux.HandleFunc("POST /api/actions/{name}/schedule_at", s.scheduleAt)
+	mux.HandleFunc("GET /api/executions", s.listExecutions)
+	mux.HandleFunc("POST /api/executions/archive", s.archiveExecutions)
+	mux.HandleFunc("GET /api/executions/{id}", s.getExecution)
+	mux.HandleFunc("GET /api/executions/{id}/stream", s.streamLogs)
+	mux.HandleFunc("GET /api/executions/{id}/events", s.pollEvents)
+	mux.HandleFunc("GET /api/executions/{id}/status", s.getStatus)
+	mux.HandleFunc("GET /api/executions/{id}/timeline", s.getTimeline)
+	mux.HandleFunc("GET /api/executions/{id}/scratch", s.getScratch)
+	mux.HandleFunc("POST /api/executions/{id}/rerun", s.rerunExecution)
+	mux.HandleFunc("GET /api/executions/{id}/comments", s.listComments)
+	mux.HandleFunc("POST /api/executions/{id}/comments", s.addComment)
+	mux.HandleFunc("POST /api/executions/{id}/archive", func(w http.ResponseWriter, r *http.Request) {
+		s.setArchived(w, r, true)
+	})
+	mux.HandleFunc("POST /api/executions/{id}/unarchive", func(w http.ResponseWriter, r *http.Request) {
+		s.setArchived(w, r, false)
+	})
+	mux.HandleFunc("POST /api/executions/{id}/cancel", s.cancelAction)
+	mux.HandleFunc("GET /api/maintenance", s.listMaintenance)
+	mux.HandleFunc("GET /api/schedules", s.listSchedules)
+	mux.HandleFunc("GET /api/schedules/{id}", s.getSchedule)
+	mux.HandleFunc("GET /api/schedules/{id}/next", s.previewSchedule)
+	mux.HandleFunc("PUT /api/schedules/{id}", s.putSchedule)
+	mux.HandleFunc("DELETE /api/schedules/{id}", s.deleteSchedule)
+	mux.HandleFunc("GET /api/workflows", s.listWorkflows)
+	mux.HandleFunc("POST /api/workflows/validate", s.validateWorkflowDefinition)
+	mux.HandleFunc("GET /api/workflows/{name}", s.getWorkflow)
+	mux.HandleFunc("PUT /api/workflows/{name}", s.putWorkflow)
+	mux.HandleFunc("DELETE /api/workflows/{name}", s.deleteWorkflow)
+	mux.HandleFunc("POST /api/workflows/{name}/run", s.runWorkflow)
+	mux.HandleFunc("GET /api/workflow_runs", s.listWorkflowRuns)
+	mux.HandleFunc("GET /api/workflow_runs/{id}", s.getWorkflowRun)
+	mux.HandleFunc("GET /api/workflow_runs/{id}/graph", s.getWorkflowGraph)
+	mux.HandleFunc("POST /api/workflow_runs/{id}/resume", s.resumeWorkflowRun)
+	mux.HandleFunc("POST /api/workflow_runs/{id}/tasks/{task}/complete", s.completeWorkflowTask)
+	mux.HandleFunc("POST /api/integrations/alertmanager", s.receiveAlerts)
+	mux.HandleFunc("POST /api/integrations/slack", s.receiveSlack)
+	mux.HandleFunc("POST /api/integrations/git", s.receiveGitPush)
+	mux.HandleFunc("GET /api/automation/catalog", s.getAutomationCatalog)
+	mux.HandleFunc("POST /api/automation/actions/{name}/run", s.runAutomation)
+	mux.HandleFunc("GET /api/automation/executions/{id}", s.getAutomationExecution)
+	mux.HandleFunc("GET /api/events", s.streamEvents)
+	mux.HandleFunc("GET /api/stream", s.streamExecutions)
+	mux.HandleFunc("GET /api/workers/{id}/telemetry", s.getTelemetry)
+	mux.HandleFunc("GET /api/workers/{id}/load_errors", s.getLoadErrors)
+	mux.HandleFunc("POST /api/workers/{id}/sync", s.syncWorker)
+	mux.HandleFunc("GET /api/quotas", s.getQuotas)
+	mux.HandleFunc("GET /api/reports/usage", s.getUsageReport)
+	mux.HandleFunc("GET /api/alerts", s.listAlerts)
+	mux.HandleFunc("GET /api/admin/mqtt/retained", s.listRetained)
+	mux.HandleFunc("GET /api/admin/mqtt/invalid_messages", s.listInvalidMessages)
+	mux.HandleFunc("DELETE /api/admin/mqtt/executions/{id}", s.purgeExecution)
+	mux.HandleFunc("POST /api/admin/mqtt/rescan", s.rescanWorkers)
+	mux.HandleFunc("GET /api/admin/mock", s.getMockConfig)
+	mux.HandleFunc("PUT /api/admin/mock", s.putMockConfig)
+	mux.HandleFunc("GET /api/login", s.getLoginMethods)
+	mux.HandleFunc("POST /api/login", s.login)
+	mux.HandleFunc("POST /api/logout", s.logout)
+	mux.HandleFunc("GET /api/me", s.getMe)
+	mux.HandleFunc("GET /api/ui-config", s.getUIConfig)
+	for _, p := range opts.AuthProviders {
+		if flow, ok := p.(LoginFlow); ok {
+			mux.Handle("/auth/"+p.Name()+"/", flow.LoginHandler(func(w http.ResponseWriter, r *http.Request, id *Identity) {
+				s.startSession(w, r, id)
+			}))
+		}
+	}
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("GET /livez", s.livez)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	s.setupPlugins(mux.ServeMux)
+
+	s.handler = versionedAPI(tenantMiddleware(opts.Tokens, opts.AuthProviders, s.sessions, mux))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Events returns the bus the server publishes execution events on
+func (s *Server) Events() *tinpot.EventBus {
+	return s.events
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"status": "healthy"}
+	if reporter, ok := s.aliases.ActionManager.(tinpot.BrokerReporter); ok {
+		resp["brokers"] = reporter.Brokers()
+	}
+	if reporter, ok := s.aliases.ActionManager.(tinpot.SiteReporter); ok {
+		sites := reporter.Sites()
+		resp["sites"] = sites
+		for _, site := range sites {
+			if site.Status != "healthy" {
+				resp["status"] = "degraded"
+			}
+		}
+	}
+	if len(s.opts.LoadShedding.Thresholds) > 0 {
+		resp["in_flight"], resp["shedding"] = s.quotas.inFlight(), s.shedding()
+	}
+	if s.mgr.IsConnected() {
+		writeJSON(w, 200, resp)
+	} else {
+		resp["status"], resp["detail"] = "unhealthy", "MQTT not connected"
+		writeJSON(w, 503, resp)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,2734 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+type echoManager struct{}
+
+func (echoManager) GetAction(name string) tinpot.ActionTrigger {
+	if name != "echo" {
+		return nil
+	}
+	return func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		if logs != nil {
+			logs("INFO", "echoing")
+		}
+		response("", map[string]interface{}{"message": params["message"]})
+	}
+}
+
+func (echoManager) ListActions() map[string]tinpot.ActionInfo {
+	return map[string]tinpot.ActionInfo{"echo": {Name: "echo", Description: "Echo the message", RunAs: "svc-echo"}}
+}
+
+func (echoManager) IsConnected() bool {
+	return true
+}
+
+func post(t *testing.T, url string, body string) *http.Response {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestExecutionHistory(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, server.NewMemoryStore(0), server.Options{}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/actions/echo/execute", `{"parameters": {"message": "hi"}}`)
+	var submitted server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+	if submitted.ExecutionID == "" {
+		t.Fatalf("missing execution id")
+	}
+
+	// The stream ends once the execution is complete
+	resp, err := http.Get(ts.URL + submitted.StreamURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(stream), `"message":"echoing"`) || !strings.Contains(string(stream), `"type":"complete"`) {
+		t.Errorf("unexpected stream: %s", stream)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/executions/" + submitted.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&rec)
+	resp.Body.Close()
+	if rec.Status != tinpot.StatusSuccess || rec.Result["message"] != "hi" || rec.CompletedAt == nil || rec.RunAs != "svc-echo" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if _, ok := rec.Parameters["_execution_id"]; ok {
+		t.Errorf("internal parameters recorded: %v", rec.Parameters)
+	}
+
+	resp = post(t, ts.URL+"/api/executions/"+submitted.ExecutionID+"/comments", `{"author": "ops", "text": "expected"}`)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Errorf("comment not created: %d", resp.StatusCode)
+	}
+	resp, err = http.Get(ts.URL + "/api/executions/" + submitted.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&rec)
+	resp.Body.Close()
+	if len(rec.Comments) != 1 || rec.Comments[0].Text != "expected" || rec.Comments[0].Author != "ops" {
+		t.Errorf("comment not returned with the record: %+v", rec.Comments)
+	}
+
+	post(t, ts.URL+"/api/actions/echo/sync_execute", `{"parameters": {"message": "again"}}`).Body.Close()
+	resp, err = http.Get(ts.URL + "/api/executions?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&records)
+	resp.Body.Close()
+	if len(records) != 1 || records[0].Result["message"] != "again" {
+		t.Errorf("expected the latest execution, got %+v", records)
+	}
+
+	post(t, ts.URL+"/api/executions/archive", `{"action": "echo"}`).Body.Close()
+	post(t, ts.URL+"/api/executions/"+submitted.ExecutionID+"/unarchive", ``).Body.Close()
+	for query, expected := range map[string]int{"": 1, "?archived=true": 1, "?archived=all": 2} {
+		resp, err = http.Get(ts.URL + "/api/executions" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&records)
+		resp.Body.Close()
+		if len(records) != expected {
+			t.Errorf("%q: expected %d executions, got %d", query, expected, len(records))
+		}
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	srv := server.NewServer(echoManager{}, nil, server.Options{Tokens: map[string]string{"a": "", "b": "team-b"}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	request := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := request("GET", "/api/actions", "wrong", "")
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("expected 401 for an unknown token, got %d", resp.StatusCode)
+	}
+
+	resp = request("POST", "/api/actions/echo/sync_execute", "a", `{"parameters": {}}`)
+	var result server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	resp = request("GET", "/api/executions/"+result.ExecutionID, "b", "")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("execution of another tenant is visible: %d", resp.StatusCode)
+	}
+}
+
+func TestTenantActions(t *testing.T) {
+	for _, spec := range []string{"t:team/a", "t:team+", "t:#"} {
+		if _, err := server.ParseAPITokens(spec); err == nil {
+			t.Errorf("%s: invalid tenant accepted", spec)
+		}
+	}
+
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "secret", Tenant: "team-a"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "public", Tenant: "team-b"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Tokens: map[string]string{"a": "team-a", "b": "team-b"}}))
+	defer ts.Close()
+	request := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := request("GET", "/api/actions", "b", "")
+	var actions map[string]tinpot.ActionInfo
+	json.NewDecoder(resp.Body).Decode(&actions)
+	resp.Body.Close()
+	if _, ok := actions["secret"]; ok || len(actions) != 1 {
+		t.Errorf("actions of another tenant listed: %v", actions)
+	}
+
+	resp = request("POST", "/api/actions/secret/sync_execute", "b", `{"parameters": {"message": "hi"}}`)
+	resp.Body.Close()
+	if resp.StatusCode != 404 || len(mgr.Calls("team-a/secret")) != 0 {
+		t.Errorf("action of another tenant executed: %d", resp.StatusCode)
+	}
+
+	resp = request("POST", "/api/actions/secret/sync_execute", "a", `{"parameters": {"message": "hi"}}`)
+	var result server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || result.ExecutionID == "" {
+		t.Fatalf("execution failed: %d", resp.StatusCode)
+	}
+	resp = request("GET", "/api/executions", "b", "")
+	var records []tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&records)
+	resp.Body.Close()
+	if len(records) != 0 {
+		t.Errorf("history of another tenant listed: %+v", records)
+	}
+	resp = request("GET", "/api/executions?action=secret", "b", "")
+	json.NewDecoder(resp.Body).Decode(&records)
+	resp.Body.Close()
+	if len(records) != 0 {
+		t.Errorf("history of another tenant listed by action: %+v", records)
+	}
+	resp = request("GET", "/api/executions", "a", "")
+	json.NewDecoder(resp.Body).Decode(&records)
+	resp.Body.Close()
+	if len(records) != 1 || records[0].ID != result.ExecutionID {
+		t.Errorf("unexpected history of the tenant: %+v", records)
+	}
+}
+
+func TestRerun(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/actions/echo/sync_execute", `{"parameters": {"message": "hi"}}`)
+	var first server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&first)
+	resp.Body.Close()
+
+	resp = post(t, ts.URL+"/api/executions/"+first.ExecutionID+"/rerun?sync=true", `{"parameters": {"message": "again"}}`)
+	var rerun server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&rerun)
+	resp.Body.Close()
+	if rerun.Status != tinpot.StatusSuccess || rerun.Result.(map[string]interface{})["message"] != "again" {
+		t.Errorf("unexpected rerun result: %+v", rerun)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/executions/" + rerun.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&rec)
+	resp.Body.Close()
+	if rec.RerunOf != first.ExecutionID {
+		t.Errorf("rerun not linked to %s: %+v", first.ExecutionID, rec)
+	}
+
+	// Without from/to, the two latest runs are compared
+	resp, err = http.Get(ts.URL + "/api/actions/echo/diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff server.ActionDiffResponse
+	json.NewDecoder(resp.Body).Decode(&diff)
+	resp.Body.Close()
+	expected := []tinpot.JSONChange{{Path: "/message", Op: tinpot.DiffChanged, From: "hi", To: "again"}}
+	if diff.From != first.ExecutionID || diff.To != rerun.ExecutionID || !reflect.DeepEqual(diff.Changes, expected) {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+}
+
+func TestCatalogETag(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	get := func(etag string) *http.Response {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/actions", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	etag := get("").Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("missing ETag")
+	}
+	if resp := get(etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for unchanged catalog, got %d", resp.StatusCode)
+	}
+	mgr.Add(tinpot.ActionInfo{Name: "other"}, tinpottest.Succeed(nil))
+	if resp := get(etag); resp.StatusCode != 200 || resp.Header.Get("ETag") == etag {
+		t.Errorf("expected new catalog, got %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/actions/echo/sync_execute", bytes.NewBufferString(`{}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if calls := mgr.Calls("echo"); len(calls) != 1 || calls[0]["_trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id not passed to the action: %v", calls)
+	}
+}
+
+func TestDeriveAction(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "svc_restart", Parameters: map[string]tinpot.ParameterInfo{
+		"service": {Type: "str", Required: true},
+		"force":   {Type: "bool", Default: false},
+	}}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	derive := func(action, body string) int {
+		resp, err := http.Post(ts.URL+"/api/actions/"+action+"/derive", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := derive("svc_restart", `{"name": "restart_nginx", "parameters": {"service": "nginx"}}`); status != 201 {
+		t.Fatalf("derive failed: %d", status)
+	}
+	if status := derive("restart_nginx", `{"name": "force_restart_nginx", "parameters": {"force": true}}`); status != 201 {
+		t.Fatalf("derive from derived action failed: %d", status)
+	}
+	if status := derive("svc_restart", `{"name": "restart_nginx"}`); status != 409 {
+		t.Errorf("expected 409 for existing name, got %d", status)
+	}
+	if status := derive("svc_restart", `{"name": "other", "parameters": {"_trace_id": "x"}}`); status != 400 {
+		t.Errorf("expected 400 for unknown parameter, got %d", status)
+	}
+
+	resp, err := http.Post(ts.URL+"/api/actions/force_restart_nginx/sync_execute", "application/json", bytes.NewBufferString(`{"parameters": {"service": "sshd"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls := mgr.Calls("svc_restart"); len(calls) != 1 || calls[0]["service"] != "nginx" || calls[0]["force"] != true {
+		t.Errorf("unexpected call: %v", calls)
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/actions/restart_nginx", nil)
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete failed: %v %v", err, resp)
+	}
+	req, _ = http.NewRequest("DELETE", ts.URL+"/api/actions/svc_restart", nil)
+	if resp, _ = http.DefaultClient.Do(req); resp.StatusCode != 404 {
+		t.Errorf("expected 404 deleting a worker action, got %d", resp.StatusCode)
+	}
+}
+
+func TestParameterOptions(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "list_services"}, tinpottest.Succeed(map[string]interface{}{"options": []string{"nginx", "sshd"}})).
+		Add(tinpot.ActionInfo{Name: "svc_restart", Parameters: map[string]tinpot.ParameterInfo{
+			"service": {Type: "str", UI: &tinpot.ParameterUI{ChoicesFrom: &tinpot.ChoiceSource{Action: "list_services"}}},
+			"mode":    {Type: "str", UI: &tinpot.ParameterUI{Choices: []interface{}{"soft", "hard"}}},
+			"force":   {Type: "bool"},
+		}}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	options := func(param string) (int, server.ParameterOptionsResponse) {
+		var options server.ParameterOptionsResponse
+		resp, err := http.Get(ts.URL + "/api/actions/svc_restart/parameters/" + param + "/options")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&options)
+		return resp.StatusCode, options
+	}
+	for i := 0; i < 2; i++ {
+		if status, got := options("service"); status != 200 || !reflect.DeepEqual(got.Options, []interface{}{"nginx", "sshd"}) || got.Source != "list_services" {
+			t.Errorf("unexpected options: %d %+v", status, got)
+		}
+	}
+	if calls := mgr.Calls("list_services"); len(calls) != 1 {
+		t.Errorf("expected the options to be cached, got %d calls", len(calls))
+	}
+	if status, got := options("mode"); status != 200 || len(got.Options) != 2 {
+		t.Errorf("unexpected static options: %d %+v", status, got)
+	}
+	if status, _ := options("force"); status != 404 {
+		t.Errorf("expected 404 for a parameter without options, got %d", status)
+	}
+}
+
+// directory is an auth provider knowing a fixed set of users, by password and by token
+type directory map[string][]string
+
+func (directory) Name() string {
+	return "directory"
+}
+
+func (d directory) AuthenticatePassword(ctx context.Context, username, password string) (*server.Identity, error) {
+	if groups, ok := d[username]; ok && password == "secret" {
+		return mapping.Identity(username, groups), nil
+	}
+	return nil, nil
+}
+
+func (d directory) AuthenticateToken(ctx context.Context, token string) (*server.Identity, error) {
+	return d.AuthenticatePassword(ctx, token, "secret")
+}
+
+var mapping, _ = server.ParseRoleMapping("ops:operator,admins:admin,*:viewer", "team-b:team-b")
+
+func TestAuthProviders(t *testing.T) {
+	users := directory{"alice": {"admins"}, "bob": {"ops", "team-b"}, "carol": nil, "dave": {"ops"}}
+	srv := server.NewServer(echoManager{}, nil, server.Options{Tokens: map[string]string{"static": ""}, AuthProviders: []server.AuthProvider{users}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	request := func(method, path, user string, bearer bool) int {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(`{"name": "echo2"}`))
+		if bearer {
+			req.Header.Set("Authorization", "Bearer "+user)
+		} else {
+			req.SetBasicAuth(user, "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, c := range []struct {
+		method, path, user string
+		bearer             bool
+		status             int
+	}{
+		{"GET", "/api/actions", "carol", false, 200},
+		{"POST", "/api/actions/echo/sync_execute", "carol", false, 403},
+		{"POST", "/api/actions/echo/sync_execute", "dave", true, 200},
+		{"POST", "/api/actions/echo/derive", "dave", false, 403},
+		{"POST", "/api/actions/echo/derive", "alice", false, 201},
+		{"GET", "/api/actions", "mallory", false, 401},
+		{"DELETE", "/api/actions/echo2", "static", true, 204},
+		{"GET", "/api/admin/mqtt/retained", "dave", false, 403},
+		{"GET", "/api/admin/mqtt/retained", "alice", false, 501},
+	} {
+		if status := request(c.method, c.path, c.user, c.bearer); status != c.status {
+			t.Errorf("%s %s as %s: expected %d, got %d", c.method, c.path, c.user, c.status, status)
+		}
+	}
+
+	// Group mapped tenants isolate like token tenants
+	req, _ := http.NewRequest("GET", ts.URL+"/api/actions", nil)
+	req.SetBasicAuth("bob", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions map[string]tinpot.ActionInfo
+	err = json.NewDecoder(resp.Body).Decode(&actions)
+	resp.Body.Close()
+	if err != nil || len(actions) != 0 {
+		t.Errorf("default tenant actions visible in team-b: %v", actions)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	users := directory{"dave": {"ops"}}
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{AuthProviders: []server.AuthProvider{users}}))
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	request := func(method, path, body, csrf string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if csrf != "" {
+			req.Header.Set(server.CSRFHeader, csrf)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request("GET", "/api/me", "", ""); resp.StatusCode != 401 {
+		t.Errorf("expected 401 before login, got %d", resp.StatusCode)
+	}
+	if resp := request("POST", "/api/login", `{"username": "dave", "password": "wrong"}`, ""); resp.StatusCode != 401 {
+		t.Errorf("expected 401 for wrong password, got %d", resp.StatusCode)
+	}
+	resp := request("POST", "/api/login", `{"username": "dave", "password": "secret"}`, "")
+	var me server.MeResponse
+	json.NewDecoder(resp.Body).Decode(&me)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || me.Subject != "dave" || me.CSRFToken == "" || !reflect.DeepEqual(me.Permissions, []string{"read", "execute"}) {
+		t.Fatalf("unexpected login: %d %+v", resp.StatusCode, me)
+	}
+
+	if resp := request("GET", "/api/me", "", ""); resp.StatusCode != 200 {
+		t.Errorf("expected the session to authenticate, got %d", resp.StatusCode)
+	}
+	if resp := request("POST", "/api/actions/echo/sync_execute", `{"parameters": {}}`, ""); resp.StatusCode != 403 {
+		t.Errorf("expected 403 without CSRF token, got %d", resp.StatusCode)
+	}
+	if resp := request("POST", "/api/actions/echo/sync_execute", `{"parameters": {}}`, me.CSRFToken); resp.StatusCode != 200 {
+		t.Errorf("expected execution with CSRF token, got %d", resp.StatusCode)
+	}
+
+	if resp := request("POST", "/api/logout", "", ""); resp.StatusCode != 204 {
+		t.Errorf("logout failed: %d", resp.StatusCode)
+	}
+	if resp := request("GET", "/api/me", "", ""); resp.StatusCode != 401 {
+		t.Errorf("expected 401 after logout, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	var lines []tinpottest.LogLine
+	for _, message := range []string{"one", "two", "three", "four", "five"} {
+		lines = append(lines, tinpottest.LogLine{Level: "INFO", Message: message})
+	}
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "chatty"}, tinpottest.Script{Logs: lines}.Trigger())
+	ts := httptest.NewServer(server.NewServer(actions, nil, server.Options{StreamBufferSize: 3}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/actions/chatty/execute", `{}`)
+	var submitted server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+
+	// Five logs and the completion, only the last three are kept
+	resp, err := http.Get(ts.URL + submitted.StreamURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(stream), `"message":"three"`) || !strings.Contains(string(stream), "id: 4\ndata: ") || !strings.Contains(string(stream), "id: 6\ndata: {\"type\":\"complete\"") {
+		t.Errorf("unexpected stream: %s", stream)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+submitted.StreamURL, nil)
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(stream), "id: 5") || !strings.Contains(string(stream), "id: 6") {
+		t.Errorf("stream did not resume after the last event: %s", stream)
+	}
+
+	resp, err = http.Get(ts.URL + submitted.StreamURL + "?format=ndjson&last_event_id=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	ndjson := strings.Split(strings.TrimSpace(string(stream)), "\n")
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || len(ndjson) != 3 || !strings.HasPrefix(ndjson[1], `{"id":5,"type":"log"`) || !strings.HasPrefix(ndjson[2], `{"id":6,"type":"complete"`) {
+		t.Errorf("unexpected NDJSON stream: %s", stream)
+	}
+
+	var batch server.StreamEventsResponse
+	resp, err = http.Get(ts.URL + "/api/executions/" + submitted.ExecutionID + "/events?since=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&batch)
+	resp.Body.Close()
+	if len(batch.Events) != 3 || batch.Events[0].ID != 4 || batch.Events[2].Type != "complete" || batch.Cursor != 6 || batch.Missed != 1 || !batch.Closed {
+		t.Errorf("unexpected batch: %+v", batch)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/executions/" + submitted.ExecutionID + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Stream server.StreamStats `json:"stream"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Stream != (server.StreamStats{LastEventID: 6, Buffered: 3, Dropped: 3}) {
+		t.Errorf("unexpected stream stats: %+v", status.Stream)
+	}
+}
+
+func TestSyncExecuteStreaming(t *testing.T) {
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "migrate"}, tinpottest.Script{
+		Logs:   []tinpottest.LogLine{{Level: "INFO", Message: "step 1"}, {Level: tinpot.LogLevelProgress, Message: `{"percent": 50}`}},
+		Result: map[string]interface{}{"migrated": true},
+	}.Trigger())
+	ts := httptest.NewServer(server.NewServer(actions, nil, server.Options{}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/actions/migrate/sync_execute", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+	var types []string
+	var result struct {
+		Data server.SyncExecutionResponse `json:"data"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var line json.RawMessage
+		if err := decoder.Decode(&line); err != nil {
+			break
+		}
+		var event server.StreamEvent
+		json.Unmarshal(line, &event)
+		types = append(types, event.Type)
+		json.Unmarshal(line, &result)
+	}
+	if strings.Join(types, ",") != "log,progress,result" {
+		t.Errorf("unexpected events: %v", types)
+	}
+	if result.Data.Status != tinpot.StatusSuccess || result.Data.Result.(map[string]interface{})["migrated"] != true {
+		t.Errorf("unexpected result: %+v", result.Data)
+	}
+}
+
+type countingPlugin struct {
+	ran chan struct{}
+}
+
+func (countingPlugin) Name() string {
+	return "counter"
+}
+
+func (p countingPlugin) Setup(host *server.PluginHost) error {
+	host.HandleFunc("GET /actions", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"tenant": server.TenantFromRequest(r), "actions": len(host.Manager.ListActions())})
+	})
+	return nil
+}
+
+func (p countingPlugin) Run(ctx context.Context) error {
+	close(p.ran)
+	<-ctx.Done()
+	return nil
+}
+
+func TestPlugins(t *testing.T) {
+	plugin := countingPlugin{ran: make(chan struct{})}
+	srv := server.NewServer(echoManager{}, nil, server.Options{Plugins: []server.Plugin{plugin}, Tokens: map[string]string{"secret": "teamA"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.RunPlugins(ctx)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/api/plugins/counter/actions")
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("plugin routes should need authentication, got %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/api/plugins/counter/actions", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if body["tenant"] != "teamA" || body["actions"] != float64(1) {
+		t.Errorf("unexpected plugin response: %v", body)
+	}
+	select {
+	case <-plugin.ran:
+	case <-time.After(5 * time.Second):
+		t.Error("plugin service not started")
+	}
+}
+
+func TestUIConfig(t *testing.T) {
+	features, err := server.ParseUIFeatures("execute=false, wallboard")
+	if err != nil || !reflect.DeepEqual(features, map[string]bool{"execute": false, "wallboard": true}) {
+		t.Fatalf("unexpected features: %v %v", features, err)
+	}
+	if _, err := server.ParseUIFeatures("execute=maybe"); err == nil {
+		t.Error("invalid flag value should be rejected")
+	}
+	srv := server.NewServer(echoManager{}, nil, server.Options{
+		Tokens: map[string]string{"secret": ""},
+		UI:     server.UIConfig{Title: "Ops", BasePath: "/tinpot", Features: features},
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// Readable before logging in
+	resp, err := http.Get(ts.URL + "/api/ui-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config server.UIConfig
+	json.NewDecoder(resp.Body).Decode(&config)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || config.Title != "Ops" || config.BasePath != "/tinpot" || config.Features["execute"] || config.AuthMode != server.AuthModeToken {
+		t.Errorf("unexpected UI config: %d %+v", resp.StatusCode, config)
+	}
+}
+
+type failingPlugin struct{}
+
+func (failingPlugin) Name() string {
+	return "failing"
+}
+
+func (failingPlugin) Setup(host *server.PluginHost) error {
+	host.AddHealthCheck(server.HealthCheck{Name: "upstream", Check: func(ctx context.Context) error {
+		return io.ErrUnexpectedEOF
+	}})
+	return nil
+}
+
+func TestHealthProbes(t *testing.T) {
+	events := tinpot.NewEventBus()
+	srv := server.NewServer(echoManager{}, nil, server.Options{Events: events, MinWorkers: 1})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	probe := func(path string) (int, server.HealthResponse) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var health server.HealthResponse
+		json.NewDecoder(resp.Body).Decode(&health)
+		return resp.StatusCode, health
+	}
+
+	if code, health := probe("/livez"); code != 200 || health.Status != "ok" {
+		t.Errorf("unexpected liveness: %d %+v", code, health)
+	}
+	code, health := probe("/readyz")
+	if code != 503 || len(health.Checks) != 3 || health.Checks[2].Name != "workers" || health.Checks[2].Status != "failing" {
+		t.Errorf("readiness should wait for a worker: %d %+v", code, health)
+	}
+	events.Publish(tinpot.ExecutionEvent{Type: tinpot.EventWorkerConnected, Worker: "w1"})
+	if code, health := probe("/readyz"); code != 200 || health.Status != "ok" {
+		t.Errorf("unexpected readiness: %d %+v", code, health)
+	}
+
+	ts2 := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{Plugins: []server.Plugin{failingPlugin{}}}))
+	defer ts2.Close()
+	resp, err := http.Get(ts2.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if resp.StatusCode != 503 || health.Checks[len(health.Checks)-1].Error != io.ErrUnexpectedEOF.Error() {
+		t.Errorf("plugin check not reported: %d %+v", resp.StatusCode, health)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+	put := func(body string) *http.Response {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/schedules/ping", bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := put(`{"action": "echo", "cron": "every minute"}`); resp.StatusCode != 400 {
+		t.Errorf("invalid cron expression accepted: %d", resp.StatusCode)
+	}
+	if resp := put(`{"action": "echo", "cron": "@every 20ms", "parameters": {"message": "tick"}}`); resp.StatusCode != 201 {
+		t.Fatalf("schedule not created: %d", resp.StatusCode)
+	}
+	var schedule server.ScheduleResponse
+	tinpottest.WaitFor(t, func() bool {
+		resp, err := http.Get(ts.URL + "/api/schedules/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&schedule)
+		resp.Body.Close()
+		return schedule.LastExecution != nil && schedule.LastExecution.Status == tinpot.StatusSuccess
+	})
+	if schedule.LastExecution.Result["message"] != "tick" || schedule.NextRun == nil {
+		t.Errorf("unexpected schedule: %+v", schedule)
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/schedules/ping", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, _ = http.Get(ts.URL + "/api/schedules/ping")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("schedule not deleted: %d", resp.StatusCode)
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	queue := t.TempDir() + "/queue.json"
+	offline := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	offline.SetConnected(false)
+	ts := httptest.NewServer(server.NewServer(offline, nil, server.Options{OfflineQueueFile: queue, OfflineQueueInterval: 10 * time.Millisecond}))
+	defer ts.Close()
+
+	if resp := post(t, ts.URL+"/api/actions/echo/sync_execute", `{"parameters": {"message": "hi"}}`); resp.StatusCode != 503 {
+		t.Errorf("sync execution accepted offline: %d", resp.StatusCode)
+	}
+	resp := post(t, ts.URL+"/api/actions/echo/execute", `{"parameters": {"message": "hi"}}`)
+	var queued server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&queued)
+	resp.Body.Close()
+	if resp.StatusCode != 202 || queued.Status != "queued_offline" {
+		t.Fatalf("execution not queued: %d %+v", resp.StatusCode, queued)
+	}
+	status := func(url string) map[string]interface{} {
+		resp, err := http.Get(url + "/api/executions/" + queued.ExecutionID + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status
+	}
+	if state := status(ts.URL)["state"]; state != tinpot.StatusQueuedOffline {
+		t.Errorf("unexpected state: %v", state)
+	}
+
+	// A restarted coordinator picks up the queue and dispatches it once connected
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	mgr.SetConnected(false)
+	restarted := httptest.NewServer(server.NewServer(mgr, nil, server.Options{OfflineQueueFile: queue, OfflineQueueInterval: 10 * time.Millisecond}))
+	defer restarted.Close()
+	if state := status(restarted.URL)["state"]; state != tinpot.StatusQueuedOffline {
+		t.Errorf("queue not restored: %v", state)
+	}
+	mgr.SetConnected(true)
+	tinpottest.WaitFor(t, func() bool {
+		return status(restarted.URL)["state"] == tinpot.StatusSuccess
+	})
+	if calls := mgr.Calls("echo"); len(calls) != 1 || calls[0]["message"] != "hi" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestExecutionDeadlines(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{OfflineQueueFile: t.TempDir() + "/queue.json", OfflineQueueInterval: 10 * time.Millisecond}))
+	defer ts.Close()
+	execute := func(notBefore, notAfter time.Duration) (int, server.ExecutionResponse) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"parameters": map[string]interface{}{"message": "hi"},
+			"not_before": time.Now().Add(notBefore),
+			"not_after":  time.Now().Add(notAfter),
+		})
+		resp := post(t, ts.URL+"/api/actions/echo/execute", string(body))
+		defer resp.Body.Close()
+		var exec server.ExecutionResponse
+		json.NewDecoder(resp.Body).Decode(&exec)
+		return resp.StatusCode, exec
+	}
+	record := func(id string) tinpot.ExecutionRecord {
+		resp, err := http.Get(ts.URL + "/api/executions/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rec tinpot.ExecutionRecord
+		json.NewDecoder(resp.Body).Decode(&rec)
+		return rec
+	}
+
+	if code, _ := execute(0, -time.Second); code != 400 {
+		t.Errorf("expired execution accepted: %d", code)
+	}
+	code, held := execute(50*time.Millisecond, time.Minute)
+	if code != 202 || held.Status != "scheduled" {
+		t.Fatalf("execution not held: %d %+v", code, held)
+	}
+	if rec := record(held.ExecutionID); rec.Status != tinpot.StatusPending || len(mgr.Calls("echo")) != 0 {
+		t.Errorf("execution started early: %+v", rec)
+	}
+	tinpottest.WaitFor(t, func() bool { return record(held.ExecutionID).Status == tinpot.StatusSuccess })
+	if rec := record(held.ExecutionID); rec.StartedAt.Before(*rec.NotBefore) {
+		t.Errorf("started before not_before: %+v", rec)
+	}
+
+	// Not picked up before the deadline
+	mgr.SetConnected(false)
+	_, queued := execute(0, 30*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	mgr.SetConnected(true)
+	tinpottest.WaitFor(t, func() bool { return record(queued.ExecutionID).Status == tinpot.StatusExpired })
+	if calls := mgr.Calls("echo"); len(calls) != 1 {
+		t.Errorf("expired execution triggered: %v", calls)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "reboot", Group: "ops"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "vacuum", Group: "db"}, tinpottest.Echo())
+	// A weekly window opening within a second
+	opens := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	ics := t.TempDir() + "/db.ics"
+	os.WriteFile(ics, []byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:"+opens.Format("20060102T150405Z")+"\r\nDURATION:PT1H\r\nRRULE:FREQ=WEEKLY\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"), 0600)
+	config := server.MaintenanceConfig{
+		"ops": {Policy: server.MaintenancePolicyReject, Windows: []server.MaintenanceWindow{{Start: time.Now().Add(time.Hour), End: time.Now().Add(2 * time.Hour)}}},
+		"db":  {ICal: ics},
+	}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Maintenance: config}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var windows map[string]server.MaintenanceStatus
+	json.NewDecoder(resp.Body).Decode(&windows)
+	resp.Body.Close()
+	if db := windows["db"]; db.Open || db.OpensAt == nil || !db.OpensAt.Equal(opens) {
+		t.Errorf("unexpected db window: %+v", db)
+	}
+
+	if resp := post(t, ts.URL+"/api/actions/reboot/execute", `{}`); resp.StatusCode != 409 {
+		t.Errorf("execution outside of the window accepted: %d", resp.StatusCode)
+	}
+	resp = post(t, ts.URL+"/api/actions/vacuum/execute", `{}`)
+	var held server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&held)
+	resp.Body.Close()
+	if resp.StatusCode != 202 || held.Status != "scheduled" {
+		t.Fatalf("execution not held until the window: %d %+v", resp.StatusCode, held)
+	}
+	tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("vacuum")) == 1 })
+	if time.Now().Before(opens) {
+		t.Error("execution started before the window opened")
+	}
+}
+
+func TestWorkflows(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "deploy"}, func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			if broken.Load() {
+				response("deploy failed", nil)
+			} else {
+				response("", map[string]interface{}{"version": "1.2"})
+			}
+		})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/workflows/release", bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	getRun := func(id string) server.WorkflowRun {
+		resp, err := http.Get(ts.URL + "/api/workflow_runs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var run server.WorkflowRun
+		json.NewDecoder(resp.Body).Decode(&run)
+		return run
+	}
+
+	if status := put(`{"steps": [{"id": "a", "action": "echo", "depends_on": ["b"]}, {"id": "b", "action": "echo", "depends_on": ["a"]}]}`); status != 400 {
+		t.Errorf("cyclic workflow accepted: %d", status)
+	}
+	if status := put(`{"steps": [
+		{"id": "build", "action": "echo", "parameters": {"message": "built"}},
+		{"id": "deploy", "action": "deploy", "depends_on": ["build"], "retries": 1},
+		{"id": "notify", "action": "echo", "depends_on": ["deploy"]}
+	]}`); status != 201 {
+		t.Fatalf("workflow not created: %d", status)
+	}
+
+	resp := post(t, ts.URL+"/api/workflows/release/run", `{}`)
+	var run server.WorkflowRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		t.Fatalf("run not started: %d", resp.StatusCode)
+	}
+	tinpottest.WaitFor(t, func() bool { run = getRun(run.ID); return run.CompletedAt != nil })
+	if run.Status != tinpot.StatusFailure || run.Steps[1].Attempts != 2 || run.Steps[1].Error != "deploy failed" || run.Steps[2].Status != server.StatusSkipped {
+		t.Fatalf("unexpected failed run: %+v", run)
+	}
+
+	broken.Store(false)
+	if resp := post(t, ts.URL+"/api/workflow_runs/"+run.ID+"/resume", `{}`); resp.StatusCode != 202 {
+		t.Fatalf("run not resumed: %d", resp.StatusCode)
+	}
+	tinpottest.WaitFor(t, func() bool { run = getRun(run.ID); return run.CompletedAt != nil })
+	if run.Status != tinpot.StatusSuccess || run.Resumed != 1 || run.Steps[0].Result["message"] != "built" {
+		t.Errorf("unexpected resumed run: %+v", run)
+	}
+	if calls := len(mgr.Calls("echo")); calls != 2 {
+		t.Errorf("succeeded step ran again, echo called %d times", calls)
+	}
+	if resp := post(t, ts.URL+"/api/workflow_runs/"+run.ID+"/resume", `{}`); resp.StatusCode != 409 {
+		t.Errorf("successful run resumed: %d", resp.StatusCode)
+	}
+}
+
+func TestWorkflowExpressions(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+	put := func(expression string) (int, string) {
+		body := `{"inputs": {"factor": {"type": "int", "default": 2}}, "steps": [
+			{"id": "fetch", "action": "echo", "parameters": {"total": 21}},
+			{"id": "double", "action": "echo", "depends_on": ["fetch"], "expressions": {"amount": ` + expression + `}}
+		]}`
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/workflows/double", bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var detail map[string]string
+		json.NewDecoder(resp.Body).Decode(&detail)
+		return resp.StatusCode, detail["detail"]
+	}
+
+	if status, detail := put(`"steps.double.result.total"`); status != 400 || !strings.Contains(detail, `steps[1].expressions.amount: 1:6: step "double" is not a dependency`) {
+		t.Errorf("expression on a non-dependency accepted: %d %s", status, detail)
+	}
+	if status, detail := put(`"steps.fetch.result.total *"`); status != 400 || !strings.Contains(detail, "steps[1].expressions.amount: 1:") {
+		t.Errorf("invalid expression accepted: %d %s", status, detail)
+	}
+	if status, detail := put(`"steps.fetch.result.total * inputs.factor"`); status != 201 {
+		t.Fatalf("workflow not created: %d %s", status, detail)
+	}
+	if resp := post(t, ts.URL+"/api/workflows/double/run", `{"inputs": {"factor": "x"}}`); resp.StatusCode != 400 {
+		t.Errorf("invalid input accepted: %d", resp.StatusCode)
+	}
+
+	resp := post(t, ts.URL+"/api/workflows/double/run", `{"inputs": {"factor": 3}}`)
+	var run server.WorkflowRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	tinpottest.WaitFor(t, func() bool {
+		resp, err := http.Get(ts.URL + "/api/workflow_runs/" + run.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&run)
+		return run.CompletedAt != nil
+	})
+	if run.Status != tinpot.StatusSuccess || run.Steps[1].Result["amount"] != float64(63) {
+		t.Errorf("unexpected run: %+v", run)
+	}
+}
+
+func TestWorkflowForEach(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var broken atomic.Bool
+	broken.Store(true)
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "ping"}, func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if parameters["host"] == "b" && broken.Load() {
+				response("unreachable", nil)
+			} else {
+				response("", map[string]interface{}{"host": parameters["host"], "up": true})
+			}
+		})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/workflows/ping-all", bytes.NewBufferString(`{"steps": [
+		{"id": "list", "action": "echo", "parameters": {"hosts": ["a", "b", "c", "d"]}},
+		{"id": "ping", "action": "ping", "depends_on": ["list"], "for_each": "steps.list.result.hosts", "parallelism": 2, "expressions": {"host": "item"}},
+		{"id": "report", "action": "echo", "depends_on": ["ping"], "expressions": {"up": "steps.ping.result.items.filter(i, i.up).size()"}}
+	]}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("workflow not created: %d", resp.StatusCode)
+	}
+	wait := func(id string) server.WorkflowRun {
+		var run server.WorkflowRun
+		tinpottest.WaitFor(t, func() bool {
+			resp, err := http.Get(ts.URL + "/api/workflow_runs/" + id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			json.NewDecoder(resp.Body).Decode(&run)
+			return run.CompletedAt != nil
+		})
+		return run
+	}
+
+	resp = post(t, ts.URL+"/api/workflows/ping-all/run", `{}`)
+	var run server.WorkflowRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	run = wait(run.ID)
+	ping := run.Steps[1]
+	if run.Status != tinpot.StatusFailure || len(ping.Items) != 4 || ping.Items[1].Status != tinpot.StatusFailure || ping.Items[1].Error != "unreachable" || ping.Items[2].Status != tinpot.StatusSuccess {
+		t.Fatalf("unexpected failed run: %+v", run)
+	}
+	mu.Lock()
+	if maxRunning > 2 {
+		t.Errorf("%d items ran at once", maxRunning)
+	}
+	mu.Unlock()
+
+	broken.Store(false)
+	post(t, ts.URL+"/api/workflow_runs/"+run.ID+"/resume", `{}`).Body.Close()
+	run = wait(run.ID)
+	if run.Status != tinpot.StatusSuccess || run.Steps[2].Result["up"] != float64(4) {
+		t.Errorf("unexpected resumed run: %+v", run)
+	}
+	if calls := len(mgr.Calls("ping")); calls != 5 {
+		t.Errorf("succeeded items ran again, ping called %d times", calls)
+	}
+}
+
+func TestWorkflowManualSteps(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/workflows/change", bytes.NewBufferString(`{"steps": [
+		{"id": "check", "action": "echo"},
+		{"id": "approve", "type": "manual", "instructions": "Open a change ticket", "depends_on": ["check"]},
+		{"id": "apply", "action": "echo", "depends_on": ["approve"], "expressions": {"ticket": "steps.approve.result.ticket"}}
+	]}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("workflow not created: %d", resp.StatusCode)
+	}
+	resp = post(t, ts.URL+"/api/workflows/change/run", `{}`)
+	var run server.WorkflowRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	get := func() server.WorkflowRun {
+		resp, err := http.Get(ts.URL + "/api/workflow_runs/" + run.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got server.WorkflowRun
+		json.NewDecoder(resp.Body).Decode(&got)
+		return got
+	}
+	tinpottest.WaitFor(t, func() bool { return get().Steps[1].Status == server.StatusWaiting })
+	if len(mgr.Calls("echo")) != 1 {
+		t.Error("the run did not wait for the manual step")
+	}
+	resp, err = http.Get(ts.URL + "/api/workflow_runs/" + run.ID + "/graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var graph server.WorkflowGraph
+	json.NewDecoder(resp.Body).Decode(&graph)
+	resp.Body.Close()
+	if len(graph.Nodes) != 3 || graph.Nodes[1].Title != "manual" || graph.Nodes[1].Status != server.StatusWaiting || graph.Nodes[0].Status != tinpot.StatusSuccess {
+		t.Errorf("unexpected nodes: %+v", graph.Nodes)
+	}
+	if len(graph.Edges) != 2 || graph.Edges[0].Source != "check" || graph.Edges[0].Target != "approve" {
+		t.Errorf("unexpected edges: %+v", graph.Edges)
+	}
+
+	task := ts.URL + "/api/workflow_runs/" + run.ID + "/tasks/"
+	if resp := post(t, task+"check/complete", `{}`); resp.StatusCode != 404 {
+		t.Errorf("action step completed: %d", resp.StatusCode)
+	}
+	if resp := post(t, task+"approve/complete", `{"result": {"ticket": "CHG-1"}, "author": "alice"}`); resp.StatusCode != 200 {
+		t.Fatalf("manual step not completed: %d", resp.StatusCode)
+	}
+	tinpottest.WaitFor(t, func() bool { run = get(); return run.CompletedAt != nil })
+	if run.Status != tinpot.StatusSuccess || run.Steps[1].CompletedBy != "alice" || run.Steps[2].Result["ticket"] != "CHG-1" {
+		t.Errorf("unexpected run: %+v", run)
+	}
+	if resp := post(t, task+"approve/complete", `{}`); resp.StatusCode != 409 {
+		t.Errorf("manual step completed twice: %d", resp.StatusCode)
+	}
+}
+
+func TestWorkflowDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/scale-up.yaml", []byte(`
+description: Scale the web tier
+inputs:
+  replicas: {type: int, default: 3}
+steps:
+  - id: scale
+    action: scale
+    expressions:
+      replicas: inputs.replicas
+`), 0600)
+	os.Mkdir(dir+"/team-a", 0700)
+	os.WriteFile(dir+"/team-a/other.yml", []byte("steps: [{id: noop, action: scale, parameters: {replicas: 1}}]\n"), 0600)
+	workflows, err := server.LoadWorkflows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := workflows["team-a/other"]; !ok || len(workflows) != 2 {
+		t.Errorf("unexpected workflows: %v", workflows)
+	}
+
+	// Loaded definitions are only checked against the catalog when they run
+	workflows["escape"] = server.Workflow{Steps: []server.WorkflowStep{{ID: "steal", Action: "team-a/secret"}}}
+
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "scale", Parameters: map[string]tinpot.ParameterInfo{"replicas": {Type: "int", Required: true}}}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "secret", Tenant: "team-a"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Workflows: workflows}))
+	defer ts.Close()
+	validate := func(body string) server.WorkflowValidation {
+		resp := post(t, ts.URL+"/api/workflows/validate", body)
+		defer resp.Body.Close()
+		var validation server.WorkflowValidation
+		json.NewDecoder(resp.Body).Decode(&validation)
+		return validation
+	}
+	if v := validate("steps:\n  - id: scale\n    action: scale\n    retry: 2\n"); v.Valid || len(v.Problems) != 1 || !strings.Contains(v.Problems[0], `unknown field "retry"`) {
+		t.Errorf("unknown field accepted: %+v", v)
+	}
+	v := validate("steps:\n  - {id: a, action: scale, parameters: {replicas: many}}\n  - {id: b, action: deploy}\n  - {id: c, action: scale}\n")
+	expected := []string{
+		"steps[0].parameters.replicas: many does not match the int parameter",
+		"steps[1]: action not found: deploy",
+		"steps[2]: missing required parameter replicas of scale",
+	}
+	if v.Valid || !reflect.DeepEqual(v.Problems, expected) {
+		t.Errorf("unexpected problems: %+v", v)
+	}
+
+	resp := post(t, ts.URL+"/api/workflows/scale-up/run", `{}`)
+	var run server.WorkflowRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		t.Fatalf("loaded workflow not run: %d", resp.StatusCode)
+	}
+	tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("scale")) == 1 })
+	if replicas := mgr.Calls("scale")[0]["replicas"]; replicas != float64(3) {
+		t.Errorf("unexpected replicas: %v", replicas)
+	}
+
+	if v := validate("steps:\n  - {id: steal, action: team-a/secret}\n"); v.Valid || len(v.Problems) != 1 || v.Problems[0] != `steps[0]: invalid action "team-a/secret"` {
+		t.Errorf("action of another tenant accepted: %+v", v)
+	}
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/workflows/steal", strings.NewReader(`{"steps": [{"id": "steal", "action": "team-a/secret"}]}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("workflow with the action of another tenant saved: %d", resp.StatusCode)
+	}
+	resp = post(t, ts.URL+"/api/workflows/escape/run", `{}`)
+	resp.Body.Close()
+	if resp.StatusCode != 400 || len(mgr.Calls("team-a/secret")) != 0 {
+		t.Errorf("action of another tenant run by a workflow: %d", resp.StatusCode)
+	}
+}
+
+func TestAlertmanager(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "cleanup_disk", Parameters: map[string]tinpot.ParameterInfo{"mountpoint": {Type: "str"}}}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "page"}, tinpottest.Echo())
+	config := server.AlertmanagerConfig{Routes: []server.AlertRoute{
+		{Matchers: []string{"alertname=DiskFull", `severity=~"critical|warning"`}, Action: "cleanup_disk", Parameters: map[string]interface{}{"force": true}, Continue: true},
+		{Matchers: []string{"severity=critical"}, Action: "page"},
+	}}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Alertmanager: config}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/integrations/alertmanager", `{"version": "4", "status": "firing", "alerts": [
+		{"status": "firing", "labels": {"alertname": "DiskFull", "severity": "critical"}, "annotations": {"mountpoint": "/var", "summary": "Disk full"}, "fingerprint": "a1"},
+		{"status": "firing", "labels": {"alertname": "DiskFull", "severity": "info"}, "annotations": {"mountpoint": "/tmp"}},
+		{"status": "resolved", "labels": {"alertname": "HighLoad", "severity": "critical"}}
+	]}`)
+	var executions []server.AlertExecution
+	json.NewDecoder(resp.Body).Decode(&executions)
+	resp.Body.Close()
+	if len(executions) != 2 || executions[0].Action != "cleanup_disk" || executions[0].Fingerprint != "a1" || executions[1].Action != "page" || executions[0].ExecutionID == "" {
+		t.Fatalf("unexpected executions: %+v", executions)
+	}
+	tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("cleanup_disk")) == 1 && len(mgr.Calls("page")) == 1 })
+	call := mgr.Calls("cleanup_disk")[0]
+	if call["mountpoint"] != "/var" || call["force"] != true || call["summary"] != nil {
+		t.Errorf("unexpected parameters: %v", call)
+	}
+	if call := mgr.Calls("page")[0]; call["summary"] != "Disk full" {
+		t.Errorf("annotations not passed to an action without declared parameters: %v", call)
+	}
+}
+
+func TestAutomationEndpoints(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "resize", Parameters: map[string]tinpot.ParameterInfo{
+			"size": {Type: "int", Required: true, UI: &tinpot.ParameterUI{Order: 1, Help: "New size in GB"}},
+			"disk": {Type: "str", Enum: []interface{}{"data", "logs"}},
+		}}, tinpottest.Script{Delay: 50 * time.Millisecond, Result: map[string]interface{}{"ok": true}}.Trigger()).
+		Add(tinpot.ActionInfo{Name: "fail"}, tinpottest.Fail("boom"))
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Tokens: map[string]string{"key": ""}}))
+	defer ts.Close()
+	do := func(method, path, body string, v interface{}) int {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", "key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(v)
+		return resp.StatusCode
+	}
+
+	var catalog []server.AutomationAction
+	if status := do("GET", "/api/automation/catalog", "", &catalog); status != 200 || len(catalog) != 2 || catalog[1].Name != "resize" {
+		t.Fatalf("unexpected catalog: %d %+v", status, catalog)
+	}
+	fields := catalog[1].Fields
+	if len(fields) != 2 || fields[0].Name != "disk" || len(fields[0].Options) != 2 || fields[1].Type != "integer" || !fields[1].Required || fields[1].Description != "New size in GB" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+
+	var execution server.AutomationExecution
+	do("POST", "/api/automation/actions/resize/run", `{"size": 10}`, &execution)
+	if execution.Done || execution.Result == nil || execution.ID == "" {
+		t.Errorf("unexpected started execution: %+v", execution)
+	}
+	do("GET", "/api/automation/executions/"+execution.ID+"?wait=5s", "", &execution)
+	if !execution.Done || execution.Status != tinpot.StatusSuccess || execution.Result["ok"] != true {
+		t.Errorf("unexpected finished execution: %+v", execution)
+	}
+	var failed server.AutomationExecution
+	do("POST", "/api/automation/actions/fail/run?wait=5s", ``, &failed)
+	if !failed.Done || failed.Error != "boom" || failed.Result == nil || len(failed.Result) != 0 {
+		t.Errorf("unexpected failed execution: %+v", failed)
+	}
+	if status := do("POST", "/api/automation/actions/missing/run", `{}`, &failed); status != 404 {
+		t.Errorf("unknown action executed: %d", status)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "clean_cache", Description: "Cleans the cache"}, tinpottest.Script{Result: map[string]interface{}{"freed": 42}}.Trigger()).
+		Add(tinpot.ActionInfo{Name: "secret", Tenant: "team-a"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{
+		Tokens: map[string]string{"key": ""},
+		Slack:  server.SlackConfig{SigningSecret: "secret", Users: []string{"U1"}},
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var messages []map[string]interface{}
+	responses := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&message)
+		mu.Lock()
+		messages = append(messages, message)
+		mu.Unlock()
+	}))
+	defer responses.Close()
+
+	send := func(form url.Values, secret string) (int, map[string]interface{}) {
+		body := form.Encode()
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":" + body))
+		req, _ := http.NewRequest("POST", ts.URL+"/api/integrations/slack", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var reply map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return resp.StatusCode, reply
+	}
+	command := func(user, text string) url.Values {
+		return url.Values{"command": {"/tinpot"}, "text": {text}, "user_id": {user}, "response_url": {responses.URL}}
+	}
+
+	if status, _ := send(command("U1", "list"), "wrong"); status != 401 {
+		t.Errorf("request with an invalid signature accepted: %d", status)
+	}
+	if _, reply := send(command("U1", "list"), "secret"); !strings.Contains(reply["text"].(string), "clean_cache") {
+		t.Errorf("unexpected list: %v", reply)
+	}
+	if _, reply := send(command("U2", "run clean_cache"), "secret"); reply["blocks"] != nil {
+		t.Errorf("unknown user asked for confirmation: %v", reply)
+	}
+
+	// Neither the command nor a forged button reach the actions of another tenant
+	if _, reply := send(command("U1", "run team-a/secret"), "secret"); reply["text"] != "Invalid action: team-a/secret" {
+		t.Errorf("qualified action accepted: %v", reply)
+	}
+	forged, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U1"},
+		"response_url": responses.URL,
+		"actions":      []map[string]interface{}{{"action_id": "run", "value": `{"action": "team-a/secret"}`}},
+	})
+	send(url.Values{"payload": {string(forged)}}, "secret")
+	tinpottest.WaitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(messages) > 0 && messages[len(messages)-1]["text"] == "Invalid action: team-a/secret"
+	})
+	if calls := mgr.Calls("team-a/secret"); len(calls) != 0 {
+		t.Errorf("action of another tenant run from Slack: %v", calls)
+	}
+
+	_, reply := send(command("U1", `run clean_cache days=5 reason="old files"`), "secret")
+	blocks, _ := reply["blocks"].([]interface{})
+	if len(blocks) != 2 {
+		t.Fatalf("no confirmation: %v", reply)
+	}
+	button := blocks[1].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U1"},
+		"response_url": responses.URL,
+		"actions":      []map[string]interface{}{{"action_id": "run", "value": button["value"]}},
+	})
+	if status, _ := send(url.Values{"payload": {string(payload)}}, "secret"); status != 200 {
+		t.Fatalf("confirmation failed: %d", status)
+	}
+	tinpottest.WaitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(messages) > 0 && messages[len(messages)-1]["response_type"] == "in_channel"
+	})
+	if calls := mgr.Calls("clean_cache"); len(calls) != 1 || calls[0]["days"] != float64(5) || calls[0]["reason"] != "old files" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if outcome := messages[len(messages)-1]["text"].(string); !strings.Contains(outcome, "succeeded") || !strings.Contains(outcome, `"freed": 42`) {
+		t.Errorf("unexpected outcome: %s", outcome)
+	}
+}
+
+// syncingManager records the sync requests of the workers
+type syncingManager struct {
+	*tinpottest.ActionManager
+	mu    sync.Mutex
+	syncs []string
+}
+
+func (m *syncingManager) SyncWorkers(tenant, workerID, ref string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs = append(m.syncs, tenant+"|"+workerID+"|"+ref)
+	return nil
+}
+
+func TestWorkerSync(t *testing.T) {
+	mgr := &syncingManager{ActionManager: tinpottest.NewActionManager()}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Tokens: map[string]string{"key": ""}, GitWebhookSecret: "secret"}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/workers/w1/sync", nil)
+	req.Header.Set("Authorization", "Bearer key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		t.Errorf("sync not requested: %d", resp.StatusCode)
+	}
+
+	push := func(body, signature string) int {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/integrations/git?tenant=team-a", strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	body := `{"ref": "refs/heads/main", "after": "abc"}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	if status := push(body, "sha256=0000"); status != 401 {
+		t.Errorf("push with an invalid signature accepted: %d", status)
+	}
+	if status := push(body, "sha256="+hex.EncodeToString(mac.Sum(nil))); status != 202 {
+		t.Errorf("push not accepted: %d", status)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if !reflect.DeepEqual(mgr.syncs, []string{"|w1|", "team-a||refs/heads/main"}) {
+		t.Errorf("unexpected syncs: %v", mgr.syncs)
+	}
+}
+
+// scratchManager serves the scratch directories of the executions it ran
+type scratchManager struct {
+	*tinpottest.ActionManager
+}
+
+func (m scratchManager) FetchScratch(ctx context.Context, tenant, executionID string) ([]byte, error) {
+	for _, call := range m.Calls("echo") {
+		if call["_execution_id"] == executionID {
+			return []byte("archive"), nil
+		}
+	}
+	return nil, tinpot.ErrScratchNotFound
+}
+
+func TestScratchDownload(t *testing.T) {
+	mgr := scratchManager{tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "ephemeral", Capabilities: []string{tinpot.CapabilityProgress}}, tinpottest.Echo())}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/actions/echo/sync_execute", "application/json", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var execution map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&execution)
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/api/executions/" + execution["execution_id"].(string) + "/scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "archive" || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Errorf("unexpected download: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := http.Get(ts.URL + "/api/executions/unknown/scratch"); resp.StatusCode != 404 {
+		t.Errorf("unknown execution: %d", resp.StatusCode)
+	}
+
+	// Actions whose workers don't announce the capabilities are told apart
+	resp = post(t, ts.URL+"/api/actions/ephemeral/sync_execute", `{}`)
+	json.NewDecoder(resp.Body).Decode(&execution)
+	resp.Body.Close()
+	for _, endpoint := range []string{"scratch", "cancel"} {
+		url := ts.URL + "/api/executions/" + execution["execution_id"].(string) + "/" + endpoint
+		if endpoint == "scratch" {
+			resp, err = http.Get(url)
+		} else {
+			resp, err = http.Post(url, "application/json", nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 501 || !strings.Contains(string(body), "The workers of ephemeral") {
+			t.Errorf("unsupported %s: %d %s", endpoint, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	release := make(chan struct{})
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "slow"}, func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		go func() {
+			<-release
+			response("", nil)
+		}()
+	})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{LoadShedding: server.SheddingPolicy{
+		Thresholds: map[string]int{server.PriorityLow: 1, server.PriorityNormal: 2},
+		RetryAfter: 5 * time.Second,
+	}}))
+	defer ts.Close()
+	defer close(release)
+	execute := func(priority string) *http.Response {
+		resp := post(t, ts.URL+"/api/actions/slow/execute", `{"parameters": {}, "priority": "`+priority+`"}`)
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := execute(""); resp.StatusCode != 200 {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	if resp := execute("low"); resp.StatusCode != 503 || resp.Header.Get("Retry-After") != "6" {
+		t.Errorf("low priority admitted: %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp := execute("normal"); resp.StatusCode != 200 {
+		t.Errorf("normal priority rejected: %d", resp.StatusCode)
+	}
+	if resp := execute("normal"); resp.StatusCode != 503 {
+		t.Errorf("normal priority admitted: %d", resp.StatusCode)
+	}
+	if resp := execute("high"); resp.StatusCode != 200 {
+		t.Errorf("high priority rejected: %d", resp.StatusCode)
+	}
+	if resp := execute("urgent"); resp.StatusCode != 400 {
+		t.Errorf("unknown priority accepted: %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		InFlight int      `json:"in_flight"`
+		Shedding []string `json:"shedding"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if health.InFlight != 3 || !reflect.DeepEqual(health.Shedding, []string{"low", "normal"}) {
+		t.Errorf("unexpected health: %+v", health)
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	heavy := func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		tinpot.ReportUsage(params, tinpot.ResourceUsage{DurationSeconds: 10, CPUSeconds: 8, PeakMemoryBytes: 2 << 30})
+		tinpot.ReportEnvironment(params, tinpot.ExecutionEnvironment{WorkerID: "w1", PythonVersion: "3.11.9"})
+		response("", nil)
+	}
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "heavy", Group: "batch"}, heavy).
+		Add(tinpot.ActionInfo{Name: "light", Group: "batch"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "heavy", Tenant: "team-b"}, heavy)
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{
+		Tokens:     map[string]string{"a": "", "b": "team-b"},
+		UsageRates: server.UsageRates{Execution: 1, CPUSecond: 0.5, GBSecond: 0.1},
+	}))
+	defer ts.Close()
+	request := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for _, run := range []struct{ action, token string }{{"heavy", "a"}, {"heavy", "a"}, {"light", "a"}, {"heavy", "b"}} {
+		request("POST", "/api/actions/"+run.action+"/sync_execute", run.token, `{"parameters": {}}`).Body.Close()
+	}
+	report := func(query, token string) (int, server.UsageReportResponse) {
+		resp := request("GET", "/api/reports/usage"+query, token, "")
+		defer resp.Body.Close()
+		var report server.UsageReportResponse
+		json.NewDecoder(resp.Body).Decode(&report)
+		return resp.StatusCode, report
+	}
+
+	_, byAction := report("", "a")
+	if len(byAction.Rows) != 2 || byAction.Rows[0].Key != "heavy" || byAction.Rows[0].Executions != 2 || byAction.Rows[0].CPUSeconds != 16 {
+		t.Fatalf("unexpected report: %+v", byAction)
+	}
+	// 1 per execution, 0.5 per CPU second, 0.1 per GB second
+	if cost := byAction.Rows[0].Cost; cost != 2*(1+4+2) {
+		t.Errorf("unexpected cost: %v", cost)
+	}
+	if _, byGroup := report("?group_by=group", "a"); len(byGroup.Rows) != 1 || byGroup.Rows[0].Key != "batch" || byGroup.Total.Executions != 3 {
+		t.Errorf("unexpected group report: %+v", byGroup)
+	}
+	if _, byTenant := report("?group_by=tenant&all_tenants=true", "a"); len(byTenant.Rows) != 2 || byTenant.Total.Executions != 4 {
+		t.Errorf("unexpected tenant report: %+v", byTenant)
+	}
+	if code, _ := report("?all_tenants=true", "b"); code != 403 {
+		t.Errorf("tenant sees all tenants: %d", code)
+	}
+	if _, future := report("?since="+time.Now().Add(time.Hour).Format(time.RFC3339), "a"); future.Total.Executions != 0 {
+		t.Errorf("unexpected report of the future: %+v", future)
+	}
+
+	// The environment is kept with the history
+	resp := request("GET", "/api/executions?action=heavy", "a", "")
+	defer resp.Body.Close()
+	var history []tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&history)
+	if len(history) == 0 || history[0].Environment == nil || history[0].Environment.PythonVersion != "3.11.9" {
+		t.Errorf("environment not recorded: %+v", history)
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	var mu sync.Mutex
+	var received []server.AlertState
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var state server.AlertState
+		json.NewDecoder(r.Body).Decode(&state)
+		mu.Lock()
+		received = append(received, state)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+	webhooks := func() []server.AlertState {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]server.AlertState(nil), received...)
+	}
+
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "backup"}, tinpottest.Fail("disk full")).
+		Add(tinpot.ActionInfo{Name: "sync"}, tinpottest.Echo())
+	events := tinpot.NewEventBus()
+	alerts := make(chan tinpot.ExecutionEvent, 10)
+	events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Alert != "" {
+			alerts <- event
+		}
+	})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Events: events, AlertRules: server.AlertRules{
+		Webhooks: []string{hook.URL},
+		Rules: []server.AlertRule{
+			{Name: "backup failing", Action: "backup", ConsecutiveFailures: 2},
+			{Name: "sync stale", Action: "sync", NoSuccessWithin: "500ms"},
+		},
+	}}))
+	defer ts.Close()
+	run := func(action string) {
+		post(t, ts.URL+"/api/actions/"+action+"/sync_execute", `{"parameters": {}}`).Body.Close()
+	}
+
+	run("backup")
+	run("sync")
+	if len(alerts) != 0 {
+		t.Fatalf("alert fired early: %+v", <-alerts)
+	}
+	run("backup")
+	if event := <-alerts; event.Type != tinpot.EventAlertFiring || event.Alert != "backup failing" || !strings.Contains(event.Message, "disk full") {
+		t.Errorf("unexpected alert: %+v", event)
+	}
+	run("backup")
+	if event := <-alerts; event.Type != tinpot.EventAlertFiring || event.Alert != "sync stale" {
+		t.Errorf("unexpected alert: %+v", event)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("alert fired twice: %+v", <-alerts)
+	}
+	tinpottest.WaitFor(t, func() bool { return len(webhooks()) == 2 })
+
+	resp, err := http.Get(ts.URL + "/api/alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []server.AlertState
+	json.NewDecoder(resp.Body).Decode(&states)
+	resp.Body.Close()
+	if len(states) != 2 || !states[0].Firing || !states[1].Firing || states[0].ConsecutiveFailures+states[1].ConsecutiveFailures != 3 {
+		t.Errorf("unexpected alert states: %+v", states)
+	}
+
+	run("sync")
+	if event := <-alerts; event.Type != tinpot.EventAlertResolved || event.Alert != "sync stale" {
+		t.Errorf("unexpected alert: %+v", event)
+	}
+	tinpottest.WaitFor(t, func() bool { return len(webhooks()) == 3 })
+	if last := webhooks()[2]; last.Firing || last.Rule != "sync stale" {
+		t.Errorf("unexpected resolution: %+v", last)
+	}
+}
+
+func TestCanaryRelease(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "deploy", Canary: &tinpot.CanaryInfo{}}, func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		if params[tinpot.TrackParameter] == tinpot.TrackCanary {
+			response("regression", nil)
+			return
+		}
+		response("", map[string]interface{}{})
+	})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{CanaryTraffic: server.CanaryTraffic{"*": 0}}))
+	defer ts.Close()
+	run := func(track string) int {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/actions/deploy/sync_execute", strings.NewReader(`{"parameters": {}}`))
+		req.Header.Set(server.TrackHeader, track)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := run("beta"); code != 400 {
+		t.Errorf("invalid track accepted: %d", code)
+	}
+	run("")
+	run("")
+	run(tinpot.TrackCanary)
+	resp, err := http.Get(ts.URL + "/api/actions/deploy/canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report server.CanaryReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	stable, canary := report.Tracks[tinpot.TrackStable], report.Tracks[tinpot.TrackCanary]
+	if stable.Executions != 2 || stable.Failures != 0 || canary.Executions != 1 || canary.Failures != 1 || report.FailureRateDelta != 1 {
+		t.Errorf("unexpected canary report: %+v", report)
+	}
+
+	if traffic, err := server.ParseCanaryTraffic("deploy=20, team-a/sync=5%"); err != nil || traffic["deploy"] != 20 || traffic["team-a/sync"] != 5 {
+		t.Errorf("unexpected traffic: %v %v", traffic, err)
+	}
+	if _, err := server.ParseCanaryTraffic("deploy=120"); err == nil {
+		t.Error("out of range traffic accepted")
+	}
+}
+
+func TestMockMode(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "backup", Examples: []tinpot.ActionExample{
+		{Title: "Full", Parameters: map[string]interface{}{"full": true}, Result: map[string]interface{}{"files": 1200.0}},
+		{Title: "Incremental", Parameters: map[string]interface{}{"full": false}, Result: map[string]interface{}{"files": 12.0}},
+	}}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Mock: server.MockConfig{Actions: map[string]server.MockAction{
+		"backup": {},
+		"report": {Action: &tinpot.ActionInfo{Description: "Weekly report"}, Error: "no data", Logs: []string{"Collecting"}},
+	}}}))
+	defer ts.Close()
+	type response struct {
+		server.SyncExecutionResponse
+		Result map[string]interface{} `json:"result"`
+	}
+	run := func(action, body string) response {
+		resp := post(t, ts.URL+"/api/actions/"+action+"/sync_execute", body)
+		defer resp.Body.Close()
+		var result response
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+
+	if result := run("backup", `{"parameters": {"full": false}}`); result.Result["files"] != 12.0 {
+		t.Errorf("unexpected mocked result: %+v", result)
+	}
+	if result := run("backup", `{"parameters": {}}`); result.Result["files"] != 1200.0 {
+		t.Errorf("unexpected fallback result: %+v", result)
+	}
+	result := run("report", `{"parameters": {}}`)
+	if result.Error != "no data" {
+		t.Errorf("declared action not mocked: %+v", result)
+	}
+	resp, err := http.Get(ts.URL + "/api/executions/" + result.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&rec)
+	resp.Body.Close()
+	if rec.Environment == nil || !rec.Environment.Mock {
+		t.Errorf("mocked execution not marked: %+v", rec.Environment)
+	}
+	if len(mgr.Calls("backup")) != 0 {
+		t.Errorf("mocked action triggered: %v", mgr.Calls("backup"))
+	}
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/admin/mock", strings.NewReader(`{}`))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("mock mode not turned off: %v %v", resp, err)
+	}
+	if result := run("backup", `{"parameters": {"full": true}}`); result.Result["full"] != true || len(mgr.Calls("backup")) != 1 {
+		t.Errorf("action still mocked: %+v", result)
+	}
+	if result := run("report", `{"parameters": {}}`); result.Status != "" {
+		t.Errorf("declared action still served: %+v", result)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "deploy", Debounce: 0.5}, tinpottest.Script{Delay: 100 * time.Millisecond, Result: map[string]interface{}{"ok": true}}.Trigger())
+	store := server.NewMemoryStore(0)
+	ts := httptest.NewServer(server.NewServer(mgr, store, server.Options{}))
+	defer ts.Close()
+
+	submit := func(body string) server.ExecutionResponse {
+		resp := post(t, ts.URL+"/api/actions/deploy/execute", body)
+		defer resp.Body.Close()
+		var submitted server.ExecutionResponse
+		json.NewDecoder(resp.Body).Decode(&submitted)
+		return submitted
+	}
+	first := submit(`{"parameters": {"env": "prod"}}`)
+	second := submit(`{"parameters": {"env": "prod"}}`)
+	other := submit(`{"parameters": {"env": "staging"}}`)
+	if second.ExecutionID != first.ExecutionID || second.Status != "coalesced" || other.ExecutionID == first.ExecutionID {
+		t.Errorf("unexpected executions: %+v %+v %+v", first, second, other)
+	}
+
+	// Sync callers within the window share the completed result
+	resp := post(t, ts.URL+"/api/actions/deploy/sync_execute", `{"parameters": {"env": "prod"}}`)
+	var result server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if result.ExecutionID != first.ExecutionID || result.Status != tinpot.StatusSuccess {
+		t.Errorf("unexpected sync result: %+v", result)
+	}
+	if calls := mgr.Calls("deploy"); len(calls) != 2 {
+		t.Errorf("expected 2 executions, got %d", len(calls))
+	}
+	if rec, _ := store.Get(first.ExecutionID); rec.Coalesced != 2 {
+		t.Errorf("expected 2 coalesced requests, got %d", rec.Coalesced)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	if again := submit(`{"parameters": {"env": "prod"}}`); again.ExecutionID == first.ExecutionID {
+		t.Error("coalesced after the window")
+	}
+}
+
+func TestExecutionVisibility(t *testing.T) {
+	users := directory{"alice": {"admins"}, "dave": {"ops"}, "erin": {"ops"}}
+	for scope, erinSees := range map[string]bool{server.VisibilityOwn: false, server.VisibilityGroup: true} {
+		ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{AuthProviders: []server.AuthProvider{users}, Visibility: server.VisibilityPolicy{Scope: scope}}))
+		request := func(method, path, user string, v interface{}) int {
+			req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(`{"parameters": {"message": "hi"}}`))
+			req.SetBasicAuth(user, "secret")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			json.NewDecoder(resp.Body).Decode(v)
+			return resp.StatusCode
+		}
+
+		var result server.ExecutionResponse
+		request("POST", "/api/actions/echo/execute", "dave", &result)
+		for user, sees := range map[string]bool{"dave": true, "erin": erinSees, "alice": true} {
+			var rec tinpot.ExecutionRecord
+			status := request("GET", "/api/executions/"+result.ExecutionID, user, &rec)
+			var records []tinpot.ExecutionRecord
+			request("GET", "/api/executions", user, &records)
+			if (status == 200) != sees || len(records) == 1 != sees || (sees && rec.RequestedBy != "dave") {
+				t.Errorf("%s scope, %s: unexpected visibility: %d %+v %d", scope, user, status, rec, len(records))
+			}
+			if status := request("GET", "/api/executions/"+result.ExecutionID+"/events", user, &struct{}{}); (status == 200) != sees {
+				t.Errorf("%s scope, %s: unexpected stream visibility: %d", scope, user, status)
+			}
+		}
+		ts.Close()
+	}
+}
+
+func TestAPIv1(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(v)
+		return resp.StatusCode
+	}
+
+	var actions server.ListResponse
+	if get("/api/v1/actions", &actions); len(actions.Items) != 1 || actions.Total != 1 || actions.NextCursor != nil {
+		t.Errorf("unexpected actions: %+v", actions)
+	}
+	for i := 0; i < 3; i++ {
+		post(t, ts.URL+"/api/v1/actions/echo/sync_execute", `{"parameters": {"message": "hi"}}`).Body.Close()
+	}
+	var page server.ListResponse
+	get("/api/v1/executions?limit=2", &page)
+	if len(page.Items) != 2 || page.Total != 3 || page.NextCursor == nil {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	get("/api/v1/executions?limit=2&cursor="+url.QueryEscape(*page.NextCursor), &page)
+	if len(page.Items) != 1 || page.Total != 3 || page.NextCursor != nil {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+
+	for path, code := range map[string]string{"/api/v1/executions/missing": "not_found", "/api/v1/executions?limit=x": "invalid_request", "/api/v1/unknown": "not_found"} {
+		var failure server.ErrorResponse
+		if status := get(path, &failure); status < 400 || failure.Code != code || failure.Message == "" || failure.Details == nil {
+			t.Errorf("%s: unexpected error %d %+v", path, status, failure)
+		}
+	}
+	// The unversioned paths keep their responses
+	var records []tinpot.ExecutionRecord
+	if get("/api/executions", &records); len(records) != 3 {
+		t.Errorf("unexpected legacy list: %+v", records)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	var nonce string
+	handler := server.DefaultSecurityHeaders.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = server.CSPNonce(r)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if nonce == "" || !strings.Contains(rec.Header().Get("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+		t.Errorf("nonce %q not in the policy: %s", nonce, rec.Header().Get("Content-Security-Policy"))
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" || rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("unexpected headers: %v", rec.Header())
+	}
+
+	first := nonce
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if nonce == first {
+		t.Error("nonce reused")
+	}
+
+	headers := server.DefaultSecurityHeaders
+	headers.Embeddable = []string{"/embed"}
+	rec = httptest.NewRecorder()
+	headers.Handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/embed", nil))
+	if policy := rec.Header().Get("Content-Security-Policy"); strings.Contains(policy, "frame-ancestors") || !strings.Contains(policy, "script-src") || rec.Header().Get("X-Frame-Options") != "" {
+		t.Errorf("embeddable page not frameable: %v", rec.Header())
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{RequestLimits: server.RequestLimits{MaxBodyBytes: 1000, MaxParameters: 3, MaxDepth: 2}}))
+	defer ts.Close()
+
+	for body, status := range map[string]int{
+		`{"parameters": {"message": "hi", "list": [[1]]}}`:                                      200,
+		`{"parameters": {"message": "` + strings.Repeat("x", 1000) + `"}}`:                      413,
+		`{"parameters": {"a": 1, "b": 2, "c": 3, "d": 4}}`:                                      400,
+		`{"parameters": {"message": "hi", "deep": {"a": [{"b": 1}]}}}`:                          400,
+		`{"parameters": {"deep": ` + strings.Repeat("[", 300) + strings.Repeat("]", 300) + `}}`: 400,
+	} {
+		resp := post(t, ts.URL+"/api/actions/echo/sync_execute", body)
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%.60s: expected %d, got %d", body, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestInvalidPathValues(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+
+	for path, status := range map[string]int{
+		"/api/actions/echo/schema":                       200,
+		"/api/actions/ec%2Fho/schema":                    400,
+		"/api/actions/%23/schema":                        400,
+		"/api/executions/a+b":                            400,
+		"/api/v1/executions/" + strings.Repeat("x", 200): 400,
+		"/api/executions/unknown":                        404,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestReservedParameters(t *testing.T) {
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+
+	// The selector is only taken from the validated request field
+	for _, path := range []string{"/api/actions/echo/execute", "/api/actions/echo/sync_execute"} {
+		resp := post(t, ts.URL+path, `{"parameters": {"message": "hi", "_selector": "zone in (eu"}}`)
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("%s: expected 400 for a reserved parameter, got %d", path, resp.StatusCode)
+		}
+	}
+	resp := post(t, ts.URL+"/api/actions/echo/sync_execute", `{"parameters": {"message": "hi"}}`)
+	var result server.SyncExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	resp = post(t, ts.URL+"/api/executions/"+result.ExecutionID+"/rerun", `{"parameters": {"_selector": "zone=eu"}}`)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("rerun: expected 400 for a reserved parameter, got %d", resp.StatusCode)
+	}
+	for _, body := range []string{`{"parameters": {"_session": "pinned"}}`, `{"parameters": {"_expires_at": "2099-01-01T00:00:00Z"}}`} {
+		resp := post(t, ts.URL+"/api/actions/echo/execute", body)
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("%s: expected 400 for a reserved parameter, got %d", body, resp.StatusCode)
+		}
+	}
+	if calls := mgr.Calls("echo"); len(calls) != 1 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestReservedStepParameters(t *testing.T) {
+	// Workflow steps pass their parameters to the actions, the reserved ones are dropped
+	workflows := map[string]server.Workflow{"pin": {Steps: []server.WorkflowStep{{ID: "echo", Action: "echo", Parameters: map[string]interface{}{
+		"message": "hi", "_session": "pinned", "_expires_at": "2099-01-01T00:00:00Z", "_selector": "zone=eu",
+	}}}}}
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Workflows: workflows}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/workflows/pin/run", `{}`)
+	resp.Body.Close()
+	tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("echo")) == 1 })
+	call := mgr.Calls("echo")[0]
+	if call["message"] != "hi" || call["_session"] != nil || call["_expires_at"] != nil || call["_selector"] != nil || call["_execution_id"] == nil {
+		t.Errorf("unexpected parameters: %v", call)
+	}
+}
+
+func TestQualifiedActionNames(t *testing.T) {
+	// Action names outside of the path reach submit unchecked, e.g. an alert route
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "secret", Tenant: "team-a"}, tinpottest.Echo())
+	config := server.AlertmanagerConfig{Routes: []server.AlertRoute{{Matchers: []string{"alertname=DiskFull"}, Action: "team-a/secret"}}}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Alertmanager: config}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/integrations/alertmanager", `{"status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "DiskFull"}}]}`)
+	var executions []server.AlertExecution
+	json.NewDecoder(resp.Body).Decode(&executions)
+	resp.Body.Close()
+	if len(executions) != 1 || !strings.HasPrefix(executions[0].Error, "400") || len(mgr.Calls("team-a/secret")) != 0 {
+		t.Errorf("action of another tenant executed: %+v", executions)
+	}
+}
+
+func TestExecutionTimeline(t *testing.T) {
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "migrate"}, func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		tinpot.ReportPublish(params, time.Now())
+		go func() {
+			logs("INFO", "step 1")
+			logs(tinpot.LogLevelProgress, `{"current": 1, "total": 2}`)
+			logs(tinpot.LogLevelProgress, `{"current": 2, "total": 2}`)
+			response("", map[string]interface{}{})
+		}()
+	})
+	ts := httptest.NewServer(server.NewServer(actions, nil, server.Options{}))
+	defer ts.Close()
+
+	var result server.ExecutionResponse
+	resp := post(t, ts.URL+"/api/actions/migrate/execute", `{}`)
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	var timeline []server.TimelineEvent
+	tinpottest.WaitFor(t, func() bool {
+		resp, err := http.Get(ts.URL + "/api/executions/" + result.ExecutionID + "/timeline")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&timeline)
+		return len(timeline) > 0 && timeline[len(timeline)-1].Event == server.TimelineCompleted
+	})
+
+	var events []string
+	for i, event := range timeline {
+		events = append(events, event.Event)
+		if i > 0 && (event.Time.Before(timeline[i-1].Time) || event.OffsetSeconds < timeline[i-1].OffsetSeconds) {
+			t.Errorf("%s out of order", event.Event)
+		}
+	}
+	if strings.Join(events, ",") != "submitted,dispatched,published,first_log,progress,progress,completed" {
+		t.Errorf("unexpected timeline: %v", events)
+	}
+	if timeline[5].Progress == nil || timeline[5].Progress.Current != 2 || timeline[6].Status != tinpot.StatusSuccess {
+		t.Errorf("unexpected events: %+v", timeline)
+	}
+}
+
+func TestStreamFiltering(t *testing.T) {
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "check"}, tinpottest.Script{
+		Logs: []tinpottest.LogLine{
+			{Level: "DEBUG", Message: "disk sda: 10%"},
+			{Level: "WARNING", Message: "disk sdb: 85%"},
+			{Level: "INFO", Message: "network ok"},
+			{Level: "ERROR", Message: "disk sdc: 99%"},
+			{Level: "ERROR", Message: "network down"},
+		},
+		Result: map[string]interface{}{},
+	}.Trigger())
+	ts := httptest.NewServer(server.NewServer(actions, nil, server.Options{}))
+	defer ts.Close()
+
+	var result server.ExecutionResponse
+	resp := post(t, ts.URL+"/api/actions/check/execute", `{}`)
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	poll := func(query string) (int, []string) {
+		resp, err := http.Get(ts.URL + "/api/executions/" + result.ExecutionID + "/events?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var batch server.StreamEventsResponse
+		json.NewDecoder(resp.Body).Decode(&batch)
+		var messages []string
+		for _, event := range batch.Events {
+			if event.Type == "log" {
+				messages = append(messages, event.Data.(map[string]interface{})["message"].(string))
+			}
+		}
+		return resp.StatusCode, messages
+	}
+	tinpottest.WaitFor(t, func() bool {
+		_, messages := poll("")
+		return len(messages) == 5
+	})
+
+	for query, expected := range map[string]string{
+		"level=warn":                     "disk sdb: 85%,disk sdc: 99%,network down",
+		"grep=^disk":                     "disk sda: 10%,disk sdb: 85%,disk sdc: 99%",
+		"level=WARNING&grep=disk&tail=1": "disk sdc: 99%",
+		"tail=2":                         "disk sdc: 99%,network down",
+	} {
+		if status, messages := poll(query); status != 200 || strings.Join(messages, ",") != expected {
+			t.Errorf("%s: unexpected logs %d %v", query, status, messages)
+		}
+	}
+	for _, query := range []string{"level=LOUD", "grep=(", "tail=-1"} {
+		if status, _ := poll(query); status != 400 {
+			t.Errorf("%s: expected 400, got %d", query, status)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/executions/"+result.ExecutionID+"/stream?format=ndjson&level=ERROR&grep=disk", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Count(string(body), `"type":"log"`) != 1 || !strings.Contains(string(body), "sdc") || !strings.Contains(string(body), `"type":"complete"`) {
+		t.Errorf("unexpected stream: %s", body)
+	}
+}
+
+func TestCombinedStream(t *testing.T) {
+	actions := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "check"}, tinpottest.Script{
+		Logs:   []tinpottest.LogLine{{Level: "INFO", Message: "checking"}},
+		Result: map[string]interface{}{},
+	}.Trigger())
+	ts := httptest.NewServer(server.NewServer(actions, nil, server.Options{}))
+	defer ts.Close()
+	execute := func() string {
+		var result server.ExecutionResponse
+		resp := post(t, ts.URL+"/api/actions/check/execute", `{}`)
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		return result.ExecutionID
+	}
+	stream := func(query string) (*http.Response, *json.Decoder) {
+		resp, err := http.Get(ts.URL + "/api/stream?format=ndjson&" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, json.NewDecoder(resp.Body)
+	}
+
+	first, second := execute(), execute()
+	resp, decoder := stream("ids=" + first + "," + second + ",unknown")
+	defer resp.Body.Close()
+	completed := map[string]bool{}
+	for {
+		var event map[string]interface{}
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("stream ended without end event: %v", err)
+		}
+		if event["type"] == "connected" && !reflect.DeepEqual(event["missing"], []interface{}{"unknown"}) {
+			t.Errorf("unexpected connected event: %v", event)
+		}
+		if event["type"] == "complete" {
+			completed[event["execution_id"].(string)] = true
+		}
+		if event["type"] == "end" {
+			break
+		}
+	}
+	if !completed[first] || !completed[second] {
+		t.Errorf("missing completions: %v", completed)
+	}
+
+	for query, expected := range map[string]int{"": 400, "ids=a&action=check": 400, "ids=unknown": 404} {
+		resp, _ := stream(query)
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d", query, expected, resp.StatusCode)
+		}
+	}
+
+	resp, decoder = stream("action=check")
+	defer resp.Body.Close()
+	var connected map[string]interface{}
+	decoder.Decode(&connected)
+	third := execute()
+	for {
+		var event server.MultiplexedEvent
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.ExecutionID == third && event.Type == "complete" {
+			break
+		}
+	}
+}
+
+func TestExecutionOrigins(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+	execute := func(path, parent string) (int, string) {
+		req, _ := http.NewRequest("POST", ts.URL+path, strings.NewReader(`{"parameters": {"message": "hi"}}`))
+		if parent != "" {
+			req.Header.Set(server.ParentExecutionHeader, parent)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result server.SyncExecutionResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result.ExecutionID
+	}
+	history := func(query string) []tinpot.ExecutionRecord {
+		resp, err := http.Get(ts.URL + "/api/executions?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var records []tinpot.ExecutionRecord
+		json.NewDecoder(resp.Body).Decode(&records)
+		return records
+	}
+
+	_, parent := execute("/api/actions/echo/sync_execute", "")
+	_, child := execute("/api/actions/echo/sync_execute", parent)
+	_, rerun := execute("/api/executions/"+parent+"/rerun?sync=true", "")
+	if status, _ := execute("/api/actions/echo/sync_execute", "unknown"); status != 400 {
+		t.Errorf("expected 400 for an unknown parent, got %d", status)
+	}
+
+	for query, expected := range map[string]tinpot.ExecutionOrigin{
+		"origin=api":                           {Type: tinpot.OriginAPI},
+		"origin=execution&origin_id=" + parent: {Type: tinpot.OriginExecution, ID: parent},
+		"origin=rerun":                         {Type: tinpot.OriginRerun, ID: parent},
+	} {
+		records := history(query)
+		if len(records) != 1 || records[0].Origin == nil || *records[0].Origin != expected {
+			t.Errorf("%s: unexpected records %+v", query, records)
+		}
+	}
+	if records := history("origin=execution"); len(records) != 1 || records[0].ID != child {
+		t.Errorf("unexpected children: %+v", records)
+	}
+	if records := history("origin=rerun"); len(records) != 1 || records[0].ID != rerun {
+		t.Errorf("unexpected reruns: %+v", records)
+	}
+}
+
+func TestSchedulePolicies(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "slow"}, func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			go func() {
+				<-release
+				response("", map[string]interface{}{})
+			}()
+		}).
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "secret", Tenant: "team-a"}, tinpottest.Echo())
+	file := t.TempDir() + "/schedules.json"
+	saved := `{"schedules": [{"id": "nightly", "schedule": {"action": "echo", "cron": "@daily", "parameters": {"message": "once"}, "catch_up": "once"}, "due": "` +
+		time.Now().Add(-48*time.Hour).Format(time.RFC3339) + `"},
+		{"id": "hourly", "schedule": {"action": "echo", "cron": "@hourly", "parameters": {"message": "skipped"}}, "due": "` +
+		time.Now().Add(-3*time.Hour).Format(time.RFC3339) + `"}]}`
+	if err := os.WriteFile(file, []byte(saved), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{SchedulesFile: file}))
+	defer ts.Close()
+	schedule := func(id string) server.ScheduleResponse {
+		resp, err := http.Get(ts.URL + "/api/schedules/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var schedule server.ScheduleResponse
+		json.NewDecoder(resp.Body).Decode(&schedule)
+		return schedule
+	}
+
+	// Only the schedule catching up runs on startup
+	tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("echo")) > 0 })
+	if calls := mgr.Calls("echo"); len(calls) != 1 || calls[0]["message"] != "once" || !schedule("nightly").LastRun.CatchUp {
+		t.Errorf("unexpected catch-up: %v", calls)
+	}
+	if run := schedule("hourly").LastRun; run != nil {
+		t.Errorf("missed runs not skipped: %+v", run)
+	}
+
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/schedules/slow", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, body := range []string{
+		`{"action": "slow", "cron": "@every 20ms", "overlap": "cancel"}`,
+		`{"action": "slow", "cron": "@every 20ms", "overlap": "wait"}`,
+		`{"action": "team-a/secret", "cron": "@every 20ms"}`,
+		`{"action": "slow", "cron": "@every 20ms", "jitter": "-1s"}`,
+		`{"action": "slow", "cron": "@every 20ms", "catch_up": "all"}`,
+	} {
+		if status := put(body); status != 400 {
+			t.Errorf("%s: expected 400, got %d", body, status)
+		}
+	}
+	if status := put(`{"action": "slow", "cron": "@every 20ms", "overlap": "skip", "jitter": "5ms"}`); status != 201 {
+		t.Fatalf("schedule not created: %d", status)
+	}
+	tinpottest.WaitFor(t, func() bool {
+		run := schedule("slow").LastRun
+		return run != nil && run.Skipped != ""
+	})
+	if calls := mgr.Calls("slow"); len(calls) != 1 {
+		t.Errorf("overlapping runs started: %d", len(calls))
+	}
+
+	data, _ := os.ReadFile(file)
+	if !strings.Contains(string(data), `"overlap":"skip"`) {
+		t.Errorf("schedule not saved: %s", data)
+	}
+}
+
+func TestScheduleQueue(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "slow"}, func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			go func() {
+				<-release
+				response("", map[string]interface{}{})
+			}()
+		})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{}))
+	defer ts.Close()
+	schedule := func() server.ScheduleResponse {
+		resp, err := http.Get(ts.URL + "/api/schedules/slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var schedule server.ScheduleResponse
+		json.NewDecoder(resp.Body).Decode(&schedule)
+		return schedule
+	}
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/schedules/slow", strings.NewReader(`{"action": "slow", "cron": "@every 1s", "overlap": "queue"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("schedule not created: %d", resp.StatusCode)
+	}
+
+	// The runs due while the first execution is running wait, none is dropped
+	tinpottest.WaitFor(t, func() bool { return schedule().Queued >= 2 })
+	if calls := mgr.Calls("slow"); len(calls) != 1 {
+		t.Fatalf("queued runs started early: %d", len(calls))
+	}
+	queued := schedule().Queued
+	// Completing the executions one by one starts the waiting runs in turn
+	for i := 1; i <= queued; i++ {
+		release <- struct{}{}
+		tinpottest.WaitFor(t, func() bool { return len(mgr.Calls("slow")) > i })
+	}
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/api/schedules/slow", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestScheduleAt(t *testing.T) {
+	file := t.TempDir() + "/schedules.json"
+	mgr := tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo())
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{SchedulesFile: file}))
+	defer ts.Close()
+	scheduleAt := func(at time.Time) (int, string) {
+		resp := post(t, ts.URL+"/api/actions/echo/schedule_at", `{"at": "`+at.Format(time.RFC3339Nano)+`", "parameters": {"message": "later"}}`)
+		defer resp.Body.Close()
+		var execution server.ExecutionResponse
+		json.NewDecoder(resp.Body).Decode(&execution)
+		return resp.StatusCode, execution.ExecutionID
+	}
+	record := func(url, id string) tinpot.ExecutionRecord {
+		resp, err := http.Get(url + "/api/executions/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rec tinpot.ExecutionRecord
+		json.NewDecoder(resp.Body).Decode(&rec)
+		return rec
+	}
+
+	if status, _ := scheduleAt(time.Now().Add(-time.Minute)); status != 400 {
+		t.Errorf("expected 400 for a past time, got %d", status)
+	}
+	_, soon := scheduleAt(time.Now().Add(100 * time.Millisecond))
+	_, cancelled := scheduleAt(time.Now().Add(time.Hour))
+	status, kept := scheduleAt(time.Now().Add(time.Hour))
+	if status != 202 {
+		t.Fatalf("execution not scheduled: %d", status)
+	}
+
+	resp := post(t, ts.URL+"/api/executions/"+cancelled+"/cancel", ``)
+	resp.Body.Close()
+	if rec := record(ts.URL, cancelled); resp.StatusCode != 200 || rec.Status != tinpot.StatusCancelled {
+		t.Errorf("execution not cancelled: %d %+v", resp.StatusCode, rec)
+	}
+	tinpottest.WaitFor(t, func() bool { return record(ts.URL, soon).Status == tinpot.StatusSuccess })
+
+	// A restarted coordinator keeps the one still held
+	restarted := httptest.NewServer(server.NewServer(mgr, nil, server.Options{SchedulesFile: file}))
+	defer restarted.Close()
+	if rec := record(restarted.URL, kept); rec.Status != tinpot.StatusPending || rec.NotBefore == nil {
+		t.Errorf("held execution not restored: %+v", rec)
+	}
+	for _, id := range []string{soon, cancelled} {
+		if rec := record(restarted.URL, id); rec.ID != "" {
+			t.Errorf("execution %s restored: %+v", id, rec)
+		}
+	}
+}
+
+func TestScheduleTimezones(t *testing.T) {
+	ts := httptest.NewServer(server.NewServer(echoManager{}, nil, server.Options{}))
+	defer ts.Close()
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/schedules/nightly", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	preview := func(query string) (int, server.ScheduleNextRunsResponse) {
+		resp, err := http.Get(ts.URL + "/api/schedules/nightly/next" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var next server.ScheduleNextRunsResponse
+		json.NewDecoder(resp.Body).Decode(&next)
+		return resp.StatusCode, next
+	}
+
+	if status := put(`{"action": "echo", "cron": "0 3 * * *", "timezone": "Mars/Olympus"}`); status != 400 {
+		t.Errorf("expected 400 for an unknown timezone, got %d", status)
+	}
+	if status := put(`{"action": "echo", "cron": "0 3 * * *", "timezone": "Europe/Budapest", "paused": true}`); status != 201 {
+		t.Fatalf("schedule not created: %d", status)
+	}
+	if status, next := preview(""); status != 200 || len(next.NextRuns) != 5 || next.Timezone != "Europe/Budapest" {
+		t.Errorf("unexpected preview: %d %+v", status, next)
+	}
+	// Every run is at 03:00 local time, on either side of the DST changes
+	budapest, _ := time.LoadLocation("Europe/Budapest")
+	_, next := preview("?count=100")
+	for i, run := range next.NextRuns {
+		if local := run.In(budapest); local.Hour() != 3 || local.Minute() != 0 || (i > 0 && !run.After(next.NextRuns[i-1])) {
+			t.Errorf("unexpected run %d: %v", i, run)
+		}
+	}
+	if status, _ := preview("?count=0"); status != 400 {
+		t.Errorf("expected 400 for count=0, got %d", status)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "off"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "noisy"}, tinpottest.Script{Logs: []tinpottest.LogLine{{Level: "INFO", Message: "12345"}}}.Trigger()).
+		Add(tinpot.ActionInfo{Name: "chatty"}, func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			// The logs of an execution arrive concurrently, like from the MQTT client
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					logs("INFO", "12345")
+				}()
+			}
+			wg.Wait()
+			response("", map[string]interface{}{})
+		})
+	ts := httptest.NewServer(server.NewServer(mgr, nil, server.Options{Quotas: server.QuotaConfig{Actions: map[string]server.QuotaLimits{
+		"echo":   {MaxExecutionsPerHour: 1},
+		"off":    {Disabled: true},
+		"chatty": {MaxLogBytes: 10},
+		"noisy":  {MaxLogBytesPerHour: 10},
+	}}}))
+	defer ts.Close()
+
+	resp := post(t, ts.URL+"/api/actions/echo/execute", `{"parameters": {"message": "hi"}}`)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("first execution rejected: %d", resp.StatusCode)
+	}
+	resp = post(t, ts.URL+"/api/actions/echo/execute", `{"parameters": {"message": "hi"}}`)
+	resp.Body.Close()
+	if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); resp.StatusCode != 429 || retry <= 0 || retry > 3601 {
+		t.Errorf("expected 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	resp = post(t, ts.URL+"/api/actions/off/execute", `{"parameters": {"message": "hi"}}`)
+	resp.Body.Close()
+	if resp.StatusCode != 403 || resp.Header.Get("Retry-After") != "" {
+		t.Errorf("expected 403 for the disabled action, got %d", resp.StatusCode)
+	}
+
+	// Two 5 byte logs fit the cap, the rest is replaced by a single warning
+	resp = post(t, ts.URL+"/api/actions/chatty/execute", `{}`)
+	var submitted server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+	resp, err := http.Get(ts.URL + submitted.StreamURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if logged, warned := strings.Count(string(stream), `"message":"12345"`), strings.Count(string(stream), "Log quota exceeded"); logged != 2 || warned != 1 {
+		t.Errorf("logs not truncated at the cap (%d logged, %d warnings): %s", logged, warned, stream)
+	}
+
+	// The hourly log volume of the action is shared by its executions, the third one is truncated
+	for i, expected := range []bool{false, false, true} {
+		resp = post(t, ts.URL+"/api/actions/noisy/execute", `{}`)
+		json.NewDecoder(resp.Body).Decode(&submitted)
+		resp.Body.Close()
+		resp, err := http.Get(ts.URL + submitted.StreamURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if truncated := strings.Contains(string(stream), "Log quota exceeded"); truncated != expected || truncated == strings.Contains(string(stream), `"message":"12345"`) {
+			t.Errorf("execution %d: unexpected logs: %s", i+1, stream)
+		}
+	}
+	resp, err = http.Get(ts.URL + "/api/quotas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quotas struct {
+		Actions map[string]server.QuotaStatus `json:"actions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&quotas)
+	resp.Body.Close()
+	if usage := quotas.Actions["noisy"].Usage; usage.LogBytes != 10 {
+		t.Errorf("unexpected log usage: %+v", usage)
+	}
+}
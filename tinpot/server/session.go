@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookie identifies the session of a logged in browser
+	SessionCookie = "tinpot_session"
+	// CSRFHeader must carry the session's CSRF token on requests changing state
+	CSRFHeader = "X-CSRF-Token"
+)
+
+type session struct {
+	identity *Identity
+	csrf     string
+	expires  time.Time
+}
+
+type sessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*session
+}
+
+func randomKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (st *sessionStore) start(id *Identity) (string, *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	for key, sess := range st.sessions {
+		if now.After(sess.expires) {
+			delete(st.sessions, key)
+		}
+	}
+	key := randomKey()
+	sess := &session{identity: id, csrf: randomKey(), expires: now.Add(st.ttl)}
+	st.sessions[key] = sess
+	return key, sess
+}
+
+// get returns the live session of the request's cookie, if any
+func (st *sessionStore) get(r *http.Request) *session {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[cookie.Value]
+	if !ok || time.Now().After(sess.expires) {
+		return nil
+	}
+	return sess
+}
+
+func (st *sessionStore) end(r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		st.mu.Lock()
+		delete(st.sessions, cookie.Value)
+		st.mu.Unlock()
+	}
+}
+
+// startSession logs the caller in, setting the session cookie
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, id *Identity) *session {
+	key, sess := s.sessions.start(id)
+	http.SetCookie(w, &http.Cookie{
+		Name: SessionCookie, Value: key, Path: "/", Expires: sess.expires,
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	return sess
+}
+
+func (s *Server) authConfigured() bool {
+	return len(s.opts.Tokens) > 0 || len(s.opts.AuthProviders) > 0
+}
+
+func (s *Server) getLoginMethods(w http.ResponseWriter, r *http.Request) {
+	resp := LoginMethodsResponse{Token: len(s.opts.Tokens) > 0, Redirects: map[string]string{}}
+	for _, p := range s.opts.AuthProviders {
+		if _, ok := p.(PasswordAuthenticator); ok {
+			resp.Password = true
+		}
+		if _, ok := p.(TokenAuthenticator); ok {
+			resp.Token = true
+		}
+		if _, ok := p.(LoginFlow); ok {
+			resp.Redirects[p.Name()] = "/auth/" + p.Name() + "/login"
+		}
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if !s.authConfigured() {
+		writeJSON(w, 400, map[string]string{"detail": "Authentication is not configured"})
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	var id *Identity
+	if req.Token != "" {
+		id = authenticateToken(r.Context(), s.opts.Tokens, s.opts.AuthProviders, req.Token)
+	} else if req.Username != "" {
+		id = authenticatePassword(r.Context(), s.opts.AuthProviders, req.Username, req.Password)
+	}
+	if id == nil {
+		writeJSON(w, 401, map[string]string{"detail": "Invalid credentials"})
+		return
+	}
+	sess := s.startSession(w, r, id)
+	writeJSON(w, 200, meResponse(id, sess.csrf))
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	s.sessions.end(r)
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
+	id := IdentityFromRequest(r)
+	if id == nil {
+		// Open API, everybody may do anything
+		id = &Identity{Subject: "anonymous", Roles: []string{RoleAdmin}}
+	}
+	csrf := ""
+	if sess := s.sessions.get(r); sess != nil {
+		csrf = sess.csrf
+	}
+	writeJSON(w, 200, meResponse(id, csrf))
+}
+
+func meResponse(id *Identity, csrf string) MeResponse {
+	resp := MeResponse{Identity: *id, Permissions: []string{}, CSRFToken: csrf}
+	for _, p := range rolePermissions {
+		if id.HasRole(p.role) {
+			resp.Permissions = append(resp.Permissions, p.permission)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priorities of the execution requests, PriorityNormal when not given
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+var priorities = []string{PriorityLow, PriorityNormal, PriorityHigh}
+
+// SheddingPolicy rejects new executions of a priority with 503 while at least its threshold
+// of executions are in flight (admitted and not completed yet), so the higher priorities still
+// get through when the coordinator is overloaded. Priorities without a threshold are never
+// rejected.
+type SheddingPolicy struct {
+	Thresholds map[string]int
+	// RetryAfter is suggested to the rejected callers (default 30 seconds)
+	RetryAfter time.Duration
+}
+
+// ParseSheddingThresholds parses the thresholds of a SheddingPolicy: "low=50,normal=200"
+func ParseSheddingThresholds(spec string) (map[string]int, error) {
+	thresholds := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		priority, value, _ := strings.Cut(entry, "=")
+		if !slices.Contains(priorities, priority) {
+			return nil, fmt.Errorf("unknown priority: %q", priority)
+		}
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold of %s: %q", priority, value)
+		}
+		thresholds[priority] = threshold
+	}
+	return thresholds, nil
+}
+
+// validPriority tells whether a requested priority is known, empty is PriorityNormal
+func validPriority(priority string) bool {
+	return priority == "" || slices.Contains(priorities, priority)
+}
+
+// shed rejects an execution of the priority if the policy says so
+func (s *Server) shed(priority string) error {
+	if priority == "" {
+		priority = PriorityNormal
+	}
+	threshold, ok := s.opts.LoadShedding.Thresholds[priority]
+	if !ok {
+		return nil
+	}
+	inFlight := s.quotas.inFlight()
+	if inFlight < threshold {
+		return nil
+	}
+	retryAfter := s.opts.LoadShedding.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 30 * time.Second
+	}
+	return &quotaError{
+		status:     http.StatusServiceUnavailable,
+		message:    fmt.Sprintf("Overloaded, %s priority executions are rejected (%d in flight)", priority, inFlight),
+		retryAfter: retryAfter,
+	}
+}
+
+// shedding lists the priorities currently rejected
+func (s *Server) shedding() []string {
+	inFlight := s.quotas.inFlight()
+	rejected := []string{}
+	for _, priority := range priorities {
+		if threshold, ok := s.opts.LoadShedding.Thresholds[priority]; ok && inFlight >= threshold {
+			rejected = append(rejected, priority)
+		}
+	}
+	return rejected
+}
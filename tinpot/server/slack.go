@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// SlackConfig enables the Slack slash command endpoint, /api/integrations/slack. It is
+// authenticated by the Slack signature instead of API tokens.
+type SlackConfig struct {
+	// SigningSecret of the Slack app, the endpoint is disabled without it
+	SigningSecret string
+	// Tenant whose actions the command runs
+	Tenant string
+	// Users are the Slack user ids allowed to run actions, empty allows everyone in the workspace
+	Users []string
+	// NoConfirm runs the actions right away instead of asking for a confirmation
+	NoConfirm bool
+}
+
+// Slack allows 5 messages per response_url, one is kept for the outcome
+const slackResponseBudget = 4
+
+// slackProgressInterval throttles the progress updates
+var slackProgressInterval = 5 * time.Second
+
+// slackInteraction is the part of the interactive component payload used here
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// slackRun is the value of the confirmation button
+type slackRun struct {
+	Action     string                 `json:"action"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// verifySlack checks the request signature of Slack (v0=HMAC-SHA256 of "v0:{timestamp}:{body}")
+func (s *Server) verifySlack(r *http.Request, body []byte) bool {
+	ts, err := strconv.ParseInt(r.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.opts.Slack.SigningSecret))
+	fmt.Fprintf(mac, "v0:%d:%s", ts, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// POST /api/integrations/slack handles the slash command ("/tinpot run clean_cache days=5",
+// "/tinpot list") and the buttons of its confirmations
+func (s *Server) receiveSlack(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Slack.SigningSecret == "" {
+		writeJSON(w, 404, map[string]string{"detail": "The Slack integration is not configured"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil || !s.verifySlack(r, body) {
+		writeJSON(w, 401, map[string]string{"detail": "Invalid Slack signature"})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+
+	if payload := form.Get("payload"); payload != "" {
+		var interaction slackInteraction
+		if err := json.Unmarshal([]byte(payload), &interaction); err != nil || len(interaction.Actions) == 0 {
+			writeJSON(w, 400, map[string]string{"detail": "Invalid interaction payload"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		action := interaction.Actions[0]
+		if action.ActionID != "run" {
+			go postSlack(interaction.ResponseURL, map[string]interface{}{"replace_original": true, "text": "Cancelled."})
+			return
+		}
+		var run slackRun
+		json.Unmarshal([]byte(action.Value), &run)
+		// The button value comes back from Slack, the action is checked again
+		if !tinpot.ValidName(run.Action) {
+			go postSlack(interaction.ResponseURL, map[string]interface{}{"replace_original": true, "text": fmt.Sprintf("Invalid action: %s", run.Action)})
+			return
+		}
+		if !s.slackUserAllowed(interaction.User.ID) {
+			go postSlack(interaction.ResponseURL, map[string]interface{}{"replace_original": true, "text": "You are not allowed to run actions."})
+			return
+		}
+		go s.runSlack(run, interaction.User.ID, interaction.ResponseURL, true)
+		return
+	}
+
+	writeJSON(w, 200, s.slackCommand(form))
+}
+
+func (s *Server) slackUserAllowed(user string) bool {
+	return len(s.opts.Slack.Users) == 0 || slices.Contains(s.opts.Slack.Users, user)
+}
+
+// slackCommand answers a slash command
+func (s *Server) slackCommand(form url.Values) map[string]interface{} {
+	ephemeral := func(text string) map[string]interface{} {
+		return map[string]interface{}{"response_type": "ephemeral", "text": text}
+	}
+	args := splitArgs(form.Get("text"))
+	if len(args) == 0 || args[0] == "help" {
+		return ephemeral(fmt.Sprintf("Usage: `%s list` or `%s run <action> [name=value ...]`", form.Get("command"), form.Get("command")))
+	}
+	tenant := s.opts.Slack.Tenant
+	switch args[0] {
+	case "list":
+		var lines []string
+		for _, act := range s.mgr.ListActions() {
+			if act.Tenant == tenant {
+				lines = append(lines, fmt.Sprintf("• `%s` %s", act.Name, act.Description))
+			}
+		}
+		sort.Strings(lines)
+		return ephemeral(strings.Join(lines, "\n"))
+	case "run":
+		if len(args) < 2 {
+			return ephemeral("Missing action name")
+		}
+		if !s.slackUserAllowed(form.Get("user_id")) {
+			return ephemeral("You are not allowed to run actions.")
+		}
+		run := slackRun{Action: args[1], Parameters: make(map[string]interface{})}
+		if !tinpot.ValidName(run.Action) {
+			return ephemeral(fmt.Sprintf("Invalid action: %s", run.Action))
+		}
+		if _, ok := s.mgr.ListActions()[tinpot.QualifiedName(tenant, run.Action)]; !ok {
+			return ephemeral(fmt.Sprintf("Action not found: %s", run.Action))
+		}
+		for _, arg := range args[2:] {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return ephemeral(fmt.Sprintf("Invalid parameter %q, expected name=value", arg))
+			}
+			// Numbers, booleans and JSON values are typed, anything else is a string
+			var typed interface{}
+			if json.Unmarshal([]byte(value), &typed) != nil {
+				typed = value
+			}
+			run.Parameters[name] = typed
+		}
+		if s.opts.Slack.NoConfirm {
+			go s.runSlack(run, form.Get("user_id"), form.Get("response_url"), false)
+			return map[string]interface{}{"response_type": "in_channel", "text": fmt.Sprintf("<@%s> runs `%s`", form.Get("user_id"), run.Action)}
+		}
+		value, _ := json.Marshal(run)
+		question := fmt.Sprintf("Run `%s`%s?", run.Action, formatSlackParameters(run.Parameters))
+		return map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          question,
+			"blocks": []interface{}{
+				map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": question}},
+				map[string]interface{}{"type": "actions", "elements": []interface{}{
+					map[string]interface{}{"type": "button", "action_id": "run", "style": "primary", "value": string(value), "text": map[string]string{"type": "plain_text", "text": "Run"}},
+					map[string]interface{}{"type": "button", "action_id": "cancel", "text": map[string]string{"type": "plain_text", "text": "Cancel"}},
+				}},
+			},
+		}
+	}
+	return ephemeral(fmt.Sprintf("Unknown command %q, try `%s help`", args[0], form.Get("command")))
+}
+
+// runSlack executes the action and posts its progress and outcome to the response URL
+func (s *Server) runSlack(run slackRun, user, responseURL string, replace bool) {
+	tenant := s.opts.Slack.Tenant
+	rec := &responseRecorder{header: make(http.Header)}
+	s.submit(rec, submission{
+		tenant:     tenant,
+		action:     run.Action,
+		parameters: run.Parameters,
+		origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginSlack, ID: user},
+	}, false)
+	if rec.status >= 300 {
+		var detail map[string]interface{}
+		json.Unmarshal(rec.body.Bytes(), &detail)
+		postSlack(responseURL, map[string]interface{}{"replace_original": replace, "text": fmt.Sprintf(":x: `%s` was not started: %v", run.Action, detail["detail"])})
+		return
+	}
+	var started ExecutionResponse
+	json.Unmarshal(rec.body.Bytes(), &started)
+	id := started.ExecutionID
+
+	events := make(chan tinpot.ExecutionEvent, 16)
+	unsubscribe := s.events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.ExecutionID == id && (event.Type == tinpot.EventProgress || event.Type == tinpot.EventCompleted) {
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	})
+	defer unsubscribe()
+
+	running := fmt.Sprintf(":hourglass_flowing_sand: <@%s> runs `%s`%s (execution %s)", user, run.Action, formatSlackParameters(run.Parameters), id)
+	postSlack(responseURL, map[string]interface{}{"replace_original": replace, "text": running})
+	budget := slackResponseBudget - 1
+	var lastUpdate time.Time
+	for {
+		select {
+		case event := <-events:
+			if event.Type == tinpot.EventProgress {
+				if budget > 0 && time.Since(lastUpdate) >= slackProgressInterval {
+					budget--
+					lastUpdate = time.Now()
+					postSlack(responseURL, map[string]interface{}{"replace_original": true, "text": running + "\n" + formatProgress(event.Progress)})
+				}
+				continue
+			}
+		case <-time.After(time.Second):
+			// The execution may have completed before the subscription
+		}
+		record, err := s.store.Get(id)
+		if err != nil || !record.Done() {
+			continue
+		}
+		outcome := fmt.Sprintf(":white_check_mark: `%s` succeeded (execution %s)", run.Action, id)
+		if record.Status != tinpot.StatusSuccess {
+			outcome = fmt.Sprintf(":x: `%s` %s (execution %s): %s", run.Action, strings.ToLower(record.Status), id, record.Error)
+		} else if len(record.Result) > 0 {
+			result, _ := json.MarshalIndent(record.Result, "", "  ")
+			outcome += "\n```" + string(result) + "```"
+		}
+		postSlack(responseURL, map[string]interface{}{"response_type": "in_channel", "replace_original": false, "text": outcome})
+		return
+	}
+}
+
+func formatSlackParameters(parameters map[string]interface{}) string {
+	if len(parameters) == 0 {
+		return ""
+	}
+	var args []string
+	for _, name := range slices.Sorted(maps.Keys(parameters)) {
+		value, _ := json.Marshal(parameters[name])
+		args = append(args, fmt.Sprintf("%s=%s", name, value))
+	}
+	return " with `" + strings.Join(args, " ") + "`"
+}
+
+func formatProgress(p *tinpot.Progress) string {
+	if p == nil {
+		return ""
+	}
+	text := fmt.Sprintf("%g", p.Current)
+	if p.Total > 0 {
+		text = fmt.Sprintf("%.0f%%", 100*p.Current/p.Total)
+	}
+	if p.Message != "" {
+		text += " " + p.Message
+	}
+	return text
+}
+
+// splitArgs splits a command line at spaces, except in double quoted parts
+func splitArgs(line string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, started := false, false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted, started = !quoted, true
+		case (c == ' ' || c == '\t') && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+func postSlack(responseURL string, message map[string]interface{}) {
+	body, _ := json.Marshal(message)
+	resp, err := http.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to post to Slack: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Failed to post to Slack: %s", resp.Status)
+	}
+}
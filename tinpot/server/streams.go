@@ -0,0 +1,346 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// executionStream keeps the recent events of an execution in a ring buffer, so several
+// SSE clients can follow it and reconnecting ones resume after their Last-Event-ID
+type executionStream struct {
+	tenant string
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	ring    []bufferedEvent
+	start   int   // index of the oldest buffered event
+	count   int   // number of buffered events
+	lastID  int64 // ID of the latest event, IDs start at 1
+	dropped int   // events overwritten because the buffer was full
+	expired int   // events removed because they were older than maxAge
+	closed  bool
+	notify  chan struct{} // closed and replaced on every change
+}
+
+type bufferedEvent struct {
+	id    int64
+	at    time.Time
+	event StreamEvent
+}
+
+func (s *Server) registerStream(id string, tenant string) *executionStream {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	stream := &executionStream{
+		tenant: tenant,
+		maxAge: s.opts.StreamBufferAge,
+		ring:   make([]bufferedEvent, s.opts.StreamBufferSize),
+		notify: make(chan struct{}),
+	}
+	s.streams[id] = stream
+	return stream
+}
+
+// send buffers an event without blocking the execution, overwriting the oldest one when full
+func (e *executionStream) send(event StreamEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	e.expire(now)
+	if e.count == len(e.ring) {
+		e.start = (e.start + 1) % len(e.ring)
+		e.count--
+		e.dropped++
+	}
+	e.lastID++
+	e.ring[(e.start+e.count)%len(e.ring)] = bufferedEvent{id: e.lastID, at: now, event: event}
+	e.count++
+	e.changed()
+}
+
+// expire removes the events older than maxAge
+func (e *executionStream) expire(now time.Time) {
+	for e.maxAge > 0 && e.count > 0 && now.Sub(e.ring[e.start].at) > e.maxAge {
+		e.start = (e.start + 1) % len(e.ring)
+		e.count--
+		e.expired++
+	}
+}
+
+func (e *executionStream) changed() {
+	close(e.notify)
+	e.notify = make(chan struct{})
+}
+
+// after returns the buffered events following the ID, whether the stream is closed, and a
+// channel closed on the next change
+func (e *executionStream) after(id int64) ([]bufferedEvent, bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expire(time.Now())
+	var events []bufferedEvent
+	for i := 0; i < e.count; i++ {
+		if event := e.ring[(e.start+i)%len(e.ring)]; event.id > id {
+			events = append(events, event)
+		}
+	}
+	return events, e.closed, e.notify
+}
+
+func (e *executionStream) stats() StreamStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expire(time.Now())
+	return StreamStats{LastEventID: e.lastID, Buffered: e.count, Dropped: e.dropped, Expired: e.expired}
+}
+
+// stream returns the execution stream only if it belongs to the tenant
+func (s *Server) stream(id string, tenant string) *executionStream {
+	s.streamsMu.RLock()
+	defer s.streamsMu.RUnlock()
+	stream := s.streams[id]
+	if stream == nil || stream.tenant != tenant {
+		return nil
+	}
+	return stream
+}
+
+// closeStream ends the stream; it stays readable for the retention period so
+// clients can still catch up with the buffered events.
+func (s *Server) closeStream(id string, stream *executionStream) {
+	stream.mu.Lock()
+	stream.closed = true
+	stream.changed()
+	stream.mu.Unlock()
+	time.AfterFunc(s.opts.StreamRetention, func() {
+		s.streamsMu.Lock()
+		defer s.streamsMu.Unlock()
+		delete(s.streams, id)
+	})
+}
+
+// streamLogs sends the buffered and new events of an execution, each with its ID. Clients
+// resume after the Last-Event-ID header (sent by EventSource on reconnect) or the
+// last_event_id query parameter. With ?format=ndjson (or Accept: application/x-ndjson) the
+// events are newline-delimited JSON objects with their "id" instead of SSE. The log events
+// are filtered by the level, grep and tail parameters, see parseLogFilter.
+func (s *Server) streamLogs(w http.ResponseWriter, r *http.Request) {
+	execID := r.PathValue("id")
+
+	stream := s.visibleStream(r, execID)
+	if stream == nil {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var last int64
+	if lastID != "" {
+		var err error
+		if last, err = strconv.ParseInt(lastID, 10, 64); err != nil {
+			writeJSON(w, 400, map[string]string{"detail": "Invalid Last-Event-ID"})
+			return
+		}
+	}
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	ndjson := r.URL.Query().Get("format") == "ndjson" || acceptsNDJSON(r)
+
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Connection", "keep-alive")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// For http.ResponseWriter, we check if it supports flushing
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	// Send connected
+	encoded, _ := json.Marshal(map[string]string{"type": "connected", "execution_id": execID})
+	if ndjson {
+		fmt.Fprintf(w, "%s\n", encoded)
+	} else {
+		fmt.Fprintf(w, "data: %s\n\n", encoded)
+	}
+	flusher.Flush()
+
+	ctx := r.Context()
+	for buffered := true; ; buffered = false {
+		events, closed, changed := stream.after(last)
+		if len(events) > 0 {
+			// Filtered events are skipped for good, a reconnecting client resumes after them
+			last = events[len(events)-1].id
+		}
+		for _, event := range filter.apply(events, buffered) {
+			if ndjson {
+				bytes, _ := json.Marshal(StreamEventWithID{ID: event.id, StreamEvent: event.event})
+				fmt.Fprintf(w, "%s\n", bytes)
+			} else {
+				bytes, _ := json.Marshal(event.event)
+				fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.id, bytes)
+			}
+		}
+		flusher.Flush()
+		if closed {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollEvents returns the buffered events after the "since" cursor (0 by default), for clients
+// behind proxies that break SSE. The batch is in order and ends at the returned cursor. The
+// log events are filtered like those of streamLogs, tail applying to the first batch.
+func (s *Server) pollEvents(w http.ResponseWriter, r *http.Request) {
+	stream := s.visibleStream(r, r.PathValue("id"))
+	if stream == nil {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	var since int64
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil || since < 0 {
+			writeJSON(w, 400, map[string]string{"detail": "Invalid since cursor"})
+			return
+		}
+	}
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	// No events follow a closed stream, so the batch of a closed one is the last
+	events, closed, _ := stream.after(since)
+	resp := StreamEventsResponse{Events: []StreamEventWithID{}, Cursor: since}
+	for _, event := range filter.apply(events, since == 0) {
+		resp.Events = append(resp.Events, StreamEventWithID{ID: event.id, StreamEvent: event.event})
+	}
+	if len(events) > 0 {
+		resp.Missed = events[0].id - since - 1
+		resp.Cursor = events[len(events)-1].id
+	}
+	resp.Closed = closed
+	writeJSON(w, 200, resp)
+}
+
+// acceptsNDJSON tells whether the client asked for newline-delimited JSON
+func acceptsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamSync writes the log and progress events of a sync execution as newline-delimited
+// JSON while it runs, then its result as a "result" event with a SyncExecutionResponse
+func (s *Server) streamSync(w http.ResponseWriter, execID, actionName string, stream *executionStream) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// Without a stream, of an execution whose events expired, only the result is sent
+	var last int64
+	for stream != nil {
+		events, closed, changed := stream.after(last)
+		for _, event := range events {
+			if event.event.Type != "complete" {
+				encoder.Encode(event.event)
+			}
+			last = event.id
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if closed {
+			break
+		}
+		<-changed
+	}
+
+	result := SyncExecutionResponse{ExecutionID: execID, ActionName: actionName}
+	if rec, err := s.store.Get(execID); err == nil {
+		result.Status, result.Result, result.Error = rec.Status, rec.Result, rec.Error
+	}
+	encoder.Encode(StreamEvent{Type: "result", Data: result})
+}
+
+// streamEvents sends the coordinator-wide events of the caller's tenant: execution lifecycle,
+// catalog changes and worker presence. Log and progress events stay on the execution streams.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	tenant := TenantFromRequest(r)
+	visible := tinpot.ExecutionFilter{Tenant: tenant}
+	s.visibilityFilter(r, &visible)
+
+	// A slow client loses events instead of holding up the publishers
+	events := make(chan tinpot.ExecutionEvent, 100)
+	unsubscribe := s.events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Tenant != tenant || event.Type == tinpot.EventLog || event.Type == tinpot.EventProgress {
+			return
+		}
+		if event.ExecutionID != "" && visible.RequestedBy != "" {
+			if rec, err := s.store.Get(event.ExecutionID); err != nil || !visible.Match(&rec) {
+				return
+			}
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "data: {\"type\":\"connected\"}\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	ctx := r.Context()
+	for {
+		select {
+		case event := <-events:
+			bytes, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", bytes)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+type identityContextKey struct{}
+
+// ParseAPITokens parses a token to tenant binding list: "token1:teamA,token2:teamB".
+// A token without tenant ("token3:") belongs to the default tenant.
+func ParseAPITokens(spec string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		token, tenant, _ := strings.Cut(entry, ":")
+		if err := tinpot.ValidTenant(tenant); err != nil {
+			return nil, err
+		}
+		tokens[token] = tenant
+	}
+	return tokens, nil
+}
+
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	// Low-code tools usually set API keys as a plain header
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	// EventSource can't send headers, so the stream endpoints accept a query parameter too
+	return r.URL.Query().Get("access_token")
+}
+
+// tenantMiddleware resolves the identity (and so the tenant) of API requests from their
+// credentials or session and checks its role
+func tenantMiddleware(tokens map[string]string, providers []AuthProvider, sessions *sessionStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(tokens) == 0 && len(providers) == 0 || !strings.HasPrefix(r.URL.Path, "/api/") || r.Method == "OPTIONS" ||
+			r.URL.Path == "/api/login" || r.URL.Path == "/api/logout" || r.URL.Path == "/api/ui-config" ||
+			r.URL.Path == "/api/integrations/slack" || r.URL.Path == "/api/integrations/git" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var id *Identity
+		if sess := sessions.get(r); sess != nil && r.Header.Get("Authorization") == "" && requestToken(r) == "" {
+			// Browsers send the cookie with cross-site requests too
+			if r.Method != "GET" && r.Method != "HEAD" && r.Header.Get(CSRFHeader) != sess.csrf {
+				writeJSON(w, 403, map[string]string{"detail": "Missing or invalid CSRF token"})
+				return
+			}
+			id = sess.identity
+		} else {
+			id = authenticate(r, tokens, providers)
+		}
+		if id == nil {
+			writeJSON(w, 401, map[string]string{"detail": "Invalid or missing API token"})
+			return
+		}
+		if role := requiredRole(r); !id.HasRole(role) {
+			writeJSON(w, 403, map[string]string{"detail": fmt.Sprintf("Requires the %s role", role)})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, id)))
+	})
+}
+
+// TenantFromRequest returns the tenant resolved from the request's credentials
+func TenantFromRequest(r *http.Request) string {
+	if id := IdentityFromRequest(r); id != nil {
+		return id.Tenant
+	}
+	return ""
+}
+
+// IdentityFromRequest returns the authenticated caller, nil when the API is open
+func IdentityFromRequest(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityContextKey{}).(*Identity)
+	return id
+}
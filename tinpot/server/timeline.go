@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// Events of the execution timeline, see TimelineEvent
+const (
+	TimelineSubmitted = "submitted"
+	// TimelineDispatched is when the coordinator handed the execution to the action manager
+	TimelineDispatched = "dispatched"
+	TimelinePublished  = "published"
+	// TimelineAccepted is when the worker acknowledged the request
+	TimelineAccepted  = "accepted"
+	TimelineFirstLog  = "first_log"
+	TimelineProgress  = "progress"
+	TimelineCompleted = "completed"
+)
+
+// TimelineEvent is a step of the lifecycle of an execution, see GET /api/executions/{id}/timeline
+type TimelineEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// OffsetSeconds is the time since the submission
+	OffsetSeconds float64 `json:"offset_seconds"`
+	// Progress of the progress events
+	Progress *tinpot.Progress `json:"progress,omitempty"`
+	// Status of the completed event
+	Status string `json:"status,omitempty"`
+}
+
+// timeline lists the lifecycle events of the execution in order. Those the execution did not
+// go through (yet), or its action manager does not report, are left out.
+func timeline(rec tinpot.ExecutionRecord) []TimelineEvent {
+	events := []TimelineEvent{{Event: TimelineSubmitted, Time: rec.SubmittedAt}}
+	add := func(event string, at *time.Time) {
+		if at != nil {
+			events = append(events, TimelineEvent{Event: event, Time: *at})
+		}
+	}
+	add(TimelineDispatched, rec.StartedAt)
+	add(TimelinePublished, rec.PublishedAt)
+	add(TimelineAccepted, rec.AcceptedAt)
+	add(TimelineFirstLog, rec.FirstLogAt)
+	for _, point := range rec.ProgressPoints {
+		progress := point.Progress
+		events = append(events, TimelineEvent{Event: TimelineProgress, Time: point.At, Progress: &progress})
+	}
+	if rec.CompletedAt != nil {
+		events = append(events, TimelineEvent{Event: TimelineCompleted, Time: *rec.CompletedAt, Status: rec.Status})
+	}
+	// Stable, so the events of the same instant keep the order of the lifecycle
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	for i := range events {
+		events[i].OffsetSeconds = events[i].Time.Sub(rec.SubmittedAt).Seconds()
+	}
+	return events
+}
+
+// getTimeline returns the lifecycle events of an execution with their times, for analyzing
+// where the latency of the pipeline goes
+func (s *Server) getTimeline(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.visibleRecord(r, r.PathValue("id"))
+	if err == tinpot.ErrExecutionNotFound {
+		writeJSON(w, 404, map[string]string{"detail": "Execution not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, 200, timeline(rec))
+}
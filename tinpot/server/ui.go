@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Authentication modes reported to the web interface
+const (
+	// AuthModeOpen needs no login, the API is open
+	AuthModeOpen = "open"
+	// AuthModeRedirect logs in through a LoginFlow provider, e.g. OIDC
+	AuthModeRedirect = "redirect"
+	// AuthModePassword logs in with a username and password
+	AuthModePassword = "password"
+	// AuthModeToken logs in with an API token
+	AuthModeToken = "token"
+)
+
+// UIConfig lets the web interface adapt to the deployment without rebuilding it. It is
+// served on /api/ui-config (without authentication) and injected into the pages as
+// window.TINPOT_CONFIG.
+type UIConfig struct {
+	Title   string `json:"title"`
+	LogoURL string `json:"logo_url,omitempty"`
+	// BasePath is where the coordinator is mounted, e.g. behind a reverse proxy
+	BasePath string `json:"base_path"`
+	// Features switches parts of the interface on or off, missing ones are on
+	Features map[string]bool `json:"features"`
+	// AuthMode is filled in by the server, see the AuthMode constants
+	AuthMode string `json:"auth_mode"`
+}
+
+// ParseUIFeatures parses feature flags: "execute=false,wallboard" (a flag alone is on)
+func ParseUIFeatures(spec string) (map[string]bool, error) {
+	features := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		enabled := true
+		if ok {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid feature flag %q", entry)
+			}
+		}
+		features[name] = enabled
+	}
+	return features, nil
+}
+
+// UIConfig returns the configuration of the web interface
+func (s *Server) UIConfig() UIConfig {
+	config := s.opts.UI
+	if config.Title == "" {
+		config.Title = "Tinpot"
+	}
+	if config.Features == nil {
+		config.Features = map[string]bool{}
+	}
+	config.AuthMode = AuthModeOpen
+	if s.authConfigured() {
+		// In the order the dashboard prefers them
+		config.AuthMode = AuthModeToken
+		for _, p := range s.opts.AuthProviders {
+			if _, ok := p.(PasswordAuthenticator); ok {
+				config.AuthMode = AuthModePassword
+			}
+		}
+		for _, p := range s.opts.AuthProviders {
+			if _, ok := p.(LoginFlow); ok {
+				config.AuthMode = AuthModeRedirect
+			}
+		}
+	}
+	return config
+}
+
+func (s *Server) getUIConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, s.UIConfig())
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// Visibility scopes of the executions, see VisibilityPolicy
+const (
+	// VisibilityAll shows every execution of the caller's tenant
+	VisibilityAll = "all"
+	// VisibilityGroup shows the executions requested by the caller or a member of one of their groups
+	VisibilityGroup = "group"
+	// VisibilityOwn shows the executions requested by the caller
+	VisibilityOwn = "own"
+)
+
+// VisibilityPolicy limits the executions of their tenant the callers may see, follow and act
+// on. Executions requested without authentication or started by the coordinator itself
+// (schedules, workflows, integrations) are only visible to the Role.
+type VisibilityPolicy struct {
+	// Scope is VisibilityAll (the default), VisibilityGroup or VisibilityOwn
+	Scope string
+	// Role sees every execution of the tenant (default admin)
+	Role string
+}
+
+// Validate checks the scope and the role
+func (p VisibilityPolicy) Validate() error {
+	if p.Scope != "" && !slices.Contains([]string{VisibilityAll, VisibilityGroup, VisibilityOwn}, p.Scope) {
+		return fmt.Errorf("unknown visibility scope: %q", p.Scope)
+	}
+	if p.Role != "" && roleRanks[p.Role] == 0 {
+		return fmt.Errorf("unknown role: %q", p.Role)
+	}
+	return nil
+}
+
+// requester returns the subject and groups of the authenticated caller
+func requester(r *http.Request) (string, []string) {
+	if id := IdentityFromRequest(r); id != nil {
+		return id.Subject, id.Groups
+	}
+	return "", nil
+}
+
+// visibilityFilter narrows an execution filter to what the caller may see
+func (s *Server) visibilityFilter(r *http.Request, filter *tinpot.ExecutionFilter) {
+	id := IdentityFromRequest(r)
+	if id == nil || s.opts.Visibility.Scope == "" || s.opts.Visibility.Scope == VisibilityAll || id.HasRole(s.opts.Visibility.Role) {
+		return
+	}
+	// The subject never matches the executions of the open API
+	filter.RequestedBy = id.Subject
+	if s.opts.Visibility.Scope == VisibilityGroup {
+		filter.RequesterGroups = id.Groups
+	}
+}
+
+// visibleRecord returns the execution record only if it belongs to the caller's tenant and
+// the visibility policy shows it to them
+func (s *Server) visibleRecord(r *http.Request, id string) (tinpot.ExecutionRecord, error) {
+	rec, err := s.record(id, TenantFromRequest(r))
+	if err != nil {
+		return rec, err
+	}
+	filter := tinpot.ExecutionFilter{Tenant: rec.Tenant, Archived: tinpot.IncludeArchived}
+	s.visibilityFilter(r, &filter)
+	if !filter.Match(&rec) {
+		return tinpot.ExecutionRecord{}, tinpot.ErrExecutionNotFound
+	}
+	return rec, nil
+}
+
+// visibleStream returns the event stream of an execution the caller may see
+func (s *Server) visibleStream(r *http.Request, id string) *executionStream {
+	if _, err := s.visibleRecord(r, id); err != nil {
+		return nil
+	}
+	return s.stream(id, TenantFromRequest(r))
+}
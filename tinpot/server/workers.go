@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// recordTelemetry keeps the recent telemetry samples reported by the workers
+func (s *Server) recordTelemetry(event tinpot.ExecutionEvent) {
+	if event.Type != tinpot.EventWorkerTelemetry || event.Telemetry == nil {
+		return
+	}
+	key := tinpot.QualifiedName(event.Tenant, event.Worker)
+	s.telemetryMu.Lock()
+	defer s.telemetryMu.Unlock()
+	samples := append(s.telemetry[key], *event.Telemetry)
+	if len(samples) > s.opts.TelemetrySamples {
+		samples = samples[len(samples)-s.opts.TelemetrySamples:]
+	}
+	s.telemetry[key] = samples
+}
+
+// recordPresence tracks the connected workers for the readiness check
+func (s *Server) recordPresence(event tinpot.ExecutionEvent) {
+	key := tinpot.QualifiedName(event.Tenant, event.Worker)
+	switch event.Type {
+	case tinpot.EventWorkerConnected:
+		s.telemetryMu.Lock()
+		s.online[key] = true
+		s.telemetryMu.Unlock()
+	case tinpot.EventWorkerDisconnected:
+		s.telemetryMu.Lock()
+		delete(s.online, key)
+		s.telemetryMu.Unlock()
+	}
+}
+
+func (s *Server) onlineWorkers() int {
+	s.telemetryMu.RLock()
+	defer s.telemetryMu.RUnlock()
+	return len(s.online)
+}
+
+func (s *Server) getTelemetry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.telemetryMu.RLock()
+	samples, ok := s.telemetry[tinpot.QualifiedName(TenantFromRequest(r), id)]
+	samples = append([]tinpot.MqttWorkerTelemetry(nil), samples...)
+	s.telemetryMu.RUnlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": "No telemetry for worker: " + id})
+		return
+	}
+	writeJSON(w, 200, WorkerTelemetryResponse{Worker: id, Samples: samples})
+}
+
+// recordLoadErrors keeps the latest load errors reported by the workers
+func (s *Server) recordLoadErrors(event tinpot.ExecutionEvent) {
+	if event.Type != tinpot.EventWorkerLoadErrors {
+		return
+	}
+	loadErrors := event.LoadErrors
+	if loadErrors == nil {
+		loadErrors = []tinpot.MqttLoadError{}
+	}
+	s.telemetryMu.Lock()
+	defer s.telemetryMu.Unlock()
+	s.loadErrors[tinpot.QualifiedName(event.Tenant, event.Worker)] = loadErrors
+}
+
+func (s *Server) getLoadErrors(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.telemetryMu.RLock()
+	loadErrors, ok := s.loadErrors[tinpot.QualifiedName(TenantFromRequest(r), id)]
+	s.telemetryMu.RUnlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": "No load errors reported by worker: " + id})
+		return
+	}
+	writeJSON(w, 200, WorkerLoadErrorsResponse{Worker: id, Errors: loadErrors})
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseWorkflow reads a workflow definition in YAML or JSON, rejecting unknown fields
+func ParseWorkflow(data []byte) (Workflow, error) {
+	var wf Workflow
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return wf, err
+	}
+	// Through JSON, so the definitions have the same fields as the API types
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return wf, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(converted))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&wf); err != nil {
+		return wf, fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return wf, nil
+}
+
+// LoadWorkflows reads the *.yaml, *.yml and *.json workflow definitions of a directory,
+// named after the files. Definitions in subdirectories belong to the tenant named after
+// the directory, e.g. team-a/deploy.yaml is the deploy workflow of team-a.
+func LoadWorkflows(dir string) (map[string]Workflow, error) {
+	workflows := make(map[string]Workflow)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, ext))
+		if strings.Count(name, "/") > 1 {
+			return fmt.Errorf("%s: workflows are nested one tenant directory deep at most", rel)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		wf, err := ParseWorkflow(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		workflows[name] = wf
+		return nil
+	})
+	return workflows, err
+}
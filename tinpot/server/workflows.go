@@ -0,0 +1,763 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+const (
+	// StatusSkipped is the state of the workflow steps whose dependencies failed
+	StatusSkipped = "SKIPPED"
+	// StatusWaiting is the state of the manual steps until they are completed
+	StatusWaiting = "WAITING"
+)
+
+// WorkflowStepManual is the type of the steps completed by a user instead of an action
+const WorkflowStepManual = "manual"
+
+type workflowRun struct {
+	tenant   string
+	workflow Workflow
+
+	mu    sync.Mutex
+	run   WorkflowRun
+	tasks map[string]chan struct{} // closed when the waiting manual step is completed
+}
+
+// snapshot copies the run for a response
+func (r *workflowRun) snapshot() WorkflowRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := r.run
+	run.Steps = slices.Clone(r.run.Steps)
+	for i := range run.Steps {
+		run.Steps[i].Items = slices.Clone(run.Steps[i].Items)
+	}
+	return run
+}
+
+// validateWorkflow checks the step references, the expressions and the parameters against
+// the actions of the tenant. It returns the problems found.
+func (s *Server) validateWorkflow(tenant string, wf Workflow) []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if len(wf.Steps) == 0 {
+		report("the workflow has no steps")
+	}
+	inputs := make(map[string]bool, len(wf.Inputs))
+	for _, name := range slices.Sorted(maps.Keys(wf.Inputs)) {
+		if p := wf.Inputs[name]; p.Default != nil && !tinpot.ValueMatches(p, p.Default) {
+			report("inputs.%s: default %v does not match the input", name, p.Default)
+		}
+		inputs[name] = true
+	}
+
+	actions := s.mgr.ListActions()
+	steps := make(map[string]WorkflowStep, len(wf.Steps))
+	for i, step := range wf.Steps {
+		if step.ID == "" {
+			report("steps[%d]: missing id", i)
+		} else if _, ok := steps[step.ID]; ok {
+			report("steps[%d]: duplicate id %q", i, step.ID)
+		}
+		steps[step.ID] = step
+		switch step.Type {
+		case "":
+			if !tinpot.ValidName(step.Action) {
+				report("steps[%d]: invalid action %q", i, step.Action)
+				continue
+			}
+			act, ok := actions[tinpot.QualifiedName(tenant, step.Action)]
+			if !ok {
+				report("steps[%d]: action not found: %s", i, step.Action)
+				continue
+			}
+			for _, name := range slices.Sorted(maps.Keys(step.Parameters)) {
+				p, ok := act.Parameters[name]
+				if !ok && len(act.Parameters) > 0 {
+					report("steps[%d].parameters.%s: unknown parameter of %s", i, name, step.Action)
+				} else if ok && !tinpot.ValueMatches(p, step.Parameters[name]) {
+					report("steps[%d].parameters.%s: %v does not match the %s parameter", i, name, step.Parameters[name], p.Type)
+				}
+			}
+			for _, name := range slices.Sorted(maps.Keys(act.Parameters)) {
+				_, given := step.Parameters[name]
+				_, computed := step.Expressions[name]
+				if p := act.Parameters[name]; p.Required && p.Default == nil && !given && !computed {
+					report("steps[%d]: missing required parameter %s of %s", i, name, step.Action)
+				}
+			}
+			for _, name := range slices.Sorted(maps.Keys(step.Expressions)) {
+				if _, ok := act.Parameters[name]; !ok && len(act.Parameters) > 0 {
+					report("steps[%d].expressions.%s: unknown parameter of %s", i, name, step.Action)
+				}
+			}
+		case WorkflowStepManual:
+			if step.Action != "" || step.ForEach != "" {
+				report("steps[%d]: manual steps have no action or for_each", i)
+			}
+		default:
+			report("steps[%d]: unknown type %q", i, step.Type)
+		}
+		if step.Parallelism < 0 {
+			report("steps[%d].parallelism: must not be negative", i)
+		}
+	}
+	for i, step := range wf.Steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				report("steps[%d]: unknown dependency %q", i, dep)
+			}
+		}
+	}
+	// Depth first search for cycles
+	state := make(map[string]int) // 1 visiting, 2 done
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case 1:
+			report("dependency cycle through step %q", id)
+			return false
+		case 2:
+			return true
+		}
+		state[id] = 1
+		for _, dep := range steps[id].DependsOn {
+			if !visit(dep) {
+				return false
+			}
+		}
+		state[id] = 2
+		return true
+	}
+	for _, step := range wf.Steps {
+		if !visit(step.ID) {
+			break
+		}
+	}
+
+	for i, step := range wf.Steps {
+		deps := ancestors(wf, step.ID)
+		if step.ForEach != "" {
+			if _, err := compileExpression(step.ForEach, inputs, deps, false); err != nil {
+				report("steps[%d].for_each: %v", i, err)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(step.Expressions)) {
+			if _, err := compileExpression(step.Expressions[name], inputs, deps, step.ForEach != ""); err != nil {
+				report("steps[%d].expressions.%s: %v", i, name, err)
+			}
+		}
+	}
+	return problems
+}
+
+// POST /api/workflows/validate checks a definition (JSON or YAML) without saving it
+func (s *Server) validateWorkflowDefinition(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	resp := WorkflowValidation{Problems: []string{}}
+	if wf, err := ParseWorkflow(data); err != nil {
+		resp.Problems = append(resp.Problems, err.Error())
+	} else {
+		resp.Problems = append(resp.Problems, s.validateWorkflow(TenantFromRequest(r), wf)...)
+	}
+	resp.Valid = len(resp.Problems) == 0
+	writeJSON(w, 200, resp)
+}
+
+// PUT /api/workflows/{name} creates or replaces a workflow, runs in progress keep their definition
+func (s *Server) putWorkflow(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	name := r.PathValue("name")
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	wf, err := ParseWorkflow(data)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": fmt.Sprintf("Invalid workflow: %v", err)})
+		return
+	}
+	if problems := s.validateWorkflow(tenant, wf); len(problems) > 0 {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid workflow: " + strings.Join(problems, "; ")})
+		return
+	}
+	key := tinpot.QualifiedName(tenant, name)
+	s.workflowsMu.Lock()
+	_, exists := s.workflows[key]
+	s.workflows[key] = wf
+	s.workflowsMu.Unlock()
+	status := 200
+	if !exists {
+		status = 201
+	}
+	writeJSON(w, status, WorkflowResponse{Name: name, Workflow: wf})
+}
+
+func (s *Server) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	s.workflowsMu.Lock()
+	resp := []WorkflowResponse{}
+	for key, wf := range s.workflows {
+		if wfTenant, name := tinpot.SplitQualifiedName(key); wfTenant == tenant {
+			resp = append(resp, WorkflowResponse{Name: name, Workflow: wf})
+		}
+	}
+	s.workflowsMu.Unlock()
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.workflowsMu.Lock()
+	wf, ok := s.workflows[tinpot.QualifiedName(TenantFromRequest(r), name)]
+	s.workflowsMu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow not found: %s", name)})
+		return
+	}
+	writeJSON(w, 200, WorkflowResponse{Name: name, Workflow: wf})
+}
+
+func (s *Server) deleteWorkflow(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	key := tinpot.QualifiedName(TenantFromRequest(r), name)
+	s.workflowsMu.Lock()
+	_, ok := s.workflows[key]
+	delete(s.workflows, key)
+	s.workflowsMu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow not found: %s", name)})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/workflows/{name}/run starts a run
+func (s *Server) runWorkflow(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	name := r.PathValue("name")
+	s.workflowsMu.Lock()
+	wf, ok := s.workflows[tinpot.QualifiedName(tenant, name)]
+	s.workflowsMu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow not found: %s", name)})
+		return
+	}
+	var req WorkflowRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	// Loaded definitions are only checked against the actions now, the workers may have
+	// been offline at startup
+	if problems := s.validateWorkflow(tenant, wf); len(problems) > 0 {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid workflow: " + strings.Join(problems, "; ")})
+		return
+	}
+	inputs, err := workflowInputs(wf, req.Inputs)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	run := &workflowRun{tenant: tenant, workflow: wf, tasks: make(map[string]chan struct{}), run: WorkflowRun{
+		ID:        uuid.New().String(),
+		Workflow:  name,
+		Status:    tinpot.StatusRunning,
+		Inputs:    inputs,
+		StartedAt: time.Now(),
+	}}
+	for _, step := range wf.Steps {
+		run.run.Steps = append(run.run.Steps, WorkflowStepRun{ID: step.ID, Status: tinpot.StatusPending})
+	}
+	s.workflowsMu.Lock()
+	s.workflowRuns[run.run.ID] = run
+	s.workflowsMu.Unlock()
+	go s.executeWorkflow(run)
+	writeJSON(w, 202, run.snapshot())
+}
+
+// workflowInputs validates the inputs of a run and fills in the defaults
+func workflowInputs(wf Workflow, given map[string]interface{}) (map[string]interface{}, error) {
+	inputs := make(map[string]interface{}, len(wf.Inputs))
+	for _, name := range slices.Sorted(maps.Keys(given)) {
+		p, ok := wf.Inputs[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown input: %s", name)
+		}
+		if !tinpot.ValueMatches(p, given[name]) {
+			return nil, fmt.Errorf("Invalid value of input %s: %v", name, given[name])
+		}
+		inputs[name] = given[name]
+	}
+	for _, name := range slices.Sorted(maps.Keys(wf.Inputs)) {
+		if _, ok := inputs[name]; ok {
+			continue
+		}
+		if p := wf.Inputs[name]; p.Default != nil {
+			inputs[name] = p.Default
+		} else if p.Required {
+			return nil, fmt.Errorf("Missing required input: %s", name)
+		}
+	}
+	return inputs, nil
+}
+
+// workflowRun returns the run only if it belongs to the tenant
+func (s *Server) workflowRun(id, tenant string) *workflowRun {
+	s.workflowsMu.Lock()
+	defer s.workflowsMu.Unlock()
+	run := s.workflowRuns[id]
+	if run == nil || run.tenant != tenant {
+		return nil
+	}
+	return run
+}
+
+// GET /api/workflow_runs lists the runs of the tenant, most recent first (filter: workflow)
+func (s *Server) listWorkflowRuns(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantFromRequest(r)
+	workflow := r.URL.Query().Get("workflow")
+	s.workflowsMu.Lock()
+	var runs []*workflowRun
+	for _, run := range s.workflowRuns {
+		if run.tenant == tenant {
+			runs = append(runs, run)
+		}
+	}
+	s.workflowsMu.Unlock()
+	resp := []WorkflowRun{}
+	for _, run := range runs {
+		if snapshot := run.snapshot(); workflow == "" || snapshot.Workflow == workflow {
+			resp = append(resp, snapshot)
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].StartedAt.After(resp[j].StartedAt) })
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) getWorkflowRun(w http.ResponseWriter, r *http.Request) {
+	run := s.workflowRun(r.PathValue("id"), TenantFromRequest(r))
+	if run == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow run not found: %s", r.PathValue("id"))})
+		return
+	}
+	writeJSON(w, 200, run.snapshot())
+}
+
+// GET /api/workflow_runs/{id}/graph returns the steps of a run as nodes and their dependencies as edges
+func (s *Server) getWorkflowGraph(w http.ResponseWriter, r *http.Request) {
+	run := s.workflowRun(r.PathValue("id"), TenantFromRequest(r))
+	if run == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow run not found: %s", r.PathValue("id"))})
+		return
+	}
+	snapshot := run.snapshot()
+	graph := WorkflowGraph{RunID: snapshot.ID, Workflow: snapshot.Workflow, Status: snapshot.Status, Nodes: []WorkflowGraphNode{}, Edges: []WorkflowGraphEdge{}}
+	now := time.Now()
+	for i, def := range run.workflow.Steps {
+		step := snapshot.Steps[i]
+		node := WorkflowGraphNode{
+			ID:          def.ID,
+			Title:       def.Action,
+			Status:      step.Status,
+			ExecutionID: step.ExecutionID,
+			StartedAt:   step.StartedAt,
+			CompletedAt: step.CompletedAt,
+		}
+		switch {
+		case def.Type == WorkflowStepManual:
+			node.Title, node.Subtitle = WorkflowStepManual, def.Instructions
+		case def.ForEach != "":
+			node.Subtitle = "for each " + def.ForEach
+		}
+		if step.StartedAt != nil {
+			end := now
+			if step.CompletedAt != nil {
+				end = *step.CompletedAt
+			}
+			node.DurationMs = end.Sub(*step.StartedAt).Milliseconds()
+		}
+		if len(step.Items) > 0 {
+			node.Items = make(map[string]int)
+			for _, item := range step.Items {
+				node.Items[item.Status]++
+			}
+		}
+		graph.Nodes = append(graph.Nodes, node)
+		for _, dep := range def.DependsOn {
+			graph.Edges = append(graph.Edges, WorkflowGraphEdge{ID: dep + "->" + def.ID, Source: dep, Target: def.ID})
+		}
+	}
+	writeJSON(w, 200, graph)
+}
+
+// POST /api/workflow_runs/{id}/resume runs the failed and skipped steps of a failed run
+// again; the succeeded ones keep their results
+func (s *Server) resumeWorkflowRun(w http.ResponseWriter, r *http.Request) {
+	run := s.workflowRun(r.PathValue("id"), TenantFromRequest(r))
+	if run == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow run not found: %s", r.PathValue("id"))})
+		return
+	}
+	run.mu.Lock()
+	if run.run.Status != tinpot.StatusFailure {
+		status := run.run.Status
+		run.mu.Unlock()
+		writeJSON(w, 409, map[string]string{"detail": fmt.Sprintf("Only failed runs can be resumed, the run is %s", status)})
+		return
+	}
+	for i := range run.run.Steps {
+		if step := &run.run.Steps[i]; step.Status != tinpot.StatusSuccess {
+			// The succeeded items of for_each steps are kept too
+			*step = WorkflowStepRun{ID: step.ID, Status: tinpot.StatusPending, Items: step.Items}
+		}
+	}
+	run.run.Status = tinpot.StatusRunning
+	run.run.CompletedAt = nil
+	run.run.Resumed++
+	run.mu.Unlock()
+	go s.executeWorkflow(run)
+	writeJSON(w, 202, run.snapshot())
+}
+
+// executeWorkflow starts the pending steps as their dependencies succeed, until none can start
+func (s *Server) executeWorkflow(run *workflowRun) {
+	done := make(chan struct{})
+	running := 0
+	for {
+		run.mu.Lock()
+		states := make(map[string]string, len(run.run.Steps))
+		for _, step := range run.run.Steps {
+			states[step.ID] = step.Status
+		}
+		for i, def := range run.workflow.Steps {
+			step := &run.run.Steps[i]
+			if step.Status != tinpot.StatusPending {
+				continue
+			}
+			ready := true
+			for _, dep := range def.DependsOn {
+				switch states[dep] {
+				case tinpot.StatusFailure, StatusSkipped:
+					step.Status, step.Error = StatusSkipped, fmt.Sprintf("Dependency %s did not succeed", dep)
+					states[step.ID] = StatusSkipped
+				case tinpot.StatusSuccess:
+				default:
+					ready = false
+				}
+			}
+			if ready && step.Status == tinpot.StatusPending {
+				now := time.Now()
+				step.Status, step.StartedAt = tinpot.StatusRunning, &now
+				running++
+				go func() {
+					s.executeStep(run, i, def)
+					done <- struct{}{}
+				}()
+			}
+		}
+		if running == 0 {
+			now := time.Now()
+			run.run.Status = tinpot.StatusSuccess
+			for _, step := range run.run.Steps {
+				if step.Status != tinpot.StatusSuccess {
+					run.run.Status = tinpot.StatusFailure
+				}
+			}
+			run.run.CompletedAt = &now
+			run.mu.Unlock()
+			return
+		}
+		run.mu.Unlock()
+		<-done
+		running--
+	}
+}
+
+// POST /api/workflow_runs/{id}/tasks/{task}/complete completes a waiting manual step
+func (s *Server) completeWorkflowTask(w http.ResponseWriter, r *http.Request) {
+	run := s.workflowRun(r.PathValue("id"), TenantFromRequest(r))
+	if run == nil {
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Workflow run not found: %s", r.PathValue("id"))})
+		return
+	}
+	var req TaskCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, 400, map[string]string{"detail": "Invalid request body"})
+		return
+	}
+	if id := IdentityFromRequest(r); req.Author == "" && id != nil {
+		req.Author = id.Subject
+	}
+
+	task := r.PathValue("task")
+	run.mu.Lock()
+	index := slices.IndexFunc(run.run.Steps, func(step WorkflowStepRun) bool { return step.ID == task })
+	if index < 0 || run.workflow.Steps[index].Type != WorkflowStepManual {
+		run.mu.Unlock()
+		writeJSON(w, 404, map[string]string{"detail": fmt.Sprintf("Manual step not found: %s", task)})
+		return
+	}
+	step := &run.run.Steps[index]
+	waiting, ok := run.tasks[task]
+	if !ok {
+		status := step.Status
+		run.mu.Unlock()
+		writeJSON(w, 409, map[string]string{"detail": fmt.Sprintf("The step is not waiting, it is %s", status)})
+		return
+	}
+	now := time.Now()
+	step.CompletedAt, step.CompletedBy = &now, req.Author
+	if req.Error != "" {
+		step.Status, step.Error = tinpot.StatusFailure, req.Error
+	} else {
+		step.Status, step.Result = tinpot.StatusSuccess, req.Result
+	}
+	delete(run.tasks, task)
+	close(waiting)
+	run.mu.Unlock()
+	writeJSON(w, 200, run.snapshot())
+}
+
+// executeStep runs the action of a step, or of each item of a for_each step
+func (s *Server) executeStep(run *workflowRun, index int, def WorkflowStep) {
+	if def.Type == WorkflowStepManual {
+		run.mu.Lock()
+		waiting := make(chan struct{})
+		run.tasks[def.ID] = waiting
+		run.run.Steps[index].Status = StatusWaiting
+		run.mu.Unlock()
+		<-waiting
+		return
+	}
+	vars := run.variables(def)
+	if def.ForEach != "" {
+		s.executeItems(run, index, def, vars)
+		return
+	}
+	parameters, err := run.parameters(def, vars)
+	var result map[string]interface{}
+	var execID, failure string
+	attempts := 0
+	if err != nil {
+		failure = err.Error()
+	} else {
+		var value interface{}
+		execID, attempts, value, failure = s.executeStepAction(run, def, parameters)
+		result, _ = value.(map[string]interface{})
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	step := &run.run.Steps[index]
+	now := time.Now()
+	step.ExecutionID, step.Attempts, step.CompletedAt = execID, attempts, &now
+	if failure == "" {
+		step.Status, step.Result = tinpot.StatusSuccess, result
+	} else {
+		step.Status, step.Error = tinpot.StatusFailure, failure
+	}
+}
+
+// executeItems runs the action of a for_each step per item, Parallelism at a time. The
+// step succeeds when all items do, its result lists the item results in order.
+func (s *Server) executeItems(run *workflowRun, index int, def WorkflowStep, vars map[string]interface{}) {
+	finish := func(items []interface{}, failure string) {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		step := &run.run.Steps[index]
+		now := time.Now()
+		step.CompletedAt = &now
+		if failure == "" {
+			step.Status, step.Result = tinpot.StatusSuccess, map[string]interface{}{"items": items}
+		} else {
+			step.Status, step.Error = tinpot.StatusFailure, failure
+		}
+	}
+	prg, err := compileExpression(def.ForEach, run.inputNames(), ancestors(run.workflow, def.ID), false)
+	var value interface{}
+	if err == nil {
+		value, err = evalExpression(prg, vars)
+	}
+	if err != nil {
+		finish(nil, fmt.Sprintf("Expression of for_each: %v", err))
+		return
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		finish(nil, fmt.Sprintf("for_each is not a list: %v", value))
+		return
+	}
+
+	run.mu.Lock()
+	step := &run.run.Steps[index]
+	// Items that succeeded before a resume are not run again
+	if len(step.Items) != len(list) {
+		step.Items = make([]WorkflowItemRun, len(list))
+	}
+	for i := range step.Items {
+		if step.Items[i].Status != tinpot.StatusSuccess {
+			step.Items[i] = WorkflowItemRun{Status: tinpot.StatusPending}
+		}
+	}
+	run.mu.Unlock()
+
+	parallelism := def.Parallelism
+	if parallelism == 0 {
+		parallelism = 4
+	}
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, item := range list {
+		run.mu.Lock()
+		done := step.Items[i].Status == tinpot.StatusSuccess
+		if !done {
+			step.Items[i].Status = tinpot.StatusRunning
+		}
+		run.mu.Unlock()
+		if done {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			itemVars := maps.Clone(vars)
+			itemVars["item"], itemVars["index"] = item, int64(i)
+			var execID, failure string
+			var result interface{}
+			attempts := 0
+			parameters, err := run.parameters(def, itemVars)
+			if err != nil {
+				failure = err.Error()
+			} else {
+				execID, attempts, result, failure = s.executeStepAction(run, def, parameters)
+			}
+			run.mu.Lock()
+			defer run.mu.Unlock()
+			status := tinpot.StatusSuccess
+			if failure != "" {
+				status = tinpot.StatusFailure
+			}
+			step.Items[i] = WorkflowItemRun{Status: status, ExecutionID: execID, Attempts: attempts, Result: result, Error: failure}
+		}()
+	}
+	wg.Wait()
+
+	run.mu.Lock()
+	items := make([]interface{}, len(step.Items))
+	failed := 0
+	for i, item := range step.Items {
+		items[i] = item.Result
+		if item.Status != tinpot.StatusSuccess {
+			failed++
+		}
+	}
+	run.mu.Unlock()
+	if failed > 0 {
+		finish(nil, fmt.Sprintf("%d of %d items failed", failed, len(items)))
+	} else {
+		finish(items, "")
+	}
+}
+
+// executeStepAction runs the action of a step like sync_execute, retrying failures. It
+// returns the last execution, the number of attempts, the result and the failure message.
+func (s *Server) executeStepAction(run *workflowRun, def WorkflowStep, parameters map[string]interface{}) (string, int, interface{}, string) {
+	var execID, failure string
+	var result interface{}
+	// Saved workflows predating the validation may name the actions of other tenants
+	if !tinpot.ValidName(def.Action) {
+		return "", 0, nil, fmt.Sprintf("Invalid action: %q", def.Action)
+	}
+	attempts := 0
+	for attempts <= def.Retries {
+		attempts++
+		rec := &responseRecorder{header: make(http.Header)}
+		s.submit(rec, submission{
+			tenant:     run.tenant,
+			action:     def.Action,
+			parameters: parameters,
+			origin:     tinpot.ExecutionOrigin{Type: tinpot.OriginWorkflow, ID: run.run.ID},
+		}, true)
+		var resp SyncExecutionResponse
+		if rec.status >= 300 || json.Unmarshal(rec.body.Bytes(), &resp) != nil {
+			var detail map[string]interface{}
+			json.Unmarshal(rec.body.Bytes(), &detail)
+			failure = fmt.Sprintf("%d %v", rec.status, detail["detail"])
+			continue
+		}
+		execID, result = resp.ExecutionID, resp.Result
+		if resp.Status == tinpot.StatusSuccess {
+			return execID, attempts, result, ""
+		}
+		failure = resp.Status
+		if record, err := s.store.Get(execID); err == nil && record.Error != "" {
+			failure = record.Error
+		}
+	}
+	return execID, attempts, result, strings.TrimSpace(failure)
+}
+
+func (r *workflowRun) inputNames() map[string]bool {
+	inputs := make(map[string]bool, len(r.workflow.Inputs))
+	for name := range r.workflow.Inputs {
+		inputs[name] = true
+	}
+	return inputs
+}
+
+// variables returns the inputs and the dependencies of a step for its expressions
+func (r *workflowRun) variables(def WorkflowStep) map[string]interface{} {
+	deps := ancestors(r.workflow, def.ID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	steps := make(map[string]interface{}, len(deps))
+	for _, step := range r.run.Steps {
+		if deps[step.ID] {
+			steps[step.ID] = map[string]interface{}{"result": step.Result, "execution_id": step.ExecutionID, "status": step.Status}
+		}
+	}
+	return map[string]interface{}{"inputs": r.run.Inputs, "steps": steps}
+}
+
+// parameters evaluates the expressions of a step over the variables
+func (r *workflowRun) parameters(def WorkflowStep, vars map[string]interface{}) (map[string]interface{}, error) {
+	if len(def.Expressions) == 0 {
+		return def.Parameters, nil
+	}
+	inputs, deps := r.inputNames(), ancestors(r.workflow, def.ID)
+	parameters := make(map[string]interface{}, len(def.Parameters)+len(def.Expressions))
+	maps.Copy(parameters, def.Parameters)
+	for _, name := range slices.Sorted(maps.Keys(def.Expressions)) {
+		prg, err := compileExpression(def.Expressions[name], inputs, deps, def.ForEach != "")
+		if err == nil {
+			parameters[name], err = evalExpression(prg, vars)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Expression of %s: %v", name, err)
+		}
+	}
+	return parameters, nil
+}
@@ -0,0 +1,163 @@
+package tinpot
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSignatureMaxAge is how old a signed execution request may be when it arrives
+const DefaultSignatureMaxAge = 5 * time.Minute
+
+// SigningKey signs execution requests on the coordinator and verifies them on the workers,
+// so clients of the broker can't inject triggers. The signature covers the trigger topic
+// and the whole request, and requests are only accepted once within their maximum age.
+type SigningKey struct {
+	secret  []byte // HMAC-SHA256, shared
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+
+	// MaxAge of accepted requests, DefaultSignatureMaxAge when zero
+	MaxAge time.Duration
+
+	seenMu sync.Mutex
+	seen   map[string]time.Time // execution IDs of the accepted requests, for replays
+}
+
+// ParseSigningKey parses a key specification:
+//   - "hmac:<base64 secret>" signs and verifies with a secret shared by the coordinator and the workers
+//   - "ed25519:<base64 private key>" (64 bytes) signs and verifies, for the coordinator
+//   - "ed25519:<base64 public key>" (32 bytes) only verifies, for the workers
+func ParseSigningKey(spec string) (*SigningKey, error) {
+	kind, encoded, _ := strings.Cut(spec, ":")
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key: %v", kind, err)
+	}
+	k := &SigningKey{seen: make(map[string]time.Time)}
+	switch {
+	case kind == "hmac" && len(key) >= 16:
+		k.secret = key
+	case kind == "ed25519" && len(key) == ed25519.PrivateKeySize:
+		k.private = ed25519.PrivateKey(key)
+		k.public = k.private.Public().(ed25519.PublicKey)
+	case kind == "ed25519" && len(key) == ed25519.PublicKeySize:
+		k.public = ed25519.PublicKey(key)
+	case kind == "hmac" || kind == "ed25519":
+		return nil, fmt.Errorf("invalid %s key length: %d bytes", kind, len(key))
+	default:
+		return nil, fmt.Errorf("unknown key type: %q", kind)
+	}
+	return k, nil
+}
+
+// CanSign tells whether the key can sign, i.e. it is not an Ed25519 public key
+func (k *SigningKey) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+// SignRequest stamps and signs a request published on the topic
+func (k *SigningKey) SignRequest(topic string, req *MqttExecutionRequest) error {
+	if !k.CanSign() {
+		return errors.New("the key can only verify")
+	}
+	req.Signature = ""
+	req.SignedAt = time.Now().Unix()
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	message, err := signedMessage(topic, payload)
+	if err != nil {
+		return err
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(k.sign(message))
+	return nil
+}
+
+// VerifyRequest checks the signature, age and uniqueness of a request received on the topic
+func (k *SigningKey) VerifyRequest(topic string, payload []byte) error {
+	var req MqttExecutionRequest
+	version, err := UnmarshalMessage(payload, MessageExecutionRequest, &req)
+	if err != nil {
+		return err
+	}
+	// The signature covers the bare JSON form of binary and enveloped requests
+	if version > 0 || PayloadFormat(payload) != "" {
+		payload, _ = json.Marshal(req)
+	}
+	if req.Signature == "" {
+		return errors.New("request is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	message, err := signedMessage(topic, payload)
+	if err != nil {
+		return err
+	}
+	if !k.verify(message, signature) {
+		return errors.New("invalid signature")
+	}
+
+	maxAge := k.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultSignatureMaxAge
+	}
+	signedAt := time.Unix(req.SignedAt, 0)
+	if age := time.Since(signedAt); age > maxAge || age < -maxAge {
+		return fmt.Errorf("request signed at %s is outside of the accepted %s", signedAt.Format(time.RFC3339), maxAge)
+	}
+
+	k.seenMu.Lock()
+	defer k.seenMu.Unlock()
+	for id, at := range k.seen {
+		if time.Since(at) > 2*maxAge {
+			delete(k.seen, id)
+		}
+	}
+	if _, ok := k.seen[req.ExecutionID]; ok {
+		return fmt.Errorf("replayed request of execution %s", req.ExecutionID)
+	}
+	k.seen[req.ExecutionID] = time.Now()
+	return nil
+}
+
+func (k *SigningKey) sign(message []byte) []byte {
+	if k.secret != nil {
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(message)
+		return mac.Sum(nil)
+	}
+	return ed25519.Sign(k.private, message)
+}
+
+func (k *SigningKey) verify(message, signature []byte) bool {
+	if k.secret != nil {
+		return hmac.Equal(k.sign(message), signature)
+	}
+	return ed25519.Verify(k.public, message, signature)
+}
+
+// signedMessage is the topic and the request without its signature, with the fields in a
+// stable order. Field values are kept as sent, so both sides sign the same bytes.
+func signedMessage(topic string, payload []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "signature")
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(topic+"\n"), canonical...), nil
+}
@@ -0,0 +1,79 @@
+package tinpot
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningKey(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	hmacKey, err := ParseSigningKey("hmac:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ParseSigningKey("ed25519:" + base64.StdEncoding.EncodeToString(private))
+	verifier, err := ParseSigningKey("ed25519:" + base64.StdEncoding.EncodeToString(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.CanSign() || verifier.SignRequest("t", &MqttExecutionRequest{}) == nil {
+		t.Error("a public key should not sign")
+	}
+	for _, spec := range []string{"hmac:c2hvcnQ=", "rsa:AAAA", "ed25519:!"} {
+		if _, err := ParseSigningKey(spec); err == nil {
+			t.Errorf("%s should be rejected", spec)
+		}
+	}
+
+	for name, keys := range map[string][2]*SigningKey{"hmac": {hmacKey, hmacKey}, "ed25519": {signer, verifier}} {
+		sign := func(id string, params map[string]interface{}) []byte {
+			req := MqttExecutionRequest{ExecutionID: id, Parameters: params, ResultTopic: "r", LogTopic: "l"}
+			if err := keys[0].SignRequest("tinpot/actions/a/trigger", &req); err != nil {
+				t.Fatal(err)
+			}
+			payload, _ := json.Marshal(req)
+			return payload
+		}
+
+		payload := sign("1", map[string]interface{}{"n": 1.5, "nested": map[string]interface{}{"b": 1, "a": 2}})
+		if err := keys[1].VerifyRequest("tinpot/actions/a/trigger", payload); err != nil {
+			t.Errorf("%s: valid request rejected: %v", name, err)
+		}
+		if err := keys[1].VerifyRequest("tinpot/actions/a/trigger", payload); err == nil || !strings.Contains(err.Error(), "replayed") {
+			t.Errorf("%s: replay accepted: %v", name, err)
+		}
+		payload = sign("2", map[string]interface{}{"n": 1})
+		if err := keys[1].VerifyRequest("tinpot/actions/b/trigger", payload); err == nil {
+			t.Errorf("%s: request accepted on another action", name)
+		}
+		tampered := []byte(strings.Replace(string(payload), `"n":1`, `"n":2`, 1))
+		if err := keys[1].VerifyRequest("tinpot/actions/a/trigger", tampered); err == nil {
+			t.Errorf("%s: tampered request accepted", name)
+		}
+		for _, format := range append(Formats, "") {
+			req := MqttExecutionRequest{ExecutionID: "enveloped-" + format, Parameters: map[string]interface{}{"n": 1, "s": []interface{}{"x"}}, ResultTopic: "r"}
+			keys[0].SignRequest("tinpot/actions/a/trigger", &req)
+			enveloped, _ := MarshalMessage(format, SchemaVersion, MessageExecutionRequest, req)
+			if err := keys[1].VerifyRequest("tinpot/actions/a/trigger", enveloped); err != nil {
+				t.Errorf("%s: valid %s request rejected: %v", name, format, err)
+			}
+		}
+		unsigned, _ := json.Marshal(MqttExecutionRequest{ExecutionID: "3"})
+		if err := keys[1].VerifyRequest("tinpot/actions/a/trigger", unsigned); err == nil {
+			t.Errorf("%s: unsigned request accepted", name)
+		}
+	}
+
+	hmacKey.MaxAge = time.Nanosecond
+	req := MqttExecutionRequest{ExecutionID: "4"}
+	hmacKey.SignRequest("t", &req)
+	time.Sleep(time.Millisecond)
+	payload, _ := json.Marshal(req)
+	if err := hmacKey.VerifyRequest("t", payload); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Error("expired request accepted")
+	}
+}
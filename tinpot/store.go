@@ -0,0 +1,229 @@
+package tinpot
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Execution states
+const (
+	StatusPending = "PENDING"
+	StatusRunning = "RUNNING"
+	StatusSuccess = "SUCCESS"
+	StatusFailure = "FAILURE"
+
+	// StatusQueuedOffline executions wait for the broker, see server.Options.OfflineQueueFile
+	StatusQueuedOffline = "QUEUED_OFFLINE"
+	// StatusExpired executions were not started before their deadline, see ExpiredError
+	StatusExpired = "EXPIRED"
+	// StatusCancelled executions were cancelled while held, see CancelledError
+	StatusCancelled = "CANCELLED"
+)
+
+// CancelledError is the error of the held executions cancelled before they started
+const CancelledError = "Cancelled before it started"
+
+var ErrExecutionNotFound = errors.New("execution not found")
+
+// Origins of the executions, the Type of ExecutionOrigin
+const (
+	OriginAPI          = "api"
+	OriginRerun        = "rerun"
+	OriginSchedule     = "schedule"
+	OriginWorkflow     = "workflow"
+	OriginAlertmanager = "alertmanager"
+	OriginSlack        = "slack"
+	OriginPlugin       = "plugin"
+	// OriginExecution executions were requested by another execution, see
+	// server.ParentExecutionHeader
+	OriginExecution = "execution"
+)
+
+// ExecutionOrigin tells why an execution ran
+type ExecutionOrigin struct {
+	Type string `json:"type"`
+	// ID is what started the execution within the type: the original execution of a rerun,
+	// the schedule, the workflow run, the Alertmanager alert, the Slack user, the plugin or
+	// the parent execution
+	ID string `json:"id,omitempty"`
+}
+
+// ExecutionRecord is the history entry of an execution
+type ExecutionRecord struct {
+	ID         string                 `json:"execution_id"`
+	Action     string                 `json:"action_name"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Parameters map[string]interface{} `json:"parameters"`
+	// RerunOf is the execution this one was re-run from
+	RerunOf string `json:"rerun_of,omitempty"`
+	// Origin tells why the execution ran, nil for the ones recorded before origins were tracked
+	Origin *ExecutionOrigin `json:"origin,omitempty"`
+	// TraceID is the distributed trace the execution was requested in
+	TraceID string `json:"trace_id,omitempty"`
+	// Selector narrows the workers the execution may be routed to, see ParseSelector
+	Selector string `json:"selector,omitempty"`
+	// Session keeps the executions sharing it on the same worker
+	Session string `json:"session,omitempty"`
+	// Priority of the request, empty for normal
+	Priority string `json:"priority,omitempty"`
+	// Track is the release track the execution was routed to, empty if the action had no canary
+	Track string `json:"track,omitempty"`
+	// RequestedBy is the subject of the caller that requested the execution, empty when the
+	// API is open or for executions started by the coordinator itself
+	RequestedBy string `json:"requested_by,omitempty"`
+	// RequesterGroups are the groups of the caller, for the group visibility scope
+	RequesterGroups []string `json:"requester_groups,omitempty"`
+	// Coalesced counts the identical requests that joined the execution, see ActionInfo.Debounce
+	Coalesced int `json:"coalesced,omitempty"`
+	// Usage is the resource usage reported by the worker
+	Usage *ResourceUsage `json:"usage,omitempty"`
+	// Environment is the worker and code version reported with the result
+	Environment *ExecutionEnvironment `json:"environment,omitempty"`
+	// RunAs is the user the worker ran the action as, see ActionInfo.RunAs
+	RunAs       string                 `json:"run_as,omitempty"`
+	Status      string                 `json:"status"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	SubmittedAt time.Time              `json:"submitted_at"`
+	StartedAt   *time.Time             `json:"started_at,omitempty"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	// PublishedAt is when the request was handed to the broker
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	// AcceptedAt is when the coordinator learned that a worker picked the execution up
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	// FirstLogAt is when the first log line of the execution arrived
+	FirstLogAt *time.Time `json:"first_log_at,omitempty"`
+	// ProgressPoints are the progress reports of the execution, the first MaxProgressPoints
+	ProgressPoints []ProgressPoint `json:"progress_points,omitempty"`
+	// WorkerTimes are the worker's clock readings of the execution
+	WorkerTimes *WorkerTimes `json:"worker_times,omitempty"`
+	// Durations are computed on completion, see ExecutionDurations
+	Durations *ExecutionDurations `json:"durations,omitempty"`
+	Comments  []ExecutionComment  `json:"comments,omitempty"`
+	// NotBefore and NotAfter bound when the execution may start
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// Archived executions are hidden from history queries by default
+	Archived bool `json:"archived,omitempty"`
+}
+
+// MaxProgressPoints bounds the progress reports kept in an ExecutionRecord
+const MaxProgressPoints = 100
+
+// ProgressPoint is a progress report of an execution and when it arrived
+type ProgressPoint struct {
+	At time.Time `json:"at"`
+	Progress
+}
+
+// ExecutionDurations of a completed execution. The coordinator's times are used for the
+// durations, so a worker with a skewed clock does not distort them; the worker's own view is
+// kept besides them.
+type ExecutionDurations struct {
+	// QueuedSeconds is from the submission until a worker picked the execution up
+	QueuedSeconds float64 `json:"queued_seconds,omitempty"`
+	// RunSeconds is from the pickup (or the trigger, for workers not reporting the pickup)
+	// until the result arrived
+	RunSeconds   float64 `json:"run_seconds"`
+	TotalSeconds float64 `json:"total_seconds"`
+	// WorkerRunSeconds is the run time by the worker's clock
+	WorkerRunSeconds float64 `json:"worker_run_seconds,omitempty"`
+	// ClockSkewSeconds is how far the worker's clock is ahead of the coordinator's, measured
+	// at the pickup and including the delivery delay
+	ClockSkewSeconds float64 `json:"clock_skew_seconds,omitempty"`
+}
+
+// ComputeDurations fills the Durations of a completed record from its times
+func (r *ExecutionRecord) ComputeDurations() {
+	if r.CompletedAt == nil {
+		return
+	}
+	d := &ExecutionDurations{TotalSeconds: r.CompletedAt.Sub(r.SubmittedAt).Seconds()}
+	if start := cmp.Or(r.AcceptedAt, r.StartedAt); start != nil {
+		d.RunSeconds = r.CompletedAt.Sub(*start).Seconds()
+	}
+	if r.AcceptedAt != nil {
+		d.QueuedSeconds = r.AcceptedAt.Sub(r.SubmittedAt).Seconds()
+	}
+	if w := r.WorkerTimes; w != nil && w.StartedAt != nil {
+		if w.CompletedAt != nil {
+			d.WorkerRunSeconds = w.CompletedAt.Sub(*w.StartedAt).Seconds()
+		}
+		if r.AcceptedAt != nil {
+			d.ClockSkewSeconds = w.StartedAt.Sub(*r.AcceptedAt).Seconds()
+		}
+	}
+	r.Durations = d
+}
+
+// ExecutionComment is an annotation attached to an execution, e.g. for post-mortems
+type ExecutionComment struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author,omitempty"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Done reports whether the execution has completed
+func (r *ExecutionRecord) Done() bool {
+	return r.Status == StatusSuccess || r.Status == StatusFailure || r.Status == StatusExpired || r.Status == StatusCancelled
+}
+
+// ArchiveFilter selects executions by their archived flag
+type ArchiveFilter int
+
+const (
+	ExcludeArchived ArchiveFilter = iota
+	OnlyArchived
+	IncludeArchived
+)
+
+// ExecutionFilter selects records from an ExecutionStore. Empty fields match everything,
+// except Tenant, which restricts the result to one tenant unless AllTenants is set, and Archived.
+type ExecutionFilter struct {
+	Tenant     string
+	AllTenants bool
+	Action     string
+	Status     string
+	// Origin and OriginID match the Type and ID of the ExecutionOrigin
+	Origin, OriginID string
+	// Before matches executions submitted before the time
+	Before   time.Time
+	Archived ArchiveFilter
+	// RequestedBy, when set, matches the executions requested by the subject or by a member
+	// of one of the RequesterGroups
+	RequestedBy     string
+	RequesterGroups []string
+	// Limit caps the number of returned records, 0 means no limit
+	Limit int
+}
+
+// Match reports whether the record is selected by the filter (ignoring Limit)
+func (f ExecutionFilter) Match(rec *ExecutionRecord) bool {
+	switch {
+	case !f.AllTenants && rec.Tenant != f.Tenant,
+		f.Action != "" && rec.Action != f.Action,
+		f.Status != "" && rec.Status != f.Status,
+		f.Origin != "" && (rec.Origin == nil || rec.Origin.Type != f.Origin),
+		f.OriginID != "" && (rec.Origin == nil || rec.Origin.ID != f.OriginID),
+		!f.Before.IsZero() && !rec.SubmittedAt.Before(f.Before),
+		f.Archived == ExcludeArchived && rec.Archived,
+		f.Archived == OnlyArchived && !rec.Archived:
+		return false
+	}
+	return f.RequestedBy == "" || rec.RequestedBy == f.RequestedBy || slices.ContainsFunc(rec.RequesterGroups, func(group string) bool {
+		return slices.Contains(f.RequesterGroups, group)
+	})
+}
+
+// ExecutionStore keeps the execution history
+type ExecutionStore interface {
+	Create(record ExecutionRecord) error
+	// Update applies fn to the stored record; returns ErrExecutionNotFound for unknown ids
+	Update(id string, fn func(*ExecutionRecord)) error
+	Get(id string) (ExecutionRecord, error)
+	// List returns the matching records, most recently submitted first
+	List(filter ExecutionFilter) ([]ExecutionRecord, error)
+}
@@ -0,0 +1,507 @@
+// Package telegram is a Telegram bot frontend: it lists the action groups as menus, asks
+// for the parameters in a conversation, runs the action and follows its logs in the chat.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+)
+
+// Config of the bot
+type Config struct {
+	// Token of the bot, from @BotFather
+	Token string
+	// Tenant whose actions the bot runs
+	Tenant string
+	// Chats are the chat ids allowed to use the bot, empty allows every chat
+	Chats []int64
+	// APIURL of the Bot API, https://api.telegram.org by default
+	APIURL string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+	// PollTimeout is the long polling timeout of getUpdates (default 30 seconds)
+	PollTimeout time.Duration
+	// UpdateInterval throttles the edits of the log tail (default 2 seconds)
+	UpdateInterval time.Duration
+}
+
+// tailLines is the number of log lines shown while an execution runs
+const tailLines = 10
+
+// Telegram rejects messages longer than 4096 characters
+const maxMessage = 4000
+
+// Bot is the server.Plugin polling the updates of the bot in the background
+type Bot struct {
+	config Config
+	host   *server.PluginHost
+
+	// conversations by chat are only used by the Run goroutine
+	conversations map[int64]*conversation
+
+	mu      sync.Mutex
+	watches map[string]*watch
+}
+
+// conversation collects the parameters of an action in a chat
+type conversation struct {
+	action tinpot.ActionInfo
+	// names are the parameters still to ask, the first one is asked now
+	names      []string
+	parameters map[string]interface{}
+}
+
+// watch is an execution started from a chat, its message shows the tail of the logs
+type watch struct {
+	chat, message int64
+	action        string
+	tail          []string
+	changed       bool
+}
+
+func New(config Config) *Bot {
+	if config.APIURL == "" {
+		config.APIURL = "https://api.telegram.org"
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	if config.PollTimeout == 0 {
+		config.PollTimeout = 30 * time.Second
+	}
+	if config.UpdateInterval == 0 {
+		config.UpdateInterval = 2 * time.Second
+	}
+	return &Bot{config: config, conversations: make(map[int64]*conversation), watches: make(map[string]*watch)}
+}
+
+func (b *Bot) Name() string {
+	return "telegram"
+}
+
+func (b *Bot) Setup(host *server.PluginHost) error {
+	if b.config.Token == "" {
+		return fmt.Errorf("missing Telegram bot token")
+	}
+	b.host = host
+	host.Events.Subscribe(func(event tinpot.ExecutionEvent) {
+		if event.Type != tinpot.EventLog && event.Type != tinpot.EventProgress {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		w := b.watches[event.ExecutionID]
+		if w == nil {
+			return
+		}
+		line := event.Message
+		if event.Type == tinpot.EventProgress && event.Progress != nil {
+			line = fmt.Sprintf("[%g/%g] %s", event.Progress.Current, event.Progress.Total, event.Progress.Message)
+		}
+		w.tail = append(w.tail, line)
+		if len(w.tail) > tailLines {
+			w.tail = w.tail[len(w.tail)-tailLines:]
+		}
+		w.changed = true
+	})
+	return nil
+}
+
+// Run polls the updates of the bot until the context is done
+func (b *Bot) Run(ctx context.Context) error {
+	var offset int64
+	for {
+		var updates []update
+		err := b.call(ctx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(b.config.PollTimeout.Seconds()),
+			"allowed_updates": []string{"message", "callback_query"},
+		}, &updates)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to get the Telegram updates: %v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			b.handle(ctx, u)
+		}
+	}
+}
+
+// Bot API types, only the fields used here
+type update struct {
+	UpdateID      int64          `json:"update_id"`
+	Message       *message       `json:"message,omitempty"`
+	CallbackQuery *callbackQuery `json:"callback_query,omitempty"`
+}
+
+type message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      chat   `json:"chat"`
+	Text      string `json:"text,omitempty"`
+}
+
+type chat struct {
+	ID int64 `json:"id"`
+}
+
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	Message *message `json:"message,omitempty"`
+	Data    string   `json:"data"`
+}
+
+type button struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type keyboard struct {
+	InlineKeyboard [][]button `json:"inline_keyboard"`
+}
+
+func (b *Bot) call(ctx context.Context, method string, body interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.config.PollTimeout+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", b.config.APIURL+"/bot"+b.config.Token+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
+
+// send posts a message to the chat and returns its id, 0 if it failed
+func (b *Bot) send(ctx context.Context, chat int64, text string, markup *keyboard) int64 {
+	body := map[string]interface{}{"chat_id": chat, "text": truncate(text)}
+	if markup != nil {
+		body["reply_markup"] = markup
+	}
+	var sent message
+	if err := b.call(ctx, "sendMessage", body, &sent); err != nil {
+		log.Printf("Failed to send a Telegram message: %v", err)
+	}
+	return sent.MessageID
+}
+
+func (b *Bot) edit(ctx context.Context, chat, messageID int64, text string) {
+	body := map[string]interface{}{"chat_id": chat, "message_id": messageID, "text": truncate(text)}
+	if err := b.call(ctx, "editMessageText", body, nil); err != nil {
+		log.Printf("Failed to edit a Telegram message: %v", err)
+	}
+}
+
+func truncate(text string) string {
+	if len(text) > maxMessage {
+		return text[:maxMessage] + "…"
+	}
+	return text
+}
+
+func (b *Bot) handle(ctx context.Context, u update) {
+	switch {
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		b.call(ctx, "answerCallbackQuery", map[string]string{"callback_query_id": u.CallbackQuery.ID}, nil)
+		if id := u.CallbackQuery.Message.Chat.ID; b.allowed(id) {
+			b.choose(ctx, id, u.CallbackQuery.Data)
+		}
+	case u.Message != nil:
+		id := u.Message.Chat.ID
+		if !b.allowed(id) {
+			b.send(ctx, id, "This chat is not allowed to use the bot.", nil)
+			return
+		}
+		b.reply(ctx, id, strings.TrimSpace(u.Message.Text))
+	}
+}
+
+func (b *Bot) allowed(chat int64) bool {
+	return len(b.config.Chats) == 0 || slices.Contains(b.config.Chats, chat)
+}
+
+func (b *Bot) actions() []tinpot.ActionInfo {
+	var actions []tinpot.ActionInfo
+	for _, act := range b.host.Manager.ListActions() {
+		if act.Tenant == b.config.Tenant {
+			actions = append(actions, act)
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Name < actions[j].Name })
+	return actions
+}
+
+// reply handles a text message: a command or the value of the parameter asked
+func (b *Bot) reply(ctx context.Context, chat int64, text string) {
+	command, _, _ := strings.Cut(text, " ")
+	command, _, _ = strings.Cut(command, "@")
+	conv := b.conversations[chat]
+
+	switch {
+	case command == "/start" || command == "/actions":
+		delete(b.conversations, chat)
+		b.menu(ctx, chat)
+	case command == "/cancel":
+		delete(b.conversations, chat)
+		b.send(ctx, chat, "Cancelled.", nil)
+	case conv == nil || len(conv.names) == 0:
+		b.send(ctx, chat, "Send /actions to choose an action.", nil)
+	case command == "/skip":
+		if conv.action.Parameters[conv.names[0]].Required {
+			b.send(ctx, chat, conv.names[0]+" is required.", nil)
+			return
+		}
+		conv.names = conv.names[1:]
+		b.ask(ctx, chat, conv)
+	default:
+		p := conv.action.Parameters[conv.names[0]]
+		var value interface{} = text
+		if p.Type != "str" && json.Unmarshal([]byte(text), &value) != nil {
+			value = text
+		}
+		if !tinpot.ValueMatches(p, value) {
+			b.send(ctx, chat, fmt.Sprintf("Invalid value for %s, expected %s.", conv.names[0], p.Type), nil)
+			return
+		}
+		conv.parameters[conv.names[0]] = value
+		conv.names = conv.names[1:]
+		b.ask(ctx, chat, conv)
+	}
+}
+
+// choose handles the buttons: g:<group>, a:<action>, v:<choice>, run and cancel
+func (b *Bot) choose(ctx context.Context, chat int64, data string) {
+	kind, value, _ := strings.Cut(data, ":")
+	conv := b.conversations[chat]
+
+	switch kind {
+	case "g":
+		var rows [][]button
+		for _, act := range b.actions() {
+			if act.Group == value {
+				rows = append(rows, []button{{Text: act.Name, CallbackData: "a:" + act.Name}})
+			}
+		}
+		b.send(ctx, chat, groupTitle(value)+":", &keyboard{InlineKeyboard: rows})
+	case "a":
+		act, ok := b.host.Manager.ListActions()[tinpot.QualifiedName(b.config.Tenant, value)]
+		if !ok {
+			b.send(ctx, chat, "Action not found: "+value, nil)
+			return
+		}
+		conv := &conversation{action: act, names: tinpot.ParameterOrder(act.Parameters), parameters: make(map[string]interface{})}
+		b.conversations[chat] = conv
+		if act.Description != "" {
+			b.send(ctx, chat, act.Name+": "+act.Description, nil)
+		}
+		b.ask(ctx, chat, conv)
+	case "v":
+		i, err := strconv.Atoi(value)
+		if conv == nil || len(conv.names) == 0 || err != nil {
+			return
+		}
+		choices := choicesOf(conv.action.Parameters[conv.names[0]])
+		if i < 0 || i >= len(choices) {
+			return
+		}
+		conv.parameters[conv.names[0]] = choices[i]
+		conv.names = conv.names[1:]
+		b.ask(ctx, chat, conv)
+	case "run":
+		if conv == nil || len(conv.names) > 0 {
+			return
+		}
+		delete(b.conversations, chat)
+		b.execute(ctx, chat, conv)
+	case "cancel":
+		delete(b.conversations, chat)
+		b.send(ctx, chat, "Cancelled.", nil)
+	}
+}
+
+// menu lists the action groups, actions without a group are under Other
+func (b *Bot) menu(ctx context.Context, chat int64) {
+	var groups []string
+	for _, act := range b.actions() {
+		if !slices.Contains(groups, act.Group) {
+			groups = append(groups, act.Group)
+		}
+	}
+	if len(groups) == 0 {
+		b.send(ctx, chat, "There are no actions.", nil)
+		return
+	}
+	sort.Strings(groups)
+	var rows [][]button
+	for _, group := range groups {
+		rows = append(rows, []button{{Text: groupTitle(group), CallbackData: "g:" + group}})
+	}
+	b.send(ctx, chat, "Choose a group:", &keyboard{InlineKeyboard: rows})
+}
+
+func groupTitle(group string) string {
+	if group == "" {
+		return "Other"
+	}
+	return group
+}
+
+// choicesOf returns the values offered as buttons
+func choicesOf(p tinpot.ParameterInfo) []interface{} {
+	switch {
+	case len(p.Enum) > 0:
+		return p.Enum
+	case p.UI != nil && len(p.UI.Choices) > 0:
+		return p.UI.Choices
+	case p.Type == "bool":
+		return []interface{}{true, false}
+	}
+	return nil
+}
+
+// ask asks for the next parameter, or for the confirmation when all are collected
+func (b *Bot) ask(ctx context.Context, chat int64, conv *conversation) {
+	if len(conv.names) == 0 {
+		text := "Run " + conv.action.Name + "?"
+		for _, name := range tinpot.ParameterOrder(conv.action.Parameters) {
+			if value, ok := conv.parameters[name]; ok {
+				data, _ := json.Marshal(value)
+				text += fmt.Sprintf("\n%s = %s", name, data)
+			}
+		}
+		b.send(ctx, chat, text, &keyboard{InlineKeyboard: [][]button{{{Text: "Run", CallbackData: "run"}, {Text: "Cancel", CallbackData: "cancel"}}}})
+		return
+	}
+	name := conv.names[0]
+	p := conv.action.Parameters[name]
+	title := name
+	if p.UI != nil && p.UI.Title != "" {
+		title = p.UI.Title
+	}
+	text := fmt.Sprintf("%s (%s)?", title, p.Type)
+	if p.UI != nil && p.UI.Help != "" {
+		text += "\n" + p.UI.Help
+	}
+	if !p.Required {
+		if p.Default != nil {
+			data, _ := json.Marshal(p.Default)
+			text += fmt.Sprintf("\nSend /skip to keep the default %s.", data)
+		} else {
+			text += "\nSend /skip to leave it empty."
+		}
+	}
+	var markup *keyboard
+	if choices := choicesOf(p); len(choices) > 0 {
+		markup = &keyboard{}
+		for i, choice := range choices {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, []button{{Text: fmt.Sprint(choice), CallbackData: fmt.Sprintf("v:%d", i)}})
+		}
+	}
+	b.send(ctx, chat, text, markup)
+}
+
+// execute starts the action and follows it in a message showing the tail of its logs
+func (b *Bot) execute(ctx context.Context, chat int64, conv *conversation) {
+	id, err := b.host.Execute(b.config.Tenant, conv.action.Name, conv.parameters)
+	if err != nil {
+		b.send(ctx, chat, fmt.Sprintf("Failed to start %s: %v", conv.action.Name, err), nil)
+		return
+	}
+	w := &watch{chat: chat, action: conv.action.Name}
+	w.message = b.send(ctx, chat, w.text(id), nil)
+	b.mu.Lock()
+	b.watches[id] = w
+	b.mu.Unlock()
+	go b.follow(ctx, id, w)
+}
+
+func (w *watch) text(id string) string {
+	text := fmt.Sprintf("%s is running (execution %s)", w.action, id)
+	if len(w.tail) > 0 {
+		text += "\n\n" + strings.Join(w.tail, "\n")
+	}
+	return text
+}
+
+// follow updates the message of the execution with its logs and posts the outcome
+func (b *Bot) follow(ctx context.Context, id string, w *watch) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.watches, id)
+		b.mu.Unlock()
+	}()
+	ticker := time.NewTicker(b.config.UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		b.mu.Lock()
+		text, changed := w.text(id), w.changed
+		w.changed = false
+		b.mu.Unlock()
+		if changed && w.message != 0 {
+			b.edit(ctx, w.chat, w.message, text)
+		}
+
+		rec, err := b.host.Store.Get(id)
+		if err != nil || !rec.Done() {
+			continue
+		}
+		outcome := fmt.Sprintf("%s succeeded (execution %s)", w.action, id)
+		if rec.Status != tinpot.StatusSuccess {
+			outcome = fmt.Sprintf("%s %s (execution %s): %s", w.action, strings.ToLower(rec.Status), id, rec.Error)
+		} else if len(rec.Result) > 0 {
+			result, _ := json.MarshalIndent(rec.Result, "", "  ")
+			outcome += "\n\n" + string(result)
+		}
+		b.send(ctx, w.chat, outcome, nil)
+		return
+	}
+}
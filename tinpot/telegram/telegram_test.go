@@ -0,0 +1,133 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+// fakeAPI serves the Bot API methods used by the bot from a queue of updates
+type fakeAPI struct {
+	mu       sync.Mutex
+	updates  []update
+	messages []map[string]interface{}
+	edits    int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	var result interface{} = true
+	idle := false
+	f.mu.Lock()
+	switch strings.TrimPrefix(r.URL.Path, "/bottoken/") {
+	case "getUpdates":
+		result, idle = append([]update{}, f.updates...), len(f.updates) == 0
+		f.updates = nil
+	case "sendMessage":
+		f.messages = append(f.messages, body)
+		result = message{MessageID: int64(len(f.messages))}
+	case "editMessageText":
+		f.edits++
+	}
+	f.mu.Unlock()
+	if idle {
+		// Long polling without updates
+		time.Sleep(10 * time.Millisecond)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// push queues an update and waits for the bot to answer with a message containing text
+func (f *fakeAPI) push(t *testing.T, u update, text string) map[string]interface{} {
+	t.Helper()
+	f.mu.Lock()
+	sent := len(f.messages)
+	f.updates = append(f.updates, u)
+	f.mu.Unlock()
+	return f.await(t, sent, text)
+}
+
+// await waits for a message containing text, from the sent-th message on
+func (f *fakeAPI) await(t *testing.T, sent int, text string) map[string]interface{} {
+	t.Helper()
+	var reply map[string]interface{}
+	tinpottest.WaitFor(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, m := range f.messages[sent:] {
+			if strings.Contains(m["text"].(string), text) {
+				reply = m
+				return true
+			}
+		}
+		return false
+	})
+	return reply
+}
+
+func text(id int64, s string) update {
+	return update{UpdateID: id, Message: &message{Chat: chat{ID: 7}, Text: s}}
+}
+
+func press(id int64, data string) update {
+	return update{UpdateID: id, CallbackQuery: &callbackQuery{ID: "q", Message: &message{Chat: chat{ID: 7}}, Data: data}}
+}
+
+func TestConversation(t *testing.T) {
+	api := &fakeAPI{}
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	mgr := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "clean_cache", Group: "maintenance", Parameters: map[string]tinpot.ParameterInfo{
+			"days": {Type: "int", Required: true, UI: &tinpot.ParameterUI{Order: 1}},
+			"mode": {Type: "str", Enum: []interface{}{"fast", "thorough"}, UI: &tinpot.ParameterUI{Order: 2}},
+			"dry":  {Type: "bool", Default: false, UI: &tinpot.ParameterUI{Order: 3}},
+		}}, tinpottest.Script{Logs: []tinpottest.LogLine{{Level: "INFO", Message: "removing old entries"}}, LogInterval: 50 * time.Millisecond, Result: map[string]interface{}{"freed": 42}}.Trigger())
+	bot := New(Config{Token: "token", APIURL: ts.URL, Chats: []int64{7}, UpdateInterval: 20 * time.Millisecond})
+	srv := server.NewServer(mgr, nil, server.Options{Plugins: []server.Plugin{bot}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.RunPlugins(ctx)
+
+	menu := api.push(t, text(1, "/actions"), "Choose a group")
+	if rows := menu["reply_markup"].(map[string]interface{})["inline_keyboard"].([]interface{}); len(rows) != 1 {
+		t.Errorf("unexpected groups: %v", rows)
+	}
+	api.push(t, press(2, "g:maintenance"), "maintenance")
+	api.push(t, press(3, "a:clean_cache"), "days (int)?")
+	api.push(t, text(4, "many"), "Invalid value for days")
+	api.push(t, text(5, "5"), "mode (str)?")
+	api.push(t, press(6, "v:1"), "dry (bool)?")
+	confirm := api.push(t, text(7, "/skip"), "Run clean_cache?")
+	if !strings.Contains(confirm["text"].(string), `days = 5`) || !strings.Contains(confirm["text"].(string), `mode = "thorough"`) {
+		t.Errorf("unexpected confirmation: %v", confirm["text"])
+	}
+	api.push(t, press(8, "run"), "clean_cache is running")
+	outcome := api.await(t, 0, "clean_cache succeeded")
+	if !strings.Contains(outcome["text"].(string), `"freed": 42`) {
+		t.Errorf("unexpected outcome: %v", outcome["text"])
+	}
+	if calls := mgr.Calls("clean_cache"); len(calls) != 1 || calls[0]["days"] != float64(5) || calls[0]["mode"] != "thorough" || calls[0]["dry"] != nil {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	api.mu.Lock()
+	if api.edits == 0 {
+		t.Error("log tail not shown")
+	}
+	api.mu.Unlock()
+
+	if reply := api.push(t, update{UpdateID: 9, Message: &message{Chat: chat{ID: 8}, Text: "/actions"}}, "not allowed"); reply["chat_id"] != float64(8) {
+		t.Errorf("unexpected reply: %v", reply)
+	}
+}
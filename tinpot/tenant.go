@@ -0,0 +1,124 @@
+package tinpot
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MQTT_TENANT_TOPIC_PREFIX = "tinpot/tenants/"
+)
+
+// TopicPrefix returns the root of the topic tree of a tenant.
+// The default tenant ("") uses the unscoped tinpot/ layout.
+func TopicPrefix(tenant string) string {
+	if tenant == "" {
+		return "tinpot/"
+	}
+	return MQTT_TENANT_TOPIC_PREFIX + tenant + "/"
+}
+
+// ValidTenant checks that the tenant name fits in a topic level and a qualified name
+func ValidTenant(tenant string) error {
+	if strings.ContainsAny(tenant, "/+#") {
+		return fmt.Errorf("invalid tenant name: %q", tenant)
+	}
+	return nil
+}
+
+// QualifiedName identifies an action across tenants
+func QualifiedName(tenant, name string) string {
+	if tenant == "" {
+		return name
+	}
+	return tenant + "/" + name
+}
+
+// SplitQualifiedName is the inverse of QualifiedName
+func SplitQualifiedName(qualified string) (tenant string, name string) {
+	if t, n, ok := strings.Cut(qualified, "/"); ok {
+		return t, n
+	}
+	return "", qualified
+}
+
+// CatalogTopic is where the coordinator publishes the action catalog changes of the tenant
+func CatalogTopic(tenant string) string {
+	return TopicPrefix(tenant) + "catalog"
+}
+
+// WorkerStatusTopic is where a worker of the tenant keeps its MqttWorkerStatus
+func WorkerStatusTopic(tenant, workerID string) string {
+	return TopicPrefix(tenant) + "workers/" + workerID
+}
+
+// WorkerLoadErrorsTopic is where a worker of the tenant keeps the MqttLoadError list of its discovery
+func WorkerLoadErrorsTopic(tenant, workerID string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/load_errors"
+}
+
+// WorkerTriggerTopic is where a worker of the tenant takes the executions of an action addressed to it
+func WorkerTriggerTopic(tenant, workerID, action string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/actions/" + action + "/trigger"
+}
+
+// WorkerExecPrefix is the root of the execution topics of a worker using TopicLayoutWorker
+func WorkerExecPrefix(tenant, workerID string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/exec/"
+}
+
+// WorkerTelemetryTopic is where a worker of the tenant publishes its MqttWorkerTelemetry
+func WorkerTelemetryTopic(tenant, workerID string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/telemetry"
+}
+
+// WorkerSyncTopic is where a worker of the tenant receives its MqttSyncRequest commands
+func WorkerSyncTopic(tenant, workerID string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/sync"
+}
+
+// SyncTopic is where every worker of the tenant receives MqttSyncRequest commands
+func SyncTopic(tenant string) string {
+	return TopicPrefix(tenant) + "sync"
+}
+
+// WorkerInstallTopic is where a worker of the tenant receives its MqttInstallRequest commands
+func WorkerInstallTopic(tenant, workerID string) string {
+	return WorkerStatusTopic(tenant, workerID) + "/install"
+}
+
+// InstallTopic is where every worker of the tenant receives MqttInstallRequest commands
+func InstallTopic(tenant string) string {
+	return TopicPrefix(tenant) + "install"
+}
+
+// WorkerInstallResultTopic is where a worker of the tenant answers MqttInstallRequest commands
+func WorkerInstallResultTopic(tenant, workerID string) string {
+	return WorkerInstallTopic(tenant, workerID) + "/result"
+}
+
+// ScratchTopic is where the workers of the tenant receive MqttScratchRequest commands
+func ScratchTopic(tenant string) string {
+	return TopicPrefix(tenant) + "scratch"
+}
+
+// RescanTopic is where every worker of the tenant is asked to announce its actions, status
+// and load errors again
+func RescanTopic(tenant string) string {
+	return TopicPrefix(tenant) + "rescan"
+}
+
+// DiagnosticsTopic is where the coordinator reports the malformed messages of the tenant it
+// dropped, as MqttDiagnostic
+func DiagnosticsTopic(tenant string) string {
+	return TopicPrefix(tenant) + "diagnostics"
+}
+
+// IsTenantTopic tells whether a topic is under the TopicPrefix of the tenant, and not under
+// the one of another tenant
+func IsTenantTopic(tenant, topic string) bool {
+	if tenant == "" && strings.HasPrefix(topic, MQTT_TENANT_TOPIC_PREFIX) {
+		return false
+	}
+	return strings.HasPrefix(topic, TopicPrefix(tenant))
+}
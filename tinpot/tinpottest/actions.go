@@ -0,0 +1,157 @@
+// Package tinpottest provides an in-memory ActionManager with scripted actions and
+// helpers running a broker, coordinator and workers in-process for integration tests.
+package tinpottest
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// ActionManager is an in-memory tinpot.ActionManager. The zero value is not usable, see NewActionManager.
+type ActionManager struct {
+	mu           sync.RWMutex
+	actions      map[string]tinpot.ActionInfo
+	triggers     map[string]tinpot.ActionTrigger
+	calls        map[string][]map[string]interface{}
+	disconnected bool
+}
+
+func NewActionManager() *ActionManager {
+	return &ActionManager{
+		actions:  make(map[string]tinpot.ActionInfo),
+		triggers: make(map[string]tinpot.ActionTrigger),
+		calls:    make(map[string][]map[string]interface{}),
+	}
+}
+
+// Add registers an action under its qualified name (see tinpot.QualifiedName)
+func (m *ActionManager) Add(info tinpot.ActionInfo, trigger tinpot.ActionTrigger) *ActionManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	qualified := tinpot.QualifiedName(info.Tenant, info.Name)
+	m.actions[qualified] = info
+	m.triggers[qualified] = trigger
+	return m
+}
+
+func (m *ActionManager) Remove(qualified string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.actions, qualified)
+	delete(m.triggers, qualified)
+}
+
+// SetConnected changes what IsConnected reports
+func (m *ActionManager) SetConnected(connected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnected = !connected
+}
+
+// Calls returns the parameters of every trigger of the action so far
+func (m *ActionManager) Calls(qualified string) []map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]map[string]interface{}(nil), m.calls[qualified]...)
+}
+
+func (m *ActionManager) GetAction(name string) tinpot.ActionTrigger {
+	m.mu.RLock()
+	trigger, ok := m.triggers[name]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		m.mu.Lock()
+		m.calls[name] = append(m.calls[name], parameters)
+		m.mu.Unlock()
+		trigger(parameters, response, logs)
+	}
+}
+
+func (m *ActionManager) ListActions() map[string]tinpot.ActionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]tinpot.ActionInfo, len(m.actions))
+	for name, act := range m.actions {
+		result[name] = act
+	}
+	return result
+}
+
+func (m *ActionManager) IsConnected() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !m.disconnected
+}
+
+type LogLine struct {
+	Level   string
+	Message string
+}
+
+// Script describes the behavior of a scripted action: it emits the logs, waits, then
+// responds with the error if set, otherwise with the result.
+type Script struct {
+	Logs []LogLine
+	// LogInterval is the delay before each log line
+	LogInterval time.Duration
+	// Delay is the wait between the last log line and the response
+	Delay  time.Duration
+	Result map[string]interface{}
+	Error  string
+}
+
+func (s Script) Trigger() tinpot.ActionTrigger {
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		for _, line := range s.Logs {
+			time.Sleep(s.LogInterval)
+			if logs != nil {
+				logs(line.Level, line.Message)
+			}
+		}
+		time.Sleep(s.Delay)
+		if s.Error != "" {
+			response(s.Error, nil)
+		} else {
+			response("", s.Result)
+		}
+	}
+}
+
+// Succeed responds with the result right away
+func Succeed(result map[string]interface{}) tinpot.ActionTrigger {
+	return Script{Result: result}.Trigger()
+}
+
+// Fail responds with the error right away
+func Fail(err string) tinpot.ActionTrigger {
+	return Script{Error: err}.Trigger()
+}
+
+// Echo responds with the parameters it was called with, except the internal "_" prefixed ones
+func Echo() tinpot.ActionTrigger {
+	return func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		result := make(map[string]interface{})
+		for k, v := range parameters {
+			if len(k) == 0 || k[0] != '_' {
+				result[k] = v
+			}
+		}
+		response("", result)
+	}
+}
+
+// Progress reports the steps as progress updates (see tinpot.LogLevelProgress), then succeeds
+func Progress(steps ...tinpot.Progress) tinpot.ActionTrigger {
+	script := Script{}
+	for _, step := range steps {
+		data, _ := json.Marshal(step)
+		script.Logs = append(script.Logs, LogLine{Level: tinpot.LogLevelProgress, Message: string(data)})
+	}
+	return script.Trigger()
+}
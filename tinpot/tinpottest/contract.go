@@ -0,0 +1,210 @@
+package tinpottest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// ContractActions returns an ActionManager with the fixture actions RunActionManagerTests expects.
+// Managers of other runtimes have to provide the same actions:
+//
+//	echo(message)  succeeds with {"message": message}
+//	fail(reason)   fails with a non-empty error
+//	log(lines)     logs "line 1" ... "line <lines>" at INFO level, then succeeds with {"lines": lines}
+func ContractActions() *ActionManager {
+	m := NewActionManager()
+	m.Add(tinpot.ActionInfo{Name: "echo", Parameters: map[string]tinpot.ParameterInfo{"message": {Type: "str", Required: true}}},
+		func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			response("", map[string]interface{}{"message": parameters["message"]})
+		})
+	m.Add(tinpot.ActionInfo{Name: "fail", Parameters: map[string]tinpot.ParameterInfo{"reason": {Type: "str", Required: true}}},
+		func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			response(fmt.Sprint(parameters["reason"]), nil)
+		})
+	m.Add(tinpot.ActionInfo{Name: "log", Parameters: map[string]tinpot.ParameterInfo{"lines": {Type: "int", Required: true}}},
+		func(parameters map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+			lines := int(number(parameters["lines"]))
+			for i := 1; i <= lines; i++ {
+				if logs != nil {
+					logs("INFO", fmt.Sprintf("line %d", i))
+				}
+			}
+			response("", map[string]interface{}{"lines": lines})
+		})
+	return m
+}
+
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+type callResult struct {
+	err    string
+	result map[string]interface{}
+	logs   []string
+	// logsAfterResponse counts log lines delivered after the response
+	logsAfterResponse int
+}
+
+const contractTimeout = 10 * time.Second
+
+// call triggers the action and waits for its response, failing the test if the response
+// does not arrive or arrives more than once. Safe to use from goroutines.
+func call(t *testing.T, trigger tinpot.ActionTrigger, params map[string]interface{}, withLogs bool) callResult {
+	t.Helper()
+	var mu sync.Mutex
+	var res callResult
+	responses := 0
+	done := make(chan struct{})
+
+	var logs tinpot.ActionLogs
+	if withLogs {
+		logs = func(level string, message string) {
+			mu.Lock()
+			defer mu.Unlock()
+			res.logs = append(res.logs, level+" "+message)
+			if responses > 0 {
+				res.logsAfterResponse++
+			}
+		}
+	}
+	go trigger(params, func(err string, result map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		responses++
+		if responses == 1 {
+			res.err = err
+			res.result = result
+			close(done)
+		}
+	}, logs)
+
+	select {
+	case <-done:
+	case <-time.After(contractTimeout):
+		t.Errorf("no response within %v", contractTimeout)
+		return res
+	}
+	// Give late duplicates and stray logs a chance to show up
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if responses != 1 {
+		t.Errorf("response called %d times, expected once", responses)
+	}
+	return res
+}
+
+// RunActionManagerTests checks that the manager returned by factory conforms to the ActionManager
+// contract. The manager must provide the actions described at ContractActions.
+func RunActionManagerTests(t *testing.T, factory func(t *testing.T) tinpot.ActionManager) {
+	mgr := factory(t)
+
+	trigger := func(t *testing.T, name string) tinpot.ActionTrigger {
+		t.Helper()
+		tr := mgr.GetAction(name)
+		if tr == nil {
+			t.Fatalf("action %s not found", name)
+		}
+		return tr
+	}
+
+	t.Run("ListActions", func(t *testing.T) {
+		actions := mgr.ListActions()
+		for _, name := range []string{"echo", "fail", "log"} {
+			act, ok := actions[name]
+			if !ok {
+				t.Errorf("action %s not listed", name)
+				continue
+			}
+			if act.Name != name {
+				t.Errorf("action listed as %s has name %q", name, act.Name)
+			}
+		}
+	})
+
+	t.Run("UnknownAction", func(t *testing.T) {
+		if mgr.GetAction("no_such_action") != nil {
+			t.Errorf("expected nil trigger for an unknown action")
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		res := call(t, trigger(t, "echo"), map[string]interface{}{"message": "hello"}, true)
+		if res.err != "" {
+			t.Fatalf("unexpected error: %s", res.err)
+		}
+		if res.result["message"] != "hello" {
+			t.Errorf("unexpected result: %v", res.result)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		res := call(t, trigger(t, "fail"), map[string]interface{}{"reason": "broken"}, true)
+		if res.err == "" {
+			t.Errorf("expected an error, got result %v", res.result)
+		}
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		res := call(t, trigger(t, "log"), map[string]interface{}{"lines": 3}, true)
+		if res.err != "" {
+			t.Fatalf("unexpected error: %s", res.err)
+		}
+		expected := []string{"INFO line 1", "INFO line 2", "INFO line 3"}
+		if fmt.Sprint(res.logs) != fmt.Sprint(expected) {
+			t.Errorf("expected logs %v, got %v", expected, res.logs)
+		}
+		if res.logsAfterResponse > 0 {
+			t.Errorf("%d log lines delivered after the response", res.logsAfterResponse)
+		}
+		if number(res.result["lines"]) != 3 {
+			t.Errorf("unexpected result: %v", res.result)
+		}
+	})
+
+	t.Run("NilLogs", func(t *testing.T) {
+		res := call(t, trigger(t, "log"), map[string]interface{}{"lines": 2}, false)
+		if res.err != "" {
+			t.Errorf("unexpected error: %s", res.err)
+		}
+	})
+
+	t.Run("InternalParameters", func(t *testing.T) {
+		// "_" prefixed parameters are for the plumbing and must not reach the action
+		res := call(t, trigger(t, "echo"), map[string]interface{}{"message": "hi", "_execution_id": "contract"}, false)
+		if res.err != "" {
+			t.Errorf("unexpected error: %s", res.err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		echo := trigger(t, "echo")
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				message := fmt.Sprintf("message %d", i)
+				res := call(t, echo, map[string]interface{}{"message": message}, false)
+				if res.result["message"] != message {
+					t.Errorf("call %d got result %v", i, res.result)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
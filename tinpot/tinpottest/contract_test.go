@@ -0,0 +1,14 @@
+package tinpottest_test
+
+import (
+	"testing"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/tinpottest"
+)
+
+func TestContractActions(t *testing.T) {
+	tinpottest.RunActionManagerTests(t, func(t *testing.T) tinpot.ActionManager {
+		return tinpottest.ContractActions()
+	})
+}
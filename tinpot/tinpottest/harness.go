@@ -0,0 +1,108 @@
+package tinpottest
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/broker"
+	"github.com/balazsgrill/tinpot/mqtttransport"
+	"github.com/balazsgrill/tinpot/remote"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/worker"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// StartBroker runs an in-process MQTT broker for the duration of the test and returns its URL
+func StartBroker(t testing.TB) string {
+	t.Helper()
+	b, err := broker.Start(broker.Options{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b.URL()
+}
+
+// Connect returns a transport connected to the broker, disconnected at the end of the test
+func Connect(t testing.TB, brokerURL string) *mqtttransport.Transport {
+	t.Helper()
+	opts := mqtt.NewClientOptions().AddBroker(brokerURL)
+	opts.SetClientID("tinpottest-" + uuid.New().String())
+	opts.SetAutoReconnect(true)
+	transport := mqtttransport.New(opts)
+	if err := transport.Connect(); err != nil {
+		t.Fatalf("failed to connect to %s: %v", brokerURL, err)
+	}
+	t.Cleanup(func() { transport.Disconnect(100) })
+	return transport
+}
+
+type Coordinator struct {
+	// URL is the base URL of the HTTP API
+	URL     string
+	Server  *server.Server
+	Manager tinpot.ActionManager
+}
+
+// StartCoordinator serves the HTTP API over the actions announced on the broker
+func StartCoordinator(t testing.TB, brokerURL string, opts server.Options) *Coordinator {
+	t.Helper()
+	if opts.Events == nil {
+		opts.Events = tinpot.NewEventBus()
+	}
+	mgr := remote.NewActionManager(Connect(t, brokerURL), remote.Options{Events: opts.Events})
+	srv := server.NewServer(mgr, server.NewMemoryStore(0), opts)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return &Coordinator{URL: ts.URL, Server: srv, Manager: mgr}
+}
+
+// WaitForAction waits until the action (by qualified name) has been announced to the coordinator
+func (c *Coordinator) WaitForAction(t testing.TB, qualified string) {
+	t.Helper()
+	if !eventually(func() bool { return c.Manager.GetAction(qualified) != nil }) {
+		t.Fatalf("action %s not announced", qualified)
+	}
+}
+
+// WaitFor fails the test if the condition does not become true within 10 seconds
+func WaitFor(t testing.TB, condition func() bool) {
+	t.Helper()
+	if !eventually(condition) {
+		t.Fatalf("condition not met in time")
+	}
+}
+
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// StartWorker serves the actions of mgr on the broker until the end of the test
+func StartWorker(t testing.TB, brokerURL string, mgr tinpot.ActionManager, opts worker.Options) {
+	t.Helper()
+	transport := Connect(t, brokerURL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := worker.NewWorker(transport, mgr, opts).Run(ctx); err != nil && err != context.Canceled {
+			panic(fmt.Sprintf("worker failed: %v", err))
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
@@ -0,0 +1,240 @@
+package tinpottest_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/server"
+	"github.com/balazsgrill/tinpot/tinpottest"
+	"github.com/balazsgrill/tinpot/worker"
+)
+
+func TestHarness(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	coordinator := tinpottest.StartCoordinator(t, broker, server.Options{})
+
+	actions := tinpottest.NewActionManager().
+		Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()).
+		Add(tinpot.ActionInfo{Name: "flaky"}, tinpottest.Script{
+			Logs:        []tinpottest.LogLine{{Level: "INFO", Message: "trying"}},
+			LogInterval: 10 * time.Millisecond,
+			Error:       "DNS outage",
+		}.Trigger())
+	tinpottest.StartWorker(t, broker, actions, worker.Options{})
+	coordinator.WaitForAction(t, "echo")
+	coordinator.WaitForAction(t, "flaky")
+
+	resp, err := http.Post(coordinator.URL+"/api/actions/echo/sync_execute", "application/json",
+		bytes.NewBufferString(`{"parameters": {"message": "hi"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Status string                 `json:"status"`
+		Result map[string]interface{} `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if result.Status != "SUCCESS" || result.Result["message"] != "hi" {
+		t.Errorf("unexpected echo result: %+v", result)
+	}
+	if calls := actions.Calls("echo"); len(calls) != 1 {
+		t.Errorf("expected one call, got %v", calls)
+	}
+
+	// The coordinator clears the retained result once it has received it
+	tinpottest.WaitFor(t, func() bool {
+		return len(retained(t, broker, "tinpot/exec/#")) == 0
+	})
+
+	resp, err = http.Post(coordinator.URL+"/api/actions/flaky/execute", "application/json", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var submitted server.ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+
+	resp, err = http.Get(coordinator.URL + submitted.StreamURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(stream), `"message":"trying"`) || !strings.Contains(string(stream), `"error":"DNS outage"`) {
+		t.Errorf("unexpected stream: %s", stream)
+	}
+
+	// Durations come from the coordinator's clock, the worker's view is kept besides them
+	resp, err = http.Get(coordinator.URL + "/api/executions/" + submitted.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec tinpot.ExecutionRecord
+	json.NewDecoder(resp.Body).Decode(&rec)
+	resp.Body.Close()
+	if rec.AcceptedAt == nil || rec.WorkerTimes == nil || rec.WorkerTimes.CompletedAt == nil || rec.Durations == nil ||
+		rec.Durations.RunSeconds < 0.01 || rec.Durations.WorkerRunSeconds < 0.01 || rec.Durations.TotalSeconds < rec.Durations.RunSeconds {
+		t.Errorf("unexpected timing: accepted %v, worker %+v, durations %+v", rec.AcceptedAt, rec.WorkerTimes, rec.Durations)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	coordinator := tinpottest.StartCoordinator(t, broker, server.Options{})
+
+	resp, err := http.Get(coordinator.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := make(chan tinpot.ExecutionEvent, 100)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var event tinpot.ExecutionEvent
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok && json.Unmarshal([]byte(data), &event) == nil {
+				events <- event
+			}
+		}
+	}()
+	// Events of different sources may arrive in any order
+	var received []tinpot.ExecutionEvent
+	expect := func(eventType string) tinpot.ExecutionEvent {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			for i, event := range received {
+				if event.Type == eventType {
+					received = append(received[:i], received[i+1:]...)
+					return event
+				}
+			}
+			select {
+			case event := <-events:
+				received = append(received, event)
+			case <-timeout:
+				t.Fatalf("no %s event", eventType)
+			}
+		}
+	}
+	expect("connected")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	w := worker.NewWorker(tinpottest.Connect(t, broker), tinpottest.NewActionManager().Add(tinpot.ActionInfo{Name: "echo"}, tinpottest.Echo()), worker.Options{})
+	go func() {
+		defer close(done)
+		w.Run(ctx)
+	}()
+	if event := expect(tinpot.EventWorkerConnected); event.Worker != w.ID() {
+		t.Errorf("unexpected worker: %+v", event)
+	}
+	if event := expect(tinpot.EventActionAdded); event.Action != "echo" {
+		t.Errorf("unexpected action: %+v", event)
+	}
+
+	coordinator.WaitForAction(t, "echo")
+	resp2, err := http.Post(coordinator.URL+"/api/actions/echo/sync_execute", "application/json", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	expect(tinpot.EventStarted)
+	if event := expect(tinpot.EventCompleted); event.Status != tinpot.StatusSuccess {
+		t.Errorf("unexpected completion: %+v", event)
+	}
+
+	cancel()
+	<-done
+	if event := expect(tinpot.EventWorkerDisconnected); event.Worker != w.ID() {
+		t.Errorf("unexpected worker: %+v", event)
+	}
+}
+
+func TestWorkerTelemetry(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	coordinator := tinpottest.StartCoordinator(t, broker, server.Options{})
+	tinpottest.StartWorker(t, broker, tinpottest.NewActionManager(), worker.Options{
+		ID:                "w1",
+		TelemetryInterval: 20 * time.Millisecond,
+		Telemetry: func(sample *tinpot.MqttWorkerTelemetry) {
+			sample.Python = map[string]interface{}{"version": "test"}
+		},
+	})
+
+	var telemetry server.WorkerTelemetryResponse
+	tinpottest.WaitFor(t, func() bool {
+		resp, err := http.Get(coordinator.URL + "/api/workers/w1/telemetry")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&telemetry)
+		return len(telemetry.Samples) >= 2
+	})
+	if sample := telemetry.Samples[1]; sample.MemoryBytes == 0 || sample.Goroutines == 0 || sample.Python["version"] != "test" {
+		t.Errorf("unexpected sample: %+v", sample)
+	}
+
+	resp, err := http.Get(coordinator.URL + "/api/workers/unknown/telemetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("expected 404 for unknown worker, got %d", resp.StatusCode)
+	}
+}
+
+func TestWorkerLoadErrors(t *testing.T) {
+	broker := tinpottest.StartBroker(t)
+	coordinator := tinpottest.StartCoordinator(t, broker, server.Options{})
+	tinpottest.StartWorker(t, broker, tinpottest.NewActionManager(), worker.Options{
+		ID: "w1",
+		LoadErrors: func() []tinpot.MqttLoadError {
+			return []tinpot.MqttLoadError{{Module: "billing", Error: "ModuleNotFoundError: No module named 'stripe'"}}
+		},
+	})
+
+	var loadErrors server.WorkerLoadErrorsResponse
+	tinpottest.WaitFor(t, func() bool {
+		resp, err := http.Get(coordinator.URL + "/api/workers/w1/load_errors")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&loadErrors)
+		return resp.StatusCode == 200
+	})
+	if len(loadErrors.Errors) != 1 || loadErrors.Errors[0].Module != "billing" {
+		t.Errorf("unexpected load errors: %+v", loadErrors)
+	}
+}
+
+// retained returns the topics with a retained message matching the filter
+func retained(t *testing.T, broker string, filter string) []string {
+	var mu sync.Mutex
+	var topics []string
+	transport := tinpottest.Connect(t, broker)
+	defer transport.Disconnect(0)
+	transport.Subscribe(filter, 1, func(topic string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		topics = append(topics, topic)
+	})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	return topics
+}
@@ -0,0 +1,66 @@
+package tinpot
+
+// MessageHandler receives the messages of a subscription
+type MessageHandler func(topic string, payload []byte)
+
+// Transport is the pub/sub connection between coordinators and workers
+type Transport interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Subscribe(topic string, qos byte, handler MessageHandler) error
+	Unsubscribe(topics ...string) error
+	// OnConnect registers a handler called after every (re)connection, subscriptions
+	// and announcements are to be renewed there
+	OnConnect(handler func())
+	IsConnected() bool
+}
+
+// PropertyPublisher is implemented by transports that can attach user properties to messages (MQTT 5)
+type PropertyPublisher interface {
+	PublishWithProperties(topic string, qos byte, retained bool, payload []byte, properties map[string]string) error
+}
+
+// PublishWithProperties publishes with user properties if the transport supports them, plainly otherwise
+func PublishWithProperties(t Transport, topic string, qos byte, retained bool, payload []byte, properties map[string]string) error {
+	if p, ok := t.(PropertyPublisher); ok && len(properties) > 0 {
+		return p.PublishWithProperties(topic, qos, retained, payload, properties)
+	}
+	return t.Publish(topic, qos, retained, payload)
+}
+
+// User properties of the execution messages (trigger, log, result, ack)
+const (
+	PropertyExecutionID = "tinpot-execution-id"
+	PropertyAction      = "tinpot-action"
+	PropertyTenant      = "tinpot-tenant"
+	PropertyTraceID     = "tinpot-trace-id"
+)
+
+// ExecutionProperties returns the user properties correlating the messages of an execution.
+// Empty values are left out.
+func ExecutionProperties(executionID, action, tenant, traceID string) map[string]string {
+	properties := make(map[string]string)
+	for key, value := range map[string]string{
+		PropertyExecutionID: executionID,
+		PropertyAction:      action,
+		PropertyTenant:      tenant,
+		PropertyTraceID:     traceID,
+	} {
+		if value != "" {
+			properties[key] = value
+		}
+	}
+	return properties
+}
+
+// BrokerState is the connection state of one of the brokers of a transport
+type BrokerState struct {
+	URL       string `json:"url"`
+	Connected bool   `json:"connected"`
+	// Mirror brokers only get the announcements, see MirrorTransport
+	Mirror bool `json:"mirror,omitempty"`
+}
+
+// BrokerReporter is implemented by transports that report the state of their brokers
+type BrokerReporter interface {
+	Brokers() []BrokerState
+}
@@ -0,0 +1,153 @@
+package tinpot
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// MaxNameLength is the longest action name, execution ID, worker ID or tenant accepted
+const MaxNameLength = 128
+
+// ValidName tells whether a name can be used as a topic level and a path segment: letters,
+// digits, '_', '-' and '.' (not at the start), at most MaxNameLength long. Action names,
+// execution IDs, worker IDs and tenants are checked against it where they enter.
+func ValidName(name string) bool {
+	if name == "" || len(name) > MaxNameLength || name[0] == '.' {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateAction checks an action registration for mistakes of its author: names unusable
+// in topics, defaults, choices and examples not matching the parameter types, hints
+// referring to unknown parameters. With the catalog (by qualified name) it also checks
+// that choices_from sources exist. It returns the problems found.
+func ValidateAction(act ActionInfo, catalog map[string]ActionInfo) []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !ValidName(act.Name) {
+		report("invalid name %q", act.Name)
+	}
+	names := make([]string, 0, len(act.Parameters))
+	for name := range act.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := act.Parameters[name]
+		if p.Default != nil && !ValueMatches(p, p.Default) {
+			report("parameter %s: default %v does not match the parameter", name, p.Default)
+		}
+		if p.UI == nil {
+			continue
+		}
+		for _, choice := range p.UI.Choices {
+			if !ValueMatches(ParameterInfo{Type: p.Type}, choice) {
+				report("parameter %s: choice %v is not a %s", name, choice, p.Type)
+			}
+		}
+		for other := range p.UI.VisibleIf {
+			if _, ok := act.Parameters[other]; !ok {
+				report("parameter %s: visible_if refers to unknown parameter %s", name, other)
+			}
+		}
+		if source := p.UI.ChoicesFrom; source != nil && catalog != nil {
+			if _, ok := catalog[QualifiedName(act.Tenant, source.Action)]; !ok {
+				report("parameter %s: choices_from refers to unknown action %s", name, source.Action)
+			}
+		}
+	}
+
+	for i, example := range act.Examples {
+		title := example.Title
+		if title == "" {
+			title = fmt.Sprintf("#%d", i+1)
+		}
+		for name, value := range example.Parameters {
+			if p, ok := act.Parameters[name]; !ok {
+				report("example %s: unknown parameter %s", title, name)
+			} else if !ValueMatches(p, value) {
+				report("example %s: %s=%v does not match the parameter", title, name, value)
+			}
+		}
+		for _, name := range names {
+			if _, ok := example.Parameters[name]; !ok && act.Parameters[name].Required {
+				report("example %s: missing required parameter %s", title, name)
+			}
+		}
+	}
+	return problems
+}
+
+// ValueMatches tells whether a value is valid for the parameter: its type (for the known
+// type names), enum values, list items and object properties. Nil always matches.
+func ValueMatches(p ParameterInfo, v interface{}) bool {
+	v = NormalizeJSON(v)
+	if v == nil {
+		return true
+	}
+	if len(p.Enum) > 0 {
+		found := false
+		for _, e := range p.Enum {
+			if reflect.DeepEqual(NormalizeJSON(e), v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	switch p.Type {
+	case "str":
+		_, ok := v.(string)
+		return ok
+	case "int":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "float":
+		_, ok := v.(float64)
+		return ok
+	case "bool":
+		_, ok := v.(bool)
+		return ok
+	case "list":
+		items, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		if p.Items != nil {
+			for _, item := range items {
+				if !ValueMatches(*p.Items, item) {
+					return false
+				}
+			}
+		}
+		return true
+	case "dict":
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for name, field := range fields {
+			if prop, ok := p.Properties[name]; ok && !ValueMatches(prop, field) {
+				return false
+			}
+		}
+		return true
+	}
+	// Other types are not checked
+	return true
+}
@@ -0,0 +1,61 @@
+package tinpot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateAction(t *testing.T) {
+	act := ActionInfo{
+		Name: "deploy",
+		Parameters: map[string]ParameterInfo{
+			"env":   {Type: "str", Required: true, UI: &ParameterUI{Choices: []interface{}{"staging", 3}}},
+			"count": {Type: "int", Default: 1.5},
+			"mode":  {Type: "str", Default: "slow", Enum: []interface{}{"fast", "safe"}},
+			"hosts": {Type: "list", Items: &ParameterInfo{Type: "str"}, UI: &ParameterUI{
+				VisibleIf:   map[string]interface{}{"region": "eu"},
+				ChoicesFrom: &ChoiceSource{Action: "list_hosts"},
+			}},
+		},
+		Examples: []ActionExample{{Title: "bad", Parameters: map[string]interface{}{"hosts": []interface{}{1}, "extra": true}}},
+	}
+	want := []string{
+		`parameter count: default 1.5 does not match the parameter`,
+		`parameter env: choice 3 is not a str`,
+		`parameter hosts: visible_if refers to unknown parameter region`,
+		`parameter hosts: choices_from refers to unknown action list_hosts`,
+		`parameter mode: default slow does not match the parameter`,
+	}
+	got := ValidateAction(act, map[string]ActionInfo{})
+	if !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("ValidateAction() = %q", got)
+	}
+	// Example problems depend on map order
+	if len(got) != len(want)+3 {
+		t.Errorf("expected 3 example problems, got %q", got[len(want):])
+	}
+
+	if problems := ValidateAction(ActionInfo{Name: "ok", Parameters: map[string]ParameterInfo{"n": {Type: "int", Default: 3}}}, nil); len(problems) != 0 {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"deploy":                             true,
+		"deploy_app-v1.2":                    true,
+		"":                                   false,
+		".hidden":                            false,
+		"a/b":                                false,
+		"a+":                                 false,
+		"a#":                                 false,
+		"a b":                                false,
+		"deploy@canary":                      false,
+		strings.Repeat("a", MaxNameLength+1): false,
+	} {
+		if ValidName(name) != valid {
+			t.Errorf("ValidName(%q) != %v", name, valid)
+		}
+	}
+}
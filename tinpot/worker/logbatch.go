@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// logBatcher collects the log entries of an execution and publishes them together when
+// the batch reaches its size or has waited for the interval
+type logBatcher struct {
+	interval time.Duration
+	maxBytes int
+	publish  func([]tinpot.MqttLogEntry)
+
+	mu      sync.Mutex
+	entries []tinpot.MqttLogEntry
+	size    int
+	timer   *time.Timer
+}
+
+func (b *logBatcher) add(entry tinpot.MqttLogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, entry)
+	b.size += len(entry.Message) + len(entry.Level) + len(entry.Timestamp)
+	if b.size >= b.maxBytes {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.flush)
+	}
+}
+
+// flush publishes the pending entries, e.g. before the result so the logs come first
+func (b *logBatcher) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+// flushLocked publishes while holding the lock, which keeps the batches in order
+func (b *logBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.entries) == 0 {
+		return
+	}
+	b.publish(b.entries)
+	b.entries = nil
+	b.size = 0
+}
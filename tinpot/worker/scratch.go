@@ -0,0 +1,240 @@
+package worker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+var (
+	// scratchCheckInterval is how often the size of a scratch directory is checked against the quota
+	scratchCheckInterval = time.Second
+	// scratchSweepInterval is how often the kept scratch directories are checked for expiry
+	scratchSweepInterval = time.Minute
+)
+
+// scratchNamePattern restricts the execution ids naming scratch directories
+var scratchNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// scratchSpace manages the execution directories in Options.ScratchDir
+type scratchSpace struct {
+	dir       string
+	retention time.Duration
+	quota     int64
+
+	mu sync.Mutex
+	// running are the directories of the executions in progress
+	running map[string]bool
+}
+
+func (s *scratchSpace) path(executionID string) (string, error) {
+	if !scratchNamePattern.MatchString(executionID) {
+		return "", fmt.Errorf("invalid execution id %q", executionID)
+	}
+	return filepath.Join(s.dir, executionID), nil
+}
+
+// create makes the empty directory of an execution, replacing a kept one of a previous attempt
+func (s *scratchSpace) create(executionID string) (string, error) {
+	path, err := s.path(executionID)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[path] {
+		return "", fmt.Errorf("execution %s is already running", executionID)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", err
+	}
+	s.running[path] = true
+	return path, nil
+}
+
+// release removes the directory of a finished execution, or keeps it for the retention if it failed
+func (s *scratchSpace) release(path string, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, path)
+	if failed && s.retention > 0 {
+		// The retention starts now, whenever the directory was last written
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return
+	}
+	if err := os.RemoveAll(path); err != nil {
+		log.Printf("Failed to remove scratch directory %s: %v", path, err)
+	}
+}
+
+// sweep removes the kept directories older than the retention, including those left behind
+// by a previous run of the worker
+func (s *scratchSpace) sweep() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		path := filepath.Join(s.dir, entry.Name())
+		if s.running[path] {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) >= s.retention {
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Failed to remove scratch directory %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// watch calls exceeded once the directory grows over the quota, checking until done is closed
+func (s *scratchSpace) watch(path string, done <-chan struct{}, exceeded func(size int64)) {
+	ticker := time.NewTicker(scratchCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if size := dirSize(path); size > s.quota {
+				exceeded(size)
+				return
+			}
+		}
+	}
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// archive packs the directory of an execution into a gzip compressed tar file. Files other
+// than regular ones and directories are left out.
+func (s *scratchSpace) archive(executionID string) ([]byte, error) {
+	path, err := s.path(executionID)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == path || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, file)
+		header.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, header.Size)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// subscribeToScratch answers the download requests of the scratch directories the worker keeps
+func (w *Worker) subscribeToScratch() {
+	if w.scratch == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	topic := tinpot.ScratchTopic(w.opts.Tenant)
+	err := w.transport.Subscribe(topic, 1, func(topic string, payload []byte) {
+		var req tinpot.MqttScratchRequest
+		if _, err := tinpot.DecodeMessage(payload, "", &req); err != nil {
+			log.Printf("Invalid scratch request: %v", err)
+			return
+		}
+		if !strings.HasPrefix(req.ReplyTopic, topic+"/") {
+			log.Printf("Rejected scratch request: topic %s is outside of %s", req.ReplyTopic, topic)
+			return
+		}
+		go w.sendScratch(&req)
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to %s: %v", topic, err)
+		return
+	}
+	w.subscribed = append(w.subscribed, topic)
+}
+
+func (w *Worker) sendScratch(req *tinpot.MqttScratchRequest) {
+	archive, err := w.scratch.archive(req.ExecutionID)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Kept by another worker, if any
+		return
+	}
+	reply := tinpot.MqttScratchArchive{WorkerID: w.opts.ID, Archive: archive}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	payload, _ := json.Marshal(reply)
+	if err := w.transport.Publish(req.ReplyTopic, 1, false, payload); err != nil {
+		log.Printf("Failed to publish the scratch directory of %s: %v", req.ExecutionID, err)
+	}
+}
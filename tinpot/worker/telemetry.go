@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"encoding/json"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+)
+
+// processStats is a snapshot of the resources used by the worker process
+type processStats struct {
+	cpuTime     time.Duration // user + system time consumed so far
+	memoryBytes uint64
+	memoryTotal uint64
+	diskFree    uint64
+	diskTotal   uint64
+}
+
+func (w *Worker) publishTelemetry() {
+	disk := w.opts.TelemetryDisk
+	if disk == "" {
+		disk = "."
+	}
+	stats := readProcessStats(disk)
+	now := time.Now()
+
+	sample := tinpot.MqttWorkerTelemetry{
+		Timestamp:   now.Format(time.RFC3339),
+		MemoryBytes: stats.memoryBytes,
+		MemoryTotal: stats.memoryTotal,
+		DiskFree:    stats.diskFree,
+		DiskTotal:   stats.diskTotal,
+		Goroutines:  runtime.NumGoroutine(),
+	}
+	if !w.lastSample.IsZero() {
+		if elapsed := now.Sub(w.lastSample); elapsed > 0 {
+			sample.CPUPercent = 100 * float64(stats.cpuTime-w.lastCPUTime) / float64(elapsed)
+		}
+	}
+	w.lastSample, w.lastCPUTime = now, stats.cpuTime
+	if w.opts.Telemetry != nil {
+		w.opts.Telemetry(&sample)
+	}
+
+	payload, _ := json.Marshal(sample)
+	if err := w.transport.Publish(tinpot.WorkerTelemetryTopic(w.opts.Tenant, w.opts.ID), 0, false, payload); err != nil {
+		log.Printf("Failed to publish telemetry: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func readProcessStats(disk string) processStats {
+	var stats processStats
+	var usage syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &usage) == nil {
+		stats.cpuTime = time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	}
+	// Resident pages are the second field of statm
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			pages, _ := strconv.ParseUint(fields[1], 10, 64)
+			stats.memoryBytes = pages * uint64(os.Getpagesize())
+		}
+	}
+	var info syscall.Sysinfo_t
+	if syscall.Sysinfo(&info) == nil {
+		stats.memoryTotal = uint64(info.Totalram) * uint64(info.Unit)
+	}
+	var fs syscall.Statfs_t
+	if syscall.Statfs(disk, &fs) == nil {
+		stats.diskFree = fs.Bavail * uint64(fs.Bsize)
+		stats.diskTotal = fs.Blocks * uint64(fs.Bsize)
+	}
+	return stats
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package worker
+
+import "runtime"
+
+// readProcessStats only reports the Go heap outside of Linux
+func readProcessStats(disk string) processStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return processStats{memoryBytes: mem.Sys}
+}
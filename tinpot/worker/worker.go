@@ -0,0 +1,656 @@
+// Package worker serves the actions of an ActionManager over a tinpot.Transport:
+// it announces the actions, listens on their trigger topics and publishes logs and results.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/google/uuid"
+)
+
+type Options struct {
+	// Tenant whose topic tree the actions are announced in, empty for the default tenant
+	Tenant string
+	// ID identifies the worker in its presence status, random when empty. To report crashes
+	// too, set OfflineStatus as retained last will on tinpot.WorkerStatusTopic(Tenant, ID).
+	ID string
+	// TelemetryInterval is how often resource usage is published, 0 disables telemetry
+	TelemetryInterval time.Duration
+	// TelemetryDisk is the path whose file system is reported (default: working directory)
+	TelemetryDisk string
+	// Telemetry adds runtime specific statistics to each sample, optional
+	Telemetry func(*tinpot.MqttWorkerTelemetry)
+	// LoadErrors reports the action modules that failed to load, optional
+	LoadErrors func() []tinpot.MqttLoadError
+	// Delivery of the protocol messages, tinpot.DefaultDelivery when nil
+	Delivery *tinpot.DeliveryConfig
+	// ResultAckTimeout is the wait for the first acknowledgement of a result before it is
+	// published again, doubled on each retry (default 2 seconds)
+	ResultAckTimeout time.Duration
+	// ResultRetries is the number of republications of an unacknowledged result (default 5)
+	ResultRetries int
+	// LogBatchInterval is how long log entries are collected before they are published
+	// together (default 100ms), negative to publish each entry on its own
+	LogBatchInterval time.Duration
+	// LogBatchBytes publishes a batch early when its entries reach the size (default 32 KiB)
+	LogBatchBytes int
+	// TopicLayout of the trigger and execution topics, tinpot.TopicLayoutShared when empty
+	TopicLayout string
+	// Track tinpot.TrackCanary announces the actions as canary versions, which the coordinator
+	// sends a share of the executions to. Stable when empty.
+	Track string
+	// SigningKey verifies the execution requests, unsigned or invalid ones are dropped.
+	// Optional, requests are not checked without it.
+	SigningKey *tinpot.SigningKey
+	// Sync is called with the ref of the sync commands of the coordinator (see
+	// tinpot.MqttSyncRequest), to update the actions. Optional, the commands are ignored without it.
+	Sync func(ref string)
+	// Install deploys the bundles of the install commands (see tinpot.MqttInstallRequest).
+	// Optional, the commands are ignored without it.
+	Install func(req *tinpot.MqttInstallRequest) (*tinpot.BundleInfo, error)
+	// Installed is called once the result of a successful installation is published, e.g. to
+	// restart and load the new actions
+	Installed func()
+	// Labels of the worker, matched by the selectors of the actions and executions
+	Labels map[string]string
+	// ScratchDir is where each execution gets an empty directory of its own, passed to the
+	// runtime in the tinpot.ScratchDirParameter. Optional, executions get none without it.
+	ScratchDir string
+	// ScratchRetention is how long the directories of failed executions are kept for debugging
+	// (see tinpot.MqttScratchRequest), 0 removes them right away like those of successful ones
+	ScratchRetention time.Duration
+	// ScratchQuota fails the executions whose directory grows larger (bytes), 0 for no limit
+	ScratchQuota int64
+	// Cancel stops the running executions when Run's context is cancelled, e.g. by cancelling
+	// the coroutines of the async actions, so they can clean up and report. Optional.
+	Cancel func()
+	// ShutdownGrace is how long Run waits for the running executions to publish their results
+	// once cancelled, before going offline (default 10 seconds)
+	ShutdownGrace time.Duration
+	// Environment is attached to every result, with the worker ID, the bundle of the action
+	// and the host name if empty
+	Environment tinpot.ExecutionEnvironment
+}
+
+type Worker struct {
+	transport tinpot.Transport
+	mgr       tinpot.ActionManager
+	opts      Options
+
+	mu         sync.Mutex
+	subscribed []string
+	installMu  sync.Mutex
+	scratch    *scratchSpace
+	// running counts the executions until their result is published
+	running sync.WaitGroup
+
+	// previous telemetry sample, for the CPU usage
+	lastSample  time.Time
+	lastCPUTime time.Duration
+}
+
+func NewWorker(transport tinpot.Transport, mgr tinpot.ActionManager, opts Options) *Worker {
+	if opts.ID == "" {
+		opts.ID = uuid.New().String()
+	}
+	if opts.ResultAckTimeout == 0 {
+		opts.ResultAckTimeout = 2 * time.Second
+	}
+	if opts.ResultRetries == 0 {
+		opts.ResultRetries = 5
+	}
+	if opts.LogBatchInterval == 0 {
+		opts.LogBatchInterval = 100 * time.Millisecond
+	}
+	if opts.ShutdownGrace == 0 {
+		opts.ShutdownGrace = 10 * time.Second
+	}
+	if opts.LogBatchBytes == 0 {
+		opts.LogBatchBytes = 32 << 10
+	}
+	if opts.Delivery == nil {
+		delivery := tinpot.DefaultDelivery()
+		opts.Delivery = &delivery
+	}
+	if opts.Environment.Hostname == "" {
+		opts.Environment.Hostname, _ = os.Hostname()
+	}
+	opts.Environment.WorkerID = opts.ID
+	// Only canary workers announce their track, stable ones look like those predating tracks
+	if opts.Track != tinpot.TrackCanary {
+		opts.Track = ""
+	}
+	w := &Worker{
+		transport: transport,
+		mgr:       mgr,
+		opts:      opts,
+	}
+	if opts.ScratchDir != "" {
+		w.scratch = &scratchSpace{
+			dir:       opts.ScratchDir,
+			retention: opts.ScratchRetention,
+			quota:     opts.ScratchQuota,
+			running:   map[string]bool{},
+		}
+	}
+	return w
+}
+
+// Run announces the actions and serves them until ctx is cancelled. Announcements and
+// subscriptions are renewed whenever the transport reconnects.
+func (w *Worker) Run(ctx context.Context) error {
+	connected := make(chan struct{}, 1)
+	w.transport.OnConnect(func() {
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	})
+	if w.transport.IsConnected() {
+		connected <- struct{}{}
+	}
+	rescan := make(chan struct{}, 1)
+	var telemetry <-chan time.Time
+	if w.opts.TelemetryInterval > 0 {
+		ticker := time.NewTicker(w.opts.TelemetryInterval)
+		defer ticker.Stop()
+		telemetry = ticker.C
+	}
+	var sweep <-chan time.Time
+	if w.scratch != nil {
+		if err := os.MkdirAll(w.scratch.dir, 0755); err != nil {
+			return fmt.Errorf("scratch directory: %w", err)
+		}
+		w.scratch.sweep()
+		if w.scratch.retention > 0 {
+			ticker := time.NewTicker(scratchSweepInterval)
+			defer ticker.Stop()
+			sweep = ticker.C
+		}
+	}
+
+	for {
+		select {
+		case <-telemetry:
+			w.publishTelemetry()
+		case <-sweep:
+			w.scratch.sweep()
+		case <-connected:
+			w.announceActions()
+			w.subscribeToActions()
+			w.subscribeToSync()
+			w.subscribeToInstall()
+			w.subscribeToScratch()
+			w.subscribeToRescan(rescan)
+			w.publishStatus()
+			w.publishLoadErrors()
+		case <-rescan:
+			w.announceActions()
+			w.publishStatus()
+			w.publishLoadErrors()
+		case <-ctx.Done():
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if len(w.subscribed) > 0 {
+				w.transport.Unsubscribe(w.subscribed...)
+			}
+			// No new requests arrive, the running ones report before the worker goes offline
+			if w.opts.Cancel != nil {
+				w.opts.Cancel()
+			}
+			w.awaitRunning()
+			w.transport.Publish(tinpot.WorkerStatusTopic(w.opts.Tenant, w.opts.ID), 1, true, OfflineStatus(w.opts.ID))
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *Worker) triggerTopicForAction(actionName string) string {
+	if w.opts.TopicLayout == tinpot.TopicLayoutWorker {
+		return tinpot.WorkerTriggerTopic(w.opts.Tenant, w.opts.ID, actionName)
+	}
+	return fmt.Sprintf("%sactions/%s/trigger", tinpot.TopicPrefix(w.opts.Tenant), w.announcedName(actionName))
+}
+
+// execPrefix is announced to the coordinator, empty for the shared layout
+func (w *Worker) execPrefix() string {
+	if w.opts.TopicLayout == tinpot.TopicLayoutWorker {
+		return tinpot.WorkerExecPrefix(w.opts.Tenant, w.opts.ID)
+	}
+	return ""
+}
+
+func (w *Worker) announceTopicForAction(actionName string) string {
+	return fmt.Sprintf("%sactions/%s", tinpot.TopicPrefix(w.opts.Tenant), w.announcedName(actionName))
+}
+
+// announcedName is the name of the action on its track, see tinpot.CanaryName
+func (w *Worker) announcedName(actionName string) string {
+	if w.opts.Track == tinpot.TrackCanary {
+		return tinpot.CanaryName(actionName)
+	}
+	return actionName
+}
+
+func (w *Worker) toMqttAction(act tinpot.ActionInfo) tinpot.MqttAction {
+	return tinpot.MqttAction{
+		Description:   act.Description,
+		Group:         act.Group,
+		Parameters:    act.Parameters,
+		TriggerTopic:  w.triggerTopicForAction(act.Name),
+		Docs:          act.Docs,
+		Examples:      act.Examples,
+		Translations:  act.Translations,
+		ExecPrefix:    w.execPrefix(),
+		Encodings:     tinpot.Encodings,
+		Formats:       tinpot.Formats,
+		SchemaVersion: tinpot.SchemaVersion,
+		Bundle:        act.Bundle,
+		Sandbox:       act.Sandbox,
+		RunAs:         act.RunAs,
+		Selector:      act.Selector,
+		Debounce:      act.Debounce,
+		Capabilities:  w.capabilities(act),
+		Track:         w.opts.Track,
+	}
+}
+
+// capabilities are the ones of the worker and those its runtime declares for the action
+func (w *Worker) capabilities(act tinpot.ActionInfo) []string {
+	capabilities := []string{tinpot.CapabilityProgress, tinpot.CapabilityCompression}
+	if w.scratch != nil && w.scratch.retention > 0 {
+		capabilities = append(capabilities, tinpot.CapabilityArtifacts)
+	}
+	for _, capability := range act.Capabilities {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+func (w *Worker) announceActions() {
+	for _, act := range w.mgr.ListActions() {
+		payload, _ := json.Marshal(w.toMqttAction(act))
+		announce := w.opts.Delivery.Announce
+		if err := w.transport.Publish(w.announceTopicForAction(act.Name), announce.QoS, announce.Retained, payload); err != nil {
+			log.Printf("Failed to announce action %s: %v", act.Name, err)
+		}
+	}
+}
+
+// ID returns the worker's presence identifier
+func (w *Worker) ID() string {
+	return w.opts.ID
+}
+
+// OfflineStatus is the presence status of a worker that went away
+func OfflineStatus(id string) []byte {
+	payload, _ := json.Marshal(tinpot.MqttWorkerStatus{ID: id})
+	return payload
+}
+
+func (w *Worker) publishStatus() {
+	status := tinpot.MqttWorkerStatus{ID: w.opts.ID, Online: true, Since: time.Now().Format(time.RFC3339), Labels: w.opts.Labels, TopicLayout: w.opts.TopicLayout, Track: w.opts.Track}
+	if status.TopicLayout == "" {
+		status.TopicLayout = tinpot.TopicLayoutShared
+	}
+	for _, act := range w.mgr.ListActions() {
+		status.Actions = append(status.Actions, act.Name)
+	}
+	sort.Strings(status.Actions)
+	payload, _ := json.Marshal(status)
+	if err := w.transport.Publish(tinpot.WorkerStatusTopic(w.opts.Tenant, w.opts.ID), 1, true, payload); err != nil {
+		log.Printf("Failed to publish worker status: %v", err)
+	}
+}
+
+// publishLoadErrors replaces the retained load errors of the previous run, even with an empty list
+func (w *Worker) publishLoadErrors() {
+	if w.opts.LoadErrors == nil {
+		return
+	}
+	loadErrors := w.opts.LoadErrors()
+	if loadErrors == nil {
+		loadErrors = []tinpot.MqttLoadError{}
+	}
+	payload, _ := json.Marshal(loadErrors)
+	if err := w.transport.Publish(tinpot.WorkerLoadErrorsTopic(w.opts.Tenant, w.opts.ID), 1, true, payload); err != nil {
+		log.Printf("Failed to publish load errors: %v", err)
+	}
+}
+
+func (w *Worker) subscribeToActions() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribed = w.subscribed[:0]
+	for _, act := range w.mgr.ListActions() {
+		name := act.Name
+		// The coordinator addresses the executions it routes to the worker's own topic
+		topics := []string{w.triggerTopicForAction(name)}
+		if direct := tinpot.WorkerTriggerTopic(w.opts.Tenant, w.opts.ID, name); direct != topics[0] {
+			topics = append(topics, direct)
+		}
+		for _, topic := range topics {
+			err := w.transport.Subscribe(topic, w.opts.Delivery.Trigger.QoS, func(topic string, payload []byte) {
+				// Counted until executeAction takes over, so Run can't miss a starting execution
+				w.running.Add(1)
+				go func() {
+					defer w.running.Done()
+					w.executeAction(name, topic, payload)
+				}()
+			})
+			if err != nil {
+				log.Printf("Failed to subscribe to %s: %v", topic, err)
+				continue
+			}
+			w.subscribed = append(w.subscribed, topic)
+		}
+	}
+}
+
+// subscribeToInstall listens for the install commands of the worker and of the whole tenant.
+// Installations run one at a time.
+func (w *Worker) subscribeToInstall() {
+	if w.opts.Install == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, topic := range []string{tinpot.WorkerInstallTopic(w.opts.Tenant, w.opts.ID), tinpot.InstallTopic(w.opts.Tenant)} {
+		err := w.transport.Subscribe(topic, 1, func(topic string, payload []byte) {
+			var req tinpot.MqttInstallRequest
+			if _, err := tinpot.DecodeMessage(payload, "", &req); err != nil {
+				log.Printf("Invalid install request: %v", err)
+				return
+			}
+			go w.install(&req)
+		})
+		if err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+			continue
+		}
+		w.subscribed = append(w.subscribed, topic)
+	}
+}
+
+func (w *Worker) install(req *tinpot.MqttInstallRequest) {
+	w.installMu.Lock()
+	defer w.installMu.Unlock()
+	result := tinpot.MqttInstallResult{ID: req.ID, WorkerID: w.opts.ID}
+	bundle, err := w.opts.Install(req)
+	if err != nil {
+		log.Printf("Failed to install bundle: %v", err)
+		result.Error = err.Error()
+	}
+	result.Bundle = bundle
+	payload, _ := json.Marshal(result)
+	if err := w.transport.Publish(tinpot.WorkerInstallResultTopic(w.opts.Tenant, w.opts.ID), 1, false, payload); err != nil {
+		log.Printf("Failed to publish the install result: %v", err)
+	}
+	if err == nil && w.opts.Installed != nil {
+		w.opts.Installed()
+	}
+}
+
+// subscribeToSync listens for the sync commands of the worker and of the whole tenant
+func (w *Worker) subscribeToSync() {
+	if w.opts.Sync == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, topic := range []string{tinpot.WorkerSyncTopic(w.opts.Tenant, w.opts.ID), tinpot.SyncTopic(w.opts.Tenant)} {
+		err := w.transport.Subscribe(topic, 1, func(topic string, payload []byte) {
+			var req tinpot.MqttSyncRequest
+			if _, err := tinpot.DecodeMessage(payload, "", &req); err != nil {
+				log.Printf("Invalid sync request: %v", err)
+				return
+			}
+			go w.opts.Sync(req.Ref)
+		})
+		if err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+			continue
+		}
+		w.subscribed = append(w.subscribed, topic)
+	}
+}
+
+// environment is the one of the worker with the bundle of the action
+func (w *Worker) environment(actionName string) *tinpot.ExecutionEnvironment {
+	environment := w.opts.Environment
+	if act, ok := w.mgr.ListActions()[actionName]; ok {
+		environment.Bundle = act.Bundle
+	}
+	return &environment
+}
+
+// subscribeToRescan listens for the coordinator asking the workers of the tenant to announce
+// themselves again, e.g. after their retained announcements were cleared
+func (w *Worker) subscribeToRescan(rescan chan<- struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	topic := tinpot.RescanTopic(w.opts.Tenant)
+	err := w.transport.Subscribe(topic, 1, func(topic string, payload []byte) {
+		select {
+		case rescan <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to %s: %v", topic, err)
+		return
+	}
+	w.subscribed = append(w.subscribed, topic)
+}
+
+// awaitRunning waits for the running executions to publish their results, up to ShutdownGrace
+func (w *Worker) awaitRunning() {
+	done := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.opts.ShutdownGrace):
+		log.Printf("Executions still running after %v, stopping anyway", w.opts.ShutdownGrace)
+	}
+}
+
+func (w *Worker) sendResult(req tinpot.MqttExecutionRequest, properties map[string]string, status string, result interface{}, error string, usage *tinpot.ResourceUsage) {
+	now := time.Now()
+	resp := tinpot.MqttResultResponse{
+		Status:      status,
+		Result:      result,
+		Error:       error,
+		CompletedAt: now.Format(time.RFC3339Nano),
+		Usage:       usage,
+		Environment: w.environment(properties[tinpot.PropertyAction]),
+	}
+	if usage != nil {
+		resp.StartedAt = now.Add(-time.Duration(usage.DurationSeconds * float64(time.Second))).Format(time.RFC3339Nano)
+	}
+	payload, _ := tinpot.MarshalMessage(req.ContentFormat, req.SchemaVersion, tinpot.MessageResult, resp)
+	payload = tinpot.CompressPayload(req.ContentEncoding, payload)
+	if req.AckTopic == "" {
+		w.publishResult(req, properties, payload)
+		return
+	}
+
+	acked := make(chan struct{})
+	var once sync.Once
+	err := w.transport.Subscribe(req.AckTopic, w.opts.Delivery.Result.QoS, func(topic string, payload []byte) {
+		once.Do(func() { close(acked) })
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to %s: %v", req.AckTopic, err)
+	}
+	w.publishResult(req, properties, payload)
+	// The action may hold resources (e.g. the interpreter lock) until the callback returns
+	go w.awaitAck(req, properties, payload, acked)
+}
+
+// awaitAck republishes the result with exponential backoff until the coordinator acknowledges it
+func (w *Worker) awaitAck(req tinpot.MqttExecutionRequest, properties map[string]string, payload []byte, acked <-chan struct{}) {
+	defer w.transport.Unsubscribe(req.AckTopic)
+	timeout := w.opts.ResultAckTimeout
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-acked:
+			return
+		case <-time.After(timeout):
+		}
+		if attempt >= w.opts.ResultRetries {
+			log.Printf("Result of execution %s was not acknowledged", req.ExecutionID)
+			return
+		}
+		timeout *= 2
+		w.publishResult(req, properties, payload)
+	}
+}
+
+func (w *Worker) publishResult(req tinpot.MqttExecutionRequest, properties map[string]string, payload []byte) {
+	delivery := w.opts.Delivery.Result
+	if err := tinpot.PublishWithProperties(w.transport, req.ResultTopic, delivery.QoS, delivery.Retained, payload, properties); err != nil {
+		log.Printf("Failed to publish result: %v", err)
+	}
+}
+
+func (w *Worker) executeAction(actionName, topic string, payload []byte) {
+	// The topics of a forged request can't be trusted either, so it is not answered
+	if w.opts.SigningKey != nil {
+		if err := w.opts.SigningKey.VerifyRequest(topic, payload); err != nil {
+			log.Printf("Rejected execution request of %s: %v", actionName, err)
+			return
+		}
+	}
+	var req tinpot.MqttExecutionRequest
+	version, err := tinpot.DecodeMessage(payload, tinpot.MessageExecutionRequest, &req)
+	if err != nil {
+		log.Printf("Failed to unmarshal action %s: %v", actionName, err)
+		return
+	}
+	req.SchemaVersion = version
+	if prefix := w.execPrefix(); prefix != "" {
+		for _, topic := range []string{req.ResultTopic, req.LogTopic, req.AckTopic, req.AcceptedTopic} {
+			if topic != "" && !strings.HasPrefix(topic, prefix) {
+				log.Printf("Rejected execution request of %s: topic %s is outside of %s", actionName, topic, prefix)
+				return
+			}
+		}
+	}
+
+	properties := tinpot.ExecutionProperties(req.ExecutionID, actionName, w.opts.Tenant, req.TraceID)
+	if req.ExpiresAt != nil && time.Now().After(*req.ExpiresAt) {
+		w.sendResult(req, properties, "FAILURE", nil, tinpot.ExpiredError, nil)
+		return
+	}
+	trigger := w.mgr.GetAction(actionName)
+	if trigger == nil {
+		w.sendResult(req, properties, "FAILURE", nil, fmt.Sprintf("Action not found: %s", actionName), nil)
+		return
+	}
+	if req.Parameters == nil {
+		req.Parameters = map[string]interface{}{}
+	}
+	var scratchDir string
+	if w.scratch != nil {
+		if scratchDir, err = w.scratch.create(req.ExecutionID); err != nil {
+			w.sendResult(req, properties, "FAILURE", nil, fmt.Sprintf("Failed to create the scratch directory: %v", err), nil)
+			return
+		}
+		req.Parameters[tinpot.ScratchDirParameter] = scratchDir
+	}
+	// The runtime adds the CPU time and memory it measured, if it can
+	started := time.Now()
+	if req.AcceptedTopic != "" {
+		data, _ := tinpot.MarshalMessage(req.ContentFormat, req.SchemaVersion, tinpot.MessageAccepted, tinpot.MqttAccepted{WorkerID: w.opts.ID, AcceptedAt: started.Format(time.RFC3339Nano)})
+		tinpot.PublishWithProperties(w.transport, req.AcceptedTopic, w.opts.Delivery.Result.QoS, false, data, properties)
+	}
+	var usageMu sync.Mutex
+	var usage tinpot.ResourceUsage
+	req.Parameters[tinpot.UsageParameter] = tinpot.UsageReport(func(u tinpot.ResourceUsage) {
+		usageMu.Lock()
+		defer usageMu.Unlock()
+		usage = u
+	})
+
+	publishLogs := func(v interface{}) {
+		data, _ := tinpot.MarshalMessage(req.ContentFormat, req.SchemaVersion, tinpot.MessageLog, v)
+		data = tinpot.CompressPayload(req.ContentEncoding, data)
+		tinpot.PublishWithProperties(w.transport, req.LogTopic, w.opts.Delivery.Log.QoS, w.opts.Delivery.Log.Retained, data, properties)
+	}
+	var batcher *logBatcher
+	if req.BatchLogs && w.opts.LogBatchInterval > 0 {
+		batcher = &logBatcher{
+			interval: w.opts.LogBatchInterval,
+			maxBytes: w.opts.LogBatchBytes,
+			publish:  func(entries []tinpot.MqttLogEntry) { publishLogs(entries) },
+		}
+	}
+
+	// Only the first response counts, the quota may fail the execution before the action returns
+	done := make(chan struct{})
+	var once sync.Once
+	w.running.Add(1)
+	responseCallback := func(error string, result map[string]interface{}) {
+		once.Do(func() {
+			defer w.running.Done()
+			close(done)
+			status := "SUCCESS"
+			if error != "" {
+				status = "FAILURE"
+			}
+			if batcher != nil {
+				batcher.flush()
+			}
+			usageMu.Lock()
+			measured := usage
+			usageMu.Unlock()
+			measured.DurationSeconds = time.Since(started).Seconds()
+			w.sendResult(req, properties, status, result, error, &measured)
+			if scratchDir != "" {
+				w.scratch.release(scratchDir, error != "")
+			}
+		})
+	}
+	if scratchDir != "" && w.scratch.quota > 0 {
+		go w.scratch.watch(scratchDir, done, func(size int64) {
+			responseCallback(fmt.Sprintf("%s: the scratch directory grew to %d bytes, over the quota of %d", tinpot.ScratchQuotaError, size, w.scratch.quota), nil)
+		})
+	}
+
+	var logMu sync.Mutex
+	var sequence uint64
+	logsCallback := func(level, message string) {
+		// Numbered and queued together, so concurrent log calls keep their order
+		logMu.Lock()
+		defer logMu.Unlock()
+		sequence++
+		entry := tinpot.MqttLogEntry{
+			Timestamp: time.Now().Format(time.RFC3339Nano),
+			Level:     level,
+			Message:   message,
+			Sequence:  sequence,
+		}
+		if batcher != nil {
+			batcher.add(entry)
+		} else {
+			publishLogs(entry)
+		}
+	}
+
+	trigger(req.Parameters, responseCallback, logsCallback)
+}
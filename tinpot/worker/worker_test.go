@@ -0,0 +1,570 @@
+package worker_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/tinpot"
+	"github.com/balazsgrill/tinpot/worker"
+)
+
+// loopback delivers published messages to exact-topic subscribers
+type loopback struct {
+	mu        sync.Mutex
+	handlers  map[string]tinpot.MessageHandler
+	published map[string][]byte
+}
+
+func (l *loopback) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	l.mu.Lock()
+	l.published[topic] = payload
+	handler := l.handlers[topic]
+	l.mu.Unlock()
+	if handler != nil {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (l *loopback) Subscribe(topic string, qos byte, handler tinpot.MessageHandler) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers[topic] = handler
+	return nil
+}
+
+func (l *loopback) Unsubscribe(topics ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, topic := range topics {
+		delete(l.handlers, topic)
+	}
+	return nil
+}
+
+func (l *loopback) OnConnect(handler func()) {}
+
+func (l *loopback) IsConnected() bool {
+	return true
+}
+
+func (l *loopback) message(topic string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.published[topic]
+}
+
+func (l *loopback) waitSubscribed(t *testing.T, topic string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		_, subscribed := l.handlers[topic]
+		l.mu.Unlock()
+		if subscribed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not subscribed", topic)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type greeter struct{}
+
+func (greeter) GetAction(name string) tinpot.ActionTrigger {
+	return func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		logs("INFO", "greeting")
+		tinpot.ReportUsage(params, tinpot.ResourceUsage{CPUSeconds: 0.5, PeakMemoryBytes: 1 << 20})
+		response("", map[string]interface{}{"greeting": "hello " + params["name"].(string)})
+	}
+}
+
+func (greeter) ListActions() map[string]tinpot.ActionInfo {
+	return map[string]tinpot.ActionInfo{"greet": {Name: "greet"}}
+}
+
+func (greeter) IsConnected() bool {
+	return true
+}
+
+func TestWorker(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.NewWorker(transport, greeter{}, worker.Options{Tenant: "team-a", ID: "w1", Environment: tinpot.ExecutionEnvironment{GitCommit: "abc123"}}).Run(ctx)
+		close(done)
+	}()
+
+	var announced tinpot.MqttAction
+	deadline := time.Now().Add(5 * time.Second)
+	for transport.message("tinpot/tenants/team-a/actions/greet") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("action not announced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	json.Unmarshal(transport.message("tinpot/tenants/team-a/actions/greet"), &announced)
+	if announced.TriggerTopic != "tinpot/tenants/team-a/actions/greet/trigger" {
+		t.Errorf("unexpected trigger topic %q", announced.TriggerTopic)
+	}
+	if !reflect.DeepEqual(announced.Capabilities, []string{tinpot.CapabilityProgress, tinpot.CapabilityCompression}) {
+		t.Errorf("unexpected capabilities %v", announced.Capabilities)
+	}
+
+	req, _ := json.Marshal(tinpot.MqttExecutionRequest{
+		ExecutionID: "1",
+		Parameters:  map[string]interface{}{"name": "tinpot"},
+		ResultTopic: "result",
+		LogTopic:    "log",
+	})
+	transport.Publish(announced.TriggerTopic, 1, false, req)
+
+	for transport.message("result") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no result")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var result tinpot.MqttResultResponse
+	json.Unmarshal(transport.message("result"), &result)
+	if result.Status != "SUCCESS" || result.Result.(map[string]interface{})["greeting"] != "hello tinpot" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if usage := result.Usage; usage == nil || usage.CPUSeconds != 0.5 || usage.PeakMemoryBytes != 1<<20 || usage.DurationSeconds <= 0 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if env := result.Environment; env == nil || env.WorkerID != "w1" || env.GitCommit != "abc123" || env.Hostname == "" {
+		t.Errorf("unexpected environment %+v", env)
+	}
+	if transport.message("log") == nil {
+		t.Errorf("log not published")
+	}
+
+	cancel()
+	<-done
+	if len(transport.handlers) != 0 {
+		t.Errorf("trigger topics still subscribed after Run returned")
+	}
+}
+
+func TestResultAck(t *testing.T) {
+	for _, ack := range []bool{true, false} {
+		transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			worker.NewWorker(transport, greeter{}, worker.Options{ResultAckTimeout: 10 * time.Millisecond, ResultRetries: 2}).Run(ctx)
+			close(done)
+		}()
+
+		var mu sync.Mutex
+		results := 0
+		transport.Subscribe("result", 1, func(topic string, payload []byte) {
+			mu.Lock()
+			results++
+			mu.Unlock()
+			if ack {
+				go transport.Publish("ack", 1, false, []byte("{}"))
+			}
+		})
+		for transport.message("tinpot/actions/greet") == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		req, _ := json.Marshal(tinpot.MqttExecutionRequest{
+			ExecutionID: "1",
+			Parameters:  map[string]interface{}{"name": "tinpot"},
+			ResultTopic: "result",
+			LogTopic:    "log",
+			AckTopic:    "ack",
+		})
+		transport.Publish("tinpot/actions/greet/trigger", 1, false, req)
+
+		// Unacknowledged results are published again after 10, 20 ms, then given up
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		expected := 3
+		if ack {
+			expected = 1
+		}
+		if results != expected {
+			t.Errorf("ack %v: expected %d result publications, got %d", ack, expected, results)
+		}
+		mu.Unlock()
+		cancel()
+		<-done
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	key, _ := tinpot.ParseSigningKey("hmac:MDEyMzQ1Njc4OWFiY2RlZg==")
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, greeter{}, worker.Options{SigningKey: key}).Run(ctx)
+
+	const topic = "tinpot/actions/greet/trigger"
+	transport.waitSubscribed(t, topic)
+	deadline := time.Now().Add(5 * time.Second)
+
+	forged, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "1", Parameters: map[string]interface{}{"name": "x"}, ResultTopic: "forged", LogTopic: "forged_log"})
+	transport.Publish(topic, 1, false, forged)
+	req := tinpot.MqttExecutionRequest{ExecutionID: "2", Parameters: map[string]interface{}{"name": "x"}, ResultTopic: "signed", LogTopic: "signed_log"}
+	key.SignRequest(topic, &req)
+	signed, _ := json.Marshal(req)
+	transport.Publish(topic, 1, false, signed)
+
+	for transport.message("signed") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no result of the signed request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if transport.message("forged") != nil || transport.message("forged_log") != nil {
+		t.Error("unsigned request was executed")
+	}
+}
+
+func TestWorkerTopicLayout(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, greeter{}, worker.Options{ID: "w1", TopicLayout: tinpot.TopicLayoutWorker}).Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for transport.message("tinpot/actions/greet") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("action not announced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var announced tinpot.MqttAction
+	json.Unmarshal(transport.message("tinpot/actions/greet"), &announced)
+	if announced.TriggerTopic != "tinpot/workers/w1/actions/greet/trigger" || announced.ExecPrefix != "tinpot/workers/w1/exec/" {
+		t.Fatalf("unexpected announcement: %+v", announced)
+	}
+
+	outside, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "1", Parameters: map[string]interface{}{"name": "x"}, ResultTopic: "tinpot/exec/1/result", LogTopic: "tinpot/exec/1/log"})
+	transport.Publish(announced.TriggerTopic, 1, false, outside)
+	inside, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "2", Parameters: map[string]interface{}{"name": "x"}, ResultTopic: "tinpot/workers/w1/exec/2/result", LogTopic: "tinpot/workers/w1/exec/2/log"})
+	transport.Publish(announced.TriggerTopic, 1, false, inside)
+
+	for transport.message("tinpot/workers/w1/exec/2/result") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no result of the request within the worker's topics")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if transport.message("tinpot/exec/1/result") != nil || transport.message("tinpot/exec/1/log") != nil {
+		t.Error("request with topics outside of the worker's was executed")
+	}
+}
+
+type chatty struct{}
+
+func (chatty) GetAction(name string) tinpot.ActionTrigger {
+	return func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		for _, line := range []string{"one", "two", "three"} {
+			logs("INFO", line)
+		}
+		response("", map[string]interface{}{})
+	}
+}
+
+func (chatty) ListActions() map[string]tinpot.ActionInfo {
+	return map[string]tinpot.ActionInfo{"chat": {Name: "chat"}}
+}
+
+func (chatty) IsConnected() bool {
+	return true
+}
+
+func TestLogBatching(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, chatty{}, worker.Options{LogBatchInterval: time.Hour}).Run(ctx)
+
+	const topic = "tinpot/actions/chat/trigger"
+	transport.waitSubscribed(t, topic)
+	deadline := time.Now().Add(5 * time.Second)
+
+	req, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "1", ResultTopic: "result", LogTopic: "log", BatchLogs: true})
+	transport.Publish(topic, 1, false, req)
+	for transport.message("result") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no result")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The batch is flushed before the result, long before the interval
+	var batch []tinpot.MqttLogEntry
+	if err := json.Unmarshal(transport.message("log"), &batch); err != nil || len(batch) != 3 || batch[2].Message != "three" {
+		t.Errorf("unexpected log batch: %s", transport.message("log"))
+	}
+}
+
+func TestSyncCommands(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	refs := make(chan string, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, greeter{}, worker.Options{ID: "w1", Sync: func(ref string) { refs <- ref }}).Run(ctx)
+
+	transport.waitSubscribed(t, tinpot.SyncTopic(""))
+	transport.waitSubscribed(t, tinpot.WorkerSyncTopic("", "w1"))
+	transport.Publish(tinpot.WorkerSyncTopic("", "w1"), 1, false, []byte(`{}`))
+	transport.Publish(tinpot.SyncTopic(""), 1, false, []byte(`{"ref": "refs/heads/main"}`))
+	received := map[string]bool{}
+	for range 2 {
+		select {
+		case ref := <-refs:
+			received[ref] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("sync not requested")
+		}
+	}
+	if !received[""] || !received["refs/heads/main"] {
+		t.Errorf("unexpected refs: %v", received)
+	}
+}
+
+func TestInstallCommands(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	installed := make(chan bool, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, greeter{}, worker.Options{
+		ID: "w1",
+		Install: func(req *tinpot.MqttInstallRequest) (*tinpot.BundleInfo, error) {
+			if string(req.Archive) != "archive" {
+				return nil, errors.New("invalid archive")
+			}
+			return &tinpot.BundleInfo{Name: "ops", Digest: tinpot.ArchiveDigest(req.Archive)}, nil
+		},
+		Installed: func() { installed <- true },
+	}).Run(ctx)
+
+	transport.waitSubscribed(t, tinpot.InstallTopic(""))
+	transport.waitSubscribed(t, tinpot.WorkerInstallTopic("", "w1"))
+	result := func(payload string) (result tinpot.MqttInstallResult) {
+		t.Helper()
+		transport.Publish(tinpot.WorkerInstallTopic("", "w1"), 1, false, []byte(payload))
+		for range 100 {
+			if json.Unmarshal(transport.message(tinpot.WorkerInstallResultTopic("", "w1")), &result); result.ID != "" {
+				transport.Publish(tinpot.WorkerInstallResultTopic("", "w1"), 1, false, nil)
+				return result
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("no install result")
+		return result
+	}
+
+	if r := result(`{"id": "1", "archive": "b3RoZXI="}`); r.Error == "" || r.WorkerID != "w1" {
+		t.Errorf("invalid bundle installed: %+v", r)
+	}
+	if r := result(`{"id": "2", "archive": "YXJjaGl2ZQ=="}`); r.Error != "" || r.Bundle == nil || r.Bundle.Name != "ops" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	select {
+	case <-installed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("installation not reported")
+	}
+	if len(installed) > 0 {
+		t.Error("failed installation reported")
+	}
+}
+
+// scribe writes a file to the scratch directory of its executions
+type scribe struct {
+	release chan struct{}
+}
+
+func (s scribe) GetAction(name string) tinpot.ActionTrigger {
+	return func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		dir, _ := params[tinpot.ScratchDirParameter].(string)
+		size, _ := params["size"].(float64)
+		if err := os.WriteFile(filepath.Join(dir, "out.txt"), make([]byte, int(size)), 0600); err != nil {
+			response(err.Error(), nil)
+			return
+		}
+		if size > 100 {
+			<-s.release
+		}
+		if params["fail"] == true {
+			response("failed", nil)
+			return
+		}
+		response("", nil)
+	}
+}
+
+func (scribe) ListActions() map[string]tinpot.ActionInfo {
+	return map[string]tinpot.ActionInfo{"write": {Name: "write"}}
+}
+
+func (scribe) IsConnected() bool {
+	return true
+}
+
+func TestScratchDirectories(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	dir := t.TempDir()
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewWorker(transport, scribe{release}, worker.Options{
+		ID:               "w1",
+		ScratchDir:       dir,
+		ScratchRetention: time.Hour,
+		ScratchQuota:     100,
+	}).Run(ctx)
+	transport.waitSubscribed(t, tinpot.ScratchTopic(""))
+
+	execute := func(id string, params map[string]interface{}) (result tinpot.MqttResultResponse) {
+		t.Helper()
+		req, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: id, Parameters: params, ResultTopic: "result/" + id})
+		transport.Publish("tinpot/actions/write/trigger", 1, false, req)
+		for range 100 {
+			if payload := transport.message("result/" + id); payload != nil {
+				json.Unmarshal(payload, &result)
+				return result
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("no result of %s", id)
+		return result
+	}
+
+	if r := execute("ok", map[string]interface{}{"size": 10}); r.Status != "SUCCESS" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ok")); err == nil {
+		t.Error("scratch directory of a successful execution kept")
+	}
+	if r := execute("failed", map[string]interface{}{"size": 10, "fail": true}); r.Status != "FAILURE" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r := execute("big", map[string]interface{}{"size": 1000}); !strings.HasPrefix(r.Error, tinpot.ScratchQuotaError) {
+		t.Errorf("quota not enforced: %+v", r)
+	}
+
+	// The worker keeping the directory answers with its archive
+	transport.Subscribe("tinpot/scratch/r1", 1, func(topic string, payload []byte) {})
+	req, _ := json.Marshal(tinpot.MqttScratchRequest{ExecutionID: "failed", ReplyTopic: "tinpot/scratch/r1"})
+	transport.Publish(tinpot.ScratchTopic(""), 1, false, req)
+	var reply tinpot.MqttScratchArchive
+	for range 100 {
+		if json.Unmarshal(transport.message("tinpot/scratch/r1"), &reply); reply.WorkerID != "" {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if reply.WorkerID != "w1" || reply.Error != "" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(reply.Archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := tar.NewReader(gz).Next()
+	if err != nil || header.Name != "out.txt" || header.Size != 10 {
+		t.Errorf("unexpected archive entry: %+v %v", header, err)
+	}
+}
+
+// canceller blocks its executions until the worker cancels them, like an async action
+type canceller struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (c canceller) GetAction(name string) tinpot.ActionTrigger {
+	return func(params map[string]interface{}, response tinpot.ActionResponse, logs tinpot.ActionLogs) {
+		close(c.started)
+		go func() {
+			<-c.cancelled
+			time.Sleep(50 * time.Millisecond)
+			response("CancelledError", nil)
+		}()
+	}
+}
+
+func (canceller) ListActions() map[string]tinpot.ActionInfo {
+	return map[string]tinpot.ActionInfo{"sleep": {Name: "sleep"}}
+}
+
+func (canceller) IsConnected() bool {
+	return true
+}
+
+func TestShutdownWaitsForCancelledExecutions(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	mgr := canceller{started: make(chan struct{}), cancelled: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.NewWorker(transport, mgr, worker.Options{ID: "w1", Cancel: func() { close(mgr.cancelled) }}).Run(ctx)
+		close(done)
+	}()
+
+	transport.waitSubscribed(t, "tinpot/actions/sleep/trigger")
+	req, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "1", ResultTopic: "result"})
+	transport.Publish("tinpot/actions/sleep/trigger", 1, false, req)
+	<-mgr.started
+
+	cancel()
+	<-done
+	var result tinpot.MqttResultResponse
+	if err := json.Unmarshal(transport.message("result"), &result); err != nil {
+		t.Fatalf("no result before Run returned: %v", err)
+	}
+	if result.Status != "FAILURE" || result.Error != "CancelledError" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	var status tinpot.MqttWorkerStatus
+	json.Unmarshal(transport.message(tinpot.WorkerStatusTopic("", "w1")), &status)
+	if status.Online {
+		t.Errorf("worker still online after Run returned")
+	}
+}
+
+func TestShutdownGrace(t *testing.T) {
+	transport := &loopback{handlers: make(map[string]tinpot.MessageHandler), published: make(map[string][]byte)}
+	// Never cancelled, the worker gives up after the grace period
+	mgr := canceller{started: make(chan struct{}), cancelled: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.NewWorker(transport, mgr, worker.Options{ShutdownGrace: 50 * time.Millisecond}).Run(ctx)
+		close(done)
+	}()
+
+	transport.waitSubscribed(t, "tinpot/actions/sleep/trigger")
+	req, _ := json.Marshal(tinpot.MqttExecutionRequest{ExecutionID: "1", ResultTopic: "result"})
+	transport.Publish("tinpot/actions/sleep/trigger", 1, false, req)
+	<-mgr.started
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the shutdown grace")
+	}
+}